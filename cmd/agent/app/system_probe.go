@@ -0,0 +1,162 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build process
+
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/cmd/agent/common"
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/net"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	AgentCmd.AddCommand(systemProbeCmd)
+	systemProbeCmd.AddCommand(systemProbeConntrackCmd)
+	systemProbeConntrackCmd.AddCommand(systemProbeConntrackDumpCmd)
+}
+
+var systemProbeCmd = &cobra.Command{
+	Use:   "system-probe",
+	Short: "Inspect the state of a running system-probe",
+	Long:  ``,
+}
+
+var systemProbeConntrackCmd = &cobra.Command{
+	Use:   "conntrack",
+	Short: "Inspect the conntrack cache of a running system-probe",
+	Long:  ``,
+}
+
+var systemProbeConntrackDumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Print the conntracker description, the cached NAT translations, conntrack stats and kernel conntrack counters",
+	Long:  ``,
+	RunE:  dumpConntrack,
+}
+
+func dumpConntrack(cmd *cobra.Command, args []string) error {
+	if flagNoColor {
+		color.NoColor = true
+	}
+
+	err := common.SetupConfigWithoutSecrets(confFilePath, "")
+	if err != nil {
+		return fmt.Errorf("unable to set up global agent configuration: %v", err)
+	}
+
+	err = config.SetupLogger(loggerName, config.GetEnv("DD_LOG_LEVEL", "off"), "", "", false, true, false)
+	if err != nil {
+		fmt.Printf("Cannot setup logger, exiting: %v\n", err)
+		return err
+	}
+
+	net.SetSystemProbePath(config.Datadog.GetString("system_probe_config.sysprobe_socket"))
+	probeUtil, err := net.GetRemoteSystemProbeUtil()
+	if err != nil {
+		return fmt.Errorf("could not reach system-probe (running?): %v", err)
+	}
+
+	table, err := probeUtil.GetConntrackTable()
+	if err != nil {
+		return fmt.Errorf("could not retrieve conntrack table from system-probe: %v", err)
+	}
+
+	printConntrackDescription(table.Description)
+	printConntrackCounters("Conntrack stats", table.Stats)
+	printConntrackCounters("Kernel conntrack counters", table.Kernel)
+	printConntrackEntries(table.Entries)
+	printConntrackErrors(table.Errors)
+
+	return nil
+}
+
+func printConntrackDescription(d network.ConntrackDescription) {
+	fmt.Fprintln(color.Output, fmt.Sprintf("\n=== %s ===", color.GreenString("Conntracker")))
+	fmt.Fprintln(color.Output, fmt.Sprintf("%s: %s", color.BlueString("backend"), d.Backend))
+	fmt.Fprintln(color.Output, fmt.Sprintf("%s: %s", color.BlueString("status"), d.Status))
+	if len(d.Layers) > 0 {
+		fmt.Fprintln(color.Output, fmt.Sprintf("%s: %s", color.BlueString("layers"), strings.Join(d.Layers, ", ")))
+	}
+	if d.MaxStateSize > 0 {
+		fmt.Fprintln(color.Output, fmt.Sprintf("%s: %d", color.BlueString("max_state_size"), d.MaxStateSize))
+	}
+	if d.Storage != "" {
+		fmt.Fprintln(color.Output, fmt.Sprintf("%s: %s", color.BlueString("storage"), d.Storage))
+	}
+	if d.TargetRateLimit > 0 {
+		fmt.Fprintln(color.Output, fmt.Sprintf("%s: %d", color.BlueString("target_rate_limit"), d.TargetRateLimit))
+	}
+	if d.ListenAllNamespaces {
+		fmt.Fprintln(color.Output, fmt.Sprintf("%s: %t", color.BlueString("listen_all_namespaces"), d.ListenAllNamespaces))
+	}
+	if len(d.NetlinkGroups) > 0 {
+		fmt.Fprintln(color.Output, fmt.Sprintf("%s: %s", color.BlueString("netlink_groups"), strings.Join(d.NetlinkGroups, ", ")))
+	}
+}
+
+func printConntrackCounters(title string, counters map[string]int64) {
+	fmt.Fprintln(color.Output, fmt.Sprintf("\n=== %s ===", color.GreenString(title)))
+
+	names := make([]string, 0, len(counters))
+	for name := range counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintln(color.Output, fmt.Sprintf("%s: %d", color.BlueString(name), counters[name]))
+	}
+}
+
+func printConntrackEntries(entries []network.DebugConntrackEntry) {
+	fmt.Fprintln(color.Output, fmt.Sprintf("\n=== %s (%d) ===", color.GreenString("Cached translations"), len(entries)))
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Origin.Src != entries[j].Origin.Src {
+			return entries[i].Origin.Src < entries[j].Origin.Src
+		}
+		return entries[i].Origin.SPort < entries[j].Origin.SPort
+	})
+
+	for _, e := range entries {
+		var cloudNAT, local string
+		if e.CloudNAT {
+			cloudNAT = color.YellowString(" (cloud NAT)")
+		}
+		if e.Local {
+			local = color.YellowString(" (local, not cached)")
+		}
+		fmt.Fprintln(color.Output, fmt.Sprintf(
+			"[%s] %s:%d -> %s:%d => %s%s%s",
+			e.Proto,
+			e.Origin.Src, e.Origin.SPort,
+			e.Origin.Dst, e.Origin.DPort,
+			color.CyanString("%s:%d -> %s:%d", e.Reply.Src, e.Reply.SPort, e.Reply.Dst, e.Reply.DPort),
+			cloudNAT,
+			local,
+		))
+	}
+}
+
+func printConntrackErrors(errors []string) {
+	if len(errors) == 0 {
+		return
+	}
+
+	fmt.Fprintln(color.Output, fmt.Sprintf("\n=== %s (%d) ===", color.GreenString("Recent errors"), len(errors)))
+	for _, err := range errors {
+		fmt.Fprintln(color.Output, color.RedString("%s", err))
+	}
+}
@@ -16,6 +16,7 @@ func installKubernetesMetadataEndpoints(r *mux.Router) {
 	r.HandleFunc("/tags/pod/{nodeName}", getPodMetadataForNode).Methods("GET")
 	r.HandleFunc("/tags/pod", getAllMetadata).Methods("GET")
 	r.HandleFunc("/tags/node/{nodeName}", getNodeMetadata).Methods("GET")
+	r.HandleFunc("/services/addresses", getServiceAddresses).Methods("GET")
 }
 
 // getNodeMetadata is only used when the node agent hits the DCA for the list of labels
@@ -237,3 +238,45 @@ func getAllMetadata(w http.ResponseWriter, r *http.Request) {
 	)
 	return
 }
+
+// getServiceAddresses is used by the system-probe to resolve the services of the translated connections.
+func getServiceAddresses(w http.ResponseWriter, r *http.Request) {
+	/*
+		Input
+			localhost:5001/api/v1/services/addresses
+		Outputs
+			Status: 200
+			Returns: []apiv1.ServiceAddress
+			Example: [{"ip":"10.96.0.10","port":53,"protocol":"UDP","namespace":"kube-system","name":"kube-dns"}]
+
+			Status: 500
+			Returns: string
+			Example: "the services informer is not synced"
+	*/
+	addrs, err := as.GetServiceAddresses()
+	if err != nil {
+		log.Errorf("Could not retrieve the service addresses: %v", err.Error()) //nolint:errcheck
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apiRequests.Inc(
+			"getServiceAddresses",
+			strconv.Itoa(http.StatusInternalServerError),
+		)
+		return
+	}
+	addrsBytes, err := json.Marshal(addrs)
+	if err != nil {
+		log.Errorf("Could not process the service addresses: %v", err.Error()) //nolint:errcheck
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apiRequests.Inc(
+			"getServiceAddresses",
+			strconv.Itoa(http.StatusInternalServerError),
+		)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(addrsBytes)
+	apiRequests.Inc(
+		"getServiceAddresses",
+		strconv.Itoa(http.StatusOK),
+	)
+}
@@ -23,3 +23,8 @@ type Module interface {
 	Register(*http.ServeMux) error
 	Close()
 }
+
+// Reloadable is implemented by the Modules able to apply a new configuration without being restarted
+type Reloadable interface {
+	Reload(cfg *config.AgentConfig) error
+}
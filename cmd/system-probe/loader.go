@@ -66,6 +66,23 @@ func (l *Loader) GetStats() map[string]interface{} {
 	return stats
 }
 
+// Reload applies a new configuration to the modules supporting it. The other modules keep their configuration
+// until system-probe is restarted.
+func (l *Loader) Reload(cfg *config.AgentConfig) {
+	for name, module := range l.modules {
+		reloadable, ok := module.(api.Reloadable)
+		if !ok {
+			continue
+		}
+
+		if err := reloadable.Reload(cfg); err != nil {
+			log.Errorf("error reloading module `%s`: %s", name, err)
+			continue
+		}
+		log.Infof("module: %s reloaded", name)
+	}
+}
+
 // Close each registered module
 func (l *Loader) Close() {
 	l.once.Do(func() {
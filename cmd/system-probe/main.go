@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	_ "net/http/pprof"
@@ -20,6 +22,7 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/process/config"
 	"github.com/DataDog/datadog-agent/pkg/process/net"
 	"github.com/DataDog/datadog-agent/pkg/process/statsd"
+	"github.com/DataDog/datadog-agent/pkg/status/health"
 	"github.com/DataDog/datadog-agent/pkg/util/log"
 )
 
@@ -135,6 +138,20 @@ func runAgent(exit <-chan struct{}) {
 		utils.WriteAsJSON(w, stats)
 	})
 
+	// Register health endpoint, reporting the readiness of the components registered with the health package
+	httpMux.HandleFunc("/debug/health", func(w http.ResponseWriter, req *http.Request) {
+		status, err := health.GetReadyNonBlocking()
+		if err != nil {
+			log.Errorf("unable to retrieve system-probe health: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		if len(status.Unhealthy) > 0 {
+			w.WriteHeader(500)
+		}
+		utils.WriteAsJSON(w, status)
+	})
+
 	go func() {
 		err = http.Serve(conn.GetListener(), httpMux)
 		if err != nil {
@@ -144,10 +161,27 @@ func runAgent(exit <-chan struct{}) {
 		}
 	}()
 
+	go handleReloads(loader)
+
 	log.Infof("system probe successfully started")
 	<-exit
 }
 
+// handleReloads reloads the configuration of the modules whenever a SIGHUP is received
+func handleReloads(loader *Loader) {
+	sigHup := make(chan os.Signal, 1)
+	signal.Notify(sigHup, syscall.SIGHUP)
+	for range sigHup {
+		log.Infof("Caught signal '%s'; reloading configuration.", syscall.SIGHUP)
+		cfg, err := config.NewSystemProbeConfig(loggerName, opts.configPath)
+		if err != nil {
+			log.Errorf("failed to reload config: %s", err)
+			continue
+		}
+		loader.Reload(cfg)
+	}
+}
+
 func gracefulExit() {
 	// A sleep is necessary to ensure that supervisor registers this process as "STARTED"
 	// If the exit is "too quick", we enter a BACKOFF->FATAL loop even though this is an expected exit
@@ -6,6 +6,9 @@ import (
 	"flag"
 
 	"github.com/DataDog/datadog-agent/pkg/process/util"
+
+	// register the cgroup container implementation, which resolves the containers of the network namespaces
+	_ "github.com/DataDog/datadog-agent/pkg/util/containers/providers/cgroup"
 )
 
 func main() {
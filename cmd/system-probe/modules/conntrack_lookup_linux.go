@@ -0,0 +1,74 @@
+// +build linux
+
+package modules
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+	netapi "github.com/DataDog/datadog-agent/pkg/network/api"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// conntrackLookupServer serves the conntrack lookups of the local clients, such as the core agent or the security
+// agent, from the conntracker of the network tracer
+type conntrackLookupServer struct {
+	tracer *ebpf.Tracer
+}
+
+var _ netapi.ConntrackLookupServer = &conntrackLookupServer{}
+
+// Lookup returns the translations of the requested connections, in the same order
+func (s *conntrackLookupServer) Lookup(ctx context.Context, req *netapi.ConntrackLookupRequest) (*netapi.ConntrackLookupResponse, error) {
+	conns, err := req.LookupConns()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	translations, err := s.tracer.LookupConntrack(conns)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return netapi.NewConntrackLookupResponse(translations), nil
+}
+
+// serveConntrackLookups starts the gRPC server of the conntrack lookup service on the given unix socket
+func serveConntrackLookups(tracer *ebpf.Tracer, socketPath string) (*grpc.Server, error) {
+	// remove the socket left by a previous run which didn't clean it up
+	if fileInfo, err := os.Stat(socketPath); err == nil {
+		if fileInfo.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("cannot reuse %s socket path: path already exists and it is not a UNIX socket", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, fmt.Errorf("cannot remove stale UNIX socket: %s", err)
+		}
+	}
+
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't listen: %s", err)
+	}
+	// the socket is write only for the other users, like the one of the system-probe API, so the agents can connect to it
+	if err := os.Chmod(socketPath, 0722); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("can't set the socket at write only: %s", err)
+	}
+
+	server := grpc.NewServer()
+	netapi.RegisterConntrackLookupServer(server, &conntrackLookupServer{tracer: tracer})
+	go func() {
+		if err := server.Serve(ln); err != nil {
+			log.Errorf("conntrack lookup server stopped: %s", err)
+		}
+	}()
+
+	log.Infof("serving the conntrack lookups on %s", socketPath)
+	return server, nil
+}
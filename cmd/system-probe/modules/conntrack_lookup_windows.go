@@ -0,0 +1,16 @@
+// +build windows
+
+package modules
+
+import (
+	"errors"
+
+	"google.golang.org/grpc"
+
+	"github.com/DataDog/datadog-agent/pkg/ebpf"
+)
+
+// serveConntrackLookups is not supported, the conntrack lookups are only served on unix sockets
+func serveConntrackLookups(tracer *ebpf.Tracer, socketPath string) (*grpc.Server, error) {
+	return nil, errors.New("the conntrack lookups are only served on linux")
+}
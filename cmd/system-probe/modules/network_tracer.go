@@ -15,6 +15,7 @@ import (
 	"github.com/DataDog/datadog-agent/cmd/system-probe/utils"
 	"github.com/DataDog/datadog-agent/pkg/network"
 	"github.com/DataDog/datadog-agent/pkg/util/log"
+	"google.golang.org/grpc"
 
 	"github.com/DataDog/datadog-agent/pkg/ebpf"
 	"github.com/DataDog/datadog-agent/pkg/network/encoding"
@@ -43,14 +44,19 @@ var NetworkTracer = api.Factory{
 		log.Infof("Creating tracer for: %s", filepath.Base(os.Args[0]))
 
 		t, err := ebpf.NewTracer(config.SysProbeConfigFromConfig(cfg))
-		return &networkTracer{tracer: t}, err
+		return &networkTracer{tracer: t, lookupSocket: cfg.ConntrackLookupSocket}, err
 	},
 }
 
 var _ api.Module = &networkTracer{}
+var _ api.Reloadable = &networkTracer{}
 
 type networkTracer struct {
 	tracer *ebpf.Tracer
+
+	// lookupSocket is the unix socket the conntrack lookups are served on by lookupServer, none when it is empty
+	lookupSocket string
+	lookupServer *grpc.Server
 }
 
 func (nt *networkTracer) GetStats() map[string]interface{} {
@@ -105,6 +111,41 @@ func (nt *networkTracer) Register(httpMux *http.ServeMux) error {
 		utils.WriteAsJSON(w, stats)
 	})
 
+	httpMux.HandleFunc("/debug/conntrack", func(w http.ResponseWriter, req *http.Request) {
+		table, err := nt.tracer.DebugConntrackTable()
+		if err != nil {
+			log.Errorf("unable to retrieve conntrack table: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+
+		utils.WriteAsJSON(w, table)
+	})
+
+	httpMux.HandleFunc("/debug/conntrack/refresh", func(w http.ResponseWriter, req *http.Request) {
+		if err := nt.tracer.RefreshConntrack(); err != nil {
+			log.Errorf("unable to refresh conntrack table: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+
+		table, err := nt.tracer.DebugConntrackTable()
+		if err != nil {
+			log.Errorf("unable to retrieve conntrack table: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		utils.WriteAsJSON(w, table.Stats)
+	})
+
+	if nt.lookupSocket != "" {
+		server, err := serveConntrackLookups(nt.tracer, nt.lookupSocket)
+		if err != nil {
+			log.Errorf("unable to serve the conntrack lookups on %s: %s", nt.lookupSocket, err)
+		}
+		nt.lookupServer = server
+	}
+
 	// Convenience logging if nothing has made any requests to the system-probe in some time, let's log something.
 	// This should be helpful for customers + support to debug the underlying issue.
 	time.AfterFunc(inactivityLogDuration, func() {
@@ -116,8 +157,21 @@ func (nt *networkTracer) Register(httpMux *http.ServeMux) error {
 	return nil
 }
 
+// Reload applies the settings which can be changed without restarting the tracer. Enabling conntrack re-initializes
+// the conntracker, which can take a while, since it loads the conntrack table.
+func (nt *networkTracer) Reload(cfg *config.AgentConfig) error {
+	if err := nt.tracer.SetConntrackEnabled(cfg.EnableConntrack); err != nil {
+		return fmt.Errorf("could not enable conntrack: %s", err)
+	}
+	nt.tracer.SetConntrackRateLimit(cfg.ConntrackRateLimit)
+	return nil
+}
+
 // Close will stop all system probe activities
 func (nt *networkTracer) Close() {
+	if nt.lookupServer != nil {
+		nt.lookupServer.Stop()
+	}
 	nt.tracer.Stop()
 }
 
@@ -134,3 +134,13 @@ func NewMetadataResponse() *MetadataResponse {
 		Nodes: make(map[string]*MetadataResponseBundle),
 	}
 }
+
+// ServiceAddress is an address a Kubernetes service is exposed at: one of its cluster or external IPs, and one of its
+// ports. It is used to encode the /api/v1/services/addresses payloads.
+type ServiceAddress struct {
+	IP        string `json:"ip"`
+	Port      int32  `json:"port"`
+	Protocol  string `json:"protocol"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
@@ -709,7 +709,65 @@ func InitConfig(config Config) {
 	config.SetKnown("system_probe_config.enable_conntrack")
 	config.SetKnown("system_probe_config.sysprobe_socket")
 	config.SetKnown("system_probe_config.conntrack_rate_limit")
+	config.SetKnown("system_probe_config.conntrack_namespace_rate_limit")
+	config.SetKnown("system_probe_config.conntrack_max_entries_per_source")
+	config.SetKnown("system_probe_config.conntrack_quota_per_namespace")
+	config.SetKnown("system_probe_config.conntrack_arena_min_state_size")
+	config.SetKnown("system_probe_config.conntrack_compaction_strategy")
+	config.SetKnown("system_probe_config.conntrack_compact_interval_in_s")
+	config.SetKnown("system_probe_config.flare_conntrack")
 	config.SetKnown("system_probe_config.enable_conntrack_all_namespaces")
+	config.SetKnown("system_probe_config.enable_conntrack_modprobe")
+	config.SetKnown("system_probe_config.enable_conntrack_ipvs")
+	config.SetKnown("system_probe_config.enable_conntrack_cilium")
+	config.SetKnown("system_probe_config.conntrack_docker_port_bindings")
+	config.SetKnown("system_probe_config.conntrack_container_attribution")
+	config.SetKnown("system_probe_config.conntrack_service_resolution")
+	config.SetKnown("system_probe_config.conntrack_pid_attribution")
+	config.SetKnown("system_probe_config.conntrack_nat_rule_filter")
+	config.SetKnown("system_probe_config.conntrack_include_cidrs")
+	config.SetKnown("system_probe_config.conntrack_exclude_cidrs")
+	config.SetKnown("system_probe_config.conntrack_include_ports")
+	config.SetKnown("system_probe_config.conntrack_exclude_ports")
+	config.SetKnown("system_probe_config.conntrack_zones")
+	config.SetKnown("system_probe_config.conntrack_cloud_nat_cidrs")
+	config.SetKnown("system_probe_config.conntrack_trace_tuples")
+	config.SetKnown("system_probe_config.conntrack_skip_unreplied")
+	config.SetKnown("system_probe_config.conntrack_skip_unassured")
+	config.SetKnown("system_probe_config.conntrack_skip_local_replies")
+	config.SetKnown("system_probe_config.conntrack_tcp_state")
+	config.SetKnown("system_probe_config.conntrack_counters")
+	config.SetKnown("system_probe_config.conntrack_start_times")
+	config.SetKnown("system_probe_config.conntrack_status")
+	config.SetKnown("system_probe_config.conntrack_expectations")
+	config.SetKnown("system_probe_config.conntrack_disable_ipv6")
+	config.SetKnown("system_probe_config.conntrack_lazy_init")
+	config.SetKnown("system_probe_config.conntrack_init_timeout_in_s")
+	config.SetKnown("system_probe_config.conntrack_dump_timeout_in_s")
+	config.SetKnown("system_probe_config.conntrack_init_retry_backoff_in_s")
+	config.SetKnown("system_probe_config.conntrack_init_retry_max_backoff_in_s")
+	config.SetKnown("system_probe_config.conntrack_init_max_retries")
+	config.SetKnown("system_probe_config.conntrack_dump_mark_mask")
+	config.SetKnown("system_probe_config.conntrack_rcvbuf_size")
+	config.SetKnown("system_probe_config.conntrack_mmap_ring")
+	config.SetKnown("system_probe_config.conntrack_resync_interval_in_s")
+	config.SetKnown("system_probe_config.conntrack_tcp_ttl_in_s")
+	config.SetKnown("system_probe_config.conntrack_udp_ttl_in_s")
+	config.SetKnown("system_probe_config.conntrack_register_queue_size")
+	config.SetKnown("system_probe_config.conntrack_miss_query_rate")
+	config.SetKnown("system_probe_config.conntrack_negative_lookup_ttl_in_s")
+	config.SetKnown("system_probe_config.conntrackd_sync_address")
+	config.SetKnown("system_probe_config.conntrackd_sync_interface")
+	config.SetKnown("system_probe_config.conntrack_snapshot_path")
+	config.SetKnown("system_probe_config.conntrack_record_path")
+	config.SetKnown("system_probe_config.conntrack_replay_path")
+	config.SetKnown("system_probe_config.conntrack_netlink_groups")
+	config.SetKnown("system_probe_config.conntrack_udp_close_hints")
+	config.SetKnown("system_probe_config.conntrack_nat_events_enabled")
+	config.SetKnown("system_probe_config.conntrack_lookup_socket")
+	config.SetKnown("system_probe_config.conntrack_adaptive_sampling")
+	config.SetKnown("system_probe_config.conntrack_adaptive_sampling_cpu_pct")
+	config.SetKnown("system_probe_config.conntrack_cpu_breaker_pct")
 	config.SetKnown("system_probe_config.max_conns_per_message")
 	config.SetKnown("system_probe_config.max_tracked_connections")
 	config.SetKnown("system_probe_config.max_closed_connections_buffered")
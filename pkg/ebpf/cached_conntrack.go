@@ -10,7 +10,6 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/network/netlink"
 	"github.com/DataDog/datadog-agent/pkg/process/util"
 	"github.com/DataDog/datadog-agent/pkg/util/log"
-	ct "github.com/florianl/go-conntrack"
 	"github.com/golang/groupcache/lru"
 	"golang.org/x/sys/unix"
 )
@@ -72,17 +71,7 @@ func (cache *cachedConntrack) Exists(c *ConnTuple) (bool, error) {
 	srcPort, dstPort := c.SourcePort(), c.DestPort()
 
 	conn := netlink.Con{
-		Con: ct.Con{
-			Origin: &ct.IPTuple{
-				Src: &srcAddr,
-				Dst: &dstAddr,
-				Proto: &ct.ProtoTuple{
-					Number:  &protoNumber,
-					SrcPort: &srcPort,
-					DstPort: &dstPort,
-				},
-			},
-		},
+		Origin: netlink.NewIPTuple(srcAddr, dstAddr, srcPort, dstPort, protoNumber),
 	}
 
 	ok, err := ctrk.Exists(&conn)
@@ -97,7 +86,7 @@ func (cache *cachedConntrack) Exists(c *ConnTuple) (bool, error) {
 	}
 
 	conn.Reply = conn.Origin
-	conn.Origin = nil
+	conn.Origin = netlink.IPTuple{}
 	ok, err = ctrk.Exists(&conn)
 	if err != nil {
 		log.Debugf("error while checking conntrack for connection %#v: %s", conn, err)
@@ -83,11 +83,11 @@ func TestCachedConntrackExists(t *testing.T) {
 	ct := newConnTuple(os.Getpid(), 1234, saddr, daddr, sport, dport, network.TCP)
 
 	m.EXPECT().Exists(gomock.Not(gomock.Nil())).Times(1).DoAndReturn(func(c *netlink.Con) (bool, error) {
-		require.Equal(t, saddr.String(), c.Origin.Src.String())
-		require.Equal(t, daddr.String(), c.Origin.Dst.String())
-		require.Equal(t, sport, *c.Origin.Proto.SrcPort)
-		require.Equal(t, dport, *c.Origin.Proto.DstPort)
-		require.Equal(t, uint8(unix.IPPROTO_TCP), *c.Origin.Proto.Number)
+		require.Equal(t, saddr.String(), c.Origin.Src().String())
+		require.Equal(t, daddr.String(), c.Origin.Dst().String())
+		require.Equal(t, sport, c.Origin.SrcPort())
+		require.Equal(t, dport, c.Origin.DstPort())
+		require.Equal(t, uint8(unix.IPPROTO_TCP), c.Origin.Proto())
 		return true, nil
 	})
 
@@ -101,22 +101,22 @@ func TestCachedConntrackExists(t *testing.T) {
 		i++
 
 		if i == 1 {
-			require.Nil(t, c.Reply)
-			require.Equal(t, saddr.String(), c.Origin.Src.String())
-			require.Equal(t, daddr.String(), c.Origin.Dst.String())
-			require.Equal(t, sport, *c.Origin.Proto.SrcPort)
-			require.Equal(t, dport, *c.Origin.Proto.DstPort)
-			require.Equal(t, uint8(unix.IPPROTO_TCP), *c.Origin.Proto.Number)
+			require.True(t, c.Reply.IsZero())
+			require.Equal(t, saddr.String(), c.Origin.Src().String())
+			require.Equal(t, daddr.String(), c.Origin.Dst().String())
+			require.Equal(t, sport, c.Origin.SrcPort())
+			require.Equal(t, dport, c.Origin.DstPort())
+			require.Equal(t, uint8(unix.IPPROTO_TCP), c.Origin.Proto())
 			return false, nil
 		}
 
 		if i == 2 {
-			require.Nil(t, c.Origin)
-			require.Equal(t, saddr.String(), c.Reply.Src.String())
-			require.Equal(t, daddr.String(), c.Reply.Dst.String())
-			require.Equal(t, sport, *c.Reply.Proto.SrcPort)
-			require.Equal(t, dport, *c.Reply.Proto.DstPort)
-			require.Equal(t, uint8(unix.IPPROTO_TCP), *c.Reply.Proto.Number)
+			require.True(t, c.Origin.IsZero())
+			require.Equal(t, saddr.String(), c.Reply.Src().String())
+			require.Equal(t, daddr.String(), c.Reply.Dst().String())
+			require.Equal(t, sport, c.Reply.SrcPort())
+			require.Equal(t, dport, c.Reply.DstPort())
+			require.Equal(t, uint8(unix.IPPROTO_TCP), c.Reply.Proto())
 			return true, nil
 		}
 
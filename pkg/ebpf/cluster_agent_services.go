@@ -0,0 +1,63 @@
+// +build linux_bpf
+
+package ebpf
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/network/netlink"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/DataDog/datadog-agent/pkg/util/clusteragent"
+)
+
+// clusterAgentServicesPollInterval is the interval at which the service addresses are listed from the cluster agent
+const clusterAgentServicesPollInterval = time.Minute
+
+// clusterAgentServices lists the addresses the Kubernetes services are exposed at from the cluster agent, which
+// watches them with its services informer
+type clusterAgentServices struct{}
+
+// newClusterAgentServiceConntracker returns a Conntracker setting the Kubernetes service the pre-NAT destination of
+// the translations of the given Conntracker belongs to, from the service addresses of the cluster agent
+func newClusterAgentServiceConntracker(ctr netlink.Conntracker) netlink.Conntracker {
+	return netlink.NewPolledServiceConntracker(ctr, &clusterAgentServices{}, clusterAgentServicesPollInterval)
+}
+
+func (s *clusterAgentServices) Name() string {
+	return "the cluster agent"
+}
+
+func (s *clusterAgentServices) ServiceAddresses() ([]netlink.ServiceAddress, error) {
+	cl, err := clusteragent.GetClusterAgentClient()
+	if err != nil {
+		return nil, fmt.Errorf("could not reach the cluster agent: %w", err)
+	}
+	addrs, err := cl.GetServiceAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]netlink.ServiceAddress, 0, len(addrs))
+	for _, a := range addrs {
+		transport := network.TCP
+		if a.Protocol == "UDP" {
+			transport = network.UDP
+		} else if a.Protocol != "TCP" {
+			continue
+		}
+		ip := net.ParseIP(a.IP)
+		if ip == nil || a.Port <= 0 || a.Port > 0xffff {
+			continue
+		}
+		services = append(services, netlink.ServiceAddress{
+			IP:        util.AddressFromNetIP(ip),
+			Port:      uint16(a.Port),
+			Transport: transport,
+			Service:   a.Namespace + "/" + a.Name,
+		})
+	}
+	return services, nil
+}
@@ -77,14 +77,233 @@ type Config struct {
 	// ConntrackMaxStateSize specifies the maximum number of connections with NAT we can track
 	ConntrackMaxStateSize int
 
+	// ConntrackAutoMaxStateSize derives the maximum number of connections with NAT we can track from the
+	// size of the kernel conntrack table. ConntrackMaxStateSize is used when the kernel table size can't be read.
+	ConntrackAutoMaxStateSize bool
+
+	// ConntrackArenaMinStateSize allocates the slots storing the connections with NAT upfront, when the maximum
+	// number of connections tracked is at least this value. Setting it to 0 disables it.
+	ConntrackArenaMinStateSize int
+
+	// ConntrackCompactionStrategy is how the storage of the connections with NAT is shrunk once most of them were
+	// deleted: "incremental", "copy" or "off". It is "incremental" when empty.
+	ConntrackCompactionStrategy string
+
+	// ConntrackCompactInterval is the interval at which the storage of the connections with NAT is checked for
+	// being shrunk. It is checked after each deletion when it is 0.
+	ConntrackCompactInterval time.Duration
+
+	// ConntrackMaxEntriesPerSource caps the number of connections with NAT tracked for each source address,
+	// so a single workload can't fill the whole conntrack cache. A value of 0 disables the cap.
+	ConntrackMaxEntriesPerSource int
+
+	// ConntrackQuotaPerNamespace caps the connections tracked for each network namespace rather than each
+	// source address, when ConntrackMaxEntriesPerSource is set
+	ConntrackQuotaPerNamespace bool
+
 	// ConntrackRateLimit specifies the maximum number of netlink messages *per second* that can be processed
 	// Setting it to -1 disables the limit and can result in a high CPU usage.
 	ConntrackRateLimit int
 
+	// ConntrackNamespaceRateLimit is the maximum number of netlink messages *per second* processed from each network
+	// namespace when EnableConntrackAllNamespaces is set, so a noisy namespace doesn't get the events of all the
+	// namespaces sampled. A value of 0 disables the limit.
+	ConntrackNamespaceRateLimit int
+
 	// EnableConntrackAllNamespaces enables network address translation via netlink for all namespaces that are peers of the root namespace.
 	// default is true
 	EnableConntrackAllNamespaces bool
 
+	// EnableConntrackModprobe enables loading the nf_conntrack and nf_conntrack_netlink kernel modules when they are missing
+	EnableConntrackModprobe bool
+
+	// EnableConntrackIPVS enables the resolution of the translations done by IPVS (eg. kube-proxy in IPVS mode),
+	// by reading the IPVS connection table
+	EnableConntrackIPVS bool
+
+	// EnableConntrackCilium enables the resolution of the translations done by Cilium in eBPF (eg. when it replaces
+	// kube-proxy), by reading the NAT maps Cilium pins
+	EnableConntrackCilium bool
+
+	// ConntrackdSyncAddress enables the resolution of the translations replicated by conntrackd between the firewalls
+	// of a high-availability cluster, by listening to its sync messages on this address (eg. "225.0.0.50:3780"), so
+	// a passive firewall resolves them before it fails over
+	ConntrackdSyncAddress string
+
+	// ConntrackdSyncInterface is the interface the multicast group of ConntrackdSyncAddress is joined on
+	ConntrackdSyncInterface string
+
+	// ConntrackDockerPortBindings enables the resolution of the connections to the ports published by docker
+	// containers from their port bindings, when conntrack has no translation for them (eg. with docker-proxy)
+	ConntrackDockerPortBindings bool
+
+	// ConntrackContainerAttribution sets the container of the network namespace of the connections on their
+	// translations, resolved from the cgroups of the processes of the namespace, so the NAT of the containers can be
+	// attributed to them
+	ConntrackContainerAttribution bool
+
+	// ConntrackServiceResolution sets the Kubernetes service the pre-NAT destination of the connections belongs to on
+	// their translations, from the service addresses listed by the cluster agent
+	ConntrackServiceResolution bool
+
+	// ConntrackPIDAttribution sets the process which created the connections on their translations, from the pid
+	// the tracer knows of their sockets, so the NAT of the processes can be attributed to them
+	ConntrackPIDAttribution bool
+
+	// ConntrackNATRuleFilter only tracks the conntrack entries matching the addresses of the NAT rules of the host,
+	// which reduces the cache size on hosts where only a few subnets are NAT'ed
+	ConntrackNATRuleFilter bool
+
+	// ConntrackIncludeCIDRs restricts the conntrack entries tracked to the ones with an address in one of these networks.
+	// All entries are tracked when it is empty.
+	ConntrackIncludeCIDRs []string
+
+	// ConntrackExcludeCIDRs prevents the conntrack entries with an address in one of these networks from being tracked
+	ConntrackExcludeCIDRs []string
+
+	// ConntrackIncludePorts restricts the conntrack entries tracked to the ones with an origin port in one of these
+	// ranges (eg. "1-32767"). All entries are tracked when it is empty.
+	ConntrackIncludePorts []string
+
+	// ConntrackExcludePorts prevents the conntrack entries with an origin port in one of these ranges from being tracked
+	ConntrackExcludePorts []string
+
+	// ConntrackZones restricts the conntrack entries tracked to the ones of these conntrack zones and of the default
+	// zone, the zone listed first winning when a connection is tracked in several of them (eg. the zones OVN-Kubernetes
+	// does the service NAT in). The zones are ignored when it is empty.
+	ConntrackZones []string
+
+	// ConntrackCloudNATCIDRs are the ranges of the cloud NAT gateways (eg. the elastic IPs of AWS NAT gateways or the
+	// addresses of GCP Cloud NAT), the translations whose reply side is in one of them are flagged as cloud NAT
+	ConntrackCloudNATCIDRs []string
+
+	// ConntrackTraceTuples are connections ("tcp 10.0.0.1:40000 10.96.0.1:443") whose conntrack events, registrations,
+	// deletions and lookups are logged at the info level, to debug the connections missing their NAT translation
+	ConntrackTraceTuples []string
+
+	// ConntrackSkipUnreplied skips the conntrack entries whose connection didn't see any reply yet (eg. half-open UDP
+	// flows or SYNs sent to dead hosts), which fill the cache during scans. It requires the "update" netlink group.
+	ConntrackSkipUnreplied bool
+
+	// ConntrackSkipUnassured skips the conntrack entries the kernel didn't mark ASSURED yet, whose connection isn't
+	// established both ways. It requires the "update" netlink group.
+	ConntrackSkipUnassured bool
+
+	// ConntrackSkipLocalReplies keeps the conntrack entries whose reply has a loopback or link-local address (eg. REDIRECT
+	// rules or localhost DNAT) out of the cache. They are still reported by the debug dumps of the cache.
+	ConntrackSkipLocalReplies bool
+
+	// ConntrackTCPState keeps the conntrack state of the translated TCP connections, so the connections
+	// conntrack considers closed can be expired. Needs the "update" netlink group to follow state transitions.
+	ConntrackTCPState bool
+
+	// ConntrackCounters keeps the packet and byte counters of the translated connections, which the kernel
+	// only maintains when nf_conntrack_acct is enabled
+	ConntrackCounters bool
+
+	// ConntrackStartTimes keeps the creation time of the translated connections, which the kernel
+	// only records when nf_conntrack_timestamp is enabled
+	ConntrackStartTimes bool
+
+	// ConntrackStatus keeps the status flags (eg. ASSURED, DYING) of the translated connections. Needs the "update"
+	// netlink group to follow their changes.
+	ConntrackStatus bool
+
+	// ConntrackExpectations enables the tracking of the conntrack expectation table, so the connections created
+	// by conntrack helpers (eg. FTP data channels) are translated
+	ConntrackExpectations bool
+
+	// ConntrackDisableIPv6 skips the IPv6 conntrack entries. They are also skipped when IPv6 connections
+	// aren't collected, or when IPv6 isn't available on the host.
+	ConntrackDisableIPv6 bool
+
+	// ConntrackLazyInit loads the initial dump of the conntrack table in the background, so the startup isn't
+	// held up on hosts with large conntrack tables. Connections may not be translated until the dump is loaded.
+	ConntrackLazyInit bool
+
+	// ConntrackInitTimeout is how long the initialization of conntrack is waited for before it is retried in the
+	// background, ConntrackDumpTimeout how long the initial dump of each address family is loaded for. A value of
+	// 0 uses the default timeout of 10 seconds, and doesn't bound the initial dumps respectively.
+	ConntrackInitTimeout time.Duration
+	ConntrackDumpTimeout time.Duration
+
+	// ConntrackInitRetryBackoff and ConntrackInitRetryMaxBackoff bound the backoff between the retries of the
+	// initialization of conntrack, 0 using the defaults. ConntrackInitMaxRetries is the number of retries after
+	// which the initialization is given up on, 0 retrying forever and a negative value never retrying.
+	ConntrackInitRetryBackoff    time.Duration
+	ConntrackInitRetryMaxBackoff time.Duration
+	ConntrackInitMaxRetries      int
+
+	// ConntrackDumpMarkMask chunks the dumps of the conntrack table on these bits of the conntrack mark,
+	// so huge tables are dumped one part at a time. The table is dumped in one pass when it is 0.
+	ConntrackDumpMarkMask uint32
+
+	// ConntrackRcvBufSize is the size (in bytes) of the conntrack netlink socket receive buffer.
+	// A value of 0 uses the default size.
+	ConntrackRcvBufSize int
+
+	// ConntrackMmapRing reads the conntrack events off a ring buffer shared with the kernel (NETLINK_RX_RING) rather
+	// than with one recvmsg call each, on the kernels supporting it (before 4.6). The ring is ConntrackRcvBufSize large.
+	ConntrackMmapRing bool
+
+	// ConntrackResyncInterval is the interval at which the conntrack cache is reconciled with a full dump of the kernel table.
+	// A value of 0 disables the periodic re-sync.
+	ConntrackResyncInterval time.Duration
+
+	// ConntrackTCPTTL and ConntrackUDPTTL are how long the conntrack entries of each protocol are kept after they were
+	// last stored, so the short-lived UDP flows don't fill the cache. A value of 0 keeps them until they are destroyed.
+	ConntrackTCPTTL time.Duration
+	ConntrackUDPTTL time.Duration
+
+	// ConntrackRegisterQueueSize is the number of conntrack events queued for a dedicated goroutine to register them,
+	// so the reads of the netlink socket never wait for the lock of the cache. A value of 0 disables the queue.
+	ConntrackRegisterQueueSize int
+
+	// ConntrackMissQueryRate is the maximum number of queries per second sent to the kernel conntrack table for the
+	// connections missing from the conntrack cache, whose entries found are cached. A value of 0 disables the queries.
+	ConntrackMissQueryRate int
+
+	// ConntrackNegativeLookupTTL is how long the connections found without translation are cached as such when
+	// ConntrackMissQueryRate is set, so they are neither looked up with the lock held nor queried again. A value of 0
+	// disables the cache.
+	ConntrackNegativeLookupTTL time.Duration
+
+	// ConntrackSnapshotPath is the file the conntrack cache is persisted to across system-probe restarts.
+	// Persistence is disabled when empty.
+	ConntrackSnapshotPath string
+
+	// ConntrackRecordPath is the file the conntrack netlink messages are recorded to, so they can be replayed offline.
+	// Recording is disabled when empty.
+	ConntrackRecordPath string
+
+	// ConntrackReplayPath is a recording of conntrack netlink messages replayed instead of reading them from
+	// the kernel, to reproduce an issue offline. It must be empty outside of troubleshooting.
+	ConntrackReplayPath string
+
+	// ConntrackNetlinkGroups are the conntrack event groups ("new", "update", "destroy") subscribed to.
+	// Only new connection events are subscribed to if it is empty.
+	ConntrackNetlinkGroups []string
+
+	// ConntrackUDPCloseHints closes the UDP connections conntrack destroys right away, rather than once they idle out.
+	// It subscribes to the conntrack DESTROY events of the UDP connections, whether they are translated or not.
+	ConntrackUDPCloseHints bool
+
+	// ConntrackNATEvents sends the creations and deletions of the translations to the event platform, so the NAT of
+	// the host can be audited
+	ConntrackNATEvents bool
+
+	// ConntrackAdaptiveSampling enables the periodic adjustment of the netlink sampling rate based on the event rate
+	// and the CPU usage of the netlink reader, so the sampling rate can go back up once the load decreases.
+	ConntrackAdaptiveSampling bool
+
+	// ConntrackAdaptiveCPUBudget is the fraction of a CPU core the netlink reader may use when adaptive sampling is enabled.
+	// A value of 0 uses the default budget.
+	ConntrackAdaptiveCPUBudget float64
+
+	// ConntrackCPUBreakerBudget is the fraction of a CPU core the processing of conntrack events may use before it is
+	// paused, in which case the conntrack table is dumped periodically instead. A value of 0 disables the breaker.
+	ConntrackCPUBreakerBudget float64
+
 	// DebugPort specifies a port to run golang's expvar and pprof debug endpoint
 	DebugPort int
 
@@ -0,0 +1,68 @@
+// +build linux_bpf windows
+
+package ebpf
+
+import (
+	"github.com/DataDog/datadog-agent/pkg/network/netlink"
+	"github.com/DataDog/datadog-agent/pkg/status/health"
+	"github.com/DataDog/datadog-agent/pkg/telemetry"
+)
+
+// conntrackTelemetrySubsystem is the subsystem the stats of the conntrackers are reported under to the agent telemetry
+const conntrackTelemetrySubsystem = "conntrack"
+
+var (
+	tlmConntrackStateSize        = telemetry.NewGauge(conntrackTelemetrySubsystem, "state_size", nil, "Number of entries of the conntrack cache")
+	tlmConntrackSamplingPct      = telemetry.NewGauge(conntrackTelemetrySubsystem, "sampling_pct", nil, "Percentage of the conntrack events processed")
+	tlmConntrackGets             = telemetry.NewCounter(conntrackTelemetrySubsystem, "gets", nil, "Number of translations looked up")
+	tlmConntrackGetHits          = telemetry.NewCounter(conntrackTelemetrySubsystem, "get_hits", nil, "Number of translations found")
+	tlmConntrackRegisters        = telemetry.NewCounter(conntrackTelemetrySubsystem, "registers", nil, "Number of conntrack entries registered")
+	tlmConntrackRegistersDropped = telemetry.NewCounter(conntrackTelemetrySubsystem, "registers_dropped", nil, "Number of conntrack entries which weren't stored")
+	tlmConntrackUnregisters      = telemetry.NewCounter(conntrackTelemetrySubsystem, "unregisters", nil, "Number of translations deleted")
+)
+
+// registerConntrackReadiness registers the readiness check of a conntracker with the agent health
+func registerConntrackReadiness(name string) netlink.Readiness {
+	return healthReadiness{health.RegisterReadiness(name)}
+}
+
+// healthReadiness is the netlink.Readiness of an agent health readiness check
+type healthReadiness struct {
+	*health.Handle
+}
+
+func (h healthReadiness) Healthy() {
+	select {
+	case <-h.C:
+	default:
+	}
+}
+
+// conntrackTelemetry reports the typed stats of a conntracker to the agent telemetry. The stats being totals, the
+// counters are increased by the change of their stat since the last report.
+type conntrackTelemetry struct {
+	last netlink.Stats
+}
+
+func (t *conntrackTelemetry) Report(s netlink.Stats) {
+	tlmConntrackStateSize.Set(float64(s.StateSize))
+	tlmConntrackSamplingPct.Set(float64(s.SamplingPercent))
+	addChange(tlmConntrackGets, t.last.Gets, s.Gets)
+	addChange(tlmConntrackGetHits, t.last.GetHits, s.GetHits)
+	addChange(tlmConntrackRegisters, t.last.Registers, s.Registers)
+	addChange(tlmConntrackRegistersDropped, t.last.RegistersDropped, s.RegistersDropped)
+	addChange(tlmConntrackUnregisters, t.last.Unregisters, s.Unregisters)
+	t.last = s
+}
+
+// addChange increases the counter by the change of a total. A total lower than its last value was reset, so the
+// counter is increased by the whole total.
+func addChange(c telemetry.Counter, last, total int64) {
+	change := total - last
+	if change < 0 {
+		change = total
+	}
+	if change > 0 {
+		c.Add(float64(change))
+	}
+}
@@ -0,0 +1,32 @@
+// +build linux_bpf
+
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCounter struct {
+	total float64
+}
+
+func (c *fakeCounter) Inc(...string)                              { c.total++ }
+func (c *fakeCounter) Add(value float64, _ ...string)             { c.total += value }
+func (c *fakeCounter) Delete(...string)                           {}
+func (c *fakeCounter) IncWithTags(map[string]string)              { c.total++ }
+func (c *fakeCounter) AddWithTags(v float64, _ map[string]string) { c.total += v }
+func (c *fakeCounter) DeleteWithTags(map[string]string)           {}
+
+func TestAddChange(t *testing.T) {
+	c := &fakeCounter{}
+	addChange(c, 0, 10)
+	addChange(c, 10, 15)
+	assert.Equal(t, float64(15), c.total)
+	// the total was reset, eg. by a new conntracker
+	addChange(c, 15, 3)
+	assert.Equal(t, float64(18), c.total)
+	addChange(c, 3, 3)
+	assert.Equal(t, float64(18), c.total)
+}
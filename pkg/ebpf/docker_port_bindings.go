@@ -0,0 +1,108 @@
+// +build linux_bpf
+// +build docker
+
+package ebpf
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/network/netlink"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/DataDog/datadog-agent/pkg/util/docker"
+)
+
+// dockerPortBindingsPollInterval is the interval at which the port bindings of the docker containers are listed
+const dockerPortBindingsPollInterval = 10 * time.Second
+
+// dockerPortBindings lists the ports published by the docker containers running on the host, from the port
+// bindings and network settings of the container list
+type dockerPortBindings struct {
+	du *docker.DockerUtil
+}
+
+// newDockerPortBindingConntracker returns a Conntracker falling back to the port bindings of the docker containers
+// for the connections to published ports the given Conntracker doesn't have a translation for
+func newDockerPortBindingConntracker(ctr netlink.Conntracker) (netlink.Conntracker, error) {
+	du, err := docker.GetDockerUtil()
+	if err != nil {
+		return nil, fmt.Errorf("could not reach docker: %w", err)
+	}
+	return netlink.NewPolledPortBindingConntracker(ctr, &dockerPortBindings{du: du}, dockerPortBindingsPollInterval)
+}
+
+func (b *dockerPortBindings) Name() string {
+	return "the docker containers"
+}
+
+func (b *dockerPortBindings) PortBindings() ([]netlink.PortBinding, error) {
+	containers, err := b.du.RawContainerList(types.ContainerListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var bindings []netlink.PortBinding
+	for _, c := range containers {
+		bindings = append(bindings, containerPortBindings(c)...)
+	}
+	return bindings, nil
+}
+
+// containerPortBindings returns the ports published by the given container, forwarded to its address on the
+// network of its network mode, or on the first of its networks otherwise
+func containerPortBindings(c types.Container) []netlink.PortBinding {
+	if c.NetworkSettings == nil || len(c.Ports) == 0 {
+		return nil
+	}
+
+	var containerIP util.Address
+	if n, ok := c.NetworkSettings.Networks[c.HostConfig.NetworkMode]; ok && n != nil && n.IPAddress != "" {
+		containerIP = util.AddressFromString(n.IPAddress)
+	} else {
+		names := make([]string, 0, len(c.NetworkSettings.Networks))
+		for name := range c.NetworkSettings.Networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if n := c.NetworkSettings.Networks[name]; n != nil && n.IPAddress != "" {
+				containerIP = util.AddressFromString(n.IPAddress)
+				break
+			}
+		}
+	}
+	if containerIP == nil {
+		return nil
+	}
+
+	var bindings []netlink.PortBinding
+	for _, p := range c.Ports {
+		if p.PublicPort == 0 {
+			continue
+		}
+		transport := network.TCP
+		if p.Type == "udp" {
+			transport = network.UDP
+		} else if p.Type != "tcp" {
+			continue
+		}
+
+		var hostIP util.Address
+		if ip := net.ParseIP(p.IP); ip != nil && !ip.IsUnspecified() {
+			hostIP = util.AddressFromNetIP(ip)
+		}
+		bindings = append(bindings, netlink.PortBinding{
+			HostIP:        hostIP,
+			HostPort:      p.PublicPort,
+			ContainerIP:   containerIP,
+			ContainerPort: p.PrivatePort,
+			Transport:     transport,
+		})
+	}
+	return bindings
+}
@@ -0,0 +1,15 @@
+// +build linux_bpf
+// +build !docker
+
+package ebpf
+
+import (
+	"errors"
+
+	"github.com/DataDog/datadog-agent/pkg/network/netlink"
+)
+
+// newDockerPortBindingConntracker is not supported without docker
+func newDockerPortBindingConntracker(_ netlink.Conntracker) (netlink.Conntracker, error) {
+	return nil, errors.New("docker support not compiled in")
+}
@@ -0,0 +1,93 @@
+// +build linux_bpf
+
+package ebpf
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	coreconfig "github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/logs/auditor"
+	"github.com/DataDog/datadog-agent/pkg/logs/client"
+	logsconfig "github.com/DataDog/datadog-agent/pkg/logs/config"
+	"github.com/DataDog/datadog-agent/pkg/logs/message"
+	"github.com/DataDog/datadog-agent/pkg/logs/pipeline"
+	"github.com/DataDog/datadog-agent/pkg/network/netlink"
+	"github.com/DataDog/datadog-agent/pkg/status/health"
+)
+
+const (
+	// natEventSource is the source and service the NAT events are sent to the event platform with
+	natEventSource = "network-nat-events"
+
+	// natEventSendTimeout is how long a batch of NAT events waits for room in the pipelines before the rest of it is
+	// dropped
+	natEventSendTimeout = 5 * time.Second
+)
+
+// natEventForwarder is the netlink.NATEventSink sending the NAT events to the event platform, through pipelines of
+// the logs agent, the same way the security agent sends its events. It never queues the events it is sent beyond the
+// channels of the pipelines: the rest of a batch is dropped when they stay full for natEventSendTimeout.
+type natEventForwarder struct {
+	source   *logsconfig.LogSource
+	auditor  *auditor.Auditor
+	provider pipeline.Provider
+	context  *client.DestinationsContext
+}
+
+// newNATEventForwarder starts the pipelines sending the NAT events to the HTTP endpoints of the event platform
+func newNATEventForwarder() (*natEventForwarder, error) {
+	endpoints, err := logsconfig.BuildHTTPEndpoints()
+	if err != nil {
+		return nil, fmt.Errorf("invalid event platform endpoints: %s", err)
+	}
+
+	context := client.NewDestinationsContext()
+	context.Start()
+
+	health := health.RegisterLiveness(natEventSource)
+	auditor := auditor.New(coreconfig.Datadog.GetString("logs_config.run_path"), "network-nat-events-registry.json", health)
+	auditor.Start()
+
+	provider := pipeline.NewProvider(logsconfig.NumberOfPipelines, auditor, nil, endpoints, context)
+	provider.Start()
+
+	return &natEventForwarder{
+		source: logsconfig.NewLogSource(natEventSource, &logsconfig.LogsConfig{
+			Type:    natEventSource,
+			Service: natEventSource,
+			Source:  natEventSource,
+		}),
+		auditor:  auditor,
+		provider: provider,
+		context:  context,
+	}, nil
+}
+
+// SendNATEvents sends each NAT event as a message of the event platform
+func (f *natEventForwarder) SendNATEvents(events []netlink.NATEvent) (int, error) {
+	timeout := time.NewTimer(natEventSendTimeout)
+	defer timeout.Stop()
+
+	for i := range events {
+		buf, err := json.Marshal(&events[i])
+		if err != nil {
+			return i, err
+		}
+		select {
+		case f.provider.NextPipelineChan() <- message.NewMessageWithSource(buf, message.StatusInfo, f.source):
+		case <-timeout.C:
+			return i, fmt.Errorf("the event platform pipelines were full for %s", natEventSendTimeout)
+		}
+	}
+	return len(events), nil
+}
+
+// Close stops the pipelines once the events they hold were sent
+func (f *natEventForwarder) Close() error {
+	f.provider.Stop()
+	f.auditor.Stop()
+	f.context.Stop()
+	return nil
+}
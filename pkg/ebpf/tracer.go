@@ -16,6 +16,7 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/ebpf/bytecode"
 	"github.com/DataDog/datadog-agent/pkg/network"
 	"github.com/DataDog/datadog-agent/pkg/network/netlink"
+	"github.com/DataDog/datadog-agent/pkg/util/containers/providers"
 	"github.com/DataDog/datadog-agent/pkg/util/kernel"
 	"github.com/DataDog/datadog-agent/pkg/util/log"
 	"github.com/DataDog/ebpf"
@@ -43,7 +44,11 @@ type Tracer struct {
 	portMapping    *network.PortMapping
 	udpPortMapping *network.PortMapping
 
-	conntracker netlink.Conntracker
+	conntracker *netlink.ToggleConntracker
+	// udpCloseHints holds the UDP connections destroyed by conntrack, nil unless ConntrackUDPCloseHints is set
+	udpCloseHints *netlink.UDPCloseHints
+	// natEvents exports the translations created and deleted, nil unless ConntrackNATEvents is set
+	natEvents *netlink.NATEventExporter
 
 	reverseDNS network.ReverseDNS
 
@@ -72,6 +77,10 @@ type Tracer struct {
 	// to determine whether a connection is truly closed or not
 	expiredTCPConns int64
 	closedConns     int64
+	// Will track the count of TCP connections expired because conntrack considers them closed
+	conntrackClosedTCPConns int64
+	// Will track the count of UDP connections expired because conntrack destroyed their entry
+	conntrackClosedUDPConns int64
 
 	buffer     []network.ConnectionStats
 	bufferLock sync.Mutex
@@ -215,12 +224,22 @@ func NewTracer(config *Config) (*Tracer, error) {
 		return nil, fmt.Errorf("failed to read initial UDP pid->port mapping: %s", err)
 	}
 
-	conntracker := netlink.NewNoOpConntracker()
-	if config.EnableConntrack {
-		if c, err := netlink.NewConntracker(config.ProcRoot, config.ConntrackMaxStateSize, config.ConntrackRateLimit, config.EnableConntrackAllNamespaces); err != nil {
-			log.Warnf("could not initialize conntrack, tracer will continue without NAT tracking: %s", err)
+	// newConntracker falls back to a no-op conntracker rather than failing
+	conntracker, _ := netlink.NewToggleConntracker(func() (netlink.Conntracker, error) {
+		return newConntracker(config), nil
+	}, config.EnableConntrack)
+
+	var udpCloseHints *netlink.UDPCloseHints
+	if config.ConntrackUDPCloseHints {
+		udpCloseHints = netlink.NewUDPCloseHints(conntracker)
+	}
+
+	var natEvents *netlink.NATEventExporter
+	if config.ConntrackNATEvents {
+		if forwarder, err := newNATEventForwarder(); err != nil {
+			log.Warnf("could not send the NAT events to the event platform: %s", err)
 		} else {
-			conntracker = c
+			natEvents = netlink.NewNATEventExporter(conntracker, forwarder)
 		}
 	}
 
@@ -240,6 +259,8 @@ func NewTracer(config *Config) (*Tracer, error) {
 		reverseDNS:     reverseDNS,
 		buffer:         make([]network.ConnectionStats, 0, 512),
 		conntracker:    conntracker,
+		udpCloseHints:  udpCloseHints,
+		natEvents:      natEvents,
 		sourceExcludes: network.ParseConnectionFilters(config.ExcludedSourceConnections),
 		destExcludes:   network.ParseConnectionFilters(config.ExcludedDestinationConnections),
 		perfHandler:    perfHandler,
@@ -431,6 +452,128 @@ func (t *Tracer) storeClosedConn(cs network.ConnectionStats) {
 	}
 }
 
+// newConntracker initializes the conntracker, along with the conntrackers layered on top of it. The ones failing to
+// initialize are left out.
+func newConntracker(config *Config) netlink.Conntracker {
+	conntrackConfig := &netlink.Config{
+		ProcRoot:            config.ProcRoot,
+		MaxStateSize:        config.ConntrackMaxStateSize,
+		AutoMaxStateSize:    config.ConntrackAutoMaxStateSize,
+		ArenaMinStateSize:   config.ConntrackArenaMinStateSize,
+		CompactionStrategy:  config.ConntrackCompactionStrategy,
+		CompactInterval:     config.ConntrackCompactInterval,
+		MaxEntriesPerSource: config.ConntrackMaxEntriesPerSource,
+		QuotaPerNamespace:   config.ConntrackQuotaPerNamespace,
+		TargetRateLimit:     config.ConntrackRateLimit,
+		NamespaceRateLimit:  config.ConntrackNamespaceRateLimit,
+		ListenAllNamespaces: config.EnableConntrackAllNamespaces,
+		Modprobe:            config.EnableConntrackModprobe,
+		DisableIPv6:         config.ConntrackDisableIPv6 || !config.CollectIPv6Conns,
+		LazyInit:            config.ConntrackLazyInit,
+		InitTimeout:         config.ConntrackInitTimeout,
+		DumpTimeout:         config.ConntrackDumpTimeout,
+		InitRetryBackoff:    config.ConntrackInitRetryBackoff,
+		InitRetryMaxBackoff: config.ConntrackInitRetryMaxBackoff,
+		InitMaxRetries:      config.ConntrackInitMaxRetries,
+		DumpMarkMask:        config.ConntrackDumpMarkMask,
+		RcvBufSize:          config.ConntrackRcvBufSize,
+		MmapRing:            config.ConntrackMmapRing,
+		ResyncInterval:      config.ConntrackResyncInterval,
+		TCPEntryTTL:         config.ConntrackTCPTTL,
+		UDPEntryTTL:         config.ConntrackUDPTTL,
+		RegisterQueueSize:   config.ConntrackRegisterQueueSize,
+		MissQueryRate:       config.ConntrackMissQueryRate,
+		NegativeLookupTTL:   config.ConntrackNegativeLookupTTL,
+		ConntrackdAddress:   config.ConntrackdSyncAddress,
+		ConntrackdInterface: config.ConntrackdSyncInterface,
+		SnapshotPath:        config.ConntrackSnapshotPath,
+		RecordPath:          config.ConntrackRecordPath,
+		ReplayPath:          config.ConntrackReplayPath,
+		NetlinkGroups:       config.ConntrackNetlinkGroups,
+		UDPCloseHints:       config.ConntrackUDPCloseHints,
+		AdaptiveSampling:    config.ConntrackAdaptiveSampling,
+		AdaptiveCPUBudget:   config.ConntrackAdaptiveCPUBudget,
+		CPUBreakerBudget:    config.ConntrackCPUBreakerBudget,
+		NATRuleFilter:       config.ConntrackNATRuleFilter,
+		IncludeCIDRs:        config.ConntrackIncludeCIDRs,
+		ExcludeCIDRs:        config.ConntrackExcludeCIDRs,
+		IncludePorts:        config.ConntrackIncludePorts,
+		ExcludePorts:        config.ConntrackExcludePorts,
+		Zones:               config.ConntrackZones,
+		TraceTuples:         config.ConntrackTraceTuples,
+		SkipUnreplied:       config.ConntrackSkipUnreplied,
+		SkipUnassured:       config.ConntrackSkipUnassured,
+		SkipLocalReplies:    config.ConntrackSkipLocalReplies,
+		TrackTCPState:       config.ConntrackTCPState,
+		TrackCounters:       config.ConntrackCounters,
+		TrackStartTimes:     config.ConntrackStartTimes,
+		TrackStatus:         config.ConntrackStatus,
+		TrackExpectations:   config.ConntrackExpectations,
+		RegisterReadiness:   registerConntrackReadiness,
+		StatsReporter:       &conntrackTelemetry{},
+	}
+	conntracker, err := netlink.NewConntracker(conntrackConfig)
+	if err != nil {
+		log.Warnf("could not initialize conntrack, tracer will continue without NAT tracking: %s", err)
+		conntracker = netlink.NewNoOpConntracker()
+	}
+	if config.EnableConntrackIPVS {
+		c, err := netlink.NewIPVSConntracker(conntracker, conntrackConfig)
+		if err != nil {
+			log.Warnf("could not initialize IPVS conntrack, tracer will continue without IPVS NAT tracking: %s", err)
+		} else {
+			conntracker = c
+		}
+	}
+	if config.EnableConntrackCilium {
+		c, err := netlink.NewCiliumConntracker(conntracker, conntrackConfig)
+		if err != nil {
+			log.Warnf("could not initialize Cilium conntrack, tracer will continue without Cilium NAT tracking: %s", err)
+		} else {
+			conntracker = c
+		}
+	}
+	if config.ConntrackdSyncAddress != "" {
+		c, err := netlink.NewConntrackdConntracker(conntracker, conntrackConfig)
+		if err != nil {
+			log.Warnf("could not listen to conntrackd, tracer will continue without the replicated NAT tracking: %s", err)
+		} else {
+			conntracker = c
+		}
+	}
+	if config.ConntrackDockerPortBindings {
+		c, err := newDockerPortBindingConntracker(conntracker)
+		if err != nil {
+			log.Warnf("could not initialize the docker port bindings, tracer will continue without them: %s", err)
+		} else {
+			conntracker = c
+		}
+	}
+	if config.ConntrackServiceResolution {
+		conntracker = newClusterAgentServiceConntracker(conntracker)
+	}
+	if len(config.ConntrackCloudNATCIDRs) > 0 {
+		c, err := netlink.NewCloudNATConntracker(conntracker, config.ConntrackCloudNATCIDRs)
+		if err != nil {
+			log.Warnf("invalid cloud NAT ranges, tracer will continue without flagging cloud NAT: %s", err)
+		} else {
+			conntracker = c
+		}
+	}
+	if config.ConntrackContainerAttribution {
+		c, err := netlink.NewContainerConntracker(conntracker, config.ProcRoot, providers.ContainerImpl().ContainerIDForPID)
+		if err != nil {
+			log.Warnf("could not list the containers, tracer will continue without attributing the NAT to them: %s", err)
+		} else {
+			conntracker = c
+		}
+	}
+	if config.ConntrackPIDAttribution {
+		conntracker = netlink.NewPIDConntracker(conntracker, conntrackConfig)
+	}
+	return conntracker
+}
+
 func (t *Tracer) Stop() {
 	close(t.stop)
 	t.reverseDNS.Close()
@@ -438,10 +581,36 @@ func (t *Tracer) Stop() {
 	_ = t.perfMap.Stop(manager.CleanAll)
 	t.perfHandler.Stop()
 	close(t.flushIdle)
+	t.udpCloseHints.Close()
+	t.natEvents.Close()
 	t.conntracker.Close()
 	t.conntrack.Close()
 }
 
+// SetConntrackRateLimit changes the maximum number of conntrack messages per second processed by the conntracker
+func (t *Tracer) SetConntrackRateLimit(limit int) {
+	t.conntracker.SetTargetRateLimit(limit)
+}
+
+// SetConntrackEnabled enables or disables the conntracker at runtime. Disabling it stops consuming the conntrack
+// events and frees the conntrack cache, and enabling it loads the conntrack table anew, with the conntrack settings
+// the tracer was created with.
+func (t *Tracer) SetConntrackEnabled(enabled bool) error {
+	if !enabled {
+		t.conntracker.Disable()
+		return nil
+	}
+	return t.conntracker.Enable()
+}
+
+// RefreshConntrack dumps the kernel conntrack table and reconciles the conntrack cache with it
+func (t *Tracer) RefreshConntrack() error {
+	if err := t.conntracker.Refresh(unix.AF_INET); err != nil {
+		return err
+	}
+	return t.conntracker.Refresh(unix.AF_INET6)
+}
+
 func (t *Tracer) GetActiveConnections(clientID string) (*network.Connections, error) {
 	t.bufferLock.Lock()
 	defer t.bufferLock.Unlock()
@@ -480,15 +649,9 @@ func (t *Tracer) getConnTelemetry(mapSize int) *network.ConnectionsTelemetry {
 	}
 
 	conntrackStats := t.conntracker.GetStats()
-	if rt, ok := conntrackStats["registers_total"]; ok {
-		tm.MonotonicConntrackRegisters = rt
-	}
-	if rtd, ok := conntrackStats["registers_dropped"]; ok {
-		tm.MonotonicConntrackRegistersDropped = rtd
-	}
-	if sp, ok := conntrackStats["sampling_pct"]; ok {
-		tm.ConntrackSamplingPercent = sp
-	}
+	tm.MonotonicConntrackRegisters = conntrackStats.Registers
+	tm.MonotonicConntrackRegistersDropped = conntrackStats.RegistersDropped
+	tm.ConntrackSamplingPercent = conntrackStats.SamplingPercent
 
 	dnsStats := t.reverseDNS.GetStats()
 	if pp, ok := dnsStats["packets_processed"]; ok {
@@ -549,9 +712,12 @@ func (t *Tracer) getConnections(active []network.ConnectionStats) ([]network.Con
 	}
 
 	// Iterate through all key-value pairs in map
+	firstActive := len(active)
 	key, stats := &ConnTuple{}, &ConnStatsWithTimestamp{}
 	seen := make(map[ConnTuple]struct{})
 	var expired []*ConnTuple
+	// keys of the active connections, in the same order
+	var activeKeys []ConnTuple
 	entries := mp.IterateFrom(unsafe.Pointer(&ConnTuple{}))
 	for entries.Next(unsafe.Pointer(key), unsafe.Pointer(stats)) {
 		if stats.isExpired(latestTime, t.timeoutForConn(key)) && !t.conntrackExists(key) {
@@ -563,13 +729,11 @@ func (t *Tracer) getConnections(active []network.ConnectionStats) ([]network.Con
 		} else {
 			conn := connStats(key, stats, t.getTCPStats(tcpMp, key, seen))
 			conn.Direction = t.determineConnectionDirection(&conn)
-
 			if t.shouldSkipConnection(&conn) {
 				atomic.AddInt64(&t.skippedConns, 1)
 			} else {
-				// lookup conntrack in for active
-				conn.IPTranslation = t.conntracker.GetTranslationForConn(conn)
 				active = append(active, conn)
+				activeKeys = append(activeKeys, *key)
 			}
 		}
 	}
@@ -578,8 +742,36 @@ func (t *Tracer) getConnections(active []network.ConnectionStats) ([]network.Con
 		return nil, 0, fmt.Errorf("unable to iterate connection map: %s", err)
 	}
 
+	// lookup conntrack for the active connections at once, along with their TCP state
+	conns := active[firstActive:]
+	translations := t.conntracker.GetTranslationsForConns(conns)
+	tcpStates := t.conntracker.GetTCPStatesForConns(conns)
+	var closed []network.ConnectionStats
+	var closedKeys []*ConnTuple
+	n := firstActive
+	for i := range conns {
+		conn := conns[i]
+		conn.IPTranslation = translations[i]
+		if tcpStates[i].Closed() {
+			// the kernel considers the connection closed, so its close event was missed
+			atomic.AddInt64(&t.conntrackClosedTCPConns, 1)
+		} else if _, ok := t.udpCloseHints.Closed(conn); ok {
+			// conntrack destroyed the entry of the UDP connection, which is likely over
+			atomic.AddInt64(&t.conntrackClosedUDPConns, 1)
+		} else {
+			active[n] = conn
+			n++
+			continue
+		}
+		closed = append(closed, conn)
+		closedKeys = append(closedKeys, &activeKeys[i])
+	}
+	active = active[:n]
+	t.udpCloseHints.Expire()
+
 	// Remove expired entries
-	t.removeEntries(mp, tcpMp, expired)
+	t.removeEntries(mp, tcpMp, expired, nil)
+	t.removeEntries(mp, tcpMp, closedKeys, closed)
 
 	// check for expired clients in the state
 	t.state.RemoveExpiredClients(time.Now())
@@ -605,10 +797,14 @@ func (t *Tracer) getConnections(active []network.ConnectionStats) ([]network.Con
 	return active, latestTime, nil
 }
 
-func (t *Tracer) removeEntries(mp, tcpMp *ebpf.Map, entries []*ConnTuple) {
+// removeEntries removes the given entries from the eBPF maps and from the userspace state. When closed is set, it holds
+// the connection of each entry, which is stored as closed once its entry is removed, the same way tcp_close does.
+func (t *Tracer) removeEntries(mp, tcpMp *ebpf.Map, entries []*ConnTuple, closed []network.ConnectionStats) {
 	now := time.Now()
 	// Byte keys of the connections to remove
 	keys := make([]string, 0, len(entries))
+	// Connections whose conntrack entries are removed at once
+	removed := make([]network.ConnectionStats, 0, len(entries))
 	// Used to create the keys
 	statsWithTs, tcpStats := &ConnStatsWithTimestamp{}, &TCPStats{}
 	// Remove the entries from the eBPF Map
@@ -624,9 +820,13 @@ func (t *Tracer) removeEntries(mp, tcpMp *ebpf.Map, entries []*ConnTuple) {
 			continue
 		}
 
-		// Delete conntrack entry for this connection
+		if closed != nil {
+			atomic.AddInt64(&t.closedConns, 1)
+			t.state.StoreClosedConnection(&closed[i])
+		}
+
 		connStats := connStats(entries[i], statsWithTs, tcpStats)
-		t.conntracker.DeleteTranslation(connStats)
+		removed = append(removed, connStats)
 
 		// Append the connection key to the keys to remove from the userspace state
 		bk, err := connStats.ByteKey(t.buf)
@@ -642,6 +842,9 @@ func (t *Tracer) removeEntries(mp, tcpMp *ebpf.Map, entries []*ConnTuple) {
 		_ = tcpMp.Delete(unsafe.Pointer(entries[i]))
 	}
 
+	// Delete the conntrack entries of these connections
+	t.conntracker.DeleteTranslations(removed)
+
 	t.state.RemoveConnections(keys)
 
 	log.Debugf("Removed %d entries in %s", len(keys), time.Now().Sub(now))
@@ -731,6 +934,8 @@ func (t *Tracer) getTelemetry() (map[string]interface{}, error) {
 			stats["state"] = telemetry
 		}
 	}
+	// the description of the conntracker isn't a telemetry counter
+	delete(stats, "conntrack_description")
 	return stats, nil
 }
 
@@ -744,19 +949,30 @@ func (t *Tracer) GetStats() (map[string]interface{}, error) {
 	received := atomic.LoadInt64(&t.perfReceived)
 	skipped := atomic.LoadInt64(&t.skippedConns)
 	expiredTCP := atomic.LoadInt64(&t.expiredTCPConns)
+	conntrackClosedTCP := atomic.LoadInt64(&t.conntrackClosedTCPConns)
+	conntrackClosedUDP := atomic.LoadInt64(&t.conntrackClosedUDPConns)
 	pidCollisions := atomic.LoadInt64(&t.pidCollisions)
 
 	stateStats := t.state.GetStats()
-	conntrackStats := t.conntracker.GetStats()
+	conntrackStats := t.conntracker.GetStats().Map()
+	for k, v := range t.udpCloseHints.GetStats() {
+		conntrackStats[k] = v
+	}
+	for k, v := range t.natEvents.GetStats() {
+		conntrackStats[k] = v
+	}
 
 	return map[string]interface{}{
-		"conntrack": conntrackStats,
-		"state":     stateStats,
+		"conntrack":             conntrackStats,
+		"conntrack_description": t.conntracker.Describe(),
+		"state":                 stateStats,
 		"tracer": map[string]int64{
 			"closed_conn_polling_lost":     lost,
 			"closed_conn_polling_received": received,
 			"conn_valid_skipped":           skipped, // Skipped connections (e.g. Local DNS requests)
 			"expired_tcp_conns":            expiredTCP,
+			"conntrack_closed_tcp_conns":   conntrackClosedTCP,
+			"conntrack_closed_udp_conns":   conntrackClosedUDP,
 			"pid_collisions":               pidCollisions,
 		},
 		"ebpf":    t.getEbpfTelemetry(),
@@ -773,6 +989,23 @@ func (t *Tracer) DebugNetworkState(clientID string) (map[string]interface{}, err
 	return t.state.DumpState(clientID), nil
 }
 
+// DebugConntrackTable returns the content of the conntrack cache along with its stats and the kernel conntrack counters
+func (t *Tracer) DebugConntrackTable() (*network.DebugConntrackTable, error) {
+	return &network.DebugConntrackTable{
+		Entries:     t.conntracker.DumpCachedTable(),
+		Stats:       t.conntracker.GetStats().Map(),
+		NATGateways: network.DebugNATGateways(t.conntracker.GetNATGateways()),
+		Kernel:      netlink.GetKernelConntrackStats(t.config.ProcRoot),
+		Errors:      t.conntracker.GetRecentErrors(),
+		Description: t.conntracker.Describe(),
+	}, nil
+}
+
+// LookupConntrack returns the NAT translations of the given connections, nil for the ones which aren't translated
+func (t *Tracer) LookupConntrack(conns []network.ConntrackLookupConn) ([]*network.DebugConntrackEntry, error) {
+	return netlink.LookupTranslations(t.conntracker, conns)
+}
+
 // DebugNetworkMaps returns all connections stored in the BPF maps without modifications from network state
 func (t *Tracer) DebugNetworkMaps() (*network.Connections, error) {
 	latestConns, _, err := t.getConnections(make([]network.ConnectionStats, 0))
@@ -1207,7 +1207,7 @@ func removeConnection(t *testing.T, tr *Tracer, c *network.ConnectionStats) {
 		},
 	}
 
-	tr.removeEntries(mp, tcpMp, tuple)
+	tr.removeEntries(mp, tcpMp, tuple, nil)
 }
 
 func byAddress(l, r net.Addr) func(c network.ConnectionStats) bool {
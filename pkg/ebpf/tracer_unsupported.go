@@ -15,6 +15,19 @@ func NewTracer(_ *Config) (*Tracer, error) {
 // Stop is not implemented on this OS for Tracer
 func (t *Tracer) Stop() {}
 
+// SetConntrackRateLimit is not implemented on this OS for Tracer
+func (t *Tracer) SetConntrackRateLimit(_ int) {}
+
+// SetConntrackEnabled is not implemented on this OS for Tracer
+func (t *Tracer) SetConntrackEnabled(_ bool) error {
+	return ErrNotImplemented
+}
+
+// RefreshConntrack is not implemented on this OS for Tracer
+func (t *Tracer) RefreshConntrack() error {
+	return ErrNotImplemented
+}
+
 // GetActiveConnections is not implemented on this OS for Tracer
 func (t *Tracer) GetActiveConnections(_ string) (*network.Connections, error) {
 	return nil, ErrNotImplemented
@@ -30,6 +43,16 @@ func (t *Tracer) DebugNetworkState(clientID string) (map[string]interface{}, err
 	return nil, ErrNotImplemented
 }
 
+// DebugConntrackTable is not implemented on this OS for Tracer
+func (t *Tracer) DebugConntrackTable() (*network.DebugConntrackTable, error) {
+	return nil, ErrNotImplemented
+}
+
+// LookupConntrack is not implemented on this OS for Tracer
+func (t *Tracer) LookupConntrack(conns []network.ConntrackLookupConn) ([]*network.DebugConntrackEntry, error) {
+	return nil, ErrNotImplemented
+}
+
 // DebugNetworkMaps is not implemented on this OS for Tracer
 func (t *Tracer) DebugNetworkMaps() (*network.Connections, error) {
 	return nil, ErrNotImplemented
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/network/netlink"
 	"github.com/DataDog/datadog-agent/pkg/util/log"
 )
 
@@ -21,7 +22,7 @@ var (
 )
 
 func init() {
-	expvarTypes := []string{"state"}
+	expvarTypes := []string{"state", "conntrack"}
 	for _, n := range network.DriverExpvarNames {
 		expvarTypes = append(expvarTypes, string(n))
 	}
@@ -39,6 +40,7 @@ type Tracer struct {
 	stopChan        chan struct{}
 	state           network.State
 	reverseDNS      network.ReverseDNS
+	conntracker     *netlink.ToggleConntracker
 
 	connStatsActive *network.DriverBuffer
 	connStatsClosed *network.DriverBuffer
@@ -65,12 +67,24 @@ func NewTracer(config *Config) (*Tracer, error) {
 		config.MaxDNSStatsBufferred,
 	)
 
+	conntracker, err := netlink.NewToggleConntracker(func() (netlink.Conntracker, error) {
+		return netlink.NewConntracker(&netlink.Config{
+			MaxStateSize:  config.ConntrackMaxStateSize,
+			StatsReporter: &conntrackTelemetry{},
+		})
+	}, config.EnableConntrack)
+	if err != nil {
+		log.Warnf("could not initialize WinNAT conntrack, tracer will continue without NAT tracking: %s", err)
+	}
+
 	tr := &Tracer{
+		config:          config,
 		driverInterface: di,
 		stopChan:        make(chan struct{}),
 		timerInterval:   defaultPollInterval,
 		state:           state,
 		reverseDNS:      network.NewNullReverseDNS(),
+		conntracker:     conntracker,
 		connStatsActive: network.NewDriverBuffer(512),
 		connStatsClosed: network.NewDriverBuffer(512),
 	}
@@ -82,12 +96,33 @@ func NewTracer(config *Config) (*Tracer, error) {
 // Stop function stops running tracer
 func (t *Tracer) Stop() {
 	close(t.stopChan)
+	t.conntracker.Close()
 	err := t.driverInterface.Close()
 	if err != nil {
 		log.Errorf("error closing driver interface: %s", err)
 	}
 }
 
+// SetConntrackRateLimit changes the maximum number of conntrack messages per second processed by the conntracker
+func (t *Tracer) SetConntrackRateLimit(limit int) {
+	t.conntracker.SetTargetRateLimit(limit)
+}
+
+// SetConntrackEnabled enables or disables the WinNAT conntracker at runtime. Disabling it stops polling the WinNAT
+// session table and frees the conntrack cache.
+func (t *Tracer) SetConntrackEnabled(enabled bool) error {
+	if !enabled {
+		t.conntracker.Disable()
+		return nil
+	}
+	return t.conntracker.Enable()
+}
+
+// RefreshConntrack reads the WinNAT session table right away
+func (t *Tracer) RefreshConntrack() error {
+	return t.conntracker.Refresh(0)
+}
+
 func (t *Tracer) expvarStats(exit <-chan struct{}) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
@@ -108,6 +143,8 @@ func (t *Tracer) expvarStats(exit <-chan struct{}) {
 					stats["state"] = telemetry
 				}
 			}
+			// the description of the conntracker isn't a telemetry counter
+			delete(stats, "conntrack_description")
 
 			for name, stat := range stats {
 				for metric, val := range stat.(map[string]int64) {
@@ -139,9 +176,14 @@ func (t *Tracer) GetActiveConnections(clientID string) (*network.Connections, er
 	activeConnStats := t.connStatsActive.Connections()
 	closedConnStats := t.connStatsClosed.Connections()
 
-	for _, connStat := range closedConnStats {
-		t.state.StoreClosedConnection(&connStat)
+	for i, translation := range t.conntracker.GetTranslationsForConns(activeConnStats) {
+		activeConnStats[i].IPTranslation = translation
 	}
+	for i, translation := range t.conntracker.GetTranslationsForConns(closedConnStats) {
+		closedConnStats[i].IPTranslation = translation
+		t.state.StoreClosedConnection(&closedConnStats[i])
+	}
+	t.conntracker.DeleteTranslations(closedConnStats)
 
 	// check for expired clients in the state
 	t.state.RemoveExpiredClients(time.Now())
@@ -158,7 +200,9 @@ func (t *Tracer) GetStats() (map[string]interface{}, error) {
 
 	stateStats := t.state.GetStats()
 	stats := map[string]interface{}{
-		"state": stateStats,
+		"state":                 stateStats,
+		"conntrack":             t.conntracker.GetStats().Map(),
+		"conntrack_description": t.conntracker.Describe(),
 	}
 	for _, name := range network.DriverExpvarNames {
 		stats[string(name)] = driverStats[name]
@@ -171,6 +215,22 @@ func (t *Tracer) DebugNetworkState(_ string) (map[string]interface{}, error) {
 	return nil, ErrNotImplemented
 }
 
+// DebugConntrackTable returns the content of the WinNAT conntrack cache along with its stats
+func (t *Tracer) DebugConntrackTable() (*network.DebugConntrackTable, error) {
+	return &network.DebugConntrackTable{
+		Entries:     t.conntracker.DumpCachedTable(),
+		Stats:       t.conntracker.GetStats().Map(),
+		NATGateways: network.DebugNATGateways(t.conntracker.GetNATGateways()),
+		Errors:      t.conntracker.GetRecentErrors(),
+		Description: t.conntracker.Describe(),
+	}, nil
+}
+
+// LookupConntrack returns the WinNAT translations of the given connections, nil for the ones which aren't translated
+func (t *Tracer) LookupConntrack(conns []network.ConntrackLookupConn) ([]*network.DebugConntrackEntry, error) {
+	return netlink.LookupTranslations(t.conntracker, conns)
+}
+
 // DebugNetworkMaps returns all connections stored in the maps without modifications from network state
 func (t *Tracer) DebugNetworkMaps() (*network.Connections, error) {
 	return nil, ErrNotImplemented
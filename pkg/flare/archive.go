@@ -204,6 +204,13 @@ func createArchive(confSearchPaths SearchPaths, local bool, zipFilePath string,
 		if err != nil {
 			log.Errorf("Could not zip system probe exp var stats: %s", err)
 		}
+
+		if config.Datadog.GetBool("system_probe_config.flare_conntrack") {
+			err = zipSystemProbeConntrack(tempDir, hostname)
+			if err != nil {
+				log.Errorf("Could not zip system probe conntrack diagnostics: %s", err)
+			}
+		}
 	}
 
 	err = zipDiagnose(tempDir, hostname)
@@ -484,6 +491,30 @@ func zipSystemProbeStats(tempDir, hostname string) error {
 	return err
 }
 
+// zipSystemProbeConntrack adds the conntrack diagnostics support usually asks for, when the system probe
+// tracks the NAT translations
+func zipSystemProbeConntrack(tempDir, hostname string) error {
+	conntrack := status.GetSystemProbeConntrack(config.Datadog.GetString("system_probe_config.sysprobe_socket"))
+	conntrackFile := filepath.Join(tempDir, hostname, "system-probe", "conntrack.yaml")
+	err := ensureParentDirsExist(conntrackFile)
+	if err != nil {
+		return err
+	}
+
+	w, err := newRedactingWriter(conntrackFile, os.ModePerm, true)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	buf, err := yaml.Marshal(conntrack)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
 func zipConfigFiles(tempDir, hostname string, confSearchPaths SearchPaths, permsInfos permissionsInfos) error {
 	c, err := yaml.Marshal(config.Datadog.AllSettings())
 	if err != nil {
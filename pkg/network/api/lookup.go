@@ -0,0 +1,74 @@
+package api
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+)
+
+// NewConntrackLookupRequest returns the request looking the translations of the given connections up
+func NewConntrackLookupRequest(conns []network.ConntrackLookupConn) *ConntrackLookupRequest {
+	req := &ConntrackLookupRequest{Conns: make([]*ConntrackLookupConn, 0, len(conns))}
+	for _, c := range conns {
+		req.Conns = append(req.Conns, &ConntrackLookupConn{
+			Proto:  c.Proto,
+			Origin: newConntrackTuple(c.DebugConntrackTuple),
+			Netns:  c.NetNS,
+		})
+	}
+	return req
+}
+
+// LookupConns returns the connections whose translations are requested
+func (m *ConntrackLookupRequest) LookupConns() ([]network.ConntrackLookupConn, error) {
+	conns := make([]network.ConntrackLookupConn, 0, len(m.GetConns()))
+	for _, c := range m.GetConns() {
+		origin := c.GetOrigin()
+		if origin.GetSport() > math.MaxUint16 || origin.GetDport() > math.MaxUint16 {
+			return nil, fmt.Errorf("invalid port in %s:%d -> %s:%d", origin.GetSrc(), origin.GetSport(), origin.GetDst(), origin.GetDport())
+		}
+		conns = append(conns, network.ConntrackLookupConn{
+			Proto: c.GetProto(),
+			DebugConntrackTuple: network.DebugConntrackTuple{
+				Src:   origin.GetSrc(),
+				SPort: uint16(origin.GetSport()),
+				Dst:   origin.GetDst(),
+				DPort: uint16(origin.GetDport()),
+			},
+			NetNS: c.GetNetns(),
+		})
+	}
+	return conns, nil
+}
+
+// NewConntrackLookupResponse returns the response reporting the given translations, which are nil for the
+// connections which aren't translated
+func NewConntrackLookupResponse(entries []*network.DebugConntrackEntry) *ConntrackLookupResponse {
+	resp := &ConntrackLookupResponse{Translations: make([]*ConntrackTranslation, 0, len(entries))}
+	for _, e := range entries {
+		if e == nil {
+			resp.Translations = append(resp.Translations, &ConntrackTranslation{})
+			continue
+		}
+		resp.Translations = append(resp.Translations, &ConntrackTranslation{
+			Translated:  true,
+			Reply:       newConntrackTuple(e.Reply),
+			NatType:     e.NATType,
+			CloudNat:    e.CloudNAT,
+			ContainerId: e.ContainerID,
+			Pid:         e.Pid,
+			Service:     e.Service,
+		})
+	}
+	return resp
+}
+
+func newConntrackTuple(t network.DebugConntrackTuple) *ConntrackTuple {
+	return &ConntrackTuple{
+		Src:   t.Src,
+		Sport: uint32(t.SPort),
+		Dst:   t.Dst,
+		Dport: uint32(t.DPort),
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConntrackLookupRequest(t *testing.T) {
+	conns := []network.ConntrackLookupConn{
+		{Proto: "TCP", DebugConntrackTuple: network.DebugConntrackTuple{Src: "10.0.0.1", SPort: 50000, Dst: "10.96.0.1", DPort: 443}, NetNS: 4026531993},
+		{Proto: "UDP", DebugConntrackTuple: network.DebugConntrackTuple{Src: "fd00::1", SPort: 5353, Dst: "fd00::2", DPort: 53}},
+	}
+
+	buf, err := proto.Marshal(NewConntrackLookupRequest(conns))
+	require.NoError(t, err)
+	req := &ConntrackLookupRequest{}
+	require.NoError(t, proto.Unmarshal(buf, req))
+
+	lookup, err := req.LookupConns()
+	require.NoError(t, err)
+	assert.Equal(t, conns, lookup)
+
+	req.Conns[0].Origin.Sport = 70000
+	_, err = req.LookupConns()
+	assert.Error(t, err)
+}
+
+func TestConntrackLookupResponse(t *testing.T) {
+	resp := NewConntrackLookupResponse([]*network.DebugConntrackEntry{
+		nil,
+		{
+			Proto:       "TCP",
+			Origin:      network.DebugConntrackTuple{Src: "10.0.0.1", SPort: 50000, Dst: "10.96.0.1", DPort: 443},
+			Reply:       network.DebugConntrackTuple{Src: "10.244.1.5", SPort: 8443, Dst: "10.0.0.1", DPort: 50000},
+			NATType:     "dnat",
+			ContainerID: "3726b9c1e7e3",
+			Pid:         4242,
+			Service:     "default/web",
+		},
+	})
+
+	require.Len(t, resp.Translations, 2)
+	assert.False(t, resp.Translations[0].Translated)
+	assert.Nil(t, resp.Translations[0].Reply)
+
+	translation := resp.Translations[1]
+	assert.True(t, translation.Translated)
+	assert.Equal(t, "10.244.1.5", translation.Reply.Src)
+	assert.Equal(t, uint32(8443), translation.Reply.Sport)
+	assert.Equal(t, "10.0.0.1", translation.Reply.Dst)
+	assert.Equal(t, uint32(50000), translation.Reply.Dport)
+	assert.Equal(t, "dnat", translation.NatType)
+	assert.Equal(t, "3726b9c1e7e3", translation.ContainerId)
+	assert.Equal(t, uint32(4242), translation.Pid)
+	assert.Equal(t, "default/web", translation.Service)
+}
@@ -0,0 +1,108 @@
+package network
+
+import "github.com/DataDog/datadog-agent/pkg/process/util"
+
+// DebugConntrackTuple is a human readable representation of one side of a conntrack entry
+type DebugConntrackTuple struct {
+	Src   string `json:"src"`
+	SPort uint16 `json:"sport"`
+	Dst   string `json:"dst"`
+	DPort uint16 `json:"dport"`
+}
+
+// DebugConntrackEntry represents a single translation held in the conntrack cache.
+// Origin is the tuple used as the lookup key and Reply is the translation returned for it.
+type DebugConntrackEntry struct {
+	Proto  string              `json:"proto"`
+	Origin DebugConntrackTuple `json:"origin"`
+	Reply  DebugConntrackTuple `json:"reply"`
+	// NATType tells which ends of the origin are translated ("snat", "dnat" or "snat+dnat"), when known
+	NATType string `json:"nat_type,omitempty"`
+	// CloudNAT is set when the reply is in one of the configured cloud NAT gateway ranges
+	CloudNAT bool `json:"cloud_nat,omitempty"`
+	// ContainerID is the container whose network namespace the connection is in, when known
+	ContainerID string `json:"container_id,omitempty"`
+	// Service is the Kubernetes service ("namespace/name") the pre-NAT destination belongs to, when known
+	Service string `json:"service,omitempty"`
+	// Pid is the process which created the connection of the origin, when known
+	Pid uint32 `json:"pid,omitempty"`
+	// Local is set when the entry has a loopback or link-local reply, and is kept out of the cache
+	Local bool `json:"local,omitempty"`
+}
+
+// DebugConntrackTable is the payload returned by the system-probe conntrack debug endpoint
+type DebugConntrackTable struct {
+	Entries []DebugConntrackEntry `json:"entries"`
+	Stats   map[string]int64      `json:"stats"`
+	Kernel  map[string]int64      `json:"kernel"`
+	// NATGateways are the addresses the connections are source NATed to, the most used first
+	NATGateways []string `json:"nat_gateways,omitempty"`
+	// Errors are the last errors the conntracker ran into, the oldest first
+	Errors []string `json:"errors,omitempty"`
+	// Description is what the conntracker describes of itself
+	Description ConntrackDescription `json:"description"`
+}
+
+// ConntrackDescription describes a conntracker: its backend, the configuration in effect, and how far along its
+// initialization is. The configuration is left to its zero value when the backend has none, or isn't initialized.
+type ConntrackDescription struct {
+	// Backend is where the translations come from, eg. "netlink", "winnat" or "noop"
+	Backend string `json:"backend" yaml:"backend"`
+	// Status is "ready" once the backend serves the translations, "loading" while it loads the initial conntrack
+	// table, "initializing" while its initialization is retried, and "failed" or "disabled" when it doesn't serve any
+	Status string `json:"status" yaml:"status"`
+	// Layers are the conntrackers layered on top of the backend, the outermost first (eg. "ipvs", "cloud_nat")
+	Layers []string `json:"layers,omitempty" yaml:"layers,omitempty"`
+	// Storage is what the translations are stored in, "table" or "arena"
+	Storage string `json:"storage,omitempty" yaml:"storage,omitempty"`
+
+	TargetRateLimit     int      `json:"target_rate_limit,omitempty" yaml:"target_rate_limit,omitempty"`
+	MaxStateSize        int      `json:"max_state_size,omitempty" yaml:"max_state_size,omitempty"`
+	ListenAllNamespaces bool     `json:"listen_all_namespaces,omitempty" yaml:"listen_all_namespaces,omitempty"`
+	NetlinkGroups       []string `json:"netlink_groups,omitempty" yaml:"netlink_groups,omitempty"`
+}
+
+// DebugConntrackSummary summarizes the content of the conntrack cache, without the addresses of the connections
+type DebugConntrackSummary struct {
+	Entries   int            `json:"entries" yaml:"entries"`
+	ByProto   map[string]int `json:"by_proto" yaml:"by_proto"`
+	ByNATType map[string]int `json:"by_nat_type,omitempty" yaml:"by_nat_type,omitempty"`
+	CloudNAT  int            `json:"cloud_nat,omitempty" yaml:"cloud_nat,omitempty"`
+	Local     int            `json:"local,omitempty" yaml:"local,omitempty"`
+}
+
+// Summary counts the cached entries by protocol, by NAT type when it is known, and the cloud NAT and local ones
+func (t *DebugConntrackTable) Summary() DebugConntrackSummary {
+	s := DebugConntrackSummary{
+		Entries: len(t.Entries),
+		ByProto: make(map[string]int),
+	}
+	for _, e := range t.Entries {
+		s.ByProto[e.Proto]++
+		if e.NATType != "" {
+			if s.ByNATType == nil {
+				s.ByNATType = make(map[string]int)
+			}
+			s.ByNATType[e.NATType]++
+		}
+		if e.CloudNAT {
+			s.CloudNAT++
+		}
+		if e.Local {
+			s.Local++
+		}
+	}
+	return s
+}
+
+// DebugNATGateways returns the human readable representation of NAT gateway addresses
+func DebugNATGateways(addrs []util.Address) []string {
+	if len(addrs) == 0 {
+		return nil
+	}
+	gateways := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		gateways = append(gateways, addr.String())
+	}
+	return gateways
+}
@@ -0,0 +1,29 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugConntrackSummary(t *testing.T) {
+	table := &DebugConntrackTable{
+		Entries: []DebugConntrackEntry{
+			{Proto: "TCP", NATType: "dnat"},
+			{Proto: "TCP", NATType: "snat"},
+			{Proto: "UDP", NATType: "dnat"},
+			{Proto: "UDP", NATType: "snat", CloudNAT: true},
+			{Proto: "UDP"},
+			{Proto: "TCP", NATType: "dnat", Local: true},
+		},
+	}
+	assert.Equal(t, DebugConntrackSummary{
+		Entries:   6,
+		ByProto:   map[string]int{"TCP": 3, "UDP": 3},
+		ByNATType: map[string]int{"dnat": 3, "snat": 2},
+		CloudNAT:  1,
+		Local:     1,
+	}, table.Summary())
+
+	assert.Equal(t, DebugConntrackSummary{ByProto: map[string]int{}}, (&DebugConntrackTable{}).Summary())
+}
@@ -0,0 +1,75 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+// ConntrackLookupConn is a connection whose NAT translation is requested from the system-probe conntrack lookup
+// service, as seen by the socket it is looked up for
+type ConntrackLookupConn struct {
+	// Proto is either "TCP" or "UDP"
+	Proto string `json:"proto"`
+	DebugConntrackTuple
+	// NetNS is the inode of the network namespace of the connection, when known
+	NetNS uint32 `json:"netns,omitempty"`
+}
+
+// ConnectionStats returns the connection to look the translation of up
+func (c ConntrackLookupConn) ConnectionStats() (ConnectionStats, error) {
+	cs := ConnectionStats{
+		SPort: c.SPort,
+		DPort: c.DPort,
+		NetNS: c.NetNS,
+	}
+
+	switch strings.ToUpper(c.Proto) {
+	case "TCP":
+		cs.Type = TCP
+	case "UDP":
+		cs.Type = UDP
+	default:
+		return cs, fmt.Errorf("invalid protocol %q", c.Proto)
+	}
+
+	src, dst := net.ParseIP(c.Src), net.ParseIP(c.Dst)
+	if src == nil || dst == nil {
+		return cs, fmt.Errorf("invalid address in %s:%d -> %s:%d", c.Src, c.SPort, c.Dst, c.DPort)
+	}
+	cs.Source, cs.Dest = util.AddressFromNetIP(src), util.AddressFromNetIP(dst)
+	if src.To4() == nil {
+		cs.Family = AFINET6
+	}
+	return cs, nil
+}
+
+// ConntrackLookupResult returns the entry reporting the given translation of the given connection, nil when it isn't
+// translated
+func ConntrackLookupResult(c ConntrackLookupConn, t *IPTranslation) *DebugConntrackEntry {
+	if t == nil {
+		return nil
+	}
+
+	var nat string
+	if t.NATType != 0 {
+		nat = t.NATType.String()
+	}
+	return &DebugConntrackEntry{
+		Proto:  strings.ToUpper(c.Proto),
+		Origin: c.DebugConntrackTuple,
+		Reply: DebugConntrackTuple{
+			Src:   t.ReplSrcIP.String(),
+			SPort: t.ReplSrcPort,
+			Dst:   t.ReplDstIP.String(),
+			DPort: t.ReplDstPort,
+		},
+		NATType:     nat,
+		CloudNAT:    t.CloudNAT,
+		ContainerID: t.ContainerID,
+		Service:     t.Service,
+		Pid:         t.Pid,
+	}
+}
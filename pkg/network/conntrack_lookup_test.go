@@ -0,0 +1,57 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConntrackLookupConn(t *testing.T) {
+	c := ConntrackLookupConn{
+		Proto:               "tcp",
+		DebugConntrackTuple: DebugConntrackTuple{Src: "10.0.0.1", SPort: 50000, Dst: "10.96.0.1", DPort: 443},
+	}
+	cs, err := c.ConnectionStats()
+	require.NoError(t, err)
+	assert.Equal(t, ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  50000,
+		Dest:   util.AddressFromString("10.96.0.1"),
+		DPort:  443,
+		Type:   TCP,
+		Family: AFINET,
+	}, cs)
+
+	assert.Nil(t, ConntrackLookupResult(c, nil))
+	assert.Equal(t, &DebugConntrackEntry{
+		Proto:       "TCP",
+		Origin:      c.DebugConntrackTuple,
+		Reply:       DebugConntrackTuple{Src: "10.244.1.5", SPort: 8443, Dst: "10.0.0.1", DPort: 50000},
+		NATType:     "dnat",
+		ContainerID: "3726b9c1e7e3",
+		Service:     "default/web",
+		Pid:         4242,
+	}, ConntrackLookupResult(c, &IPTranslation{
+		ReplSrcIP:   util.AddressFromString("10.244.1.5"),
+		ReplSrcPort: 8443,
+		ReplDstIP:   util.AddressFromString("10.0.0.1"),
+		ReplDstPort: 50000,
+		NATType:     DNAT,
+		ContainerID: "3726b9c1e7e3",
+		Service:     "default/web",
+		Pid:         4242,
+	}))
+
+	c6 := ConntrackLookupConn{Proto: "UDP", DebugConntrackTuple: DebugConntrackTuple{Src: "fd00::1", SPort: 5353, Dst: "fd00::2", DPort: 53}}
+	cs, err = c6.ConnectionStats()
+	require.NoError(t, err)
+	assert.Equal(t, AFINET6, cs.Family)
+	assert.Equal(t, UDP, cs.Type)
+
+	_, err = ConntrackLookupConn{Proto: "SCTP", DebugConntrackTuple: c.DebugConntrackTuple}.ConnectionStats()
+	assert.Error(t, err)
+	_, err = ConntrackLookupConn{Proto: "TCP", DebugConntrackTuple: DebugConntrackTuple{Src: "nope", Dst: "10.0.0.1"}}.ConnectionStats()
+	assert.Error(t, err)
+}
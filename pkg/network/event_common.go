@@ -153,6 +153,46 @@ type IPTranslation struct {
 	ReplDstIP   util.Address
 	ReplSrcPort uint16
 	ReplDstPort uint16
+
+	// Service is the Kubernetes service ("namespace/name") the pre-NAT destination belongs to, when known
+	Service string
+
+	// NATType tells which ends of the connection are translated
+	NATType NATType
+
+	// CloudNAT is set when the reply side of the translation is in one of the configured cloud NAT gateway ranges
+	// (eg. an AWS NAT gateway or GCP Cloud NAT), so the egress path of the connection is known
+	CloudNAT bool
+
+	// ContainerID is the container whose network namespace the connection is in, when known
+	ContainerID string
+
+	// Pid is the process which created the connection, when known
+	Pid uint32
+}
+
+// NATType tells which ends of a connection are translated, relative to the connection the translation is for:
+// the translation of the replies of a source-translated connection is destination-translated.
+type NATType uint8
+
+const (
+	// SNAT is set when the source of the connection is translated
+	SNAT NATType = 1 << iota
+	// DNAT is set when the destination of the connection is translated
+	DNAT
+)
+
+func (t NATType) String() string {
+	switch t {
+	case SNAT:
+		return "snat"
+	case DNAT:
+		return "dnat"
+	case SNAT | DNAT:
+		return "snat+dnat"
+	default:
+		return "none"
+	}
 }
 
 func (c ConnectionStats) String() string {
@@ -243,6 +283,19 @@ func ConnectionSummary(c *ConnectionStats, names map[util.Address][]string) stri
 		)
 	}
 
+	if c.IPTranslation != nil && c.IPTranslation.NATType != 0 {
+		str += fmt.Sprintf(", %s", c.IPTranslation.NATType)
+	}
+	if c.IPTranslation != nil && c.IPTranslation.CloudNAT {
+		str += ", cloud nat"
+	}
+	if c.IPTranslation != nil && c.IPTranslation.ContainerID != "" {
+		str += fmt.Sprintf(", container %s", c.IPTranslation.ContainerID)
+	}
+	if c.IPTranslation != nil && c.IPTranslation.Service != "" {
+		str += fmt.Sprintf(", service %s", c.IPTranslation.Service)
+	}
+
 	return str
 }
 
@@ -0,0 +1,112 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	adaptiveInterval = 30 * time.Second
+
+	// defaultCPUBudget is the fraction of a CPU core the netlink reader is allowed to use
+	// when no budget is configured
+	defaultCPUBudget = 0.05
+
+	// minSamplingRate prevents the sampler from dropping virtually every event under heavy load
+	minSamplingRate = 0.01
+
+	// resampleThreshold is the minimum relative change of the sampling rate required to re-create
+	// the netlink socket. This is to avoid re-creating sockets over small fluctuations.
+	resampleThreshold = 0.1
+)
+
+// AdaptiveSampler computes the sampling rate to apply to the netlink socket from the observed
+// event rate and the CPU time spent reading the socket. Contrary to the CircuitBreaker, which only
+// ever lowers the sampling rate, the AdaptiveSampler raises it back once the load goes down.
+type AdaptiveSampler struct {
+	// The maximum rate of events allowed to pass. math.MaxInt64 means there is no limit.
+	maxEventsPerSec int64
+
+	// The fraction of a CPU core the reader is allowed to use
+	cpuBudget float64
+
+	// The last time the sampling rate was evaluated, along with the CPU time used at that moment
+	lastCheck time.Time
+	lastCPU   time.Duration
+
+	// The CPU usage (as a fraction of a core) measured during the last interval
+	cpuUsage float64
+}
+
+// NewAdaptiveSampler returns an AdaptiveSampler aiming for at most maxEventsPerSec and cpuBudget.
+// A maxEventsPerSec of -1 disables the event rate limit, and a cpuBudget <= 0 uses the default budget.
+func NewAdaptiveSampler(maxEventsPerSec int64, cpuBudget float64) *AdaptiveSampler {
+	if maxEventsPerSec == -1 {
+		maxEventsPerSec = math.MaxInt64
+	}
+	if cpuBudget <= 0 {
+		cpuBudget = defaultCPUBudget
+	}
+
+	return &AdaptiveSampler{
+		maxEventsPerSec: maxEventsPerSec,
+		cpuBudget:       cpuBudget,
+	}
+}
+
+// SetMaxEventsPerSec changes the maximum rate of events the sampler aims for. -1 disables the limit.
+func (s *AdaptiveSampler) SetMaxEventsPerSec(maxEventsPerSec int64) {
+	if maxEventsPerSec == -1 {
+		maxEventsPerSec = math.MaxInt64
+	}
+	atomic.StoreInt64(&s.maxEventsPerSec, maxEventsPerSec)
+}
+
+// Next evaluates the sampling rate once per adaptiveInterval. eventRate is the rate of events
+// received with the given samplingRate, and cpuTime the total CPU time used so far by the reader.
+// The second value returned is false when the current sampling rate should be kept as is.
+func (s *AdaptiveSampler) Next(now time.Time, cpuTime time.Duration, eventRate int64, samplingRate float64) (float64, bool) {
+	if s.lastCheck.IsZero() {
+		s.lastCheck, s.lastCPU = now, cpuTime
+		return samplingRate, false
+	}
+
+	elapsed := now.Sub(s.lastCheck)
+	if elapsed < adaptiveInterval {
+		return samplingRate, false
+	}
+
+	s.cpuUsage = float64(cpuTime-s.lastCPU) / float64(elapsed)
+	s.lastCheck, s.lastCPU = now, cpuTime
+
+	target := 1.0
+
+	// The rate of events we would get without any sampling
+	if kernelRate := float64(eventRate) / samplingRate; kernelRate > 0 {
+		target = math.Min(target, float64(atomic.LoadInt64(&s.maxEventsPerSec))/kernelRate)
+	}
+
+	// The CPU usage is roughly proportional to the amount of events we read
+	if s.cpuUsage > 0 {
+		target = math.Min(target, samplingRate*s.cpuBudget/s.cpuUsage)
+	}
+
+	if target < 1.0 {
+		target = math.Max(target*overshootFactor, minSamplingRate)
+	}
+
+	if math.Abs(target-samplingRate)/samplingRate < resampleThreshold {
+		return samplingRate, false
+	}
+
+	return target, true
+}
+
+// CPUUsage returns the CPU usage (as a fraction of a core) measured during the last interval
+func (s *AdaptiveSampler) CPUUsage() float64 {
+	return s.cpuUsage
+}
@@ -0,0 +1,67 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveSamplerWaitsForInterval(t *testing.T) {
+	sampler := NewAdaptiveSampler(100, 0.05)
+	now := time.Now()
+
+	// the first call only primes the sampler
+	_, ok := sampler.Next(now, 0, 1000, 1.0)
+	assert.False(t, ok)
+
+	_, ok = sampler.Next(now.Add(time.Second), 0, 1000, 1.0)
+	assert.False(t, ok)
+}
+
+func TestAdaptiveSamplerLowersRateOverLimit(t *testing.T) {
+	sampler := NewAdaptiveSampler(100, 0.05)
+	now := time.Now()
+	sampler.Next(now, 0, 0, 1.0)
+
+	// 1000 events/s with no sampling while the limit is 100 events/s
+	rate, ok := sampler.Next(now.Add(adaptiveInterval), 0, 1000, 1.0)
+	assert.True(t, ok)
+	assert.InDelta(t, 0.1*overshootFactor, rate, 0.001)
+}
+
+func TestAdaptiveSamplerLowersRateOverCPUBudget(t *testing.T) {
+	sampler := NewAdaptiveSampler(-1, 0.05)
+	now := time.Now()
+	sampler.Next(now, 0, 0, 1.0)
+
+	// 10% of a core used while the budget is 5%
+	rate, ok := sampler.Next(now.Add(adaptiveInterval), adaptiveInterval/10, 1000, 1.0)
+	assert.True(t, ok)
+	assert.InDelta(t, 0.5*overshootFactor, rate, 0.001)
+	assert.InDelta(t, 0.1, sampler.CPUUsage(), 0.001)
+}
+
+func TestAdaptiveSamplerRaisesRateWhenQuiet(t *testing.T) {
+	sampler := NewAdaptiveSampler(100, 0.05)
+	now := time.Now()
+	sampler.Next(now, 0, 0, 0.1)
+
+	// with a sampling rate of 10% we see 2 events/s, meaning there are ~20 events/s in total
+	rate, ok := sampler.Next(now.Add(adaptiveInterval), 0, 2, 0.1)
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, rate)
+}
+
+func TestAdaptiveSamplerIgnoresSmallChanges(t *testing.T) {
+	sampler := NewAdaptiveSampler(100, 0.05)
+	now := time.Now()
+	sampler.Next(now, 0, 0, 0.5)
+
+	// ~190 events/s in total, which amounts to a target close to the current sampling rate
+	_, ok := sampler.Next(now.Add(adaptiveInterval), 0, 95, 0.5)
+	assert.False(t, ok)
+}
@@ -152,7 +152,7 @@ func (s *AttributeScanner) push() {
 	prev := s.frame()
 
 	// Create a new frame object if necessary
-	if len(s.frames) <= s.level {
+	if len(s.frames) <= s.level+1 {
 		s.frames = append(s.frames, &NestedFrame{})
 	}
 
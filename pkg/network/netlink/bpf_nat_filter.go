@@ -0,0 +1,195 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// nfgenHeaderLen is the size of the netlink header followed by the nfgenmsg header.
+	// Conntrack attributes start right after it.
+	nfgenHeaderLen = 16 + 4
+
+	// scratch memory slots used by the non-NAT filter
+	origTupleSlot  = 0
+	replyTupleSlot = 1
+	origAttrSlot   = 2
+	replyAttrSlot  = 3
+	valueSlot      = 4
+)
+
+// tupleComparison describes an attribute of the origin tuple that must match an attribute
+// of the reply tuple for a conntrack entry to be considered as not NAT'ed
+type tupleComparison struct {
+	nested    uint32
+	origAttr  uint32
+	replyAttr uint32
+	// size of the values compared, and number of values in the attribute payload
+	size  int
+	count int
+}
+
+// natComparisons matches the checks done by isNAT
+var natComparisons = []tupleComparison{
+	{nested: ctaTupleIP, origAttr: ctaIPv4Src, replyAttr: ctaIPv4Dst, size: 4, count: 1},
+	{nested: ctaTupleIP, origAttr: ctaIPv4Dst, replyAttr: ctaIPv4Src, size: 4, count: 1},
+	{nested: ctaTupleIP, origAttr: ctaIPv6Src, replyAttr: ctaIPv6Dst, size: 4, count: 4},
+	{nested: ctaTupleIP, origAttr: ctaIPv6Dst, replyAttr: ctaIPv6Src, size: 4, count: 4},
+	{nested: ctaTupleProto, origAttr: ctaProtoSrcPort, replyAttr: ctaProtoDstPort, size: 2, count: 1},
+	{nested: ctaTupleProto, origAttr: ctaProtoDstPort, replyAttr: ctaProtoSrcPort, size: 2, count: 1},
+}
+
+// GenerateBPFNATFilter returns BPF assembly dropping conntrack events whose origin and reply
+// tuples are symmetric, meaning no address translation happens. Events that are kept are
+// sampled with the given sampling rate. The DESTROY events of UDP connections are kept whether
+// they are translated or not when keepUDPDestroys is set, so they can be used as close hints.
+// The filter only looks at the first message of each packet, so it must only be attached to
+// sockets receiving conntrack events, not table dumps.
+func GenerateBPFNATFilter(samplingRate float64, keepUDPDestroys bool) ([]bpf.RawInstruction, error) {
+	if samplingRate < 0 || samplingRate > 1 {
+		return nil, errInvalidSamplingRate
+	}
+
+	return bpf.Assemble(natFilterInstructions(samplingRate, keepUDPDestroys))
+}
+
+// msgTypeOffset returns the offset of the message type byte of the nlmsg_type field of the netlink header, the
+// other byte being the netfilter subsystem
+func msgTypeOffset() uint32 {
+	if nlenc.NativeEndian() == binary.ByteOrder(binary.BigEndian) {
+		return 5
+	}
+	return 4
+}
+
+func natFilterInstructions(samplingRate float64, keepUDPDestroys bool) []bpf.Instruction {
+	b := &filterBuilder{}
+
+	// Locate the origin and reply tuples. Anything we can't make sense of is handed over to userspace.
+	for _, t := range []struct{ attr, slot uint32 }{{ctaTupleOrig, origTupleSlot}, {ctaTupleReply, replyTupleSlot}} {
+		b.emit(
+			bpf.LoadConstant{Dst: bpf.RegA, Val: nfgenHeaderLen},
+			bpf.LoadConstant{Dst: bpf.RegX, Val: t.attr},
+			bpf.LoadExtension{Num: bpf.ExtNetlinkAttr},
+		)
+		b.acceptIf(bpf.JumpEqual, 0)
+		b.emit(bpf.StoreScratch{Src: bpf.RegA, N: int(t.slot)})
+	}
+
+	if keepUDPDestroys {
+		b.emit(bpf.LoadAbsolute{Off: msgTypeOffset(), Size: 1})
+		notDestroy := b.jumpIfNot(bpf.JumpEqual, ipctnlMsgCtDelete)
+		b.locate(origTupleSlot, ctaTupleProto, ctaProtoNum)
+		noProto := b.jumpIfZero()
+		b.emit(
+			bpf.TAX{},
+			bpf.LoadIndirect{Off: 4, Size: 1},
+		)
+		b.acceptIf(bpf.JumpEqual, unix.IPPROTO_UDP)
+		b.land(notDestroy, noProto)
+	}
+
+	for _, cmp := range natComparisons {
+		// attributes missing from the origin tuple (eg. IPv6 addresses of an IPv4 entry) are not compared
+		b.locate(origTupleSlot, cmp.nested, cmp.origAttr)
+		skip := b.jumpIfZero()
+		b.emit(bpf.StoreScratch{Src: bpf.RegA, N: origAttrSlot})
+
+		b.locate(replyTupleSlot, cmp.nested, cmp.replyAttr)
+		b.acceptIf(bpf.JumpEqual, 0)
+		b.emit(bpf.StoreScratch{Src: bpf.RegA, N: replyAttrSlot})
+
+		for i := 0; i < cmp.count; i++ {
+			// attribute payloads start right after the 4 bytes attribute header
+			off := uint32(4 + i*cmp.size)
+			b.emit(
+				bpf.LoadScratch{Dst: bpf.RegX, N: origAttrSlot},
+				bpf.LoadIndirect{Off: off, Size: cmp.size},
+				bpf.StoreScratch{Src: bpf.RegA, N: valueSlot},
+				bpf.LoadScratch{Dst: bpf.RegX, N: replyAttrSlot},
+				bpf.LoadIndirect{Off: off, Size: cmp.size},
+				bpf.LoadScratch{Dst: bpf.RegX, N: valueSlot},
+			)
+			b.acceptIfX(bpf.JumpNotEqual)
+		}
+
+		b.land(skip)
+	}
+
+	// Both tuples are symmetric, drop the message
+	b.emit(bpf.RetConstant{Val: 0})
+
+	b.land(b.accepts...)
+	if samplingRate >= 1.0 {
+		b.emit(bpf.RetConstant{Val: bpfCaptureLen})
+	} else {
+		b.emit(samplerInstructions(samplingRate)...)
+	}
+
+	return b.insns
+}
+
+// filterBuilder assembles a BPF program with forward jumps whose targets are resolved once known
+type filterBuilder struct {
+	insns   []bpf.Instruction
+	accepts []int
+}
+
+func (b *filterBuilder) emit(insns ...bpf.Instruction) {
+	b.insns = append(b.insns, insns...)
+}
+
+// locate loads into A the offset of the attribute nested into the given tuple, or 0 when it doesn't exist
+func (b *filterBuilder) locate(tupleSlot int, nested, attr uint32) {
+	b.emit(
+		bpf.LoadScratch{Dst: bpf.RegA, N: tupleSlot},
+		bpf.LoadConstant{Dst: bpf.RegX, Val: nested},
+		bpf.LoadExtension{Num: bpf.ExtNetlinkAttrNested},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0, SkipTrue: 2},
+		bpf.LoadConstant{Dst: bpf.RegX, Val: attr},
+		bpf.LoadExtension{Num: bpf.ExtNetlinkAttrNested},
+	)
+}
+
+// jumpIfZero emits a jump taken when A is 0, and returns it so its target can be set with land
+func (b *filterBuilder) jumpIfZero() int {
+	return b.jumpIfNot(bpf.JumpNotEqual, 0)
+}
+
+// jumpIfNot emits a jump taken when A doesn't pass the given test, and returns it so its target can be set with land
+func (b *filterBuilder) jumpIfNot(cond bpf.JumpTest, val uint32) int {
+	b.emit(
+		bpf.JumpIf{Cond: cond, Val: val, SkipTrue: 1},
+		bpf.Jump{},
+	)
+	return len(b.insns) - 1
+}
+
+func (b *filterBuilder) acceptIf(cond bpf.JumpTest, val uint32) {
+	b.emit(
+		bpf.JumpIf{Cond: cond, Val: val, SkipFalse: 1},
+		bpf.Jump{},
+	)
+	b.accepts = append(b.accepts, len(b.insns)-1)
+}
+
+func (b *filterBuilder) acceptIfX(cond bpf.JumpTest) {
+	b.emit(
+		bpf.JumpIfX{Cond: cond, SkipFalse: 1},
+		bpf.Jump{},
+	)
+	b.accepts = append(b.accepts, len(b.insns)-1)
+}
+
+// land points the given jumps to the next instruction emitted
+func (b *filterBuilder) land(jumps ...int) {
+	for _, i := range jumps {
+		b.insns[i] = bpf.Jump{Skip: uint32(len(b.insns) - i - 1)}
+	}
+}
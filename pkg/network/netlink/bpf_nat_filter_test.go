@@ -0,0 +1,203 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// orig_src=10.0.2.15:58472 orig_dst=2.2.2.2:5432 reply_src=1.1.1.1:5432 reply_dst=10.0.2.15:58472 proto=tcp(6)
+var natEventPayload = []byte{0x2, 0x0, 0x0, 0x0, 0x34, 0x0, 0x1, 0x80, 0x14, 0x0, 0x1, 0x80, 0x8, 0x0, 0x1, 0x0, 0xa, 0x0, 0x2, 0xf, 0x8, 0x0, 0x2, 0x0, 0x2, 0x2, 0x2, 0x2, 0x1c, 0x0, 0x2, 0x80, 0x5, 0x0, 0x1, 0x0, 0x6, 0x0, 0x0, 0x0, 0x6, 0x0, 0x2, 0x0, 0xe4, 0x68, 0x0, 0x0, 0x6, 0x0, 0x3, 0x0, 0x15, 0x38, 0x0, 0x0, 0x34, 0x0, 0x2, 0x80, 0x14, 0x0, 0x1, 0x80, 0x8, 0x0, 0x1, 0x0, 0x1, 0x1, 0x1, 0x1, 0x8, 0x0, 0x2, 0x0, 0xa, 0x0, 0x2, 0xf, 0x1c, 0x0, 0x2, 0x80, 0x5, 0x0, 0x1, 0x0, 0x6, 0x0, 0x0, 0x0, 0x6, 0x0, 0x2, 0x0, 0x15, 0x38, 0x0, 0x0, 0x6, 0x0, 0x3, 0x0, 0xe4, 0x68, 0x0, 0x0, 0x8, 0x0, 0xc, 0x0, 0x3e, 0x63, 0x25, 0x71}
+
+// replySrcOffset is the offset of the reply source address in natEventPayload, and protoNumOffset the one of the
+// protocol number of its origin tuple
+const (
+	replySrcOffset = 68
+	protoNumOffset = 36
+)
+
+func TestNATFilterAssembles(t *testing.T) {
+	_, err := GenerateBPFNATFilter(1.0, false)
+	assert.NoError(t, err)
+	_, err = GenerateBPFNATFilter(0.5, true)
+	assert.NoError(t, err)
+	_, err = GenerateBPFNATFilter(2, false)
+	assert.Equal(t, errInvalidSamplingRate, err)
+}
+
+func TestNATFilterKeepsNATEvents(t *testing.T) {
+	insns := natFilterInstructions(1.0, false)
+	assert.Equal(t, uint32(bpfCaptureLen), runFilter(t, insns, netlinkPacket(natEventPayload)))
+}
+
+func TestNATFilterDropsNonNATEvents(t *testing.T) {
+	payload := append([]byte{}, natEventPayload...)
+	// make the reply source the same as the origin destination (2.2.2.2)
+	copy(payload[replySrcOffset:], []byte{0x2, 0x2, 0x2, 0x2})
+
+	insns := natFilterInstructions(1.0, false)
+	assert.Equal(t, uint32(0), runFilter(t, insns, netlinkPacket(payload)))
+}
+
+func TestNATFilterKeepsUnknownMessages(t *testing.T) {
+	insns := natFilterInstructions(1.0, false)
+	assert.Equal(t, uint32(bpfCaptureLen), runFilter(t, insns, netlinkPacket([]byte{0x2, 0x0, 0x0, 0x0})))
+}
+
+func TestNATFilterKeepsUDPDestroys(t *testing.T) {
+	payload := append([]byte{}, natEventPayload...)
+	copy(payload[replySrcOffset:], []byte{0x2, 0x2, 0x2, 0x2})
+	udp := append([]byte{}, payload...)
+	udp[protoNumOffset] = 17
+
+	destroy := func(payload []byte) []byte {
+		pkt := netlinkPacket(payload)
+		binary.LittleEndian.PutUint16(pkt[4:], unix.NFNL_SUBSYS_CTNETLINK<<8|ipctnlMsgCtDelete)
+		return pkt
+	}
+
+	// the non-NAT UDP destroy events are only kept when asked to
+	assert.Equal(t, uint32(0), runFilter(t, natFilterInstructions(1.0, false), destroy(udp)))
+	insns := natFilterInstructions(1.0, true)
+	assert.Equal(t, uint32(bpfCaptureLen), runFilter(t, insns, destroy(udp)))
+	// but not the ones of other protocols, nor the other UDP events
+	assert.Equal(t, uint32(0), runFilter(t, insns, destroy(payload)))
+	assert.Equal(t, uint32(0), runFilter(t, insns, netlinkPacket(udp)))
+	// the NAT events are still kept
+	assert.Equal(t, uint32(bpfCaptureLen), runFilter(t, insns, netlinkPacket(natEventPayload)))
+}
+
+func netlinkPacket(payload []byte) []byte {
+	pkt := make([]byte, 16, 16+len(payload))
+	binary.LittleEndian.PutUint32(pkt, uint32(16+len(payload)))
+	return append(pkt, payload...)
+}
+
+// runFilter interprets the subset of classic BPF used by the non-NAT filter, including the
+// netlink attribute extensions which aren't supported by the bpf package VM
+func runFilter(t *testing.T, insns []bpf.Instruction, pkt []byte) uint32 {
+	var a, x uint32
+	var scratch [16]uint32
+	var ok bool
+
+	for pc := 0; pc < len(insns); pc++ {
+		switch ins := insns[pc].(type) {
+		case bpf.LoadConstant:
+			if ins.Dst == bpf.RegA {
+				a = ins.Val
+			} else {
+				x = ins.Val
+			}
+		case bpf.LoadScratch:
+			if ins.Dst == bpf.RegA {
+				a = scratch[ins.N]
+			} else {
+				x = scratch[ins.N]
+			}
+		case bpf.StoreScratch:
+			if ins.Src == bpf.RegA {
+				scratch[ins.N] = a
+			} else {
+				scratch[ins.N] = x
+			}
+		case bpf.LoadIndirect:
+			if a, ok = load(pkt, int(x)+int(ins.Off), ins.Size); !ok {
+				return 0
+			}
+		case bpf.LoadAbsolute:
+			if a, ok = load(pkt, int(ins.Off), ins.Size); !ok {
+				return 0
+			}
+		case bpf.TAX:
+			x = a
+		case bpf.LoadExtension:
+			switch ins.Num {
+			case bpf.ExtNetlinkAttr:
+				a = findAttr(pkt, int(a), len(pkt), x)
+			case bpf.ExtNetlinkAttrNested:
+				if int(a)+4 > len(pkt) {
+					a = 0
+					break
+				}
+				nlaLen := int(binary.LittleEndian.Uint16(pkt[a:]))
+				if int(a)+nlaLen > len(pkt) {
+					a = 0
+					break
+				}
+				a = findAttr(pkt, int(a)+4, int(a)+nlaLen, x)
+			default:
+				require.Failf(t, "unsupported extension", "%v", ins.Num)
+			}
+		case bpf.JumpIf:
+			if jumpTest(ins.Cond, a, ins.Val) {
+				pc += int(ins.SkipTrue)
+			} else {
+				pc += int(ins.SkipFalse)
+			}
+		case bpf.JumpIfX:
+			if jumpTest(ins.Cond, a, x) {
+				pc += int(ins.SkipTrue)
+			} else {
+				pc += int(ins.SkipFalse)
+			}
+		case bpf.Jump:
+			pc += int(ins.Skip)
+		case bpf.RetConstant:
+			return ins.Val
+		default:
+			require.Failf(t, "unsupported instruction", "%#v", ins)
+		}
+	}
+
+	require.Fail(t, "filter did not return")
+	return 0
+}
+
+// load reads a value of the given size at the given offset of the packet, in network byte order
+func load(pkt []byte, off, size int) (uint32, bool) {
+	if off+size > len(pkt) {
+		return 0, false
+	}
+	switch size {
+	case 1:
+		return uint32(pkt[off]), true
+	case 2:
+		return uint32(binary.BigEndian.Uint16(pkt[off:])), true
+	default:
+		return binary.BigEndian.Uint32(pkt[off:]), true
+	}
+}
+
+func jumpTest(cond bpf.JumpTest, a, v uint32) bool {
+	switch cond {
+	case bpf.JumpEqual:
+		return a == v
+	case bpf.JumpNotEqual:
+		return a != v
+	case bpf.JumpLessThan:
+		return a < v
+	}
+	panic("unsupported jump test")
+}
+
+// findAttr mimics nla_find, returning the offset of the attribute of the given type found within [start, end)
+func findAttr(pkt []byte, start, end int, attrType uint32) uint32 {
+	for off := start; off+4 <= end; {
+		nlaLen := int(binary.LittleEndian.Uint16(pkt[off:]))
+		if nlaLen < 4 || off+nlaLen > end {
+			return 0
+		}
+		if uint32(binary.LittleEndian.Uint16(pkt[off+2:])&0x3fff) == attrType {
+			return uint32(off)
+		}
+		off += (nlaLen + 3) &^ 3
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"fmt"
+	"net"
+)
+
+// cidrFilter restricts the conntrack entries stored to the ones involving addresses of the configured networks
+type cidrFilter struct {
+	include []*net.IPNet
+	exclude []*net.IPNet
+}
+
+// newCIDRFilter returns a filter keeping the entries with an address in one of the include CIDRs (or all of them if
+// there is none), unless one of their addresses is in one of the exclude CIDRs. nil is returned when there is nothing
+// to filter.
+func newCIDRFilter(include, exclude []string) (*cidrFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	f := &cidrFilter{}
+	var err error
+	if f.include, err = parseCIDRs(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = parseCIDRs(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// matches returns whether the conntrack entry should be stored. Both the origin and the reply tuples are looked at.
+func (f *cidrFilter) matches(c Con) bool {
+	if f == nil {
+		return true
+	}
+
+	addrs := [...]net.IP{c.Origin.src[:], c.Origin.dst[:], c.Reply.src[:], c.Reply.dst[:]}
+	for _, addr := range addrs {
+		if containsAddr(f.exclude, addr) {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, addr := range addrs {
+		if containsAddr(f.include, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAddr(nets []*net.IPNet, addr net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
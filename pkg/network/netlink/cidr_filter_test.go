@@ -0,0 +1,56 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCIDRFilter(t *testing.T) {
+	f, err := newCIDRFilter(nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, f)
+	assert.True(t, f.matches(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("1.1.1.1"), net.ParseIP("2.2.2.2"), 6, 12345, 80, 80)))
+
+	_, err = newCIDRFilter([]string{"10.0.0.0/33"}, nil)
+	assert.Error(t, err)
+
+	f, err = newCIDRFilter([]string{"10.244.0.0/16", "10.96.0.0/12"}, []string{"10.244.3.0/24"})
+	require.NoError(t, err)
+
+	// origin in the pod CIDR
+	assert.True(t, f.matches(makeTranslatedConn(net.ParseIP("10.244.1.2"), net.ParseIP("1.1.1.1"), net.ParseIP("2.2.2.2"), 6, 12345, 80, 80)))
+	// reply from the pod CIDR
+	assert.True(t, f.matches(makeTranslatedConn(net.ParseIP("192.168.0.1"), net.ParseIP("10.244.1.2"), net.ParseIP("2.2.2.2"), 6, 12345, 80, 80)))
+	// destination in the service CIDR
+	assert.True(t, f.matches(makeTranslatedConn(net.ParseIP("192.168.0.1"), net.ParseIP("1.1.1.1"), net.ParseIP("10.96.0.10"), 17, 12345, 53, 53)))
+	// nothing in the included networks
+	assert.False(t, f.matches(makeTranslatedConn(net.ParseIP("192.168.0.1"), net.ParseIP("1.1.1.1"), net.ParseIP("2.2.2.2"), 6, 12345, 80, 80)))
+	// excluded
+	assert.False(t, f.matches(makeTranslatedConn(net.ParseIP("10.244.3.2"), net.ParseIP("1.1.1.1"), net.ParseIP("2.2.2.2"), 6, 12345, 80, 80)))
+
+	// exclusions only
+	f, err = newCIDRFilter(nil, []string{"fd00::/8"})
+	require.NoError(t, err)
+	assert.True(t, f.matches(makeTranslatedConn(net.ParseIP("192.168.0.1"), net.ParseIP("1.1.1.1"), net.ParseIP("2.2.2.2"), 6, 12345, 80, 80)))
+	assert.False(t, f.matches(makeTranslatedConn(net.ParseIP("fd00::1"), net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 6, 12345, 80, 80)))
+}
+
+func TestRegisterFilteredByCIDRs(t *testing.T) {
+	rt := newConntracker()
+	f, err := newCIDRFilter([]string{"10.244.0.0/16"}, nil)
+	require.NoError(t, err)
+	rt.cidrFilter = f
+
+	rt.register(makeTranslatedConn(net.ParseIP("192.168.0.2"), net.ParseIP("172.16.0.1"), net.ParseIP("172.16.0.2"), 6, 12345, 80, 80))
+	assert.Equal(t, 0, rt.state.len())
+	assert.EqualValues(t, 1, rt.stats.registersFiltered)
+
+	rt.register(makeTranslatedConn(net.ParseIP("10.244.1.2"), net.ParseIP("1.1.1.1"), net.ParseIP("8.8.8.8"), 6, 12345, 53, 53))
+	assert.Equal(t, 1, rt.state.len())
+}
@@ -0,0 +1,372 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/ebpf"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// ciliumMapsDir is where Cilium pins the maps of its datapath
+const ciliumMapsDir = "/sys/fs/bpf/tc/globals"
+
+// ciliumPollInterval is the interval at which the NAT maps of Cilium are read
+const ciliumPollInterval = 10 * time.Second
+
+// layout of the entries of the Cilium NAT maps, keyed by a struct ipv{4,6}_ct_tuple and holding a
+// struct ipv{4,6}_nat_entry:
+//
+// struct ipv4_ct_tuple { __be32 daddr; __be32 saddr; __be16 dport; __be16 sport; __u8 nexthdr; __u8 flags; }
+// struct ipv4_nat_entry { struct nat_entry common; __be32 to_addr; __be16 to_port; }
+const (
+	// ciliumNATEntryHeaderLen is the size of struct nat_entry, which precedes the address the tuple is NATed to
+	ciliumNATEntryHeaderLen = 32
+	// ciliumTupleFlagIn flags the tuples of the reverse NAT, mapping the replies back to the original source
+	ciliumTupleFlagIn = 1
+)
+
+// ciliumNATMap is a NAT map of Cilium, along with the size of the addresses it holds
+type ciliumNATMap struct {
+	name    string
+	addrLen int
+}
+
+var ciliumNATMaps = []ciliumNATMap{
+	{name: "cilium_snat_v4_external", addrLen: net.IPv4len},
+	{name: "cilium_snat_v6_external", addrLen: net.IPv6len},
+}
+
+// ciliumConntracker resolves the translations done by Cilium when it replaces kube-proxy, or masquerades the
+// traffic of the pods, in eBPF: its NAT bypasses conntrack, so the NAT maps Cilium pins are polled and used when the
+// wrapped Conntracker doesn't know about a connection.
+// The service translations done by the socket load-balancer of Cilium aren't NAT: the sockets are connected to the
+// backends directly, so the connections are already seen with their backend as destination.
+type ciliumConntracker struct {
+	Conntracker
+	sync.RWMutex
+
+	state map[connKey]*network.IPTranslation
+
+	// read reads the NAT maps, calling fn with the origin and reply tuples of each translated connection
+	read         func(fn func(origin, reply connKey)) error
+	maxStateSize int
+
+	pollTicker *time.Ticker
+	exit       chan struct{}
+
+	stats struct {
+		hits          int64
+		polls         int64
+		pollErrors    int64
+		pollTimeTotal int64
+		connsDropped  int64
+	}
+}
+
+// NewCiliumConntracker returns a Conntracker resolving the translations of the NAT maps of Cilium, falling back to
+// the given Conntracker for the connections Cilium doesn't translate
+func NewCiliumConntracker(ctr Conntracker, cfg *Config) (Conntracker, error) {
+	cilium, err := newCiliumConntracker(ctr, cfg, func(fn func(origin, reply connKey)) error {
+		return readCiliumNATMaps(ciliumMapsDir, fn)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("initialized Cilium conntracker")
+	return cilium, nil
+}
+
+func newCiliumConntracker(ctr Conntracker, cfg *Config, read func(fn func(origin, reply connKey)) error) (*ciliumConntracker, error) {
+	cilium := &ciliumConntracker{
+		Conntracker:  ctr,
+		state:        make(map[connKey]*network.IPTranslation),
+		read:         read,
+		maxStateSize: cfg.MaxStateSize,
+		exit:         make(chan struct{}),
+	}
+
+	if err := cilium.poll(); err != nil {
+		return nil, fmt.Errorf("could not read the Cilium NAT maps, is Cilium running with its eBPF NAT? %w", err)
+	}
+
+	cilium.pollTicker = time.NewTicker(ciliumPollInterval)
+	go cilium.run()
+	return cilium, nil
+}
+
+func (cilium *ciliumConntracker) GetTranslationForConn(c network.ConnectionStats) *network.IPTranslation {
+	if t := cilium.Conntracker.GetTranslationForConn(c); t != nil {
+		return t
+	}
+
+	cilium.RLock()
+	defer cilium.RUnlock()
+
+	t := cilium.state[connStatsToConnKey(c)]
+	if t != nil {
+		atomic.AddInt64(&cilium.stats.hits, 1)
+	}
+	return t
+}
+
+// GetTranslationsForConns returns the translations of the given connections, using the Cilium
+// translations for the connections the wrapped Conntracker doesn't know about
+func (cilium *ciliumConntracker) GetTranslationsForConns(conns []network.ConnectionStats) []*network.IPTranslation {
+	translations := cilium.Conntracker.GetTranslationsForConns(conns)
+
+	cilium.RLock()
+	defer cilium.RUnlock()
+
+	for i := range conns {
+		if translations[i] != nil {
+			continue
+		}
+		if t := cilium.state[connStatsToConnKey(conns[i])]; t != nil {
+			translations[i] = t
+			atomic.AddInt64(&cilium.stats.hits, 1)
+		}
+	}
+	return translations
+}
+
+// GetEntryForConn returns the entry of the wrapped Conntracker, or else the entry of the Cilium translation
+func (cilium *ciliumConntracker) GetEntryForConn(c network.ConnectionStats) *ConntrackEntry {
+	if e := cilium.Conntracker.GetEntryForConn(c); e != nil {
+		return e
+	}
+
+	cilium.RLock()
+	defer cilium.RUnlock()
+
+	t := cilium.state[connStatsToConnKey(c)]
+	if t == nil {
+		return nil
+	}
+	atomic.AddInt64(&cilium.stats.hits, 1)
+	return newConntrackEntry(c, t)
+}
+
+// DeleteTranslation removes the translation of a closed connection from both caches. Cilium garbage collects
+// its NAT entries on its own schedule, so the connection would otherwise be resolved until the next poll.
+func (cilium *ciliumConntracker) DeleteTranslation(c network.ConnectionStats) {
+	cilium.Conntracker.DeleteTranslation(c)
+
+	cilium.Lock()
+	defer cilium.Unlock()
+	cilium.deleteTranslation(c)
+}
+
+// DeleteTranslations removes the translations of the given connections from both caches
+func (cilium *ciliumConntracker) DeleteTranslations(conns []network.ConnectionStats) {
+	cilium.Conntracker.DeleteTranslations(conns)
+
+	cilium.Lock()
+	defer cilium.Unlock()
+	for i := range conns {
+		cilium.deleteTranslation(conns[i])
+	}
+}
+
+// deleteTranslation must be called with the write lock held
+func (cilium *ciliumConntracker) deleteTranslation(c network.ConnectionStats) {
+	k := connStatsToConnKey(c)
+	for _, key := range []connKey{k, {srcIP: k.dstIP, srcPort: k.dstPort, dstIP: k.srcIP, dstPort: k.srcPort, transport: k.transport}} {
+		if t, ok := cilium.state[key]; ok {
+			delete(cilium.state, ipTranslationToConnKey(key.transport, t))
+			delete(cilium.state, key)
+			return
+		}
+	}
+}
+
+func (cilium *ciliumConntracker) Describe() network.ConntrackDescription {
+	return withLayer(cilium.Conntracker.Describe(), "cilium")
+}
+
+func (cilium *ciliumConntracker) GetStats() Stats {
+	cilium.RLock()
+	size := len(cilium.state)
+	cilium.RUnlock()
+
+	s := cilium.Conntracker.GetStats()
+	polls := atomic.LoadInt64(&cilium.stats.polls)
+	s.set("cilium_state_size", int64(size))
+	s.set("cilium_hits_total", atomic.LoadInt64(&cilium.stats.hits))
+	s.set("cilium_polls_total", polls)
+	s.set("cilium_poll_errors_total", atomic.LoadInt64(&cilium.stats.pollErrors))
+	s.set("cilium_conns_dropped", atomic.LoadInt64(&cilium.stats.connsDropped))
+	if polls != 0 {
+		s.set("cilium_nanoseconds_per_poll", atomic.LoadInt64(&cilium.stats.pollTimeTotal)/polls)
+	}
+	return s
+}
+
+// DumpCachedTable returns the translations held by the wrapped Conntracker, followed by the Cilium ones
+func (cilium *ciliumConntracker) DumpCachedTable() []network.DebugConntrackEntry {
+	entries := cilium.Conntracker.DumpCachedTable()
+
+	cilium.RLock()
+	defer cilium.RUnlock()
+	for k, t := range cilium.state {
+		entries = append(entries, debugConntrackEntry(k, t))
+	}
+	return entries
+}
+
+// Refresh refreshes the wrapped Conntracker, along with the Cilium translations of all families
+func (cilium *ciliumConntracker) Refresh(family uint8) error {
+	if err := cilium.Conntracker.Refresh(family); err != nil {
+		return err
+	}
+	return cilium.poll()
+}
+
+func (cilium *ciliumConntracker) Close() {
+	cilium.pollTicker.Stop()
+	close(cilium.exit)
+	cilium.Conntracker.Close()
+}
+
+func (cilium *ciliumConntracker) run() {
+	for {
+		select {
+		case <-cilium.pollTicker.C:
+			if err := cilium.poll(); err != nil {
+				log.Warnf("could not read the Cilium NAT maps: %s", err)
+			}
+		case <-cilium.exit:
+			return
+		}
+	}
+}
+
+// poll reads the NAT maps and replaces the cache with their content
+func (cilium *ciliumConntracker) poll() error {
+	then := time.Now()
+	defer func() {
+		atomic.AddInt64(&cilium.stats.polls, 1)
+		atomic.AddInt64(&cilium.stats.pollTimeTotal, time.Since(then).Nanoseconds())
+	}()
+
+	state := make(map[connKey]*network.IPTranslation)
+	err := cilium.read(func(origin, reply connKey) {
+		if len(state) >= cilium.maxStateSize {
+			atomic.AddInt64(&cilium.stats.connsDropped, 1)
+			return
+		}
+
+		state[origin] = replyTranslation(origin, reply)
+		state[reply] = replyTranslation(reply, origin)
+	})
+	if err != nil {
+		atomic.AddInt64(&cilium.stats.pollErrors, 1)
+		return err
+	}
+
+	cilium.Lock()
+	cilium.state = state
+	cilium.Unlock()
+	return nil
+}
+
+// readCiliumNATMaps reads the NAT maps pinned in the given directory, skipping the ones of the families Cilium
+// doesn't handle, and calls fn with the origin and reply tuples of each translated connection
+func readCiliumNATMaps(dir string, fn func(origin, reply connKey)) error {
+	found := false
+	for _, natMap := range ciliumNATMaps {
+		path := filepath.Join(dir, natMap.name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		found = true
+
+		if err := readCiliumNATMap(path, natMap.addrLen, fn); err != nil {
+			return fmt.Errorf("could not read %s: %w", path, err)
+		}
+	}
+	if !found {
+		return fmt.Errorf("no Cilium NAT map found in %s", dir)
+	}
+	return nil
+}
+
+func readCiliumNATMap(path string, addrLen int, fn func(origin, reply connKey)) error {
+	m, err := ebpf.LoadPinnedMap(path)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	var key, value []byte
+	it := m.Iterate()
+	for it.Next(&key, &value) {
+		if origin, reply, ok := parseCiliumNATEntry(key, value, addrLen); ok {
+			fn(origin, reply)
+		}
+	}
+	return it.Err()
+}
+
+// parseCiliumNATEntry returns the origin and reply tuples of the connection of an entry of a NAT map, the same way
+// they are reported by conntrack. Only the entries of the source NAT are parsed, since the entries of the reverse
+// NAT describe the same connections.
+func parseCiliumNATEntry(key, value []byte, addrLen int) (origin, reply connKey, ok bool) {
+	// the addresses and ports of the tuple, then its protocol and flags
+	if len(key) < 2*addrLen+6 || len(value) < ciliumNATEntryHeaderLen+addrLen+2 {
+		return origin, reply, false
+	}
+
+	ports := key[2*addrLen:]
+	nexthdr, flags := ports[4], ports[5]
+	if flags&ciliumTupleFlagIn != 0 {
+		return origin, reply, false
+	}
+
+	var transport network.ConnectionType
+	switch nexthdr {
+	case 6:
+		transport = network.TCP
+	case 17:
+		transport = network.UDP
+	default:
+		return origin, reply, false
+	}
+
+	to := value[ciliumNATEntryHeaderLen:]
+	origin = connKey{
+		srcIP:     keyAddrFromNetIP(key[addrLen : 2*addrLen]),
+		srcPort:   binary.BigEndian.Uint16(ports[2:4]),
+		dstIP:     keyAddrFromNetIP(key[:addrLen]),
+		dstPort:   binary.BigEndian.Uint16(ports[0:2]),
+		transport: transport,
+	}
+	reply = connKey{
+		srcIP:     origin.dstIP,
+		srcPort:   origin.dstPort,
+		dstIP:     keyAddrFromNetIP(to[:addrLen]),
+		dstPort:   binary.BigEndian.Uint16(to[addrLen : addrLen+2]),
+		transport: transport,
+	}
+	return origin, reply, true
+}
+
+func ciliumAddress(b []byte) util.Address {
+	if len(b) == net.IPv4len {
+		return util.V4AddressFromBytes(b)
+	}
+	return util.V6AddressFromBytes(b)
+}
@@ -0,0 +1,118 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ciliumNATEntry encodes an entry of a Cilium NAT map
+func ciliumNATEntry(saddr, daddr string, sport, dport uint16, nexthdr, flags uint8, toAddr string, toPort uint16) (key, value []byte) {
+	ip := func(s string) []byte {
+		if v4 := net.ParseIP(s).To4(); v4 != nil {
+			return v4
+		}
+		return net.ParseIP(s)
+	}
+
+	key = append(key, ip(daddr)...)
+	key = append(key, ip(saddr)...)
+	key = append(key, 0, 0, 0, 0, nexthdr, flags)
+	binary.BigEndian.PutUint16(key[len(key)-6:], dport)
+	binary.BigEndian.PutUint16(key[len(key)-4:], sport)
+
+	value = make([]byte, ciliumNATEntryHeaderLen)
+	value = append(value, ip(toAddr)...)
+	value = append(value, 0, 0)
+	binary.BigEndian.PutUint16(value[len(value)-2:], toPort)
+	return key, value
+}
+
+func TestParseCiliumNATEntry(t *testing.T) {
+	// 10.0.1.5:40000 -> 1.1.1.1:443 masqueraded to 192.168.0.10:61000
+	key, value := ciliumNATEntry("10.0.1.5", "1.1.1.1", 40000, 443, 6, 0, "192.168.0.10", 61000)
+	origin, reply, ok := parseCiliumNATEntry(key, value, net.IPv4len)
+	require.True(t, ok)
+	assert.Equal(t, connKey{srcIP: keyAddrFromString("10.0.1.5"), srcPort: 40000, dstIP: keyAddrFromString("1.1.1.1"), dstPort: 443, transport: network.TCP}, origin)
+	assert.Equal(t, connKey{srcIP: keyAddrFromString("1.1.1.1"), srcPort: 443, dstIP: keyAddrFromString("192.168.0.10"), dstPort: 61000, transport: network.TCP}, reply)
+
+	// the reverse NAT entry of the same connection is skipped
+	key, value = ciliumNATEntry("1.1.1.1", "192.168.0.10", 443, 61000, 6, ciliumTupleFlagIn, "10.0.1.5", 40000)
+	_, _, ok = parseCiliumNATEntry(key, value, net.IPv4len)
+	assert.False(t, ok)
+
+	// ICMP isn't tracked
+	key, value = ciliumNATEntry("10.0.1.5", "1.1.1.1", 1, 0, 1, 0, "192.168.0.10", 1)
+	_, _, ok = parseCiliumNATEntry(key, value, net.IPv4len)
+	assert.False(t, ok)
+
+	// truncated entries are skipped
+	_, _, ok = parseCiliumNATEntry(key[:10], value, net.IPv4len)
+	assert.False(t, ok)
+
+	key, value = ciliumNATEntry("fd00::1:5", "2001:db8::1", 40000, 53, 17, 0, "fd00::10", 61000)
+	origin, reply, ok = parseCiliumNATEntry(key, value, net.IPv6len)
+	require.True(t, ok)
+	assert.Equal(t, connKey{srcIP: keyAddrFromString("fd00::1:5"), srcPort: 40000, dstIP: keyAddrFromString("2001:db8::1"), dstPort: 53, transport: network.UDP}, origin)
+	assert.Equal(t, keyAddrFromString("fd00::10"), reply.dstIP)
+}
+
+func TestCiliumConntracker(t *testing.T) {
+	entries := [][2][]byte{}
+	key, value := ciliumNATEntry("10.0.1.5", "1.1.1.1", 40000, 443, 6, 0, "192.168.0.10", 61000)
+	entries = append(entries, [2][]byte{key, value})
+
+	read := func(fn func(origin, reply connKey)) error {
+		for _, e := range entries {
+			if origin, reply, ok := parseCiliumNATEntry(e[0], e[1], net.IPv4len); ok {
+				fn(origin, reply)
+			}
+		}
+		return nil
+	}
+	ctr, err := newCiliumConntracker(NewNoOpConntracker(), &Config{MaxStateSize: 100}, read)
+	require.NoError(t, err)
+	defer ctr.Close()
+
+	c := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.1.5"),
+		SPort:  40000,
+		Dest:   util.AddressFromString("1.1.1.1"),
+		DPort:  443,
+		Type:   network.TCP,
+	}
+	trans := ctr.GetTranslationForConn(c)
+	require.NotNil(t, trans)
+	assert.Equal(t, util.AddressFromString("192.168.0.10"), trans.ReplDstIP)
+	assert.Equal(t, uint16(61000), trans.ReplDstPort)
+	assert.Equal(t, network.SNAT, trans.NATType)
+
+	translations := ctr.GetTranslationsForConns([]network.ConnectionStats{c})
+	assert.Equal(t, trans, translations[0])
+	assert.Len(t, ctr.DumpCachedTable(), 2)
+
+	ctr.DeleteTranslation(c)
+	assert.Nil(t, ctr.GetTranslationForConn(c))
+	assert.Empty(t, ctr.DumpCachedTable())
+
+	// the translation is back once the map is polled again
+	require.NoError(t, ctr.Refresh(0))
+	assert.NotNil(t, ctr.GetTranslationForConn(c))
+	assert.Equal(t, int64(2), ctr.GetStats().Extra["cilium_polls_total"])
+
+	// the maps aren't pinned outside of a Cilium node
+	dir, err := ioutil.TempDir("", "bpffs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.Error(t, readCiliumNATMaps(dir, nil))
+}
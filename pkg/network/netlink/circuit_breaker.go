@@ -62,6 +62,15 @@ func NewCircuitBreaker(maxEventsPerSec int64) *CircuitBreaker {
 	return c
 }
 
+// SetMaxEventsPerSec changes the maximum rate of events allowed to pass.
+// -1 will virtually disable the circuit breaker.
+func (c *CircuitBreaker) SetMaxEventsPerSec(maxEventsPerSec int64) {
+	if maxEventsPerSec == -1 {
+		maxEventsPerSec = math.MaxInt64
+	}
+	atomic.StoreInt64(&c.maxEventsPerSec, maxEventsPerSec)
+}
+
 // IsOpen returns true when the circuit breaker trips and remain
 // unchanched until Reset() is called.
 func (c *CircuitBreaker) IsOpen() bool {
@@ -115,10 +124,10 @@ func (c *CircuitBreaker) update(now time.Time) {
 	atomic.StoreInt64(&c.eventRate, int64(newEventRate))
 
 	// Update circuit breaker status accordingly
-	if int64(newEventRate) > c.maxEventsPerSec {
+	if maxEventsPerSec := atomic.LoadInt64(&c.maxEventsPerSec); int64(newEventRate) > maxEventsPerSec {
 		log.Warnf(
 			"exceeded maximum number of netlink messages per second. expected=%d actual=%d",
-			c.maxEventsPerSec,
+			maxEventsPerSec,
 			int(newEventRate),
 		)
 		atomic.StoreInt64(&c.status, breakerOpen)
@@ -72,6 +72,30 @@ func TestCircuitBreakerReset(t *testing.T) {
 	assert.False(t, breaker.IsOpen())
 }
 
+func TestCircuitBreakerSetMaxEventsPerSec(t *testing.T) {
+	const maxEventRate = 100
+	breaker := newTestBreaker(maxEventRate)
+
+	// raising the limit lets a higher rate of events pass
+	breaker.SetMaxEventsPerSec(maxEventRate * 2)
+	breaker.Tick(maxEventRate + 50)
+	breaker.update(time.Now())
+	assert.False(t, breaker.IsOpen())
+
+	// lowering it trips the circuit at the next update
+	breaker.SetMaxEventsPerSec(maxEventRate / 2)
+	breaker.Tick(maxEventRate)
+	breaker.update(time.Now().Add(time.Second))
+	assert.True(t, breaker.IsOpen())
+
+	// -1 disables the limit
+	breaker.SetMaxEventsPerSec(-1)
+	breaker.Reset()
+	breaker.Tick(maxEventRate * 1000)
+	breaker.update(time.Now())
+	assert.False(t, breaker.IsOpen())
+}
+
 func TestStartAboveThreshold(t *testing.T) {
 	const maxEventRate = 100
 	breaker := newTestBreaker(maxEventRate)
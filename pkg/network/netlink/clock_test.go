@@ -0,0 +1,90 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is a clock only moving forward when it is told to, firing the timers and the tickers it passes
+type fakeClock struct {
+	sync.Mutex
+	now     time.Time
+	timers  []*fakeTicker
+	tickers []*fakeTicker
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1600000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Lock()
+	defer c.Unlock()
+	t := &fakeTicker{clock: c, c: make(chan time.Time, 1), next: c.now.Add(d)}
+	c.timers = append(c.timers, t)
+	return t.c
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.Lock()
+	defer c.Unlock()
+	t := &fakeTicker{clock: c, c: make(chan time.Time, 1), interval: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Add moves the clock forward, firing the timers and the tickers due in the meantime. Like a time.Ticker, a
+// ticker whose previous tick wasn't received yet drops the ticks.
+func (c *fakeClock) Add(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+
+	timers := c.timers[:0]
+	for _, t := range c.timers {
+		if t.next.After(c.now) {
+			timers = append(timers, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.timers = timers
+
+	for _, t := range c.tickers {
+		for !t.stopped && !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.interval)
+		}
+	}
+}
+
+// fakeTicker is a timer of a fakeClock when its interval is 0
+type fakeTicker struct {
+	clock    *fakeClock
+	c        chan time.Time
+	interval time.Duration
+	next     time.Time
+	stopped  bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+	t.stopped = true
+}
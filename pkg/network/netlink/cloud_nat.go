@@ -0,0 +1,103 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+// cloudNATConntracker flags the translations returned by the wrapped Conntracker whose reply side is in one of the
+// ranges of the cloud NAT gateways, eg. the elastic IPs of an AWS NAT gateway or the addresses of a GCP Cloud NAT
+type cloudNATConntracker struct {
+	Conntracker
+	ranges []*net.IPNet
+
+	stats struct {
+		flagged int64
+	}
+}
+
+// NewCloudNATConntracker returns a Conntracker setting CloudNAT on the translations returned by the given
+// Conntracker whose reply source or destination is in one of the given CIDRs
+func NewCloudNATConntracker(ctr Conntracker, cidrs []string) (Conntracker, error) {
+	ranges, err := parseCIDRs(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	return &cloudNATConntracker{
+		Conntracker: ctr,
+		ranges:      ranges,
+	}, nil
+}
+
+func (ctr *cloudNATConntracker) GetTranslationForConn(c network.ConnectionStats) *network.IPTranslation {
+	return ctr.withCloudNAT(ctr.Conntracker.GetTranslationForConn(c))
+}
+
+func (ctr *cloudNATConntracker) GetTranslationsForConns(conns []network.ConnectionStats) []*network.IPTranslation {
+	translations := ctr.Conntracker.GetTranslationsForConns(conns)
+	for i := range translations {
+		translations[i] = ctr.withCloudNAT(translations[i])
+	}
+	return translations
+}
+
+func (ctr *cloudNATConntracker) GetEntryForConn(c network.ConnectionStats) *ConntrackEntry {
+	e := ctr.Conntracker.GetEntryForConn(c)
+	if e != nil {
+		e.Translation = ctr.withCloudNAT(e.Translation)
+	}
+	return e
+}
+
+// withCloudNAT returns the given translation, flagged when its reply side is in one of the cloud NAT ranges
+func (ctr *cloudNATConntracker) withCloudNAT(t *network.IPTranslation) *network.IPTranslation {
+	if t == nil || !ctr.isCloudNAT(t.ReplSrcIP, t.ReplDstIP) {
+		return t
+	}
+	atomic.AddInt64(&ctr.stats.flagged, 1)
+
+	// translations returned by the wrapped Conntracker may be shared, so they are never modified
+	flagged := *t
+	flagged.CloudNAT = true
+	return &flagged
+}
+
+func (ctr *cloudNATConntracker) isCloudNAT(addrs ...util.Address) bool {
+	for _, addr := range addrs {
+		if addr != nil && containsAddr(ctr.ranges, util.NetIPFromAddress(addr)) {
+			return true
+		}
+	}
+	return false
+}
+
+// DumpCachedTable returns the entries of the wrapped Conntracker, flagging the cloud NAT ones
+func (ctr *cloudNATConntracker) DumpCachedTable() []network.DebugConntrackEntry {
+	entries := ctr.Conntracker.DumpCachedTable()
+	for i := range entries {
+		reply := entries[i].Reply
+		for _, addr := range []string{reply.Src, reply.Dst} {
+			if ip := net.ParseIP(addr); ip != nil && containsAddr(ctr.ranges, ip) {
+				entries[i].CloudNAT = true
+				break
+			}
+		}
+	}
+	return entries
+}
+
+func (ctr *cloudNATConntracker) Describe() network.ConntrackDescription {
+	return withLayer(ctr.Conntracker.Describe(), "cloud_nat")
+}
+
+func (ctr *cloudNATConntracker) GetStats() Stats {
+	s := ctr.Conntracker.GetStats()
+	s.set("cloud_nat_translations", atomic.LoadInt64(&ctr.stats.flagged))
+	return s
+}
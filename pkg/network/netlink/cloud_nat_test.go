@@ -0,0 +1,62 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudNATConntracker(t *testing.T) {
+	_, err := NewCloudNATConntracker(NewNoOpConntracker(), []string{"not a cidr"})
+	assert.Error(t, err)
+
+	rt := newConntracker()
+	// 10.0.1.5:40000 -> 1.1.1.1:443 masqueraded to 34.120.0.7:61000, an address of the cloud NAT
+	masq := makeTranslatedConn(net.ParseIP("10.0.1.5"), net.ParseIP("1.1.1.1"), net.ParseIP("1.1.1.1"), 6, 40000, 443, 443)
+	masq.Reply.dst, masq.Reply.dstPort = keyAddrFromString("34.120.0.7"), 61000
+	rt.register(masq)
+	// 10.0.1.5:40001 -> 10.96.0.1:443 translated to 10.244.1.5:8443
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.1.5"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40001, 8443, 443))
+
+	ctr, err := NewCloudNATConntracker(rt, []string{"34.120.0.0/24"})
+	require.NoError(t, err)
+
+	masqueraded := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.1.5"),
+		SPort:  40000,
+		Dest:   util.AddressFromString("1.1.1.1"),
+		DPort:  443,
+		Type:   network.TCP,
+	}
+	trans := ctr.GetTranslationForConn(masqueraded)
+	require.NotNil(t, trans)
+	assert.True(t, trans.CloudNAT)
+
+	balanced := masqueraded
+	balanced.SPort = 40001
+	balanced.Dest = util.AddressFromString("10.96.0.1")
+	translations := ctr.GetTranslationsForConns([]network.ConnectionStats{masqueraded, balanced})
+	require.Len(t, translations, 2)
+	assert.True(t, translations[0].CloudNAT)
+	require.NotNil(t, translations[1])
+	assert.False(t, translations[1].CloudNAT)
+
+	// the cached translations are left untouched
+	assert.False(t, rt.GetTranslationForConn(masqueraded).CloudNAT)
+
+	var cloudNAT int
+	for _, e := range ctr.DumpCachedTable() {
+		if e.CloudNAT {
+			cloudNAT++
+		}
+	}
+	// only the origin entry of the masqueraded connection has the gateway on its reply side
+	assert.Equal(t, 1, cloudNAT)
+}
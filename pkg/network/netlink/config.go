@@ -0,0 +1,240 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+import "time"
+
+// Config holds the settings used to create a Conntracker
+type Config struct {
+	// ProcRoot is the root path to the proc filesystem
+	ProcRoot string
+
+	// MaxStateSize is the maximum number of translated connections the conntracker will store
+	MaxStateSize int
+
+	// MaxEntriesPerSource caps the number of connections stored for each source address, so a single workload
+	// can't fill the whole cache. A value of 0 disables the cap.
+	MaxEntriesPerSource int
+
+	// QuotaPerNamespace caps the connections stored for each network namespace rather than each source address,
+	// when MaxEntriesPerSource is set. The root namespace isn't capped.
+	QuotaPerNamespace bool
+
+	// AutoMaxStateSize derives MaxStateSize from the size of the kernel conntrack table (nf_conntrack_max).
+	// MaxStateSize is used as a fallback when the kernel table size can't be read.
+	AutoMaxStateSize bool
+
+	// ArenaMinStateSize allocates the slots of the table storing the translations upfront for MaxStateSize connections,
+	// when MaxStateSize is at least this value, so very large caches are never resized, at the cost of the memory of
+	// all the slots being used from the start. Setting it to 0 disables it.
+	ArenaMinStateSize int
+
+	// CompactionStrategy is how the table storing the translations is shrunk once most of its entries were deleted:
+	// "incremental" migrates its slots a few at a time along with the following updates, "copy" moves them all at
+	// once, which releases the memory sooner at the cost of holding the lock for longer, and "off" never shrinks it,
+	// which keeps the memory of its largest size. It is "incremental" when empty.
+	CompactionStrategy string
+
+	// CompactInterval is the interval at which the table storing the translations is checked for being shrunk,
+	// which batches the shrinks of hosts whose number of connections varies a lot. It is checked after each deletion
+	// when it is 0.
+	CompactInterval time.Duration
+
+	// TargetRateLimit is the maximum number of netlink messages per second that can be read off the socket.
+	// Setting it to -1 disables the limit.
+	TargetRateLimit int
+
+	// NamespaceRateLimit is the maximum number of netlink messages per second read from each network namespace when
+	// ListenAllNamespaces is set, the messages over it being dropped. It keeps a noisy namespace from using up
+	// TargetRateLimit, which would have the events of all the namespaces sampled. Setting it to 0 disables the limit.
+	NamespaceRateLimit int
+
+	// AdaptiveSampling enables the periodic adjustment of the sampling rate based on the event rate and the
+	// CPU usage of the netlink reader, so the sampling rate goes back up once the load decreases.
+	AdaptiveSampling bool
+
+	// AdaptiveCPUBudget is the fraction of a CPU core the netlink reader is allowed to use when
+	// AdaptiveSampling is enabled. A value of 0 uses the default budget.
+	AdaptiveCPUBudget float64
+
+	// CPUBreakerBudget is the fraction of a CPU core the processing of conntrack events is allowed to use.
+	// Event processing is paused for a while when it is exceeded, and the conntrack table is dumped periodically
+	// instead. A value of 0 disables the breaker.
+	CPUBreakerBudget float64
+
+	// DisableIPv6 skips the dumps of the IPv6 conntrack table, and drops the IPv6 conntrack events.
+	// IPv6 is also skipped when it isn't available on the host.
+	DisableIPv6 bool
+
+	// DumpMarkMask chunks the dumps of the conntrack table on these bits of the conntrack mark, dumping the entries
+	// of each value of the bits one at a time, so huge tables are dumped reliably. Only its 8 lowest bits set are
+	// used. The table is dumped in one pass when it is 0.
+	DumpMarkMask uint32
+
+	// LazyInit loads the initial dump of the conntrack table in the background, the translations of the live
+	// events being served in the meantime. It speeds up the startup on hosts with large conntrack tables.
+	LazyInit bool
+
+	// InitTimeout is how long NewConntracker waits for the conntracker to be initialized, which includes the load
+	// of the initial dump unless LazyInit is set, before retrying in the background. A value of 0 uses the default
+	// timeout of 10 seconds.
+	InitTimeout time.Duration
+
+	// DumpTimeout is how long the initial dump of each address family is loaded for. The entries left once it
+	// expires are loaded by a re-sync in the background. Setting it to 0 doesn't bound the initial dumps.
+	DumpTimeout time.Duration
+
+	// InitRetryBackoff and InitRetryMaxBackoff bound the backoff between the retries of the initialization, which
+	// doubles after each failure. A value of 0 uses the default of 5 seconds and 5 minutes respectively.
+	InitRetryBackoff    time.Duration
+	InitRetryMaxBackoff time.Duration
+
+	// InitMaxRetries is the number of retries after which the initialization is given up on, in which case the
+	// tracer continues without NAT tracking. The initialization is retried forever when it is 0, and never when it
+	// is negative, NewConntracker failing when conntrack can't be initialized in time.
+	InitMaxRetries int
+
+	// Modprobe enables loading the nf_conntrack and nf_conntrack_netlink kernel modules when they are missing
+	Modprobe bool
+
+	// ListenAllNamespaces enables tracking of conntrack entries in all namespaces that are peers of the root namespace
+	ListenAllNamespaces bool
+
+	// RcvBufSize is the size (in bytes) requested for the netlink socket receive buffer.
+	// A value of 0 uses the default size.
+	RcvBufSize int
+
+	// MmapRing reads the conntrack events off a ring buffer of RcvBufSize bytes mapped in memory and shared with the
+	// kernel, instead of copying them with a recvmsg call each, which saves CPU during event storms. The ring is only
+	// supported by kernels older than 4.6 (NETLINK_RX_RING), and doesn't report the namespace of the events, so the
+	// socket falls back to recvmsg on newer kernels and when ListenAllNamespaces is set.
+	MmapRing bool
+
+	// NetlinkGroups are the conntrack event groups ("new", "update", "destroy") the netlink socket subscribes to.
+	// Only new connection events are subscribed to if it is empty.
+	NetlinkGroups []string
+
+	// UDPCloseHints notifies the subscribers about the UDP connections conntrack destroys, translated or not, so
+	// they can be closed without waiting for them to idle out. It subscribes to the "destroy" group.
+	UDPCloseHints bool
+
+	// NATRuleFilter only stores the translations matching the address constraints of the NAT rules of the host,
+	// which are read with nftables netlink
+	NATRuleFilter bool
+
+	// IncludeCIDRs restricts the translations stored to the ones with an address in one of these networks
+	IncludeCIDRs []string
+
+	// ExcludeCIDRs prevents the translations with an address in one of these networks from being stored
+	ExcludeCIDRs []string
+
+	// IncludePorts restricts the entries decoded to the ones with an origin port in one of these ranges ("53", "1024-32767")
+	IncludePorts []string
+
+	// ExcludePorts prevents the entries with an origin port in one of these ranges from being decoded
+	ExcludePorts []string
+
+	// Zones restricts the entries decoded to the ones of these conntrack zones and of the default zone. When the same
+	// connection is tracked in several zones, the translation of the zone listed first is kept. The zones are
+	// ignored when it is empty.
+	Zones []string
+
+	// SkipUnreplied skips the entries whose connection didn't see any reply yet (eg. half-open UDP flows or SYNs sent to
+	// dead hosts), which would fill the cache during scans. They are stored once their reply is reported, which
+	// requires the "update" group to be subscribed to.
+	SkipUnreplied bool
+
+	// SkipUnassured skips the entries conntrack didn't mark ASSURED yet, whose connection isn't established both ways
+	// (eg. TCP connections whose handshake didn't complete). They are stored once they are assured, which requires
+	// the "update" group to be subscribed to.
+	SkipUnassured bool
+
+	// SkipLocalReplies keeps the entries whose reply has a loopback or link-local address (eg. translated by REDIRECT
+	// rules or localhost DNAT) out of the cache, so they are never returned as translations. They are still reported,
+	// up to a limit, by the debug dumps of the cache.
+	SkipLocalReplies bool
+
+	// TrackTCPState keeps the conntrack state of the TCP connections translated, so it can be retrieved
+	// with GetTCPStateForConn. State transitions are only received when the "update" group is subscribed to.
+	TrackTCPState bool
+
+	// TrackCounters keeps the packet and byte counters of the connections translated, so they can be retrieved
+	// with GetCountersForConn. The counters are only maintained by the kernel when nf_conntrack_acct is enabled,
+	// and are only refreshed when the "update" group is subscribed to.
+	TrackCounters bool
+
+	// TrackStartTimes keeps the creation time of the connections translated, so it can be retrieved
+	// with GetStartTimeForConn. The creation time is only recorded by the kernel when nf_conntrack_timestamp is enabled.
+	TrackStartTimes bool
+
+	// TrackStatus keeps the status flags (eg. ASSURED, SEEN_REPLY, DYING) of the connections translated, so they are
+	// returned by GetEntryForConn. Status changes are only received when the "update" group is subscribed to.
+	TrackStatus bool
+
+	// TrackExpectations subscribes to the conntrack expectation table, so the connections created by conntrack
+	// helpers (eg. FTP data channels) are translated even before their own conntrack entry is reported
+	TrackExpectations bool
+
+	// SnapshotPath is the file the cache is persisted to when the conntracker is closed, and restored from
+	// when it is created, instead of dumping the whole conntrack table. Persistence is disabled when empty.
+	SnapshotPath string
+
+	// RecordPath is the file the raw netlink messages read are recorded to, along with the time they were read at,
+	// so they can be replayed with ReplayPath. Recording is disabled when empty.
+	RecordPath string
+
+	// ReplayPath is a recording made with RecordPath, whose events are replayed instead of being read from
+	// the kernel, at the pace they were recorded. The kernel conntrack table isn't read at all when it is set.
+	ReplayPath string
+
+	// TraceTuples are connections ("tcp 10.0.0.1:40000 10.96.0.1:443") whose events, registrations, deletions and
+	// lookups are logged at the info level, whatever their direction and network namespace, to debug the connections
+	// missing their translation
+	TraceTuples []string
+
+	// ResyncInterval is the interval at which the cache is reconciled with a full dump of the kernel conntrack table.
+	// Setting it to 0 disables the periodic re-sync.
+	ResyncInterval time.Duration
+
+	// TCPEntryTTL and UDPEntryTTL are how long the entries of each protocol are kept after they were last stored,
+	// so the short-lived flows (eg. DNS lookups) don't fill the cache until they are destroyed. Setting one of them
+	// to 0 keeps the entries of its protocol until they are destroyed.
+	TCPEntryTTL time.Duration
+	UDPEntryTTL time.Duration
+
+	// RegisterQueueSize is the number of events queued for a dedicated goroutine to register them, so the reads of
+	// the event socket never wait for the lock of the cache. The events are dropped when the queue is full, and
+	// CPUBreakerBudget only applies to the decoding of the events then. Setting it to 0 registers the events as
+	// they are read.
+	RegisterQueueSize int
+
+	// MissQueryRate is the maximum number of queries per second sent to the kernel conntrack table for the connections
+	// missing from the cache, whose entry may not have been stored because its events were sampled, rate-limited or
+	// lost. The entries found are stored in the cache, and the connections found without translation aren't queried
+	// again for NegativeLookupTTL. Setting it to 0 disables the queries.
+	MissQueryRate int
+
+	// NegativeLookupTTL is how long the connections found without translation are cached as such when MissQueryRate
+	// is set, so the repeated lookups of the connections which aren't NAT'ed neither take the lock of the cache nor
+	// query the kernel again. Up to MissQueryRate connections per second of it are cached. Setting it to 0 disables
+	// the cache.
+	NegativeLookupTTL time.Duration
+
+	// ConntrackdAddress is the address the sync messages of conntrackd are listened to on ("225.0.0.50:3780" for the
+	// default multicast group of conntrackd), so the passive firewalls of a high-availability cluster resolve the
+	// translations replicated by the active one. It is only used by NewConntrackdConntracker.
+	ConntrackdAddress string
+
+	// ConntrackdInterface is the interface the multicast group of ConntrackdAddress is joined on. The group is joined
+	// on the default interface when it is empty.
+	ConntrackdInterface string
+
+	// RegisterReadiness registers the readiness check of the given name with the agent health, which the conntracker
+	// keeps healthy as long as the conntrack events are processed. No readiness is reported when it is nil.
+	RegisterReadiness func(name string) Readiness
+
+	// StatsReporter is reported the typed stats of the conntracker periodically, eg. to forward them to the agent
+	// telemetry. The stats aren't reported when it is nil.
+	StatsReporter StatsReporter
+}
@@ -0,0 +1,98 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"sync/atomic"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"golang.org/x/sys/unix"
+)
+
+// connClass is the transport protocol and the address family of a conntrack entry
+type connClass uint8
+
+const (
+	classTCPv4 connClass = iota
+	classTCPv6
+	classUDPv4
+	classUDPv6
+	numConnClasses
+)
+
+var connClassNames = [numConnClasses]string{"tcp_v4", "tcp_v6", "udp_v4", "udp_v6"}
+
+// keyClass returns the class of a key of the state map
+func keyClass(k connKey) connClass {
+	class := classTCPv4
+	if k.transport == network.UDP {
+		class = classUDPv4
+	}
+	if !k.srcIP.isIPv4() {
+		class++
+	}
+	return class
+}
+
+// conClass returns the class of the origin tuple of an entry, false when its protocol isn't tracked
+func conClass(c *Con) (connClass, bool) {
+	if c.Origin.fields&(tupleSrc|tupleProto) != tupleSrc|tupleProto {
+		return 0, false
+	}
+
+	var class connClass
+	switch c.Origin.proto {
+	case unix.IPPROTO_TCP:
+		class = classTCPv4
+	case unix.IPPROTO_UDP:
+		class = classUDPv4
+	default:
+		return 0, false
+	}
+	if !c.Origin.src.isIPv4() {
+		class++
+	}
+	return class, true
+}
+
+// classStats breaks the registrations, the drops and the size of the state map down by class, so what fills the
+// cache can be told (eg. the UDP entries of DNS lookups)
+type classStats struct {
+	registers [numConnClasses]int64
+	drops     [numConnClasses]int64
+	entries   [numConnClasses]int64
+}
+
+// registered counts an entry registered
+func (s *classStats) registered(c *Con) {
+	if class, ok := conClass(c); ok {
+		atomic.AddInt64(&s.registers[class], 1)
+	}
+}
+
+// dropped counts an entry which wasn't stored, whatever the reason
+func (s *classStats) dropped(c *Con) {
+	if class, ok := conClass(c); ok {
+		atomic.AddInt64(&s.drops[class], 1)
+	}
+}
+
+// added counts a key added to the state map, and removed a key removed from it
+func (s *classStats) added(k connKey) {
+	atomic.AddInt64(&s.entries[keyClass(k)], 1)
+}
+
+func (s *classStats) removed(k connKey) {
+	atomic.AddInt64(&s.entries[keyClass(k)], -1)
+}
+
+func (s *classStats) getStats() map[string]int64 {
+	m := make(map[string]int64, 3*numConnClasses)
+	for class, name := range connClassNames {
+		m[name+"_registers"] = atomic.LoadInt64(&s.registers[class])
+		m[name+"_registers_dropped"] = atomic.LoadInt64(&s.drops[class])
+		m[name+"_state_size"] = atomic.LoadInt64(&s.entries[class])
+	}
+	return m
+}
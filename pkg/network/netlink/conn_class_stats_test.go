@@ -0,0 +1,56 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnClass(t *testing.T) {
+	assert.Equal(t, classTCPv4, keyClass(connKey{srcIP: keyAddrFromString("10.0.0.1"), transport: network.TCP}))
+	assert.Equal(t, classUDPv6, keyClass(connKey{srcIP: keyAddrFromString("fd00::1"), transport: network.UDP}))
+
+	c := makeUntranslatedConn(net.ParseIP("fd00::1"), net.ParseIP("fd00::2"), 6, 40000, 443)
+	class, ok := conClass(&c)
+	assert.True(t, ok)
+	assert.Equal(t, classTCPv6, class)
+
+	c = makeUntranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), 1, 0, 0)
+	_, ok = conClass(&c)
+	assert.False(t, ok)
+}
+
+func TestClassStats(t *testing.T) {
+	rt := newConntracker()
+
+	// a TCP connection and a DNS lookup translated, and a DNS lookup over IPv6 which isn't
+	tcp := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8443, 443)
+	dns := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.6"), net.ParseIP("10.96.0.10"), 17, 40001, 53, 53)
+	rt.register(tcp)
+	rt.register(dns)
+	rt.register(makeUntranslatedConn(net.ParseIP("fd00::1"), net.ParseIP("fd00::2"), 17, 40002, 53))
+
+	stats := rt.classStats.getStats()
+	assert.Equal(t, int64(1), stats["tcp_v4_registers"])
+	assert.Equal(t, int64(1), stats["tcp_v4_state_size"])
+	assert.Equal(t, int64(1), stats["udp_v4_registers"])
+	assert.Equal(t, int64(1), stats["udp_v4_state_size"])
+	assert.Equal(t, int64(0), stats["udp_v6_registers"])
+	assert.Equal(t, int64(1), stats["udp_v6_registers_dropped"])
+	assert.Equal(t, int64(0), stats["udp_v6_state_size"])
+
+	// the state map is full
+	rt.maxStateSize = 2
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.6"), net.ParseIP("10.96.0.10"), 17, 40003, 53, 53))
+	assert.Equal(t, int64(1), rt.classStats.getStats()["udp_v4_registers_dropped"])
+
+	rt.unregister(dns)
+	stats = rt.classStats.getStats()
+	assert.Equal(t, int64(0), stats["udp_v4_state_size"])
+	assert.Equal(t, int64(1), stats["tcp_v4_state_size"])
+}
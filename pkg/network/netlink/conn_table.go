@@ -0,0 +1,183 @@
+// +build linux
+// +build !android
+
+package netlink
+
+// connTable holds the connections of the state map, each of them once: keyed with its origin tuple and translated
+// to its reply tuple. The connections are indexed by their reply tuple, so they are found from both of their ends,
+// the translation of the reply tuple being derived from the one of the origin tuple.
+// A tuple belongs to the last connection stored with it: a connection stored with the origin or reply tuple of
+// another one takes it over from that one, which is then only found from its other tuple. The origin tuples are
+// looked up before the reply tuples, so a connection whose origin tuple is the reply tuple of another one is found
+// from it.
+type connTable struct {
+	conns   *translationTable
+	replies *replyIndex
+}
+
+// newConnTable returns a table whose slots are pre-allocated for the given number of connections
+func newConnTable(conns int) *connTable {
+	return &connTable{
+		conns:   newTranslationTable(conns),
+		replies: newReplyIndex(conns),
+	}
+}
+
+// newStateTable returns the table of a state map of the given maximum size, whose slots are pre-allocated for all
+// its connections when it is at least arenaMinStateSize. A value of 0 for arenaMinStateSize never pre-allocates them.
+func newStateTable(maxStateSize, arenaMinStateSize int) *connTable {
+	if arenaMinStateSize > 0 && maxStateSize >= arenaMinStateSize {
+		return newConnTable(maxStateSize)
+	}
+	return newConnTable(0)
+}
+
+// reverseTranslation returns the translation of the reply tuple of a connection, from its origin key and the
+// translation of its origin tuple
+func reverseTranslation(origin connKey, v translationValue) translationValue {
+	r := translationValue{
+		replSrcIP:   origin.srcIP,
+		replSrcPort: origin.srcPort,
+		replDstIP:   origin.dstIP,
+		replDstPort: origin.dstPort,
+	}
+	r.natType = r.natTypeOf(v.replyKey(origin))
+	return r
+}
+
+// get returns the translation of the given key, be it the origin or the reply key of its connection
+func (c *connTable) get(k connKey) (translationValue, bool) {
+	origin, v, reversed, ok := c.find(k)
+	if reversed {
+		return reverseTranslation(origin, v), ok
+	}
+	return v, ok
+}
+
+// find returns the connection of the given key: its origin key and the translation of its origin tuple, and
+// whether the given key is its reply key
+func (c *connTable) find(k connKey) (origin connKey, v translationValue, reversed bool, ok bool) {
+	h := c.conns.hash(k)
+	if v, ok := c.conns.getHashed(h, k); ok {
+		return k, v, false, true
+	}
+	origin, v, ok = c.findReply(h, k)
+	return origin, v, ok, ok
+}
+
+// findReply returns the connection indexed with the given reply key, whose hash is h
+func (c *connTable) findReply(h uint64, reply connKey) (connKey, translationValue, bool) {
+	var buf [4]uint64
+	for _, o := range c.replies.candidates(h, buf[:0]) {
+		if origin, v, ok := c.conns.getReply(o, reply); ok {
+			return origin, v, true
+		}
+	}
+	return connKey{}, translationValue{}, false
+}
+
+// contains returns whether the given key is the origin or reply key of a connection
+func (c *connTable) contains(k connKey) bool {
+	_, _, _, ok := c.find(k)
+	return ok
+}
+
+// getConn returns the translation of the connection of the given origin key
+func (c *connTable) getConn(origin connKey) (translationValue, bool) {
+	return c.conns.get(origin)
+}
+
+// set stores the connection of the given origin key, and indexes it with its reply key
+func (c *connTable) set(origin connKey, v translationValue) {
+	h := c.conns.hash(origin)
+	if old, ok := c.conns.getHashed(h, origin); ok {
+		if old == v {
+			return
+		}
+		c.unindex(origin, old)
+	} else if prev, _, ok := c.findReply(h, origin); ok {
+		// the tuple of the connection is taken over from the reply of another one
+		c.replies.remove(h, c.conns.hash(prev))
+	}
+	c.conns.set(origin, v)
+
+	reply := v.replyKey(origin)
+	rh := c.conns.hash(reply)
+	if prev, _, ok := c.findReply(rh, reply); ok {
+		c.replies.remove(rh, c.conns.hash(prev))
+	}
+	c.replies.add(rh, h)
+}
+
+// unindex removes the reply key of the given connection from the index, unless it was taken over by another one
+func (c *connTable) unindex(origin connKey, v translationValue) {
+	c.replies.remove(c.conns.hash(v.replyKey(origin)), c.conns.hash(origin))
+}
+
+// indexed returns whether the given connection is found from its reply key
+func (c *connTable) indexed(origin connKey, v translationValue) bool {
+	reply := v.replyKey(origin)
+	found, _, ok := c.findReply(c.conns.hash(reply), reply)
+	return ok && found == origin
+}
+
+// delete removes the connection of the given origin key, and returns its translation
+func (c *connTable) delete(origin connKey) (translationValue, bool) {
+	v, ok := c.conns.get(origin)
+	if !ok {
+		return translationValue{}, false
+	}
+	c.unindex(origin, v)
+	c.conns.delete(origin)
+	return v, true
+}
+
+// len returns the number of connections of the table
+func (c *connTable) len() int {
+	return c.conns.len()
+}
+
+// forEach calls fn with the origin key and translation of each connection until it returns false.
+// The table must not be modified meanwhile.
+func (c *connTable) forEach(fn func(connKey, translationValue) bool) {
+	c.conns.forEach(fn)
+}
+
+// clone returns a copy of the table, which is read without the lock once published. The copy shares the chunks of
+// the slots of the table and its index until they are written.
+func (c *connTable) clone() *connTable {
+	return &connTable{
+		conns:   c.conns.clone(),
+		replies: c.replies.clone(),
+	}
+}
+
+// capacity returns the number of slots allocated by the connections and by the index
+func (c *connTable) capacity() (conns, replies int) {
+	return c.conns.capacity(), c.replies.capacity()
+}
+
+// unsharedCapacity returns the number of slots of the connections and of the index which aren't shared with the given
+// table the table was cloned from
+func (c *connTable) unsharedCapacity(from *connTable) (conns, replies int) {
+	return c.conns.unsharedCapacity(from.conns), c.replies.unsharedCapacity(from.replies)
+}
+
+// preallocated returns whether the table was allocated for a large number of connections upfront
+func (c *connTable) preallocated() bool {
+	return c.conns.preallocated()
+}
+
+// setCompaction sets how the table and its index are shrunk once most of the connections were deleted, by the
+// deletions, or by compact when deferred is set
+func (c *connTable) setCompaction(strategy compactionStrategy, deferred bool) {
+	c.conns.compaction, c.replies.compaction = strategy, strategy
+	c.conns.deferCompaction, c.replies.deferCompaction = deferred, deferred
+}
+
+// compact shrinks the table and its index, when fewer than tableMinLoad of their slots are used, and returns
+// whether the table was shrunk
+func (c *connTable) compact() bool {
+	c.replies.compact()
+	return c.conns.compact()
+}
@@ -0,0 +1,167 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connValue returns the translation of the connection of tableKey(port), DNAT'ed to 20.0.0.1:8080
+func connValue(port uint16) translationValue {
+	return translationValue{
+		replSrcIP:   keyAddrFromString("20.0.0.1"),
+		replSrcPort: 8080,
+		replDstIP:   keyAddrFromString("10.0.0.1"),
+		replDstPort: port,
+		natType:     network.DNAT,
+	}
+}
+
+func TestConnTable(t *testing.T) {
+	table := newConnTable(0)
+	origin := tableKey(12345)
+	v := connValue(12345)
+	reply := v.replyKey(origin)
+
+	table.set(origin, v)
+	assert.Equal(t, 1, table.len())
+	assert.Equal(t, 1, table.replies.len())
+
+	got, ok := table.get(origin)
+	require.True(t, ok)
+	assert.Equal(t, v, got)
+
+	// the translation of the reply tuple is derived from the one of the origin tuple
+	got, ok = table.get(reply)
+	require.True(t, ok)
+	assert.Equal(t, translationValue{
+		replSrcIP:   origin.srcIP,
+		replSrcPort: origin.srcPort,
+		replDstIP:   origin.dstIP,
+		replDstPort: origin.dstPort,
+		natType:     network.SNAT,
+	}, got)
+	assert.Equal(t, origin, got.replyKey(reply))
+	found, _, reversed, ok := table.find(reply)
+	assert.True(t, ok)
+	assert.True(t, reversed)
+	assert.Equal(t, origin, found)
+
+	// the reply tuple of a connection updated is indexed again
+	updated := connValue(12345)
+	updated.replSrcPort = 9090
+	table.set(origin, updated)
+	assert.Equal(t, 1, table.replies.len())
+	assert.False(t, table.contains(reply))
+	assert.True(t, table.contains(updated.replyKey(origin)))
+
+	_, ok = table.delete(origin)
+	assert.True(t, ok)
+	assert.False(t, table.contains(origin))
+	assert.False(t, table.contains(updated.replyKey(origin)))
+	assert.Zero(t, table.len())
+	assert.Zero(t, table.replies.len())
+}
+
+// TestConnTableTakeOver checks the tuples of a connection are taken over by the connections stored with them later
+func TestConnTableTakeOver(t *testing.T) {
+	table := newConnTable(0)
+	first := tableKey(12345)
+	v := connValue(12345)
+	reply := v.replyKey(first)
+	table.set(first, v)
+
+	// a newer connection recycles the reply tuple, the first one is only found from its origin tuple
+	second := tableKey(23456)
+	table.set(second, connValue(12345))
+	assert.Equal(t, 2, table.len())
+	assert.Equal(t, 1, table.replies.len())
+	found, _, _, ok := table.find(reply)
+	assert.True(t, ok)
+	assert.Equal(t, second, found)
+	assert.False(t, table.indexed(first, v))
+
+	// deleting the first connection leaves the reply tuple to the newer one
+	table.delete(first)
+	found, _, _, ok = table.find(reply)
+	assert.True(t, ok)
+	assert.Equal(t, second, found)
+
+	// a connection stored with the reply tuple takes it over as well
+	table.set(reply, connValue(34567))
+	assert.False(t, table.indexed(second, connValue(12345)))
+	got, _ := table.get(reply)
+	assert.Equal(t, connValue(34567), got)
+	table.delete(reply)
+	assert.False(t, table.contains(reply))
+	assert.True(t, table.contains(second))
+}
+
+func TestReplyIndex(t *testing.T) {
+	index := newReplyIndex(0)
+
+	// the origins sharing a reply hash are all candidates
+	index.add(1, 10)
+	index.add(1, 11)
+	index.add(2, 20)
+	assert.ElementsMatch(t, []uint64{10, 11}, index.candidates(1, nil))
+	assert.True(t, index.remove(1, 10))
+	assert.False(t, index.remove(1, 10))
+	assert.Equal(t, []uint64{11}, index.candidates(1, nil))
+	assert.Equal(t, []uint64{20}, index.candidates(2, nil))
+
+	// the index grows with its entries, and is shrunk once most of them are removed
+	for i := uint64(100); i < 1000; i++ {
+		index.add(i, i)
+	}
+	assert.True(t, index.capacity() > tableMinSlots)
+	for i := uint64(100); i < 1000; i++ {
+		assert.Equal(t, []uint64{i}, index.candidates(i, nil))
+		assert.True(t, index.remove(i, i))
+	}
+	assert.Equal(t, 2, index.len())
+	assert.Equal(t, tableMinSlots, index.capacity())
+	assert.Equal(t, []uint64{11}, index.candidates(1, nil))
+}
+
+func TestReplyIndexCopyOnWrite(t *testing.T) {
+	index := newReplyIndex(0)
+	index.add(1, 10)
+	published := index.clone()
+	assert.Zero(t, published.unsharedCapacity(index))
+
+	index.remove(1, 10)
+	index.add(2, 20)
+	assert.Equal(t, tableMinSlots, published.unsharedCapacity(index))
+	assert.Equal(t, []uint64{10}, published.candidates(1, nil))
+	assert.Empty(t, published.candidates(2, nil))
+	assert.Equal(t, []uint64{20}, index.candidates(2, nil))
+}
+
+func TestStateTablePreallocation(t *testing.T) {
+	assert.False(t, newStateTable(1000, 0).preallocated())
+	assert.False(t, newStateTable(1000, 1001).preallocated())
+
+	table := newStateTable(1000, 1000)
+	assert.True(t, table.preallocated())
+	slots, indexSlots := table.capacity()
+	assert.True(t, float64(slots) >= 1000/tableMaxLoad)
+	assert.True(t, float64(indexSlots) >= 1000/tableMaxLoad)
+
+	// a pre-allocated table isn't grown until it holds its maximum size, nor shrunk below it
+	for i := 0; i < 1000; i++ {
+		table.set(tableKey(uint16(i)), connValue(uint16(i)))
+	}
+	for i := 0; i < 1000; i++ {
+		table.delete(tableKey(uint16(i)))
+	}
+	assert.Zero(t, table.conns.grows)
+	assert.Zero(t, table.conns.shrinks)
+	_, after := table.capacity()
+	assert.Equal(t, indexSlots, after)
+}
@@ -77,8 +77,44 @@ func (c *conntrack) Dump() ([]Con, error) {
 	return nil, fmt.Errorf("not implemented")
 }
 
-func (c *conntrack) Get(_ *Con) (Con, error) {
-	return Con{}, fmt.Errorf("not implemented")
+// Get returns the entry of the origin tuple of conn, which conntrack matches against the tuples of both directions
+// of its entries. The error wraps os.ErrNotExist when there is no such entry.
+func (c *conntrack) Get(conn *Con) (Con, error) {
+	if !conn.Origin.hasAddresses() {
+		return Con{}, fmt.Errorf("no origin tuple to look up")
+	}
+	family := byte(unix.AF_INET6)
+	if conn.Origin.isIPv4() {
+		family = unix.AF_INET
+	}
+
+	data, err := EncodeConn(conn)
+	if err != nil {
+		return Con{}, err
+	}
+
+	// the entry is the reply itself, the kernel only acknowledges the requests which fail
+	replies, err := c.conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType((unix.NFNL_SUBSYS_CTNETLINK << 8) | ipctnlMsgCtGet),
+			Flags: netlink.Request,
+		},
+		Data: append([]byte{family, unix.NFNETLINK_V0, 0, 0}, data...),
+	})
+	if err != nil {
+		return Con{}, err
+	}
+	if len(replies) == 0 {
+		return Con{}, fmt.Errorf("no replies received from netlink call")
+	}
+
+	d := decoderPool.Get().(*Decoder)
+	defer decoderPool.Put(d)
+	entry := Con{NetNS: noNSID, EventType: eventType(replies[0].Header)}
+	if err := d.decode(replies[0].Data, &entry); err != nil {
+		return Con{}, err
+	}
+	return entry, nil
 }
 
 func (c *conntrack) Close() error {
@@ -9,7 +9,6 @@ import (
 	"os/exec"
 	"testing"
 
-	ct "github.com/florianl/go-conntrack"
 	"github.com/stretchr/testify/require"
 	"github.com/vishvananda/netns"
 	"golang.org/x/sys/unix"
@@ -72,9 +71,7 @@ func testConntrackExists(t *testing.T, laddrIP string, laddrPort int, proto stri
 		{
 			desc: fmt.Sprintf("net ns 0, origin exists, proto %s", proto),
 			c: Con{
-				Con: ct.Con{
-					Origin: newIPTuple(laddrIP, "2.2.2.4", uint16(laddrPort), 80, ipProto),
-				},
+				Origin: newIPTuple(laddrIP, "2.2.2.4", uint16(laddrPort), 80, ipProto),
 			},
 			exists: true,
 			ns:     0,
@@ -82,9 +79,7 @@ func testConntrackExists(t *testing.T, laddrIP string, laddrPort int, proto stri
 		{
 			desc: fmt.Sprintf("net ns 0, reply exists, proto %s", proto),
 			c: Con{
-				Con: ct.Con{
-					Reply: newIPTuple("2.2.2.4", laddrIP, 80, uint16(laddrPort), ipProto),
-				},
+				Reply: newIPTuple("2.2.2.4", laddrIP, 80, uint16(laddrPort), ipProto),
 			},
 			exists: true,
 			ns:     0,
@@ -92,9 +87,7 @@ func testConntrackExists(t *testing.T, laddrIP string, laddrPort int, proto stri
 		{
 			desc: fmt.Sprintf("net ns 0, origin does not exist, proto %s", proto),
 			c: Con{
-				Con: ct.Con{
-					Origin: newIPTuple(laddrIP, "2.2.2.3", uint16(laddrPort), 80, ipProto),
-				},
+				Origin: newIPTuple(laddrIP, "2.2.2.3", uint16(laddrPort), 80, ipProto),
 			},
 			exists: false,
 			ns:     0,
@@ -102,9 +95,7 @@ func testConntrackExists(t *testing.T, laddrIP string, laddrPort int, proto stri
 		{
 			desc: fmt.Sprintf("net ns %d, origin exists, proto %s", int(testNs), proto),
 			c: Con{
-				Con: ct.Con{
-					Origin: newIPTuple(laddrIP, "2.2.2.4", uint16(laddrPort), 80, ipProto),
-				},
+				Origin: newIPTuple(laddrIP, "2.2.2.4", uint16(laddrPort), 80, ipProto),
 			},
 			exists: true,
 			ns:     int(testNs),
@@ -112,9 +103,7 @@ func testConntrackExists(t *testing.T, laddrIP string, laddrPort int, proto stri
 		{
 			desc: fmt.Sprintf("net ns %d, reply exists, proto %s", int(testNs), proto),
 			c: Con{
-				Con: ct.Con{
-					Reply: newIPTuple("2.2.2.4", laddrIP, 8080, uint16(laddrPort), ipProto),
-				},
+				Reply: newIPTuple("2.2.2.4", laddrIP, 8080, uint16(laddrPort), ipProto),
 			},
 			exists: true,
 			ns:     int(testNs),
@@ -122,9 +111,7 @@ func testConntrackExists(t *testing.T, laddrIP string, laddrPort int, proto stri
 		{
 			desc: fmt.Sprintf("net ns %d, origin does not exist, proto %s", int(testNs), proto),
 			c: Con{
-				Con: ct.Con{
-					Origin: newIPTuple(laddrIP, "2.2.2.3", uint16(laddrPort), 80, ipProto),
-				},
+				Origin: newIPTuple(laddrIP, "2.2.2.3", uint16(laddrPort), 80, ipProto),
 			},
 			exists: false,
 			ns:     int(testNs),
@@ -0,0 +1,444 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// conntrackdMaxDatagramSize is the size of the buffer the datagrams of conntrackd are read into, which is the
+// maximum size of a UDP datagram
+const conntrackdMaxDatagramSize = 65535
+
+// layout of the messages of the conntrackd sync protocol (see network.h in conntrack-tools): a datagram holds a
+// sequence of messages, each of them made of a header and, for the conntrack entries, of attributes.
+//
+// struct nethdr { uint8_t version:4, type:4; uint8_t flags; uint16_t len; uint32_t seq; }
+// struct netattr { uint16_t nta_len; uint16_t nta_attr; }
+//
+// The lengths include the headers, and are in network byte order along with the attribute types. The messages and
+// the attributes are aligned on 4 bytes.
+const (
+	conntrackdProtocolVersion = 1
+	conntrackdHeaderLen       = 8
+	conntrackdAttrHeaderLen   = 4
+	conntrackdAlign           = 4
+)
+
+// types of the messages of the conntrackd sync protocol. The ones following conntrackdCtDelete are the messages
+// of the expectations and the control messages.
+const (
+	conntrackdCtNew uint8 = iota
+	conntrackdCtUpdate
+	conntrackdCtDelete
+)
+
+// attributes of the conntrack entries replicated by conntrackd (enum nta_attr)
+const (
+	// struct nfct_attr_grp_ipv{4,6} { uint32_t src[n], dst[n]; }, the addresses of the origin direction
+	conntrackdAttrIPv4 = 0
+	conntrackdAttrIPv6 = 1
+	// uint8_t
+	conntrackdAttrL4Proto = 2
+	// struct nfct_attr_grp_port { uint16_t sport, dport; }, the ports of the origin direction
+	conntrackdAttrPort = 3
+	// the destination (SNAT) and the source (DNAT) of the reply direction, when they are translated
+	conntrackdAttrSNATIPv4 = 12
+	conntrackdAttrDNATIPv4 = 13
+	conntrackdAttrSPATPort = 14
+	conntrackdAttrDPATPort = 15
+	conntrackdAttrSNATIPv6 = 29
+	conntrackdAttrDNATIPv6 = 30
+)
+
+// conntrackdMessage is a conntrack entry replicated by conntrackd
+type conntrackdMessage struct {
+	typ    uint8
+	origin connKey
+	reply  connKey
+}
+
+// parseConntrackdMessage parses the first message of the given datagram, and returns its length along with whether
+// it is a conntrack entry of a TCP or UDP connection. The length is 0 when the datagram is malformed.
+// conntrackd writes the translated addresses and ports as integers converted from the byte order of its host, which
+// already holds them in network byte order: they are parsed as written by the little-endian hosts (x86, arm64).
+func parseConntrackdMessage(b []byte) (m conntrackdMessage, n int, ok bool) {
+	if len(b) < conntrackdHeaderLen {
+		return m, 0, false
+	}
+	version, typ := b[0]>>4, b[0]&0xf
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if version != conntrackdProtocolVersion || length < conntrackdHeaderLen || length > len(b) {
+		return m, 0, false
+	}
+	n = conntrackdAligned(length, len(b))
+	if typ > conntrackdCtDelete {
+		return m, n, false
+	}
+	m.typ = typ
+
+	var (
+		proto                      uint8
+		src, dst                   []byte
+		sport, dport               uint16
+		snat, dnat                 []byte
+		spat, dpat                 uint16
+		hasPorts, hasSPAT, hasDPAT bool
+	)
+	for attrs := b[conntrackdHeaderLen:length]; len(attrs) >= conntrackdAttrHeaderLen; {
+		attrLen := int(binary.BigEndian.Uint16(attrs[0:2]))
+		if attrLen < conntrackdAttrHeaderLen || attrLen > len(attrs) {
+			return m, n, false
+		}
+		data := attrs[conntrackdAttrHeaderLen:attrLen]
+		switch binary.BigEndian.Uint16(attrs[2:4]) {
+		case conntrackdAttrIPv4:
+			if len(data) == 2*net.IPv4len {
+				src, dst = data[:net.IPv4len], data[net.IPv4len:]
+			}
+		case conntrackdAttrIPv6:
+			if len(data) == 2*net.IPv6len {
+				src, dst = data[:net.IPv6len], data[net.IPv6len:]
+			}
+		case conntrackdAttrL4Proto:
+			if len(data) >= 1 {
+				proto = data[0]
+			}
+		case conntrackdAttrPort:
+			if len(data) == 4 {
+				sport, dport = binary.BigEndian.Uint16(data[0:2]), binary.BigEndian.Uint16(data[2:4])
+				hasPorts = true
+			}
+		case conntrackdAttrSNATIPv4:
+			if len(data) == net.IPv4len {
+				snat = []byte{data[3], data[2], data[1], data[0]}
+			}
+		case conntrackdAttrDNATIPv4:
+			if len(data) == net.IPv4len {
+				dnat = []byte{data[3], data[2], data[1], data[0]}
+			}
+		case conntrackdAttrSNATIPv6:
+			if len(data) == net.IPv6len {
+				snat = data
+			}
+		case conntrackdAttrDNATIPv6:
+			if len(data) == net.IPv6len {
+				dnat = data
+			}
+		case conntrackdAttrSPATPort:
+			if len(data) == 2 {
+				spat, hasSPAT = binary.LittleEndian.Uint16(data), true
+			}
+		case conntrackdAttrDPATPort:
+			if len(data) == 2 {
+				dpat, hasDPAT = binary.LittleEndian.Uint16(data), true
+			}
+		}
+		attrs = attrs[conntrackdAligned(attrLen, len(attrs)):]
+	}
+
+	switch proto {
+	case 6:
+		m.origin.transport = network.TCP
+	case 17:
+		m.origin.transport = network.UDP
+	default:
+		return m, n, false
+	}
+	if src == nil || !hasPorts {
+		return m, n, false
+	}
+	m.origin.srcIP, m.origin.srcPort = keyAddrFromBytes(src), sport
+	m.origin.dstIP, m.origin.dstPort = keyAddrFromBytes(dst), dport
+
+	// the replies are sent back to the origin, unless it is translated
+	m.reply = reversedKey(m.origin)
+	if snat != nil {
+		m.reply.dstIP = keyAddrFromBytes(snat)
+	}
+	if hasSPAT {
+		m.reply.dstPort = spat
+	}
+	if dnat != nil {
+		m.reply.srcIP = keyAddrFromBytes(dnat)
+	}
+	if hasDPAT {
+		m.reply.srcPort = dpat
+	}
+	return m, n, true
+}
+
+// conntrackdAligned returns the given length aligned on conntrackdAlign, capped to max
+func conntrackdAligned(length, max int) int {
+	if length = (length + conntrackdAlign - 1) &^ (conntrackdAlign - 1); length > max {
+		return max
+	}
+	return length
+}
+
+// conntrackdConntracker resolves the translations replicated by conntrackd, which synchronizes the conntrack tables
+// of the firewalls of a high-availability cluster: the passive firewalls don't commit the replicated entries to their
+// own conntrack table until they fail over, so their translations are learnt from the sync messages instead, and
+// used when the wrapped Conntracker doesn't know about a connection.
+// The sync messages are only listened to: the listener doesn't take part in the protocol, so the connections
+// established before it started are only learnt once they are updated, or replicated again by a resync (eg.
+// conntrackd -n on the passive firewall).
+type conntrackdConntracker struct {
+	Conntracker
+	sync.RWMutex
+
+	state        map[connKey]*network.IPTranslation
+	maxStateSize int
+
+	conn *net.UDPConn
+	exit chan struct{}
+	done chan struct{}
+
+	stats struct {
+		hits            int64
+		messages        int64
+		messagesInvalid int64
+		connsDropped    int64
+	}
+}
+
+// NewConntrackdConntracker returns a Conntracker resolving the translations conntrackd replicates to the address of
+// Config.ConntrackdAddress, falling back to the given Conntracker for the connections they don't translate
+func NewConntrackdConntracker(ctr Conntracker, cfg *Config) (Conntracker, error) {
+	conntrackd, err := newConntrackdConntracker(ctr, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("listening to the conntrackd sync messages on %s", conntrackd.conn.LocalAddr())
+	return conntrackd, nil
+}
+
+func newConntrackdConntracker(ctr Conntracker, cfg *Config) (*conntrackdConntracker, error) {
+	conn, err := listenConntrackd(cfg.ConntrackdAddress, cfg.ConntrackdInterface)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen to the conntrackd sync messages on %s: %w", cfg.ConntrackdAddress, err)
+	}
+
+	conntrackd := &conntrackdConntracker{
+		Conntracker:  ctr,
+		state:        make(map[connKey]*network.IPTranslation),
+		maxStateSize: cfg.MaxStateSize,
+		conn:         conn,
+		exit:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go conntrackd.run()
+	return conntrackd, nil
+}
+
+// listenConntrackd listens to the sync messages sent to the given address, joining its group on the given interface
+// when it is a multicast address
+func listenConntrackd(address, iface string) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	if !addr.IP.IsMulticast() {
+		return net.ListenUDP("udp", addr)
+	}
+
+	var ifi *net.Interface
+	if iface != "" {
+		if ifi, err = net.InterfaceByName(iface); err != nil {
+			return nil, err
+		}
+	}
+	return net.ListenMulticastUDP("udp", ifi, addr)
+}
+
+func (conntrackd *conntrackdConntracker) GetTranslationForConn(c network.ConnectionStats) *network.IPTranslation {
+	if t := conntrackd.Conntracker.GetTranslationForConn(c); t != nil {
+		return t
+	}
+
+	conntrackd.RLock()
+	defer conntrackd.RUnlock()
+
+	t := conntrackd.state[connStatsToConnKey(c)]
+	if t != nil {
+		atomic.AddInt64(&conntrackd.stats.hits, 1)
+	}
+	return t
+}
+
+// GetTranslationsForConns returns the translations of the given connections, using the replicated translations for
+// the connections the wrapped Conntracker doesn't know about
+func (conntrackd *conntrackdConntracker) GetTranslationsForConns(conns []network.ConnectionStats) []*network.IPTranslation {
+	translations := conntrackd.Conntracker.GetTranslationsForConns(conns)
+
+	conntrackd.RLock()
+	defer conntrackd.RUnlock()
+
+	for i := range conns {
+		if translations[i] != nil {
+			continue
+		}
+		if t := conntrackd.state[connStatsToConnKey(conns[i])]; t != nil {
+			translations[i] = t
+			atomic.AddInt64(&conntrackd.stats.hits, 1)
+		}
+	}
+	return translations
+}
+
+// GetEntryForConn returns the entry of the wrapped Conntracker, or else the entry of the replicated translation
+func (conntrackd *conntrackdConntracker) GetEntryForConn(c network.ConnectionStats) *ConntrackEntry {
+	if e := conntrackd.Conntracker.GetEntryForConn(c); e != nil {
+		return e
+	}
+
+	conntrackd.RLock()
+	defer conntrackd.RUnlock()
+
+	t := conntrackd.state[connStatsToConnKey(c)]
+	if t == nil {
+		return nil
+	}
+	atomic.AddInt64(&conntrackd.stats.hits, 1)
+	return newConntrackEntry(c, t)
+}
+
+// DeleteTranslation removes the translation of a closed connection from both caches, in case its deletion isn't
+// replicated
+func (conntrackd *conntrackdConntracker) DeleteTranslation(c network.ConnectionStats) {
+	conntrackd.Conntracker.DeleteTranslation(c)
+
+	conntrackd.Lock()
+	defer conntrackd.Unlock()
+	k := connStatsToConnKey(c)
+	if !conntrackd.deleteTranslation(k) {
+		conntrackd.deleteTranslation(reversedKey(k))
+	}
+}
+
+// DeleteTranslations removes the translations of the given connections from both caches
+func (conntrackd *conntrackdConntracker) DeleteTranslations(conns []network.ConnectionStats) {
+	conntrackd.Conntracker.DeleteTranslations(conns)
+
+	conntrackd.Lock()
+	defer conntrackd.Unlock()
+	for i := range conns {
+		k := connStatsToConnKey(conns[i])
+		if !conntrackd.deleteTranslation(k) {
+			conntrackd.deleteTranslation(reversedKey(k))
+		}
+	}
+}
+
+// deleteTranslation removes both directions of the translation of the given key, and returns whether there was one.
+// It must be called with the write lock held.
+func (conntrackd *conntrackdConntracker) deleteTranslation(k connKey) bool {
+	t, ok := conntrackd.state[k]
+	if !ok {
+		return false
+	}
+	delete(conntrackd.state, ipTranslationToConnKey(k.transport, t))
+	delete(conntrackd.state, k)
+	return true
+}
+
+func (conntrackd *conntrackdConntracker) Describe() network.ConntrackDescription {
+	return withLayer(conntrackd.Conntracker.Describe(), "conntrackd")
+}
+
+func (conntrackd *conntrackdConntracker) GetStats() Stats {
+	conntrackd.RLock()
+	size := len(conntrackd.state)
+	conntrackd.RUnlock()
+
+	s := conntrackd.Conntracker.GetStats()
+	s.set("conntrackd_state_size", int64(size))
+	s.set("conntrackd_hits_total", atomic.LoadInt64(&conntrackd.stats.hits))
+	s.set("conntrackd_messages_total", atomic.LoadInt64(&conntrackd.stats.messages))
+	s.set("conntrackd_messages_invalid", atomic.LoadInt64(&conntrackd.stats.messagesInvalid))
+	s.set("conntrackd_conns_dropped", atomic.LoadInt64(&conntrackd.stats.connsDropped))
+	return s
+}
+
+// DumpCachedTable returns the translations held by the wrapped Conntracker, followed by the replicated ones
+func (conntrackd *conntrackdConntracker) DumpCachedTable() []network.DebugConntrackEntry {
+	entries := conntrackd.Conntracker.DumpCachedTable()
+
+	conntrackd.RLock()
+	defer conntrackd.RUnlock()
+	for k, t := range conntrackd.state {
+		entries = append(entries, debugConntrackEntry(k, t))
+	}
+	return entries
+}
+
+func (conntrackd *conntrackdConntracker) Close() {
+	close(conntrackd.exit)
+	_ = conntrackd.conn.Close()
+	<-conntrackd.done
+	conntrackd.Conntracker.Close()
+}
+
+// run reads the datagrams of conntrackd until the listener is closed
+func (conntrackd *conntrackdConntracker) run() {
+	defer close(conntrackd.done)
+
+	buf := make([]byte, conntrackdMaxDatagramSize)
+	for {
+		n, err := conntrackd.conn.Read(buf)
+		if err != nil {
+			select {
+			case <-conntrackd.exit:
+			default:
+				log.Errorf("stopped listening to the conntrackd sync messages: %s", err)
+			}
+			return
+		}
+		conntrackd.handle(buf[:n])
+	}
+}
+
+// handle applies the conntrack entries of a datagram to the cache
+func (conntrackd *conntrackdConntracker) handle(b []byte) {
+	conntrackd.Lock()
+	defer conntrackd.Unlock()
+
+	for len(b) > 0 {
+		m, n, ok := parseConntrackdMessage(b)
+		if n == 0 {
+			atomic.AddInt64(&conntrackd.stats.messagesInvalid, 1)
+			return
+		}
+		b = b[n:]
+		atomic.AddInt64(&conntrackd.stats.messages, 1)
+		if ok {
+			conntrackd.apply(m)
+		}
+	}
+}
+
+// apply stores or deletes the translation of a replicated conntrack entry. It must be called with the write lock held.
+func (conntrackd *conntrackdConntracker) apply(m conntrackdMessage) {
+	// the translation of the entry is replaced, or the entry isn't translated anymore
+	if m.typ == conntrackdCtDelete || m.reply == reversedKey(m.origin) {
+		conntrackd.deleteTranslation(m.origin)
+		return
+	}
+
+	if _, ok := conntrackd.state[m.origin]; !ok && len(conntrackd.state) >= conntrackd.maxStateSize {
+		atomic.AddInt64(&conntrackd.stats.connsDropped, 1)
+		return
+	}
+	conntrackd.deleteTranslation(m.origin)
+	conntrackd.state[m.origin] = replyTranslation(m.origin, m.reply)
+	conntrackd.state[m.reply] = replyTranslation(m.reply, m.origin)
+}
@@ -0,0 +1,180 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// conntrackdAttr encodes an attribute of a conntrackd sync message
+func conntrackdAttr(typ uint16, data []byte) []byte {
+	b := make([]byte, conntrackdAttrHeaderLen, conntrackdAligned(conntrackdAttrHeaderLen+len(data), 1<<16))
+	binary.BigEndian.PutUint16(b[0:2], uint16(conntrackdAttrHeaderLen+len(data)))
+	binary.BigEndian.PutUint16(b[2:4], typ)
+	b = append(b, data...)
+	return b[:cap(b)]
+}
+
+// conntrackdMessageBytes encodes a conntrackd sync message of the given type and attributes
+func conntrackdMessageBytes(typ uint8, attrs ...[]byte) []byte {
+	b := make([]byte, conntrackdHeaderLen)
+	b[0] = conntrackdProtocolVersion<<4 | typ
+	for _, attr := range attrs {
+		b = append(b, attr...)
+	}
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b
+}
+
+// conntrackdEntry encodes the sync message of a conntrack entry of the origin tuple, source NATed to snat:spat
+// when snat isn't empty, as written by a little-endian host
+func conntrackdEntry(typ uint8, proto uint8, src string, sport uint16, dst string, dport uint16, snat string, spat uint16) []byte {
+	ips := append(net.ParseIP(src).To4(), net.ParseIP(dst).To4()...)
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports[0:2], sport)
+	binary.BigEndian.PutUint16(ports[2:4], dport)
+	attrs := [][]byte{
+		conntrackdAttr(conntrackdAttrIPv4, ips),
+		conntrackdAttr(conntrackdAttrL4Proto, []byte{proto}),
+		conntrackdAttr(conntrackdAttrPort, ports),
+	}
+	if snat != "" {
+		ip := net.ParseIP(snat).To4()
+		port := make([]byte, 2)
+		binary.LittleEndian.PutUint16(port, spat)
+		attrs = append(attrs,
+			conntrackdAttr(conntrackdAttrSNATIPv4, []byte{ip[3], ip[2], ip[1], ip[0]}),
+			conntrackdAttr(conntrackdAttrSPATPort, port),
+		)
+	}
+	return conntrackdMessageBytes(typ, attrs...)
+}
+
+func TestParseConntrackdMessage(t *testing.T) {
+	// 10.0.1.5:40000 -> 1.1.1.1:443 masqueraded to 192.168.0.10:61000
+	b := conntrackdEntry(conntrackdCtNew, 6, "10.0.1.5", 40000, "1.1.1.1", 443, "192.168.0.10", 61000)
+	m, n, ok := parseConntrackdMessage(b)
+	require.True(t, ok)
+	assert.Equal(t, len(b), n)
+	assert.Equal(t, conntrackdCtNew, m.typ)
+	assert.Equal(t, connKey{srcIP: keyAddrFromString("10.0.1.5"), srcPort: 40000, dstIP: keyAddrFromString("1.1.1.1"), dstPort: 443, transport: network.TCP}, m.origin)
+	assert.Equal(t, connKey{srcIP: keyAddrFromString("1.1.1.1"), srcPort: 443, dstIP: keyAddrFromString("192.168.0.10"), dstPort: 61000, transport: network.TCP}, m.reply)
+
+	// fd00::5:5000 -> fd00::10:53 translated to fd00::1:5:53
+	ips := append([]byte{}, net.ParseIP("fd00::5")...)
+	ips = append(ips, net.ParseIP("fd00::10")...)
+	b = conntrackdMessageBytes(conntrackdCtUpdate,
+		conntrackdAttr(conntrackdAttrIPv6, ips),
+		conntrackdAttr(conntrackdAttrL4Proto, []byte{17}),
+		conntrackdAttr(conntrackdAttrPort, []byte{0x13, 0x88, 0, 53}),
+		conntrackdAttr(conntrackdAttrDNATIPv6, net.ParseIP("fd00::1:5")),
+	)
+	m, _, ok = parseConntrackdMessage(b)
+	require.True(t, ok)
+	assert.Equal(t, connKey{srcIP: keyAddrFromString("fd00::1:5"), srcPort: 53, dstIP: keyAddrFromString("fd00::5"), dstPort: 5000, transport: network.UDP}, m.reply)
+
+	// the expectations and the control messages are skipped, like the protocols which aren't tracked
+	for _, b := range [][]byte{
+		conntrackdMessageBytes(conntrackdCtDelete + 1),
+		conntrackdEntry(conntrackdCtNew, 1, "10.0.1.5", 0, "1.1.1.1", 0, "", 0),
+		conntrackdMessageBytes(conntrackdCtNew, conntrackdAttr(conntrackdAttrL4Proto, []byte{6})),
+	} {
+		_, n, ok = parseConntrackdMessage(b)
+		assert.False(t, ok)
+		assert.Equal(t, len(b), n)
+	}
+
+	// the malformed datagrams aren't parsed any further
+	b = conntrackdEntry(conntrackdCtNew, 6, "10.0.1.5", 40000, "1.1.1.1", 443, "", 0)
+	_, n, _ = parseConntrackdMessage(b[:len(b)-4])
+	assert.Zero(t, n)
+	b[0] = 2 << 4
+	_, n, _ = parseConntrackdMessage(b)
+	assert.Zero(t, n)
+}
+
+func TestConntrackdConntracker(t *testing.T) {
+	ctr, err := newConntrackdConntracker(NewNoOpConntracker(), &Config{MaxStateSize: 4, ConntrackdAddress: "127.0.0.1:0"})
+	require.NoError(t, err)
+	defer ctr.Close()
+
+	sender, err := net.DialUDP("udp", nil, ctr.conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer sender.Close()
+
+	send := func(messages ...[]byte) {
+		var datagram []byte
+		for _, m := range messages {
+			datagram = append(datagram, m...)
+		}
+		_, err := sender.Write(datagram)
+		require.NoError(t, err)
+	}
+	stat := func(name string) func() bool {
+		total := ctr.GetStats().Extra[name]
+		return func() bool { return ctr.GetStats().Extra[name] > total }
+	}
+
+	c := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.1.5"),
+		SPort:  40000,
+		Dest:   util.AddressFromString("1.1.1.1"),
+		DPort:  443,
+		Type:   network.TCP,
+	}
+	handled := stat("conntrackd_messages_total")
+	send(
+		conntrackdEntry(conntrackdCtNew, 6, "10.0.1.5", 40000, "1.1.1.1", 443, "192.168.0.10", 61000),
+		// the connections which aren't translated aren't stored
+		conntrackdEntry(conntrackdCtNew, 6, "10.0.1.6", 40000, "1.1.1.1", 443, "", 0),
+	)
+	require.Eventually(t, handled, time.Second, time.Millisecond)
+
+	trans := ctr.GetTranslationForConn(c)
+	require.NotNil(t, trans)
+	assert.Equal(t, util.AddressFromString("192.168.0.10"), trans.ReplDstIP)
+	assert.Equal(t, uint16(61000), trans.ReplDstPort)
+	assert.Equal(t, network.SNAT, trans.NATType)
+	assert.Equal(t, []*network.IPTranslation{trans}, ctr.GetTranslationsForConns([]network.ConnectionStats{c}))
+	assert.Len(t, ctr.DumpCachedTable(), 2)
+	assert.Equal(t, []string{"conntrackd"}, ctr.Describe().Layers)
+
+	// the translation is updated, and then deleted
+	handled = stat("conntrackd_messages_total")
+	send(conntrackdEntry(conntrackdCtUpdate, 6, "10.0.1.5", 40000, "1.1.1.1", 443, "192.168.0.11", 61000))
+	require.Eventually(t, handled, time.Second, time.Millisecond)
+	assert.Equal(t, util.AddressFromString("192.168.0.11"), ctr.GetTranslationForConn(c).ReplDstIP)
+	assert.Len(t, ctr.DumpCachedTable(), 2)
+
+	handled = stat("conntrackd_messages_total")
+	send(conntrackdEntry(conntrackdCtDelete, 6, "10.0.1.5", 40000, "1.1.1.1", 443, "", 0))
+	require.Eventually(t, handled, time.Second, time.Millisecond)
+	assert.Nil(t, ctr.GetTranslationForConn(c))
+	assert.Empty(t, ctr.DumpCachedTable())
+
+	// the closed connections are deleted, and the cache is capped
+	handled = stat("conntrackd_messages_total")
+	send(
+		conntrackdEntry(conntrackdCtNew, 6, "10.0.1.5", 40000, "1.1.1.1", 443, "192.168.0.10", 61000),
+		conntrackdEntry(conntrackdCtNew, 6, "10.0.1.5", 40001, "1.1.1.1", 443, "192.168.0.10", 61001),
+		conntrackdEntry(conntrackdCtNew, 6, "10.0.1.5", 40002, "1.1.1.1", 443, "192.168.0.10", 61002),
+	)
+	require.Eventually(t, handled, time.Second, time.Millisecond)
+	assert.Equal(t, int64(1), ctr.GetStats().Extra["conntrackd_conns_dropped"])
+	ctr.DeleteTranslation(c)
+	assert.Nil(t, ctr.GetTranslationForConn(c))
+	assert.Len(t, ctr.DumpCachedTable(), 2)
+
+	invalid := stat("conntrackd_messages_invalid")
+	send([]byte{0xff})
+	require.Eventually(t, invalid, time.Second, time.Millisecond)
+}
@@ -4,7 +4,11 @@
 package netlink
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,355 +16,2510 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/network"
 	"github.com/DataDog/datadog-agent/pkg/process/util"
 	"github.com/DataDog/datadog-agent/pkg/util/log"
-	ct "github.com/florianl/go-conntrack"
 	"golang.org/x/sys/unix"
 )
 
 const (
+	// initializationTimeout is how long the initialization of the conntracker is waited for by default, before
+	// it is retried in the background
 	initializationTimeout = time.Second * 10
 
-	compactInterval = time.Minute
-)
+	// overflowRecoveryInterval is the minimum amount of time between two re-dumps triggered by socket overflows
+	overflowRecoveryInterval = 30 * time.Second
 
-// Conntracker is a wrapper around go-conntracker that keeps a record of all connections in user space
-type Conntracker interface {
-	GetTranslationForConn(network.ConnectionStats) *network.IPTranslation
-	DeleteTranslation(network.ConnectionStats)
-	GetStats() map[string]int64
-	Close()
-}
+	// natRulesRefreshInterval is the interval at which the NAT rules of the host are read again
+	natRulesRefreshInterval = time.Minute
 
-type connKey struct {
-	srcIP   util.Address
-	srcPort uint16
+	// maxNATChainLength is the maximum number of NAT stages followed when resolving a translation
+	maxNATChainLength = 4
 
-	dstIP   util.Address
-	dstPort uint16
+	// nearCapacityPct is the fill percentage of the state map above which the destroy events are processed
+	// ahead of the other events, so the space they free can be used by the new entries
+	nearCapacityPct = 90
 
-	// the transport protocol of the connection, using the same values as specified in the agent payload.
-	transport network.ConnectionType
-}
+	// maxLoadWorkers bounds the number of goroutines decoding the dumps of the conntrack table loaded at startup
+	maxLoadWorkers = 8
+
+	// maxPrioritizedEvents is the maximum number of queued events whose destroy events are processed first
+	maxPrioritizedEvents = outputBuffer
+
+	// conntrackHealthName is the name the readiness of the conntracker is reported under
+	conntrackHealthName = "system-probe-conntrack"
+
+	// healthInterval is the interval at which the readiness check is reported healthy, which must be shorter
+	// than the interval the health checks are run at
+	healthInterval = 5 * time.Second
+
+	// eventStallTimeout is how long the processing of an event can take before it is considered stalled
+	eventStallTimeout = 30 * time.Second
+
+	// publishInterval is the interval at which a copy of the state map is published for lock-free reads,
+	// when the state map was modified
+	publishInterval = time.Second
+
+	// telemetryInterval is the interval at which the stats are reported to the agent telemetry
+	telemetryInterval = 10 * time.Second
+)
 
 type realConntracker struct {
 	sync.RWMutex
 	consumer *Consumer
-	state    map[connKey]*network.IPTranslation
+	procRoot string
+	state    *connTable
+	// clock is the source of time of the conntracker and its expectation tracker, replaced in tests
+	clock clock
+
+	// published is a read-only copy of the state map (*publication), read without the lock.
+	// stale is set when the state map is modified, until it is published again, so lookups missing from the
+	// published copy only take the lock when the state map may have the translation.
+	published     atomic.Value
+	stale         int32
+	publishTicker ticker
+
+	// snapshotPath is where the cache is persisted across restarts. Empty when persistence is disabled.
+	snapshotPath string
+
+	// tcpStates holds the state of the TCP connections of the state map, by origin key, nil when it
+	// isn't tracked
+	tcpStates map[connKey]TCPState
+
+	// counters holds the counters of the connections of the state map, by origin key, from the point of view of
+	// their origin. It is nil when they aren't tracked.
+	counters map[connKey]Counters
+
+	// prioritizeDestroys is set when destroy events are subscribed to, so they are processed ahead of the other
+	// events when the state map is near capacity
+	prioritizeDestroys bool
+
+	// udpCloseHints notifies the subscribers about the UDP connections destroyed, translated or not
+	udpCloseHints bool
 
-	// The maximum size the state map will grow before we reject new entries
+	// coalescer skips the update events which wouldn't change the state map, nil unless update events are subscribed to
+	coalescer *updateCoalescer
+
+	// queue hands the events over to a writer goroutine registering them, so the reads of the event socket don't
+	// wait for the lock. It is nil when the events are registered as they are read.
+	queue *registrationQueue
+
+	// expectations resolves the translations of the connections expected by conntrack helpers, nil when disabled
+	expectations *expectationTracker
+
+	// misses queries the kernel conntrack table for the connections missing from the cache, nil when disabled
+	misses *missQuerier
+	// negatives holds the connections recently found without translation, so they aren't looked up again
+	// with the lock held, nor queried again. It is nil unless the misses are queried and NegativeLookupTTL is set.
+	negatives *negativeCache
+
+	// startTimes holds the creation time (in nanoseconds since the epoch) of the connections of the state map,
+	// by origin key, nil when it isn't tracked
+	startTimes map[connKey]uint64
+
+	// statuses holds the status flags of the connections of the state map, by origin key, nil when they aren't tracked
+	statuses map[connKey]Status
+
+	// ids holds the id conntrack assigned to the connections of the state map, by origin key, so the DESTROY events
+	// of a connection don't delete the entries of a newer connection recycling its tuple. The connections without
+	// id aren't held.
+	ids map[connKey]uint32
+
+	// The maximum number of connections of the state map, beyond which new connections are rejected
 	maxStateSize int
+	// stateFull is set once a connection was rejected for lack of room in the state map. The new connection events
+	// aren't decoded then, since they would be rejected too, until connections are removed.
+	stateFull int32
+
+	// health is the readiness check of the event stream, unhealthy when the events stop being processed,
+	// because their processing is stalled, the event socket can't be re-created or the stream ended.
+	// It is nil when the readiness isn't registered, and in tests.
+	health       Readiness
+	healthTicker ticker
+	// processingSince is when the processing of the current event started, 0 while waiting for events
+	processingSince int64
+	eventsEnded     int32
+
+	// telemetry is reported the typed stats at each tick of telemetryTicker, it is nil when they aren't reported
+	telemetry       StatsReporter
+	telemetryTicker ticker
+
+	// sourceQuota caps the connections stored for each source, it is nil when they aren't capped
+	sourceQuota *sourceQuota
+
+	// natGateways counts the source NATed connections of the state map by the address they are NATed to
+	natGateways *natGateways
+
+	// ttls expires the entries kept longer than the retention of their protocol, it is nil when they are kept
+	// until they are destroyed. expireTicker checks them for expiration.
+	ttls         *entryTTLs
+	expireTicker ticker
+
+	// resyncTicker is nil when the periodic re-sync with the kernel conntrack table is disabled
+	resyncTicker ticker
+	// compactTicker is nil when the state map is compacted by the deletions rather than periodically
+	compactTicker ticker
+	// resyncLock ensures only one dump of the kernel conntrack table is reconciled at a time
+	resyncLock sync.Mutex
+
+	// bootstrapping is set while the initial dump of the conntrack table is loaded in the background, in which
+	// case bootstrapDestroyed holds the keys destroyed in the meantime, so the dump doesn't bring them back
+	bootstrapping      int32
+	bootstrapDestroyed map[connKey]struct{}
+	// pending buffers the live events while the initial dump is loaded otherwise, nil when there is no such dump
+	pending *pendingEvents
+	// dumpTimeout is how long the initial dump of each address family is loaded for, 0 when it isn't bounded
+	dumpTimeout time.Duration
+
+	// flushes detects the flushes of the conntrack table, after which the cache is rebuilt. flushTicker checks
+	// nf_conntrack_count, it is nil when the events are replayed.
+	flushes     *flushDetector
+	flushTicker ticker
+
+	// natRules holds the NAT rules of the host entries are filtered with, nil when they aren't filtered
+	natRules atomic.Value
+	// natRulesTicker is nil when entries aren't filtered with the NAT rules of the host
+	natRulesTicker ticker
 
-	compactTicker *time.Ticker
-	stats         struct {
+	// netnsWatcher reports the network namespaces created and removed after startup, it is nil unless
+	// the conntrack entries of all the namespaces are tracked. netnsTicker polls it.
+	netnsWatcher *netnsWatcher
+	netnsTicker  ticker
+
+	// cpuBreaker pauses the event processing when it uses too much CPU, nil when its CPU usage isn't limited.
+	// cpuBreakerTicker dumps the conntrack table while the event processing is paused.
+	cpuBreaker       *cpuBreaker
+	cpuBreakerTicker ticker
+
+	// cidrFilter is nil when entries aren't filtered by address
+	cidrFilter *cidrFilter
+	// tracer logs what happens to the entries of the traced tuples, it is nil when none is traced
+	tracer *tupleTracer
+	// skipUnreplied skips the entries whose connection didn't see any reply yet, until their reply is reported
+	skipUnreplied bool
+	// skipUnassured skips the entries conntrack didn't mark assured yet, until they are
+	skipUnassured bool
+	// local holds the entries translated to or from loopback and link-local addresses, which are kept out of
+	// the state map. It is nil when they are stored like the others.
+	local *localEntries
+	// portFilter is applied when decoding entries, it is nil when they aren't filtered by port
+	portFilter *portFilter
+	// zoneFilter is applied when decoding entries, it is nil when they aren't filtered by conntrack zone.
+	// entryZones holds the zone each connection of the state map was stored from, by origin key, when they are.
+	zoneFilter *zoneFilter
+	entryZones map[connKey]uint16
+
+	// decodeErrors counts the entries and attributes which couldn't be decoded
+	decodeErrors decodeErrors
+	// caps are the optional attributes the kernel sends, nil when replaying the events of another kernel
+	caps *kernelCapabilities
+
+	// nsids maps the namespace ids of the entries to the inodes their keys hold, nil when only the root
+	// namespace is tracked
+	nsids *netnsIDs
+
+	stats struct {
 		gets                 int64
+		getHits              int64
 		getTimeTotal         int64
 		registers            int64
-		registersDropped     int64
+		registersNonNAT      int64
+		registersFiltered    int64
+		registersStateFull   int64
+		registersZone        int64
+		registersHairpin     int64
+		registersNAT64       int64
+		registersUnreplied   int64
+		registersUnassured   int64
+		registersLocal       int64
 		registersTotalTime   int64
 		unregisters          int64
 		unregistersTotalTime int64
+		resyncs              int64
+		resyncOrphans        int64
+		resyncMissing        int64
+		resyncDivergence     int64
+		refreshes            int64
+		chainsResolved       int64
+		overflowRecoveries   int64
+		destroys             int64
+		publishes            int64
+		publishTimeTotal     int64
+		expirations          int64
+		destroysPrioritized  int64
+		destroysRecycled     int64
+		newEventsSkipped     int64
+		udpCloseHints        int64
+		flushes              int64
+		dumpTimeouts         int64
 	}
 	exceededSizeLogLimit *util.LogLimit
+
+	// classStats breaks the registrations, drops and state size down by protocol and address family
+	classStats classStats
+
+	// notifier is notified whenever an entry of the state map changes
+	notifier translationNotifier
 }
 
-// NewConntracker creates a new conntracker with a short term buffer capped at the given size
-func NewConntracker(procRoot string, maxStateSize, targetRateLimit int, listenAllNamespaces bool) (Conntracker, error) {
-	var (
-		err         error
-		conntracker Conntracker
-	)
+// NewConntracker creates a new conntracker with a short term buffer capped at the given size.
+// When conntrack can't be initialized in time, the initialization is retried in the background.
+func NewConntracker(cfg *Config) (Conntracker, error) {
+	// replayed events don't need conntrack to be available
+	if cfg.ReplayPath == "" {
+		if err := checkConntrackAvailable(cfg.ProcRoot, cfg.Modprobe); err != nil {
+			return nil, err
+		}
+	}
+
+	// the filters are invalid for good, so they aren't part of the attempts retried
+	filter, err := newCIDRFilter(cfg.IncludeCIDRs, cfg.ExcludeCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	ports, err := newPortFilter(cfg.IncludePorts, cfg.ExcludePorts)
+	if err != nil {
+		return nil, err
+	}
+	zones, err := newZoneFilter(cfg.Zones)
+	if err != nil {
+		return nil, err
+	}
+	tracer, err := newTupleTracer(cfg.TraceTuples)
+	if err != nil {
+		return nil, err
+	}
 
-	done := make(chan struct{})
+	attempt := func() (Conntracker, error) {
+		return newConntrackerOnce(cfg, filter, ports, zones, tracer)
+	}
 
+	done := make(chan initResult, 1)
 	go func() {
-		conntracker, err = newConntrackerOnce(procRoot, maxStateSize, targetRateLimit, listenAllNamespaces)
-		done <- struct{}{}
+		c, err := attempt()
+		done <- initResult{c, err}
 	}()
 
+	timeout := initializationTimeout
+	if cfg.InitTimeout > 0 {
+		timeout = cfg.InitTimeout
+	}
+	policy := newRetryPolicy(cfg)
 	select {
-	case <-done:
-		return conntracker, err
-	case <-time.After(initializationTimeout):
-		return nil, fmt.Errorf("could not initialize conntrack after: %s", initializationTimeout)
+	case res := <-done:
+		if res.err == nil || !policy.retries() {
+			return res.conntracker, res.err
+		}
+		log.Warnf("could not initialize conntrack, retrying in the background: %s", res.err)
+		return newRetryingConntracker(nil, attempt, policy, registerReadiness(cfg, conntrackHealthName)), nil
+	case <-time.After(timeout):
+		if !policy.retries() {
+			go closeWhenDone(done)
+			return nil, fmt.Errorf("could not initialize conntrack after %s", timeout)
+		}
+		log.Warnf("could not initialize conntrack after %s, retrying in the background", timeout)
+		return newRetryingConntracker(done, attempt, policy, registerReadiness(cfg, conntrackHealthName)), nil
 	}
 }
 
-func newConntrackerOnce(procRoot string, maxStateSize, targetRateLimit int, listenAllNamespaces bool) (Conntracker, error) {
-	consumer, err := NewConsumer(procRoot, targetRateLimit, listenAllNamespaces)
+func newConntrackerOnce(cfg *Config, filter *cidrFilter, ports *portFilter, zones *zoneFilter, tracer *tupleTracer) (Conntracker, error) {
+	consumer, err := NewConsumer(cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	maxStateSize := cfg.MaxStateSize
+	if cfg.AutoMaxStateSize {
+		maxStateSize = autoMaxStateSize(cfg.ProcRoot, cfg.MaxStateSize)
+	}
+
+	clk := realClock{}
 	ctr := &realConntracker{
 		consumer:             consumer,
-		compactTicker:        time.NewTicker(compactInterval),
-		state:                make(map[connKey]*network.IPTranslation),
+		clock:                clk,
+		nsids:                consumer.nsids,
+		procRoot:             cfg.ProcRoot,
+		snapshotPath:         cfg.SnapshotPath,
+		publishTicker:        clk.NewTicker(publishInterval),
+		state:                newStateTable(maxStateSize, cfg.ArenaMinStateSize),
+		ids:                  make(map[connKey]uint32),
+		ttls:                 newEntryTTLs(cfg.TCPEntryTTL, cfg.UDPEntryTTL),
 		maxStateSize:         maxStateSize,
+		natGateways:          newNATGateways(),
+		sourceQuota:          newSourceQuota(cfg.MaxEntriesPerSource, cfg.QuotaPerNamespace),
+		cidrFilter:           filter,
+		portFilter:           ports,
+		zoneFilter:           zones,
+		tracer:               tracer,
+		local:                newLocalEntries(cfg.SkipLocalReplies),
+		queue:                newRegistrationQueue(cfg.RegisterQueueSize),
+		dumpTimeout:          cfg.DumpTimeout,
+		telemetry:            cfg.StatsReporter,
+		telemetryTicker:      clk.NewTicker(telemetryInterval),
 		exceededSizeLogLimit: util.NewLogLimit(10, time.Minute*10),
 	}
 
-	ctr.loadInitialState(consumer.DumpTable(unix.AF_INET))
-	ctr.loadInitialState(consumer.DumpTable(unix.AF_INET6))
-	ctr.run()
-	log.Infof("initialized conntrack with target_rate_limit=%d messages/sec", targetRateLimit)
-	return ctr, nil
-}
-
-func (ctr *realConntracker) GetTranslationForConn(c network.ConnectionStats) *network.IPTranslation {
-	then := time.Now().UnixNano()
-
-	ctr.RLock()
-	defer ctr.RUnlock()
+	if cfg.RegisterReadiness != nil {
+		ctr.health = cfg.RegisterReadiness(conntrackHealthName)
+		ctr.healthTicker = clk.NewTicker(healthInterval)
+	}
 
-	k := connKey{
-		srcIP:     c.Source,
-		srcPort:   c.SPort,
-		dstIP:     c.Dest,
-		dstPort:   c.DPort,
-		transport: c.Type,
+	if cfg.ReplayPath == "" {
+		ctr.caps = probeKernelCapabilities(cfg.ProcRoot)
+		ctr.flushes = newFlushDetector(filepath.Join(cfg.ProcRoot, kernelConntrackSysctls["kernel_conntrack_count"]))
+		ctr.flushTicker = ctr.clock.NewTicker(flushCheckInterval)
+	} else {
+		ctr.flushes = newFlushDetector("")
 	}
 
-	result := ctr.state[k]
+	if cfg.ResyncInterval > 0 {
+		ctr.resyncTicker = ctr.clock.NewTicker(cfg.ResyncInterval)
+	}
 
-	now := time.Now().UnixNano()
-	atomic.AddInt64(&ctr.stats.gets, 1)
-	atomic.AddInt64(&ctr.stats.getTimeTotal, now-then)
-	return result
-}
+	compaction := parseCompactionStrategy(cfg.CompactionStrategy)
+	deferCompaction := cfg.CompactInterval > 0 && compaction != compactOff
+	ctr.state.setCompaction(compaction, deferCompaction)
+	if deferCompaction {
+		ctr.compactTicker = ctr.clock.NewTicker(cfg.CompactInterval)
+	}
 
-func (ctr *realConntracker) GetStats() map[string]int64 {
-	// only a few stats are locked
-	ctr.RLock()
-	size := len(ctr.state)
-	ctr.RUnlock()
+	if ctr.ttls != nil {
+		ctr.expireTicker = ctr.clock.NewTicker(ctr.ttls.interval())
+	}
 
-	m := map[string]int64{
-		"state_size": int64(size),
+	if zones != nil {
+		ctr.entryZones = make(map[connKey]uint16)
 	}
 
-	if ctr.stats.gets != 0 {
-		m["gets_total"] = ctr.stats.gets
-		m["nanoseconds_per_get"] = ctr.stats.getTimeTotal / ctr.stats.gets
+	if cfg.TrackTCPState {
+		ctr.tcpStates = make(map[connKey]TCPState)
 	}
-	if ctr.stats.registers != 0 {
-		m["registers_total"] = ctr.stats.registers
-		m["registers_dropped"] = ctr.stats.registersDropped
-		m["nanoseconds_per_register"] = ctr.stats.registersTotalTime / ctr.stats.registers
+
+	if cfg.TrackCounters {
+		if !ctr.caps.available(attrCounters) {
+			log.Warnf("nf_conntrack_acct is disabled, conntrack entries won't have any counters")
+		}
+		ctr.counters = make(map[connKey]Counters)
 	}
-	if ctr.stats.unregisters != 0 {
-		m["unregisters_total"] = ctr.stats.unregisters
-		m["nanoseconds_per_unregister"] = ctr.stats.unregistersTotalTime / ctr.stats.unregisters
+
+	if cfg.TrackStartTimes {
+		if !ctr.caps.available(attrTimestamp) {
+			log.Warnf("the kernel doesn't record the creation time of the conntrack entries, they won't have any start time: it requires kernel 3.2+ with nf_conntrack_timestamp enabled")
+		}
+		ctr.startTimes = make(map[connKey]uint64)
 	}
 
-	// Merge telemetry from the consumer
-	for k, v := range ctr.consumer.GetStats() {
-		m[k] = v
+	if cfg.TrackStatus {
+		ctr.statuses = make(map[connKey]Status)
 	}
 
-	return m
-}
+	ctr.prioritizeDestroys = consumer.subscribes(netlinkCtDestroy)
+	ctr.udpCloseHints = cfg.UDPCloseHints
+	if consumer.subscribes(netlinkCtUpdate) {
+		ctr.coalescer = newUpdateCoalescer(maxStateSize, cfg.TrackTCPState, cfg.TrackCounters, cfg.TrackStartTimes, cfg.TrackStatus)
+	}
 
-func (ctr *realConntracker) DeleteTranslation(c network.ConnectionStats) {
-	then := time.Now().UnixNano()
-	defer func() {
-		atomic.AddInt64(&ctr.stats.unregistersTotalTime, time.Now().UnixNano()-then)
-	}()
+	// new entries are reported before their connection sees any reply, which is only reported by update events
+	if cfg.SkipUnreplied && !consumer.subscribes(netlinkCtUpdate) {
+		log.Warnf("unreplied conntrack entries can only be skipped when the \"update\" netlink group is subscribed to, they won't be skipped")
+	} else {
+		ctr.skipUnreplied = cfg.SkipUnreplied
+	}
 
-	ctr.Lock()
-	defer ctr.Unlock()
+	// the entries are reported before conntrack marks them assured, which is only reported by update events
+	if cfg.SkipUnassured && !consumer.subscribes(netlinkCtUpdate) {
+		log.Warnf("unassured conntrack entries can only be skipped when the \"update\" netlink group is subscribed to, they won't be skipped")
+	} else {
+		ctr.skipUnassured = cfg.SkipUnassured
+	}
 
-	keys := []connKey{
-		{
-			srcIP:     c.Source,
-			srcPort:   c.SPort,
-			dstIP:     c.Dest,
-			dstPort:   c.DPort,
-			transport: c.Type,
-		},
-		{
-			srcIP:     c.Dest,
-			srcPort:   c.DPort,
-			dstIP:     c.Source,
-			dstPort:   c.SPort,
-			transport: c.Type,
-		},
+	if cfg.TrackExpectations {
+		if ctr.expectations, err = newExpectationTracker(cfg.ProcRoot, maxStateSize, ctr.clock); err != nil {
+			log.Warnf("could not track conntrack expectations, the connections created by conntrack helpers may not be translated: %s", err)
+		}
 	}
 
-	deleteTrans := func(k connKey) bool {
-		t, ok := ctr.state[k]
-		if !ok {
-			log.Tracef("not deleting %+v from conntrack", k)
-			return false
+	// replayed events may come from another kernel, whose conntrack table can't be queried
+	if cfg.ReplayPath == "" {
+		if ctr.misses, err = newKernelMissQuerier(cfg.ProcRoot, cfg.MissQueryRate); err != nil {
+			log.Warnf("could not query the conntrack table, the connections missing from the cache won't be looked up in it: %s", err)
 		}
+	}
+	if ctr.misses != nil {
+		ctr.negatives = newNegativeCache(cfg.NegativeLookupTTL, cfg.MissQueryRate)
+	}
 
-		delete(ctr.state, k)
-		delete(ctr.state, ipTranslationToConnKey(k.transport, t))
-		log.Tracef("deleted %+v from conntrack", k)
-		return true
+	if ctr.cpuBreaker = newCPUBreaker(cfg.CPUBreakerBudget); ctr.cpuBreaker != nil {
+		ctr.cpuBreakerTicker = ctr.clock.NewTicker(cpuBreakerDumpInterval)
 	}
 
-	for _, k := range keys {
-		if ok := deleteTrans(k); ok {
-			atomic.AddInt64(&ctr.stats.unregisters, 1)
-			break
+	if cfg.NATRuleFilter {
+		ctr.refreshNATRules()
+		ctr.natRulesTicker = ctr.clock.NewTicker(natRulesRefreshInterval)
+	}
+
+	// namespaces are listed before the initial dump, so none of the namespaces created afterwards is missed
+	if cfg.ListenAllNamespaces {
+		if ctr.netnsWatcher, err = newNetnsWatcher(cfg.ProcRoot); err != nil {
+			log.Warnf("could not list network namespaces, the conntrack table of the namespaces created from now on won't be dumped: %s", err)
+		} else {
+			ctr.netnsTicker = ctr.clock.NewTicker(netnsPollInterval)
 		}
 	}
+
+	restored := ctr.restoreSnapshot()
+	lazy := !restored && cfg.LazyInit
+	if lazy {
+		ctr.bootstrapping = 1
+		ctr.bootstrapDestroyed = make(map[connKey]struct{})
+	} else if !restored {
+		ctr.pending = newPendingEvents(maxStateSize)
+	}
+	ctr.run()
+	if ctr.pending != nil {
+		ctr.loadInitialDump()
+	}
+	if restored {
+		// The snapshot may have diverged from the kernel table while system-probe was down
+		go ctr.resync()
+	}
+	if lazy {
+		go ctr.bootstrap()
+	}
+	log.Infof("initialized conntrack with target_rate_limit=%d messages/sec", cfg.TargetRateLimit)
+	return ctr, nil
 }
 
-func (ctr *realConntracker) Close() {
-	ctr.consumer.Stop()
-	ctr.compactTicker.Stop()
-	ctr.exceededSizeLogLimit.Close()
+func (ctr *realConntracker) GetTranslationForConn(c network.ConnectionStats) *network.IPTranslation {
+	then := ctr.clock.Now().UnixNano()
+
+	// staleness is checked first, so a miss isn't trusted when the state map was modified in between
+	stale := ctr.isStale()
+	k := connStatsToNSConnKey(c)
+	result := ctr.lookupPublished(k)
+	negative := result == nil && ctr.negatives.has(k, ctr.clock.Now())
+	if result == nil && stale && !negative {
+		ctr.RLock()
+		result = ctr.lookupOrCacheMiss(k)
+		ctr.RUnlock()
+	}
+	if result == nil {
+		result = ctr.getExpectedTranslation(k)
+	}
+	if result == nil && !negative {
+		result = ctr.queryMiss(k)
+	}
+	ctr.tracer.traceLookup(k, result)
+
+	now := ctr.clock.Now().UnixNano()
+	atomic.AddInt64(&ctr.stats.gets, 1)
+	if result != nil {
+		atomic.AddInt64(&ctr.stats.getHits, 1)
+	}
+	atomic.AddInt64(&ctr.stats.getTimeTotal, now-then)
+	return result
 }
 
-func (ctr *realConntracker) loadInitialState(events <-chan Event) {
-	for e := range events {
-		conns := DecodeAndReleaseEvent(e)
-		for _, c := range conns {
-			if len(ctr.state) < ctr.maxStateSize && isNAT(c) {
-				log.Tracef("%s", c)
-				if k, ok := formatKey(c.Origin); ok {
-					ctr.state[k] = formatIPTranslation(c.Reply)
-				}
-				if k, ok := formatKey(c.Reply); ok {
-					ctr.state[k] = formatIPTranslation(c.Origin)
-				}
+// GetTranslationsForConns returns the translations of the given connections, in the same order,
+// taking the lock only once
+func (ctr *realConntracker) GetTranslationsForConns(conns []network.ConnectionStats) []*network.IPTranslation {
+	then := ctr.clock.Now().UnixNano()
+	translations := make([]*network.IPTranslation, len(conns))
+
+	stale := ctr.isStale()
+	// negatives holds the connections missing from the published state which are cached without translation
+	var missed, negatives []bool
+	for i := range conns {
+		translations[i] = ctr.lookupPublished(connStatsToNSConnKey(conns[i]))
+		if translations[i] == nil && missed == nil {
+			missed, negatives = make([]bool, len(conns)), make([]bool, len(conns))
+		}
+		if translations[i] == nil {
+			missed[i] = true
+			negatives[i] = ctr.negatives.has(connStatsToNSConnKey(conns[i]), time.Unix(0, then))
+		}
+	}
+	if missed != nil && stale {
+		ctr.RLock()
+		for i := range conns {
+			if missed[i] && !negatives[i] {
+				translations[i] = ctr.lookupOrCacheMiss(connStatsToNSConnKey(conns[i]))
 			}
 		}
+		ctr.RUnlock()
 	}
-}
-
-// register is registered to be called whenever a conntrack update/create is called.
-// it will keep being called until it returns nonzero.
-func (ctr *realConntracker) register(c Con) int {
-	// don't bother storing if the connection is not NAT
-	if !isNAT(c) {
-		atomic.AddInt64(&ctr.stats.registersDropped, 1)
-		return 0
+	if missed != nil {
+		for i := range conns {
+			if translations[i] == nil {
+				translations[i] = ctr.getExpectedTranslation(connStatsToNSConnKey(conns[i]))
+			}
+			if translations[i] == nil && !negatives[i] {
+				translations[i] = ctr.queryMiss(connStatsToNSConnKey(conns[i]))
+			}
+		}
 	}
-
-	now := time.Now().UnixNano()
-	registerTuple := func(keyTuple, transTuple *ct.IPTuple) {
-		key, ok := formatKey(keyTuple)
-		if !ok {
-			return
+	if ctr.tracer != nil {
+		for i := range conns {
+			ctr.tracer.traceLookup(connStatsToNSConnKey(conns[i]), translations[i])
 		}
+	}
 
-		if len(ctr.state) >= ctr.maxStateSize {
-			ctr.logExceededSize()
-			return
+	now := ctr.clock.Now().UnixNano()
+	atomic.AddInt64(&ctr.stats.gets, int64(len(conns)))
+	atomic.AddInt64(&ctr.stats.getHits, countHits(translations))
+	atomic.AddInt64(&ctr.stats.getTimeTotal, now-then)
+	return translations
+}
+
+// GetEntryForConn returns both directions of the translation of a connection, along with the TCP state, counters,
+// start time and status flags conntrack has for it, all looked up at once
+func (ctr *realConntracker) GetEntryForConn(c network.ConnectionStats) (e *ConntrackEntry) {
+	then := ctr.clock.Now().UnixNano()
+	defer func() {
+		atomic.AddInt64(&ctr.stats.gets, 1)
+		if e != nil {
+			atomic.AddInt64(&ctr.stats.getHits, 1)
+		}
+		atomic.AddInt64(&ctr.stats.getTimeTotal, ctr.clock.Now().UnixNano()-then)
+		if ctr.tracer != nil {
+			var t *network.IPTranslation
+			if e != nil {
+				t = e.Translation
+			}
+			ctr.tracer.traceLookup(connStatsToNSConnKey(c), t)
 		}
+	}()
 
-		ctr.state[key] = formatIPTranslation(transTuple)
+	ctr.RLock()
+	defer ctr.RUnlock()
+	t, k, ok := lookupNamespace(ctr.state, connStatsToNSConnKey(c))
+	if !ok {
+		if t := ctr.getExpectedTranslation(connStatsToNSConnKey(c)); t != nil {
+			return newConntrackEntry(c, t)
+		}
+		return nil
 	}
 
-	log.Tracef("%s", c)
+	e = newConntrackEntry(c, ctr.resolveChain(ctr.state, k, t).translation())
+	origin, _, reversed, _ := ctr.state.find(k)
+	if ctr.tcpStates != nil && c.Type == network.TCP {
+		e.TCPState = ctr.tcpStates[origin]
+	}
+	if counters, ok := ctr.counters[origin]; ok {
+		if reversed {
+			counters = counters.reversed()
+		}
+		e.Counters = counters
+	}
+	if start, ok := ctr.startTimes[origin]; ok {
+		e.StartTime = time.Unix(0, int64(start))
+	}
+	e.Status = ctr.statuses[origin]
+	e.ID = ctr.ids[origin]
+	return e
+}
 
-	ctr.Lock()
-	defer ctr.Unlock()
-	registerTuple(c.Origin, c.Reply)
-	registerTuple(c.Reply, c.Origin)
-	then := time.Now()
-	atomic.AddInt64(&ctr.stats.registers, 1)
-	atomic.AddInt64(&ctr.stats.registersTotalTime, then.UnixNano()-now)
+// lookupPublished looks a translation up in the published state, without the lock.
+// The published state may miss the translations registered since it was published, at most publishInterval ago,
+// so a miss must be looked up again in the state map while it is stale. The translations deleted or modified since
+// it was published are dropped from it, so they miss as well rather than being returned out of date, including
+// when they were only looked up to resolve a NAT chain.
+// Staleness must be checked before the lookup, since publishing clears it.
+func (ctr *realConntracker) lookupPublished(k connKey) *network.IPTranslation {
+	p, _ := ctr.published.Load().(*publication)
+	if p == nil {
+		return nil
+	}
+	if p.dropCount() == 0 {
+		return ctr.lookup(p.state, k)
+	}
+	r := &publicationReader{p: p}
+	if result := ctr.lookup(r, k); !r.dropped {
+		return result
+	}
+	return nil
+}
 
-	return 0
+// dropPublished drops the given keys from the published state, before their translation is deleted or modified in
+// the state map. It must be called with the write lock held.
+func (ctr *realConntracker) dropPublished(keys ...connKey) {
+	if p, _ := ctr.published.Load().(*publication); p != nil {
+		p.drop(keys...)
+	}
 }
 
-func (ctr *realConntracker) logExceededSize() {
-	if ctr.exceededSizeLogLimit.ShouldLog() {
-		log.Warnf("exceeded maximum conntrack state size: %d entries. You may need to increase system_probe_config.conntrack_max_state_size (will log first ten times, and then once every 10 minutes)", ctr.maxStateSize)
+// lookup looks a translation up in the given state, which must either be the published state,
+// or the state map with the read lock held. It returns a copy of the translation.
+func (ctr *realConntracker) lookup(state translationReader, k connKey) *network.IPTranslation {
+	if result, k, ok := lookupNamespace(state, k); ok {
+		return ctr.resolveChain(state, k, result).translation()
 	}
+	return nil
 }
 
-func (ctr *realConntracker) run() {
-	go func() {
-		events := ctr.consumer.Events()
-		for e := range events {
-			conns := DecodeAndReleaseEvent(e)
-			for _, c := range conns {
-				ctr.register(c)
-			}
-		}
-	}()
+// lookupNamespace returns the translation of the given key in its network namespace, or else in the root namespace,
+// whose entries translate the connections of all the namespaces routed through the host. The key found is
+// returned along with it.
+func lookupNamespace(state translationReader, k connKey) (translationValue, connKey, bool) {
+	if t, ok := state.get(k); ok || k.netns == 0 {
+		return t, k, ok
+	}
+	k.netns = 0
+	t, ok := state.get(k)
+	return t, k, ok
+}
 
-	go func() {
-		for range ctr.compactTicker.C {
-			ctr.compact()
-		}
-	}()
+// lookupConn returns the origin key of the connection of the given key, looked up in its network namespace and then
+// in the root namespace, and whether the given key is its reply key. It must be called with the lock held.
+func (ctr *realConntracker) lookupConn(k connKey) (origin connKey, reversed bool, ok bool) {
+	origin, _, reversed, ok = ctr.state.find(k)
+	if ok || k.netns == 0 {
+		return origin, reversed, ok
+	}
+	k.netns = 0
+	origin, _, reversed, ok = ctr.state.find(k)
+	return origin, reversed, ok
 }
 
-func (ctr *realConntracker) compact() {
-	ctr.Lock()
-	defer ctr.Unlock()
+// getExpectedTranslation returns the translation of a connection conntrack doesn't know about yet,
+// when it is expected by a conntrack helper
+func (ctr *realConntracker) getExpectedTranslation(k connKey) *network.IPTranslation {
+	if ctr.expectations != nil {
+		return ctr.expectations.get(k)
+	}
+	return nil
+}
 
-	// https://github.com/golang/go/issues/20135
-	copied := make(map[connKey]*network.IPTranslation, len(ctr.state))
-	for k, v := range ctr.state {
-		copied[k] = v
+// lookupOrCacheMiss looks a translation up in the state map, which must be read-locked, and caches the connection
+// as having no translation when it misses
+func (ctr *realConntracker) lookupOrCacheMiss(k connKey) *network.IPTranslation {
+	result := ctr.lookup(ctr.state, k)
+	if result == nil {
+		ctr.negatives.add(k, ctr.clock.Now())
 	}
-	ctr.state = copied
+	return result
 }
 
-func isNAT(c Con) bool {
-	if c.Origin == nil ||
-		c.Reply == nil ||
-		c.Origin.Proto == nil ||
-		c.Reply.Proto == nil ||
-		c.Origin.Proto.SrcPort == nil ||
-		c.Origin.Proto.DstPort == nil ||
-		c.Reply.Proto.SrcPort == nil ||
-		c.Reply.Proto.DstPort == nil {
-		return false
+// queryMiss looks the connection of a key missing from the cache up in the kernel conntrack table, unless the
+// queries are rate-limited. The entry found is registered like the entries of the events, so the next lookups
+// find it in the cache, while a connection without translation is cached as such.
+// The connections cached without translation mustn't be queried.
+func (ctr *realConntracker) queryMiss(k connKey) *network.IPTranslation {
+	if !ctr.misses.allow(ctr.clock.Now()) {
+		return nil
+	}
+	if c, ok := ctr.misses.query(k); ok {
+		ctr.tracer.traceCon(&c, "queried after a cache miss")
+		ctr.register(c)
 	}
 
-	return !(*c.Origin.Src).Equal(*c.Reply.Dst) ||
-		!(*c.Origin.Dst).Equal(*c.Reply.Src) ||
-		*c.Origin.Proto.SrcPort != *c.Reply.Proto.DstPort ||
-		*c.Origin.Proto.DstPort != *c.Reply.Proto.SrcPort
+	ctr.RLock()
+	defer ctr.RUnlock()
+	return ctr.lookupOrCacheMiss(k)
 }
 
-func formatIPTranslation(tuple *ct.IPTuple) *network.IPTranslation {
-	srcIP := *tuple.Src
-	dstIP := *tuple.Dst
+// Subscribe returns a channel notified when the translations matching the given filter are created or deleted
+func (ctr *realConntracker) Subscribe(filter TranslationFilter) (<-chan TranslationEvent, func()) {
+	return ctr.notifier.subscribe(filter)
+}
 
-	srcPort := *tuple.Proto.SrcPort
-	dstPort := *tuple.Proto.DstPort
+// GetTCPStateForConn returns the state conntrack has for a translated TCP connection.
+// TCPStateNone is returned when TCP states aren't tracked, or the connection isn't translated.
+func (ctr *realConntracker) GetTCPStateForConn(c network.ConnectionStats) TCPState {
+	if ctr.tcpStates == nil || c.Type != network.TCP {
+		return TCPStateNone
+	}
 
-	return &network.IPTranslation{
-		ReplSrcIP:   util.AddressFromNetIP(srcIP),
-		ReplDstIP:   util.AddressFromNetIP(dstIP),
-		ReplSrcPort: srcPort,
-		ReplDstPort: dstPort,
+	ctr.RLock()
+	defer ctr.RUnlock()
+	if origin, _, ok := ctr.lookupConn(connStatsToNSConnKey(c)); ok {
+		return ctr.tcpStates[origin]
 	}
+	return TCPStateNone
 }
 
-func formatKey(tuple *ct.IPTuple) (k connKey, ok bool) {
-	ok = true
-	k.srcIP = util.AddressFromNetIP(*tuple.Src)
-	k.dstIP = util.AddressFromNetIP(*tuple.Dst)
-	k.srcPort = *tuple.Proto.SrcPort
-	k.dstPort = *tuple.Proto.DstPort
+// GetTCPStatesForConns returns the states conntrack has for the given connections, in the same order, from a
+// single lock of the state. TCPStateNone is returned for the ones which aren't translated TCP connections.
+func (ctr *realConntracker) GetTCPStatesForConns(conns []network.ConnectionStats) []TCPState {
+	states := make([]TCPState, len(conns))
+	if ctr.tcpStates == nil {
+		return states
+	}
 
-	proto := *tuple.Proto.Number
-	switch proto {
-	case unix.IPPROTO_TCP:
-		k.transport = network.TCP
-	case unix.IPPROTO_UDP:
-		k.transport = network.UDP
-	default:
-		ok = false
+	ctr.RLock()
+	defer ctr.RUnlock()
+	for i := range conns {
+		if conns[i].Type != network.TCP {
+			continue
+		}
+		if origin, _, ok := ctr.lookupConn(connStatsToNSConnKey(conns[i])); ok {
+			states[i] = ctr.tcpStates[origin]
+		}
 	}
+	return states
+}
 
-	return
+// GetCountersForConn returns the counters conntrack has for a translated connection.
+// false is returned when counters aren't tracked, or the connection isn't translated.
+func (ctr *realConntracker) GetCountersForConn(c network.ConnectionStats) (Counters, bool) {
+	if ctr.counters == nil {
+		return Counters{}, false
+	}
+
+	ctr.RLock()
+	defer ctr.RUnlock()
+	origin, reversed, ok := ctr.lookupConn(connStatsToNSConnKey(c))
+	if !ok {
+		return Counters{}, false
+	}
+	counters, ok := ctr.counters[origin]
+	if reversed {
+		counters = counters.reversed()
+	}
+	return counters, ok
 }
 
-func ipTranslationToConnKey(proto network.ConnectionType, t *network.IPTranslation) connKey {
-	return connKey{
-		srcIP:     t.ReplSrcIP,
-		dstIP:     t.ReplDstIP,
-		srcPort:   t.ReplSrcPort,
-		dstPort:   t.ReplDstPort,
-		transport: proto,
+// GetStartTimeForConn returns the time conntrack started tracking a translated connection.
+// false is returned when start times aren't tracked, or the connection isn't translated.
+func (ctr *realConntracker) GetStartTimeForConn(c network.ConnectionStats) (time.Time, bool) {
+	if ctr.startTimes == nil {
+		return time.Time{}, false
+	}
+
+	ctr.RLock()
+	defer ctr.RUnlock()
+	origin, _, ok := ctr.lookupConn(connStatsToNSConnKey(c))
+	if !ok {
+		return time.Time{}, false
 	}
+	start, ok := ctr.startTimes[origin]
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(start)), true
+}
+
+// GetStatusForConn returns the status flags conntrack has for a translated connection.
+// 0 is returned when they aren't tracked, or the connection isn't translated.
+func (ctr *realConntracker) GetStatusForConn(c network.ConnectionStats) Status {
+	if ctr.statuses == nil {
+		return 0
+	}
+
+	ctr.RLock()
+	defer ctr.RUnlock()
+	if origin, _, ok := ctr.lookupConn(connStatsToNSConnKey(c)); ok {
+		return ctr.statuses[origin]
+	}
+	return 0
+}
+
+// typedStats returns the stats every conntracker has, which are cheap enough to be reported to the telemetry
+func (ctr *realConntracker) typedStats() Stats {
+	ctr.RLock()
+	size := ctr.state.len()
+	ctr.RUnlock()
+
+	return Stats{
+		StateSize:        int64(size),
+		Gets:             atomic.LoadInt64(&ctr.stats.gets),
+		GetHits:          atomic.LoadInt64(&ctr.stats.getHits),
+		Registers:        atomic.LoadInt64(&ctr.stats.registers),
+		RegistersDropped: ctr.getDropStats()["registers_dropped"],
+		Unregisters:      atomic.LoadInt64(&ctr.stats.unregisters),
+		SamplingPercent:  atomic.LoadInt64(&ctr.consumer.samplingPct),
+	}
+}
+
+func (ctr *realConntracker) GetStats() Stats {
+	s := ctr.typedStats()
+	sizes := ctr.getStateSizes()
+	m := map[string]int64{
+		"state_bytes_estimate": estimateStateMemory(sizes),
+	}
+	if ctr.tcpStates != nil {
+		m["tcp_states"] = int64(sizes.tcpStates)
+	}
+	if ctr.counters != nil {
+		m["counters"] = int64(sizes.counters)
+	}
+	if ctr.startTimes != nil {
+		m["start_times"] = int64(sizes.startTimes)
+	}
+	if ctr.statuses != nil {
+		m["statuses"] = int64(sizes.statuses)
+	}
+	m["ids"] = int64(sizes.ids)
+	ctr.RLock()
+	m["nat_gateways"] = int64(ctr.natGateways.len())
+	if ctr.sourceQuota != nil {
+		m["quota_sources"] = int64(ctr.sourceQuota.sources())
+	}
+	if ctr.local != nil {
+		m["local_entries"] = int64(ctr.local.len())
+	}
+	ctr.RUnlock()
+
+	if ctr.stats.gets != 0 {
+		m["nanoseconds_per_get"] = ctr.stats.getTimeTotal / ctr.stats.gets
+	}
+	if ctr.stats.registers != 0 {
+		m["registers_filtered"] = atomic.LoadInt64(&ctr.stats.registersFiltered)
+		m["registers_hairpin"] = atomic.LoadInt64(&ctr.stats.registersHairpin)
+		m["registers_nat64"] = atomic.LoadInt64(&ctr.stats.registersNAT64)
+		m["nanoseconds_per_register"] = ctr.stats.registersTotalTime / ctr.stats.registers
+	}
+	// the total of the drops is typed
+	for k, v := range ctr.getDropStats() {
+		if k != "registers_dropped" {
+			m[k] = v
+		}
+	}
+	for k, v := range ctr.classStats.getStats() {
+		m[k] = v
+	}
+	for k, v := range ctr.caps.getStats() {
+		m[k] = v
+	}
+	for k, v := range ctr.decodeErrors.getStats() {
+		m[k] = v
+	}
+	if ctr.stats.unregisters != 0 {
+		m["nanoseconds_per_unregister"] = ctr.stats.unregistersTotalTime / ctr.stats.unregisters
+	}
+
+	if ctr.stats.resyncs != 0 {
+		m["resyncs_total"] = atomic.LoadInt64(&ctr.stats.resyncs)
+		m["resync_orphans_removed"] = atomic.LoadInt64(&ctr.stats.resyncOrphans)
+		m["resync_missing_added"] = atomic.LoadInt64(&ctr.stats.resyncMissing)
+		m["resync_divergence"] = atomic.LoadInt64(&ctr.stats.resyncDivergence)
+		m["refreshes_total"] = atomic.LoadInt64(&ctr.stats.refreshes)
+	}
+
+	if ctr.stats.chainsResolved != 0 {
+		m["nat_chains_resolved"] = atomic.LoadInt64(&ctr.stats.chainsResolved)
+	}
+
+	m["bootstrap_in_progress"] = int64(atomic.LoadInt32(&ctr.bootstrapping))
+	m["state_table_slots"] = int64(sizes.slots)
+	m["reply_index_slots"] = int64(sizes.indexSlots)
+	m["state_table_grows"] = sizes.grows
+	m["compactions_total"] = sizes.shrinks
+	if ctr.ttls != nil {
+		m["expirations_total"] = atomic.LoadInt64(&ctr.stats.expirations)
+	}
+
+	if ctr.stats.destroys != 0 {
+		m["destroys_total"] = atomic.LoadInt64(&ctr.stats.destroys)
+		m["destroys_prioritized"] = atomic.LoadInt64(&ctr.stats.destroysPrioritized)
+		m["destroys_recycled"] = atomic.LoadInt64(&ctr.stats.destroysRecycled)
+	}
+	if ctr.udpCloseHints {
+		m["udp_close_hints"] = atomic.LoadInt64(&ctr.stats.udpCloseHints)
+	}
+
+	if publishes := atomic.LoadInt64(&ctr.stats.publishes); publishes != 0 {
+		m["publishes_total"] = publishes
+		m["nanoseconds_per_publish"] = atomic.LoadInt64(&ctr.stats.publishTimeTotal) / publishes
+		m["published_keys_dropped"] = int64(sizes.publishedDrops)
+	}
+
+	if ctr.portFilter != nil {
+		m["ports_filtered"] = atomic.LoadInt64(&ctr.portFilter.filtered)
+	}
+	if ctr.zoneFilter != nil {
+		m["zones_filtered"] = atomic.LoadInt64(&ctr.zoneFilter.filtered)
+	}
+
+	if ctr.stats.overflowRecoveries != 0 {
+		m["overflow_recoveries"] = atomic.LoadInt64(&ctr.stats.overflowRecoveries)
+	}
+	m["table_flushes_detected"] = atomic.LoadInt64(&ctr.stats.flushes)
+	if ctr.dumpTimeout > 0 {
+		m["initial_dump_timeouts"] = atomic.LoadInt64(&ctr.stats.dumpTimeouts)
+	}
+
+	if ctr.netnsWatcher != nil {
+		for k, v := range ctr.netnsWatcher.getStats() {
+			m[k] = v
+		}
+	}
+
+	if ctr.cpuBreaker != nil {
+		for k, v := range ctr.cpuBreaker.getStats() {
+			m[k] = v
+		}
+	}
+
+	if ctr.coalescer != nil {
+		for k, v := range ctr.coalescer.getStats() {
+			m[k] = v
+		}
+	}
+
+	if ctr.queue != nil {
+		for k, v := range ctr.queue.getStats() {
+			m[k] = v
+		}
+	}
+
+	if ctr.pending != nil {
+		for k, v := range ctr.pending.getStats() {
+			m[k] = v
+		}
+	}
+
+	if ctr.expectations != nil {
+		for k, v := range ctr.expectations.getStats() {
+			m[k] = v
+		}
+	}
+
+	if ctr.misses != nil {
+		for k, v := range ctr.misses.getStats() {
+			m[k] = v
+		}
+	}
+	m["negative_lookups"] = int64(ctr.negatives.len())
+
+	for k, v := range ctr.notifier.getStats() {
+		m[k] = v
+	}
+
+	// Merge telemetry from the consumer
+	for k, v := range ctr.consumer.GetStats() {
+		m[k] = v
+	}
+
+	// Merge the counters of the kernel conntrack table, so we can tell when the table itself is under pressure
+	for k, v := range GetKernelConntrackStats(ctr.procRoot) {
+		m[k] = v
+	}
+
+	s.Extra = m
+	return s
+}
+
+// getStateSizes returns the numbers of connections of the state map and of the entries of the maps kept along with it.
+// Only these stats are locked.
+func (ctr *realConntracker) getStateSizes() stateSizes {
+	published, _ := ctr.published.Load().(*publication)
+
+	ctr.RLock()
+	defer ctr.RUnlock()
+	slots, indexSlots := ctr.state.capacity()
+	sizes := stateSizes{
+		entries:    ctr.state.len(),
+		slots:      slots,
+		indexSlots: indexSlots,
+		grows:      ctr.state.conns.grows,
+		shrinks:    ctr.state.conns.shrinks,
+		tcpStates:  len(ctr.tcpStates),
+		counters:   len(ctr.counters),
+		startTimes: len(ctr.startTimes),
+		statuses:   len(ctr.statuses),
+		ids:        len(ctr.ids),
+		ttls:       ctr.ttls.len(),
+	}
+	if published != nil {
+		sizes.publishedSlots, sizes.publishedIndexSlots = published.state.unsharedCapacity(ctr.state)
+		sizes.publishedDrops = published.dropCount()
+	}
+	return sizes
+}
+
+// getDropStats breaks the conntrack entries which weren't stored down by reason, so a cache too small
+// can be told apart from filters too loose. registers_dropped is the total of all the reasons.
+func (ctr *realConntracker) getDropStats() map[string]int64 {
+	m := map[string]int64{
+		"registers_dropped_non_nat":      atomic.LoadInt64(&ctr.stats.registersNonNAT),
+		"registers_dropped_state_full":   atomic.LoadInt64(&ctr.stats.registersStateFull),
+		"registers_dropped_decode_error": atomic.LoadInt64(&ctr.decodeErrors.messages),
+		"registers_dropped_filtered":     atomic.LoadInt64(&ctr.stats.registersFiltered),
+		"registers_dropped_backpressure": atomic.LoadInt64(&ctr.stats.newEventsSkipped),
+	}
+	if ctr.sourceQuota != nil {
+		m["registers_dropped_quota"] = ctr.sourceQuota.exceededCount()
+	}
+	if ctr.skipUnreplied {
+		m["registers_dropped_unreplied"] = atomic.LoadInt64(&ctr.stats.registersUnreplied)
+	}
+	if ctr.skipUnassured {
+		m["registers_dropped_unassured"] = atomic.LoadInt64(&ctr.stats.registersUnassured)
+	}
+	if ctr.queue != nil {
+		m["registers_dropped_queue_full"] = atomic.LoadInt64(&ctr.queue.droppedRegisters)
+	}
+	if ctr.local != nil {
+		m["registers_dropped_local"] = atomic.LoadInt64(&ctr.stats.registersLocal)
+	}
+	if ctr.portFilter != nil {
+		m["registers_dropped_filtered"] += atomic.LoadInt64(&ctr.portFilter.filtered)
+	}
+	if ctr.zoneFilter != nil {
+		m["registers_dropped_filtered"] += atomic.LoadInt64(&ctr.zoneFilter.filtered)
+		m["registers_dropped_zone"] = atomic.LoadInt64(&ctr.stats.registersZone)
+	}
+
+	var total int64
+	for _, v := range m {
+		total += v
+	}
+	m["registers_dropped"] = total
+	return m
+}
+
+func (ctr *realConntracker) DeleteTranslation(c network.ConnectionStats) {
+	then := ctr.clock.Now().UnixNano()
+	defer func() {
+		atomic.AddInt64(&ctr.stats.unregistersTotalTime, ctr.clock.Now().UnixNano()-then)
+	}()
+
+	ctr.Lock()
+	defer ctr.Unlock()
+
+	ctr.deleteTranslation(c)
+}
+
+// DeleteTranslations removes the translations of the given connections, taking the lock only once
+func (ctr *realConntracker) DeleteTranslations(conns []network.ConnectionStats) {
+	then := ctr.clock.Now().UnixNano()
+	defer func() {
+		atomic.AddInt64(&ctr.stats.unregistersTotalTime, ctr.clock.Now().UnixNano()-then)
+	}()
+
+	ctr.Lock()
+	defer ctr.Unlock()
+
+	for i := range conns {
+		ctr.deleteTranslation(conns[i])
+	}
+}
+
+// deleteTranslation must be called with the write lock held. The entries of the network namespace of the connection
+// are looked up before the entries of the root namespace, the same way translations are.
+func (ctr *realConntracker) deleteTranslation(c network.ConnectionStats) {
+	keys := []connKey{
+		{
+			srcIP:     newKeyAddr(c.Source),
+			srcPort:   c.SPort,
+			dstIP:     newKeyAddr(c.Dest),
+			dstPort:   c.DPort,
+			transport: c.Type,
+			netns:     c.NetNS,
+		},
+		{
+			srcIP:     newKeyAddr(c.Dest),
+			srcPort:   c.DPort,
+			dstIP:     newKeyAddr(c.Source),
+			dstPort:   c.SPort,
+			transport: c.Type,
+			netns:     c.NetNS,
+		},
+	}
+	if c.NetNS != 0 {
+		for _, k := range keys[:2] {
+			k.netns = 0
+			keys = append(keys, k)
+		}
+	}
+
+	deleteTrans := func(k connKey) bool {
+		origin, t, reversed, ok := ctr.state.find(k)
+		if !ok {
+			log.Tracef("not deleting %+v from conntrack", k)
+			return false
+		}
+
+		// both ends of hairpin connections are local, so the translation of the other end is kept for its own
+		// connection
+		switch {
+		case isHairpinEntry(origin, t) && reversed:
+			atomic.StoreInt32(&ctr.stale, 1)
+			ctr.dropPublished(k)
+			ctr.state.unindex(origin, t)
+		case isHairpinEntry(origin, t) && ctr.state.indexed(origin, t):
+			ctr.reverseEntry(origin, t)
+		default:
+			ctr.deleteEntry(origin)
+		}
+		log.Tracef("deleted %+v from conntrack", k)
+		return true
+	}
+
+	for _, k := range keys {
+		if ok := deleteTrans(k); ok {
+			atomic.AddInt64(&ctr.stats.unregisters, 1)
+			break
+		}
+	}
+	for _, k := range keys {
+		if ctr.local.remove(k) {
+			break
+		}
+	}
+}
+
+// DumpCachedTable returns a snapshot of all translations currently held in the cache, one per connection, along with
+// the local entries kept out of it, when they are
+func (ctr *realConntracker) DumpCachedTable() []network.DebugConntrackEntry {
+	ctr.RLock()
+	defer ctr.RUnlock()
+
+	entries := make([]network.DebugConntrackEntry, 0, ctr.state.len()+ctr.local.len())
+	ctr.state.forEach(func(k connKey, t translationValue) bool {
+		entries = append(entries, debugConntrackEntry(k, t.translation()))
+		return true
+	})
+	return append(entries, ctr.local.dump()...)
+}
+
+// GetNATGateways returns the addresses the connections of the state map are source NATed to
+func (ctr *realConntracker) GetNATGateways() []util.Address {
+	ctr.RLock()
+	defer ctr.RUnlock()
+	return ctr.natGateways.addresses()
+}
+
+// GetRecentErrors returns the last errors the consumer ran into
+func (ctr *realConntracker) GetRecentErrors() []string {
+	return ctr.consumer.RecentErrors()
+}
+
+// SetTargetRateLimit changes the maximum number of conntrack events per second read off the netlink socket
+func (ctr *realConntracker) SetTargetRateLimit(limit int) {
+	ctr.consumer.SetTargetRateLimit(limit)
+}
+
+// Describe returns the netlink backend, which is loading until the initial dump of the conntrack table is loaded
+func (ctr *realConntracker) Describe() network.ConntrackDescription {
+	status := "ready"
+	if atomic.LoadInt32(&ctr.bootstrapping) == 1 {
+		status = "loading"
+	}
+	storage := "table"
+	if ctr.state.preallocated() {
+		storage = "arena"
+	}
+	return network.ConntrackDescription{
+		Backend:             "netlink",
+		Status:              status,
+		TargetRateLimit:     int(atomic.LoadInt64(&ctr.consumer.targetRateLimit)),
+		MaxStateSize:        ctr.maxStateSize,
+		Storage:             storage,
+		ListenAllNamespaces: ctr.consumer.listenAllNamespaces,
+		NetlinkGroups:       ctr.consumer.groupNames(),
+	}
+}
+
+func (ctr *realConntracker) Close() {
+	ctr.consumer.Stop()
+	ctr.publishTicker.Stop()
+	ctr.telemetryTicker.Stop()
+	if ctr.resyncTicker != nil {
+		ctr.resyncTicker.Stop()
+	}
+	if ctr.compactTicker != nil {
+		ctr.compactTicker.Stop()
+	}
+	if ctr.natRulesTicker != nil {
+		ctr.natRulesTicker.Stop()
+	}
+	if ctr.expireTicker != nil {
+		ctr.expireTicker.Stop()
+	}
+	if ctr.cpuBreakerTicker != nil {
+		ctr.cpuBreakerTicker.Stop()
+	}
+	if ctr.netnsTicker != nil {
+		ctr.netnsTicker.Stop()
+	}
+	if ctr.flushTicker != nil {
+		ctr.flushTicker.Stop()
+	}
+	if ctr.expectations != nil {
+		ctr.expectations.close()
+	}
+	ctr.misses.close()
+	if ctr.health != nil {
+		ctr.healthTicker.Stop()
+		_ = ctr.health.Deregister()
+	}
+	ctr.exceededSizeLogLimit.Close()
+	ctr.saveSnapshot()
+}
+
+// saveSnapshot persists the cache so the next system-probe run can restore it instead of dumping the conntrack table
+func (ctr *realConntracker) saveSnapshot() {
+	if ctr.snapshotPath == "" {
+		return
+	}
+
+	ctr.RLock()
+	defer ctr.RUnlock()
+	if err := saveSnapshot(ctr.snapshotPath, readBootID(ctr.procRoot), ctr.state); err != nil {
+		log.Warnf("could not save conntrack snapshot to %s: %s", ctr.snapshotPath, err)
+		return
+	}
+	log.Infof("saved %d conntrack connections to %s", ctr.state.len(), ctr.snapshotPath)
+}
+
+// restoreSnapshot loads the cache persisted by the previous system-probe run, if any
+func (ctr *realConntracker) restoreSnapshot() bool {
+	if ctr.snapshotPath == "" {
+		return false
+	}
+
+	restored := 0
+	err := loadSnapshot(ctr.snapshotPath, readBootID(ctr.procRoot), func(origin connKey, t network.IPTranslation) {
+		if ctr.state.len() >= ctr.maxStateSize || !ctr.sourceQuota.allows(origin) {
+			return
+		}
+		if stored, _ := ctr.storeConn(origin, t, connData{}); stored {
+			restored++
+		}
+	})
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("could not restore conntrack snapshot from %s, dumping the conntrack table instead: %s", ctr.snapshotPath, err)
+		}
+		return false
+	}
+
+	log.Infof("restored %d conntrack connections from %s", restored, ctr.snapshotPath)
+	return true
+}
+
+// loadedConn is a connection decoded from a dump, along with what is tracked for it
+type loadedConn struct {
+	origin connKey
+	reply  connKey
+	trans  network.IPTranslation
+	data   connData
+}
+
+// loadInitialState stores the entries of a dump of the conntrack table. The events of the dump are decoded by
+// a pool of workers, each of them storing the entries of an event at once, so they seldom contend on the lock.
+func (ctr *realConntracker) loadInitialState(events <-chan Event) {
+	workers := runtime.NumCPU()
+	if workers > maxLoadWorkers {
+		workers = maxLoadWorkers
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			decoder := ctr.newDecoder()
+			var batch []loadedConn
+			collect := func(c *Con) {
+				if !isNAT(*c) || !ctr.matchesFilters(*c) || ctr.skipsUnreplied(*c) || ctr.skipsUnassured(*c) || ctr.setsAsideLocal(c, nil) {
+					return
+				}
+				log.Tracef("%s", c)
+				netns := ctr.nsids.inode(c.NetNS)
+				origin, ok := formatNSKey(netns, c.Origin)
+				reply, replyOK := formatNSKey(netns, c.Reply)
+				if ok && replyOK {
+					batch = append(batch, loadedConn{origin, reply, formatIPTranslation(c.Reply), newConnData(c)})
+				}
+			}
+
+			for e := range events {
+				batch = batch[:0]
+				decoder.DecodeAndReleaseEvent(e, collect)
+				ctr.load(batch)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// load stores the connections decoded from an event of a dump
+func (ctr *realConntracker) load(batch []loadedConn) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctr.Lock()
+	defer ctr.Unlock()
+	for _, e := range batch {
+		if ctr.state.len() >= ctr.maxStateSize {
+			return
+		}
+		if !ctr.sourceQuota.allows(e.origin) || !ctr.loadable(e.origin) || !ctr.loadable(e.reply) {
+			continue
+		}
+		ctr.storeConn(e.origin, e.trans, e.data)
+	}
+}
+
+// loadable returns whether a tuple of a dumped connection can be loaded. While the initial dump is loaded in the
+// background, the tuples registered or destroyed by the live events are more recent than the dump, so they are kept
+// as they are. It must be called with the write lock held.
+func (ctr *realConntracker) loadable(k connKey) bool {
+	if ctr.bootstrapDestroyed == nil {
+		return true
+	}
+	if ctr.state.contains(k) {
+		return false
+	}
+	_, destroyed := ctr.bootstrapDestroyed[k]
+	return !destroyed
+}
+
+// loadInitialDump loads the initial dump of the conntrack table while the live events are buffered, and applies them
+// once it is loaded, so the dump doesn't override them and they don't back up into the event socket in the meantime
+func (ctr *realConntracker) loadInitialDump() {
+	ctr.resyncLock.Lock()
+	defer ctr.resyncLock.Unlock()
+
+	complete := ctr.loadInitialFamilies()
+	if !ctr.applyPendingEvents() || !complete {
+		// the re-sync waits for the initial dump to be loaded
+		go ctr.resync()
+	}
+}
+
+// applyPendingEvents applies the events buffered during the initial dump, and returns false when some of them
+// were dropped, in which case the cache must be re-synced
+func (ctr *realConntracker) applyPendingEvents() bool {
+	if ctr.pending.apply(ctr.processEvent) {
+		log.Warnf("conntrack events were dropped while loading the initial conntrack table dump, re-syncing the cache")
+		return false
+	}
+	return true
+}
+
+// loadInitialFamilies loads the initial dumps of the IPv4 and IPv6 conntrack tables concurrently, each of them over
+// its own socket, so the startup of dual-stack hosts isn't held up by the two dumps in a row. It returns false when
+// one of them timed out before it was loaded entirely.
+func (ctr *realConntracker) loadInitialFamilies() bool {
+	var wg sync.WaitGroup
+	var ipv6Complete bool
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ipv6Complete = ctr.loadInitialFamily(unix.AF_INET6)
+	}()
+	ipv4Complete := ctr.loadInitialFamily(unix.AF_INET)
+	wg.Wait()
+	return ipv4Complete && ipv6Complete
+}
+
+// loadInitialFamily loads the initial dump of the conntrack table of the given address family, and returns false
+// when it timed out before it was loaded entirely
+func (ctr *realConntracker) loadInitialFamily(family uint8) bool {
+	events := ctr.consumer.DumpTable(family)
+	if ctr.dumpTimeout <= 0 {
+		ctr.loadInitialState(events)
+		return true
+	}
+
+	var timedOut bool
+	ctr.loadInitialState(ctr.withDumpTimeout(events, &timedOut))
+	if timedOut {
+		atomic.AddInt64(&ctr.stats.dumpTimeouts, 1)
+		log.Warnf("the initial dump of the conntrack table of address family %d timed out after %s, the entries left are loaded by a re-sync", family, ctr.dumpTimeout)
+	}
+	return !timedOut
+}
+
+// withDumpTimeout forwards the events of a dump until the dump timeout expires, after which timedOut is set and
+// the events left are released without being forwarded. timedOut can be read once the events forwarded end.
+func (ctr *realConntracker) withDumpTimeout(events <-chan Event, timedOut *bool) <-chan Event {
+	forwarded := make(chan Event)
+	go func() {
+		defer close(forwarded)
+		timeout := ctr.clock.After(ctr.dumpTimeout)
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				forwarded <- e
+			case <-timeout:
+				*timedOut = true
+				go func() {
+					for e := range events {
+						e.Done()
+					}
+				}()
+				return
+			}
+		}
+	}()
+	return forwarded
+}
+
+// bootstrap loads the initial dump of the conntrack table while the live events are already processed,
+// so translations are served before the dump of a large table completes
+func (ctr *realConntracker) bootstrap() {
+	ctr.resyncLock.Lock()
+	defer ctr.resyncLock.Unlock()
+
+	then := ctr.clock.Now()
+	complete := ctr.loadInitialFamilies()
+
+	ctr.Lock()
+	ctr.bootstrapDestroyed = nil
+	atomic.StoreInt32(&ctr.bootstrapping, 0)
+	ctr.Unlock()
+	log.Infof("loaded the initial conntrack table dump in %s", ctr.clock.Now().Sub(then))
+	if !complete {
+		go ctr.resync()
+	}
+}
+
+// refreshNamespaces dumps the conntrack table of the network namespaces created since the last refresh, since
+// the entries they had before the event socket started reporting them would otherwise never be registered.
+// The entries of removed namespaces are left behind, so the cache is re-synced to remove them.
+func (ctr *realConntracker) refreshNamespaces() {
+	added, removed, err := ctr.netnsWatcher.poll()
+	if err != nil {
+		log.Debugf("could not list network namespaces: %s", err)
+		return
+	}
+
+	decoder := ctr.newDecoder()
+	handle := func(c *Con) {
+		ctr.register(*c)
+	}
+	for _, pid := range added {
+		for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+			for e := range ctr.consumer.DumpNamespaceTable(family, pid) {
+				decoder.DecodeAndReleaseEvent(e, handle)
+			}
+		}
+	}
+
+	if removed > 0 {
+		ctr.resync()
+	}
+}
+
+// Refresh dumps the kernel conntrack table of the given address family and reconciles the cache with it,
+// the same way the periodic re-syncs do. It waits for the re-sync in progress, if any.
+// There is nothing to refresh for IPv6 when its entries are skipped.
+func (ctr *realConntracker) Refresh(family uint8) error {
+	switch {
+	case family != unix.AF_INET && family != unix.AF_INET6:
+		return fmt.Errorf("unsupported address family %d", family)
+	case family == unix.AF_INET6 && ctr.consumer.disableIPv6:
+		return nil
+	case ctr.consumer.replayPath != "":
+		return errors.New("the conntrack table can't be dumped while events are replayed")
+	case atomic.LoadInt32(&ctr.bootstrapping) == 1:
+		return errors.New("the initial conntrack dump is still being loaded")
+	}
+
+	atomic.AddInt64(&ctr.stats.refreshes, 1)
+	ctr.resyncFamilies(family)
+	return nil
+}
+
+// resync dumps the kernel conntrack table and reconciles the cache with it:
+// entries missing from the cache are added, and cached entries no longer present
+// in the kernel (orphans) are removed.
+func (ctr *realConntracker) resync() {
+	ctr.resyncFamilies(unix.AF_INET, unix.AF_INET6)
+}
+
+// resyncFamilies re-syncs the cache with the kernel conntrack table of the given address families
+func (ctr *realConntracker) resyncFamilies(families ...uint8) {
+	ctr.resyncLock.Lock()
+	defer ctr.resyncLock.Unlock()
+
+	// Only entries already cached before the dump starts can be considered orphans,
+	// since anything registered in the meantime may legitimately be absent from the dump.
+	// The entries of the families not dumped can't be either.
+	ctr.RLock()
+	before := make(map[connKey]struct{}, ctr.state.len())
+	ctr.state.forEach(func(k connKey, t translationValue) bool {
+		if inFamilies(k, t, families) {
+			before[k] = struct{}{}
+		}
+		return true
+	})
+	localBefore := ctr.local.keys(families)
+	ctr.RUnlock()
+
+	kernel := make(map[connKey]Con, len(before))
+	localSeen := make(map[connKey]struct{}, len(localBefore))
+	decoder := ctr.newDecoder()
+	collect := func(c *Con) {
+		if !isNAT(*c) || !ctr.matchesFilters(*c) || ctr.skipsUnreplied(*c) || ctr.skipsUnassured(*c) || ctr.setsAsideLocal(c, localSeen) {
+			return
+		}
+		netns := ctr.nsids.inode(c.NetNS)
+		if k, ok := formatNSKey(netns, c.Origin); ok {
+			kernel[k] = *c
+		}
+		if k, ok := formatNSKey(netns, c.Reply); ok {
+			kernel[k] = *c
+		}
+	}
+	for _, family := range families {
+		for e := range ctr.consumer.DumpTable(family) {
+			decoder.DecodeAndReleaseEvent(e, collect)
+		}
+	}
+
+	ctr.Lock()
+	orphans, missing := ctr.reconcile(before, kernel)
+	ctr.local.removeMissing(localBefore, localSeen)
+	ctr.Unlock()
+
+	atomic.AddInt64(&ctr.stats.resyncs, 1)
+	atomic.AddInt64(&ctr.stats.resyncOrphans, orphans)
+	atomic.AddInt64(&ctr.stats.resyncMissing, missing)
+	atomic.StoreInt64(&ctr.stats.resyncDivergence, orphans+missing)
+	log.Debugf("conntrack re-sync done: %d orphans removed, %d missing connections added", orphans, missing)
+}
+
+// inFamilies returns whether both the key and the translation of an entry are of one of the given address families.
+// The entries of NAT64 connections are of both families, so they are only dumped along with both.
+func inFamilies(k connKey, t translationValue, families []uint8) bool {
+	var key, trans bool
+	for _, family := range families {
+		key = key || k.srcIP.family() == family
+		trans = trans || t.replSrcIP.family() == family
+	}
+	return key && trans
+}
+
+// family returns the address family of the key address
+func (a keyAddr) family() uint8 {
+	if a.isIPv4() {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
+
+// reconcile merges the kernel view, the connections of the dump by the keys of both their directions, into the
+// state map. The connections are cached with their origin key, or with their reply key once the client end of
+// a hairpin connection was closed. The missing connections are stored the same way as the registered ones.
+// It must be called with the write lock held.
+func (ctr *realConntracker) reconcile(before map[connKey]struct{}, kernel map[connKey]Con) (orphans, missing int64) {
+	for k := range before {
+		if _, ok := kernel[k]; ok {
+			continue
+		}
+		if ctr.deleteEntry(k) {
+			orphans++
+		}
+	}
+
+	for k, c := range kernel {
+		// each connection is stored once, from the key of its origin direction
+		if origin, ok := formatNSKey(ctr.nsids.inode(c.NetNS), c.Origin); !ok || origin != k {
+			continue
+		}
+		if ctr.state.contains(k) {
+			continue
+		}
+		if ctr.state.len() >= ctr.maxStateSize {
+			ctr.logExceededSize()
+			break
+		}
+		if !ctr.admits(&c) {
+			continue
+		}
+		if stored, _ := ctr.store(&c); stored {
+			missing++
+		}
+	}
+
+	return orphans, missing
+}
+
+// register is registered to be called whenever a conntrack update/create is called.
+// it will keep being called until it returns nonzero.
+func (ctr *realConntracker) register(c Con) int {
+	// don't bother storing if the connection is not NAT
+	if !isNAT(c) {
+		atomic.AddInt64(&ctr.stats.registersNonNAT, 1)
+		ctr.classStats.dropped(&c)
+		ctr.tracer.traceCon(&c, "not registered, the connection isn't NAT'ed")
+		return 0
+	}
+	if !ctr.matchesFilters(c) {
+		atomic.AddInt64(&ctr.stats.registersFiltered, 1)
+		ctr.classStats.dropped(&c)
+		ctr.tracer.traceCon(&c, "not registered, filtered out")
+		return 0
+	}
+	if ctr.skipsUnreplied(c) {
+		ctr.classStats.dropped(&c)
+		ctr.tracer.traceCon(&c, "not registered, the connection didn't see any reply yet")
+		return 0
+	}
+	if ctr.skipsUnassured(c) {
+		ctr.classStats.dropped(&c)
+		ctr.tracer.traceCon(&c, "not registered, the connection isn't assured yet")
+		return 0
+	}
+	if ctr.setsAsideLocal(&c, nil) {
+		ctr.classStats.dropped(&c)
+		ctr.tracer.traceCon(&c, "not registered, the connection is translated to or from a local address")
+		return 0
+	}
+	// the update events repeating what is stored already don't need the write lock
+	if ctr.coalescer.unchanged(&c) {
+		ctr.tracer.traceCon(&c, "not registered, unchanged")
+		return 0
+	}
+
+	now := ctr.clock.Now().UnixNano()
+	log.Tracef("%s", c)
+	if isHairpin(c) {
+		atomic.AddInt64(&ctr.stats.registersHairpin, 1)
+	}
+	if isNAT64(c) {
+		atomic.AddInt64(&ctr.stats.registersNAT64, 1)
+	}
+
+	ctr.Lock()
+	if !ctr.admits(&c) {
+		ctr.Unlock()
+		ctr.classStats.dropped(&c)
+		return 0
+	}
+	stored, dropped := ctr.store(&c)
+	ctr.Unlock()
+	if stored {
+		ctr.coalescer.stored(&c)
+	}
+	if dropped {
+		ctr.classStats.dropped(&c)
+	}
+	then := ctr.clock.Now()
+	atomic.AddInt64(&ctr.stats.registers, 1)
+	ctr.classStats.registered(&c)
+	atomic.AddInt64(&ctr.stats.registersTotalTime, then.UnixNano()-now)
+
+	return 0
+}
+
+// admits returns whether the given connection can be stored, which is the case unless the quota of its source is
+// exceeded. It must be called with the write lock held.
+func (ctr *realConntracker) admits(c *Con) bool {
+	if ctr.sourceQuota == nil {
+		return true
+	}
+	if origin, ok := formatNSKey(ctr.nsids.inode(c.NetNS), c.Origin); ok && !ctr.sourceQuota.allows(origin) {
+		ctr.tracer.traceCon(c, "not registered, the quota of its source is exceeded")
+		return false
+	}
+	return true
+}
+
+// connData is what is tracked along with the translation of a connection, from the point of view of its origin
+type connData struct {
+	zone      uint16
+	tcpState  TCPState
+	counters  Counters
+	startTime uint64
+	status    Status
+	id        uint32
+}
+
+func newConnData(c *Con) connData {
+	return connData{c.Zone, c.TCPState, c.Counters, c.StartTime, Status(c.Status), c.ID}
+}
+
+// store stores the given connection admitted (see storeConn). It returns whether it was stored, and whether it was
+// dropped. It must be called with the write lock held.
+func (ctr *realConntracker) store(c *Con) (stored, dropped bool) {
+	netns := ctr.nsids.inode(c.NetNS)
+	origin, ok := formatNSKey(netns, c.Origin)
+	if _, replyOK := formatNSKey(netns, c.Reply); !ok || !replyOK {
+		ctr.decodeErrors.message()
+		return false, false
+	}
+	return ctr.storeConn(origin, formatIPTranslation(c.Reply), newConnData(c))
+}
+
+// storeConn stores the connection of the given origin key and translation as a whole, along with its bookkeeping
+// (source quota, NAT gateways, zone, data and id), unless it is new while the state map is full, or one of its
+// tuples is held by a connection of a zone of higher priority. It returns whether it was stored, and whether it
+// was dropped. It must be called with the write lock held.
+func (ctr *realConntracker) storeConn(origin connKey, t network.IPTranslation, d connData) (stored, dropped bool) {
+	if _, ok := ctr.state.getConn(origin); !ok && ctr.state.len() >= ctr.maxStateSize {
+		atomic.AddInt64(&ctr.stats.registersStateFull, 1)
+		atomic.StoreInt32(&ctr.stateFull, 1)
+		ctr.logExceededSize()
+		ctr.tracer.traceKey(origin, "not registered, the state map is full")
+		return false, true
+	}
+
+	for _, k := range [...]connKey{origin, newTranslationValue(&t).replyKey(origin)} {
+		if !ctr.overridesZone(k, d.zone) {
+			atomic.AddInt64(&ctr.stats.registersZone, 1)
+			if ctr.tracer != nil {
+				zone, _ := ctr.zoneOf(k)
+				ctr.tracer.traceKey(k, "not registered, the translation of zone %d is kept", zone)
+			}
+			return false, true
+		}
+	}
+
+	ctr.setEntry(origin, t)
+	ctr.natGateways.observe(origin, t.ReplDstIP)
+	ctr.sourceQuota.add(origin)
+	ctr.setData(origin, d)
+	return true, false
+}
+
+// unregister is called whenever a conntrack entry is destroyed, which is only reported
+// when the destroy netlink group is subscribed to
+func (ctr *realConntracker) unregister(c Con) {
+	ctr.coalescer.forget(&c)
+	netns := ctr.nsids.inode(c.NetNS)
+	if ctr.udpCloseHints && c.Origin.proto == unix.IPPROTO_UDP {
+		if key, ok := formatNSKey(netns, c.Origin); ok {
+			ctr.notifier.notifyDestroyed(key, formatIPTranslation(c.Reply), c.Counters)
+			atomic.AddInt64(&ctr.stats.udpCloseHints, 1)
+		}
+	}
+	if !isNAT(c) {
+		return
+	}
+
+	ctr.Lock()
+	defer ctr.Unlock()
+	size, removed := ctr.state.len(), 0
+	// the connection is cached with its origin key, or with its reply key once the client end of a hairpin
+	// connection was closed
+	for _, tuple := range [...]IPTuple{c.Origin, c.Reply} {
+		if key, ok := formatNSKey(netns, tuple); ok {
+			if zone, ok := ctr.zoneOf(key); ok && zone != c.Zone {
+				// the same connection tracked in another zone
+				continue
+			}
+			if ctr.recycled(key, c.ID) {
+				atomic.AddInt64(&ctr.stats.destroysRecycled, 1)
+				ctr.tracer.traceKey(key, "not deleted, the tuple was recycled by a newer connection")
+				continue
+			}
+			if _, ok := ctr.state.getConn(key); ok && ctr.deleteEntry(key) {
+				removed++
+			}
+			ctr.local.remove(key)
+			if ctr.bootstrapDestroyed != nil && len(ctr.bootstrapDestroyed) < ctr.maxStateSize {
+				ctr.bootstrapDestroyed[key] = struct{}{}
+			}
+		}
+	}
+	atomic.AddInt64(&ctr.stats.destroys, 1)
+	if ctr.flushes.destroyed(ctr.clock.Now(), size, removed) {
+		go ctr.flushed("most of the cached entries were destroyed at once")
+	}
+}
+
+// flushed notifies the subscribers about a flush of the conntrack table, and rebuilds the cache from a new dump
+// of the table, since the DESTROY events of a flush are likely to overflow the event socket
+func (ctr *realConntracker) flushed(reason string) {
+	log.Warnf("the conntrack table was flushed (%s), rebuilding the conntrack cache", reason)
+	atomic.AddInt64(&ctr.stats.flushes, 1)
+	ctr.notifier.notifyFlush()
+	ctr.resync()
+}
+
+// setEntry stores the connection of the given origin key and translation. It must be called with the write
+// lock held.
+func (ctr *realConntracker) setEntry(k connKey, t network.IPTranslation) {
+	atomic.StoreInt32(&ctr.stale, 1)
+	t.NATType = natType(k, &t)
+	v := newTranslationValue(&t)
+	old, ok := ctr.state.getConn(k)
+	if !ok {
+		ctr.classStats.added(k)
+	}
+	reply := v.replyKey(k)
+	if ok && old != v {
+		ctr.dropPublished(k, reply, old.replyKey(k))
+	} else if !ok {
+		// the tuples of the connection may have been taken over from other connections
+		ctr.dropPublished(k, reply)
+	}
+	ctr.state.set(k, v)
+	ctr.negatives.forget(k)
+	ctr.negatives.forget(reply)
+	ctr.ttls.stored(k, ctr.clock.Now())
+	ctr.tracer.traceKey(k, "stored, translated to %s:%d -> %s:%d", t.ReplDstIP, t.ReplDstPort, t.ReplSrcIP, t.ReplSrcPort)
+	ctr.tracer.traceKey(reply, "stored, as the reply of %s", keyString(k))
+	if !ok || old != v {
+		ctr.notifier.notify(TranslationCreated, k, &t)
+	}
+}
+
+// deleteEntry removes the connection of the given origin key from the state map. It must be called with the write
+// lock held.
+func (ctr *realConntracker) deleteEntry(k connKey) bool {
+	t, ok := ctr.state.getConn(k)
+	if !ok {
+		return false
+	}
+	ctr.dropPublished(k, t.replyKey(k))
+	ctr.state.delete(k)
+	atomic.StoreInt32(&ctr.stale, 1)
+	ctr.classStats.removed(k)
+	ctr.forgetConn(k)
+	ctr.ttls.forget(k)
+	ctr.tracer.traceKey(k, "deleted")
+	ctr.tracer.traceKey(t.replyKey(k), "deleted, as the reply of %s", keyString(k))
+	if ctr.notifier.active() {
+		ctr.notifier.notify(TranslationDeleted, k, t.translation())
+	}
+	ctr.natGateways.forget(k)
+	ctr.sourceQuota.remove(k)
+	return true
+}
+
+// reverseEntry stores the connection of the given origin key with its reply key instead, once the client end of
+// a hairpin connection was closed, so only the server end keeps its translation. The connection is no longer
+// counted in the quota of its source nor in its NAT gateway, since its client end is closed. It must be called with
+// the write lock held.
+func (ctr *realConntracker) reverseEntry(origin connKey, t translationValue) {
+	reply := t.replyKey(origin)
+	d := connData{
+		zone:      ctr.entryZones[origin],
+		tcpState:  ctr.tcpStates[origin],
+		counters:  ctr.counters[origin].reversed(),
+		startTime: ctr.startTimes[origin],
+		status:    ctr.statuses[origin],
+		id:        ctr.ids[origin],
+	}
+	ctr.deleteEntry(origin)
+
+	r := reverseTranslation(origin, t)
+	ctr.setEntry(reply, *r.translation())
+	ctr.dropPublished(origin)
+	ctr.state.unindex(reply, r)
+	ctr.setData(reply, d)
+}
+
+// forgetConn removes the data kept along with the connection of the given origin key. It must be called with the
+// write lock held.
+func (ctr *realConntracker) forgetConn(k connKey) {
+	if ctr.tcpStates != nil {
+		delete(ctr.tcpStates, k)
+	}
+	if ctr.counters != nil {
+		delete(ctr.counters, k)
+	}
+	if ctr.startTimes != nil {
+		delete(ctr.startTimes, k)
+	}
+	if ctr.statuses != nil {
+		delete(ctr.statuses, k)
+	}
+	if ctr.entryZones != nil {
+		delete(ctr.entryZones, k)
+	}
+	delete(ctr.ids, k)
+}
+
+// zoneOf returns the zone the connection of the given key of the state map was stored from, false when it isn't
+// known. It must be called with the lock held.
+func (ctr *realConntracker) zoneOf(k connKey) (uint16, bool) {
+	if ctr.entryZones == nil {
+		return 0, false
+	}
+	origin, _, _, ok := ctr.state.find(k)
+	if !ok {
+		return 0, false
+	}
+	zone, ok := ctr.entryZones[origin]
+	return zone, ok
+}
+
+// overridesZone returns whether an entry of the given zone can replace the translation stored for the given key,
+// which is the case unless it was stored from a zone of higher priority. It must be called with the write lock held.
+func (ctr *realConntracker) overridesZone(k connKey, zone uint16) bool {
+	stored, ok := ctr.zoneOf(k)
+	return !ok || ctr.zoneFilter.overrides(zone, stored)
+}
+
+// setData records what is tracked along with the connection of the given origin key. It must be called with the
+// write lock held.
+func (ctr *realConntracker) setData(k connKey, d connData) {
+	ctr.setZone(k, d.zone)
+	ctr.setTCPState(k, d.tcpState)
+	ctr.setCounters(k, d.counters)
+	ctr.setStartTime(k, d.startTime)
+	ctr.setStatus(k, d.status)
+	ctr.setID(k, d.id)
+}
+
+// setZone records the zone the connection of the given origin key was stored from, when the entries are filtered
+// by zone. It must be called with the write lock held.
+func (ctr *realConntracker) setZone(k connKey, zone uint16) {
+	if ctr.entryZones != nil {
+		ctr.entryZones[k] = zone
+	}
+}
+
+// setTCPState records the TCP state of the connection of the given origin key, when TCP states are tracked.
+// It must be called with the write lock held.
+func (ctr *realConntracker) setTCPState(k connKey, s TCPState) {
+	if ctr.tcpStates != nil && s != TCPStateNone {
+		ctr.tcpStates[k] = s
+	}
+}
+
+// setCounters records the counters of the connection of the given origin key, when counters are tracked.
+// Entries without any counter are ignored, since nf_conntrack_acct is disabled.
+// It must be called with the write lock held.
+func (ctr *realConntracker) setCounters(k connKey, c Counters) {
+	if ctr.counters != nil && c != (Counters{}) {
+		ctr.counters[k] = c
+	}
+}
+
+// setStartTime records the creation time of the connection of the given origin key, when start times are tracked.
+// It must be called with the write lock held.
+func (ctr *realConntracker) setStartTime(k connKey, start uint64) {
+	if ctr.startTimes != nil && start != 0 {
+		ctr.startTimes[k] = start
+	}
+}
+
+// setStatus records the status flags of the connection of the given origin key, when they are tracked.
+// It must be called with the write lock held.
+func (ctr *realConntracker) setStatus(k connKey, s Status) {
+	if ctr.statuses != nil && s != 0 {
+		ctr.statuses[k] = s
+	}
+}
+
+// setID records the conntrack id of the connection of the given origin key. The id of a connection stored from an
+// entry without id is forgotten, since it may belong to another connection. It must be called with the write lock held.
+func (ctr *realConntracker) setID(k connKey, id uint32) {
+	if id != 0 {
+		ctr.ids[k] = id
+	} else {
+		delete(ctr.ids, k)
+	}
+}
+
+// recycled returns whether the given key of the state map was stored from another conntrack entry than the one
+// of the given id, in which case its tuple was recycled by a newer connection. Unknown ids don't tell.
+// It must be called with the write lock held.
+func (ctr *realConntracker) recycled(k connKey, id uint32) bool {
+	if id == 0 {
+		return false
+	}
+	origin, _, _, ok := ctr.state.find(k)
+	if !ok {
+		return false
+	}
+	stored, ok := ctr.ids[origin]
+	return ok && stored != id
+}
+
+func (ctr *realConntracker) logExceededSize() {
+	if ctr.exceededSizeLogLimit.ShouldLog() {
+		log.Warnf("exceeded maximum conntrack state size: %d connections. You may need to increase system_probe_config.conntrack_max_state_size (will log first ten times, and then once every 10 minutes)", ctr.maxStateSize)
+	}
+}
+
+// reportHealth keeps the readiness check healthy as long as the events are streamed and processed
+func (ctr *realConntracker) reportHealth(now time.Time) {
+	if !ctr.eventsHealthy(now) {
+		return
+	}
+	ctr.health.Healthy()
+}
+
+// eventsHealthy returns whether the events are streamed, and the processing of the current one, if any, isn't stalled
+func (ctr *realConntracker) eventsHealthy(now time.Time) bool {
+	if atomic.LoadInt32(&ctr.eventsEnded) == 1 || ctr.consumer.isRestarting() {
+		return false
+	}
+	since := atomic.LoadInt64(&ctr.processingSince)
+	return since == 0 || now.UnixNano()-since < eventStallTimeout.Nanoseconds()
+}
+
+// eventsStopped is called once the event stream ends, which is only expected when the conntracker is closed
+func (ctr *realConntracker) eventsStopped() {
+	atomic.StoreInt64(&ctr.processingSince, 0)
+	if ctr.consumer.isStopped() {
+		return
+	}
+	atomic.StoreInt32(&ctr.eventsEnded, 1)
+	log.Errorf("the conntrack event stream stopped, the translations won't be updated anymore")
+}
+
+func (ctr *realConntracker) run() {
+	go func() {
+		decoder := ctr.newDecoder()
+		handle := func(c *Con) {
+			ctr.tracer.traceCon(c, "%s event", c.EventType)
+			if ctr.pending.buffer(c) {
+				return
+			}
+			if ctr.queue == nil {
+				ctr.processEvent(c)
+				return
+			}
+			if !ctr.queue.push(c) {
+				ctr.tracer.traceCon(c, "dropped, the registration queue is full")
+				if c.EventType != EventDestroy {
+					ctr.classStats.dropped(c)
+				}
+			}
+		}
+		if ctr.queue != nil {
+			go ctr.queue.run(func(c Con) {
+				ctr.processEvent(&c)
+			})
+		}
+
+		if ctr.cpuBreaker != nil {
+			// the CPU time used by the event processing is measured per thread
+			runtime.LockOSThread()
+		}
+
+		var paused bool
+		events := ctr.consumer.Events()
+		defer ctr.eventsStopped()
+		defer ctr.queue.close()
+		for e := range events {
+			now := ctr.clock.Now()
+			if ctr.cpuBreaker.paused(now) {
+				e.Done()
+				paused = true
+				continue
+			}
+			atomic.StoreInt64(&ctr.processingSince, now.UnixNano())
+			if paused {
+				// events were discarded while paused, the table is dumped again to catch up with them
+				paused = false
+				go ctr.resync()
+			}
+
+			if ctr.isStateFull() {
+				ctr.skipNewEvents(decoder, e, handle)
+			} else if ctr.prioritizeDestroys && ctr.nearCapacity() {
+				ctr.processDestroysFirst(decoder, e, events, handle)
+			} else {
+				decoder.DecodeAndReleaseEvent(e, handle)
+			}
+			ctr.cpuBreaker.update(now)
+			atomic.StoreInt64(&ctr.processingSince, 0)
+		}
+	}()
+
+	if ctr.health != nil {
+		go func() {
+			for range ctr.healthTicker.C() {
+				ctr.reportHealth(ctr.clock.Now())
+			}
+		}()
+	}
+
+	go func() {
+		for range ctr.publishTicker.C() {
+			ctr.publish()
+		}
+	}()
+
+	go func() {
+		for range ctr.telemetryTicker.C() {
+			reportStats(ctr.telemetry, ctr.typedStats())
+		}
+	}()
+
+	if ctr.resyncTicker != nil {
+		go func() {
+			for range ctr.resyncTicker.C() {
+				ctr.resync()
+			}
+		}()
+	}
+
+	if ctr.compactTicker != nil {
+		go func() {
+			for range ctr.compactTicker.C() {
+				ctr.compact()
+			}
+		}()
+	}
+
+	if ctr.natRulesTicker != nil {
+		go func() {
+			for range ctr.natRulesTicker.C() {
+				ctr.refreshNATRules()
+			}
+		}()
+	}
+
+	if ctr.expireTicker != nil {
+		go func() {
+			for range ctr.expireTicker.C() {
+				ctr.expireEntries()
+			}
+		}()
+	}
+
+	if ctr.netnsTicker != nil {
+		go func() {
+			for range ctr.netnsTicker.C() {
+				ctr.refreshNamespaces()
+			}
+		}()
+	}
+
+	if ctr.flushTicker != nil {
+		go func() {
+			for range ctr.flushTicker.C() {
+				if ctr.flushes.countReset() {
+					ctr.flushed("nf_conntrack_count was reset")
+				}
+			}
+		}()
+	}
+
+	if ctr.cpuBreakerTicker != nil {
+		go func() {
+			for range ctr.cpuBreakerTicker.C() {
+				if ctr.cpuBreaker.paused(ctr.clock.Now()) {
+					ctr.resync()
+				}
+			}
+		}()
+	}
+
+	// The events of the meantime are lost when the event socket has to be re-created, so the table is re-dumped
+	go func() {
+		for range ctr.consumer.Restarts() {
+			log.Infof("conntrack netlink socket was re-created, re-dumping the conntrack table to recover lost events")
+			ctr.resync()
+		}
+	}()
+
+	// Events lost to a socket overflow would otherwise never make it to the cache,
+	// so we re-dump the table to converge again. Netlink doesn't tell us which address
+	// family the lost events belonged to, so both are re-dumped.
+	go func() {
+		for range ctr.consumer.Overflows() {
+			log.Infof("conntrack netlink socket overflowed, re-dumping the conntrack table to recover lost events")
+			ctr.resync()
+			atomic.AddInt64(&ctr.stats.overflowRecoveries, 1)
+			<-ctr.clock.After(overflowRecoveryInterval)
+		}
+	}()
+}
+
+// processEvent registers or unregisters the entry of an event
+func (ctr *realConntracker) processEvent(c *Con) {
+	if c.EventType == EventDestroy {
+		ctr.unregister(*c)
+		return
+	}
+	ctr.register(*c)
+}
+
+// nearCapacity returns whether the state map is almost full
+func (ctr *realConntracker) nearCapacity() bool {
+	ctr.RLock()
+	size := ctr.state.len()
+	ctr.RUnlock()
+	return size*100 >= ctr.maxStateSize*nearCapacityPct
+}
+
+// isStateFull returns whether the state map is full since an entry was rejected, in which case the new connection
+// events are skipped. It is only checked again, with the lock, while it is set.
+func (ctr *realConntracker) isStateFull() bool {
+	if atomic.LoadInt32(&ctr.stateFull) == 0 {
+		return false
+	}
+
+	ctr.RLock()
+	size := ctr.state.len()
+	ctr.RUnlock()
+	if size >= ctr.maxStateSize {
+		return true
+	}
+	atomic.StoreInt32(&ctr.stateFull, 0)
+	return false
+}
+
+// skipNewEvents processes the update and destroy events of the given event, without decoding its new connection
+// events, which couldn't be registered in the full state map
+func (ctr *realConntracker) skipNewEvents(decoder *Decoder, e Event, handle func(c *Con)) {
+	var skipped int64
+	decoder.decodeEvent(e, func(typ EventType) bool {
+		if typ == EventNew {
+			skipped++
+			return false
+		}
+		return true
+	}, handle)
+	e.Done()
+	atomic.AddInt64(&ctr.stats.newEventsSkipped, skipped)
+}
+
+// processDestroysFirst processes the given event along with the events already queued behind it, handling all
+// their destroy events first, so the entries they remove make room for the new ones. The entries destroyed
+// are known by their conntrack id, so their own new and update events of the batch don't register them again.
+func (ctr *realConntracker) processDestroysFirst(decoder *Decoder, first Event, events <-chan Event, handle func(c *Con)) {
+	batch := []Event{first}
+drain:
+	for len(batch) < maxPrioritizedEvents {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				break drain
+			}
+			batch = append(batch, e)
+		default:
+			break drain
+		}
+	}
+
+	destroyed := make(map[coalescerKey]struct{})
+	isDestroy := func(typ EventType) bool { return typ == EventDestroy }
+	for _, e := range batch {
+		decoder.decodeEvent(e, isDestroy, func(c *Con) {
+			if c.ID != 0 {
+				destroyed[coalescerKey{c.NetNS, c.ID}] = struct{}{}
+			}
+			atomic.AddInt64(&ctr.stats.destroysPrioritized, 1)
+			handle(c)
+		})
+	}
+
+	isNotDestroy := func(typ EventType) bool { return typ != EventDestroy }
+	for _, e := range batch {
+		decoder.decodeEvent(e, isNotDestroy, func(c *Con) {
+			if _, ok := destroyed[coalescerKey{c.NetNS, c.ID}]; ok && c.ID != 0 {
+				return
+			}
+			handle(c)
+		})
+		e.Done()
+	}
+}
+
+// isStale returns whether the state map was modified since it was last published
+func (ctr *realConntracker) isStale() bool {
+	return atomic.LoadInt32(&ctr.stale) != 0
+}
+
+// publish copies the state map for lock-free reads, when it was modified since the last copy.
+// Modifications are batched by the publish interval. The copy shares the chunks of the slots of the state map, which
+// only copies the ones written since, so publishing doesn't copy the state map however large it is.
+func (ctr *realConntracker) publish() {
+	if !ctr.isStale() {
+		return
+	}
+
+	then := ctr.clock.Now()
+	// the write lock is held since cloning the state map marks its chunks as shared, which only takes a pass over them
+	ctr.Lock()
+	ctr.published.Store(newPublication(ctr.state.clone()))
+	atomic.StoreInt32(&ctr.stale, 0)
+	ctr.Unlock()
+
+	atomic.AddInt64(&ctr.stats.publishes, 1)
+	atomic.AddInt64(&ctr.stats.publishTimeTotal, ctr.clock.Now().Sub(then).Nanoseconds())
+}
+
+// expireEntries removes the connections which weren't stored again for longer than the retention of their protocol
+func (ctr *realConntracker) expireEntries() {
+	ctr.Lock()
+	defer ctr.Unlock()
+
+	var expired int64
+	for _, k := range ctr.ttls.expired(ctr.clock.Now()) {
+		ctr.tracer.traceKey(k, "expired")
+		if ctr.deleteEntry(k) {
+			expired++
+		}
+	}
+	atomic.AddInt64(&ctr.stats.expirations, expired)
+}
+
+// compact shrinks the state map once most of its entries were deleted, when it isn't shrunk by the deletions
+func (ctr *realConntracker) compact() {
+	ctr.Lock()
+	defer ctr.Unlock()
+	ctr.state.compact()
+}
+
+// resolveChain follows the translations of a flow going through several NAT stages (eg. in a container network
+// namespace, and then in the root namespace), each of them having its own conntrack entry. The flow leaving a
+// stage is the origin of the entry of the next stage, so the translation returned goes from the first stage to
+// the last one. Each stage is looked up in the namespace of the previous one, and then in the root namespace.
+// It must be called with the lock held.
+func (ctr *realConntracker) resolveChain(state translationReader, k connKey, t translationValue) translationValue {
+	netns, transport := k.netns, k.transport
+	last := t
+	for i := 0; i < maxNATChainLength; i++ {
+		next, k, ok := lookupNamespace(state, translatedConnKey(netns, transport, last))
+		if !ok || next == last || next == t {
+			break
+		}
+		last, netns = next, k.netns
+	}
+	if last == t {
+		return t
+	}
+
+	atomic.AddInt64(&ctr.stats.chainsResolved, 1)
+	resolved := last
+	resolved.natType = natType(k, last.translation())
+	return resolved
+}
+
+// translatedConnKey returns the key of the flow leaving a NAT stage, which is the reverse of the reply tuple
+func translatedConnKey(netns uint32, proto network.ConnectionType, t translationValue) connKey {
+	return connKey{
+		srcIP:     t.replDstIP,
+		srcPort:   t.replDstPort,
+		dstIP:     t.replSrcIP,
+		dstPort:   t.replSrcPort,
+		transport: proto,
+		netns:     netns,
+	}
+}
+
+// connStatsToNSConnKey returns the key of a connection in its network namespace
+func connStatsToNSConnKey(c network.ConnectionStats) connKey {
+	k := connStatsToConnKey(c)
+	k.netns = c.NetNS
+	return k
+}
+
+// newDecoder returns a Decoder applying the port filter
+func (ctr *realConntracker) newDecoder() *Decoder {
+	d := NewDecoder()
+	d.ports = ctr.portFilter
+	d.zones = ctr.zoneFilter
+	d.errors = &ctr.decodeErrors
+	d.caps = ctr.caps
+	return d
+}
+
+// refreshNATRules reads the NAT rules of the host again. The rules previously read are kept if they can't be read.
+// Entries that no longer match the rules are removed by the next re-sync.
+func (ctr *realConntracker) refreshNATRules() {
+	rules, err := readNATRules(ctr.procRoot)
+	if err != nil {
+		log.Warnf("could not read the NAT rules, conntrack entries won't be filtered with them: %s", err)
+		return
+	}
+	if rules == nil {
+		log.Debugf("NAT rules can't be restricted to a set of addresses, conntrack entries aren't filtered with them")
+	}
+	ctr.natRules.Store(rules)
+}
+
+// skipsUnreplied returns whether the entry is skipped because its connection didn't see any reply yet, and counts it
+func (ctr *realConntracker) skipsUnreplied(c Con) bool {
+	if !ctr.skipUnreplied || !c.unreplied() {
+		return false
+	}
+	atomic.AddInt64(&ctr.stats.registersUnreplied, 1)
+	return true
+}
+
+// skipsUnassured returns whether the entry is skipped because conntrack didn't mark it assured yet, and counts it.
+// Entries of unknown status are assumed to be assured.
+func (ctr *realConntracker) skipsUnassured(c Con) bool {
+	if !ctr.skipUnassured || c.Status == 0 || Status(c.Status).Assured() {
+		return false
+	}
+	atomic.AddInt64(&ctr.stats.registersUnassured, 1)
+	return true
+}
+
+// setsAsideLocal keeps the entry out of the state map when it is translated to or from a loopback or link-local
+// address and those are skipped, and counts it. The keys of the entry are added to seen, unless it is nil.
+func (ctr *realConntracker) setsAsideLocal(c *Con, seen map[connKey]struct{}) bool {
+	if ctr.local == nil || !isLocalCon(*c) {
+		return false
+	}
+	atomic.AddInt64(&ctr.stats.registersLocal, 1)
+	netns := ctr.nsids.inode(c.NetNS)
+	ctr.Lock()
+	ctr.local.add(netns, c, seen)
+	ctr.Unlock()
+	return true
+}
+
+// matchesFilters returns whether the conntrack entry matches both the NAT rules of the host and the configured CIDRs,
+// when filtering is enabled
+func (ctr *realConntracker) matchesFilters(c Con) bool {
+	rules, _ := ctr.natRules.Load().(natRules)
+	return rules.matches(c) && ctr.cidrFilter.matches(c)
+}
+
+func isNAT(c Con) bool {
+	if !c.Origin.hasPorts() || !c.Reply.hasPorts() {
+		return false
+	}
+
+	return c.Origin.src != c.Reply.dst ||
+		c.Origin.dst != c.Reply.src ||
+		c.Origin.srcPort != c.Reply.dstPort ||
+		c.Origin.dstPort != c.Reply.srcPort
+}
+
+// isNAT64 returns whether a NAT connection is translated between address families, as done by NAT64 gateways:
+// the origin tuple has IPv6 addresses, and the reply tuple IPv4 ones. Each tuple is keyed in its own family,
+// so the IPv6 flows seen by the tracer resolve to their IPv4 endpoints.
+func isNAT64(c Con) bool {
+	return c.Origin.src.isIPv4() != c.Reply.src.isIPv4()
+}
+
+// isHairpin returns whether a NAT connection is translated back to the host it comes from, which happens when a pod
+// reaches itself through the address of its service. The origin and reply tuples then share addresses, and both
+// ends of the connection are seen locally: the client with the origin tuple, and the server with the reply tuple.
+func isHairpin(c Con) bool {
+	return isNAT(c) && c.Origin.src == c.Reply.src
+}
+
+// isHairpinEntry returns whether an entry of the state map belongs to a hairpin connection. The entries of both
+// tuples of such connections are translated to the address their key comes from.
+func isHairpinEntry(k connKey, t translationValue) bool {
+	return t.replSrcIP == k.srcIP
+}
+
+func formatIPTranslation(tuple IPTuple) network.IPTranslation {
+	return network.IPTranslation{
+		ReplSrcIP:   tuple.src.address(),
+		ReplDstIP:   tuple.dst.address(),
+		ReplSrcPort: tuple.srcPort,
+		ReplDstPort: tuple.dstPort,
+	}
+}
+
+// formatNSKey returns the key of the tuple of an entry of the network namespace of the given inode
+func formatNSKey(netns uint32, tuple IPTuple) (connKey, bool) {
+	k, ok := formatKey(tuple)
+	k.netns = netns
+	return k, ok
+}
+
+// formatKey returns the key of a tuple, and false when it isn't the complete tuple of a TCP or UDP connection
+func formatKey(tuple IPTuple) (k connKey, ok bool) {
+	ok = tuple.isComplete()
+	k.srcIP = tuple.src
+	k.dstIP = tuple.dst
+	k.srcPort = tuple.srcPort
+	k.dstPort = tuple.dstPort
+
+	switch tuple.proto {
+	case unix.IPPROTO_TCP:
+		k.transport = network.TCP
+	case unix.IPPROTO_UDP:
+		k.transport = network.UDP
+	default:
+		ok = false
+	}
+
+	return
 }
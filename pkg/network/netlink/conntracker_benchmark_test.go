@@ -0,0 +1,144 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+// benchmarkStateSizes are the numbers of connections registered in the benchmarks depending on the state size.
+// Every connection is NAT-ed, so it takes two entries of the state map.
+var benchmarkStateSizes = []int{1000, 10000, 100000}
+
+// makeBenchmarkConns returns n distinct NAT-ed connections, along with the stats looked up for them
+func makeBenchmarkConns(n int) ([]Con, []network.ConnectionStats) {
+	conns := make([]Con, n)
+	stats := make([]network.ConnectionStats, n)
+	for i := 0; i < n; i++ {
+		src := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(src, 0x0a000000+uint32(i))
+		transSrc := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(transSrc, 0x14000000+uint32(i))
+		dst := net.ParseIP("50.30.40.10").To4()
+
+		conns[i] = makeTranslatedConn(src, transSrc, dst, 6, 12345, 80, 80)
+		stats[i] = network.ConnectionStats{
+			Source: util.AddressFromNetIP(src),
+			SPort:  12345,
+			Dest:   util.AddressFromNetIP(dst),
+			DPort:  80,
+			Type:   network.TCP,
+		}
+	}
+	return conns, stats
+}
+
+func newBenchmarkConntracker(conns []Con) *realConntracker {
+	ctr := newConntracker()
+	ctr.maxStateSize = 2 * len(conns)
+	for _, c := range conns {
+		ctr.register(c)
+	}
+	ctr.publish()
+	return ctr
+}
+
+// BenchmarkGetTranslationForConnUnderWriteLoad looks translations up concurrently while another goroutine keeps
+// registering and unregistering entries, so the lookups see a state modified since it was last published
+func BenchmarkGetTranslationForConnUnderWriteLoad(b *testing.B) {
+	for _, size := range benchmarkStateSizes {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			conns, stats := makeBenchmarkConns(size)
+			ctr := newBenchmarkConntracker(conns)
+			churn, _ := makeBenchmarkConns(size + 1000)
+			churn = churn[size:]
+
+			done := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; ; i++ {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					c := churn[i%len(churn)]
+					ctr.register(c)
+					ctr.unregister(c)
+				}
+			}()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					ctr.GetTranslationForConn(stats[i%len(stats)])
+				}
+			})
+			b.StopTimer()
+			close(done)
+			wg.Wait()
+		})
+	}
+}
+
+// BenchmarkRegisterEventStorm registers entries from concurrent goroutines, as when a burst of events
+// is decoded, the state map being refreshed with the same connections over and over
+func BenchmarkRegisterEventStorm(b *testing.B) {
+	for _, size := range benchmarkStateSizes {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			conns, _ := makeBenchmarkConns(size)
+			ctr := newBenchmarkConntracker(conns)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					ctr.register(conns[i%len(conns)])
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkRegisterUnregister measures the cost of a connection going through the state map,
+// from its creation to its destruction
+func BenchmarkRegisterUnregister(b *testing.B) {
+	conns, _ := makeBenchmarkConns(10000)
+	ctr := newConntracker()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := conns[i%len(conns)]
+		ctr.register(c)
+		ctr.unregister(c)
+	}
+}
+
+// BenchmarkPublish measures the time the state map takes to be copied for lock-free reads, depending on its size
+func BenchmarkPublish(b *testing.B) {
+	for _, size := range benchmarkStateSizes {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			conns, _ := makeBenchmarkConns(size)
+			ctr := newBenchmarkConntracker(conns)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ctr.stale = 1
+				ctr.publish()
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+// Conntracker is a wrapper around go-conntracker that keeps a record of all connections in user space
+type Conntracker interface {
+	GetTranslationForConn(network.ConnectionStats) *network.IPTranslation
+	// GetTranslationsForConns returns the translations of the given connections, in the same order.
+	// It is cheaper than calling GetTranslationForConn for each connection.
+	GetTranslationsForConns([]network.ConnectionStats) []*network.IPTranslation
+	// GetEntryForConn returns both directions of the translation of a connection, along with what conntrack
+	// tracks for it, from a single lookup. It returns nil when the connection isn't translated.
+	GetEntryForConn(network.ConnectionStats) *ConntrackEntry
+	DeleteTranslation(network.ConnectionStats)
+	// DeleteTranslations removes the translations of the given connections.
+	// It is cheaper than calling DeleteTranslation for each connection.
+	DeleteTranslations([]network.ConnectionStats)
+	GetStats() Stats
+	DumpCachedTable() []network.DebugConntrackEntry
+	// Subscribe returns a channel notified when the translations matching the given filter are created or deleted,
+	// along with a function cancelling the subscription and closing the channel.
+	// Events are dropped when they aren't consumed fast enough.
+	Subscribe(TranslationFilter) (<-chan TranslationEvent, func())
+	// GetTCPStateForConn returns the state conntrack has for a TCP connection, or TCPStateNone when it is unknown
+	GetTCPStateForConn(network.ConnectionStats) TCPState
+	// GetTCPStatesForConns returns the states conntrack has for the given connections, in the same order.
+	// It is cheaper than calling GetTCPStateForConn for each connection.
+	GetTCPStatesForConns([]network.ConnectionStats) []TCPState
+	// GetCountersForConn returns the counters conntrack has for a connection, and whether they are known
+	GetCountersForConn(network.ConnectionStats) (Counters, bool)
+	// GetStartTimeForConn returns the time conntrack started tracking a connection, and whether it is known
+	GetStartTimeForConn(network.ConnectionStats) (time.Time, bool)
+	// GetStatusForConn returns the status flags conntrack has for a connection, or 0 when they are unknown
+	GetStatusForConn(network.ConnectionStats) Status
+	// GetNATGateways returns the addresses the host's connections are source NATed to, which are the addresses
+	// of its egress gateways, the ones with the most translations first
+	GetNATGateways() []util.Address
+	// GetRecentErrors returns the last errors the conntracker ran into, the oldest first
+	GetRecentErrors() []string
+	// Refresh dumps the kernel conntrack table of the given address family (unix.AF_INET or unix.AF_INET6) and
+	// reconciles the cache with it, adding the missing entries and removing the ones the kernel no longer has
+	Refresh(family uint8) error
+	// SetTargetRateLimit changes the maximum number of conntrack messages per second processed, without restarting
+	SetTargetRateLimit(int)
+	// Describe returns the backend of the conntracker, the configuration in effect and its initialization status
+	Describe() network.ConntrackDescription
+	Close()
+}
+
+// conntrackerHolder boxes a Conntracker, as an atomic.Value must always hold the same concrete type
+type conntrackerHolder struct {
+	Conntracker
+}
+
+// ConntrackTuple is one direction of a conntrack entry
+type ConntrackTuple struct {
+	Src   util.Address
+	SPort uint16
+	Dst   util.Address
+	DPort uint16
+}
+
+// ConntrackEntry is the complete mapping of a translated connection: Origin is the connection as it was looked up,
+// and Reply the tuple of its replies, which holds the translated addresses
+type ConntrackEntry struct {
+	Proto  network.ConnectionType
+	Origin ConntrackTuple
+	Reply  ConntrackTuple
+
+	// Translation is the translation of the connection, as returned by GetTranslationForConn
+	Translation *network.IPTranslation
+
+	// TCPState, Counters, StartTime, Status and ID are left to their zero value when they aren't known
+	TCPState  TCPState
+	Counters  Counters
+	StartTime time.Time
+	Status    Status
+	// ID is the id conntrack assigned to the entry of the connection
+	ID uint32
+}
+
+// newConntrackEntry returns the entry of a connection with the given translation
+func newConntrackEntry(c network.ConnectionStats, t *network.IPTranslation) *ConntrackEntry {
+	return &ConntrackEntry{
+		Proto: c.Type,
+		Origin: ConntrackTuple{
+			Src:   c.Source,
+			SPort: c.SPort,
+			Dst:   c.Dest,
+			DPort: c.DPort,
+		},
+		Reply: ConntrackTuple{
+			Src:   t.ReplSrcIP,
+			SPort: t.ReplSrcPort,
+			Dst:   t.ReplDstIP,
+			DPort: t.ReplDstPort,
+		},
+		Translation: t,
+	}
+}
+
+// connKey is the key of a direction of a connection. It is packed into a fixed size and holds no pointer, so the
+// keys of the state map aren't scanned by the GC.
+type connKey struct {
+	srcIP   keyAddr
+	srcPort uint16
+
+	dstIP   keyAddr
+	dstPort uint16
+
+	// the transport protocol of the connection, using the same values as specified in the agent payload.
+	transport network.ConnectionType
+
+	// the inode of the network namespace of the entry, 0 for the root namespace. The entries of other
+	// namespaces are kept apart, since their addresses may overlap with each other (eg. pod IPs).
+	netns uint32
+}
+
+// keyAddr is an address of a connKey, in its 16-byte form: IPv4 addresses are IPv4-mapped (::ffff:a.b.c.d), the way
+// a net.IP holds them. Unlike a util.Address, it doesn't point to its bytes.
+type keyAddr [16]byte
+
+// newKeyAddr returns the key address of an address. The IPv4-mapped IPv6 addresses stand for their IPv4 address.
+func newKeyAddr(a util.Address) keyAddr {
+	return util.To16(a)
+}
+
+func keyAddrFromNetIP(ip net.IP) keyAddr {
+	var a keyAddr
+	copy(a[:], ip.To16())
+	return a
+}
+
+// address returns the key address as a util.Address, IPv4 addresses being unmapped
+func (a keyAddr) address() util.Address {
+	return util.AddressFromNetIP(a[:])
+}
+
+// isIPv4 returns whether the key address is an IPv4 one
+func (a keyAddr) isIPv4() bool {
+	return net.IP(a[:]).To4() != nil
+}
+
+func (a keyAddr) String() string {
+	return net.IP(a[:]).String()
+}
+
+// connStatsToConnKey returns the key of a connection. The flows of dual-stack sockets have IPv4-mapped addresses,
+// while conntrack tracks them as IPv4 flows, which the key addresses don't tell apart.
+func connStatsToConnKey(c network.ConnectionStats) connKey {
+	return connKey{
+		srcIP:     newKeyAddr(c.Source),
+		srcPort:   c.SPort,
+		dstIP:     newKeyAddr(c.Dest),
+		dstPort:   c.DPort,
+		transport: c.Type,
+	}
+}
+
+func ipTranslationToConnKey(proto network.ConnectionType, t *network.IPTranslation) connKey {
+	return connKey{
+		srcIP:     newKeyAddr(t.ReplSrcIP),
+		dstIP:     newKeyAddr(t.ReplDstIP),
+		srcPort:   t.ReplSrcPort,
+		dstPort:   t.ReplDstPort,
+		transport: proto,
+	}
+}
+
+// natType returns which ends of the connection of the given key its translation rewrites: its source when the
+// replies aren't sent back to it, and its destination when the replies don't come from it
+func natType(k connKey, t *network.IPTranslation) network.NATType {
+	var typ network.NATType
+	if newKeyAddr(t.ReplDstIP) != k.srcIP || t.ReplDstPort != k.srcPort {
+		typ |= network.SNAT
+	}
+	if newKeyAddr(t.ReplSrcIP) != k.dstIP || t.ReplSrcPort != k.dstPort {
+		typ |= network.DNAT
+	}
+	return typ
+}
+
+// replyTranslation returns the translation of the connection of the given key, whose replies have the reply key
+func replyTranslation(k, reply connKey) *network.IPTranslation {
+	t := &network.IPTranslation{
+		ReplSrcIP:   reply.srcIP.address(),
+		ReplDstIP:   reply.dstIP.address(),
+		ReplSrcPort: reply.srcPort,
+		ReplDstPort: reply.dstPort,
+	}
+	t.NATType = natType(k, t)
+	return t
+}
+
+func debugConntrackEntry(k connKey, t *network.IPTranslation) network.DebugConntrackEntry {
+	var nat string
+	if t.NATType != 0 {
+		nat = t.NATType.String()
+	}
+	return network.DebugConntrackEntry{
+		Proto: k.transport.String(),
+		Origin: network.DebugConntrackTuple{
+			Src:   k.srcIP.String(),
+			SPort: k.srcPort,
+			Dst:   k.dstIP.String(),
+			DPort: k.dstPort,
+		},
+		Reply: network.DebugConntrackTuple{
+			Src:   t.ReplSrcIP.String(),
+			SPort: t.ReplSrcPort,
+			Dst:   t.ReplDstIP.String(),
+			DPort: t.ReplDstPort,
+		},
+		NATType: nat,
+	}
+}
+
+// countHits returns the number of connections a translation was found for
+func countHits(translations []*network.IPTranslation) int64 {
+	var hits int64
+	for _, t := range translations {
+		if t != nil {
+			hits++
+		}
+	}
+	return hits
+}
+
+// addHitStats adds the number of lookups which found a translation, or missed, to the given stats, along with
+// the percentage of hits. A low hit ratio means the connections aren't translated, or that the conntracker
+// misses their translations, eg. because the conntrack events are sampled or rate limited.
+func addHitStats(m map[string]int64, gets, hits int64) {
+	m["gets_hits"] = hits
+	m["gets_misses"] = gets - hits
+	if gets != 0 {
+		m["gets_hit_pct"] = hits * 100 / gets
+	}
+}
+
+// withLayer adds the given layer on top of the layers of a description
+func withLayer(d network.ConntrackDescription, layer string) network.ConntrackDescription {
+	d.Layers = append([]string{layer}, d.Layers...)
+	return d
+}
+
+// LookupTranslations returns the translations the given Conntracker has for the connections requested to the
+// conntrack lookup service, in the same order, nil for the connections which aren't translated
+func LookupTranslations(ctr Conntracker, conns []network.ConntrackLookupConn) ([]*network.DebugConntrackEntry, error) {
+	stats := make([]network.ConnectionStats, len(conns))
+	for i := range conns {
+		c, err := conns[i].ConnectionStats()
+		if err != nil {
+			return nil, err
+		}
+		stats[i] = c
+	}
+
+	translations := ctr.GetTranslationsForConns(stats)
+	entries := make([]*network.DebugConntrackEntry, len(conns))
+	for i := range conns {
+		entries[i] = network.ConntrackLookupResult(conns[i], translations[i])
+	}
+	return entries, nil
+}
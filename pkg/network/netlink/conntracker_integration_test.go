@@ -87,7 +87,7 @@ func TestConnTrackerCrossNamespaceAllNsDisabled(t *testing.T) {
 }
 
 func setupTestConnTrackerCrossNamespace(t *testing.T, enableAllNs bool) (Conntracker, io.Closer, *net.TCPAddr) {
-	ct, err := NewConntracker("/proc", 100, 500, enableAllNs)
+	ct, err := NewConntracker(&Config{ProcRoot: "/proc", MaxStateSize: 100, TargetRateLimit: 500, ListenAllNamespaces: enableAllNs})
 	require.NoError(t, err)
 
 	time.Sleep(time.Second)
@@ -122,7 +122,7 @@ func TestConntracker6(t *testing.T) {
 }
 
 func testConntracker(t *testing.T, serverIP, clientIP net.IP) {
-	ct, err := NewConntracker("/proc", 100, 500, false)
+	ct, err := NewConntracker(&Config{ProcRoot: "/proc", MaxStateSize: 100, TargetRateLimit: 500})
 	require.NoError(t, err)
 	defer ct.Close()
 	time.Sleep(100 * time.Millisecond)
@@ -215,7 +215,7 @@ func TestMessageDump6(t *testing.T) {
 }
 
 func testMessageDump(t *testing.T, f *os.File, serverIP, clientIP net.IP) {
-	consumer, err := NewConsumer("/proc", 500, false)
+	consumer, err := NewConsumer(&Config{ProcRoot: "/proc", TargetRateLimit: 500})
 	require.NoError(t, err)
 	events := consumer.Events()
 
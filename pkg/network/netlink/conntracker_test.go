@@ -5,14 +5,18 @@ package netlink
 
 import (
 	"crypto/rand"
+	"encoding/binary"
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/DataDog/datadog-agent/pkg/network"
 	"github.com/DataDog/datadog-agent/pkg/process/util"
-	ct "github.com/florianl/go-conntrack"
+	"github.com/mdlayher/netlink"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 )
 
 func TestIsNat(t *testing.T) {
@@ -23,70 +27,25 @@ func TestIsNat(t *testing.T) {
 	var dstPort uint16 = 8080
 
 	t.Run("not nat", func(t *testing.T) {
-
 		c := Con{
-			ct.Con{
-				Origin: &ct.IPTuple{
-					Src: &src,
-					Dst: &dst,
-					Proto: &ct.ProtoTuple{
-						SrcPort: &srcPort,
-						DstPort: &dstPort,
-					},
-				},
-				Reply: &ct.IPTuple{
-					Src: &dst,
-					Dst: &src,
-					Proto: &ct.ProtoTuple{
-						SrcPort: &dstPort,
-						DstPort: &srcPort,
-					},
-				},
-			},
-			0,
+			Origin: NewIPTuple(src, dst, srcPort, dstPort, unix.IPPROTO_TCP),
+			Reply:  NewIPTuple(dst, src, dstPort, srcPort, unix.IPPROTO_TCP),
 		}
 		assert.False(t, isNAT(c))
 	})
 
-	t.Run("nil proto field", func(t *testing.T) {
+	t.Run("no ports", func(t *testing.T) {
 		c := Con{
-			ct.Con{
-				Origin: &ct.IPTuple{
-					Src: &src,
-					Dst: &dst,
-				},
-				Reply: &ct.IPTuple{
-					Src: &dst,
-					Dst: &src,
-				},
-			},
-			0,
+			Origin: IPTuple{src: keyAddrFromNetIP(src), dst: keyAddrFromNetIP(dst), fields: tupleSrc | tupleDst},
+			Reply:  IPTuple{src: keyAddrFromNetIP(dst), dst: keyAddrFromNetIP(src), fields: tupleSrc | tupleDst},
 		}
 		assert.False(t, isNAT(c))
 	})
 
 	t.Run("nat", func(t *testing.T) {
-
 		c := Con{
-			ct.Con{
-				Origin: &ct.IPTuple{
-					Src: &src,
-					Dst: &dst,
-					Proto: &ct.ProtoTuple{
-						SrcPort: &srcPort,
-						DstPort: &dstPort,
-					},
-				},
-				Reply: &ct.IPTuple{
-					Src: &tdst,
-					Dst: &src,
-					Proto: &ct.ProtoTuple{
-						SrcPort: &dstPort,
-						DstPort: &srcPort,
-					},
-				},
-			},
-			0,
+			Origin: NewIPTuple(src, dst, srcPort, dstPort, unix.IPPROTO_TCP),
+			Reply:  NewIPTuple(tdst, src, dstPort, srcPort, unix.IPPROTO_TCP),
 		}
 		assert.True(t, isNAT(c))
 	})
@@ -129,6 +88,7 @@ func TestRegisterNat(t *testing.T) {
 		ReplDstIP:   util.AddressFromString("10.0.0.0"),
 		ReplSrcPort: 80,
 		ReplDstPort: 12345,
+		NATType:     network.DNAT,
 	}, translation)
 
 	udpTranslation := rt.GetTranslationForConn(
@@ -144,6 +104,218 @@ func TestRegisterNat(t *testing.T) {
 
 }
 
+func TestGetTranslationsForConns(t *testing.T) {
+	rt := newConntracker()
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+
+	conns := []network.ConnectionStats{
+		{Source: util.AddressFromString("10.0.0.0"), SPort: 12345, Dest: util.AddressFromString("50.30.40.10"), DPort: 80, Type: network.TCP},
+		{Source: util.AddressFromString("10.0.0.2"), SPort: 12345, Dest: util.AddressFromString("50.30.40.10"), DPort: 80, Type: network.TCP},
+		{Source: util.AddressFromString("10.0.0.1"), SPort: 12345, Dest: util.AddressFromString("50.30.40.10"), DPort: 80, Type: network.TCP},
+	}
+	translations := rt.GetTranslationsForConns(conns)
+	require.Len(t, translations, len(conns))
+	for i, c := range conns {
+		assert.Equal(t, rt.GetTranslationForConn(c), translations[i])
+	}
+	assert.Equal(t, util.AddressFromString("20.0.0.0"), translations[0].ReplSrcIP)
+	assert.Nil(t, translations[1])
+	assert.Equal(t, util.AddressFromString("20.0.0.1"), translations[2].ReplSrcIP)
+	assert.Equal(t, int64(2*len(conns)), rt.stats.gets)
+	assert.Equal(t, int64(4), rt.stats.getHits)
+
+	stats := make(map[string]int64)
+	addHitStats(stats, rt.stats.gets, rt.stats.getHits)
+	assert.Equal(t, int64(2), stats["gets_misses"])
+	assert.Equal(t, int64(66), stats["gets_hit_pct"])
+}
+
+func TestLookupTranslations(t *testing.T) {
+	rt := newConntracker()
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+
+	conns := []network.ConntrackLookupConn{
+		{Proto: "TCP", DebugConntrackTuple: network.DebugConntrackTuple{Src: "10.0.0.0", SPort: 12345, Dst: "50.30.40.10", DPort: 80}},
+		{Proto: "TCP", DebugConntrackTuple: network.DebugConntrackTuple{Src: "10.0.0.2", SPort: 12345, Dst: "50.30.40.10", DPort: 80}},
+	}
+	entries, err := LookupTranslations(rt, conns)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.NotNil(t, entries[0])
+	assert.Equal(t, conns[0].DebugConntrackTuple, entries[0].Origin)
+	assert.Equal(t, network.DebugConntrackTuple{Src: "20.0.0.0", SPort: 80, Dst: "10.0.0.0", DPort: 12345}, entries[0].Reply)
+	assert.Nil(t, entries[1])
+
+	_, err = LookupTranslations(rt, []network.ConntrackLookupConn{{Proto: "ICMP"}})
+	assert.Error(t, err)
+}
+
+func TestPublishedState(t *testing.T) {
+	rt := newConntracker()
+	first := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	second := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	firstConn := network.ConnectionStats{Source: util.AddressFromString("10.0.0.0"), SPort: 12345, Dest: util.AddressFromString("50.30.40.10"), DPort: 80, Type: network.TCP}
+	secondConn := network.ConnectionStats{Source: util.AddressFromString("10.0.0.1"), SPort: 12345, Dest: util.AddressFromString("50.30.40.10"), DPort: 80, Type: network.TCP}
+
+	// nothing is published yet, so translations are read from the state map
+	rt.register(first)
+	assert.True(t, rt.isStale())
+	assert.Nil(t, rt.lookupPublished(connStatsToConnKey(firstConn)))
+	assert.Equal(t, util.AddressFromString("20.0.0.0"), rt.GetTranslationForConn(firstConn).ReplSrcIP)
+
+	rt.publish()
+	assert.False(t, rt.isStale())
+	assert.Equal(t, util.AddressFromString("20.0.0.0"), rt.lookupPublished(connStatsToConnKey(firstConn)).ReplSrcIP)
+
+	// translations registered since the publication are read from the state map
+	rt.register(second)
+	assert.True(t, rt.isStale())
+	assert.Nil(t, rt.lookupPublished(connStatsToConnKey(secondConn)))
+	translations := rt.GetTranslationsForConns([]network.ConnectionStats{firstConn, secondConn})
+	assert.Equal(t, util.AddressFromString("20.0.0.0"), translations[0].ReplSrcIP)
+	assert.Equal(t, util.AddressFromString("20.0.0.1"), translations[1].ReplSrcIP)
+
+	// deleted translations are dropped from the published state right away
+	rt.unregister(first)
+	assert.Nil(t, rt.lookupPublished(connStatsToConnKey(firstConn)))
+	assert.Nil(t, rt.GetTranslationForConn(firstConn))
+	// both of its tuples are dropped
+	assert.Equal(t, 2, rt.getStateSizes().publishedDrops)
+
+	// and so are the modified ones, which are read from the state map until published again
+	modified := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.2"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	rt.publish()
+	rt.register(modified)
+	assert.Nil(t, rt.lookupPublished(connStatsToConnKey(secondConn)))
+	assert.Equal(t, util.AddressFromString("20.0.0.2"), rt.GetTranslationForConn(secondConn).ReplSrcIP)
+	rt.publish()
+	assert.Equal(t, util.AddressFromString("20.0.0.2"), rt.lookupPublished(connStatsToConnKey(secondConn)).ReplSrcIP)
+	assert.Zero(t, rt.getStateSizes().publishedDrops)
+	assert.Equal(t, int64(3), rt.stats.publishes)
+
+	// publishing an unmodified state is a no-op
+	rt.publish()
+	assert.Equal(t, int64(3), rt.stats.publishes)
+
+	// large state maps are published too, sharing their slots with the published copy
+	rt.state = newStateTable(1<<20, 1<<20)
+	rt.register(first)
+	rt.publish()
+	assert.Equal(t, int64(4), rt.stats.publishes)
+	assert.False(t, rt.isStale())
+	assert.Equal(t, util.AddressFromString("20.0.0.0"), rt.lookupPublished(connStatsToConnKey(firstConn)).ReplSrcIP)
+	assert.Zero(t, rt.getStateSizes().publishedSlots)
+	rt.register(second)
+	sizes := rt.getStateSizes()
+	assert.Equal(t, tableChunkSlots, sizes.publishedSlots)
+	assert.Equal(t, tableChunkSlots, sizes.publishedIndexSlots)
+}
+
+func TestNATType(t *testing.T) {
+	k := connKey{
+		srcIP:   keyAddrFromString("10.0.0.1"),
+		srcPort: 40000,
+		dstIP:   keyAddrFromString("10.96.0.1"),
+		dstPort: 80,
+	}
+	untranslated := &network.IPTranslation{
+		ReplSrcIP:   k.dstIP.address(),
+		ReplSrcPort: k.dstPort,
+		ReplDstIP:   k.srcIP.address(),
+		ReplDstPort: k.srcPort,
+	}
+	assert.Equal(t, network.NATType(0), natType(k, untranslated))
+
+	dnat := *untranslated
+	dnat.ReplSrcIP = util.AddressFromString("10.244.1.5")
+	assert.Equal(t, network.DNAT, natType(k, &dnat))
+
+	snat := *untranslated
+	snat.ReplDstPort = 61000
+	assert.Equal(t, network.SNAT, natType(k, &snat))
+
+	both := dnat
+	both.ReplDstIP = util.AddressFromString("192.168.1.1")
+	assert.Equal(t, network.SNAT|network.DNAT, natType(k, &both))
+	assert.Equal(t, "snat+dnat", natType(k, &both).String())
+}
+
+func TestNATGateways(t *testing.T) {
+	rt := newConntracker()
+	snatConn := func(src string, port uint16, gateway string) Con {
+		c := makeUntranslatedConn(net.ParseIP(src), net.ParseIP("8.8.8.8"), 6, port, 443)
+		c.Reply.dst = keyAddrFromString(gateway)
+		return c
+	}
+
+	first := snatConn("10.0.0.1", 40000, "192.168.1.1")
+	rt.register(first)
+	rt.register(snatConn("10.0.0.2", 40001, "192.168.1.1"))
+	rt.register(snatConn("10.0.0.3", 40002, "192.168.1.2"))
+	// destination NAT doesn't go through a gateway
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.4"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 40003, 80, 80))
+
+	assert.Equal(t, []util.Address{
+		util.AddressFromString("192.168.1.1"),
+		util.AddressFromString("192.168.1.2"),
+	}, rt.GetNATGateways())
+
+	rt.unregister(first)
+	rt.unregister(snatConn("10.0.0.3", 40002, "192.168.1.2"))
+	assert.Equal(t, []util.Address{util.AddressFromString("192.168.1.1")}, rt.GetNATGateways())
+}
+
+func TestRegisterSourceQuota(t *testing.T) {
+	rt := newConntracker()
+	rt.sourceQuota = newSourceQuota(1, false)
+
+	first := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	rt.register(first)
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.2"), net.ParseIP("50.30.40.10"), 6, 12346, 80, 80))
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.2"), net.ParseIP("20.0.0.3"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+	assert.Equal(t, 2, rt.state.len())
+	assert.Equal(t, int64(1), rt.getDropStats()["registers_dropped_quota"])
+
+	// the quota is released along with the connection
+	rt.unregister(first)
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.2"), net.ParseIP("50.30.40.10"), 6, 12346, 80, 80))
+	assert.Equal(t, 2, rt.state.len())
+}
+
+func TestRegisterZones(t *testing.T) {
+	rt := newConntracker()
+	rt.zoneFilter, _ = newZoneFilter([]string{"64000", "12"})
+	rt.entryZones = make(map[connKey]uint16)
+
+	// the connection is DNATed in zone 64000, and seen untranslated in zone 12
+	lb := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	lb.Zone = 64000
+	other := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.2"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	other.Zone = 12
+
+	rt.register(lb)
+	rt.register(other)
+	conn := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("50.30.40.10"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+	require.NotNil(t, rt.GetTranslationForConn(conn))
+	assert.Equal(t, "20.0.0.1", rt.GetTranslationForConn(conn).ReplSrcIP.String())
+	assert.Equal(t, int64(1), rt.getDropStats()["registers_dropped_zone"])
+
+	// the destruction of the connection in another zone leaves its translation
+	rt.unregister(other)
+	assert.NotNil(t, rt.GetTranslationForConn(conn))
+
+	rt.unregister(lb)
+	assert.Nil(t, rt.GetTranslationForConn(conn))
+	assert.Empty(t, rt.entryZones)
+}
+
 func TestRegisterNatUDP(t *testing.T) {
 	rt := newConntracker()
 	c := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 17, 12345, 80, 80)
@@ -164,6 +336,7 @@ func TestRegisterNatUDP(t *testing.T) {
 		ReplDstIP:   util.AddressFromString("10.0.0.0"),
 		ReplSrcPort: 80,
 		ReplDstPort: 12345,
+		NATType:     network.DNAT,
 	}, translation)
 
 	translation = rt.GetTranslationForConn(
@@ -178,6 +351,257 @@ func TestRegisterNatUDP(t *testing.T) {
 	assert.Nil(t, translation)
 }
 
+func TestResolveNATChain(t *testing.T) {
+	rt := newConntracker()
+
+	// 10.0.0.2:40000 -> 10.96.0.1:80 is DNAT'ed to 10.244.1.5:8080 in a first namespace
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.2"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8080, 80))
+
+	// and then masqueraded as 192.168.1.1:61000 in the root namespace
+	snat := makeTranslatedConn(net.ParseIP("10.0.0.2"), net.ParseIP("10.244.1.5"), net.ParseIP("10.244.1.5"), 6, 40000, 8080, 8080)
+	snat.Reply.dst, snat.Reply.dstPort = keyAddrFromString("192.168.1.1"), 61000
+	rt.register(snat)
+
+	client := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.2"),
+		SPort:  40000,
+		Dest:   util.AddressFromString("10.96.0.1"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+	assert.Equal(t, &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("10.244.1.5"),
+		ReplDstIP:   util.AddressFromString("192.168.1.1"),
+		ReplSrcPort: 8080,
+		ReplDstPort: 61000,
+		NATType:     network.SNAT | network.DNAT,
+	}, rt.GetTranslationForConn(client))
+
+	// the connection as seen from the server
+	server := network.ConnectionStats{
+		Source: util.AddressFromString("10.244.1.5"),
+		SPort:  8080,
+		Dest:   util.AddressFromString("192.168.1.1"),
+		DPort:  61000,
+		Type:   network.TCP,
+	}
+	assert.Equal(t, &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("10.0.0.2"),
+		ReplDstIP:   util.AddressFromString("10.96.0.1"),
+		ReplSrcPort: 40000,
+		ReplDstPort: 80,
+		NATType:     network.SNAT | network.DNAT,
+	}, rt.GetTranslationForConn(server))
+
+	assert.EqualValues(t, 2, rt.stats.chainsResolved)
+}
+
+func TestHairpinNAT(t *testing.T) {
+	rt := newConntracker()
+
+	// 10.244.1.5:40000 -> 10.96.0.1:80 is DNAT'ed back to 10.244.1.5:8080, the pod reaching itself through its service
+	c := makeTranslatedConn(net.ParseIP("10.244.1.5"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8080, 80)
+	assert.True(t, isHairpin(c))
+	assert.False(t, isHairpin(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)))
+	rt.register(c)
+	assert.EqualValues(t, 1, rt.stats.registersHairpin)
+
+	client := network.ConnectionStats{
+		Source: util.AddressFromString("10.244.1.5"),
+		SPort:  40000,
+		Dest:   util.AddressFromString("10.96.0.1"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+	server := network.ConnectionStats{
+		Source: util.AddressFromString("10.244.1.5"),
+		SPort:  8080,
+		Dest:   util.AddressFromString("10.244.1.5"),
+		DPort:  40000,
+		Type:   network.TCP,
+	}
+	assert.Equal(t, &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("10.244.1.5"),
+		ReplDstIP:   util.AddressFromString("10.244.1.5"),
+		ReplSrcPort: 8080,
+		ReplDstPort: 40000,
+		NATType:     network.DNAT,
+	}, rt.GetTranslationForConn(client))
+	assert.Equal(t, &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("10.244.1.5"),
+		ReplDstIP:   util.AddressFromString("10.96.0.1"),
+		ReplSrcPort: 40000,
+		ReplDstPort: 80,
+		NATType:     network.SNAT,
+	}, rt.GetTranslationForConn(server))
+
+	// both ends are local, closing one of them keeps the translation of the other
+	rt.DeleteTranslation(client)
+	assert.Nil(t, rt.GetTranslationForConn(client))
+	assert.NotNil(t, rt.GetTranslationForConn(server))
+
+	rt.DeleteTranslation(server)
+	assert.Nil(t, rt.GetTranslationForConn(server))
+	assert.Equal(t, 0, rt.state.len())
+}
+
+func TestHairpinNATServerClosesFirst(t *testing.T) {
+	c := makeTranslatedConn(net.ParseIP("10.244.1.5"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8080, 80)
+	c.Counters = Counters{SentPackets: 3, SentBytes: 300, RecvPackets: 5, RecvBytes: 500}
+	rt := newConntracker()
+	rt.counters = make(map[connKey]Counters)
+	rt.register(c)
+
+	client := network.ConnectionStats{
+		Source: util.AddressFromString("10.244.1.5"),
+		SPort:  40000,
+		Dest:   util.AddressFromString("10.96.0.1"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+	server := network.ConnectionStats{
+		Source: util.AddressFromString("10.244.1.5"),
+		SPort:  8080,
+		Dest:   util.AddressFromString("10.244.1.5"),
+		DPort:  40000,
+		Type:   network.TCP,
+	}
+
+	// closing the reply end first only stops finding the connection from it
+	rt.DeleteTranslation(server)
+	assert.Nil(t, rt.GetTranslationForConn(server))
+	assert.NotNil(t, rt.GetTranslationForConn(client))
+	assert.Equal(t, 1, rt.state.len())
+	assert.Equal(t, 0, rt.state.replies.len())
+
+	rt.DeleteTranslation(client)
+	assert.Nil(t, rt.GetTranslationForConn(client))
+	assert.Equal(t, 0, rt.state.len())
+
+	// closing the origin end first keeps the connection, now stored under its reply key, with its data reversed
+	rt.register(c)
+	rt.DeleteTranslation(client)
+	assert.Nil(t, rt.GetTranslationForConn(client))
+	assert.Equal(t, &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("10.244.1.5"),
+		ReplDstIP:   util.AddressFromString("10.96.0.1"),
+		ReplSrcPort: 40000,
+		ReplDstPort: 80,
+		NATType:     network.SNAT,
+	}, rt.GetTranslationForConn(server))
+	counters, ok := rt.GetCountersForConn(server)
+	require.True(t, ok)
+	assert.Equal(t, c.Counters.reversed(), counters)
+
+	rt.DeleteTranslation(server)
+	assert.Nil(t, rt.GetTranslationForConn(server))
+	assert.Equal(t, 0, rt.state.len())
+	assert.Equal(t, 0, rt.state.replies.len())
+}
+
+func TestNAT64(t *testing.T) {
+	rt := newConntracker()
+
+	// [2001:db8::1]:40000 -> [64:ff9b::c633:6401]:80 leaves the NAT64 gateway as 192.0.2.10:50000 -> 198.51.100.1:80
+	c := makeTranslatedConn(net.ParseIP("2001:db8::1"), net.ParseIP("198.51.100.1"), net.ParseIP("64:ff9b::c633:6401"), 6, 40000, 80, 80)
+	c.Reply.dst, c.Reply.dstPort = keyAddrFromString("192.0.2.10"), 50000
+	assert.True(t, isNAT64(c))
+	assert.False(t, isNAT64(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)))
+
+	// the mixed tuples go through the decoding untouched
+	data, err := EncodeConn(&c)
+	require.NoError(t, err)
+	var decoded int
+	rt.newDecoder().DecodeAndReleaseEvent(Event{msgs: []netlink.Message{{Data: append([]byte{unix.AF_INET6, unix.NFNETLINK_V0, 0, 0}, data...)}}}, func(c *Con) {
+		decoded++
+		rt.register(*c)
+	})
+	require.Equal(t, 1, decoded)
+	assert.EqualValues(t, 1, rt.stats.registersNAT64)
+
+	assert.Equal(t, &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("198.51.100.1"),
+		ReplDstIP:   util.AddressFromString("192.0.2.10"),
+		ReplSrcPort: 80,
+		ReplDstPort: 50000,
+		NATType:     network.SNAT | network.DNAT,
+	}, rt.GetTranslationForConn(network.ConnectionStats{
+		Source: util.AddressFromString("2001:db8::1"),
+		SPort:  40000,
+		Dest:   util.AddressFromString("64:ff9b::c633:6401"),
+		DPort:  80,
+		Type:   network.TCP,
+	}))
+	assert.Equal(t, &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("2001:db8::1"),
+		ReplDstIP:   util.AddressFromString("64:ff9b::c633:6401"),
+		ReplSrcPort: 40000,
+		ReplDstPort: 80,
+		NATType:     network.SNAT | network.DNAT,
+	}, rt.GetTranslationForConn(network.ConnectionStats{
+		Source: util.AddressFromString("198.51.100.1"),
+		SPort:  80,
+		Dest:   util.AddressFromString("192.0.2.10"),
+		DPort:  50000,
+		Type:   network.TCP,
+	}))
+}
+
+func TestDualStackLookup(t *testing.T) {
+	rt := newConntracker()
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+
+	// a dual-stack socket reports the IPv4-mapped addresses of an IPv4 flow
+	trans := rt.GetTranslationForConn(network.ConnectionStats{
+		Source: util.V6AddressFromBytes(net.ParseIP("::ffff:10.0.0.0").To16()),
+		SPort:  12345,
+		Dest:   util.V6AddressFromBytes(net.ParseIP("::ffff:50.30.40.10").To16()),
+		DPort:  80,
+		Type:   network.TCP,
+	})
+	require.NotNil(t, trans)
+	assert.Equal(t, util.AddressFromString("20.0.0.0"), trans.ReplSrcIP)
+}
+
+func TestNamespacedEntries(t *testing.T) {
+	rt := newConntracker()
+	rt.nsids = newNetnsIDs()
+	rt.nsids.set(1, 4026532001)
+	rt.nsids.set(2, 4026532002)
+
+	// two pods of different namespaces use the same addresses, and are translated differently
+	first := makeTranslatedConn(net.ParseIP("10.0.0.2"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8080, 80)
+	first.NetNS = 1
+	rt.register(first)
+	second := makeTranslatedConn(net.ParseIP("10.0.0.2"), net.ParseIP("10.244.2.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8080, 80)
+	second.NetNS = 2
+	rt.register(second)
+	// the host translates the connections of all the namespaces
+	root := makeTranslatedConn(net.ParseIP("10.0.0.3"), net.ParseIP("10.244.3.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8080, 80)
+	root.NetNS = noNSID
+	rt.register(root)
+
+	conn := func(src string, netns uint32) network.ConnectionStats {
+		return network.ConnectionStats{
+			Source: util.AddressFromString(src),
+			SPort:  40000,
+			Dest:   util.AddressFromString("10.96.0.1"),
+			DPort:  80,
+			Type:   network.TCP,
+			NetNS:  netns,
+		}
+	}
+	assert.Equal(t, util.AddressFromString("10.244.1.5"), rt.GetTranslationForConn(conn("10.0.0.2", 4026532001)).ReplSrcIP)
+	assert.Equal(t, util.AddressFromString("10.244.2.5"), rt.GetTranslationForConn(conn("10.0.0.2", 4026532002)).ReplSrcIP)
+	assert.Nil(t, rt.GetTranslationForConn(conn("10.0.0.2", 4026532003)))
+	assert.Equal(t, util.AddressFromString("10.244.3.5"), rt.GetTranslationForConn(conn("10.0.0.3", 4026532001)).ReplSrcIP)
+
+	rt.DeleteTranslation(conn("10.0.0.2", 4026532001))
+	assert.Nil(t, rt.GetTranslationForConn(conn("10.0.0.2", 4026532001)))
+	assert.NotNil(t, rt.GetTranslationForConn(conn("10.0.0.2", 4026532002)))
+	assert.Equal(t, 2, rt.state.len())
+}
+
 func TestTooManyEntries(t *testing.T) {
 	rt := newConntracker()
 	rt.maxStateSize = 1
@@ -185,6 +609,410 @@ func TestTooManyEntries(t *testing.T) {
 	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
 	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
 	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.2"), net.ParseIP("20.0.0.2"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+
+	// the first connection is stored with both of its ends, the others are rejected with both of theirs
+	assert.Equal(t, 1, rt.state.len())
+	stored := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	rejected := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	for _, tuple := range []IPTuple{stored.Origin, stored.Reply, rejected.Origin, rejected.Reply} {
+		k, _ := formatKey(tuple)
+		assert.Equal(t, tuple == stored.Origin || tuple == stored.Reply, rt.state.contains(k))
+	}
+
+	// the connection already stored is still updated while the state is full
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.3"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+	assert.Equal(t, 1, rt.state.len())
+	trans := rt.GetTranslationForConn(network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.0"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("50.30.40.10"),
+		DPort:  80,
+		Type:   network.TCP,
+	})
+	require.NotNil(t, trans)
+	assert.Equal(t, util.AddressFromString("20.0.0.3"), trans.ReplSrcIP)
+}
+
+func TestDropStats(t *testing.T) {
+	rt := newConntracker()
+	rt.maxStateSize = 1
+
+	rt.register(makeUntranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("50.30.40.10"), 6, 8080, 12345))
+	// the second connection doesn't fit in the state, it is rejected as a whole
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+
+	assert.Equal(t, map[string]int64{
+		"registers_dropped":              2,
+		"registers_dropped_non_nat":      1,
+		"registers_dropped_state_full":   1,
+		"registers_dropped_decode_error": 0,
+		"registers_dropped_filtered":     0,
+		"registers_dropped_backpressure": 0,
+	}, rt.getDropStats())
+}
+
+func TestUnregisterDestroyedConn(t *testing.T) {
+	rt := newConntracker()
+	c := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+
+	rt.register(c)
+	assert.Equal(t, 1, rt.state.len())
+
+	c.EventType = EventDestroy
+	rt.unregister(c)
+	assert.Equal(t, 0, rt.state.len())
+	assert.Equal(t, int64(1), rt.stats.destroys)
+}
+
+func TestUnregisterRecycledTuple(t *testing.T) {
+	rt := newConntracker()
+	c := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 17, 12345, 53, 53)
+	c.ID = 1
+	rt.register(c)
+
+	// a newer connection recycles the tuple before the DESTROY event of the first one is processed
+	recycled := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 17, 12345, 53, 53)
+	recycled.ID = 2
+	rt.register(recycled)
+	require.Equal(t, 1, rt.state.len())
+
+	// the first connection was replaced along with its origin tuple, and its reply tuple isn't cached anymore
+	c.EventType = EventDestroy
+	rt.unregister(c)
+	assert.Equal(t, 1, rt.state.len())
+	assert.Equal(t, int64(1), rt.stats.destroysRecycled)
+	k, _ := formatKey(recycled.Origin)
+	assert.Equal(t, uint32(2), rt.ids[k])
+	assert.Equal(t, uint32(2), rt.GetEntryForConn(network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.0"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("50.30.40.10"),
+		DPort:  53,
+		Type:   network.UDP,
+	}).ID)
+
+	// the entries destroyed without id are deleted whatever the id of the stored ones
+	c.ID = 0
+	rt.unregister(c)
+	assert.Zero(t, rt.state.len())
+	assert.Empty(t, rt.ids)
+}
+
+func TestGetTCPStateForConn(t *testing.T) {
+	rt := newConntracker()
+	c := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	c.TCPState = TCPStateEstablished
+	conn := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.0"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("50.30.40.10"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+
+	// TCP states aren't tracked by default
+	rt.register(c)
+	assert.Equal(t, TCPStateNone, rt.GetTCPStateForConn(conn))
+
+	rt.tcpStates = make(map[connKey]TCPState)
+	rt.register(c)
+	assert.Equal(t, TCPStateEstablished, rt.GetTCPStateForConn(conn))
+
+	c.TCPState = TCPStateTimeWait
+	rt.register(c)
+	assert.Equal(t, TCPStateTimeWait, rt.GetTCPStateForConn(conn))
+	// the connections which aren't translated, or aren't TCP connections, have no state
+	other := conn
+	other.SPort = 12346
+	udp := conn
+	udp.Type = network.UDP
+	assert.Equal(t, []TCPState{TCPStateTimeWait, TCPStateNone, TCPStateNone}, rt.GetTCPStatesForConns([]network.ConnectionStats{conn, other, udp}))
+	// the state is stored once for the connection
+	assert.Equal(t, 1, rt.state.len())
+	assert.Len(t, rt.tcpStates, 1)
+
+	rt.DeleteTranslation(conn)
+	assert.Equal(t, TCPStateNone, rt.GetTCPStateForConn(conn))
+	assert.Empty(t, rt.tcpStates)
+}
+
+func TestGetCountersForConn(t *testing.T) {
+	rt := newConntracker()
+	rt.counters = make(map[connKey]Counters)
+	c := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	c.Counters = Counters{SentPackets: 10, SentBytes: 1500, RecvPackets: 8, RecvBytes: 12000}
+	rt.register(c)
+
+	client := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.0"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("50.30.40.10"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+	counters, ok := rt.GetCountersForConn(client)
+	assert.True(t, ok)
+	assert.Equal(t, c.Counters, counters)
+
+	// the server sees the translated connection, and received what the client sent
+	server := network.ConnectionStats{
+		Source: util.AddressFromString("20.0.0.0"),
+		SPort:  80,
+		Dest:   util.AddressFromString("10.0.0.0"),
+		DPort:  12345,
+		Type:   network.TCP,
+	}
+	counters, ok = rt.GetCountersForConn(server)
+	assert.True(t, ok)
+	assert.Equal(t, Counters{SentPackets: 8, SentBytes: 12000, RecvPackets: 10, RecvBytes: 1500}, counters)
+
+	rt.DeleteTranslation(client)
+	_, ok = rt.GetCountersForConn(client)
+	assert.False(t, ok)
+	assert.Empty(t, rt.counters)
+}
+
+func TestGetStartTimeForConn(t *testing.T) {
+	rt := newConntracker()
+	rt.startTimes = make(map[connKey]uint64)
+	c := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	c.StartTime = 1600000000000000000
+	rt.register(c)
+
+	conn := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.0"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("50.30.40.10"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+	start, ok := rt.GetStartTimeForConn(conn)
+	assert.True(t, ok)
+	assert.Equal(t, time.Unix(1600000000, 0), start)
+
+	rt.DeleteTranslation(conn)
+	_, ok = rt.GetStartTimeForConn(conn)
+	assert.False(t, ok)
+	assert.Empty(t, rt.startTimes)
+}
+
+func TestGetEntryForConn(t *testing.T) {
+	rt := newConntracker()
+	rt.tcpStates = make(map[connKey]TCPState)
+	rt.startTimes = make(map[connKey]uint64)
+	c := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	c.TCPState = TCPStateEstablished
+	c.StartTime = uint64(time.Second)
+	conn := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.0"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("50.30.40.10"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+
+	assert.Nil(t, rt.GetEntryForConn(conn))
+	rt.register(c)
+
+	e := rt.GetEntryForConn(conn)
+	require.NotNil(t, e)
+	assert.Equal(t, network.TCP, e.Proto)
+	assert.Equal(t, ConntrackTuple{Src: conn.Source, SPort: 12345, Dst: conn.Dest, DPort: 80}, e.Origin)
+	assert.Equal(t, ConntrackTuple{
+		Src:   util.AddressFromString("20.0.0.0"),
+		SPort: 80,
+		Dst:   util.AddressFromString("10.0.0.0"),
+		DPort: 12345,
+	}, e.Reply)
+	assert.Equal(t, rt.GetTranslationForConn(conn), e.Translation)
+	assert.Equal(t, TCPStateEstablished, e.TCPState)
+	assert.Equal(t, time.Unix(0, int64(time.Second)), e.StartTime)
+	assert.Equal(t, Counters{}, e.Counters)
+}
+
+func TestDeleteTranslations(t *testing.T) {
+	rt := newConntracker()
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.2"), net.ParseIP("20.0.0.2"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+	require.Equal(t, 3, rt.state.len())
+
+	rt.DeleteTranslations([]network.ConnectionStats{
+		{Source: util.AddressFromString("10.0.0.0"), SPort: 12345, Dest: util.AddressFromString("50.30.40.10"), DPort: 80, Type: network.TCP},
+		// the connection as seen from the server
+		{Source: util.AddressFromString("20.0.0.2"), SPort: 80, Dest: util.AddressFromString("10.0.0.2"), DPort: 12345, Type: network.TCP},
+		// unknown connections are ignored
+		{Source: util.AddressFromString("10.0.0.3"), SPort: 12345, Dest: util.AddressFromString("50.30.40.10"), DPort: 80, Type: network.TCP},
+	})
+
+	assert.Equal(t, 1, rt.state.len())
+	assert.Equal(t, int64(2), rt.stats.unregisters)
+	k, _ := formatKey(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80).Origin)
+	assert.True(t, rt.state.contains(k))
+}
+
+func TestDumpCachedTable(t *testing.T) {
+	rt := newConntracker()
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+
+	// one entry per connection
+	entries := rt.DumpCachedTable()
+	assert.Len(t, entries, 1)
+	assert.Contains(t, entries, network.DebugConntrackEntry{
+		Proto:   "TCP",
+		Origin:  network.DebugConntrackTuple{Src: "10.0.0.0", SPort: 12345, Dst: "50.30.40.10", DPort: 80},
+		Reply:   network.DebugConntrackTuple{Src: "20.0.0.0", SPort: 80, Dst: "10.0.0.0", DPort: 12345},
+		NATType: "dnat",
+	})
+}
+
+func TestReconcile(t *testing.T) {
+	rt := newConntracker()
+	orphan := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	kept := makeTranslatedConn(net.ParseIP("10.0.0.2"), net.ParseIP("20.0.0.2"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	rt.register(orphan)
+	rt.register(kept)
+
+	before := make(map[connKey]struct{})
+	rt.state.forEach(func(k connKey, _ translationValue) bool {
+		before[k] = struct{}{}
+		return true
+	})
+
+	// the kernel table holds `kept` and a new entry the cache has missed
+	missing := makeTranslatedConn(net.ParseIP("10.0.0.3"), net.ParseIP("20.0.0.3"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	kernel := kernelView(kept, missing)
+
+	orphans, added := rt.reconcile(before, kernel)
+	assert.Equal(t, int64(1), orphans)
+	assert.Equal(t, int64(1), added)
+	assert.Equal(t, 2, rt.state.len())
+
+	k, _ := formatKey(orphan.Origin)
+	assert.False(t, rt.state.contains(k))
+	k, _ = formatKey(missing.Origin)
+	assert.True(t, rt.state.contains(k))
+}
+
+func TestReconcileBookkeeping(t *testing.T) {
+	rt := newConntracker()
+	rt.sourceQuota = newSourceQuota(1, false)
+	orphan := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	rt.register(orphan)
+
+	before := make(map[connKey]struct{})
+	rt.state.forEach(func(k connKey, _ translationValue) bool {
+		before[k] = struct{}{}
+		return true
+	})
+
+	// the missing connections are stored the same way as the registered ones, so they count towards the quota of
+	// their source, and their ids are recorded
+	missing := Con{
+		Origin: NewIPTuple(net.ParseIP("10.0.0.2"), net.ParseIP("50.30.40.10"), 12345, 80, 6),
+		Reply:  NewIPTuple(net.ParseIP("50.30.40.10"), net.ParseIP("1.1.1.1"), 80, 5000, 6),
+		ID:     42,
+	}
+	overQuota := Con{
+		Origin: NewIPTuple(net.ParseIP("10.0.0.2"), net.ParseIP("50.30.40.10"), 12346, 80, 6),
+		Reply:  NewIPTuple(net.ParseIP("50.30.40.10"), net.ParseIP("1.1.1.1"), 80, 5001, 6),
+	}
+	orphans, added := rt.reconcile(before, kernelView(missing))
+	assert.Equal(t, int64(1), orphans)
+	assert.Equal(t, int64(1), added)
+	assert.Equal(t, 1, rt.sourceQuota.sources())
+	assert.Equal(t, 1, rt.natGateways.len())
+	k, _ := formatKey(missing.Origin)
+	assert.False(t, rt.recycled(k, 42))
+	assert.True(t, rt.recycled(k, 43))
+
+	_, added = rt.reconcile(nil, kernelView(missing, overQuota))
+	assert.Equal(t, int64(0), added)
+	assert.Equal(t, 1, rt.state.len())
+
+	// the counts are released along with the orphans
+	orphans, _ = rt.reconcile(map[connKey]struct{}{k: {}}, nil)
+	assert.Equal(t, int64(1), orphans)
+	assert.Equal(t, 0, rt.sourceQuota.sources())
+	assert.Equal(t, 0, rt.natGateways.len())
+}
+
+// kernelView returns the connections of a dump by the keys of both their directions, the way they are reconciled
+func kernelView(conns ...Con) map[connKey]Con {
+	kernel := make(map[connKey]Con)
+	for _, c := range conns {
+		k, _ := formatKey(c.Origin)
+		kernel[k] = c
+		k, _ = formatKey(c.Reply)
+		kernel[k] = c
+	}
+	return kernel
+}
+
+func TestRefreshErrors(t *testing.T) {
+	rt := newConntracker()
+	rt.consumer = &Consumer{disableIPv6: true}
+	assert.Error(t, rt.Refresh(unix.AF_UNIX))
+	// the IPv6 entries are skipped, so there is nothing to refresh
+	assert.NoError(t, rt.Refresh(unix.AF_INET6))
+
+	rt.bootstrapping = 1
+	assert.Error(t, rt.Refresh(unix.AF_INET))
+}
+
+func TestInFamilies(t *testing.T) {
+	v4 := translationValue{replSrcIP: keyAddrFromString("20.0.0.1")}
+	v6 := translationValue{replSrcIP: keyAddrFromString("fd00::1")}
+	v4Key := connKey{srcIP: keyAddrFromString("10.0.0.1")}
+	v6Key := connKey{srcIP: keyAddrFromString("fd00::2")}
+
+	assert.True(t, inFamilies(v4Key, v4, []uint8{unix.AF_INET}))
+	assert.False(t, inFamilies(v4Key, v4, []uint8{unix.AF_INET6}))
+	assert.True(t, inFamilies(v6Key, v6, []uint8{unix.AF_INET6}))
+	// the entries of NAT64 connections are only reconciled along with both families
+	assert.False(t, inFamilies(v6Key, v4, []uint8{unix.AF_INET6}))
+	assert.False(t, inFamilies(v6Key, v4, []uint8{unix.AF_INET}))
+	assert.True(t, inFamilies(v6Key, v4, []uint8{unix.AF_INET, unix.AF_INET6}))
+}
+
+func TestKeyAddr(t *testing.T) {
+	v4 := util.AddressFromString("10.0.0.1")
+	assert.Equal(t, keyAddrFromString("10.0.0.1"), newKeyAddr(v4))
+	assert.Equal(t, v4, newKeyAddr(v4).address())
+	assert.True(t, newKeyAddr(v4).isIPv4())
+
+	v6 := util.AddressFromString("fd00::1")
+	assert.Equal(t, v6, newKeyAddr(v6).address())
+	assert.False(t, newKeyAddr(v6).isIPv4())
+
+	// the keys don't depend on how their addresses are held
+	assert.Equal(t,
+		connKey{srcIP: newKeyAddr(util.V4AddressFromBytes([]byte{10, 0, 0, 1}))},
+		connKey{srcIP: newKeyAddr(util.AddressFromNetIP(net.ParseIP("10.0.0.1")))},
+	)
+}
+
+func TestEventsHealthy(t *testing.T) {
+	rt := newConntracker()
+	rt.consumer = &Consumer{}
+	now := time.Now()
+	assert.True(t, rt.eventsHealthy(now))
+
+	// an event processed for too long is stalled
+	rt.processingSince = now.Add(-time.Second).UnixNano()
+	assert.True(t, rt.eventsHealthy(now))
+	rt.processingSince = now.Add(-2 * eventStallTimeout).UnixNano()
+	assert.False(t, rt.eventsHealthy(now))
+	rt.processingSince = 0
+
+	rt.consumer.restarting = 1
+	assert.False(t, rt.eventsHealthy(now))
+	rt.consumer.restarting = 0
+
+	// the event stream ending is only expected once the consumer is stopped
+	rt.eventsStopped()
+	assert.False(t, rt.eventsHealthy(now))
 }
 
 // Run this test with -memprofile to get an insight of how much memory is
@@ -202,41 +1030,28 @@ func TestConntrackerMemoryAllocation(t *testing.T) {
 
 func newConntracker() *realConntracker {
 	return &realConntracker{
-		state:                make(map[connKey]*network.IPTranslation),
+		clock:                realClock{},
+		state:                newConnTable(0),
+		ids:                  make(map[connKey]uint32),
 		maxStateSize:         10000,
+		natGateways:          newNATGateways(),
 		exceededSizeLogLimit: util.NewLogLimit(1, time.Minute),
 	}
 }
 
+func keyAddrFromString(ip string) keyAddr {
+	return keyAddrFromNetIP(net.ParseIP(ip))
+}
+
 func makeUntranslatedConn(src, dst net.IP, proto uint8, srcPort, dstPort uint16) Con {
 	return makeTranslatedConn(src, dst, dst, proto, srcPort, dstPort, dstPort)
 }
 
 // makes a translation where from -> to is shows as transFrom -> from
 func makeTranslatedConn(from, transFrom, to net.IP, proto uint8, fromPort, transFromPort, toPort uint16) Con {
-
 	return Con{
-		ct.Con{
-			Origin: &ct.IPTuple{
-				Src: &from,
-				Dst: &to,
-				Proto: &ct.ProtoTuple{
-					Number:  &proto,
-					SrcPort: &fromPort,
-					DstPort: &toPort,
-				},
-			},
-			Reply: &ct.IPTuple{
-				Src: &transFrom,
-				Dst: &from,
-				Proto: &ct.ProtoTuple{
-					Number:  &proto,
-					SrcPort: &transFromPort,
-					DstPort: &fromPort,
-				},
-			},
-		},
-		0,
+		Origin: NewIPTuple(from, to, fromPort, toPort, proto),
+		Reply:  NewIPTuple(transFrom, from, transFromPort, fromPort, proto),
 	}
 }
 
@@ -252,3 +1067,308 @@ func randomIPGen() func() net.IP {
 		}
 	}
 }
+
+func TestSkipUnreplied(t *testing.T) {
+	rt := newConntracker()
+	rt.skipUnreplied = true
+
+	// a DNS lookup to a dead server, which is only stored once it is replied to
+	c := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.6"), net.ParseIP("10.96.0.10"), 17, 40001, 53, 53)
+	c.Status = 0x8
+	rt.register(c)
+	assert.Zero(t, rt.state.len())
+	assert.Equal(t, int64(1), rt.getDropStats()["registers_dropped_unreplied"])
+
+	c.Status |= ipsSeenReply
+	c.EventType = EventUpdate
+	rt.register(c)
+	assert.Equal(t, 1, rt.state.len())
+
+	// the entries of unknown status aren't skipped
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.6"), net.ParseIP("10.96.0.10"), 17, 40002, 53, 53))
+	assert.Equal(t, 2, rt.state.len())
+
+	stats := rt.getDropStats()
+	assert.Equal(t, int64(1), stats["registers_dropped_unreplied"])
+	assert.Equal(t, int64(1), stats["registers_dropped"])
+}
+
+func TestSkipUnassured(t *testing.T) {
+	rt := newConntracker()
+	rt.skipUnassured = true
+
+	// a connection whose handshake didn't complete yet, which is only stored once it is assured
+	c := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.6"), net.ParseIP("10.96.0.10"), 6, 40001, 80, 80)
+	c.Status = uint32(StatusSeenReply) | 0x8
+	rt.register(c)
+	assert.Zero(t, rt.state.len())
+	assert.Equal(t, int64(1), rt.getDropStats()["registers_dropped_unassured"])
+
+	c.Status |= uint32(StatusAssured)
+	c.EventType = EventUpdate
+	rt.register(c)
+	assert.Equal(t, 1, rt.state.len())
+
+	// the entries of unknown status aren't skipped
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.6"), net.ParseIP("10.96.0.10"), 6, 40002, 80, 80))
+	assert.Equal(t, 2, rt.state.len())
+	assert.Equal(t, int64(1), rt.getDropStats()["registers_dropped_unassured"])
+}
+
+func TestGetEntryStatus(t *testing.T) {
+	rt := newConntracker()
+	rt.statuses = make(map[connKey]Status)
+	c := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	c.Status = uint32(StatusSeenReply | StatusAssured)
+	rt.register(c)
+
+	conn := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.0"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("50.30.40.10"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+	e := rt.GetEntryForConn(conn)
+	require.NotNil(t, e)
+	assert.True(t, e.Status.Assured())
+	assert.False(t, e.Status.Dying())
+	assert.Equal(t, e.Status, rt.GetStatusForConn(conn))
+
+	rt.DeleteTranslation(conn)
+	assert.Equal(t, Status(0), rt.GetStatusForConn(conn))
+	assert.Empty(t, rt.statuses)
+}
+
+func TestLatencyStats(t *testing.T) {
+	rt := newConntracker()
+	rt.clock = newFakeClock()
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("30.0.0.1"), 6, 12345, 80, 80))
+	rt.GetTranslationForConn(network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("30.0.0.1"),
+		DPort:  80,
+		Type:   network.TCP,
+	})
+
+	// the clock didn't move, so no time was spent
+	assert.Equal(t, int64(1), rt.stats.registers)
+	assert.Equal(t, int64(0), rt.stats.registersTotalTime)
+	assert.Equal(t, int64(1), rt.stats.getHits)
+	assert.Equal(t, int64(0), rt.stats.getTimeTotal)
+}
+
+func TestDestroysProcessedFirstNearCapacity(t *testing.T) {
+	rt := newConntracker()
+	rt.maxStateSize = 2
+
+	message := func(c Con, id uint32, typ netlink.HeaderType, flags netlink.HeaderFlags) netlink.Message {
+		data, err := EncodeConn(&c)
+		require.NoError(t, err)
+		ae := netlink.NewAttributeEncoder()
+		ae.ByteOrder = binary.BigEndian
+		ae.Uint32(ctaID, id)
+		attr, err := ae.Encode()
+		require.NoError(t, err)
+		return netlink.Message{
+			Header: netlink.Header{Type: netlink.HeaderType(unix.NFNL_SUBSYS_CTNETLINK<<8) | typ, Flags: flags},
+			Data:   append(data, attr...),
+		}
+	}
+	created := func(c Con, id uint32) netlink.Message { return message(c, id, 0, netlink.Create) }
+	destroyed := func(c Con, id uint32) netlink.Message { return message(c, id, ipctnlMsgCtDelete, 0) }
+
+	a := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	b := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	c := makeTranslatedConn(net.ParseIP("10.0.0.2"), net.ParseIP("20.0.0.2"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	d := makeTranslatedConn(net.ParseIP("10.0.0.3"), net.ParseIP("20.0.0.3"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	rt.register(a)
+	rt.register(b)
+	require.True(t, rt.nearCapacity())
+
+	// c is created before a is destroyed, but only fits once a is gone. d is created and destroyed in the batch.
+	events := make(chan Event, 2)
+	events <- Event{msgs: []netlink.Message{destroyed(a, 1), created(d, 4)}}
+	events <- Event{msgs: []netlink.Message{destroyed(d, 4)}}
+	rt.processDestroysFirst(rt.newDecoder(), Event{msgs: []netlink.Message{created(c, 3)}}, events, func(c *Con) {
+		if c.EventType == EventDestroy {
+			rt.unregister(*c)
+			return
+		}
+		rt.register(*c)
+	})
+
+	assert.Len(t, events, 0)
+	assert.Equal(t, 2, rt.state.len())
+	for _, conn := range []Con{b, c} {
+		k, _ := formatKey(conn.Origin)
+		assert.True(t, rt.state.contains(k))
+	}
+	assert.EqualValues(t, 2, rt.stats.destroysPrioritized)
+	assert.EqualValues(t, 0, rt.stats.registersStateFull)
+}
+
+func TestNewEventsSkippedWhenFull(t *testing.T) {
+	rt := newConntracker()
+	rt.maxStateSize = 1
+
+	message := func(c Con, typ netlink.HeaderType, flags netlink.HeaderFlags) netlink.Message {
+		data, err := EncodeConn(&c)
+		require.NoError(t, err)
+		return netlink.Message{
+			Header: netlink.Header{Type: netlink.HeaderType(unix.NFNL_SUBSYS_CTNETLINK<<8) | typ, Flags: flags},
+			Data:   data,
+		}
+	}
+
+	a := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	b := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	c := makeTranslatedConn(net.ParseIP("10.0.0.2"), net.ParseIP("20.0.0.2"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	rt.register(a)
+	assert.False(t, rt.isStateFull())
+	rt.register(b)
+	require.True(t, rt.isStateFull())
+
+	var handled []Con
+	handle := func(c *Con) {
+		handled = append(handled, *c)
+		rt.processEvent(c)
+	}
+	rt.skipNewEvents(rt.newDecoder(), Event{msgs: []netlink.Message{
+		message(c, 0, netlink.Create),
+		message(a, ipctnlMsgCtDelete, 0),
+	}}, handle)
+
+	require.Len(t, handled, 1)
+	assert.Equal(t, EventDestroy, handled[0].EventType)
+	assert.Equal(t, 0, rt.state.len())
+	assert.EqualValues(t, 1, rt.getDropStats()["registers_dropped_backpressure"])
+	// the entries destroyed made room for the new ones
+	assert.False(t, rt.isStateFull())
+}
+
+func TestLoadInitialStateWhileBootstrapping(t *testing.T) {
+	rt := newConntracker()
+	rt.bootstrapping = 1
+	rt.bootstrapDestroyed = make(map[connKey]struct{})
+
+	a := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	staleA := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.9"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	b := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	c := makeTranslatedConn(net.ParseIP("10.0.0.2"), net.ParseIP("20.0.0.2"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+
+	// the live events are processed while the table is dumped: a is registered again, and b is destroyed
+	rt.register(a)
+	rt.register(b)
+	rt.unregister(b)
+
+	events := make(chan Event, 1)
+	events <- Event{msgs: []netlink.Message{dumpedMessage(t, staleA), dumpedMessage(t, b), dumpedMessage(t, c)}}
+	close(events)
+	rt.loadInitialState(events)
+
+	kb, _ := formatKey(b.Origin)
+	assert.False(t, rt.state.contains(kb))
+	kc, _ := formatKey(c.Origin)
+	assert.True(t, rt.state.contains(kc))
+	// the dump doesn't override the more recent live entry
+	ka, _ := formatKey(a.Origin)
+	trans, _ := rt.state.get(ka)
+	assert.Equal(t, util.AddressFromString("20.0.0.0"), trans.translation().ReplSrcIP)
+}
+
+func TestLoadInitialFamilies(t *testing.T) {
+	rt := newConntracker()
+	// the dumps of a replay are part of the recording, so both of them end right away
+	rt.consumer = &Consumer{replayPath: "recording"}
+	assert.True(t, rt.loadInitialFamilies())
+	assert.Zero(t, rt.state.len())
+}
+
+func TestDumpTimeout(t *testing.T) {
+	clk := newFakeClock()
+	rt := newConntracker()
+	rt.clock = clk
+	rt.dumpTimeout = time.Minute
+
+	events := make(chan Event)
+	var timedOut bool
+	forwarded := rt.withDumpTimeout(events, &timedOut)
+	events <- Event{}
+	<-forwarded
+
+	// the events left once the timeout expired are released without being forwarded
+	clk.Add(time.Minute)
+	_, ok := <-forwarded
+	assert.False(t, ok)
+	assert.True(t, timedOut)
+	select {
+	case events <- Event{}:
+	case <-time.After(time.Second):
+		require.Fail(t, "the events left weren't released")
+	}
+	close(events)
+}
+
+func TestLoadInitialStateParallel(t *testing.T) {
+	conns, stats := makeBenchmarkConns(1000)
+	events := make(chan Event, len(conns)/10)
+	for i := 0; i < len(conns); i += 10 {
+		var msgs []netlink.Message
+		for _, c := range conns[i : i+10] {
+			msgs = append(msgs, dumpedMessage(t, c))
+		}
+		events <- Event{msgs: msgs}
+	}
+	close(events)
+
+	rt := newConntracker()
+	rt.loadInitialState(events)
+	assert.Equal(t, len(conns), rt.state.len())
+	for i, c := range stats {
+		require.NotNil(t, rt.GetTranslationForConn(c))
+		assert.Equal(t, util.AddressFromNetIP(conns[i].Reply.Src()), rt.GetTranslationForConn(c).ReplSrcIP)
+	}
+}
+
+// dumpedMessage returns the message of a conntrack table dump holding the given entry
+func dumpedMessage(t *testing.T, c Con) netlink.Message {
+	data, err := EncodeConn(&c)
+	require.NoError(t, err)
+	return netlink.Message{
+		Header: netlink.Header{Type: netlink.HeaderType(unix.NFNL_SUBSYS_CTNETLINK << 8)},
+		Data:   data,
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	rt := newConntracker()
+	rt.consumer = &Consumer{
+		targetRateLimit:     500,
+		groups:              []uint32{netlinkCtNew, netlinkCtDestroy},
+		listenAllNamespaces: true,
+	}
+	rt.bootstrapping = 1
+	assert.Equal(t, network.ConntrackDescription{
+		Backend:             "netlink",
+		Status:              "loading",
+		TargetRateLimit:     500,
+		MaxStateSize:        10000,
+		Storage:             "table",
+		ListenAllNamespaces: true,
+		NetlinkGroups:       []string{"new", "destroy"},
+	}, rt.Describe())
+
+	// the layers wrapping the conntracker are listed, the outermost first
+	rt.bootstrapping = 0
+	atomic.StoreInt64(&rt.consumer.targetRateLimit, 100)
+	ctr, err := NewCloudNATConntracker(newContainerConntracker(rt, &netnsContainers{}), []string{"34.120.0.0/24"})
+	require.NoError(t, err)
+	d := ctr.Describe()
+	assert.Equal(t, "ready", d.Status)
+	assert.Equal(t, 100, d.TargetRateLimit)
+	assert.Equal(t, []string{"cloud_nat", "containers"}, d.Layers)
+
+	assert.Equal(t, network.ConntrackDescription{Backend: "noop", Status: "disabled"}, NewNoOpConntracker().Describe())
+}
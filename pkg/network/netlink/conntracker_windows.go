@@ -0,0 +1,430 @@
+// +build windows
+
+package netlink
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+	"github.com/StackExchange/wmi"
+)
+
+const (
+	// winnatPollInterval is the interval at which the WinNAT session table is read
+	winnatPollInterval = 10 * time.Second
+
+	winnatNamespace = `root\StandardCimv2`
+	winnatQuery     = "SELECT InternalSourceAddress, InternalSourcePort, InternalDestinationAddress, InternalDestinationPort, " +
+		"ExternalSourceAddress, ExternalSourcePort, ExternalDestinationAddress, ExternalDestinationPort, Protocol FROM MSFT_NetNatSession"
+
+	ipProtoTCP = 6
+	ipProtoUDP = 17
+)
+
+// netNatSession is a session of the WinNAT translation table, as exposed by the MSFT_NetNatSession WMI class
+type netNatSession struct {
+	InternalSourceAddress      string
+	InternalSourcePort         uint16
+	InternalDestinationAddress string
+	InternalDestinationPort    uint16
+	ExternalSourceAddress      string
+	ExternalSourcePort         uint16
+	ExternalDestinationAddress string
+	ExternalDestinationPort    uint16
+	Protocol                   uint8
+}
+
+// winnatConntracker keeps a record of the translations done by WinNAT, which is used by the container networks
+// of Windows hosts. WinNAT doesn't report events, so its session table is polled periodically.
+type winnatConntracker struct {
+	sync.RWMutex
+	state map[connKey]*network.IPTranslation
+	// replies holds the keys of the state map which are the reply directions of the sessions
+	replies map[connKey]struct{}
+
+	// The maximum size the state map will grow before we reject new entries
+	maxStateSize int
+
+	// natGateways counts the source NATed sessions of the last poll by the address they are NATed to
+	natGateways *natGateways
+
+	pollTicker *time.Ticker
+	exit       chan struct{}
+
+	exceededSizeLogLimit *util.LogLimit
+
+	// errors keeps the last poll errors
+	errors *errorLog
+
+	// notifier is notified about the differences between successive polls
+	notifier translationNotifier
+
+	// telemetry is reported the typed stats after each poll, it is nil when they aren't reported
+	telemetry StatsReporter
+
+	stats struct {
+		gets              int64
+		getHits           int64
+		getTimeTotal      int64
+		polls             int64
+		pollErrors        int64
+		pollTimeTotal     int64
+		unregisters       int64
+		sessionsDropped   int64
+		lastPollSessions  int64
+		lastPollTimestamp int64
+	}
+}
+
+// NewConntracker creates a new conntracker backed by the WinNAT session table
+func NewConntracker(cfg *Config) (Conntracker, error) {
+	ctr := &winnatConntracker{
+		state:                make(map[connKey]*network.IPTranslation),
+		replies:              make(map[connKey]struct{}),
+		maxStateSize:         cfg.MaxStateSize,
+		pollTicker:           time.NewTicker(winnatPollInterval),
+		exit:                 make(chan struct{}),
+		exceededSizeLogLimit: util.NewLogLimit(10, time.Minute*10),
+		errors:               newErrorLog(maxRecentErrors),
+		telemetry:            cfg.StatsReporter,
+	}
+
+	// make sure the WinNAT session table can be read before starting to poll it
+	if err := ctr.poll(); err != nil {
+		ctr.pollTicker.Stop()
+		return nil, fmt.Errorf("could not read the WinNAT session table: %w", err)
+	}
+
+	go ctr.run()
+
+	log.Infof("initialized WinNAT conntracker with a poll interval of %s", winnatPollInterval)
+	return ctr, nil
+}
+
+func (ctr *winnatConntracker) GetTranslationForConn(c network.ConnectionStats) *network.IPTranslation {
+	then := time.Now().UnixNano()
+
+	ctr.RLock()
+	defer ctr.RUnlock()
+
+	result := ctr.state[connStatsToConnKey(c)]
+
+	now := time.Now().UnixNano()
+	atomic.AddInt64(&ctr.stats.gets, 1)
+	if result != nil {
+		atomic.AddInt64(&ctr.stats.getHits, 1)
+	}
+	atomic.AddInt64(&ctr.stats.getTimeTotal, now-then)
+	return result
+}
+
+// GetEntryForConn returns both directions of the translation of a connection. WinNAT doesn't expose the state,
+// counters or start time of the sessions.
+func (ctr *winnatConntracker) GetEntryForConn(c network.ConnectionStats) *ConntrackEntry {
+	if t := ctr.GetTranslationForConn(c); t != nil {
+		return newConntrackEntry(c, t)
+	}
+	return nil
+}
+
+// GetTranslationsForConns returns the translations of the given connections, in the same order,
+// taking the lock only once
+func (ctr *winnatConntracker) GetTranslationsForConns(conns []network.ConnectionStats) []*network.IPTranslation {
+	then := time.Now().UnixNano()
+	translations := make([]*network.IPTranslation, len(conns))
+
+	ctr.RLock()
+	for i := range conns {
+		translations[i] = ctr.state[connStatsToConnKey(conns[i])]
+	}
+	ctr.RUnlock()
+
+	now := time.Now().UnixNano()
+	atomic.AddInt64(&ctr.stats.gets, int64(len(conns)))
+	atomic.AddInt64(&ctr.stats.getHits, countHits(translations))
+	atomic.AddInt64(&ctr.stats.getTimeTotal, now-then)
+	return translations
+}
+
+// typedStats returns the stats every conntracker has, which are cheap enough to be reported to the telemetry.
+// The WinNAT sessions aren't registered one by one, so there are no registration stats.
+func (ctr *winnatConntracker) typedStats() Stats {
+	ctr.RLock()
+	size := len(ctr.state)
+	ctr.RUnlock()
+
+	return Stats{
+		StateSize:        int64(size),
+		Gets:             atomic.LoadInt64(&ctr.stats.gets),
+		GetHits:          atomic.LoadInt64(&ctr.stats.getHits),
+		RegistersDropped: atomic.LoadInt64(&ctr.stats.sessionsDropped),
+		Unregisters:      atomic.LoadInt64(&ctr.stats.unregisters),
+	}
+}
+
+func (ctr *winnatConntracker) GetStats() Stats {
+	s := ctr.typedStats()
+	ctr.RLock()
+	gateways := ctr.natGateways.len()
+	ctr.RUnlock()
+
+	polls := atomic.LoadInt64(&ctr.stats.polls)
+	m := map[string]int64{
+		"polls_total":        polls,
+		"poll_errors_total":  atomic.LoadInt64(&ctr.stats.pollErrors),
+		"sessions_dropped":   atomic.LoadInt64(&ctr.stats.sessionsDropped),
+		"last_poll_sessions": atomic.LoadInt64(&ctr.stats.lastPollSessions),
+		"last_poll":          atomic.LoadInt64(&ctr.stats.lastPollTimestamp),
+		"nat_gateways":       int64(gateways),
+	}
+	for k, v := range ctr.notifier.getStats() {
+		m[k] = v
+	}
+
+	if s.Gets != 0 {
+		m["nanoseconds_per_get"] = atomic.LoadInt64(&ctr.stats.getTimeTotal) / s.Gets
+	}
+	if polls != 0 {
+		m["nanoseconds_per_poll"] = atomic.LoadInt64(&ctr.stats.pollTimeTotal) / polls
+	}
+
+	s.Extra = m
+	return s
+}
+
+// DeleteTranslation removes the translation of a closed connection. It would be removed at the next poll anyway,
+// but this avoids attributing it to a new connection reusing the same tuple in the meantime.
+func (ctr *winnatConntracker) DeleteTranslation(c network.ConnectionStats) {
+	ctr.Lock()
+	defer ctr.Unlock()
+	ctr.deleteTranslation(c)
+}
+
+// DeleteTranslations removes the translations of the given connections, taking the lock only once
+func (ctr *winnatConntracker) DeleteTranslations(conns []network.ConnectionStats) {
+	ctr.Lock()
+	defer ctr.Unlock()
+	for i := range conns {
+		ctr.deleteTranslation(conns[i])
+	}
+}
+
+// deleteTranslation must be called with the write lock held
+func (ctr *winnatConntracker) deleteTranslation(c network.ConnectionStats) {
+	k := connStatsToConnKey(c)
+	for _, key := range []connKey{k, {srcIP: k.dstIP, srcPort: k.dstPort, dstIP: k.srcIP, dstPort: k.srcPort, transport: k.transport}} {
+		t, ok := ctr.state[key]
+		if !ok {
+			continue
+		}
+
+		reverse := ipTranslationToConnKey(key.transport, t)
+		if rt, ok := ctr.state[reverse]; ok {
+			ctr.notifyDeleted(reverse, rt)
+			delete(ctr.state, reverse)
+			ctr.natGateways.forget(reverse)
+		}
+		ctr.notifyDeleted(key, t)
+		delete(ctr.state, key)
+		ctr.natGateways.forget(key)
+		atomic.AddInt64(&ctr.stats.unregisters, 1)
+		return
+	}
+}
+
+// notifyDeleted notifies about the deletion of the given key of the state map, and forgets whether it is the reply
+// direction of its session. It must be called with the write lock held.
+func (ctr *winnatConntracker) notifyDeleted(k connKey, t *network.IPTranslation) {
+	_, reply := ctr.replies[k]
+	delete(ctr.replies, k)
+	ctr.notifier.notifyDirection(TranslationDeleted, k, t, reply)
+}
+
+// DumpCachedTable returns a snapshot of all translations currently held in the cache
+func (ctr *winnatConntracker) DumpCachedTable() []network.DebugConntrackEntry {
+	ctr.RLock()
+	defer ctr.RUnlock()
+
+	entries := make([]network.DebugConntrackEntry, 0, len(ctr.state))
+	for k, t := range ctr.state {
+		entries = append(entries, debugConntrackEntry(k, t))
+	}
+	return entries
+}
+
+// Subscribe returns a channel notified about the differences between successive reads of the WinNAT session table
+func (ctr *winnatConntracker) Subscribe(filter TranslationFilter) (<-chan TranslationEvent, func()) {
+	return ctr.notifier.subscribe(filter)
+}
+
+// GetTCPStateForConn always returns TCPStateNone, since WinNAT doesn't expose the state of the sessions
+func (ctr *winnatConntracker) GetTCPStateForConn(c network.ConnectionStats) TCPState {
+	return TCPStateNone
+}
+
+// GetTCPStatesForConns always returns TCPStateNone for all the connections
+func (ctr *winnatConntracker) GetTCPStatesForConns(conns []network.ConnectionStats) []TCPState {
+	return make([]TCPState, len(conns))
+}
+
+// GetCountersForConn always returns false, since WinNAT doesn't count the traffic of the sessions
+func (ctr *winnatConntracker) GetCountersForConn(c network.ConnectionStats) (Counters, bool) {
+	return Counters{}, false
+}
+
+// GetStartTimeForConn always returns false, since WinNAT doesn't expose the creation time of the sessions
+func (ctr *winnatConntracker) GetStartTimeForConn(c network.ConnectionStats) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// GetStatusForConn always returns 0, since WinNAT sessions have no conntrack status
+func (ctr *winnatConntracker) GetStatusForConn(c network.ConnectionStats) Status {
+	return 0
+}
+
+// GetNATGateways returns the addresses WinNAT source NATed the connections of the last poll to
+func (ctr *winnatConntracker) GetNATGateways() []util.Address {
+	ctr.RLock()
+	defer ctr.RUnlock()
+	return ctr.natGateways.addresses()
+}
+
+// GetRecentErrors returns the last errors reading the WinNAT session table
+func (ctr *winnatConntracker) GetRecentErrors() []string {
+	return ctr.errors.recent()
+}
+
+// Refresh reads the WinNAT session table right away. All its sessions are read, whatever the family.
+func (ctr *winnatConntracker) Refresh(family uint8) error {
+	return ctr.poll()
+}
+
+// SetTargetRateLimit does nothing, since the WinNAT session table is polled rather than streamed
+func (ctr *winnatConntracker) SetTargetRateLimit(limit int) {}
+
+// Describe returns the WinNAT backend, which is ready as soon as it is created
+func (ctr *winnatConntracker) Describe() network.ConntrackDescription {
+	return network.ConntrackDescription{
+		Backend:      "winnat",
+		Status:       "ready",
+		MaxStateSize: ctr.maxStateSize,
+	}
+}
+
+func (ctr *winnatConntracker) Close() {
+	ctr.pollTicker.Stop()
+	close(ctr.exit)
+}
+
+func (ctr *winnatConntracker) run() {
+	for {
+		select {
+		case <-ctr.pollTicker.C:
+			if err := ctr.poll(); err != nil {
+				log.Warnf("could not read the WinNAT session table: %s", err)
+			}
+			reportStats(ctr.telemetry, ctr.typedStats())
+		case <-ctr.exit:
+			return
+		}
+	}
+}
+
+// poll reads the whole WinNAT session table and replaces the cache with its content
+func (ctr *winnatConntracker) poll() error {
+	then := time.Now()
+	defer func() {
+		atomic.AddInt64(&ctr.stats.polls, 1)
+		atomic.AddInt64(&ctr.stats.pollTimeTotal, time.Since(then).Nanoseconds())
+		atomic.StoreInt64(&ctr.stats.lastPollTimestamp, then.Unix())
+	}()
+
+	var sessions []netNatSession
+	if err := wmi.QueryNamespace(winnatQuery, &sessions, winnatNamespace); err != nil {
+		atomic.AddInt64(&ctr.stats.pollErrors, 1)
+		ctr.errors.add("could not read the WinNAT session table: %s", err)
+		return err
+	}
+
+	state := make(map[connKey]*network.IPTranslation, 2*len(sessions))
+	replies := make(map[connKey]struct{}, len(sessions))
+	gateways := newNATGateways()
+	for _, s := range sessions {
+		origin, reply, ok := sessionTuples(s)
+		if !ok {
+			continue
+		}
+
+		if len(state) >= ctr.maxStateSize {
+			atomic.AddInt64(&ctr.stats.sessionsDropped, 1)
+			ctr.logExceededSize()
+			continue
+		}
+
+		state[origin] = replyTranslation(origin, reply)
+		state[reply] = replyTranslation(reply, origin)
+		replies[reply] = struct{}{}
+		gateways.observe(origin, reply.dstIP.address())
+	}
+	atomic.StoreInt64(&ctr.stats.lastPollSessions, int64(len(sessions)))
+
+	ctr.Lock()
+	ctr.notifier.notifyDiff(ctr.state, state, ctr.replies, replies)
+	ctr.state = state
+	ctr.replies = replies
+	ctr.natGateways = gateways
+	ctr.Unlock()
+	return nil
+}
+
+func (ctr *winnatConntracker) logExceededSize() {
+	if ctr.exceededSizeLogLimit.ShouldLog() {
+		log.Warnf("exceeded maximum WinNAT conntrack state size: %d entries. You may need to increase system_probe_config.conntrack_max_state_size (will log first ten times, and then once every 10 minutes)", ctr.maxStateSize)
+	}
+}
+
+// sessionTuples returns the origin and reply tuples of a WinNAT session, the same way they are reported by
+// conntrack: the origin tuple is the flow seen on the internal network, and the reply tuple is the flow
+// of the response seen on the external network.
+func sessionTuples(s netNatSession) (origin, reply connKey, ok bool) {
+	var transport network.ConnectionType
+	switch s.Protocol {
+	case ipProtoTCP:
+		transport = network.TCP
+	case ipProtoUDP:
+		transport = network.UDP
+	default:
+		return origin, reply, false
+	}
+
+	var addrs [4]keyAddr
+	for i, a := range []string{s.InternalSourceAddress, s.InternalDestinationAddress, s.ExternalSourceAddress, s.ExternalDestinationAddress} {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			return origin, reply, false
+		}
+		addrs[i] = keyAddrFromNetIP(ip)
+	}
+
+	origin = connKey{
+		srcIP:     addrs[0],
+		srcPort:   s.InternalSourcePort,
+		dstIP:     addrs[1],
+		dstPort:   s.InternalDestinationPort,
+		transport: transport,
+	}
+	reply = connKey{
+		srcIP:     addrs[3],
+		srcPort:   s.ExternalDestinationPort,
+		dstIP:     addrs[2],
+		dstPort:   s.ExternalSourcePort,
+		transport: transport,
+	}
+	return origin, reply, true
+}
@@ -0,0 +1,52 @@
+// +build windows
+
+package netlink
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionTuples(t *testing.T) {
+	s := netNatSession{
+		InternalSourceAddress:      "172.20.0.2",
+		InternalSourcePort:         49152,
+		InternalDestinationAddress: "2.2.2.2",
+		InternalDestinationPort:    443,
+		ExternalSourceAddress:      "10.0.0.4",
+		ExternalSourcePort:         61000,
+		ExternalDestinationAddress: "2.2.2.2",
+		ExternalDestinationPort:    443,
+		Protocol:                   ipProtoTCP,
+	}
+
+	origin, reply, ok := sessionTuples(s)
+	require.True(t, ok)
+	assert.Equal(t, connKey{
+		srcIP:     newKeyAddr(util.AddressFromString("172.20.0.2")),
+		srcPort:   49152,
+		dstIP:     newKeyAddr(util.AddressFromString("2.2.2.2")),
+		dstPort:   443,
+		transport: network.TCP,
+	}, origin)
+	assert.Equal(t, connKey{
+		srcIP:     newKeyAddr(util.AddressFromString("2.2.2.2")),
+		srcPort:   443,
+		dstIP:     newKeyAddr(util.AddressFromString("10.0.0.4")),
+		dstPort:   61000,
+		transport: network.TCP,
+	}, reply)
+
+	s.Protocol = 1
+	_, _, ok = sessionTuples(s)
+	assert.False(t, ok)
+
+	s.Protocol = ipProtoUDP
+	s.ExternalSourceAddress = "not an ip"
+	_, _, ok = sessionTuples(s)
+	assert.False(t, ok)
+}
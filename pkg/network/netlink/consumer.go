@@ -10,6 +10,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/vishvananda/netns"
@@ -28,6 +29,12 @@ const (
 	// http://man7.org/linux/man-pages/man7/netlink.7.html
 	netlinkCtNew = uint32(1)
 
+	// netlinkCtUpdate is the multicast group of conntrack entry update events
+	netlinkCtUpdate = uint32(2)
+
+	// netlinkCtDestroy is the multicast group of conntrack entry removal events
+	netlinkCtDestroy = uint32(3)
+
 	// ipctnlMsgCtGet represents the Conntrack message type used during the initial load.
 	// This value is defined in include/uapi/linux/netfilter/nfnetlink_conntrack.h
 	ipctnlMsgCtGet = 1
@@ -38,13 +45,31 @@ const (
 	// overShootFactor is used sampling rate calculation after the circuit breaker trips.
 	overshootFactor = 0.95
 
-	// netlinkBufferSize is size (in bytes) of the Netlink socket receive buffer
+	// defaultNetlinkBufferSize is the default size (in bytes) of the Netlink socket receive buffer
 	// We set it to a large enough size to support bursts of Conntrack events.
-	netlinkBufferSize = 1024 * 1024
+	defaultNetlinkBufferSize = 1024 * 1024
+
+	// consumerRestartMinBackoff and consumerRestartMaxBackoff bound the delay before the event socket is re-created
+	// after a failure. The delay doubles with each failure, and is reset once the socket stayed up for the maximum delay.
+	consumerRestartMinBackoff = time.Second
+	consumerRestartMaxBackoff = time.Minute
+
+	// maxConsecutiveReadErrors is the number of consecutive read errors after which the event socket is considered failed
+	maxConsecutiveReadErrors = 10
+
+	// socketStatsInterval is how often the receive queue of the event socket is sampled while streaming
+	socketStatsInterval = time.Second
 )
 
 var errShortErrorMessage = errors.New("not enough data for netlink error code")
 
+// netlinkGroups maps the group names accepted in the configuration to their netlink multicast group
+var netlinkGroups = map[string]uint32{
+	"new":     netlinkCtNew,
+	"update":  netlinkCtUpdate,
+	"destroy": netlinkCtDestroy,
+}
+
 // Consumer is responsible for encapsulating all the logic of hooking into Conntrack via a Netlink socket
 // and streaming new connection events.
 type Consumer struct {
@@ -56,7 +81,19 @@ type Consumer struct {
 
 	// targetRateLimit represents the maximum number of netlink messages per second
 	// that can be read off the netlink socket. Setting it to -1 disables the limit.
-	targetRateLimit int
+	// It can be changed at runtime, and rateLimitChanged is then set until the new limit is applied.
+	targetRateLimit  int64
+	rateLimitChanged int32
+
+	// rcvBufSize is the requested size (in bytes) of the netlink socket receive buffer
+	rcvBufSize int
+
+	// mmapRing has the event socket receive the events in a memory-mapped ring of rcvBufSize bytes. It is unset
+	// once the ring can't be set up, on the kernels which don't support it.
+	mmapRing bool
+
+	// groups are the conntrack multicast groups the event socket subscribes to
+	groups []uint32
 
 	// samplingRate must be a value between 0 and 1 (inclusive) which is adjusted dynamically.
 	// this represents the amount of sampling we apply to the netlink socket via a BPF filter
@@ -68,8 +105,49 @@ type Consumer struct {
 	// adjusted accordingly to meet the desired targetRateLimit.
 	breaker *CircuitBreaker
 
-	// streaming is set to true after we finish the initial Conntrack dump.
-	streaming bool
+	// sampler adjusts the samplingRate based on the event rate and CPU usage. It is nil unless
+	// adaptive sampling is enabled.
+	sampler *AdaptiveSampler
+
+	// namespaces caps the events read from each network namespace, so a noisy namespace doesn't trip the breaker
+	// for all of them. It is nil unless the events of all the namespaces are listened to and a limit is set.
+	namespaces *namespaceLimiter
+
+	// overflows is signaled whenever the event socket reports ENOBUFS, meaning some conntrack events were lost.
+	overflows chan struct{}
+
+	// restarts is signaled whenever the event socket is re-created after a failure, meaning the conntrack events
+	// of the meantime were lost. stopped is set once the consumer is stopped, so the socket isn't re-created then.
+	// restarting is set while the event socket is being re-created, when no event is streamed.
+	restarts   chan struct{}
+	stopped    int32
+	restarting int32
+
+	// nsids maps the ids of the peer namespaces tagging the events to their inodes, as learnt by the dumps
+	nsids *netnsIDs
+
+	// disableIPv6 skips the dumps of the IPv6 conntrack table, and drops the IPv6 events
+	disableIPv6 bool
+
+	// dumpChunks are the parts the conntrack table is dumped in, one at a time. It is nil when the table is
+	// dumped in one pass.
+	dumpChunks []dumpChunk
+
+	// recorder records the events read, nil unless recording is enabled
+	recorder *recorder
+
+	// clock times the events recorded, the adaptive sampling and the backoff of the restarts of the event socket
+	clock clock
+
+	// socketSampled is when the receive queue of the event socket was last sampled, by the goroutine reading it
+	socketSampled time.Time
+
+	// replayPath is the recording the events are replayed from instead of being read off netlink sockets,
+	// empty unless replaying. replayPaced keeps the intervals between the recorded events, and exit stops the replay.
+	replayPath  string
+	replayPaced bool
+	exit        chan struct{}
+	stopOnce    sync.Once
 
 	// telemetry
 	enobufs     int64
@@ -77,9 +155,33 @@ type Consumer struct {
 	samplingPct int64
 	readErrors  int64
 	msgErrors   int64
+	ipv6Dropped int64
+	rcvBufBytes int64
+	resamples   int64
+	natFilter   int64
+	mmapRingOn  int64
+	cpuPct      int64
+	restartsNum int64
+
+	// the receive queue of the event socket when last sampled, and the messages it dropped. The drops of the event
+	// sockets closed are kept in closedSocketDrops.
+	socketQueueBytes  int64
+	socketQueuePeak   int64
+	socketDrops       int64
+	closedSocketDrops int64
+
+	dumpEntries      int64
+	dumpChunksDone   int64
+	dumpChunksFailed int64
+	dumpRetries      int64
 
 	netlinkSeqNumber    uint32
 	listenAllNamespaces bool
+	// udpCloseHints keeps the UDP DESTROY events of the connections which aren't translated
+	udpCloseHints bool
+
+	// errors keeps the last errors the consumer ran into
+	errors *errorLog
 }
 
 // Event encapsulates the result of a single netlink.Con.Receive() call
@@ -103,18 +205,69 @@ func (e *Event) Done() {
 }
 
 // NewConsumer creates a new Conntrack event consumer.
-// targetRateLimit represents the maximum number of netlink messages per second that can be read off the socket
-func NewConsumer(procRoot string, targetRateLimit int, listenAllNamespaces bool) (*Consumer, error) {
+// cfg.TargetRateLimit represents the maximum number of netlink messages per second that can be read off the socket
+func NewConsumer(cfg *Config) (*Consumer, error) {
+	if cfg.ReplayPath != "" {
+		return newReplayConsumer(cfg), nil
+	}
+
+	rcvBufSize := cfg.RcvBufSize
+	if rcvBufSize <= 0 {
+		rcvBufSize = defaultNetlinkBufferSize
+	}
+
 	c := &Consumer{
-		procRoot:            procRoot,
+		procRoot:            cfg.ProcRoot,
 		pool:                newBufferPool(),
 		workQueue:           make(chan func()),
-		targetRateLimit:     targetRateLimit,
-		breaker:             NewCircuitBreaker(int64(targetRateLimit)),
+		targetRateLimit:     int64(cfg.TargetRateLimit),
+		rcvBufSize:          rcvBufSize,
+		groups:              parseNetlinkGroups(cfg.NetlinkGroups),
+		breaker:             NewCircuitBreaker(int64(cfg.TargetRateLimit)),
+		overflows:           make(chan struct{}, 1),
+		restarts:            make(chan struct{}, 1),
+		nsids:               newNetnsIDs(),
 		netlinkSeqNumber:    1,
-		listenAllNamespaces: listenAllNamespaces,
+		listenAllNamespaces: cfg.ListenAllNamespaces,
+		udpCloseHints:       cfg.UDPCloseHints,
+		disableIPv6:         cfg.DisableIPv6,
+		dumpChunks:          newDumpChunks(cfg.DumpMarkMask),
+		errors:              newErrorLog(maxRecentErrors),
+		clock:               realClock{},
 	}
-	c.initWorker(procRoot)
+	if !c.disableIPv6 && !ipv6Available(cfg.ProcRoot) {
+		log.Infof("IPv6 is not available, IPv6 conntrack entries won't be tracked")
+		c.disableIPv6 = true
+	}
+	if cfg.AdaptiveSampling {
+		c.sampler = NewAdaptiveSampler(int64(cfg.TargetRateLimit), cfg.AdaptiveCPUBudget)
+	}
+	if cfg.UDPCloseHints && !c.subscribes(netlinkCtDestroy) {
+		c.groups = append(c.groups, netlinkCtDestroy)
+	}
+	if cfg.MmapRing {
+		if cfg.ListenAllNamespaces {
+			log.Warnf("ignoring the conntrack mmap ring, since it doesn't report the namespace of the events of the other namespaces")
+		} else {
+			c.mmapRing = true
+		}
+	}
+	if cfg.NamespaceRateLimit > 0 {
+		if cfg.ListenAllNamespaces {
+			c.namespaces = newNamespaceLimiter(cfg.NamespaceRateLimit)
+		} else {
+			log.Warnf("ignoring the conntrack namespace rate limit, since the events of the other namespaces aren't listened to")
+		}
+	}
+	if cfg.RecordPath != "" {
+		var err error
+		if c.recorder, err = newRecorder(cfg.RecordPath); err != nil {
+			log.Warnf("could not record conntrack events to %s: %s", cfg.RecordPath, err)
+		} else {
+			log.Infof("recording conntrack events to %s", cfg.RecordPath)
+		}
+	}
+	c.initWorker(cfg.ProcRoot)
 
 	var err error
 	c.do(true, func() {
@@ -129,29 +282,150 @@ func NewConsumer(procRoot string, targetRateLimit int, listenAllNamespaces bool)
 	return c, nil
 }
 
+// newReplayConsumer returns a Consumer replaying the events recorded to cfg.ReplayPath, without any netlink socket.
+// The recorded events include the dumps of the conntrack table, so the tables aren't dumped by the Consumer returned.
+func newReplayConsumer(cfg *Config) *Consumer {
+	log.Infof("replaying conntrack events from %s", cfg.ReplayPath)
+	return &Consumer{
+		procRoot:        cfg.ProcRoot,
+		targetRateLimit: int64(cfg.TargetRateLimit),
+		breaker:         NewCircuitBreaker(int64(cfg.TargetRateLimit)),
+		overflows:       make(chan struct{}, 1),
+		restarts:        make(chan struct{}, 1),
+		nsids:           newNetnsIDs(),
+		replayPath:      cfg.ReplayPath,
+		replayPaced:     true,
+		exit:            make(chan struct{}),
+		errors:          newErrorLog(maxRecentErrors),
+		clock:           realClock{},
+	}
+}
+
 // Events returns a channel of Event objects (wrapping netlink messages) which receives
-// all new connections added to the Conntrack table.
+// all new connections added to the Conntrack table. The event socket is re-created when it fails,
+// and the channel is only closed once the consumer is stopped, or all the recorded events are replayed.
 func (c *Consumer) Events() <-chan Event {
 	output := make(chan Event, outputBuffer)
+	if c.replayPath != "" {
+		go func() {
+			defer close(output)
+			defer close(c.overflows)
+			defer close(c.restarts)
+			if err := replayEvents(c.replayPath, output, c.exit, c.replayPaced); err != nil {
+				c.errorf("could not replay conntrack events from %s: %s", c.replayPath, err)
+				return
+			}
+			log.Infof("replayed conntrack events from %s", c.replayPath)
+		}()
+		return output
+	}
+
 	c.do(false, func() {
 		defer close(output)
-		c.streaming = true
-		_ = c.conn.JoinGroup(netlinkCtNew)
-		c.receive(output, c.socket)
+		defer close(c.overflows)
+		defer close(c.restarts)
+
+		backoff := consumerRestartMinBackoff
+		for {
+			started := c.clock.Now()
+			_ = c.joinGroups()
+			c.receive(output, c.socket, true, noNSID)
+
+			if c.clock.Now().Sub(started) > consumerRestartMaxBackoff {
+				backoff = consumerRestartMinBackoff
+			}
+			if !c.restart(&backoff) {
+				return
+			}
+		}
 	})
 
 	return output
 }
 
-// isPeerNS determines whether the given network namespace is a peer
-// of the given netlink socket
-func (c *Consumer) isPeerNS(conn *netlink.Conn, ns netns.NsHandle) bool {
+// restart re-creates the event socket once it failed, retrying with an exponential backoff until it succeeds.
+// It returns false when the consumer is stopped instead.
+func (c *Consumer) restart(backoff *time.Duration) bool {
+	atomic.StoreInt32(&c.restarting, 1)
+	defer atomic.StoreInt32(&c.restarting, 0)
+
+	for !c.isStopped() {
+		log.Warnf("conntrack netlink socket failed, re-creating it in %s", *backoff)
+		<-c.clock.After(*backoff)
+		*backoff = nextRestartBackoff(*backoff)
+		if c.isStopped() {
+			break
+		}
+
+		if c.socket != nil {
+			c.closeSocket()
+		}
+		if err := c.initNetlinkSocket(c.samplingRate); err != nil {
+			c.errorf("failed to re-create conntrack netlink socket: %s", err)
+			continue
+		}
+		c.breaker.Reset()
+		atomic.AddInt64(&c.restartsNum, 1)
+		select {
+		case c.restarts <- struct{}{}:
+		default:
+		}
+		return true
+	}
+	return false
+}
+
+// nextRestartBackoff returns the delay before the next attempt to re-create the event socket
+func nextRestartBackoff(backoff time.Duration) time.Duration {
+	if backoff *= 2; backoff > consumerRestartMaxBackoff {
+		return consumerRestartMaxBackoff
+	}
+	return backoff
+}
+
+// Restarts returns a channel signaled whenever the event socket is re-created after a failure, meaning the
+// conntrack events of the meantime were lost. Notifications are coalesced until the channel is drained,
+// and the channel is closed once the event stream terminates.
+func (c *Consumer) Restarts() <-chan struct{} {
+	return c.restarts
+}
+
+func (c *Consumer) isStopped() bool {
+	return atomic.LoadInt32(&c.stopped) != 0
+}
+
+// errorf logs an error, and keeps it among the recent errors of the consumer
+func (c *Consumer) errorf(format string, args ...interface{}) {
+	log.Errorf(format, args...)
+	c.errors.add(format, args...)
+}
+
+// RecentErrors returns the last errors the consumer ran into, the oldest first
+func (c *Consumer) RecentErrors() []string {
+	return c.errors.recent()
+}
+
+// isRestarting returns whether the event socket failed and isn't re-created yet
+func (c *Consumer) isRestarting() bool {
+	return atomic.LoadInt32(&c.restarting) != 0
+}
+
+// Overflows returns a channel signaled whenever the event socket overflows (ENOBUFS) while streaming,
+// meaning some conntrack events were lost. Notifications are coalesced until the channel is drained,
+// and the channel is closed once the event stream terminates.
+func (c *Consumer) Overflows() <-chan struct{} {
+	return c.overflows
+}
+
+// peerNSID returns the id the namespace of the given netlink socket assigned to the given network namespace,
+// and whether the namespace is a peer of it
+func (c *Consumer) peerNSID(conn *netlink.Conn, ns netns.NsHandle) (int32, bool) {
 	encoder := netlink.NewAttributeEncoder()
 	encoder.Uint32(unix.NETNSA_FD, uint32(ns))
 	data, err := encoder.Encode()
 	if err != nil {
-		log.Errorf("isPeerNS: err encoding attributes netlink attributes: %s", err)
-		return false
+		c.errorf("peerNSID: err encoding attributes netlink attributes: %s", err)
+		return noNSID, false
 	}
 
 	msg := netlink.Message{
@@ -166,62 +440,105 @@ func (c *Consumer) isPeerNS(conn *netlink.Conn, ns netns.NsHandle) bool {
 	msg.Data = append(msg.Data, data...)
 
 	if msg, err = conn.Send(msg); err != nil {
-		log.Errorf("isPeerNS: err sending netlink request: %s", err)
-		return false
+		c.errorf("peerNSID: err sending netlink request: %s", err)
+		return noNSID, false
 	}
 
 	msgs, err := conn.Receive()
 	if err != nil {
-		log.Errorf("isPeerNS: error receiving netlink reply: %s", err)
-		return false
+		c.errorf("peerNSID: error receiving netlink reply: %s", err)
+		return noNSID, false
 	}
 
 	log.Tracef("netlink reply: %v", msgs)
 
 	if msgs[0].Header.Type == netlink.Error {
-		return false
+		return noNSID, false
 	}
 
 	c.netlinkSeqNumber++
 
 	decoder, err := netlink.NewAttributeDecoder(msgs[0].Data)
 	if err != nil {
-		return false
+		return noNSID, false
 	}
 
 	for {
 		if decoder.Type() == unix.NETNSA_NSID {
-			return int32(decoder.Uint32()) >= 0
+			nsid := int32(decoder.Uint32())
+			return nsid, nsid >= 0
 		}
 		if !decoder.Next() {
 			break
 		}
 	}
 
-	return false
+	return noNSID, false
 }
 
 // DumpTable returns a channel of Event objects containing all entries
 // present in the Conntrack table. The channel is closed once all entries are read.
-// This method is meant to be used once during the process initialization of system-probe.
+// The table is dumped from a separate go-routine, so the caller is expected to drain the channel.
 func (c *Consumer) DumpTable(family uint8) <-chan Event {
 	output := make(chan Event, outputBuffer)
-	defer close(output)
+	go func() {
+		defer close(output)
+		c.dumpAllNamespaces(family, output)
+	}()
+	return output
+}
+
+// DumpNamespaceTable returns a channel of Event objects containing all entries of the conntrack table
+// of the network namespace of the given process, when it is a peer of the root namespace
+func (c *Consumer) DumpNamespaceTable(family uint8, pid int) <-chan Event {
+	output := make(chan Event, outputBuffer)
+	go func() {
+		defer close(output)
+
+		ns, err := util.GetNetNamespaceFromPid(c.procRoot, pid)
+		if err != nil {
+			log.Debugf("error dumping conntrack table, could not get network namespace of pid %d: %s", pid, err)
+			return
+		}
+		c.dumpNamespaces(family, output, []netns.NsHandle{ns}, false)
+	}()
+	return output
+}
 
+func (c *Consumer) dumpAllNamespaces(family uint8, output chan Event) {
 	var nss []netns.NsHandle
 	var err error
 	if c.listenAllNamespaces {
 		nss, err = util.GetNetNamespaces(c.procRoot)
 		if err != nil {
-			log.Errorf("error dumping conntrack table, could not get network namespaces: %s", err)
-			return output
+			c.errorf("error dumping conntrack table, could not get network namespaces: %s", err)
+			return
+		}
+	}
+	c.dumpNamespaces(family, output, nss, true)
+}
+
+// dumpNamespaces dumps the conntrack table of the given namespaces which are peers of the root namespace,
+// after the table of the root namespace when dumpRoot is set. The namespace handles are closed once dumped.
+func (c *Consumer) dumpNamespaces(family uint8, output chan Event, nss []netns.NsHandle, dumpRoot bool) {
+	if c.replayPath != "" {
+		// the dumps are part of the recording
+		for _, ns := range nss {
+			_ = ns.Close()
+		}
+		return
+	}
+	if family == unix.AF_INET6 && c.disableIPv6 {
+		for _, ns := range nss {
+			_ = ns.Close()
 		}
+		return
 	}
 
 	rootNS, err := netns.GetFromPath(fmt.Sprintf("%s/1/ns/net", c.procRoot))
 	if err != nil {
-		log.Errorf("error dumping conntrack table, could not get root namespace: %s", err)
-		return output
+		c.errorf("error dumping conntrack table, could not get root namespace: %s", err)
+		return
 	}
 
 	defer func() {
@@ -232,8 +549,8 @@ func (c *Consumer) DumpTable(family uint8) <-chan Event {
 
 	conn, err := netlink.Dial(unix.AF_UNSPEC, &netlink.Config{NetNS: int(rootNS)})
 	if err != nil {
-		log.Errorf("error dumping conntrack table, could not open netlink socket: %s", err)
-		return output
+		c.errorf("error dumping conntrack table, could not open netlink socket: %s", err)
+		return
 	}
 
 	defer func() {
@@ -241,31 +558,41 @@ func (c *Consumer) DumpTable(family uint8) <-chan Event {
 	}()
 
 	// root ns first
-	if err := c.dumpTable(family, output, rootNS); err != nil {
-		log.Errorf("error dumping conntrack table for root namespace, some NAT info may be missing: %s", err)
+	if dumpRoot {
+		if err := c.dumpTable(family, output, rootNS, noNSID); err != nil {
+			c.errorf("error dumping conntrack table for root namespace, some NAT info may be missing: %s", err)
+		}
 	}
 
 	for _, ns := range nss {
 		if rootNS.Equal(ns) {
-			// we've already dumped the table for the root ns above
+			// the table of the root ns is dumped above when requested
+			_ = ns.Close()
 			continue
 		}
 
-		if !c.isPeerNS(conn, ns) {
+		nsid, ok := c.peerNSID(conn, ns)
+		if !ok {
 			log.Tracef("not dumping ns %s since it is not a peer of the root ns", ns)
 			_ = ns.Close()
 			continue
 		}
 
-		if err := c.dumpTable(family, output, ns); err != nil {
-			log.Errorf("error dumping conntrack table for namespace %d: %s", ns, err)
+		var stat unix.Stat_t
+		if err := unix.Fstat(int(ns), &stat); err == nil {
+			c.nsids.set(nsid, uint32(stat.Ino))
 		}
-	}
 
-	return output
+		if err := c.dumpTable(family, output, ns, nsid); err != nil {
+			c.errorf("error dumping conntrack table for namespace %d: %s", ns, err)
+		}
+	}
 }
 
-func (c *Consumer) dumpTable(family uint8, output chan Event, ns netns.NsHandle) error {
+// dumpTable dumps the conntrack table of the given namespace. The messages read from a socket opened in the
+// namespace aren't tagged with its id, so the events are tagged with the given nsid instead.
+// The table is dumped one chunk at a time when it is chunked, the dumps failing being retried.
+func (c *Consumer) dumpTable(family uint8, output chan Event, ns netns.NsHandle, nsid int32) error {
 	defer func() {
 		_ = ns.Close()
 	}()
@@ -274,55 +601,131 @@ func (c *Consumer) dumpTable(family uint8, output chan Event, ns netns.NsHandle)
 
 		log.Tracef("dumping table for ns %s", ns)
 
-		sock, err := NewSocket()
-		if err != nil {
-			log.Errorf("could not open netlink socket for net ns %d", int(ns))
+		if c.dumpChunks == nil {
+			c.dumpChunk(family, output, nsid, nil)
 			return
 		}
 
-		conn := netlink.NewConn(sock, sock.pid)
-
-		defer func() {
-			_ = conn.Close()
-		}()
+		for i := range c.dumpChunks {
+			if !c.dumpChunk(family, output, nsid, &c.dumpChunks[i]) {
+				log.Warnf("could not dump chunk %d/%d of the conntrack table for ns %s, some NAT info may be missing", i+1, len(c.dumpChunks), ns)
+				continue
+			}
+			log.Tracef("dumped chunk %d/%d of the conntrack table for ns %s", i+1, len(c.dumpChunks), ns)
+		}
+	})
+}
 
-		req := netlink.Message{
-			Header: netlink.Header{
-				Type:  netlink.HeaderType((unix.NFNL_SUBSYS_CTNETLINK << 8) | ipctnlMsgCtGet),
-				Flags: netlink.Request | netlink.Dump,
-			},
-			Data: []byte{family, unix.NFNETLINK_V0, 0, 0},
+// dumpChunk dumps the entries of the given chunk, or the whole table when it is nil, from the current namespace.
+// The dump is attempted again when it fails part way, and it returns whether it completed.
+func (c *Consumer) dumpChunk(family uint8, output chan Event, nsid int32, chunk *dumpChunk) bool {
+	for attempt := 1; attempt <= dumpChunkAttempts; attempt++ {
+		if attempt > 1 {
+			atomic.AddInt64(&c.dumpRetries, 1)
 		}
+		if c.dumpOnce(family, output, nsid, chunk) {
+			atomic.AddInt64(&c.dumpChunksDone, 1)
+			return true
+		}
+		if c.isStopped() {
+			break
+		}
+	}
+	atomic.AddInt64(&c.dumpChunksFailed, 1)
+	return false
+}
+
+func (c *Consumer) dumpOnce(family uint8, output chan Event, nsid int32, chunk *dumpChunk) bool {
+	sock, err := NewSocket()
+	if err != nil {
+		c.errorf("could not open netlink socket for conntrack dump: %s", err)
+		return false
+	}
+
+	conn := netlink.NewConn(sock, sock.pid)
+
+	defer func() {
+		_ = conn.Close()
+	}()
 
-		verify, err := conn.Send(req)
+	req := netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType((unix.NFNL_SUBSYS_CTNETLINK << 8) | ipctnlMsgCtGet),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: []byte{family, unix.NFNETLINK_V0, 0, 0},
+	}
+	if chunk != nil {
+		attrs, err := chunk.attributes()
 		if err != nil {
-			log.Errorf("netlink dump error: %s", err)
-			return
+			c.errorf("could not encode conntrack dump filter: %s", err)
+			return false
 		}
+		req.Data = append(req.Data, attrs...)
+	}
 
-		if err := netlink.Validate(req, []netlink.Message{verify}); err != nil {
-			log.Errorf("netlink dump message validation error: %s", err)
-			return
-		}
+	verify, err := conn.Send(req)
+	if err != nil {
+		c.errorf("netlink dump error: %s", err)
+		return false
+	}
 
-		c.receive(output, sock)
-	})
+	if err := netlink.Validate(req, []netlink.Message{verify}); err != nil {
+		c.errorf("netlink dump message validation error: %s", err)
+		return false
+	}
+
+	return c.receive(output, sock, false, nsid)
 }
 
 // GetStats returns telemetry associated to the Consumer
 func (c *Consumer) GetStats() map[string]int64 {
-	return map[string]int64{
+	m := map[string]int64{
 		"enobufs":      atomic.LoadInt64(&c.enobufs),
 		"throttles":    atomic.LoadInt64(&c.throttles),
-		"sampling_pct": atomic.LoadInt64(&c.samplingPct),
 		"read_errors":  atomic.LoadInt64(&c.readErrors),
 		"msg_errors":   atomic.LoadInt64(&c.msgErrors),
+		"ipv6_dropped": atomic.LoadInt64(&c.ipv6Dropped),
+		"rcvbuf_size":  atomic.LoadInt64(&c.rcvBufBytes),
+		"event_rate":   c.breaker.Rate(),
+		"resamples":    atomic.LoadInt64(&c.resamples),
+		"cpu_pct":      atomic.LoadInt64(&c.cpuPct),
+		"nat_filter":   atomic.LoadInt64(&c.natFilter),
+		"mmap_ring":    atomic.LoadInt64(&c.mmapRingOn),
+
+		"consumer_restarts": atomic.LoadInt64(&c.restartsNum),
+
+		"socket_queue_bytes":      atomic.LoadInt64(&c.socketQueueBytes),
+		"socket_queue_peak_bytes": atomic.LoadInt64(&c.socketQueuePeak),
+		"socket_drops":            atomic.LoadInt64(&c.closedSocketDrops) + atomic.LoadInt64(&c.socketDrops),
+
+		"dump_entries":       atomic.LoadInt64(&c.dumpEntries),
+		"dump_chunks_done":   atomic.LoadInt64(&c.dumpChunksDone),
+		"dump_chunks_failed": atomic.LoadInt64(&c.dumpChunksFailed),
+		"dump_retries":       atomic.LoadInt64(&c.dumpRetries),
+
+		"target_rate_limit": atomic.LoadInt64(&c.targetRateLimit),
+	}
+	if c.recorder != nil {
+		for k, v := range c.recorder.getStats() {
+			m[k] = v
+		}
+	}
+	for k, v := range c.namespaces.getStats() {
+		m[k] = v
 	}
+	return m
 }
 
 // Stop the consumer
 func (c *Consumer) Stop() {
+	atomic.StoreInt32(&c.stopped, 1)
+	if c.replayPath != "" {
+		c.stopOnce.Do(func() { close(c.exit) })
+		return
+	}
 	c.conn.Close()
+	c.recorder.close()
 }
 
 // initWorker creates a go-routine *within the root network namespace*.
@@ -368,25 +771,56 @@ func (c *Consumer) initNetlinkSocket(samplingRate float64) error {
 	c.conn = netlink.NewConn(c.socket, c.socket.pid)
 
 	// We use this as opposed to netlink.Conn.SetReadBuffer because you can only
-	// set a value higher than /proc/sys/net/core/rmem_default (which is around 200kb for most systems)
+	// set a value higher than /proc/sys/net/core/rmem_max (which is around 200kb for most systems)
 	// if you use SO_RCVBUFFORCE with CAP_NET_ADMIN (https://linux.die.net/man/7/socket).
-	if err := c.socket.SetSockoptInt(syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, netlinkBufferSize); err != nil {
-		log.Errorf("error setting rcv buffer size for netlink socket: %s", err)
+	// Without that capability we fall back to SO_RCVBUF, which the kernel caps at rmem_max.
+	if err := c.socket.SetSockoptInt(syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, c.rcvBufSize); err != nil {
+		log.Debugf("could not force rcv buffer size for netlink socket, falling back to SO_RCVBUF: %s", err)
+		if err := c.socket.SetSockoptInt(syscall.SOL_SOCKET, syscall.SO_RCVBUF, c.rcvBufSize); err != nil {
+			c.errorf("error setting rcv buffer size for netlink socket: %s", err)
+		}
 	}
 
 	if size, err := c.socket.GetSockoptInt(syscall.SOL_SOCKET, syscall.SO_RCVBUF); err == nil {
 		log.Debugf("rcv buffer size for netlink socket is %d bytes", size)
+		atomic.StoreInt64(&c.rcvBufBytes, int64(size))
+	}
+
+	if c.mmapRing {
+		if err := c.socket.EnableRxRing(c.rcvBufSize); err != nil {
+			log.Infof("could not set up a memory-mapped ring for the conntrack netlink socket, falling back to recvmsg: %s", err)
+			c.mmapRing = false
+		} else {
+			atomic.StoreInt64(&c.mmapRingOn, 1)
+		}
 	}
 
 	if c.listenAllNamespaces {
 		if err := c.socket.SetSockoptInt(unix.SOL_NETLINK, unix.NETLINK_LISTEN_ALL_NSID, 1); err != nil {
-			log.Errorf("error enabling listen for all namespaces on netlink socket: %s", err)
+			c.errorf("error enabling listen for all namespaces on netlink socket: %s", err)
 		}
 	}
 
-	// Attach BPF sampling filter if necessary
 	c.samplingRate = samplingRate
 	atomic.StoreInt64(&c.samplingPct, int64(samplingRate*100.0))
+
+	// Attach the BPF filter dropping non-NAT events before they are copied to userspace.
+	// It also takes care of the sampling, since only one filter can be attached to a socket.
+	natFilter, err := GenerateBPFNATFilter(c.samplingRate, c.udpCloseHints)
+	if err == nil {
+		err = c.socket.SetBPF(natFilter)
+	}
+	if err == nil {
+		atomic.StoreInt64(&c.natFilter, 1)
+		if c.samplingRate < 1.0 {
+			log.Infof("attached netlink BPF filter with sampling rate: %.2f", c.samplingRate)
+		}
+		return nil
+	}
+	log.Warnf("failed to attach non-NAT netlink BPF filter, non-NAT events will be discarded in userspace: %s", err)
+	atomic.StoreInt64(&c.natFilter, 0)
+
+	// Attach BPF sampling filter if necessary
 	if c.samplingRate >= 1.0 {
 		return nil
 	}
@@ -405,45 +839,83 @@ func (c *Consumer) initNetlinkSocket(samplingRate float64) error {
 // receive netlink messages and flushes them to the Event channel.
 // This method gets called in two different contexts:
 //
-// - During system-probe startup, when we're loading all entries from the Conntrack table.
-// In this case c.streaming attribute is false, and once we detect the end of the multi-part
+// - When we're loading all entries from the Conntrack table (during system-probe startup or a re-sync).
+// In this case `streaming` is false, and once we detect the end of the multi-part
 // message we stop calling socket.Receive() and close the output channel to signal upstream
-// consumers we're done.
+// consumers we're done. The events are tagged with `nsid`, the namespace the table belongs to.
 //
-// - When we're streaming new connection events from the netlink socket. In this case, `c.streaming`
-// attribute is true, and only when we detect an EOF we close the output channel.
+// - When we're streaming new connection events from the netlink socket. In this case, `streaming`
+// is true, and only when we detect an EOF we close the output channel.
 // It's also worth noting that in the event of an ENOBUF error, we'll re-create a new netlink socket,
 // and attach a BPF sampler to it, to lower the the read throughput and save CPU.
-func (c *Consumer) receive(output chan Event, socket *Socket) {
-ReadLoop:
+//
+// It returns whether a dump read all the entries of the table.
+func (c *Consumer) receive(output chan Event, socket *Socket, streaming bool, nsid int32) bool {
+	var consecutiveErrors int
 	for {
 		buffer := c.pool.Get().(*[]byte)
 		msgs, netns, err := socket.ReceiveInto(*buffer)
 
-		if err != nil {
+		if err == nil {
+			consecutiveErrors = 0
+		} else {
 			switch socketError(err) {
 			case errEOF:
 				// EOFs are usually indicative of normal program termination, so we simply exit
-				return
+				return false
 			case errENOBUF:
 				atomic.AddInt64(&c.enobufs, 1)
+				if streaming {
+					c.notifyOverflow()
+					// the queue is sampled right away, to report the drops
+					c.socketSampled = time.Time{}
+				}
 			default:
 				atomic.AddInt64(&c.readErrors, 1)
+				c.errors.add("conntrack netlink socket read error: %s", err)
+				// the socket is considered failed when it keeps erroring out, so it can be re-created,
+				// or the dump attempted again
+				if consecutiveErrors++; consecutiveErrors >= maxConsecutiveReadErrors {
+					if streaming {
+						c.errorf("conntrack netlink socket failed after %d consecutive read errors: %s", consecutiveErrors, err)
+					}
+					return false
+				}
 			}
 		}
 
-		throttlingErr := c.throttle(len(msgs))
-		if throttlingErr != nil {
-			return
+		// We don't throttle the socket when dumping the whole Conntrack table
+		if streaming {
+			if err := c.applyRateLimit(); err != nil {
+				return false
+			}
+			msgs = c.namespaces.filter(c.clock.Now(), netns, msgs)
+			if err := c.throttle(len(msgs)); err != nil {
+				return false
+			}
+			if err := c.adapt(); err != nil {
+				return false
+			}
+			// the socket may have been re-created with a different sampling rate
+			socket = c.socket
+			c.sampleSocket(socket)
 		}
 
-		// Messages with error codes are simply skipped
+		// Messages with error codes are simply skipped, without discarding the rest of the batch
+		valid := msgs[:0]
 		for _, m := range msgs {
 			if err := checkMessage(m); err != nil {
 				atomic.AddInt64(&c.msgErrors, 1)
-				continue ReadLoop
+				c.errors.add("conntrack netlink message error: %s", err)
+				continue
+			}
+			if c.disableIPv6 && isIPv6Message(m) {
+				atomic.AddInt64(&c.ipv6Dropped, 1)
+				continue
 			}
+			valid = append(valid, m)
 		}
+		msgs = valid
 
 		// Skip multi-part "done" messages
 		multiPartDone := len(msgs) > 0 && msgs[len(msgs)-1].Header.Type == netlink.Done
@@ -451,15 +923,32 @@ ReadLoop:
 			msgs = msgs[:len(msgs)-1]
 		}
 
+		if !streaming {
+			netns = nsid
+			atomic.AddInt64(&c.dumpEntries, int64(len(msgs)))
+		}
+		c.recorder.record(c.clock.Now(), netns, msgs)
 		output <- c.eventFor(msgs, netns, buffer)
 
 		// If we're doing a conntrack dump we terminate after reading the multi-part message
-		if multiPartDone && !c.streaming {
-			return
+		if multiPartDone && !streaming {
+			return true
 		}
 	}
 }
 
+// isIPv6Message returns whether a conntrack message is about an IPv6 entry, based on the family of its nfgenmsg header
+func isIPv6Message(m netlink.Message) bool {
+	return len(m.Data) > 0 && m.Data[0] == unix.AF_INET6
+}
+
+func (c *Consumer) notifyOverflow() {
+	select {
+	case c.overflows <- struct{}{}:
+	default:
+	}
+}
+
 func (c *Consumer) eventFor(msgs []netlink.Message, netns int32, buffer *[]byte) Event {
 	return Event{
 		msgs:   msgs,
@@ -472,34 +961,174 @@ func (c *Consumer) eventFor(msgs []netlink.Message, netns int32, buffer *[]byte)
 // throttle ensures that the read throughput from the socket stays below
 // the configured maxMessagePerSecond
 func (c *Consumer) throttle(numMessages int) error {
-	// We don't throttle the socket during initialization
-	// (when we dump the whole Conntrack table)
-	if !c.streaming {
-		return nil
-	}
-
 	c.breaker.Tick(numMessages)
 	if !c.breaker.IsOpen() {
 		return nil
 	}
 	atomic.AddInt64(&c.throttles, 1)
 
-	// Close current socket
+	// We calculate the required sampling rate to reach the target maxMessagesPersecond
+	samplingRate := (float64(atomic.LoadInt64(&c.targetRateLimit)) / float64(c.breaker.Rate())) * c.samplingRate * overshootFactor
+	return c.resample(samplingRate)
+}
+
+// SetTargetRateLimit changes the maximum number of netlink messages per second that can be read off the socket.
+// -1 disables the limit. The new limit is applied by the goroutine reading the socket when it receives the next events.
+func (c *Consumer) SetTargetRateLimit(limit int) {
+	if atomic.SwapInt64(&c.targetRateLimit, int64(limit)) == int64(limit) {
+		return
+	}
+
+	c.breaker.SetMaxEventsPerSec(int64(limit))
+	if c.sampler != nil {
+		c.sampler.SetMaxEventsPerSec(int64(limit))
+	}
+	atomic.StoreInt32(&c.rateLimitChanged, 1)
+	log.Infof("changed conntrack target_rate_limit to %d messages/sec", limit)
+}
+
+// applyRateLimit stops sampling events once the target rate limit was changed, so the sampling rate
+// required by the new limit is computed again from the actual event rate.
+// It must be called from the goroutine reading the socket.
+func (c *Consumer) applyRateLimit() error {
+	if atomic.SwapInt32(&c.rateLimitChanged, 0) == 0 || c.samplingRate >= 1.0 {
+		return nil
+	}
+	return c.resample(1.0)
+}
+
+// adapt periodically re-evaluates the sampling rate when adaptive sampling is enabled.
+// It must be called from the goroutine reading the socket, since the CPU time is measured per thread.
+func (c *Consumer) adapt() error {
+	if c.sampler == nil {
+		return nil
+	}
+
+	cpuTime, err := threadCPUTime()
+	if err != nil {
+		return nil
+	}
+
+	samplingRate, ok := c.sampler.Next(c.clock.Now(), cpuTime, c.breaker.Rate(), c.samplingRate)
+	atomic.StoreInt64(&c.cpuPct, int64(c.sampler.CPUUsage()*100.0))
+	if !ok {
+		return nil
+	}
+
+	log.Debugf("adjusting netlink sampling rate from %.2f to %.2f", c.samplingRate, samplingRate)
+	atomic.AddInt64(&c.resamples, 1)
+	return c.resample(samplingRate)
+}
+
+// sampleSocket samples the receive queue of the event socket, at most every socketStatsInterval.
+// It must be called from the goroutine reading the socket.
+func (c *Consumer) sampleSocket(socket *Socket) {
+	now := c.clock.Now()
+	if now.Sub(c.socketSampled) < socketStatsInterval {
+		return
+	}
+	c.socketSampled = now
+
+	mem, err := socket.MemInfo()
+	if err != nil {
+		log.Debugf("could not get the receive queue of the conntrack netlink socket: %s", err)
+		return
+	}
+	c.storeMemInfo(mem)
+}
+
+func (c *Consumer) storeMemInfo(mem MemInfo) {
+	queued := int64(mem.QueuedBytes)
+	atomic.StoreInt64(&c.socketQueueBytes, queued)
+	if queued > atomic.LoadInt64(&c.socketQueuePeak) {
+		atomic.StoreInt64(&c.socketQueuePeak, queued)
+	}
+	atomic.StoreInt64(&c.socketDrops, int64(mem.Drops))
+}
+
+// closeSocket closes the event socket, keeping the count of the messages it dropped
+func (c *Consumer) closeSocket() {
+	if mem, err := c.socket.MemInfo(); err == nil {
+		atomic.AddInt64(&c.closedSocketDrops, int64(mem.Drops))
+	}
+	atomic.StoreInt64(&c.socketDrops, 0)
+	atomic.StoreInt64(&c.socketQueueBytes, 0)
 	c.socket.Close()
+}
+
+// resample re-creates the netlink socket with the given sampling rate
+func (c *Consumer) resample(samplingRate float64) error {
+	// Close current socket
+	c.closeSocket()
 
 	// Create new socket with the desired sampling rate
-	// We calculate the required sampling rate to reach the target maxMessagesPersecond
-	samplingRate := (float64(c.targetRateLimit) / float64(c.breaker.Rate())) * c.samplingRate * overshootFactor
 	err := c.initNetlinkSocket(samplingRate)
 	if err != nil {
-		log.Errorf("failed to re-create netlink socket. exiting conntrack: %s", err)
+		c.errorf("failed to re-create netlink socket. exiting conntrack: %s", err)
 		return err
 	}
 
 	// Reset circuit breaker
 	c.breaker.Reset()
-	// Re-subscribe netlinkCtNew messages
-	return c.conn.JoinGroup(netlinkCtNew)
+	// Re-subscribe to the conntrack event groups
+	return c.joinGroups()
+}
+
+// joinGroups subscribes the event socket to the configured conntrack multicast groups
+func (c *Consumer) joinGroups() error {
+	for _, group := range c.groups {
+		if err := c.conn.JoinGroup(group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscribes returns whether the event socket subscribes to the given conntrack multicast group
+func (c *Consumer) subscribes(group uint32) bool {
+	for _, g := range c.groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetlinkGroups converts the configured group names into netlink multicast groups.
+// Unknown names are ignored, and only new connection events are subscribed to if no valid group is given.
+func parseNetlinkGroups(names []string) []uint32 {
+	var groups []uint32
+	seen := make(map[uint32]struct{}, len(names))
+	for _, name := range names {
+		group, ok := netlinkGroups[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			log.Warnf("ignoring unknown conntrack netlink group %q, valid groups are: new, update, destroy", name)
+			continue
+		}
+		if _, ok := seen[group]; ok {
+			continue
+		}
+		seen[group] = struct{}{}
+		groups = append(groups, group)
+	}
+
+	if len(groups) == 0 {
+		return []uint32{netlinkCtNew}
+	}
+	return groups
+}
+
+// groupNames returns the names of the conntrack multicast groups the event socket subscribes to
+func (c *Consumer) groupNames() []string {
+	names := make([]string, 0, len(c.groups))
+	for _, group := range c.groups {
+		for name, g := range netlinkGroups {
+			if g == group {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
 }
 
 func newBufferPool() *sync.Pool {
@@ -551,7 +1180,7 @@ var (
 
 // TODO: There is probably a more idiomatic way to do this
 func socketError(err error) error {
-	if strings.Contains(err.Error(), "closed file") {
+	if errors.Is(err, os.ErrClosed) || strings.Contains(err.Error(), "closed file") {
 		return errEOF
 	}
 
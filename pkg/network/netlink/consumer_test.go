@@ -0,0 +1,69 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestOverflowNotificationsAreCoalesced(t *testing.T) {
+	c := &Consumer{overflows: make(chan struct{}, 1)}
+
+	// none of these calls should block
+	c.notifyOverflow()
+	c.notifyOverflow()
+	c.notifyOverflow()
+
+	assert.Len(t, c.Overflows(), 1)
+}
+
+func TestParseNetlinkGroups(t *testing.T) {
+	assert.Equal(t, []uint32{netlinkCtNew}, parseNetlinkGroups(nil))
+	assert.Equal(t, []uint32{netlinkCtNew}, parseNetlinkGroups([]string{"bogus"}))
+	assert.Equal(t, []uint32{netlinkCtNew, netlinkCtDestroy}, parseNetlinkGroups([]string{"new", " Destroy", "new"}))
+}
+
+func TestNextRestartBackoff(t *testing.T) {
+	assert.Equal(t, 2*consumerRestartMinBackoff, nextRestartBackoff(consumerRestartMinBackoff))
+	assert.Equal(t, consumerRestartMaxBackoff, nextRestartBackoff(consumerRestartMaxBackoff-time.Second))
+	assert.Equal(t, consumerRestartMaxBackoff, nextRestartBackoff(consumerRestartMaxBackoff))
+}
+
+func TestIsIPv6Message(t *testing.T) {
+	assert.True(t, isIPv6Message(netlink.Message{Data: []byte{unix.AF_INET6, unix.NFNETLINK_V0, 0, 0}}))
+	assert.False(t, isIPv6Message(netlink.Message{Data: []byte{unix.AF_INET, unix.NFNETLINK_V0, 0, 0}}))
+	assert.False(t, isIPv6Message(netlink.Message{}))
+}
+
+func TestSocketStats(t *testing.T) {
+	c := &Consumer{breaker: NewCircuitBreaker(-1)}
+	c.storeMemInfo(MemInfo{QueuedBytes: 4096, Drops: 3})
+	c.storeMemInfo(MemInfo{QueuedBytes: 1024, Drops: 5})
+	atomic.AddInt64(&c.closedSocketDrops, 2)
+
+	stats := c.GetStats()
+	assert.Equal(t, int64(1024), stats["socket_queue_bytes"])
+	assert.Equal(t, int64(4096), stats["socket_queue_peak_bytes"])
+	assert.Equal(t, int64(7), stats["socket_drops"])
+}
+
+func TestSocketMemInfo(t *testing.T) {
+	socket, err := NewSocket()
+	if err != nil {
+		t.Skipf("could not open netlink socket: %s", err)
+	}
+	defer socket.Close()
+
+	mem, err := socket.MemInfo()
+	assert.NoError(t, err)
+	assert.Zero(t, mem.QueuedBytes)
+	assert.NotZero(t, mem.RcvBufBytes)
+	assert.Zero(t, mem.Drops)
+}
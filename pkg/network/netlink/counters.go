@@ -0,0 +1,23 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+// Counters are the packet and byte counters of a conntrack entry, from the point of view of the source of the
+// connection they are retrieved for. They are only maintained by the kernel when nf_conntrack_acct is enabled.
+type Counters struct {
+	SentPackets uint64
+	SentBytes   uint64
+	RecvPackets uint64
+	RecvBytes   uint64
+}
+
+// reversed returns the counters from the point of view of the destination of the connection
+func (c Counters) reversed() Counters {
+	return Counters{
+		SentPackets: c.RecvPackets,
+		SentBytes:   c.RecvBytes,
+		RecvPackets: c.SentPackets,
+		RecvBytes:   c.SentBytes,
+	}
+}
@@ -0,0 +1,117 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// cpuBreakerInterval is the interval over which the CPU usage of the event processing is measured
+	cpuBreakerInterval = 10 * time.Second
+
+	// cpuBreakerPause is how long the event processing stays paused once the CPU budget is exceeded
+	cpuBreakerPause = 5 * time.Minute
+
+	// cpuBreakerDumpInterval is the interval at which the conntrack table is dumped while the event processing is paused
+	cpuBreakerDumpInterval = time.Minute
+)
+
+// cpuBreaker pauses the processing of conntrack events (decoding and registering them) when the CPU time it uses
+// exceeds a budget, which happens during connection storms. While paused, events are discarded without being decoded,
+// and the cache is kept up to date by dumping the conntrack table periodically instead.
+// It must be updated from a goroutine locked to its thread, since the CPU time is measured per thread.
+type cpuBreaker struct {
+	// The fraction of a CPU core the event processing is allowed to use
+	budget float64
+
+	// The last time the CPU usage was evaluated, along with the CPU time used at that moment
+	lastCheck time.Time
+	lastCPU   time.Duration
+
+	// cpuTime returns the CPU time used so far by the current thread
+	cpuTime func() (time.Duration, error)
+
+	// pausedUntil is the time in nanoseconds until which the event processing is paused
+	pausedUntil int64
+
+	// telemetry
+	trips  int64
+	cpuPct int64
+}
+
+// newCPUBreaker returns a cpuBreaker allowing the given fraction of a CPU core, or nil when the budget is disabled
+func newCPUBreaker(budget float64) *cpuBreaker {
+	if budget <= 0 {
+		return nil
+	}
+	return &cpuBreaker{
+		budget:  budget,
+		cpuTime: threadCPUTime,
+	}
+}
+
+// paused returns whether the event processing is paused
+func (b *cpuBreaker) paused(now time.Time) bool {
+	if b == nil {
+		return false
+	}
+	return now.UnixNano() < atomic.LoadInt64(&b.pausedUntil)
+}
+
+// update evaluates the CPU usage once per cpuBreakerInterval, and pauses the event processing
+// when it exceeds the budget
+func (b *cpuBreaker) update(now time.Time) {
+	if b == nil {
+		return
+	}
+	if !b.lastCheck.IsZero() && now.Sub(b.lastCheck) < cpuBreakerInterval {
+		return
+	}
+
+	cpuTime, err := b.cpuTime()
+	if err != nil {
+		return
+	}
+	if b.lastCheck.IsZero() {
+		b.lastCheck, b.lastCPU = now, cpuTime
+		return
+	}
+
+	usage := float64(cpuTime-b.lastCPU) / float64(now.Sub(b.lastCheck))
+	b.lastCheck, b.lastCPU = now, cpuTime
+	atomic.StoreInt64(&b.cpuPct, int64(usage*100.0))
+
+	if usage > b.budget {
+		log.Warnf("conntrack event processing used %.1f%% of a CPU core, over the budget of %.1f%%. pausing it for %s, the conntrack table will be dumped every %s instead",
+			usage*100, b.budget*100, cpuBreakerPause, cpuBreakerDumpInterval)
+		atomic.StoreInt64(&b.pausedUntil, now.Add(cpuBreakerPause).UnixNano())
+		atomic.AddInt64(&b.trips, 1)
+	}
+}
+
+func (b *cpuBreaker) getStats() map[string]int64 {
+	var paused int64
+	if b.paused(time.Now()) {
+		paused = 1
+	}
+	return map[string]int64{
+		"cpu_breaker_trips":   atomic.LoadInt64(&b.trips),
+		"cpu_breaker_paused":  paused,
+		"cpu_breaker_cpu_pct": atomic.LoadInt64(&b.cpuPct),
+	}
+}
+
+// threadCPUTime returns the CPU time used so far by the current thread
+func threadCPUTime() (time.Duration, error) {
+	var usage unix.Rusage
+	if err := unix.Getrusage(unix.RUSAGE_THREAD, &usage); err != nil {
+		return 0, err
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), nil
+}
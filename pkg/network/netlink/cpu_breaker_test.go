@@ -0,0 +1,65 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCPUBreaker(budget float64, cpuTime *time.Duration) *cpuBreaker {
+	b := newCPUBreaker(budget)
+	b.cpuTime = func() (time.Duration, error) {
+		return *cpuTime, nil
+	}
+	return b
+}
+
+func TestCPUBreakerDisabled(t *testing.T) {
+	b := newCPUBreaker(0)
+	assert.Nil(t, b)
+
+	// a nil breaker never pauses
+	b.update(time.Now())
+	assert.False(t, b.paused(time.Now()))
+}
+
+func TestCPUBreakerWithinBudget(t *testing.T) {
+	var cpuTime time.Duration
+	b := newTestCPUBreaker(0.1, &cpuTime)
+
+	now := time.Now()
+	b.update(now)
+
+	// 5% of a core
+	cpuTime += cpuBreakerInterval / 20
+	now = now.Add(cpuBreakerInterval)
+	b.update(now)
+	assert.False(t, b.paused(now))
+	assert.Equal(t, int64(5), b.getStats()["cpu_breaker_cpu_pct"])
+}
+
+func TestCPUBreakerTrips(t *testing.T) {
+	var cpuTime time.Duration
+	b := newTestCPUBreaker(0.1, &cpuTime)
+
+	now := time.Now()
+	b.update(now)
+
+	// the usage isn't evaluated before the end of the interval
+	cpuTime += cpuBreakerInterval / 2
+	b.update(now.Add(time.Second))
+	assert.False(t, b.paused(now.Add(time.Second)))
+
+	now = now.Add(cpuBreakerInterval)
+	b.update(now)
+	assert.True(t, b.paused(now))
+	assert.Equal(t, int64(1), b.trips)
+
+	// the event processing resumes after the pause
+	assert.True(t, b.paused(now.Add(cpuBreakerPause-time.Second)))
+	assert.False(t, b.paused(now.Add(cpuBreakerPause)))
+}
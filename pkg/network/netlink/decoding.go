@@ -5,11 +5,14 @@ package netlink
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 
 	"github.com/DataDog/datadog-agent/pkg/util/log"
-	ct "github.com/florianl/go-conntrack"
+	"github.com/mdlayher/netlink"
 )
 
 const (
@@ -18,6 +21,44 @@ const (
 	ctaTupleReply
 )
 
+const (
+	ctaStatus = 3
+
+	// ipsSeenReply is the status flag of the entries whose connection saw packets in the reply direction
+	ipsSeenReply = 1 << 1
+)
+
+const (
+	ctaProtoInfo = 4
+
+	ctaProtoInfoTCP      = 1
+	ctaProtoInfoTCPState = 1
+)
+
+const (
+	ctaCountersOrig  = 9
+	ctaCountersReply = 10
+
+	ctaCountersPackets   = 1
+	ctaCountersBytes     = 2
+	ctaCounters32Packets = 3
+	ctaCounters32Bytes   = 4
+)
+
+const (
+	ctaID = 12
+)
+
+const (
+	ctaZone = 18
+)
+
+const (
+	ctaTimestamp = 20
+
+	ctaTimestampStart = 1
+)
+
 const (
 	ctaTupleIP    = 1
 	ctaTupleProto = 2
@@ -37,37 +78,182 @@ const (
 	ctaProtoDstPort = 3
 )
 
+const (
+	ipctnlMsgCtDelete = 2
+)
+
+var (
+	errMissingAddress  = errors.New("conntrack entry without source or destination address")
+	errMissingProtocol = errors.New("conntrack entry with ports but without protocol number")
+)
+
+// decodedAttribute identifies the attributes whose decoding errors are counted
+type decodedAttribute int
+
+const (
+	attrAddress decodedAttribute = iota
+	attrProtoNum
+	attrPort
+	attrTCPState
+	attrCounters
+	attrTimestamp
+	attrID
+	attrZone
+	attrStatus
+	numDecodedAttributes
+)
+
+var decodedAttributeNames = [numDecodedAttributes]string{"address", "proto_num", "port", "tcp_state", "counters", "timestamp", "id", "zone", "status"}
+
+// decodeErrors counts the messages which couldn't be decoded, and the malformed attributes skipped while decoding.
+// A nil *decodeErrors doesn't count anything.
+type decodeErrors struct {
+	messages   int64
+	attributes [numDecodedAttributes]int64
+}
+
+func (e *decodeErrors) message() {
+	if e != nil {
+		atomic.AddInt64(&e.messages, 1)
+	}
+}
+
+func (e *decodeErrors) attribute(a decodedAttribute) {
+	if e != nil {
+		atomic.AddInt64(&e.attributes[a], 1)
+	}
+}
+
+func (e *decodeErrors) getStats() map[string]int64 {
+	m := map[string]int64{
+		"decode_errors_total": atomic.LoadInt64(&e.messages),
+	}
+	for a, name := range decodedAttributeNames {
+		m["decode_errors_"+name] = atomic.LoadInt64(&e.attributes[a])
+	}
+	return m
+}
+
+// EventType describes the kind of conntrack event a Con was decoded from
+type EventType uint8
+
+const (
+	// EventNew is a new conntrack entry. Entries read from a table dump are reported as new.
+	EventNew EventType = iota
+	// EventUpdate is an update of an existing conntrack entry
+	EventUpdate
+	// EventDestroy is the removal of a conntrack entry
+	EventDestroy
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventNew:
+		return "new"
+	case EventUpdate:
+		return "update"
+	case EventDestroy:
+		return "destroy"
+	default:
+		return fmt.Sprintf("EventType(%d)", uint8(t))
+	}
+}
+
+func eventType(h netlink.Header) EventType {
+	switch {
+	case h.Type&0xff == ipctnlMsgCtDelete:
+		return EventDestroy
+	case h.Flags&netlink.Create != 0, h.Flags&netlink.Multi != 0:
+		return EventNew
+	default:
+		return EventUpdate
+	}
+}
+
 // Con represents a conntrack entry, along with any network namespace info (nsid)
 type Con struct {
-	ct.Con
-	NetNS int32
+	// Origin is the tuple of the direction the connection was initiated in, Reply the tuple of the other direction
+	Origin IPTuple
+	Reply  IPTuple
+	// NetNS is the id the root namespace assigned to the namespace of the entry, -1 for the root namespace itself
+	NetNS     int32
+	EventType EventType
+	// TCPState is the state of TCP connections, it is TCPStateNone for other protocols
+	TCPState TCPState
+	// Counters are the counters of the origin direction as sent, and of the reply direction as received.
+	// They are zero when nf_conntrack_acct is disabled.
+	Counters Counters
+	// StartTime is the creation time of the entry in nanoseconds since the epoch.
+	// It is zero when nf_conntrack_timestamp is disabled.
+	StartTime uint64
+	// ID is the id conntrack assigned to the entry, which stays the same across its events. It is zero when unknown.
+	ID uint32
+	// Zone is the conntrack zone of the entry, 0 for the default zone
+	Zone uint16
+	// Status holds the IPS_* flags of the entry, it is zero when unknown
+	Status uint32
+}
+
+// unreplied returns whether the connection of the entry didn't see any reply yet (eg. half-open UDP flows or SYNs
+// sent to dead hosts). Entries of unknown status are assumed to be replied.
+func (c Con) unreplied() bool {
+	return c.Status != 0 && c.Status&ipsSeenReply == 0
 }
 
 func (c Con) String() string {
-	return fmt.Sprintf("netns=%d src=%s dst=%s sport=%d dport=%d src=%s dst=%s sport=%d dport=%d proto=%d", c.NetNS, c.Origin.Src, c.Origin.Dst, *c.Origin.Proto.SrcPort, *c.Origin.Proto.DstPort, c.Reply.Src, c.Reply.Dst, *c.Reply.Proto.SrcPort, *c.Reply.Proto.DstPort, *c.Con.Origin.Proto.Number)
+	var proto interface{}
+	if c.Origin.fields&tupleProto != 0 {
+		proto = c.Origin.proto
+	}
+	return fmt.Sprintf("netns=%d %s %s proto=%v", c.NetNS, c.Origin, c.Reply, proto)
 }
 
-var scanner = NewAttributeScanner()
+// Decoder decodes conntrack netlink messages into Con objects, which hold their fields by value, so decoding
+// doesn't allocate. A Decoder must not be used concurrently.
+type Decoder struct {
+	scanner *AttributeScanner
+	con     Con
+
+	// ports filters the entries decoded, nil when they aren't filtered
+	ports *portFilter
+	// zones filters the entries decoded by conntrack zone, nil when they aren't filtered
+	zones *zoneFilter
+
+	// errors counts the messages and attributes which couldn't be decoded, nil when they aren't counted
+	errors *decodeErrors
+
+	// caps are the optional attributes the kernel sends, nil when they aren't known
+	caps *kernelCapabilities
+}
+
+// NewDecoder returns a new Decoder
+func NewDecoder() *Decoder {
+	return &Decoder{scanner: NewAttributeScanner()}
+}
 
-// DecodeAndReleaseEvent decodes a single Event into a slice of []ct.Con objects and
+var decoderPool = sync.Pool{
+	New: func() interface{} {
+		return NewDecoder()
+	},
+}
+
+// DecodeAndReleaseEvent decodes a single Event into a slice of Con objects and
 // releases the underlying buffer.
-// TODO: Replace the intermediate ct.Con object by the same format we use in the cache
+// The slice returned is allocated. Use Decoder.DecodeAndReleaseEvent to decode without allocating.
 func DecodeAndReleaseEvent(e Event) []Con {
+	d := decoderPool.Get().(*Decoder)
+	defer decoderPool.Put(d)
+
 	msgs := e.Messages()
 	conns := make([]Con, 0, len(msgs))
 
 	for _, msg := range msgs {
-		c := &Con{NetNS: e.netns}
-		if err := scanner.ResetTo(msg.Data); err != nil {
-			log.Debugf("error decoding netlink message: %s", err)
-			continue
-		}
-		err := unmarshalCon(scanner, c)
-		if err != nil {
+		c := Con{NetNS: e.netns, EventType: eventType(msg.Header)}
+		if err := d.decode(msg.Data, &c); err != nil {
 			log.Debugf("error decoding netlink message: %s", err)
 			continue
 		}
-		conns = append(conns, *c)
+		conns = append(conns, c)
 	}
 
 	// Return buffers to the pool
@@ -76,21 +262,154 @@ func DecodeAndReleaseEvent(e Event) []Con {
 	return conns
 }
 
-func unmarshalCon(s *AttributeScanner, c *Con) error {
-	c.Origin = &ct.IPTuple{}
-	c.Reply = &ct.IPTuple{}
+// DecodeAndReleaseEvent decodes a single Event, calling fn for each conntrack entry, and
+// releases the underlying buffer.
+// The Con passed to fn is re-used for the next entry, so it must be copied to be retained.
+func (d *Decoder) DecodeAndReleaseEvent(e Event, fn func(c *Con)) {
+	d.decodeEvent(e, nil, fn)
 
-	for toDecode := 2; toDecode > 0 && s.Next(); {
+	// Return buffers to the pool
+	e.Done()
+}
+
+// decodeEvent decodes the messages of an Event whose type matches the given filter, or all of them when
+// the filter is nil, without releasing the underlying buffer
+func (d *Decoder) decodeEvent(e Event, filter func(EventType) bool, fn func(c *Con)) {
+	for _, msg := range e.Messages() {
+		typ := eventType(msg.Header)
+		if filter != nil && !filter(typ) {
+			continue
+		}
+		d.con = Con{NetNS: e.netns, EventType: typ}
+		if err := d.decode(msg.Data, &d.con); err != nil {
+			log.Debugf("error decoding netlink message: %s", err)
+			d.errors.message()
+			continue
+		}
+		if !d.ports.matches(&d.con) || !d.zones.matches(&d.con) {
+			continue
+		}
+		fn(&d.con)
+	}
+}
+
+func (d *Decoder) decode(data []byte, c *Con) error {
+	if err := d.scanner.ResetTo(data); err != nil {
+		return err
+	}
+	if err := unmarshalCon(d.scanner, c, d.errors, d.caps); err != nil {
+		return err
+	}
+	// malformed addresses are skipped, so they may be missing from an entry otherwise decoded
+	if !c.Origin.hasAddresses() || !c.Reply.hasAddresses() {
+		return errMissingAddress
+	}
+	// the protocol number is relied upon whenever the ports are present, which the kernel always sends along
+	if !c.Origin.hasProtocol() || !c.Reply.hasProtocol() {
+		return errMissingProtocol
+	}
+	return nil
+}
+
+// unmarshalCon decodes a conntrack entry. Malformed optional attributes (protocol info, counters, timestamp)
+// are counted in errs and skipped, while malformed tuples fail the whole entry.
+// The entry is scanned until all the attributes the kernel sends according to caps are decoded.
+func unmarshalCon(s *AttributeScanner, c *Con, errs *decodeErrors, caps *kernelCapabilities) error {
+	c.Origin, c.Reply = IPTuple{}, IPTuple{}
+
+	// the protocol info is only present for TCP connections, the counters when nf_conntrack_acct is enabled,
+	// and the timestamp when nf_conntrack_timestamp is enabled
+	expected, toDecode := caps.expected()
+	for toDecode > 0 && s.Next() {
 		switch s.Type() {
 		case ctaTupleOrig:
 			toDecode--
 			s.Nested(func() error {
-				return unmarshalTuple(s, c.Origin)
+				return unmarshalTuple(s, &c.Origin, errs)
 			})
 		case ctaTupleReply:
 			toDecode--
 			s.Nested(func() error {
-				return unmarshalTuple(s, c.Reply)
+				return unmarshalTuple(s, &c.Reply, errs)
+			})
+		case ctaProtoInfo:
+			toDecode--
+			s.Nested(func() error {
+				if err := unmarshalProtoInfo(s, c, errs); err != nil {
+					errs.attribute(attrTCPState)
+				}
+				return nil
+			})
+		case ctaCountersOrig:
+			if expected[attrCounters] {
+				toDecode--
+			} else {
+				caps.observed(attrCounters)
+			}
+			s.Nested(func() error {
+				if err := unmarshalCounters(s, &c.Counters.SentPackets, &c.Counters.SentBytes, errs); err != nil {
+					errs.attribute(attrCounters)
+				}
+				return nil
+			})
+		case ctaCountersReply:
+			if expected[attrCounters] {
+				toDecode--
+			} else {
+				caps.observed(attrCounters)
+			}
+			s.Nested(func() error {
+				if err := unmarshalCounters(s, &c.Counters.RecvPackets, &c.Counters.RecvBytes, errs); err != nil {
+					errs.attribute(attrCounters)
+				}
+				return nil
+			})
+		case ctaID:
+			if expected[attrID] {
+				toDecode--
+			} else {
+				caps.observed(attrID)
+			}
+			if b := s.Bytes(); len(b) == 4 {
+				c.ID = binary.BigEndian.Uint32(b)
+			} else {
+				errs.attribute(attrID)
+			}
+		case ctaStatus:
+			// the status is always sent ahead of the protocol info and of the optional attributes, so it isn't counted
+			if b := s.Bytes(); len(b) == 4 {
+				c.Status = binary.BigEndian.Uint32(b)
+			} else {
+				errs.attribute(attrStatus)
+			}
+		case ctaZone:
+			// the zone is only sent for the entries of other zones than the default one, so it isn't counted
+			if b := s.Bytes(); len(b) == 2 {
+				c.Zone = binary.BigEndian.Uint16(b)
+			} else {
+				errs.attribute(attrZone)
+			}
+		case ctaTimestamp:
+			if expected[attrTimestamp] {
+				toDecode--
+			} else {
+				caps.observed(attrTimestamp)
+			}
+			s.Nested(func() error {
+				for s.Next() {
+					if s.Type() != ctaTimestampStart {
+						continue
+					}
+					if b := s.Bytes(); len(b) == 8 {
+						c.StartTime = binary.BigEndian.Uint64(b)
+					} else {
+						errs.attribute(attrTimestamp)
+					}
+				}
+				if s.Err() != nil {
+					errs.attribute(attrTimestamp)
+				}
+				return nil
 			})
 		}
 	}
@@ -98,69 +417,152 @@ func unmarshalCon(s *AttributeScanner, c *Con) error {
 	return s.Err()
 }
 
-func unmarshalTuple(s *AttributeScanner, t *ct.IPTuple) error {
+func unmarshalProtoInfo(s *AttributeScanner, c *Con, errs *decodeErrors) error {
+	for s.Next() {
+		if s.Type() != ctaProtoInfoTCP {
+			continue
+		}
+		s.Nested(func() error {
+			for s.Next() {
+				if s.Type() != ctaProtoInfoTCPState {
+					continue
+				}
+				if b := s.Bytes(); len(b) == 1 {
+					c.TCPState = TCPState(b[0])
+				} else {
+					errs.attribute(attrTCPState)
+				}
+				break
+			}
+			return s.Err()
+		})
+		break
+	}
+	return s.Err()
+}
+
+func unmarshalCounters(s *AttributeScanner, packets, bytes *uint64, errs *decodeErrors) error {
+	for s.Next() {
+		var dst *uint64
+		switch s.Type() {
+		case ctaCountersPackets, ctaCounters32Packets:
+			dst = packets
+		case ctaCountersBytes, ctaCounters32Bytes:
+			dst = bytes
+		default:
+			continue
+		}
+		if v, ok := counterValue(s.Bytes()); ok {
+			*dst = v
+		} else {
+			errs.attribute(attrCounters)
+		}
+	}
+	return s.Err()
+}
+
+// counterValue decodes a big endian integer attribute. Counters are reported on 32 bits by older kernels.
+// It returns false when the attribute has neither size.
+func counterValue(b []byte) (uint64, bool) {
+	switch len(b) {
+	case 8:
+		return binary.BigEndian.Uint64(b), true
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), true
+	}
+	return 0, false
+}
+
+func unmarshalTuple(s *AttributeScanner, t *IPTuple, errs *decodeErrors) error {
 	for toDecode := 2; toDecode > 0 && s.Next(); {
 		switch s.Type() {
 		case ctaTupleIP:
 			toDecode--
 			s.Nested(func() error {
-				return unmarshalTupleIP(s, t)
+				return unmarshalTupleIP(s, t, errs)
 			})
 		case ctaTupleProto:
 			toDecode--
 			s.Nested(func() error {
-				return unmarshalProto(s, t)
+				return unmarshalProto(s, t, errs)
 			})
 		}
 	}
 	return s.Err()
 }
 
-// We might also want to consider deferring the allocation of the IP byte slice
-func unmarshalTupleIP(s *AttributeScanner, t *ct.IPTuple) error {
+func unmarshalTupleIP(s *AttributeScanner, t *IPTuple, errs *decodeErrors) error {
 	for toDecode := 2; toDecode > 0 && s.Next(); {
 		switch s.Type() {
 		case ctaIPv4Src, ctaIPv6Src:
 			toDecode--
-			data := copySlice(s.Bytes())
-			ip := net.IP(data)
-			t.Src = &ip
+			if !validAddress(s.Type(), s.Bytes()) {
+				errs.attribute(attrAddress)
+				continue
+			}
+			t.src = keyAddrFromBytes(s.Bytes())
+			t.fields |= tupleSrc | addressFields(s.Type())
 		case ctaIPv4Dst, ctaIPv6Dst:
 			toDecode--
-			data := copySlice(s.Bytes())
-			ip := net.IP(data)
-			t.Dst = &ip
+			if !validAddress(s.Type(), s.Bytes()) {
+				errs.attribute(attrAddress)
+				continue
+			}
+			t.dst = keyAddrFromBytes(s.Bytes())
+			t.fields |= tupleDst | addressFields(s.Type())
 		}
 	}
 
 	return s.Err()
 }
 
-func unmarshalProto(s *AttributeScanner, t *ct.IPTuple) error {
-	t.Proto = &ct.ProtoTuple{}
+// validAddress returns whether an address attribute has the size of its address family
+func validAddress(typ uint16, b []byte) bool {
+	switch typ {
+	case ctaIPv4Src, ctaIPv4Dst:
+		return len(b) == net.IPv4len
+	default:
+		return len(b) == net.IPv6len
+	}
+}
 
+// addressFields returns the flags of the address family of an address attribute
+func addressFields(typ uint16) tupleFields {
+	if typ == ctaIPv6Src || typ == ctaIPv6Dst {
+		return tupleIPv6
+	}
+	return 0
+}
+
+func unmarshalProto(s *AttributeScanner, t *IPTuple, errs *decodeErrors) error {
 	for toDecode := 3; toDecode > 0 && s.Next(); {
 		switch s.Type() {
 		case ctaProtoNum:
 			toDecode--
-			protoNum := s.Bytes()[0]
-			t.Proto.Number = &protoNum
+			if len(s.Bytes()) != 1 {
+				errs.attribute(attrProtoNum)
+				continue
+			}
+			t.proto = s.Bytes()[0]
+			t.fields |= tupleProto
 		case ctaProtoSrcPort:
 			toDecode--
-			port := binary.BigEndian.Uint16(s.Bytes())
-			t.Proto.SrcPort = &port
+			if len(s.Bytes()) != 2 {
+				errs.attribute(attrPort)
+				continue
+			}
+			t.srcPort = binary.BigEndian.Uint16(s.Bytes())
+			t.fields |= tupleSrcPort
 		case ctaProtoDstPort:
 			toDecode--
-			port := binary.BigEndian.Uint16(s.Bytes())
-			t.Proto.DstPort = &port
+			if len(s.Bytes()) != 2 {
+				errs.attribute(attrPort)
+				continue
+			}
+			t.dstPort = binary.BigEndian.Uint16(s.Bytes())
+			t.fields |= tupleDstPort
 		}
 	}
 
 	return s.Err()
 }
-
-func copySlice(src []byte) []byte {
-	dst := make([]byte, len(src))
-	copy(dst, src)
-	return dst
-}
@@ -13,6 +13,8 @@ import (
 
 	"github.com/mdlayher/netlink"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 )
 
 func TestDecodeAndReleaseEvent(t *testing.T) {
@@ -28,19 +30,257 @@ func TestDecodeAndReleaseEvent(t *testing.T) {
 	assert.Len(t, connections, 1)
 	c := connections[0]
 
-	assert.True(t, net.ParseIP("10.0.2.15").Equal(*c.Origin.Src))
-	assert.True(t, net.ParseIP("2.2.2.2").Equal(*c.Origin.Dst))
+	assert.True(t, net.ParseIP("10.0.2.15").Equal(c.Origin.Src()))
+	assert.True(t, net.ParseIP("2.2.2.2").Equal(c.Origin.Dst()))
 
-	assert.Equal(t, uint16(58472), *c.Origin.Proto.SrcPort)
-	assert.Equal(t, uint16(5432), *c.Origin.Proto.DstPort)
-	assert.Equal(t, uint8(6), *c.Origin.Proto.Number)
+	assert.Equal(t, uint16(58472), c.Origin.SrcPort())
+	assert.Equal(t, uint16(5432), c.Origin.DstPort())
+	assert.Equal(t, uint8(6), c.Origin.Proto())
 
-	assert.True(t, net.ParseIP("1.1.1.1").Equal(*c.Reply.Src))
-	assert.True(t, net.ParseIP("10.0.2.15").Equal(*c.Reply.Dst))
+	assert.True(t, net.ParseIP("1.1.1.1").Equal(c.Reply.Src()))
+	assert.True(t, net.ParseIP("10.0.2.15").Equal(c.Reply.Dst()))
 
-	assert.Equal(t, uint16(5432), *c.Reply.Proto.SrcPort)
-	assert.Equal(t, uint16(58472), *c.Reply.Proto.DstPort)
-	assert.Equal(t, uint8(6), *c.Reply.Proto.Number)
+	assert.Equal(t, uint16(5432), c.Reply.SrcPort())
+	assert.Equal(t, uint16(58472), c.Reply.DstPort())
+	assert.Equal(t, uint8(6), c.Reply.Proto())
+
+	assert.Equal(t, TCPStateSynSent, c.TCPState)
+}
+
+func TestDecoderReusesMemory(t *testing.T) {
+	e := Event{
+		msgs: []netlink.Message{{Data: natEventPayload}},
+	}
+
+	expected := DecodeAndReleaseEvent(e)
+	require.Len(t, expected, 1)
+
+	decoder := NewDecoder()
+	var decoded []Con
+	decoder.DecodeAndReleaseEvent(e, func(c *Con) {
+		decoded = append(decoded, *c)
+	})
+	require.Len(t, decoded, 1)
+	assert.Equal(t, expected[0].String(), decoded[0].String())
+
+	allocs := testing.AllocsPerRun(100, func() {
+		decoder.DecodeAndReleaseEvent(e, func(c *Con) {})
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestDecodeCounters(t *testing.T) {
+	data, err := EncodeConn(&Con{
+		Origin: newIPTuple("10.0.2.15", "2.2.2.2", 58472, 5432, uint8(unix.IPPROTO_TCP)),
+		Reply:  newIPTuple("1.1.1.1", "10.0.2.15", 5432, 58472, uint8(unix.IPPROTO_TCP)),
+	})
+	require.NoError(t, err)
+
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	ae.Nested(ctaCountersOrig, func(nae *netlink.AttributeEncoder) error {
+		nae.Uint64(ctaCountersPackets, 10)
+		nae.Uint64(ctaCountersBytes, 1500)
+		return nil
+	})
+	// older kernels report the counters on 32 bits
+	ae.Nested(ctaCountersReply, func(nae *netlink.AttributeEncoder) error {
+		nae.Uint32(ctaCounters32Packets, 8)
+		nae.Uint32(ctaCounters32Bytes, 12000)
+		return nil
+	})
+	counters, err := ae.Encode()
+	require.NoError(t, err)
+
+	connections := DecodeAndReleaseEvent(Event{
+		msgs: []netlink.Message{{Data: append(data, counters...)}},
+	})
+	require.Len(t, connections, 1)
+	assert.Equal(t, Counters{SentPackets: 10, SentBytes: 1500, RecvPackets: 8, RecvBytes: 12000}, connections[0].Counters)
+}
+
+func TestDecodeStartTime(t *testing.T) {
+	data, err := EncodeConn(&Con{
+		Origin: newIPTuple("10.0.2.15", "2.2.2.2", 58472, 5432, uint8(unix.IPPROTO_TCP)),
+		Reply:  newIPTuple("1.1.1.1", "10.0.2.15", 5432, 58472, uint8(unix.IPPROTO_TCP)),
+	})
+	require.NoError(t, err)
+
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	ae.Nested(ctaTimestamp, func(nae *netlink.AttributeEncoder) error {
+		nae.Uint64(ctaTimestampStart, 1600000000000000000)
+		return nil
+	})
+	timestamp, err := ae.Encode()
+	require.NoError(t, err)
+
+	connections := DecodeAndReleaseEvent(Event{
+		msgs: []netlink.Message{{Data: append(data, timestamp...)}},
+	})
+	require.Len(t, connections, 1)
+	assert.Equal(t, uint64(1600000000000000000), connections[0].StartTime)
+}
+
+func TestDecodeID(t *testing.T) {
+	data, err := EncodeConn(&Con{
+		Origin: newIPTuple("10.0.2.15", "2.2.2.2", 58472, 5432, uint8(unix.IPPROTO_TCP)),
+		Reply:  newIPTuple("1.1.1.1", "10.0.2.15", 5432, 58472, uint8(unix.IPPROTO_TCP)),
+	})
+	require.NoError(t, err)
+
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	ae.Uint32(ctaID, 0xdeadbeef)
+	id, err := ae.Encode()
+	require.NoError(t, err)
+
+	connections := DecodeAndReleaseEvent(Event{
+		msgs: []netlink.Message{{Data: append(data, id...)}, {Data: data}},
+	})
+	require.Len(t, connections, 2)
+	assert.Equal(t, uint32(0xdeadbeef), connections[0].ID)
+	assert.Equal(t, uint32(0), connections[1].ID)
+}
+
+func TestDecodeStatus(t *testing.T) {
+	data, err := EncodeConn(&Con{
+		Origin: newIPTuple("10.0.2.15", "2.2.2.2", 58472, 53, uint8(unix.IPPROTO_UDP)),
+		Reply:  newIPTuple("1.1.1.1", "10.0.2.15", 53, 58472, uint8(unix.IPPROTO_UDP)),
+	})
+	require.NoError(t, err)
+
+	encodeStatus := func(status uint32) []byte {
+		ae := netlink.NewAttributeEncoder()
+		ae.ByteOrder = binary.BigEndian
+		ae.Uint32(ctaStatus, status)
+		b, err := ae.Encode()
+		require.NoError(t, err)
+		return b
+	}
+
+	// IPS_CONFIRMED, with and without IPS_SEEN_REPLY
+	connections := DecodeAndReleaseEvent(Event{
+		msgs: []netlink.Message{
+			{Data: append(append([]byte{}, data...), encodeStatus(0x8)...)},
+			{Data: append(append([]byte{}, data...), encodeStatus(0x8|ipsSeenReply)...)},
+			{Data: data},
+		},
+	})
+	require.Len(t, connections, 3)
+	assert.Equal(t, uint32(0x8), connections[0].Status)
+	assert.True(t, connections[0].unreplied())
+	assert.False(t, connections[1].unreplied())
+	// the entries of unknown status are assumed to be replied
+	assert.Equal(t, uint32(0), connections[2].Status)
+	assert.False(t, connections[2].unreplied())
+}
+
+func TestDecodeMalformedAttributes(t *testing.T) {
+	data, err := EncodeConn(&Con{
+		Origin: newIPTuple("10.0.2.15", "2.2.2.2", 58472, 5432, uint8(unix.IPPROTO_TCP)),
+		Reply:  newIPTuple("1.1.1.1", "10.0.2.15", 5432, 58472, uint8(unix.IPPROTO_TCP)),
+	})
+	require.NoError(t, err)
+
+	// the counters and the timestamp are too short, they are skipped without discarding the entry
+	ae := netlink.NewAttributeEncoder()
+	ae.Nested(ctaCountersOrig, func(nae *netlink.AttributeEncoder) error {
+		nae.Bytes(ctaCountersPackets, []byte{0, 10})
+		return nil
+	})
+	ae.Nested(ctaTimestamp, func(nae *netlink.AttributeEncoder) error {
+		nae.Bytes(ctaTimestampStart, []byte{1})
+		return nil
+	})
+	optional, err := ae.Encode()
+	require.NoError(t, err)
+
+	// the source address is too long, so the entry can't be decoded
+	ae = netlink.NewAttributeEncoder()
+	ae.Nested(ctaTupleOrig, func(nae *netlink.AttributeEncoder) error {
+		nae.Nested(ctaTupleIP, func(nnae *netlink.AttributeEncoder) error {
+			nnae.Bytes(ctaIPv4Src, []byte{10, 0, 2, 15, 1})
+			nnae.Bytes(ctaIPv4Dst, []byte{2, 2, 2, 2})
+			return nil
+		})
+		return nil
+	})
+	ae.Nested(ctaTupleReply, func(nae *netlink.AttributeEncoder) error {
+		return marshalIPTuple(nae, newIPTuple("1.1.1.1", "10.0.2.15", 5432, 58472, uint8(unix.IPPROTO_TCP)))
+	})
+	malformed, err := ae.Encode()
+	require.NoError(t, err)
+
+	var errs decodeErrors
+	decoder := NewDecoder()
+	decoder.errors = &errs
+	var decoded []Con
+	decoder.DecodeAndReleaseEvent(Event{
+		msgs: []netlink.Message{{Data: malformed}, {Data: append(data, optional...)}},
+	}, func(c *Con) {
+		decoded = append(decoded, *c)
+	})
+
+	require.Len(t, decoded, 1)
+	assert.Equal(t, Counters{}, decoded[0].Counters)
+	assert.Equal(t, uint64(0), decoded[0].StartTime)
+	assert.Equal(t, uint16(5432), decoded[0].Origin.DstPort())
+
+	stats := errs.getStats()
+	assert.Equal(t, int64(1), stats["decode_errors_total"])
+	assert.Equal(t, int64(1), stats["decode_errors_address"])
+	assert.Equal(t, int64(1), stats["decode_errors_counters"])
+	assert.Equal(t, int64(1), stats["decode_errors_timestamp"])
+	assert.Equal(t, int64(0), stats["decode_errors_port"])
+}
+
+func TestDecodeMissingProtocol(t *testing.T) {
+	ae := netlink.NewAttributeEncoder()
+	for _, typ := range []uint16{ctaTupleOrig, ctaTupleReply} {
+		ae.Nested(typ, func(nae *netlink.AttributeEncoder) error {
+			nae.Nested(ctaTupleIP, func(nnae *netlink.AttributeEncoder) error {
+				nnae.Bytes(ctaIPv4Src, []byte{10, 0, 2, 15})
+				nnae.Bytes(ctaIPv4Dst, []byte{2, 2, 2, 2})
+				return nil
+			})
+			// the ports are there, but not the protocol number
+			nae.Nested(ctaTupleProto, func(nnae *netlink.AttributeEncoder) error {
+				nnae.Bytes(ctaProtoSrcPort, []byte{0xe4, 0x68})
+				nnae.Bytes(ctaProtoDstPort, []byte{0x15, 0x38})
+				return nil
+			})
+			return nil
+		})
+	}
+	data, err := ae.Encode()
+	require.NoError(t, err)
+
+	var errs decodeErrors
+	decoder := NewDecoder()
+	decoder.errors = &errs
+	decoder.DecodeAndReleaseEvent(Event{msgs: []netlink.Message{{Data: data}}}, func(c *Con) {
+		t.Errorf("entry without protocol number decoded: %s", c)
+	})
+	assert.Equal(t, int64(1), errs.getStats()["decode_errors_total"])
+}
+
+func TestConStringWithoutPorts(t *testing.T) {
+	src, dst := keyAddrFromString("10.0.2.15"), keyAddrFromString("2.2.2.2")
+	fields := tupleSrc | tupleDst | tupleProto
+	c := Con{
+		Origin: IPTuple{src: src, dst: dst, proto: unix.IPPROTO_ICMP, fields: fields},
+		Reply:  IPTuple{src: dst, dst: src, proto: unix.IPPROTO_ICMP, fields: fields},
+		NetNS:  noNSID,
+	}
+	assert.Equal(t, "netns=-1 src=10.0.2.15 dst=2.2.2.2 sport=<nil> dport=<nil> src=2.2.2.2 dst=10.0.2.15 sport=<nil> dport=<nil> proto=1", c.String())
+}
+
+func TestEventType(t *testing.T) {
+	assert.Equal(t, EventNew, eventType(netlink.Header{Type: 0x100, Flags: netlink.Create | netlink.Excl}))
+	assert.Equal(t, EventNew, eventType(netlink.Header{Type: 0x100, Flags: netlink.Multi}))
+	assert.Equal(t, EventUpdate, eventType(netlink.Header{Type: 0x100}))
+	assert.Equal(t, EventDestroy, eventType(netlink.Header{Type: 0x102}))
 }
 
 func BenchmarkDecodeSingleMessage(b *testing.B) {
@@ -73,6 +313,40 @@ func BenchmarkDecodeMultipleMessages(b *testing.B) {
 	}
 }
 
+func BenchmarkDecoderSingleMessage(b *testing.B) {
+	b.ReportAllocs()
+	messages, err := loadDumpData()
+	if err != nil {
+		return
+	}
+
+	e := Event{msgs: messages[:1]}
+	decoder := NewDecoder()
+	noop := func(c *Con) {}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		decoder.DecodeAndReleaseEvent(e, noop)
+	}
+}
+
+func BenchmarkDecoderMultipleMessages(b *testing.B) {
+	b.ReportAllocs()
+	messages, err := loadDumpData()
+	if err != nil {
+		return
+	}
+
+	e := Event{msgs: messages}
+	decoder := NewDecoder()
+	noop := func(c *Con) {}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		decoder.DecodeAndReleaseEvent(e, noop)
+	}
+}
+
 func loadDumpData() ([]netlink.Message, error) {
 	f, err := os.Open("testdata/message_dump")
 	if err != nil {
@@ -0,0 +1,61 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"github.com/mdlayher/netlink"
+)
+
+const (
+	// maxDumpChunkBits bounds the number of mark bits the dumps are chunked on, and so the number of chunks
+	maxDumpChunkBits = 8
+
+	// dumpChunkAttempts is the number of times the dump of a chunk is attempted before it is given up on
+	dumpChunkAttempts = 3
+
+	// ctaMark and ctaMarkMask filter the entries of a dump by their conntrack mark
+	ctaMark     = 8
+	ctaMarkMask = 21
+)
+
+// dumpChunk is the part of the conntrack table whose entries have the given value for the bits of mask of
+// their conntrack mark
+type dumpChunk struct {
+	mark uint32
+	mask uint32
+}
+
+// newDumpChunks returns the chunks partitioning the conntrack table on the bits of mask, only keeping its
+// maxDumpChunkBits lowest bits. It returns nil when the table isn't chunked.
+func newDumpChunks(mask uint32) []dumpChunk {
+	for bits.OnesCount32(mask) > maxDumpChunkBits {
+		// clear the highest bit
+		mask &^= 1 << (31 - uint(bits.LeadingZeros32(mask)))
+	}
+	if mask == 0 {
+		return nil
+	}
+
+	// the values are all the subsets of the bits of the mask
+	chunks := make([]dumpChunk, 0, 1<<uint(bits.OnesCount32(mask)))
+	for mark := uint32(0); ; mark = (mark - mask) & mask {
+		chunks = append(chunks, dumpChunk{mark: mark, mask: mask})
+		if mark == mask {
+			break
+		}
+	}
+	return chunks
+}
+
+// attributes returns the attributes of a dump request filtering the entries of the chunk
+func (d dumpChunk) attributes() ([]byte, error) {
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	ae.Uint32(ctaMark, d.mark)
+	ae.Uint32(ctaMarkMask, d.mask)
+	return ae.Encode()
+}
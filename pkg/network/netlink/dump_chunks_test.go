@@ -0,0 +1,47 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDumpChunks(t *testing.T) {
+	assert.Nil(t, newDumpChunks(0))
+
+	assert.Equal(t, []dumpChunk{
+		{mark: 0x0, mask: 0x11},
+		{mark: 0x1, mask: 0x11},
+		{mark: 0x10, mask: 0x11},
+		{mark: 0x11, mask: 0x11},
+	}, newDumpChunks(0x11))
+
+	// only the lowest bits are kept
+	chunks := newDumpChunks(0xffffffff)
+	assert.Len(t, chunks, 1<<maxDumpChunkBits)
+	for i, chunk := range chunks {
+		assert.Equal(t, uint32(0xff), chunk.mask)
+		assert.Equal(t, uint32(i), chunk.mark)
+	}
+}
+
+func TestDumpChunkAttributes(t *testing.T) {
+	b, err := dumpChunk{mark: 0x10, mask: 0x30}.attributes()
+	require.NoError(t, err)
+
+	ad, err := netlink.NewAttributeDecoder(b)
+	require.NoError(t, err)
+	ad.ByteOrder = binary.BigEndian
+	attrs := map[uint16]uint32{}
+	for ad.Next() {
+		attrs[ad.Type()] = ad.Uint32()
+	}
+	require.NoError(t, ad.Err())
+	assert.Equal(t, map[uint16]uint32{ctaMark: 0x10, ctaMarkMask: 0x30}, attrs)
+}
@@ -6,18 +6,17 @@ package netlink
 import (
 	"encoding/binary"
 
-	ct "github.com/florianl/go-conntrack"
 	"github.com/mdlayher/netlink"
 	"github.com/mdlayher/netlink/nlenc"
 )
 
-// EncodeConn netlink encodes a `Con` object
+// EncodeConn netlink encodes a `Con` object. Its missing tuples are left out.
 func EncodeConn(conn *Con) ([]byte, error) {
 	ae := netlink.NewAttributeEncoder()
 	var err error
-	if conn.Con.Origin != nil {
+	if !conn.Origin.IsZero() {
 		ae.Nested(ctaTupleOrig, func(nae *netlink.AttributeEncoder) error {
-			err = marshalIPTuple(nae, conn.Con.Origin)
+			err = marshalIPTuple(nae, conn.Origin)
 			return err
 		})
 
@@ -26,9 +25,9 @@ func EncodeConn(conn *Con) ([]byte, error) {
 		}
 	}
 
-	if conn.Con.Reply != nil {
+	if !conn.Reply.IsZero() {
 		ae.Nested(ctaTupleReply, func(nae *netlink.AttributeEncoder) error {
-			err = marshalIPTuple(nae, conn.Con.Reply)
+			err = marshalIPTuple(nae, conn.Reply)
 			return err
 		})
 
@@ -40,33 +39,31 @@ func EncodeConn(conn *Con) ([]byte, error) {
 	return ae.Encode()
 }
 
-func marshalIPTuple(ae *netlink.AttributeEncoder, tuple *ct.IPTuple) error {
+func marshalIPTuple(ae *netlink.AttributeEncoder, tuple IPTuple) error {
 	var err error
 	ae.Nested(ctaTupleIP, func(nae *netlink.AttributeEncoder) error {
-		if tuple.Src != nil {
-			i4 := tuple.Src.To4()
-			if i4 != nil {
-				nae.Bytes(ctaIPv4Src, i4)
+		if tuple.fields&tupleSrc != 0 {
+			if tuple.isIPv4() {
+				nae.Bytes(ctaIPv4Src, tuple.src[12:])
 			} else {
-				nae.Bytes(ctaIPv6Src, *tuple.Src)
+				nae.Bytes(ctaIPv6Src, tuple.src[:])
 			}
 		}
 
-		if tuple.Dst != nil {
-			i4 := tuple.Dst.To4()
-			if i4 != nil {
-				nae.Bytes(ctaIPv4Dst, i4)
+		if tuple.fields&tupleDst != 0 {
+			if tuple.isIPv4() {
+				nae.Bytes(ctaIPv4Dst, tuple.dst[12:])
 			} else {
-				nae.Bytes(ctaIPv6Dst, *tuple.Dst)
+				nae.Bytes(ctaIPv6Dst, tuple.dst[:])
 			}
 		}
 
 		return nil
 	})
 
-	if tuple.Proto != nil {
+	if tuple.fields&tupleProto != 0 {
 		ae.Nested(ctaTupleProto, func(nae *netlink.AttributeEncoder) error {
-			err = marshalProto(nae, tuple.Proto)
+			err = marshalProto(nae, tuple)
 			return err
 		})
 
@@ -78,11 +75,15 @@ func marshalIPTuple(ae *netlink.AttributeEncoder, tuple *ct.IPTuple) error {
 	return err
 }
 
-func marshalProto(ae *netlink.AttributeEncoder, proto *ct.ProtoTuple) error {
+func marshalProto(ae *netlink.AttributeEncoder, tuple IPTuple) error {
 	ae.ByteOrder = binary.BigEndian
-	ae.Uint8(ctaProtoNum, *proto.Number)
-	ae.Uint16(ctaProtoSrcPort, *proto.SrcPort)
-	ae.Uint16(ctaProtoDstPort, *proto.DstPort)
+	ae.Uint8(ctaProtoNum, tuple.proto)
+	if tuple.fields&tupleSrcPort != 0 {
+		ae.Uint16(ctaProtoSrcPort, tuple.srcPort)
+	}
+	if tuple.fields&tupleDstPort != 0 {
+		ae.Uint16(ctaProtoDstPort, tuple.dstPort)
+	}
 	ae.ByteOrder = nlenc.NativeEndian()
 	return nil
 }
@@ -7,25 +7,14 @@ import (
 	"net"
 	"testing"
 
-	ct "github.com/florianl/go-conntrack"
 	"github.com/mdlayher/netlink"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sys/unix"
 )
 
-func newIPTuple(srcIP, dstIP string, srcPort, dstPort uint16, proto uint8) *ct.IPTuple {
-	_s := net.ParseIP(srcIP)
-	_d := net.ParseIP(dstIP)
-	return &ct.IPTuple{
-		Src: &_s,
-		Dst: &_d,
-		Proto: &ct.ProtoTuple{
-			Number:  &proto,
-			SrcPort: &srcPort,
-			DstPort: &dstPort,
-		},
-	}
+func newIPTuple(srcIP, dstIP string, srcPort, dstPort uint16, proto uint8) IPTuple {
+	return NewIPTuple(net.ParseIP(srcIP), net.ParseIP(dstIP), srcPort, dstPort, proto)
 }
 
 func TestEncodeConn(t *testing.T) {
@@ -33,10 +22,8 @@ func TestEncodeConn(t *testing.T) {
 	origin := newIPTuple("10.0.2.15", "2.2.2.2", 58472, 5432, uint8(unix.IPPROTO_TCP))
 	reply := newIPTuple("1.1.1.1", "10.0.2.15", 5432, 58472, uint8(unix.IPPROTO_TCP))
 	conn := Con{
-		Con: ct.Con{
-			Origin: origin,
-			Reply:  reply,
-		},
+		Origin: origin,
+		Reply:  reply,
 	}
 
 	data, err := EncodeConn(&conn)
@@ -53,18 +40,18 @@ func TestEncodeConn(t *testing.T) {
 	require.Len(t, connections, 1)
 	c := connections[0]
 
-	assert.True(t, conn.Con.Origin.Src.Equal(*c.Origin.Src))
-	assert.True(t, conn.Con.Origin.Dst.Equal(*c.Origin.Dst))
+	assert.True(t, conn.Origin.Src().Equal(c.Origin.Src()))
+	assert.True(t, conn.Origin.Dst().Equal(c.Origin.Dst()))
 
-	assert.Equal(t, *conn.Con.Origin.Proto.SrcPort, *c.Origin.Proto.SrcPort)
-	assert.Equal(t, *conn.Con.Origin.Proto.DstPort, *c.Origin.Proto.DstPort)
-	assert.Equal(t, *conn.Con.Origin.Proto.Number, *c.Origin.Proto.Number)
+	assert.Equal(t, conn.Origin.SrcPort(), c.Origin.SrcPort())
+	assert.Equal(t, conn.Origin.DstPort(), c.Origin.DstPort())
+	assert.Equal(t, conn.Origin.Proto(), c.Origin.Proto())
 
-	assert.True(t, conn.Con.Reply.Src.Equal(*c.Reply.Src))
-	assert.True(t, conn.Con.Reply.Dst.Equal(*c.Reply.Dst))
+	assert.True(t, conn.Reply.Src().Equal(c.Reply.Src()))
+	assert.True(t, conn.Reply.Dst().Equal(c.Reply.Dst()))
 
-	assert.Equal(t, *conn.Con.Reply.Proto.SrcPort, *c.Reply.Proto.SrcPort)
-	assert.Equal(t, *conn.Con.Reply.Proto.DstPort, *c.Reply.Proto.DstPort)
-	assert.Equal(t, *conn.Con.Reply.Proto.Number, *c.Reply.Proto.Number)
+	assert.Equal(t, conn.Reply.SrcPort(), c.Reply.SrcPort())
+	assert.Equal(t, conn.Reply.DstPort(), c.Reply.DstPort())
+	assert.Equal(t, conn.Reply.Proto(), c.Reply.Proto())
 
 }
@@ -0,0 +1,91 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+)
+
+// maxExpireInterval is the maximum interval at which the entries of the state map are checked for expiration
+const maxExpireInterval = 10 * time.Second
+
+// entryTTLs expires the entries of the state map which weren't stored again for longer than the retention of
+// their protocol, so the short-lived flows (eg. DNS lookups) don't stay in the cache until they are destroyed,
+// or until a re-sync finds them gone. A nil *entryTTLs expires nothing.
+// It must be accessed with the lock of the conntracker held.
+type entryTTLs struct {
+	tcp, udp time.Duration
+	// storedAt holds when each key of the state map with a retention was last stored, in nanoseconds since the epoch
+	storedAt map[connKey]int64
+}
+
+// newEntryTTLs returns the expiration of the entries of the given retentions, a retention of 0 keeping the entries
+// of its protocol until they are destroyed. nil is returned when neither protocol has a retention.
+func newEntryTTLs(tcp, udp time.Duration) *entryTTLs {
+	if tcp <= 0 && udp <= 0 {
+		return nil
+	}
+	return &entryTTLs{tcp: tcp, udp: udp, storedAt: make(map[connKey]int64)}
+}
+
+// ttl returns the retention of the entries of the given protocol, 0 when they are kept
+func (e *entryTTLs) ttl(transport network.ConnectionType) time.Duration {
+	if transport == network.UDP {
+		return e.udp
+	}
+	return e.tcp
+}
+
+// interval returns the interval at which the entries are checked for expiration, which is short enough
+// for the entries to be expired at most a quarter of their retention late
+func (e *entryTTLs) interval() time.Duration {
+	interval := maxExpireInterval
+	for _, ttl := range []time.Duration{e.tcp, e.udp} {
+		if ttl > 0 && ttl/4 < interval {
+			interval = ttl / 4
+		}
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+// stored records when the given key was stored
+func (e *entryTTLs) stored(k connKey, now time.Time) {
+	if e == nil || e.ttl(k.transport) <= 0 {
+		return
+	}
+	e.storedAt[k] = now.UnixNano()
+}
+
+func (e *entryTTLs) forget(k connKey) {
+	if e == nil {
+		return
+	}
+	delete(e.storedAt, k)
+}
+
+// expired returns the keys stored longer than their retention ago
+func (e *entryTTLs) expired(now time.Time) []connKey {
+	if e == nil {
+		return nil
+	}
+	var keys []connKey
+	for k, storedAt := range e.storedAt {
+		if now.UnixNano()-storedAt > int64(e.ttl(k.transport)) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (e *entryTTLs) len() int {
+	if e == nil {
+		return 0
+	}
+	return len(e.storedAt)
+}
@@ -0,0 +1,72 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntryTTLsInterval(t *testing.T) {
+	assert.Nil(t, newEntryTTLs(0, 0))
+	assert.Equal(t, maxExpireInterval, newEntryTTLs(2*time.Hour, 0).interval())
+	assert.Equal(t, 5*time.Second, newEntryTTLs(2*time.Hour, 20*time.Second).interval())
+	assert.Equal(t, time.Second, newEntryTTLs(0, time.Second).interval())
+}
+
+func TestExpireEntries(t *testing.T) {
+	clk := newFakeClock()
+	rt := newConntracker()
+	rt.clock = clk
+	rt.ttls = newEntryTTLs(2*time.Hour, 2*time.Minute)
+
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8443, 443))
+	dns := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.6"), net.ParseIP("10.96.0.10"), 17, 40001, 53, 53)
+	rt.register(dns)
+	assert.Equal(t, 2, rt.ttls.len())
+
+	clk.Add(time.Minute)
+	rt.expireEntries()
+	assert.Equal(t, 2, rt.state.len())
+
+	// the DNS lookup expires, but not the TCP connection
+	clk.Add(90 * time.Second)
+	rt.expireEntries()
+	assert.Equal(t, 1, rt.state.len())
+	rt.state.forEach(func(k connKey, _ translationValue) bool {
+		assert.Equal(t, network.TCP, k.transport)
+		return true
+	})
+	assert.Equal(t, int64(1), rt.stats.expirations)
+	assert.Equal(t, 1, rt.ttls.len())
+
+	// the retention starts again when the entry is stored again
+	clk.Add(time.Hour)
+	rt.register(dns)
+	clk.Add(time.Minute)
+	rt.expireEntries()
+	assert.Equal(t, 2, rt.state.len())
+	// the TCP connection expires in turn
+	clk.Add(time.Hour)
+	rt.expireEntries()
+	assert.Zero(t, rt.state.len())
+	assert.Zero(t, rt.ttls.len())
+}
+
+func TestEntryTTLsKeepProtocolWithoutTTL(t *testing.T) {
+	clk := newFakeClock()
+	rt := newConntracker()
+	rt.clock = clk
+	rt.ttls = newEntryTTLs(0, time.Minute)
+
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8443, 443))
+	assert.Zero(t, rt.ttls.len())
+	clk.Add(24 * time.Hour)
+	rt.expireEntries()
+	assert.Equal(t, 1, rt.state.len())
+}
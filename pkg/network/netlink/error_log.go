@@ -0,0 +1,61 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxRecentErrors is the number of errors kept by the conntrackers, to be reported without going through the logs
+const maxRecentErrors = 20
+
+// errorLog keeps the last errors it was given, along with the time they happened at.
+// A nil errorLog keeps nothing.
+type errorLog struct {
+	sync.Mutex
+	errors []string
+	// next is the index of the oldest error once the log is full, which the next error replaces
+	next int
+	size int
+}
+
+func newErrorLog(size int) *errorLog {
+	return &errorLog{
+		errors: make([]string, 0, size),
+		size:   size,
+	}
+}
+
+func (l *errorLog) add(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	msg := fmt.Sprintf("%s %s", time.Now().UTC().Format(time.RFC3339), fmt.Sprintf(format, args...))
+
+	l.Lock()
+	defer l.Unlock()
+	if len(l.errors) < l.size {
+		l.errors = append(l.errors, msg)
+		return
+	}
+	l.errors[l.next] = msg
+	l.next = (l.next + 1) % l.size
+}
+
+// recent returns the errors kept, the oldest first
+func (l *errorLog) recent() []string {
+	if l == nil {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	if len(l.errors) == 0 {
+		return nil
+	}
+	errors := make([]string, 0, len(l.errors))
+	errors = append(errors, l.errors[l.next:]...)
+	return append(errors, l.errors[:l.next]...)
+}
@@ -0,0 +1,31 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorLog(t *testing.T) {
+	var nilLog *errorLog
+	nilLog.add("ignored")
+	assert.Nil(t, nilLog.recent())
+
+	l := newErrorLog(3)
+	assert.Nil(t, l.recent())
+	for i := 0; i < 5; i++ {
+		l.add("error %d", i)
+	}
+
+	errors := l.recent()
+	require.Len(t, errors, 3)
+	for i, err := range errors {
+		assert.True(t, strings.HasSuffix(err, fmt.Sprintf(" error %d", i+2)), err)
+	}
+}
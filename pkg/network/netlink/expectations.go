@@ -0,0 +1,309 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+	"github.com/mdlayher/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// netlinkCtExpNew is the multicast group of new conntrack expectation events
+	netlinkCtExpNew = uint32(4)
+
+	// ipctnlMsgExpGet is the message type used to dump the expectation table
+	ipctnlMsgExpGet = 1
+)
+
+const (
+	ctaExpectTuple   = 2
+	ctaExpectMask    = 3
+	ctaExpectTimeout = 4
+	ctaExpectNAT     = 10
+
+	ctaExpectNATTuple = 2
+)
+
+// expectation is an entry of the conntrack expectation table, created by a conntrack helper (FTP, SIP, TFTP...)
+// for a connection related to the one it inspects, such as the data channel of an FTP session.
+// Only the expectations of NAT'ed connections are kept, since they are the only ones carrying a translation.
+type expectation struct {
+	// tuple is the origin tuple of the expected connection
+	tuple connKey
+	// anySrcIP and anySrcPort are set when the source of the expected connection isn't known in advance
+	anySrcIP, anySrcPort bool
+
+	// natIP and natPort are the address the expected connection is forwarded to
+	natIP   keyAddr
+	natPort uint16
+
+	expires time.Time
+}
+
+// translationFor returns the translation of the given connection when it is the expected connection,
+// seen either from its source or from the address it is forwarded to
+func (e *expectation) translationFor(k connKey) *network.IPTranslation {
+	if k.transport != e.tuple.transport {
+		return nil
+	}
+
+	var t *network.IPTranslation
+	switch {
+	case e.matchesSource(k.srcIP, k.srcPort) && k.dstIP == e.tuple.dstIP && k.dstPort == e.tuple.dstPort:
+		t = &network.IPTranslation{
+			ReplSrcIP:   e.natIP.address(),
+			ReplSrcPort: e.natPort,
+			ReplDstIP:   k.srcIP.address(),
+			ReplDstPort: k.srcPort,
+		}
+	case k.srcIP == e.natIP && k.srcPort == e.natPort && e.matchesSource(k.dstIP, k.dstPort):
+		t = &network.IPTranslation{
+			ReplSrcIP:   k.dstIP.address(),
+			ReplSrcPort: k.dstPort,
+			ReplDstIP:   e.tuple.dstIP.address(),
+			ReplDstPort: e.tuple.dstPort,
+		}
+	default:
+		return nil
+	}
+	t.NATType = natType(k, t)
+	return t
+}
+
+func (e *expectation) matchesSource(ip keyAddr, port uint16) bool {
+	return (e.anySrcIP || ip == e.tuple.srcIP) && (e.anySrcPort || port == e.tuple.srcPort)
+}
+
+// expectationTracker keeps a record of the expectations of the root network namespace, so the connections
+// created by conntrack helpers can be translated before conntrack reports them.
+// Expectations are kept until their timeout, even once the expected connection was created, since the
+// conntrack entry of the expected connection may be reported after the tracer sees the connection.
+type expectationTracker struct {
+	sync.RWMutex
+	expectations map[connKey]*expectation
+	maxSize      int
+	// clock expires the expectations
+	clock clock
+
+	conn    *netlink.Conn
+	scanner *AttributeScanner
+	closed  int32
+
+	stats struct {
+		received int64
+		dropped  int64
+	}
+}
+
+// newExpectationTracker dumps the expectation table of the root network namespace and subscribes to the
+// creation of new expectations
+func newExpectationTracker(procRoot string, maxSize int, clk clock) (*expectationTracker, error) {
+	rootNS, err := netns.GetFromPath(filepath.Join(procRoot, "1/ns/net"))
+	if err != nil {
+		return nil, fmt.Errorf("could not get root namespace: %w", err)
+	}
+	defer rootNS.Close()
+
+	et := &expectationTracker{
+		expectations: make(map[connKey]*expectation),
+		maxSize:      maxSize,
+		clock:        clk,
+		scanner:      NewAttributeScanner(),
+	}
+
+	et.conn, err = netlink.Dial(unix.NETLINK_NETFILTER, &netlink.Config{
+		NetNS:  int(rootNS),
+		Groups: 1 << (netlinkCtExpNew - 1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not open netlink socket: %w", err)
+	}
+
+	if err := et.dump(rootNS); err != nil {
+		et.conn.Close()
+		return nil, err
+	}
+
+	go et.run()
+	return et, nil
+}
+
+// dump loads the current expectation table, using a separate socket so the dump isn't mixed up with events
+func (et *expectationTracker) dump(rootNS netns.NsHandle) error {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, &netlink.Config{NetNS: int(rootNS)})
+	if err != nil {
+		return fmt.Errorf("could not open netlink socket: %w", err)
+	}
+	defer conn.Close()
+
+	msgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType((unix.NFNL_SUBSYS_CTNETLINK_EXP << 8) | ipctnlMsgExpGet),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: []byte{unix.AF_UNSPEC, unix.NFNETLINK_V0, 0, 0},
+	})
+	if err != nil {
+		return fmt.Errorf("could not dump conntrack expectations: %w", err)
+	}
+
+	et.add(msgs)
+	return nil
+}
+
+func (et *expectationTracker) run() {
+	for {
+		msgs, err := et.conn.Receive()
+		if err != nil {
+			if atomic.LoadInt32(&et.closed) == 1 || socketError(err) == errEOF {
+				return
+			}
+			log.Debugf("error receiving conntrack expectations: %s", err)
+			continue
+		}
+		et.add(msgs)
+	}
+}
+
+func (et *expectationTracker) add(msgs []netlink.Message) {
+	now := et.clock.Now()
+
+	et.Lock()
+	defer et.Unlock()
+
+	for _, m := range msgs {
+		atomic.AddInt64(&et.stats.received, 1)
+		e, ok := decodeExpectation(et.scanner, m.Data, now)
+		if !ok {
+			continue
+		}
+
+		if len(et.expectations) >= et.maxSize {
+			et.removeExpired(now)
+		}
+		if len(et.expectations) >= et.maxSize {
+			atomic.AddInt64(&et.stats.dropped, 1)
+			continue
+		}
+		et.expectations[e.tuple] = e
+	}
+}
+
+// removeExpired must be called with the write lock held
+func (et *expectationTracker) removeExpired(now time.Time) {
+	for k, e := range et.expectations {
+		if now.After(e.expires) {
+			delete(et.expectations, k)
+		}
+	}
+}
+
+// get returns the translation of the given connection when it is an expected connection
+func (et *expectationTracker) get(k connKey) *network.IPTranslation {
+	now := et.clock.Now()
+
+	et.RLock()
+	defer et.RUnlock()
+
+	// the exact tuple is usually unknown, since helpers expect connections from any source port
+	for _, e := range et.expectations {
+		if now.After(e.expires) {
+			continue
+		}
+		if t := e.translationFor(k); t != nil {
+			return t
+		}
+	}
+	return nil
+}
+
+func (et *expectationTracker) getStats() map[string]int64 {
+	et.RLock()
+	size := len(et.expectations)
+	et.RUnlock()
+
+	return map[string]int64{
+		"expectations":                int64(size),
+		"expectations_received_total": atomic.LoadInt64(&et.stats.received),
+		"expectations_dropped":        atomic.LoadInt64(&et.stats.dropped),
+	}
+}
+
+func (et *expectationTracker) close() {
+	atomic.StoreInt32(&et.closed, 1)
+	et.conn.Close()
+}
+
+// decodeExpectation decodes an expectation message, and returns false when it isn't an expectation
+// of a NAT'ed connection
+func decodeExpectation(s *AttributeScanner, data []byte, now time.Time) (*expectation, bool) {
+	if err := s.ResetTo(data); err != nil {
+		return nil, false
+	}
+
+	var tuple, mask, nat IPTuple
+	var hasTuple, hasMask, hasNAT bool
+	var timeout uint32
+	for s.Next() {
+		switch s.Type() {
+		case ctaExpectTuple:
+			hasTuple = true
+			s.Nested(func() error {
+				return unmarshalTuple(s, &tuple, nil)
+			})
+		case ctaExpectMask:
+			hasMask = true
+			s.Nested(func() error {
+				return unmarshalTuple(s, &mask, nil)
+			})
+		case ctaExpectTimeout:
+			if b := s.Bytes(); len(b) == 4 {
+				timeout = binary.BigEndian.Uint32(b)
+			}
+		case ctaExpectNAT:
+			s.Nested(func() error {
+				for s.Next() {
+					if s.Type() == ctaExpectNATTuple {
+						hasNAT = true
+						s.Nested(func() error {
+							return unmarshalTuple(s, &nat, nil)
+						})
+					}
+				}
+				return s.Err()
+			})
+		}
+	}
+	if s.Err() != nil || !hasTuple || !hasNAT || nat.fields&tupleSrc == 0 {
+		return nil, false
+	}
+
+	k, ok := formatKey(tuple)
+	if !ok {
+		return nil, false
+	}
+
+	e := &expectation{
+		tuple:   k,
+		natIP:   nat.src,
+		natPort: nat.srcPort,
+		expires: now.Add(time.Duration(timeout) * time.Second),
+	}
+	if hasMask {
+		e.anySrcIP = mask.fields&tupleSrc == 0 || net.IP(mask.src[:]).IsUnspecified()
+		e.anySrcPort = mask.srcPort == 0
+	}
+	return e, true
+}
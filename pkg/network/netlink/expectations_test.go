@@ -0,0 +1,146 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// encodeFTPExpectation encodes the expectation created by the FTP helper for the active mode data channel of a
+// client at 10.0.0.1 behind a NAT, whose PORT command was rewritten to 1.1.1.1:5001. The FTP server at
+// 2.2.2.2 connects from any port, and the data channel is forwarded to the client at 10.0.0.1:6001.
+func encodeFTPExpectation(t *testing.T) []byte {
+	ae := netlink.NewAttributeEncoder()
+	ae.Nested(ctaExpectTuple, func(nae *netlink.AttributeEncoder) error {
+		return marshalIPTuple(nae, newIPTuple("2.2.2.2", "1.1.1.1", 0, 5001, unix.IPPROTO_TCP))
+	})
+	ae.Nested(ctaExpectMask, func(nae *netlink.AttributeEncoder) error {
+		return marshalIPTuple(nae, newIPTuple("255.255.255.255", "255.255.255.255", 0, 0xffff, 0xff))
+	})
+	ae.ByteOrder = binary.BigEndian
+	ae.Uint32(ctaExpectTimeout, 300)
+	ae.Nested(ctaExpectNAT, func(nae *netlink.AttributeEncoder) error {
+		nae.Nested(ctaExpectNATTuple, func(nnae *netlink.AttributeEncoder) error {
+			return marshalIPTuple(nnae, newIPTuple("10.0.0.1", "0.0.0.0", 6001, 0, unix.IPPROTO_TCP))
+		})
+		return nil
+	})
+
+	data, err := ae.Encode()
+	require.NoError(t, err)
+	return append([]byte{unix.AF_INET, unix.NFNETLINK_V0, 0, 0}, data...)
+}
+
+func TestDecodeExpectation(t *testing.T) {
+	now := time.Now()
+	e, ok := decodeExpectation(NewAttributeScanner(), encodeFTPExpectation(t), now)
+	require.True(t, ok)
+
+	assert.Equal(t, connKey{
+		srcIP:     keyAddrFromString("2.2.2.2"),
+		dstIP:     keyAddrFromString("1.1.1.1"),
+		dstPort:   5001,
+		transport: network.TCP,
+	}, e.tuple)
+	assert.False(t, e.anySrcIP)
+	assert.True(t, e.anySrcPort)
+	assert.Equal(t, keyAddrFromString("10.0.0.1"), e.natIP)
+	assert.Equal(t, uint16(6001), e.natPort)
+	assert.Equal(t, now.Add(300*time.Second), e.expires)
+}
+
+func TestExpectationTranslation(t *testing.T) {
+	clk := newFakeClock()
+	rt := newConntracker()
+	rt.clock = clk
+	rt.expectations = &expectationTracker{
+		expectations: make(map[connKey]*expectation),
+		maxSize:      10,
+		clock:        clk,
+		scanner:      NewAttributeScanner(),
+	}
+	rt.expectations.add([]netlink.Message{{Data: encodeFTPExpectation(t)}})
+
+	// the data channel, as seen by the client
+	translation := rt.GetTranslationForConn(network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  6001,
+		Dest:   util.AddressFromString("2.2.2.2"),
+		DPort:  20,
+		Type:   network.TCP,
+	})
+	require.NotNil(t, translation)
+	assert.Equal(t, network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("2.2.2.2"),
+		ReplSrcPort: 20,
+		ReplDstIP:   util.AddressFromString("1.1.1.1"),
+		ReplDstPort: 5001,
+		NATType:     network.SNAT,
+	}, *translation)
+
+	// the data channel, as seen by the server
+	translation = rt.GetTranslationForConn(network.ConnectionStats{
+		Source: util.AddressFromString("2.2.2.2"),
+		SPort:  20,
+		Dest:   util.AddressFromString("1.1.1.1"),
+		DPort:  5001,
+		Type:   network.TCP,
+	})
+	require.NotNil(t, translation)
+	assert.Equal(t, network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("10.0.0.1"),
+		ReplSrcPort: 6001,
+		ReplDstIP:   util.AddressFromString("2.2.2.2"),
+		ReplDstPort: 20,
+		NATType:     network.DNAT,
+	}, *translation)
+
+	// unrelated connections aren't translated
+	assert.Nil(t, rt.GetTranslationForConn(network.ConnectionStats{
+		Source: util.AddressFromString("3.3.3.3"),
+		SPort:  20,
+		Dest:   util.AddressFromString("1.1.1.1"),
+		DPort:  5001,
+		Type:   network.TCP,
+	}))
+
+	// expired expectations are ignored
+	clk.Add(300 * time.Second)
+	assert.NotNil(t, rt.expectations.get(connKey{
+		srcIP:     keyAddrFromString("2.2.2.2"),
+		srcPort:   20,
+		dstIP:     keyAddrFromString("1.1.1.1"),
+		dstPort:   5001,
+		transport: network.TCP,
+	}))
+	clk.Add(time.Second)
+	assert.Nil(t, rt.expectations.get(connKey{
+		srcIP:     keyAddrFromString("2.2.2.2"),
+		srcPort:   20,
+		dstIP:     keyAddrFromString("1.1.1.1"),
+		dstPort:   5001,
+		transport: network.TCP,
+	}))
+}
+
+func TestDecodeExpectationWithoutNAT(t *testing.T) {
+	ae := netlink.NewAttributeEncoder()
+	ae.Nested(ctaExpectTuple, func(nae *netlink.AttributeEncoder) error {
+		return marshalIPTuple(nae, newIPTuple("2.2.2.2", "10.0.0.1", 0, 6001, unix.IPPROTO_TCP))
+	})
+	data, err := ae.Encode()
+	require.NoError(t, err)
+
+	_, ok := decodeExpectation(NewAttributeScanner(), append([]byte{unix.AF_INET, unix.NFNETLINK_V0, 0, 0}, data...), time.Now())
+	assert.False(t, ok)
+}
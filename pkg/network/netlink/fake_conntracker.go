@@ -0,0 +1,464 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+// FakeConntracker is a Conntracker whose translations are set by tests instead of being read off conntrack.
+// Its behavior can be scripted to simulate a slow conntracker (SetDelay), a full one (SetMaxSize)
+// or a failing one (FailNextLookups). Its zero value is not usable, use NewFakeConntracker.
+type FakeConntracker struct {
+	mux        sync.RWMutex
+	state      map[connKey]*network.IPTranslation
+	replies    map[connKey]struct{}
+	tcpStates  map[connKey]TCPState
+	counters   map[connKey]Counters
+	startTimes map[connKey]time.Time
+	statuses   map[connKey]Status
+	gateways   *natGateways
+
+	delay       time.Duration
+	maxSize     int
+	failLookups int
+	rateLimit   int
+	closed      bool
+
+	notifier translationNotifier
+
+	stats struct {
+		gets           int64
+		getHits        int64
+		failedGets     int64
+		registers      int64
+		registersTotal int64
+		unregisters    int64
+		refreshes      int64
+	}
+}
+
+var _ Conntracker = &FakeConntracker{}
+
+// NewFakeConntracker creates an empty FakeConntracker. Translations are then added with AddTranslation,
+// or with LoadTable for a canned table.
+func NewFakeConntracker() *FakeConntracker {
+	return &FakeConntracker{
+		state:      make(map[connKey]*network.IPTranslation),
+		replies:    make(map[connKey]struct{}),
+		tcpStates:  make(map[connKey]TCPState),
+		counters:   make(map[connKey]Counters),
+		startTimes: make(map[connKey]time.Time),
+		statuses:   make(map[connKey]Status),
+		gateways:   newNATGateways(),
+	}
+}
+
+// SetDelay makes every lookup take at least d, to simulate a conntracker contended by its updates
+func (ctr *FakeConntracker) SetDelay(d time.Duration) {
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+	ctr.delay = d
+}
+
+// SetMaxSize limits the number of entries held, the translations added past it are dropped the same way
+// they are when the conntrack cache exceeds its size. 0 removes the limit.
+func (ctr *FakeConntracker) SetMaxSize(size int) {
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+	ctr.maxSize = size
+}
+
+// FailNextLookups makes the next n connections looked up miss their translation, even when one is held
+func (ctr *FakeConntracker) FailNextLookups(n int) {
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+	ctr.failLookups = n
+}
+
+// AddTranslation stores the translation of a connection, along with the reverse translation of its reply,
+// as conntracker does for a NAT-ed entry. It returns false when the translation was dropped because of the max size.
+func (ctr *FakeConntracker) AddTranslation(c network.ConnectionStats, t *network.IPTranslation) bool {
+	k := connStatsToConnKey(c)
+	reverse := ipTranslationToConnKey(k.transport, t)
+	reverseTrans := &network.IPTranslation{
+		ReplSrcIP:   k.dstIP.address(),
+		ReplDstIP:   k.srcIP.address(),
+		ReplSrcPort: k.dstPort,
+		ReplDstPort: k.srcPort,
+	}
+
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+
+	atomic.AddInt64(&ctr.stats.registersTotal, 1)
+	if ctr.maxSize > 0 && len(ctr.state)+2 > ctr.maxSize {
+		return false
+	}
+
+	ctr.setEntry(k, t, false)
+	ctr.setEntry(reverse, reverseTrans, true)
+	ctr.gateways.observe(k, t.ReplDstIP)
+	atomic.AddInt64(&ctr.stats.registers, 1)
+	return true
+}
+
+// LoadTable stores the entries of a conntrack table, such as the ones dumped by the conntrack debug endpoint
+func (ctr *FakeConntracker) LoadTable(entries []network.DebugConntrackEntry) error {
+	for _, e := range entries {
+		k, err := debugTupleToConnKey(e.Proto, e.Origin)
+		if err != nil {
+			return err
+		}
+		reply, err := debugTupleToConnKey(e.Proto, e.Reply)
+		if err != nil {
+			return err
+		}
+
+		ctr.mux.Lock()
+		atomic.AddInt64(&ctr.stats.registersTotal, 1)
+		if ctr.maxSize > 0 && len(ctr.state) >= ctr.maxSize {
+			ctr.mux.Unlock()
+			continue
+		}
+		ctr.setEntry(k, &network.IPTranslation{
+			ReplSrcIP:   reply.srcIP.address(),
+			ReplDstIP:   reply.dstIP.address(),
+			ReplSrcPort: reply.srcPort,
+			ReplDstPort: reply.dstPort,
+		}, false)
+		atomic.AddInt64(&ctr.stats.registers, 1)
+		ctr.mux.Unlock()
+	}
+	return nil
+}
+
+// SetTCPState sets the state returned for a TCP connection
+func (ctr *FakeConntracker) SetTCPState(c network.ConnectionStats, state TCPState) {
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+	ctr.tcpStates[connStatsToConnKey(c)] = state
+}
+
+// SetCounters sets the counters returned for a connection
+func (ctr *FakeConntracker) SetCounters(c network.ConnectionStats, counters Counters) {
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+	ctr.counters[connStatsToConnKey(c)] = counters
+}
+
+// SetStartTime sets the start time returned for a connection
+func (ctr *FakeConntracker) SetStartTime(c network.ConnectionStats, start time.Time) {
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+	ctr.startTimes[connStatsToConnKey(c)] = start
+}
+
+// SetStatus sets the status flags returned for a connection
+func (ctr *FakeConntracker) SetStatus(c network.ConnectionStats, status Status) {
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+	ctr.statuses[connStatsToConnKey(c)] = status
+}
+
+// TargetRateLimit returns the rate limit last set with SetTargetRateLimit
+func (ctr *FakeConntracker) TargetRateLimit() int {
+	ctr.mux.RLock()
+	defer ctr.mux.RUnlock()
+	return ctr.rateLimit
+}
+
+// Closed returns whether Close was called
+func (ctr *FakeConntracker) Closed() bool {
+	ctr.mux.RLock()
+	defer ctr.mux.RUnlock()
+	return ctr.closed
+}
+
+// GetTranslationForConn returns the translation stored for the connection, after the delay set
+func (ctr *FakeConntracker) GetTranslationForConn(c network.ConnectionStats) *network.IPTranslation {
+	ctr.wait()
+
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+	return ctr.lookup(connStatsToConnKey(c))
+}
+
+// GetTranslationsForConns returns the translations of the given connections, in the same order,
+// after the delay set
+func (ctr *FakeConntracker) GetTranslationsForConns(conns []network.ConnectionStats) []*network.IPTranslation {
+	ctr.wait()
+
+	translations := make([]*network.IPTranslation, len(conns))
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+	for i := range conns {
+		translations[i] = ctr.lookup(connStatsToConnKey(conns[i]))
+	}
+	return translations
+}
+
+// GetEntryForConn returns the translation of a connection along with the state, counters, start time and status set
+// for it, after the delay set
+func (ctr *FakeConntracker) GetEntryForConn(c network.ConnectionStats) *ConntrackEntry {
+	ctr.wait()
+
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+	k := connStatsToConnKey(c)
+	t := ctr.lookup(k)
+	if t == nil {
+		return nil
+	}
+
+	e := newConntrackEntry(c, t)
+	if c.Type == network.TCP {
+		e.TCPState = ctr.tcpStates[k]
+	}
+	e.Counters = ctr.counters[k]
+	e.StartTime = ctr.startTimes[k]
+	e.Status = ctr.statuses[k]
+	return e
+}
+
+// DeleteTranslation removes the translation of a connection, and the reverse translation of its reply
+func (ctr *FakeConntracker) DeleteTranslation(c network.ConnectionStats) {
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+	ctr.deleteTranslation(c)
+}
+
+// DeleteTranslations removes the translations of the given connections
+func (ctr *FakeConntracker) DeleteTranslations(conns []network.ConnectionStats) {
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+	for i := range conns {
+		ctr.deleteTranslation(conns[i])
+	}
+}
+
+// GetTCPStateForConn returns the state set with SetTCPState, TCPStateNone if there is none
+func (ctr *FakeConntracker) GetTCPStateForConn(c network.ConnectionStats) TCPState {
+	if c.Type != network.TCP {
+		return TCPStateNone
+	}
+
+	ctr.mux.RLock()
+	defer ctr.mux.RUnlock()
+	if s, ok := ctr.tcpStates[connStatsToConnKey(c)]; ok {
+		return s
+	}
+	return TCPStateNone
+}
+
+// GetTCPStatesForConns returns the states set with SetTCPState for the given connections, in the same order
+func (ctr *FakeConntracker) GetTCPStatesForConns(conns []network.ConnectionStats) []TCPState {
+	states := make([]TCPState, len(conns))
+	ctr.mux.RLock()
+	defer ctr.mux.RUnlock()
+	for i := range conns {
+		if conns[i].Type == network.TCP {
+			states[i] = ctr.tcpStates[connStatsToConnKey(conns[i])]
+		}
+	}
+	return states
+}
+
+// GetCountersForConn returns the counters set with SetCounters
+func (ctr *FakeConntracker) GetCountersForConn(c network.ConnectionStats) (Counters, bool) {
+	ctr.mux.RLock()
+	defer ctr.mux.RUnlock()
+	counters, ok := ctr.counters[connStatsToConnKey(c)]
+	return counters, ok
+}
+
+// GetStartTimeForConn returns the start time set with SetStartTime
+func (ctr *FakeConntracker) GetStartTimeForConn(c network.ConnectionStats) (time.Time, bool) {
+	ctr.mux.RLock()
+	defer ctr.mux.RUnlock()
+	start, ok := ctr.startTimes[connStatsToConnKey(c)]
+	return start, ok
+}
+
+// GetStatusForConn returns the status flags set with SetStatus
+func (ctr *FakeConntracker) GetStatusForConn(c network.ConnectionStats) Status {
+	ctr.mux.RLock()
+	defer ctr.mux.RUnlock()
+	return ctr.statuses[connStatsToConnKey(c)]
+}
+
+// Subscribe returns a channel notified when translations are added or deleted
+func (ctr *FakeConntracker) Subscribe(filter TranslationFilter) (<-chan TranslationEvent, func()) {
+	return ctr.notifier.subscribe(filter)
+}
+
+// DumpCachedTable returns all the translations held
+func (ctr *FakeConntracker) DumpCachedTable() []network.DebugConntrackEntry {
+	ctr.mux.RLock()
+	defer ctr.mux.RUnlock()
+
+	entries := make([]network.DebugConntrackEntry, 0, len(ctr.state))
+	for k, t := range ctr.state {
+		entries = append(entries, debugConntrackEntry(k, t))
+	}
+	return entries
+}
+
+// GetNATGateways returns the addresses the translations added with AddTranslation source NAT their connections to
+func (ctr *FakeConntracker) GetNATGateways() []util.Address {
+	ctr.mux.RLock()
+	defer ctr.mux.RUnlock()
+	return ctr.gateways.addresses()
+}
+
+// GetRecentErrors returns nil, since nothing can fail
+func (ctr *FakeConntracker) GetRecentErrors() []string {
+	return nil
+}
+
+// SetTargetRateLimit records the limit, which TargetRateLimit then returns
+func (ctr *FakeConntracker) SetTargetRateLimit(limit int) {
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+	ctr.rateLimit = limit
+}
+
+// Refresh only counts the refreshes, since there is no kernel table to reconcile the translations with
+func (ctr *FakeConntracker) Refresh(family uint8) error {
+	atomic.AddInt64(&ctr.stats.refreshes, 1)
+	return nil
+}
+
+// Describe returns the fake backend, along with the rate limit last set with SetTargetRateLimit
+func (ctr *FakeConntracker) Describe() network.ConntrackDescription {
+	return network.ConntrackDescription{
+		Backend:         "fake",
+		Status:          "ready",
+		TargetRateLimit: ctr.TargetRateLimit(),
+	}
+}
+
+// GetStats returns the same counters the conntracker does, for the entries held and the lookups made
+func (ctr *FakeConntracker) GetStats() Stats {
+	ctr.mux.RLock()
+	size := len(ctr.state)
+	ctr.mux.RUnlock()
+
+	s := Stats{
+		StateSize:        int64(size),
+		Gets:             atomic.LoadInt64(&ctr.stats.gets),
+		GetHits:          atomic.LoadInt64(&ctr.stats.getHits),
+		Registers:        atomic.LoadInt64(&ctr.stats.registers),
+		RegistersDropped: atomic.LoadInt64(&ctr.stats.registersTotal) - atomic.LoadInt64(&ctr.stats.registers),
+		Unregisters:      atomic.LoadInt64(&ctr.stats.unregisters),
+		Extra: map[string]int64{
+			"gets_failed":     atomic.LoadInt64(&ctr.stats.failedGets),
+			"refreshes_total": atomic.LoadInt64(&ctr.stats.refreshes),
+		},
+	}
+	for k, v := range ctr.notifier.getStats() {
+		s.set(k, v)
+	}
+	return s
+}
+
+// Close marks the conntracker as closed. The translations are kept, so they can still be checked.
+func (ctr *FakeConntracker) Close() {
+	ctr.mux.Lock()
+	defer ctr.mux.Unlock()
+	ctr.closed = true
+}
+
+func (ctr *FakeConntracker) wait() {
+	ctr.mux.RLock()
+	delay := ctr.delay
+	ctr.mux.RUnlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// lookup must be called with the write lock held, since it consumes the failures scripted
+func (ctr *FakeConntracker) lookup(k connKey) *network.IPTranslation {
+	atomic.AddInt64(&ctr.stats.gets, 1)
+	if ctr.failLookups > 0 {
+		ctr.failLookups--
+		atomic.AddInt64(&ctr.stats.failedGets, 1)
+		return nil
+	}
+	t := ctr.state[k]
+	if t != nil {
+		atomic.AddInt64(&ctr.stats.getHits, 1)
+	}
+	return t
+}
+
+// setEntry stores the given direction of a connection, the reply one when reply is set. It must be called with the
+// write lock held.
+func (ctr *FakeConntracker) setEntry(k connKey, t *network.IPTranslation, reply bool) {
+	ctr.state[k] = t
+	if reply {
+		ctr.replies[k] = struct{}{}
+	} else {
+		delete(ctr.replies, k)
+	}
+	ctr.notifier.notifyDirection(TranslationCreated, k, t, reply)
+}
+
+// deleteEntry removes the given key, notifying about it. It must be called with the write lock held.
+func (ctr *FakeConntracker) deleteEntry(k connKey, t *network.IPTranslation) {
+	_, reply := ctr.replies[k]
+	delete(ctr.replies, k)
+	delete(ctr.state, k)
+	ctr.notifier.notifyDirection(TranslationDeleted, k, t, reply)
+}
+
+// deleteTranslation must be called with the write lock held
+func (ctr *FakeConntracker) deleteTranslation(c network.ConnectionStats) {
+	k := connStatsToConnKey(c)
+	t, ok := ctr.state[k]
+	if !ok {
+		return
+	}
+
+	reverse := ipTranslationToConnKey(k.transport, t)
+	if rt, ok := ctr.state[reverse]; ok {
+		ctr.deleteEntry(reverse, rt)
+		ctr.gateways.forget(reverse)
+	}
+	ctr.deleteEntry(k, t)
+	ctr.gateways.forget(k)
+	delete(ctr.tcpStates, k)
+	delete(ctr.counters, k)
+	delete(ctr.startTimes, k)
+	delete(ctr.statuses, k)
+	atomic.AddInt64(&ctr.stats.unregisters, 1)
+}
+
+func debugTupleToConnKey(proto string, t network.DebugConntrackTuple) (connKey, error) {
+	k := connKey{srcPort: t.SPort, dstPort: t.DPort}
+	switch proto {
+	case network.TCP.String():
+		k.transport = network.TCP
+	case network.UDP.String():
+		k.transport = network.UDP
+	default:
+		return k, fmt.Errorf("invalid protocol %q", proto)
+	}
+
+	src, dst := net.ParseIP(t.Src), net.ParseIP(t.Dst)
+	if src == nil || dst == nil {
+		return k, fmt.Errorf("invalid addresses %q, %q", t.Src, t.Dst)
+	}
+	k.srcIP = keyAddrFromNetIP(src)
+	k.dstIP = keyAddrFromNetIP(dst)
+	return k, nil
+}
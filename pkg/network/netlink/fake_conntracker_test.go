@@ -0,0 +1,150 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeConntracker(t *testing.T) {
+	ctr := NewFakeConntracker()
+	events, cancel := ctr.Subscribe(nil)
+	defer cancel()
+
+	conn := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("50.30.40.10"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+	trans := &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("20.0.0.1"),
+		ReplDstIP:   util.AddressFromString("10.0.0.1"),
+		ReplSrcPort: 80,
+		ReplDstPort: 12345,
+	}
+	require.True(t, ctr.AddTranslation(conn, trans))
+	assert.Equal(t, trans, ctr.GetTranslationForConn(conn))
+	assert.Equal(t, TranslationCreated, (<-events).Type)
+
+	// the reply is translated back to the connection
+	reply := network.ConnectionStats{
+		Source: util.AddressFromString("20.0.0.1"),
+		SPort:  80,
+		Dest:   util.AddressFromString("10.0.0.1"),
+		DPort:  12345,
+		Type:   network.TCP,
+	}
+	assert.Equal(t, util.AddressFromString("50.30.40.10"), ctr.GetTranslationForConn(reply).ReplSrcIP)
+
+	ctr.FailNextLookups(1)
+	assert.Equal(t, []*network.IPTranslation{nil, trans}, ctr.GetTranslationsForConns([]network.ConnectionStats{conn, conn}))
+
+	ctr.SetTCPState(conn, TCPStateEstablished)
+	assert.Equal(t, TCPStateEstablished, ctr.GetTCPStateForConn(conn))
+	assert.Equal(t, []TCPState{TCPStateEstablished, TCPStateNone}, ctr.GetTCPStatesForConns([]network.ConnectionStats{conn, reply}))
+	e := ctr.GetEntryForConn(conn)
+	require.NotNil(t, e)
+	assert.Equal(t, util.AddressFromString("20.0.0.1"), e.Reply.Src)
+	assert.Equal(t, TCPStateEstablished, e.TCPState)
+
+	ctr.DeleteTranslation(conn)
+	assert.Nil(t, ctr.GetTranslationForConn(conn))
+	assert.Nil(t, ctr.GetTranslationForConn(reply))
+	assert.Equal(t, TCPStateNone, ctr.GetTCPStateForConn(conn))
+
+	stats := ctr.GetStats()
+	assert.Equal(t, int64(0), stats.StateSize)
+	assert.Equal(t, int64(1), stats.Extra["gets_failed"])
+	assert.Equal(t, stats.Gets-3, stats.GetHits)
+	assert.Equal(t, int64(3), stats.Map()["gets_misses"])
+	assert.Equal(t, int64(1), stats.Unregisters)
+}
+
+func TestFakeConntrackerNATGateways(t *testing.T) {
+	ctr := NewFakeConntracker()
+	conn := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("8.8.8.8"),
+		DPort:  53,
+		Type:   network.UDP,
+	}
+	require.True(t, ctr.AddTranslation(conn, &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("8.8.8.8"),
+		ReplDstIP:   util.AddressFromString("192.168.1.1"),
+		ReplSrcPort: 53,
+		ReplDstPort: 61000,
+	}))
+	assert.Equal(t, []util.Address{util.AddressFromString("192.168.1.1")}, ctr.GetNATGateways())
+
+	ctr.DeleteTranslation(conn)
+	assert.Empty(t, ctr.GetNATGateways())
+}
+
+func TestFakeConntrackerMaxSize(t *testing.T) {
+	ctr := NewFakeConntracker()
+	ctr.SetMaxSize(2)
+
+	conn := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("50.30.40.10"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+	trans := &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("20.0.0.1"),
+		ReplDstIP:   util.AddressFromString("10.0.0.1"),
+		ReplSrcPort: 80,
+		ReplDstPort: 12345,
+	}
+	assert.True(t, ctr.AddTranslation(conn, trans))
+
+	conn.SPort = 12346
+	assert.False(t, ctr.AddTranslation(conn, trans))
+	assert.Nil(t, ctr.GetTranslationForConn(conn))
+	assert.Equal(t, int64(1), ctr.GetStats().RegistersDropped)
+}
+
+func TestFakeConntrackerLoadTable(t *testing.T) {
+	ctr := NewFakeConntracker()
+	require.NoError(t, ctr.LoadTable([]network.DebugConntrackEntry{{
+		Proto:  "UDP",
+		Origin: network.DebugConntrackTuple{Src: "10.0.0.1", SPort: 5353, Dst: "10.96.0.10", DPort: 53},
+		Reply:  network.DebugConntrackTuple{Src: "10.244.0.3", SPort: 53, Dst: "10.0.0.1", DPort: 5353},
+	}}))
+
+	conn := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  5353,
+		Dest:   util.AddressFromString("10.96.0.10"),
+		DPort:  53,
+		Type:   network.UDP,
+	}
+	assert.Equal(t, util.AddressFromString("10.244.0.3"), ctr.GetTranslationForConn(conn).ReplSrcIP)
+	assert.Equal(t, []network.DebugConntrackEntry{{
+		Proto:  "UDP",
+		Origin: network.DebugConntrackTuple{Src: "10.0.0.1", SPort: 5353, Dst: "10.96.0.10", DPort: 53},
+		Reply:  network.DebugConntrackTuple{Src: "10.244.0.3", SPort: 53, Dst: "10.0.0.1", DPort: 5353},
+	}}, ctr.DumpCachedTable())
+
+	assert.Error(t, ctr.LoadTable([]network.DebugConntrackEntry{{Proto: "ICMP"}}))
+}
+
+func TestFakeConntrackerDelay(t *testing.T) {
+	ctr := NewFakeConntracker()
+	ctr.SetDelay(10 * time.Millisecond)
+
+	start := time.Now()
+	ctr.GetTranslationForConn(network.ConnectionStats{})
+	assert.True(t, time.Since(start) >= 10*time.Millisecond)
+}
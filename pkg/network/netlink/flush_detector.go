@@ -0,0 +1,78 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"time"
+)
+
+const (
+	// flushWindow is the window the entries destroyed are counted over to detect a flush of the conntrack table
+	flushWindow = time.Second
+
+	// minFlushEntries is the minimum number of entries a flush empties, so the small tables emptied by the regular
+	// churn of their connections aren't mistaken for flushed ones
+	minFlushEntries = 200
+
+	// flushCheckInterval is the interval at which nf_conntrack_count is checked for a reset
+	flushCheckInterval = 10 * time.Second
+)
+
+// flushDetector detects the flushes of the kernel conntrack table (eg. `conntrack -F`, or a CNI restart), after
+// which the whole cache is stale as soon as some of their DESTROY events are lost. A flush shows either as a burst
+// of DESTROY events removing half of the state map within a second, or as nf_conntrack_count dropping below a
+// tenth of its last value. A nil *flushDetector detects nothing.
+type flushDetector struct {
+	// countPath is the path of nf_conntrack_count, empty when the count isn't checked. lastCount is only accessed
+	// by the goroutine checking the count.
+	countPath string
+	lastCount int64
+
+	// the window the entries destroyed are counted over, which must be accessed with the lock of the conntracker
+	// held. windowSize is the size of the state map when the window started, windowFlushed is set once a flush
+	// was detected in the window, so it is only reported once.
+	windowStart     time.Time
+	windowSize      int
+	windowDestroyed int
+	windowFlushed   bool
+}
+
+// newFlushDetector returns a detector checking the nf_conntrack_count file of the given path, if any
+func newFlushDetector(countPath string) *flushDetector {
+	return &flushDetector{countPath: countPath}
+}
+
+// destroyed counts the given number of entries removed from the state map by a DESTROY event, and returns whether
+// the entries destroyed amount to a flush. size is the size of the state map before they were removed.
+func (d *flushDetector) destroyed(now time.Time, size, removed int) bool {
+	if d == nil || removed == 0 {
+		return false
+	}
+	if now.Sub(d.windowStart) >= flushWindow {
+		d.windowStart = now
+		d.windowSize = size
+		d.windowDestroyed = 0
+		d.windowFlushed = false
+	}
+	d.windowDestroyed += removed
+	if d.windowFlushed || d.windowDestroyed < minFlushEntries || 2*d.windowDestroyed < d.windowSize {
+		return false
+	}
+	d.windowFlushed = true
+	return true
+}
+
+// countReset reads nf_conntrack_count, and returns whether it dropped below a tenth of its last value
+func (d *flushDetector) countReset() bool {
+	if d == nil || d.countPath == "" {
+		return false
+	}
+	count, err := readIntFile(d.countPath)
+	if err != nil {
+		return false
+	}
+	last := d.lastCount
+	d.lastCount = count
+	return last >= minFlushEntries && 10*count < last
+}
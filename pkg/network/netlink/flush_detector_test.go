@@ -0,0 +1,74 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlushDetectorDestroys(t *testing.T) {
+	var nilDetector *flushDetector
+	assert.False(t, nilDetector.destroyed(time.Now(), 1000, 2))
+
+	d := newFlushDetector("")
+	now := time.Unix(1600000000, 0)
+
+	// the regular churn of the entries isn't a flush
+	for i := 0; i < 100; i++ {
+		assert.False(t, d.destroyed(now.Add(time.Duration(i)*100*time.Millisecond), 1000-2*i, 2))
+	}
+
+	// half of the state map destroyed within the window is
+	now = now.Add(time.Minute)
+	var flushes int
+	for i := 0; i < 300; i++ {
+		if d.destroyed(now, 1000-2*i, 2) {
+			flushes++
+			assert.Equal(t, 249, i)
+		}
+	}
+	// it is only reported once per window
+	assert.Equal(t, 1, flushes)
+
+	// small tables emptied aren't flushed
+	now = now.Add(time.Minute)
+	for i := 0; i < 50; i++ {
+		assert.False(t, d.destroyed(now, 100-2*i, 2))
+	}
+}
+
+func TestFlushDetectorCountReset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flush")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nf_conntrack_count")
+	d := newFlushDetector(path)
+
+	// the count can't be read
+	assert.False(t, d.countReset())
+
+	for _, c := range []struct {
+		count string
+		reset bool
+	}{
+		{"5000\n", false},
+		{"4000\n", false},
+		{"300\n", true},
+		// a small table emptied isn't a reset
+		{"150\n", false},
+		{"0\n", false},
+	} {
+		require.NoError(t, ioutil.WriteFile(path, []byte(c.count), 0644))
+		assert.Equal(t, c.reset, d.countReset(), c.count)
+	}
+
+	assert.False(t, newFlushDetector("").countReset())
+}
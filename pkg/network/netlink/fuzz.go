@@ -0,0 +1,88 @@
+// +build gofuzz
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/mdlayher/netlink"
+)
+
+// Fuzz targets of the decoding of the ctnetlink messages, for go-fuzz (https://github.com/dvyukov/go-fuzz):
+//
+//   go-fuzz-build -func FuzzDecode github.com/DataDog/datadog-agent/pkg/network/netlink
+//   go-fuzz -bin netlink-fuzz.zip -workdir fuzz/decode
+//
+// The messages read from the kernel are never trusted to be well-formed, so none of the targets may panic.
+
+// maxFuzzNesting bounds the nesting levels FuzzAttributeScanner walks through
+const maxFuzzNesting = 4
+
+// fuzzConntracker registers the entries decoded by FuzzDecode. Entries are unregistered right away, so its state
+// stays empty from one input to the next.
+var fuzzConntracker = &realConntracker{
+	clock:                realClock{},
+	state:                newConnTable(0),
+	tcpStates:            make(map[connKey]TCPState),
+	counters:             make(map[connKey]Counters),
+	startTimes:           make(map[connKey]uint64),
+	ids:                  make(map[connKey]uint32),
+	maxStateSize:         1024,
+	exceededSizeLogLimit: util.NewLogLimit(1, time.Minute),
+}
+
+// FuzzDecode decodes a conntrack message, and registers the entry decoded the same way events are
+func FuzzDecode(data []byte) int {
+	ctr := fuzzConntracker
+	decoded := 0
+	ctr.newDecoder().DecodeAndReleaseEvent(Event{msgs: []netlink.Message{{Data: data}}}, func(c *Con) {
+		decoded++
+		_ = c.String()
+		ctr.register(*c)
+		ctr.unregister(*c)
+	})
+
+	if decoded == 0 {
+		return 0
+	}
+	return 1
+}
+
+// FuzzDecodeExpectation decodes a conntrack expectation message
+func FuzzDecodeExpectation(data []byte) int {
+	e, ok := decodeExpectation(NewAttributeScanner(), data, time.Now())
+	if !ok {
+		return 0
+	}
+	_ = e.translationFor(e.tuple)
+	return 1
+}
+
+// FuzzAttributeScanner walks through all the attributes of a message, entering every attribute as a nested one
+func FuzzAttributeScanner(data []byte) int {
+	s := NewAttributeScanner()
+	if err := s.ResetTo(data); err != nil {
+		return 0
+	}
+	scanAll(s, 0)
+	if s.Err() != nil {
+		return 0
+	}
+	return 1
+}
+
+func scanAll(s *AttributeScanner, level int) {
+	for s.Next() {
+		_ = s.Type()
+		_ = s.Bytes()
+		if level < maxFuzzNesting {
+			s.Nested(func() error {
+				scanAll(s, level+1)
+				return nil
+			})
+		}
+	}
+}
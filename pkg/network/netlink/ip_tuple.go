@@ -0,0 +1,162 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"golang.org/x/sys/unix"
+)
+
+// IPTuple is one direction of a conntrack entry. Unlike the tuples of go-conntrack, it holds its fields by value:
+// the entries are decoded straight into it without allocating, and they don't point to the memory of the decoder,
+// so they can be copied and kept around freely. The zero IPTuple stands for a missing tuple.
+type IPTuple struct {
+	src, dst         keyAddr
+	srcPort, dstPort uint16
+	proto            uint8
+	// fields are the fields set, since the ports are missing for the protocols without ports (eg. ICMP), and
+	// malformed attributes are skipped
+	fields tupleFields
+}
+
+// tupleFields flags the fields set in an IPTuple
+type tupleFields uint8
+
+const (
+	tupleSrc tupleFields = 1 << iota
+	tupleDst
+	tupleProto
+	tupleSrcPort
+	tupleDstPort
+	// tupleIPv6 is set for the tuples of the IPv6 table, whose addresses may be IPv4-mapped ones
+	tupleIPv6
+
+	tupleComplete = tupleSrc | tupleDst | tupleProto | tupleSrcPort | tupleDstPort
+)
+
+// NewIPTuple returns the tuple of a connection of the given protocol number between two endpoints
+func NewIPTuple(src, dst net.IP, srcPort, dstPort uint16, proto uint8) IPTuple {
+	t := IPTuple{
+		src:     keyAddrFromNetIP(src),
+		dst:     keyAddrFromNetIP(dst),
+		srcPort: srcPort,
+		dstPort: dstPort,
+		proto:   proto,
+		fields:  tupleComplete,
+	}
+	if src.To4() == nil {
+		t.fields |= tupleIPv6
+	}
+	return t
+}
+
+// keyTuple returns the tuple of the connection of the given key, whose transport protocol must be TCP or UDP
+func keyTuple(k connKey) IPTuple {
+	proto := uint8(unix.IPPROTO_TCP)
+	if k.transport == network.UDP {
+		proto = unix.IPPROTO_UDP
+	}
+	t := IPTuple{
+		src:     k.srcIP,
+		dst:     k.dstIP,
+		srcPort: k.srcPort,
+		dstPort: k.dstPort,
+		proto:   proto,
+		fields:  tupleComplete,
+	}
+	if !k.srcIP.isIPv4() {
+		t.fields |= tupleIPv6
+	}
+	return t
+}
+
+// keyAddrFromBytes returns the key address of the 4 or 16 bytes of an address attribute, without allocating
+func keyAddrFromBytes(b []byte) keyAddr {
+	var a keyAddr
+	if len(b) == net.IPv4len {
+		a[10], a[11] = 0xff, 0xff
+		copy(a[12:], b)
+		return a
+	}
+	copy(a[:], b)
+	return a
+}
+
+// Src returns the source address of the tuple, nil when it is missing
+func (t IPTuple) Src() net.IP {
+	return t.netIP(t.src, tupleSrc)
+}
+
+// Dst returns the destination address of the tuple, nil when it is missing
+func (t IPTuple) Dst() net.IP {
+	return t.netIP(t.dst, tupleDst)
+}
+
+func (t IPTuple) netIP(a keyAddr, field tupleFields) net.IP {
+	if t.fields&field == 0 {
+		return nil
+	}
+	ip := net.IP(append([]byte(nil), a[:]...))
+	if t.isIPv4() {
+		return ip.To4()
+	}
+	return ip
+}
+
+// SrcPort returns the source port of the tuple, 0 when it is missing
+func (t IPTuple) SrcPort() uint16 {
+	return t.srcPort
+}
+
+// DstPort returns the destination port of the tuple, 0 when it is missing
+func (t IPTuple) DstPort() uint16 {
+	return t.dstPort
+}
+
+// Proto returns the protocol number of the tuple, 0 when it is missing
+func (t IPTuple) Proto() uint8 {
+	return t.proto
+}
+
+// IsZero returns whether the tuple is missing
+func (t IPTuple) IsZero() bool {
+	return t.fields == 0
+}
+
+// isIPv4 returns whether the tuple belongs to the IPv4 table
+func (t IPTuple) isIPv4() bool {
+	return t.fields&tupleIPv6 == 0
+}
+
+func (t IPTuple) hasAddresses() bool {
+	return t.fields&(tupleSrc|tupleDst) == tupleSrc|tupleDst
+}
+
+func (t IPTuple) hasPorts() bool {
+	return t.fields&(tupleSrcPort|tupleDstPort) == tupleSrcPort|tupleDstPort
+}
+
+// hasProtocol returns whether the tuple has a protocol number, or no ports at all
+func (t IPTuple) hasProtocol() bool {
+	return t.fields&tupleProto != 0 || t.fields&(tupleSrcPort|tupleDstPort) == 0
+}
+
+func (t IPTuple) isComplete() bool {
+	return t.fields&tupleComplete == tupleComplete
+}
+
+// String formats the tuple, whose ports are missing for the protocols without ports (eg. ICMP)
+func (t IPTuple) String() string {
+	var sport, dport interface{}
+	if t.fields&tupleSrcPort != 0 {
+		sport = t.srcPort
+	}
+	if t.fields&tupleDstPort != 0 {
+		dport = t.dstPort
+	}
+	return fmt.Sprintf("src=%s dst=%s sport=%v dport=%v", t.Src(), t.Dst(), sport, dport)
+}
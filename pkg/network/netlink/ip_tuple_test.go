@@ -0,0 +1,41 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestIPTuple(t *testing.T) {
+	tuple := NewIPTuple(net.ParseIP("10.0.2.15"), net.ParseIP("2.2.2.2"), 58472, 5432, unix.IPPROTO_TCP)
+	assert.Equal(t, net.IPv4len, len(tuple.Src()))
+	assert.Equal(t, "10.0.2.15", tuple.Src().String())
+	assert.Equal(t, "2.2.2.2", tuple.Dst().String())
+	assert.Equal(t, uint16(58472), tuple.SrcPort())
+	assert.Equal(t, uint16(5432), tuple.DstPort())
+	assert.Equal(t, uint8(unix.IPPROTO_TCP), tuple.Proto())
+	assert.True(t, tuple.isIPv4())
+	assert.True(t, tuple.isComplete())
+	assert.False(t, tuple.IsZero())
+	assert.Equal(t, "src=10.0.2.15 dst=2.2.2.2 sport=58472 dport=5432", tuple.String())
+
+	tuple = NewIPTuple(net.ParseIP("fd00::2"), net.ParseIP("fd00::1"), 58472, 53, unix.IPPROTO_UDP)
+	assert.False(t, tuple.isIPv4())
+	assert.Equal(t, net.IPv6len, len(tuple.Dst()))
+
+	var missing IPTuple
+	assert.True(t, missing.IsZero())
+	assert.Nil(t, missing.Src())
+	assert.Nil(t, missing.Dst())
+	assert.Equal(t, "src=<nil> dst=<nil> sport=<nil> dport=<nil>", missing.String())
+}
+
+func TestKeyAddrFromBytes(t *testing.T) {
+	assert.Equal(t, keyAddrFromString("10.0.2.15"), keyAddrFromBytes([]byte{10, 0, 2, 15}))
+	assert.Equal(t, keyAddrFromString("fd00::1"), keyAddrFromBytes(net.ParseIP("fd00::1")))
+}
@@ -0,0 +1,383 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// ipvsPollInterval is the interval at which the IPVS connection table is read
+const ipvsPollInterval = 10 * time.Second
+
+// endpoints of an IPVS connection, in the order they appear in the connection table
+const (
+	ipvsClient = iota
+	ipvsVirtual
+	ipvsDest
+)
+
+// ipvsConntracker resolves the translations done by IPVS, which is used by kube-proxy in IPVS mode to
+// load-balance service VIPs to pods. IPVS connections are only partially reflected in the conntrack table,
+// so the IPVS connection table is polled and used when the wrapped Conntracker doesn't know about a connection.
+// The IPVS generic netlink family only exposes services and destinations, not connections, so the table
+// is read from /proc/net/ip_vs_conn instead.
+type ipvsConntracker struct {
+	sync.RWMutex
+	conntracker Conntracker
+
+	state map[connKey]*network.IPTranslation
+
+	connTablePath string
+	maxStateSize  int
+
+	pollTicker *time.Ticker
+	exit       chan struct{}
+
+	stats struct {
+		hits          int64
+		polls         int64
+		pollErrors    int64
+		pollTimeTotal int64
+		connsDropped  int64
+	}
+}
+
+// NewIPVSConntracker returns a Conntracker resolving IPVS translations, falling back to the given
+// Conntracker for connections which aren't load-balanced by IPVS
+func NewIPVSConntracker(ctr Conntracker, cfg *Config) (Conntracker, error) {
+	ipvs := &ipvsConntracker{
+		conntracker: ctr,
+		state:       make(map[connKey]*network.IPTranslation),
+		// IPVS runs in the root network namespace on kube-proxy nodes
+		connTablePath: filepath.Join(cfg.ProcRoot, "1/net/ip_vs_conn"),
+		maxStateSize:  cfg.MaxStateSize,
+		exit:          make(chan struct{}),
+	}
+
+	if err := ipvs.poll(); err != nil {
+		return nil, fmt.Errorf("could not read the IPVS connection table, is the ip_vs kernel module loaded? %w", err)
+	}
+
+	ipvs.pollTicker = time.NewTicker(ipvsPollInterval)
+	go ipvs.run()
+
+	log.Infof("initialized IPVS conntracker")
+	return ipvs, nil
+}
+
+func (ipvs *ipvsConntracker) GetTranslationForConn(c network.ConnectionStats) *network.IPTranslation {
+	if t := ipvs.conntracker.GetTranslationForConn(c); t != nil {
+		return t
+	}
+
+	ipvs.RLock()
+	defer ipvs.RUnlock()
+
+	t := ipvs.state[connStatsToConnKey(c)]
+	if t != nil {
+		atomic.AddInt64(&ipvs.stats.hits, 1)
+	}
+	return t
+}
+
+// GetTranslationsForConns returns the translations of the given connections, using the IPVS
+// translations for the connections the wrapped Conntracker doesn't know about
+func (ipvs *ipvsConntracker) GetTranslationsForConns(conns []network.ConnectionStats) []*network.IPTranslation {
+	translations := ipvs.conntracker.GetTranslationsForConns(conns)
+
+	ipvs.RLock()
+	defer ipvs.RUnlock()
+
+	for i := range conns {
+		if translations[i] != nil {
+			continue
+		}
+		if t := ipvs.state[connStatsToConnKey(conns[i])]; t != nil {
+			translations[i] = t
+			atomic.AddInt64(&ipvs.stats.hits, 1)
+		}
+	}
+	return translations
+}
+
+// GetEntryForConn returns the entry of the wrapped Conntracker, or else the entry of the IPVS translation
+func (ipvs *ipvsConntracker) GetEntryForConn(c network.ConnectionStats) *ConntrackEntry {
+	if e := ipvs.conntracker.GetEntryForConn(c); e != nil {
+		return e
+	}
+
+	ipvs.RLock()
+	defer ipvs.RUnlock()
+
+	t := ipvs.state[connStatsToConnKey(c)]
+	if t == nil {
+		return nil
+	}
+	atomic.AddInt64(&ipvs.stats.hits, 1)
+	return newConntrackEntry(c, t)
+}
+
+// DeleteTranslation removes the translation of a closed connection from both caches
+func (ipvs *ipvsConntracker) DeleteTranslation(c network.ConnectionStats) {
+	ipvs.conntracker.DeleteTranslation(c)
+
+	ipvs.Lock()
+	defer ipvs.Unlock()
+	ipvs.deleteTranslation(c)
+}
+
+// DeleteTranslations removes the translations of the given connections from both caches
+func (ipvs *ipvsConntracker) DeleteTranslations(conns []network.ConnectionStats) {
+	ipvs.conntracker.DeleteTranslations(conns)
+
+	ipvs.Lock()
+	defer ipvs.Unlock()
+	for i := range conns {
+		ipvs.deleteTranslation(conns[i])
+	}
+}
+
+// deleteTranslation must be called with the write lock held
+func (ipvs *ipvsConntracker) deleteTranslation(c network.ConnectionStats) {
+	k := connStatsToConnKey(c)
+	for _, key := range []connKey{k, {srcIP: k.dstIP, srcPort: k.dstPort, dstIP: k.srcIP, dstPort: k.srcPort, transport: k.transport}} {
+		if t, ok := ipvs.state[key]; ok {
+			delete(ipvs.state, ipTranslationToConnKey(key.transport, t))
+			delete(ipvs.state, key)
+			return
+		}
+	}
+}
+
+func (ipvs *ipvsConntracker) GetStats() Stats {
+	ipvs.RLock()
+	size := len(ipvs.state)
+	ipvs.RUnlock()
+
+	s := ipvs.conntracker.GetStats()
+	polls := atomic.LoadInt64(&ipvs.stats.polls)
+	s.set("ipvs_state_size", int64(size))
+	s.set("ipvs_hits_total", atomic.LoadInt64(&ipvs.stats.hits))
+	s.set("ipvs_polls_total", polls)
+	s.set("ipvs_poll_errors_total", atomic.LoadInt64(&ipvs.stats.pollErrors))
+	s.set("ipvs_conns_dropped", atomic.LoadInt64(&ipvs.stats.connsDropped))
+	if polls != 0 {
+		s.set("ipvs_nanoseconds_per_poll", atomic.LoadInt64(&ipvs.stats.pollTimeTotal)/polls)
+	}
+	return s
+}
+
+// DumpCachedTable returns the translations held by the wrapped Conntracker, followed by the IPVS ones
+func (ipvs *ipvsConntracker) DumpCachedTable() []network.DebugConntrackEntry {
+	entries := ipvs.conntracker.DumpCachedTable()
+
+	ipvs.RLock()
+	defer ipvs.RUnlock()
+	for k, t := range ipvs.state {
+		entries = append(entries, debugConntrackEntry(k, t))
+	}
+	return entries
+}
+
+// Subscribe only notifies about the translations of the wrapped Conntracker, the IPVS connection table is polled
+func (ipvs *ipvsConntracker) Subscribe(filter TranslationFilter) (<-chan TranslationEvent, func()) {
+	return ipvs.conntracker.Subscribe(filter)
+}
+
+func (ipvs *ipvsConntracker) GetTCPStateForConn(c network.ConnectionStats) TCPState {
+	return ipvs.conntracker.GetTCPStateForConn(c)
+}
+
+func (ipvs *ipvsConntracker) GetTCPStatesForConns(conns []network.ConnectionStats) []TCPState {
+	return ipvs.conntracker.GetTCPStatesForConns(conns)
+}
+
+func (ipvs *ipvsConntracker) GetCountersForConn(c network.ConnectionStats) (Counters, bool) {
+	return ipvs.conntracker.GetCountersForConn(c)
+}
+
+func (ipvs *ipvsConntracker) GetStartTimeForConn(c network.ConnectionStats) (time.Time, bool) {
+	return ipvs.conntracker.GetStartTimeForConn(c)
+}
+
+func (ipvs *ipvsConntracker) GetStatusForConn(c network.ConnectionStats) Status {
+	return ipvs.conntracker.GetStatusForConn(c)
+}
+
+// GetNATGateways returns the gateways of the wrapped Conntracker, since IPVS only translates the destination
+// of the connections it balances
+func (ipvs *ipvsConntracker) GetNATGateways() []util.Address {
+	return ipvs.conntracker.GetNATGateways()
+}
+
+func (ipvs *ipvsConntracker) GetRecentErrors() []string {
+	return ipvs.conntracker.GetRecentErrors()
+}
+
+// Refresh refreshes the wrapped Conntracker, along with the IPVS connections of all families
+func (ipvs *ipvsConntracker) Refresh(family uint8) error {
+	if err := ipvs.conntracker.Refresh(family); err != nil {
+		return err
+	}
+	return ipvs.poll()
+}
+
+// SetTargetRateLimit changes the rate limit of the underlying conntracker, since IPVS connections are polled
+func (ipvs *ipvsConntracker) SetTargetRateLimit(limit int) {
+	ipvs.conntracker.SetTargetRateLimit(limit)
+}
+
+func (ipvs *ipvsConntracker) Describe() network.ConntrackDescription {
+	return withLayer(ipvs.conntracker.Describe(), "ipvs")
+}
+
+func (ipvs *ipvsConntracker) Close() {
+	ipvs.pollTicker.Stop()
+	close(ipvs.exit)
+	ipvs.conntracker.Close()
+}
+
+func (ipvs *ipvsConntracker) run() {
+	for {
+		select {
+		case <-ipvs.pollTicker.C:
+			if err := ipvs.poll(); err != nil {
+				log.Warnf("could not read the IPVS connection table: %s", err)
+			}
+		case <-ipvs.exit:
+			return
+		}
+	}
+}
+
+// poll reads the whole IPVS connection table and replaces the cache with its content
+func (ipvs *ipvsConntracker) poll() error {
+	then := time.Now()
+	defer func() {
+		atomic.AddInt64(&ipvs.stats.polls, 1)
+		atomic.AddInt64(&ipvs.stats.pollTimeTotal, time.Since(then).Nanoseconds())
+	}()
+
+	f, err := os.Open(ipvs.connTablePath)
+	if err != nil {
+		atomic.AddInt64(&ipvs.stats.pollErrors, 1)
+		return err
+	}
+	defer f.Close()
+
+	state := make(map[connKey]*network.IPTranslation)
+	err = readIPVSConns(f, func(origin, reply connKey) {
+		if len(state) >= ipvs.maxStateSize {
+			atomic.AddInt64(&ipvs.stats.connsDropped, 1)
+			return
+		}
+
+		state[origin] = replyTranslation(origin, reply)
+		state[reply] = replyTranslation(reply, origin)
+	})
+	if err != nil {
+		atomic.AddInt64(&ipvs.stats.pollErrors, 1)
+		return err
+	}
+
+	ipvs.Lock()
+	ipvs.state = state
+	ipvs.Unlock()
+	return nil
+}
+
+// readIPVSConns parses the content of /proc/net/ip_vs_conn, and calls fn with the origin and reply tuples of
+// each translated connection, the same way they are reported by conntrack: the origin tuple goes from the
+// client to the virtual service, and the reply tuple from the real server back to the client.
+//
+// Pro FromIP   FPrt ToIP     TPrt DestIP   DPrt State       Expires PEName PEData
+// TCP 0A000001 C350 0A600001 01BB 0AF40105 1F90 ESTABLISHED     899
+func readIPVSConns(r io.Reader, fn func(origin, reply connKey)) error {
+	scanner := bufio.NewScanner(r)
+	// skip the header
+	scanner.Scan()
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+
+		var transport network.ConnectionType
+		switch fields[0] {
+		case "TCP":
+			transport = network.TCP
+		case "UDP":
+			transport = network.UDP
+		default:
+			continue
+		}
+
+		var addrs [3]util.Address
+		var ports [3]uint16
+		ok := true
+		for i := range addrs {
+			addrs[i], ok = parseIPVSAddress(fields[2*i+1])
+			if !ok {
+				break
+			}
+			port, err := strconv.ParseUint(fields[2*i+2], 16, 16)
+			if err != nil {
+				ok = false
+				break
+			}
+			ports[i] = uint16(port)
+		}
+		if !ok {
+			continue
+		}
+
+		// no translation happens when the virtual service is served locally
+		if addrs[ipvsVirtual] == addrs[ipvsDest] && ports[ipvsVirtual] == ports[ipvsDest] {
+			continue
+		}
+
+		fn(
+			connKey{srcIP: newKeyAddr(addrs[ipvsClient]), srcPort: ports[ipvsClient], dstIP: newKeyAddr(addrs[ipvsVirtual]), dstPort: ports[ipvsVirtual], transport: transport},
+			connKey{srcIP: newKeyAddr(addrs[ipvsDest]), srcPort: ports[ipvsDest], dstIP: newKeyAddr(addrs[ipvsClient]), dstPort: ports[ipvsClient], transport: transport},
+		)
+	}
+
+	return scanner.Err()
+}
+
+// parseIPVSAddress parses an address of the IPVS connection table, which is either the hexadecimal
+// representation of an IPv4 address in network byte order, or the expanded form of an IPv6 address
+func parseIPVSAddress(s string) (util.Address, bool) {
+	if strings.Contains(s, ":") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, false
+		}
+		return util.AddressFromNetIP(ip), true
+	}
+
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil, false
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	return util.V4AddressFromBytes(b[:]), true
+}
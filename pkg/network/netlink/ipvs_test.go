@@ -0,0 +1,90 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const ipvsConnTable = `Pro FromIP   FPrt ToIP     TPrt DestIP   DPrt State       Expires PEName PEData
+TCP 0A000001 C350 0A600001 01BB 0AF40105 1F90 ESTABLISHED     899
+UDP 0A000002 D431 0A60000A 0035 0AF40203 0035 UDP             299
+TCP 0A000003 C351 0A000004 0050 0A000004 0050 ESTABLISHED     899
+SCTP 0A000003 C351 0A600001 0050 0AF40105 0050 ESTABLISHED     899
+TCP FD00:0000:0000:0000:0000:0000:0000:0001 C350 FD00:0000:0000:0000:0000:0000:0001:0001 01BB FD00:0000:0000:0000:0000:0000:0002:0005 1F90 ESTABLISHED     899
+`
+
+func TestReadIPVSConns(t *testing.T) {
+	type conn struct{ origin, reply connKey }
+	var conns []conn
+	err := readIPVSConns(strings.NewReader(ipvsConnTable), func(origin, reply connKey) {
+		conns = append(conns, conn{origin, reply})
+	})
+	require.NoError(t, err)
+
+	// the locally served connection and the SCTP one are skipped
+	require.Len(t, conns, 3)
+	assert.Equal(t, conn{
+		origin: connKey{srcIP: keyAddrFromString("10.0.0.1"), srcPort: 50000, dstIP: keyAddrFromString("10.96.0.1"), dstPort: 443, transport: network.TCP},
+		reply:  connKey{srcIP: keyAddrFromString("10.244.1.5"), srcPort: 8080, dstIP: keyAddrFromString("10.0.0.1"), dstPort: 50000, transport: network.TCP},
+	}, conns[0])
+	assert.Equal(t, conn{
+		origin: connKey{srcIP: keyAddrFromString("10.0.0.2"), srcPort: 54321, dstIP: keyAddrFromString("10.96.0.10"), dstPort: 53, transport: network.UDP},
+		reply:  connKey{srcIP: keyAddrFromString("10.244.2.3"), srcPort: 53, dstIP: keyAddrFromString("10.0.0.2"), dstPort: 54321, transport: network.UDP},
+	}, conns[1])
+	assert.Equal(t, conn{
+		origin: connKey{srcIP: keyAddrFromString("fd00::1"), srcPort: 50000, dstIP: keyAddrFromString("fd00::1:1"), dstPort: 443, transport: network.TCP},
+		reply:  connKey{srcIP: keyAddrFromString("fd00::2:5"), srcPort: 8080, dstIP: keyAddrFromString("fd00::1"), dstPort: 50000, transport: network.TCP},
+	}, conns[2])
+}
+
+func TestIPVSConntracker(t *testing.T) {
+	procRoot, err := ioutil.TempDir("", "proc")
+	require.NoError(t, err)
+	defer os.RemoveAll(procRoot)
+
+	cfg := &Config{ProcRoot: procRoot, MaxStateSize: 100}
+
+	// the IPVS connection table doesn't exist when ip_vs isn't loaded
+	_, err = NewIPVSConntracker(NewNoOpConntracker(), cfg)
+	assert.Error(t, err)
+
+	path := filepath.Join(procRoot, "1/net/ip_vs_conn")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte(ipvsConnTable), 0644))
+
+	ctr, err := NewIPVSConntracker(NewNoOpConntracker(), cfg)
+	require.NoError(t, err)
+	defer ctr.Close()
+
+	c := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  50000,
+		Dest:   util.AddressFromString("10.96.0.1"),
+		DPort:  443,
+		Type:   network.TCP,
+	}
+	assert.Equal(t, &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("10.244.1.5"),
+		ReplDstIP:   util.AddressFromString("10.0.0.1"),
+		ReplSrcPort: 8080,
+		ReplDstPort: 50000,
+		NATType:     network.DNAT,
+	}, ctr.GetTranslationForConn(c))
+	assert.Len(t, ctr.DumpCachedTable(), 6)
+	assert.EqualValues(t, 6, ctr.GetStats().Extra["ipvs_state_size"])
+
+	ctr.DeleteTranslation(c)
+	assert.Nil(t, ctr.GetTranslationForConn(c))
+	assert.EqualValues(t, 4, ctr.GetStats().Extra["ipvs_state_size"])
+}
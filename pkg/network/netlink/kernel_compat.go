@@ -0,0 +1,111 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/DataDog/datadog-agent/pkg/util/kernel"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// timestampKernelVersion is the first kernel version sending the creation time of the entries (CTA_TIMESTAMP)
+var timestampKernelVersion = kernel.VersionCode(3, 2, 0)
+
+// optionalAttributes are the attributes of the entries which only some kernels send, depending on their version,
+// on the nf_conntrack sysctls, or on the patches of vendor kernels
+var optionalAttributes = []decodedAttribute{attrCounters, attrTimestamp, attrID}
+
+// kernelCapabilities tracks which of the optional attributes the kernel sends. They are first guessed from its
+// version and sysctls, then corrected by the attributes actually decoded, so the decoder can stop scanning an entry
+// once it decoded all the attributes the kernel sends, and the ones unavailable can be reported.
+// A nil *kernelCapabilities assumes all the attributes are sent.
+type kernelCapabilities struct {
+	version kernel.Version
+	// unavailable flags the attributes the kernel doesn't send, it is accessed atomically
+	unavailable [numDecodedAttributes]int32
+}
+
+// probeKernelCapabilities guesses the attributes the kernel sends. An attribute is only assumed to be unavailable
+// when the kernel is known not to send it, so an unknown version or an unreadable sysctl assumes it is sent.
+func probeKernelCapabilities(procRoot string) *kernelCapabilities {
+	k := &kernelCapabilities{}
+	if v, err := kernel.HostVersion(); err == nil {
+		k.version = v
+	} else {
+		log.Debugf("could not get the kernel version, assuming all the conntrack attributes are available: %s", err)
+	}
+
+	// the counters are only maintained when nf_conntrack_acct is enabled
+	if acct, err := readIntFile(filepath.Join(procRoot, "sys/net/netfilter/nf_conntrack_acct")); err == nil && acct == 0 {
+		k.unavailable[attrCounters] = 1
+	}
+
+	if k.version != 0 && k.version < timestampKernelVersion {
+		k.unavailable[attrTimestamp] = 1
+	} else if tstamp, err := readIntFile(filepath.Join(procRoot, "sys/net/netfilter/nf_conntrack_timestamp")); err == nil && tstamp == 0 {
+		k.unavailable[attrTimestamp] = 1
+	}
+
+	if missing := k.unavailableAttributes(); len(missing) > 0 {
+		log.Infof("kernel %s doesn't send the following conntrack attributes: %s", k.version, strings.Join(missing, ", "))
+	}
+	return k
+}
+
+// available returns whether the kernel sends the given attribute
+func (k *kernelCapabilities) available(a decodedAttribute) bool {
+	return k == nil || atomic.LoadInt32(&k.unavailable[a]) == 0
+}
+
+// observed records the kernel sends the given attribute, when it was assumed not to, eg. because the sysctl
+// enabling it was changed since it was probed
+func (k *kernelCapabilities) observed(a decodedAttribute) {
+	if k != nil && atomic.CompareAndSwapInt32(&k.unavailable[a], 1, 0) {
+		log.Infof("kernel %s sends the %s conntrack attribute after all", k.version, decodedAttributeNames[a])
+	}
+}
+
+// expected returns the optional attributes the kernel sends, along with the number of top level attributes
+// an entry is fully decoded with: both its tuples, its protocol info, and the optional attributes sent
+func (k *kernelCapabilities) expected() (attrs [numDecodedAttributes]bool, n int) {
+	n = 3
+	for _, a := range optionalAttributes {
+		if !k.available(a) {
+			continue
+		}
+		attrs[a] = true
+		if a == attrCounters {
+			// the counters of both directions are separate attributes
+			n += 2
+			continue
+		}
+		n++
+	}
+	return attrs, n
+}
+
+// unavailableAttributes returns the names of the optional attributes the kernel doesn't send
+func (k *kernelCapabilities) unavailableAttributes() []string {
+	var names []string
+	for _, a := range optionalAttributes {
+		if !k.available(a) {
+			names = append(names, decodedAttributeNames[a])
+		}
+	}
+	return names
+}
+
+func (k *kernelCapabilities) getStats() map[string]int64 {
+	if k == nil {
+		return nil
+	}
+	m := make(map[string]int64, len(optionalAttributes))
+	for _, a := range optionalAttributes {
+		m["kernel_attr_unavailable_"+decodedAttributeNames[a]] = int64(atomic.LoadInt32(&k.unavailable[a]))
+	}
+	return m
+}
@@ -0,0 +1,101 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestProbeKernelCapabilities(t *testing.T) {
+	procRoot, err := ioutil.TempDir("", "proc")
+	require.NoError(t, err)
+	defer os.RemoveAll(procRoot)
+
+	sysctls := filepath.Join(procRoot, "sys/net/netfilter")
+	require.NoError(t, os.MkdirAll(sysctls, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sysctls, "nf_conntrack_acct"), []byte("0\n"), 0644))
+
+	caps := probeKernelCapabilities(procRoot)
+	assert.False(t, caps.available(attrCounters))
+	assert.True(t, caps.available(attrID))
+	assert.Equal(t, int64(1), caps.getStats()["kernel_attr_unavailable_counters"])
+	assert.Equal(t, int64(0), caps.getStats()["kernel_attr_unavailable_id"])
+}
+
+func TestDecodeWithKernelCapabilities(t *testing.T) {
+	caps := &kernelCapabilities{}
+	caps.unavailable[attrCounters] = 1
+	caps.unavailable[attrTimestamp] = 1
+	expected, n := caps.expected()
+	assert.Equal(t, 4, n)
+	assert.True(t, expected[attrID])
+	assert.Equal(t, []string{"counters", "timestamp"}, caps.unavailableAttributes())
+
+	tuples, err := EncodeConn(&Con{
+		Origin: newIPTuple("10.0.2.15", "2.2.2.2", 58472, 5432, uint8(unix.IPPROTO_TCP)),
+		Reply:  newIPTuple("1.1.1.1", "10.0.2.15", 5432, 58472, uint8(unix.IPPROTO_TCP)),
+	})
+	require.NoError(t, err)
+	encode := func(fn func(ae *netlink.AttributeEncoder)) []byte {
+		ae := netlink.NewAttributeEncoder()
+		ae.ByteOrder = binary.BigEndian
+		fn(ae)
+		b, err := ae.Encode()
+		require.NoError(t, err)
+		return b
+	}
+	protoInfo := encode(func(ae *netlink.AttributeEncoder) {
+		ae.Nested(ctaProtoInfo, func(nae *netlink.AttributeEncoder) error {
+			nae.Nested(ctaProtoInfoTCP, func(tae *netlink.AttributeEncoder) error {
+				tae.Uint8(ctaProtoInfoTCPState, uint8(TCPStateEstablished))
+				return nil
+			})
+			return nil
+		})
+	})
+	id := encode(func(ae *netlink.AttributeEncoder) { ae.Uint32(ctaID, 1) })
+	timestamp := encode(func(ae *netlink.AttributeEncoder) {
+		ae.Nested(ctaTimestamp, func(nae *netlink.AttributeEncoder) error {
+			nae.Uint64(ctaTimestampStart, 1600000000000000000)
+			return nil
+		})
+	})
+
+	d := NewDecoder()
+	d.caps = caps
+	decode := func(attrs ...[]byte) Con {
+		var data []byte
+		for _, a := range attrs {
+			data = append(data, a...)
+		}
+		var decoded []Con
+		d.DecodeAndReleaseEvent(Event{msgs: []netlink.Message{{Data: data}}}, func(c *Con) {
+			decoded = append(decoded, *c)
+		})
+		require.Len(t, decoded, 1)
+		return decoded[0]
+	}
+
+	// the entry isn't scanned past the attributes the kernel is expected to send
+	c := decode(tuples, protoInfo, id, timestamp)
+	assert.Equal(t, TCPStateEstablished, c.TCPState)
+	assert.Equal(t, uint32(1), c.ID)
+	assert.Zero(t, c.StartTime)
+	assert.False(t, caps.available(attrTimestamp))
+
+	// the attributes decoded anyway are known to be sent from then on
+	c = decode(tuples, timestamp, protoInfo, id)
+	assert.Equal(t, uint64(1600000000000000000), c.StartTime)
+	assert.True(t, caps.available(attrTimestamp))
+	assert.Equal(t, []string{"counters"}, caps.unavailableAttributes())
+}
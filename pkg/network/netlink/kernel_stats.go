@@ -0,0 +1,110 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// approxStateEntrySize is a rough estimate of the memory used by each connection of the conntrack cache,
+	// including the overhead of its table and reply index (see TestConntrackerMemoryAllocation)
+	approxStateEntrySize = 160
+
+	// maxAutoStateSize caps the size of the cache when it is derived from nf_conntrack_max, so it
+	// doesn't use more than ~128MB
+	maxAutoStateSize = 128 * 1024 * 1024 / approxStateEntrySize
+)
+
+// kernelConntrackSysctls maps the stat name we report to the sysctl file (relative to procRoot) it is read from
+var kernelConntrackSysctls = map[string]string{
+	"kernel_conntrack_count": "sys/net/netfilter/nf_conntrack_count",
+	"kernel_conntrack_max":   "sys/net/netfilter/nf_conntrack_max",
+}
+
+// kernelConntrackCounters maps the stat name we report to the per-CPU counter of /proc/net/stat/nf_conntrack it is read from
+var kernelConntrackCounters = map[string]string{
+	"kernel_conntrack_insert_failed": "insert_failed",
+	"kernel_conntrack_drop":          "drop",
+	"kernel_conntrack_early_drop":    "early_drop",
+}
+
+// GetKernelConntrackStats returns the counters exposed by the kernel about its own conntrack table.
+// Counters that can't be read (eg. nf_conntrack module not loaded) are omitted.
+func GetKernelConntrackStats(procRoot string) map[string]int64 {
+	stats := make(map[string]int64, len(kernelConntrackSysctls)+len(kernelConntrackCounters))
+	for name, path := range kernelConntrackSysctls {
+		if v, err := readIntFile(filepath.Join(procRoot, path)); err == nil {
+			stats[name] = v
+		}
+	}
+
+	// The counters are read from the network namespace of pid 1, since the file is namespaced
+	counters, err := readConntrackCounters(filepath.Join(procRoot, "1/net/stat/nf_conntrack"))
+	if err != nil {
+		return stats
+	}
+	for name, counter := range kernelConntrackCounters {
+		if v, ok := counters[counter]; ok {
+			stats[name] = v
+		}
+	}
+	return stats
+}
+
+// readConntrackCounters reads the conntrack counters from /proc/net/stat/nf_conntrack, summing the values of all CPUs.
+// The first line holds the name of the counters, and each of the following lines the hex encoded values for a CPU.
+func readConntrackCounters(path string) (map[string]int64, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	names := strings.Fields(lines[0])
+	counters := make(map[string]int64, len(names))
+	for _, line := range lines[1:] {
+		for i, field := range strings.Fields(line) {
+			if i >= len(names) {
+				break
+			}
+			v, err := strconv.ParseInt(field, 16, 64)
+			if err != nil {
+				return nil, err
+			}
+			counters[names[i]] += v
+		}
+	}
+	return counters, nil
+}
+
+// autoMaxStateSize sizes the conntrack cache from the size of the kernel conntrack table, each conntrack entry being
+// cached as one connection. The given fallback is returned when nf_conntrack_max can't be read.
+func autoMaxStateSize(procRoot string, fallback int) int {
+	max, err := readIntFile(filepath.Join(procRoot, kernelConntrackSysctls["kernel_conntrack_max"]))
+	if err != nil || max <= 0 {
+		log.Warnf("could not read nf_conntrack_max, using a conntrack state size of %d: %v", fallback, err)
+		return fallback
+	}
+
+	size := max
+	if size > maxAutoStateSize {
+		size = maxAutoStateSize
+	}
+	log.Infof("using a conntrack state size of %d connections based on nf_conntrack_max=%d", size, max)
+	return int(size)
+}
+
+func readIntFile(path string) (int64, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+}
@@ -0,0 +1,59 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoMaxStateSize(t *testing.T) {
+	procRoot, err := ioutil.TempDir("", "proc")
+	require.NoError(t, err)
+	defer os.RemoveAll(procRoot)
+
+	// nf_conntrack_max can't be read
+	assert.Equal(t, 1000, autoMaxStateSize(procRoot, 1000))
+
+	path := filepath.Join(procRoot, "sys/net/netfilter/nf_conntrack_max")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("262144\n"), 0644))
+	assert.Equal(t, 262144, autoMaxStateSize(procRoot, 1000))
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("16777216\n"), 0644))
+	assert.Equal(t, maxAutoStateSize, autoMaxStateSize(procRoot, 1000))
+}
+
+func TestGetKernelConntrackStats(t *testing.T) {
+	procRoot, err := ioutil.TempDir("", "proc")
+	require.NoError(t, err)
+	defer os.RemoveAll(procRoot)
+
+	files := map[string]string{
+		"sys/net/netfilter/nf_conntrack_count": "42\n",
+		"sys/net/netfilter/nf_conntrack_max":   "262144\n",
+		"1/net/stat/nf_conntrack": "entries  clashres found new invalid ignore delete chainlength insert insert_failed drop early_drop icmp_error  expect_new expect_create expect_delete search_restart\n" +
+			"0000002a  00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000001 00000010 00000002 00000000  00000000 00000000 00000000 00000000\n" +
+			"0000002a  00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000002 00000001 00000000 00000000  00000000 00000000 00000000 00000000\n",
+	}
+	for path, content := range files {
+		path = filepath.Join(procRoot, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	assert.Equal(t, map[string]int64{
+		"kernel_conntrack_count":         42,
+		"kernel_conntrack_max":           262144,
+		"kernel_conntrack_insert_failed": 3,
+		"kernel_conntrack_drop":          17,
+		"kernel_conntrack_early_drop":    2,
+	}, GetKernelConntrackStats(procRoot))
+}
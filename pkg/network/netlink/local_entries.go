@@ -0,0 +1,122 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+)
+
+// maxLocalEntries caps the number of local entries kept apart from the state map
+const maxLocalEntries = 1024
+
+// localEntries holds the entries of the connections translated to or from loopback or link-local addresses
+// (eg. by REDIRECT rules or localhost DNAT), which are kept out of the state map when they are skipped. They are
+// never looked up, and are only reported by the debug dumps. A nil *localEntries holds nothing.
+// It must be accessed with the lock of the conntracker held.
+type localEntries struct {
+	entries map[connKey]*network.IPTranslation
+}
+
+func newLocalEntries(skip bool) *localEntries {
+	if !skip {
+		return nil
+	}
+	return &localEntries{entries: make(map[connKey]*network.IPTranslation)}
+}
+
+// isLocalCon returns whether the reply tuple of a NAT'ed entry has a loopback or link-local address
+func isLocalCon(c Con) bool {
+	for _, a := range [...]keyAddr{c.Reply.src, c.Reply.dst} {
+		if ip := net.IP(a[:]); ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			return true
+		}
+	}
+	return false
+}
+
+// add keeps both directions of the given entry, as long as the cap isn't reached. The keys of the entry are added
+// to seen, unless it is nil.
+func (l *localEntries) add(netns uint32, c *Con, seen map[connKey]struct{}) {
+	for _, tuples := range [...][2]IPTuple{{c.Origin, c.Reply}, {c.Reply, c.Origin}} {
+		k, ok := formatNSKey(netns, tuples[0])
+		if !ok {
+			continue
+		}
+		if seen != nil {
+			seen[k] = struct{}{}
+		}
+		if _, exists := l.entries[k]; !exists && len(l.entries) >= maxLocalEntries {
+			continue
+		}
+		t := formatIPTranslation(tuples[1])
+		t.NATType = natType(k, &t)
+		l.entries[k] = &t
+	}
+}
+
+// remove removes the entry of the given key along with the entry of the other direction, and returns whether
+// there was one
+func (l *localEntries) remove(k connKey) bool {
+	if l == nil {
+		return false
+	}
+	t, ok := l.entries[k]
+	if !ok {
+		return false
+	}
+	reverse := ipTranslationToConnKey(k.transport, t)
+	reverse.netns = k.netns
+	delete(l.entries, reverse)
+	delete(l.entries, k)
+	return true
+}
+
+// keys returns the keys of the entries of the given address families
+func (l *localEntries) keys(families []uint8) map[connKey]struct{} {
+	if l == nil {
+		return nil
+	}
+	keys := make(map[connKey]struct{}, len(l.entries))
+	for k, t := range l.entries {
+		if inFamilies(k, newTranslationValue(t), families) {
+			keys[k] = struct{}{}
+		}
+	}
+	return keys
+}
+
+// removeMissing removes the entries held before a dump of the conntrack table which weren't seen in the dump
+func (l *localEntries) removeMissing(before, seen map[connKey]struct{}) {
+	if l == nil {
+		return
+	}
+	for k := range before {
+		if _, ok := seen[k]; !ok {
+			delete(l.entries, k)
+		}
+	}
+}
+
+func (l *localEntries) len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.entries)
+}
+
+// dump returns the debug entries of the local entries, which are flagged as such
+func (l *localEntries) dump() []network.DebugConntrackEntry {
+	if l == nil {
+		return nil
+	}
+	entries := make([]network.DebugConntrackEntry, 0, len(l.entries))
+	for k, t := range l.entries {
+		e := debugConntrackEntry(k, t)
+		e.Local = true
+		entries = append(entries, e)
+	}
+	return entries
+}
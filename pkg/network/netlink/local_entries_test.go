@@ -0,0 +1,90 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestIsLocalCon(t *testing.T) {
+	// REDIRECT'ed to a local proxy
+	assert.True(t, isLocalCon(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("127.0.0.1"), net.ParseIP("10.96.0.1"), 6, 40000, 15001, 443)))
+	assert.True(t, isLocalCon(makeTranslatedConn(net.ParseIP("fd00::1"), net.ParseIP("::1"), net.ParseIP("fd00::2"), 6, 40000, 15001, 443)))
+	// DNAT'ed to the link-local address of a node-local DNS cache
+	assert.True(t, isLocalCon(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("169.254.20.10"), net.ParseIP("10.96.0.10"), 17, 40001, 53, 53)))
+	assert.False(t, isLocalCon(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8443, 443)))
+}
+
+func TestSkipLocalReplies(t *testing.T) {
+	rt := newConntracker()
+	rt.local = newLocalEntries(true)
+
+	local := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("127.0.0.1"), net.ParseIP("10.96.0.1"), 6, 40000, 15001, 443)
+	rt.register(local)
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40001, 8443, 443))
+	assert.Equal(t, 1, rt.state.len())
+	assert.Equal(t, 2, rt.local.len())
+
+	// the local entry isn't returned as a translation
+	assert.Nil(t, rt.GetTranslationForConn(network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  40000,
+		Dest:   util.AddressFromString("10.96.0.1"),
+		DPort:  443,
+		Type:   network.TCP,
+	}))
+
+	// but it is dumped
+	var dumped []network.DebugConntrackEntry
+	for _, e := range rt.DumpCachedTable() {
+		if e.Local {
+			dumped = append(dumped, e)
+		}
+	}
+	require.Len(t, dumped, 2)
+	assert.Len(t, rt.DumpCachedTable(), 3)
+
+	stats := rt.getDropStats()
+	assert.Equal(t, int64(1), stats["registers_dropped_local"])
+	assert.Equal(t, int64(1), stats["registers_dropped"])
+
+	rt.unregister(local)
+	assert.Zero(t, rt.local.len())
+	assert.Equal(t, 1, rt.state.len())
+}
+
+func TestLocalEntries(t *testing.T) {
+	// nothing is held when local replies aren't skipped
+	var l *localEntries
+	assert.Nil(t, newLocalEntries(false))
+	assert.Zero(t, l.len())
+	assert.Nil(t, l.dump())
+	assert.False(t, l.remove(connKey{}))
+
+	l = newLocalEntries(true)
+	seen := make(map[connKey]struct{})
+	c := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("127.0.0.1"), net.ParseIP("10.96.0.1"), 6, 40000, 15001, 443)
+	l.add(0, &c, seen)
+	assert.Len(t, seen, 2)
+	assert.Len(t, l.keys([]uint8{unix.AF_INET}), 2)
+
+	// the entries missing from a dump are removed
+	before := l.keys([]uint8{unix.AF_INET})
+	l.removeMissing(before, map[connKey]struct{}{})
+	assert.Zero(t, l.len())
+
+	// the number of entries is capped
+	for i := 0; i < maxLocalEntries; i++ {
+		c := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("127.0.0.1"), net.ParseIP("10.96.0.1"), 6, uint16(i), 15001, 443)
+		l.add(0, &c, nil)
+	}
+	assert.Equal(t, maxLocalEntries, l.len())
+}
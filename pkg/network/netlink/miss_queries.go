@@ -0,0 +1,128 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+	"github.com/vishvananda/netns"
+)
+
+// missQueryWindow is the window the queries of the connections missing from the cache are rate-limited over
+const missQueryWindow = time.Second
+
+// entryGetter gets the entry of a connection from the kernel conntrack table, it is implemented by Conntrack
+type entryGetter interface {
+	Get(conn *Con) (Con, error)
+	Close() error
+}
+
+// missQuerier queries the kernel conntrack table for the connections missing from the cache, whose entry may not
+// have been stored because its events were sampled, rate-limited or lost, so the cache eventually has the entries of
+// all the connections looked up. The queries are rate-limited, and the connections found without translation aren't
+// queried again until they expire from the negative cache. Only the conntrack table of the root namespace is queried.
+// A nil *missQuerier queries nothing.
+type missQuerier struct {
+	getter entryGetter
+	// rate is the maximum number of queries per missQueryWindow
+	rate int
+
+	mux           sync.Mutex
+	windowStart   time.Time
+	windowQueries int
+
+	queries     int64
+	found       int64
+	rateLimited int64
+	errors      int64
+}
+
+// newKernelMissQuerier returns a querier of the conntrack table of the root namespace, nil when the rate isn't positive
+func newKernelMissQuerier(procRoot string, rate int) (*missQuerier, error) {
+	if rate <= 0 {
+		return nil, nil
+	}
+	rootNS, err := netns.GetFromPath(filepath.Join(procRoot, "1/ns/net"))
+	if err != nil {
+		return nil, fmt.Errorf("could not get root namespace: %w", err)
+	}
+	defer rootNS.Close()
+
+	getter, err := NewConntrack(int(rootNS))
+	if err != nil {
+		return nil, fmt.Errorf("could not open netlink socket: %w", err)
+	}
+	return newMissQuerier(getter, rate), nil
+}
+
+func newMissQuerier(getter entryGetter, rate int) *missQuerier {
+	return &missQuerier{
+		getter: getter,
+		rate:   rate,
+	}
+}
+
+// allow returns whether a connection can be queried now, which it can't when the rate limit is reached
+func (q *missQuerier) allow(now time.Time) bool {
+	if q == nil {
+		return false
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if now.Sub(q.windowStart) >= missQueryWindow {
+		q.windowStart = now
+		q.windowQueries = 0
+	}
+	if q.windowQueries >= q.rate {
+		atomic.AddInt64(&q.rateLimited, 1)
+		return false
+	}
+	q.windowQueries++
+	return true
+}
+
+// query returns the entry of the connection of the given key, whichever direction of the entry it is, and whether
+// it was found
+func (q *missQuerier) query(k connKey) (Con, bool) {
+	atomic.AddInt64(&q.queries, 1)
+	c, err := q.getter.Get(keyCon(k))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			atomic.AddInt64(&q.errors, 1)
+			log.Debugf("could not query the conntrack entry of %s: %s", keyString(k), err)
+		}
+		return Con{}, false
+	}
+	atomic.AddInt64(&q.found, 1)
+	return c, true
+}
+
+func (q *missQuerier) close() {
+	if q == nil {
+		return
+	}
+	_ = q.getter.Close()
+}
+
+func (q *missQuerier) getStats() map[string]int64 {
+	return map[string]int64{
+		"miss_queries":              atomic.LoadInt64(&q.queries),
+		"miss_queries_found":        atomic.LoadInt64(&q.found),
+		"miss_queries_rate_limited": atomic.LoadInt64(&q.rateLimited),
+		"miss_query_errors":         atomic.LoadInt64(&q.errors),
+	}
+}
+
+// keyCon returns a Con whose origin tuple is the connection of the given key
+func keyCon(k connKey) *Con {
+	return &Con{Origin: keyTuple(k)}
+}
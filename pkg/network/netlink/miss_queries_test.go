@@ -0,0 +1,107 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeEntryGetter struct {
+	entries []Con
+	gets    int
+}
+
+func (g *fakeEntryGetter) Get(conn *Con) (Con, error) {
+	g.gets++
+	k, _ := formatKey(conn.Origin)
+	for _, c := range g.entries {
+		for _, tuple := range [...]IPTuple{c.Origin, c.Reply} {
+			if tk, _ := formatKey(tuple); tk == k {
+				return c, nil
+			}
+		}
+	}
+	return Con{}, fmt.Errorf("no such entry: %w", os.ErrNotExist)
+}
+
+func (g *fakeEntryGetter) Close() error {
+	return nil
+}
+
+func TestMissQuerierRateLimit(t *testing.T) {
+	q := newMissQuerier(&fakeEntryGetter{}, 2)
+	now := time.Now()
+
+	assert.True(t, q.allow(now))
+	assert.True(t, q.allow(now))
+	assert.False(t, q.allow(now))
+	assert.Equal(t, int64(1), q.getStats()["miss_queries_rate_limited"])
+
+	now = now.Add(missQueryWindow)
+	assert.True(t, q.allow(now))
+
+	var nilQuerier *missQuerier
+	assert.False(t, nilQuerier.allow(now))
+}
+
+func TestQueryMiss(t *testing.T) {
+	getter := &fakeEntryGetter{entries: []Con{
+		makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("10.96.0.1"), 6, 40000, 80, 80),
+		makeUntranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), 6, 40001, 80),
+	}}
+	rt := newConntracker()
+	rt.misses = newMissQuerier(getter, 10)
+	rt.negatives = newNegativeCache(10*time.Second, 10)
+
+	translated := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  40000,
+		Dest:   util.AddressFromString("10.96.0.1"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+	assert.Equal(t, &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("20.0.0.1"),
+		ReplDstIP:   util.AddressFromString("10.0.0.1"),
+		ReplSrcPort: 80,
+		ReplDstPort: 40000,
+		NATType:     network.DNAT,
+	}, rt.GetTranslationForConn(translated))
+	// the entry found is cached
+	assert.NotNil(t, rt.GetTranslationForConn(translated))
+	assert.Equal(t, 1, getter.gets)
+
+	// the connections which aren't NAT'ed are cached without translation
+	untranslated := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  40001,
+		Dest:   util.AddressFromString("10.0.0.2"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+	assert.Nil(t, rt.GetTranslationsForConns([]network.ConnectionStats{untranslated})[0])
+	assert.Nil(t, rt.GetTranslationForConn(untranslated))
+	assert.Equal(t, 2, getter.gets)
+
+	stats := rt.misses.getStats()
+	assert.Equal(t, int64(2), stats["miss_queries"])
+	assert.Equal(t, int64(2), stats["miss_queries_found"])
+}
+
+func TestKeyCon(t *testing.T) {
+	k := connKey{srcIP: keyAddrFromString("fd00::1"), srcPort: 40000, dstIP: keyAddrFromString("fd00::2"), dstPort: 53, transport: network.UDP}
+	c := keyCon(k)
+	assert.Equal(t, uint8(17), c.Origin.Proto())
+	got, ok := formatKey(c.Origin)
+	assert.True(t, ok)
+	assert.Equal(t, k, got)
+}
@@ -0,0 +1,104 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/netlink"
+)
+
+const (
+	// namespaceLimitWindow is the window the events of each network namespace are counted over
+	namespaceLimitWindow = time.Second
+
+	// maxThrottledNamespaces caps the number of namespaces whose throttled events are counted separately
+	maxThrottledNamespaces = 64
+)
+
+// namespaceLimiter caps the number of conntrack events read per second from each network namespace, so a single
+// noisy namespace can't use up the rate limit of the event socket and have the events of all the namespaces sampled,
+// including the ones of the root namespace. The events over the limit of their namespace are dropped before they
+// are counted by the circuit breaker. A nil *namespaceLimiter caps nothing.
+type namespaceLimiter struct {
+	max int64
+
+	mux         sync.Mutex
+	windowStart time.Time
+	// counts holds the number of events read in the current window, by namespace id
+	counts map[int32]int64
+	// throttled holds the number of events dropped, by namespace id
+	throttled map[int32]int64
+	dropped   int64
+}
+
+// newNamespaceLimiter returns a limiter of max events per second per namespace. It returns nil when max is 0.
+func newNamespaceLimiter(max int) *namespaceLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &namespaceLimiter{
+		max:       int64(max),
+		counts:    make(map[int32]int64),
+		throttled: make(map[int32]int64),
+	}
+}
+
+// filter returns the messages of the given namespace which are under its limit, dropping the others
+func (l *namespaceLimiter) filter(now time.Time, nsid int32, msgs []netlink.Message) []netlink.Message {
+	if l == nil || len(msgs) == 0 {
+		return msgs
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if now.Sub(l.windowStart) >= namespaceLimitWindow {
+		l.windowStart = now
+		l.counts = make(map[int32]int64)
+	}
+
+	count := l.counts[nsid]
+	allowed := l.max - count
+	if allowed < 0 {
+		allowed = 0
+	}
+	l.counts[nsid] = count + int64(len(msgs))
+	if int64(len(msgs)) <= allowed {
+		return msgs
+	}
+
+	dropped := int64(len(msgs)) - allowed
+	l.dropped += dropped
+	if _, ok := l.throttled[nsid]; ok || len(l.throttled) < maxThrottledNamespaces {
+		l.throttled[nsid] += dropped
+	}
+	return msgs[:allowed]
+}
+
+func (l *namespaceLimiter) getStats() map[string]int64 {
+	if l == nil {
+		return nil
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	stats := map[string]int64{
+		"namespace_throttles":  l.dropped,
+		"namespaces_throttled": int64(len(l.throttled)),
+	}
+	for nsid, dropped := range l.throttled {
+		stats[namespaceStatName("namespace_throttles", nsid)] = dropped
+	}
+	return stats
+}
+
+// namespaceStatName returns the name of the stat of the given namespace id
+func namespaceStatName(name string, nsid int32) string {
+	if nsid == noNSID {
+		return name + "_root"
+	}
+	return fmt.Sprintf("%s_%d", name, nsid)
+}
@@ -0,0 +1,45 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceLimiter(t *testing.T) {
+	assert.Nil(t, newNamespaceLimiter(0))
+
+	l := newNamespaceLimiter(3)
+	now := time.Now()
+	msgs := make([]netlink.Message, 2)
+
+	assert.Len(t, l.filter(now, 5, msgs), 2)
+	assert.Len(t, l.filter(now, 5, msgs), 1)
+	assert.Len(t, l.filter(now, 5, msgs), 0)
+	// the other namespaces, the root one included, have their own limit
+	assert.Len(t, l.filter(now, noNSID, msgs), 2)
+	assert.Len(t, l.filter(now, 6, msgs), 2)
+
+	now = now.Add(namespaceLimitWindow)
+	assert.Len(t, l.filter(now, 5, msgs), 2)
+
+	assert.Equal(t, map[string]int64{
+		"namespace_throttles":   3,
+		"namespaces_throttled":  1,
+		"namespace_throttles_5": 3,
+	}, l.getStats())
+
+	var nilLimiter *namespaceLimiter
+	assert.Len(t, nilLimiter.filter(now, 5, msgs), 2)
+	assert.Nil(t, nilLimiter.getStats())
+}
+
+func TestNamespaceStatName(t *testing.T) {
+	assert.Equal(t, "namespace_throttles_root", namespaceStatName("namespace_throttles", noNSID))
+	assert.Equal(t, "namespace_throttles_12", namespaceStatName("namespace_throttles", 12))
+}
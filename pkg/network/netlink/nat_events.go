@@ -0,0 +1,187 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// natEventBatchSize is the maximum number of NAT events sent to the sink at once
+	natEventBatchSize = 256
+
+	// natEventFlushInterval is the maximum amount of time a NAT event waits for its batch to fill up before being sent
+	natEventFlushInterval = 10 * time.Second
+)
+
+// NATEvent records the creation or the deletion of the translation of a connection, so the NAT of the host can be
+// audited
+type NATEvent struct {
+	// Type is "create" or "delete"
+	Type string `json:"type"`
+	// Timestamp is when the event was received, in nanoseconds since the epoch
+	Timestamp int64  `json:"timestamp"`
+	Transport string `json:"transport"`
+	// NetNS is the inode of the network namespace of the connection, 0 for the root namespace
+	NetNS   uint32 `json:"netns,omitempty"`
+	NATType string `json:"nat_type"`
+
+	// Pre is the tuple of the connection before its translation, Post the one after it
+	Pre  NATTuple `json:"pre"`
+	Post NATTuple `json:"post"`
+}
+
+// NATTuple is a tuple of a NATEvent
+type NATTuple struct {
+	Source string `json:"source"`
+	SPort  uint16 `json:"sport"`
+	Dest   string `json:"dest"`
+	DPort  uint16 `json:"dport"`
+}
+
+// NATEventSink publishes the NAT events, eg. to the event platform of the agent. It returns the number of events it
+// sent, along with an error when it couldn't send them all. The sinks which are also an io.Closer are closed along
+// with the NATEventExporter.
+type NATEventSink interface {
+	SendNATEvents(events []NATEvent) (int, error)
+}
+
+// NATEventExporter publishes the creations and deletions of the translations of a Conntracker to a NATEventSink, in
+// batches of up to natEventBatchSize events, sent at least every natEventFlushInterval. The events the subscription
+// drops when the exporter doesn't keep up are counted in the "subscription_events_lost" stat of the conntracker,
+// and the events the sink fails to send are dropped, so the exporter holds at most a batch of events.
+// Each connection is exported once, for its origin direction, the events about its reply direction being skipped.
+type NATEventExporter struct {
+	sink   NATEventSink
+	clock  clock
+	ticker ticker
+
+	cancel func()
+	done   chan struct{}
+
+	exported int64
+	failed   int64
+}
+
+// NewNATEventExporter subscribes to the translations of the given Conntracker, and publishes them to the given sink
+func NewNATEventExporter(ctr Conntracker, sink NATEventSink) *NATEventExporter {
+	return newNATEventExporter(ctr, sink, realClock{})
+}
+
+func newNATEventExporter(ctr Conntracker, sink NATEventSink, clk clock) *NATEventExporter {
+	events, cancel := ctr.Subscribe(isNATEvent)
+	x := &NATEventExporter{
+		sink:   sink,
+		clock:  clk,
+		ticker: clk.NewTicker(natEventFlushInterval),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go x.run(events)
+	return x
+}
+
+// run batches the events until the subscription is canceled, sending the last batch then
+func (x *NATEventExporter) run(events <-chan TranslationEvent) {
+	defer close(x.done)
+
+	batch := make([]NATEvent, 0, natEventBatchSize)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				x.send(batch)
+				return
+			}
+			// the flushes are sent whatever the filter
+			if !isNATEvent(e) {
+				continue
+			}
+			batch = append(batch, x.newNATEvent(e))
+			if len(batch) < natEventBatchSize {
+				continue
+			}
+		case <-x.ticker.C():
+		}
+		x.send(batch)
+		// the sink may hold on to the batch sent
+		batch = make([]NATEvent, 0, natEventBatchSize)
+	}
+}
+
+func (x *NATEventExporter) send(batch []NATEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	sent, err := x.sink.SendNATEvents(batch)
+	atomic.AddInt64(&x.exported, int64(sent))
+	if err != nil {
+		log.Warnf("could not send %d NAT events: %s", len(batch)-sent, err)
+		atomic.AddInt64(&x.failed, int64(len(batch)-sent))
+	}
+}
+
+// isNATEvent returns whether the event is exported: the creations and deletions of the origin directions of the
+// connections
+func isNATEvent(e TranslationEvent) bool {
+	return (e.Type == TranslationCreated || e.Type == TranslationDeleted) && !e.Reply
+}
+
+// newNATEvent returns the NAT event of a translation event. The translation holds the reply direction of the
+// connection, which is reversed into the tuple of the connection after its translation.
+func (x *NATEventExporter) newNATEvent(e TranslationEvent) NATEvent {
+	typ := "create"
+	if e.Type == TranslationDeleted {
+		typ = "delete"
+	}
+	return NATEvent{
+		Type:      typ,
+		Timestamp: x.clock.Now().UnixNano(),
+		Transport: e.Transport.String(),
+		NetNS:     e.NetNS,
+		NATType:   e.Translation.NATType.String(),
+		Pre: NATTuple{
+			Source: e.Source.String(),
+			SPort:  e.SPort,
+			Dest:   e.Dest.String(),
+			DPort:  e.DPort,
+		},
+		Post: NATTuple{
+			Source: e.Translation.ReplDstIP.String(),
+			SPort:  e.Translation.ReplDstPort,
+			Dest:   e.Translation.ReplSrcIP.String(),
+			DPort:  e.Translation.ReplSrcPort,
+		},
+	}
+}
+
+// GetStats returns the number of NAT events sent to the sink, and the ones it failed to send
+func (x *NATEventExporter) GetStats() map[string]int64 {
+	if x == nil {
+		return nil
+	}
+	return map[string]int64{
+		"nat_events_exported": atomic.LoadInt64(&x.exported),
+		"nat_events_failed":   atomic.LoadInt64(&x.failed),
+	}
+}
+
+// Close cancels the subscription, once the events received were sent, and closes the sink
+func (x *NATEventExporter) Close() {
+	if x == nil {
+		return
+	}
+	x.cancel()
+	<-x.done
+	x.ticker.Stop()
+	if c, ok := x.sink.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Warnf("could not close the sink of the NAT events: %s", err)
+		}
+	}
+}
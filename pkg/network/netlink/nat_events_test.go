@@ -0,0 +1,166 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeNATEventSink struct {
+	sync.Mutex
+	batches [][]NATEvent
+	err     error
+}
+
+func (s *fakeNATEventSink) SendNATEvents(events []NATEvent) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.batches = append(s.batches, events)
+	return len(events), nil
+}
+
+func (s *fakeNATEventSink) sent() [][]NATEvent {
+	s.Lock()
+	defer s.Unlock()
+	return append([][]NATEvent(nil), s.batches...)
+}
+
+func TestNATEventExporter(t *testing.T) {
+	rt := newConntracker()
+	clk := newFakeClock()
+	sink := &fakeNATEventSink{}
+	exporter := newNATEventExporter(rt, sink, clk)
+	defer exporter.Close()
+	start := clk.Now().UnixNano()
+
+	c := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	rt.register(c)
+	rt.unregister(c)
+	// the flushes aren't exported
+	rt.notifier.notifyFlush()
+
+	// the events are sent once the flush interval elapses
+	require.Eventually(t, func() bool {
+		clk.Add(natEventFlushInterval)
+		return len(sink.sent()) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	// the connection is exported once, for its origin direction
+	events := sink.sent()[0]
+	require.Len(t, events, 2)
+	for i := range events {
+		assert.True(t, events[i].Timestamp >= start)
+		events[i].Timestamp = 0
+	}
+	origin := NATEvent{
+		Type:      "create",
+		Transport: "TCP",
+		NATType:   "dnat",
+		Pre:       NATTuple{Source: "10.0.0.0", SPort: 12345, Dest: "50.30.40.10", DPort: 80},
+		Post:      NATTuple{Source: "10.0.0.0", SPort: 12345, Dest: "20.0.0.0", DPort: 80},
+	}
+	assert.Equal(t, origin, events[0])
+
+	origin.Type = "delete"
+	assert.Equal(t, origin, events[1])
+	assert.Equal(t, int64(2), exporter.GetStats()["nat_events_exported"])
+}
+
+func TestNATEventExporterBatches(t *testing.T) {
+	rt := newConntracker()
+	sink := &fakeNATEventSink{}
+	exporter := newNATEventExporter(rt, sink, newFakeClock())
+
+	for i := 0; i < natEventBatchSize+1; i++ {
+		rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, uint16(10000+i), 80, 80))
+	}
+
+	// a full batch is sent right away, and the rest once the exporter is closed
+	require.Eventually(t, func() bool { return len(sink.sent()) == 1 }, time.Second, time.Millisecond)
+	assert.Len(t, sink.sent()[0], natEventBatchSize)
+	exporter.Close()
+	require.Len(t, sink.sent(), 2)
+	assert.Len(t, sink.sent()[1], 1)
+}
+
+func TestNATEventExporterFailures(t *testing.T) {
+	rt := newConntracker()
+	sink := &fakeNATEventSink{err: errors.New("unavailable")}
+	exporter := newNATEventExporter(rt, sink, newFakeClock())
+
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+	exporter.Close()
+
+	assert.Empty(t, sink.sent())
+	assert.Equal(t, map[string]int64{"nat_events_exported": 0, "nat_events_failed": 1}, exporter.GetStats())
+}
+
+func TestNATEventExporterOriginOnly(t *testing.T) {
+	// the fake conntracker stores both directions of the connections, and notifies about both of them
+	ctr := NewFakeConntracker()
+	sink := &fakeNATEventSink{}
+	exporter := newNATEventExporter(ctr, sink, newFakeClock())
+
+	c := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.0"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("50.30.40.10"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+	ctr.AddTranslation(c, &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("20.0.0.0"),
+		ReplDstIP:   util.AddressFromString("10.0.0.0"),
+		ReplSrcPort: 80,
+		ReplDstPort: 12345,
+	})
+	ctr.DeleteTranslation(c)
+	exporter.Close()
+
+	var events []NATEvent
+	for _, batch := range sink.sent() {
+		events = append(events, batch...)
+	}
+	require.Len(t, events, 2)
+	for i, typ := range []string{"create", "delete"} {
+		assert.Equal(t, typ, events[i].Type)
+		assert.Equal(t, NATTuple{Source: "10.0.0.0", SPort: 12345, Dest: "50.30.40.10", DPort: 80}, events[i].Pre)
+	}
+}
+
+// partialNATEventSink sends the first events of each batch, up to its capacity
+type partialNATEventSink struct {
+	capacity int
+}
+
+func (s *partialNATEventSink) SendNATEvents(events []NATEvent) (int, error) {
+	if len(events) > s.capacity {
+		return s.capacity, errors.New("full")
+	}
+	return len(events), nil
+}
+
+func TestNATEventExporterPartialFailures(t *testing.T) {
+	rt := newConntracker()
+	exporter := newNATEventExporter(rt, &partialNATEventSink{capacity: 2}, newFakeClock())
+
+	for i := 0; i < 5; i++ {
+		rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, uint16(10000+i), 80, 80))
+	}
+	exporter.Close()
+	assert.Equal(t, map[string]int64{"nat_events_exported": 2, "nat_events_failed": 3}, exporter.GetStats())
+}
@@ -0,0 +1,89 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+import (
+	"sort"
+
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+// natGateways counts the connections source NATed to each address, which are the addresses the host's connections
+// egress through. Only the connections whose direction is known are counted: the reply entry of a destination NATed
+// connection looks the same as a source NATed connection, with the virtual IP in place of the gateway.
+// A nil natGateways counts nothing.
+type natGateways struct {
+	// origins are the keys of the origin entries of the connections counted, with the address they are NATed to
+	origins map[connKey]util.Address
+	counts  map[util.Address]int
+}
+
+func newNATGateways() *natGateways {
+	return &natGateways{
+		origins: make(map[connKey]util.Address),
+		counts:  make(map[util.Address]int),
+	}
+}
+
+// observe counts the connection of the given origin key when its replies are sent to another address than its source
+func (g *natGateways) observe(origin connKey, replyDst util.Address) {
+	if g == nil {
+		return
+	}
+	if old, ok := g.origins[origin]; ok {
+		if old == replyDst {
+			return
+		}
+		g.forget(origin)
+	}
+	if newKeyAddr(replyDst) == origin.srcIP {
+		// the source isn't translated, or only its port is
+		return
+	}
+	g.origins[origin] = replyDst
+	g.counts[replyDst]++
+}
+
+// forget stops counting the connection of the given key, if it was
+func (g *natGateways) forget(k connKey) {
+	if g == nil {
+		return
+	}
+	addr, ok := g.origins[k]
+	if !ok {
+		return
+	}
+	delete(g.origins, k)
+	if g.counts[addr] <= 1 {
+		delete(g.counts, addr)
+		return
+	}
+	g.counts[addr]--
+}
+
+func (g *natGateways) len() int {
+	if g == nil {
+		return 0
+	}
+	return len(g.counts)
+}
+
+// addresses returns the gateway addresses, the ones with the most connections first
+func (g *natGateways) addresses() []util.Address {
+	if g.len() == 0 {
+		return nil
+	}
+
+	addrs := make([]util.Address, 0, len(g.counts))
+	for addr := range g.counts {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		if g.counts[addrs[i]] != g.counts[addrs[j]] {
+			return g.counts[addrs[i]] > g.counts[addrs[j]]
+		}
+		return addrs[i].String() < addrs[j].String()
+	})
+	return addrs
+}
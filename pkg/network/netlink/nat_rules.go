@@ -0,0 +1,280 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"path/filepath"
+
+	"github.com/mdlayher/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// natRule holds the address constraints of a NAT rule. A nil network matches any address.
+type natRule struct {
+	src, dst *net.IPNet
+}
+
+// natRules are the NAT rules of the host. A conntrack entry is only worth storing when it matches
+// one of them. A nil natRules matches everything.
+type natRules []natRule
+
+// matches returns whether the conntrack entry may have been translated by one of the rules.
+// The destination is compared to both the original and the translated destination, since
+// source NAT rules see the packets after destination NAT happened.
+func (rules natRules) matches(c Con) bool {
+	if rules == nil {
+		return true
+	}
+	for _, r := range rules {
+		if (r.src == nil || r.src.Contains(c.Origin.src[:])) &&
+			(r.dst == nil || r.dst.Contains(c.Origin.dst[:]) || r.dst.Contains(c.Reply.src[:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// readNATRules dumps the nftables rules of the root network namespace, and returns the address constraints of the
+// NAT rules. Rules created with iptables-nft are included, but rules created with iptables-legacy aren't visible
+// through nftables.
+// nil is returned when any NAT rule can't be restricted to a set of addresses, or when no NAT rule was found, in which
+// case the NAT might be done by iptables-legacy.
+func readNATRules(procRoot string) (natRules, error) {
+	rootNS, err := netns.GetFromPath(filepath.Join(procRoot, "1/ns/net"))
+	if err != nil {
+		return nil, fmt.Errorf("could not get root namespace: %w", err)
+	}
+	defer rootNS.Close()
+
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, &netlink.Config{NetNS: int(rootNS)})
+	if err != nil {
+		return nil, fmt.Errorf("could not open netlink socket: %w", err)
+	}
+	defer conn.Close()
+
+	req := netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES << 8) | unix.NFT_MSG_GETRULE),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: []byte{unix.AF_UNSPEC, unix.NFNETLINK_V0, 0, 0},
+	}
+
+	msgs, err := conn.Execute(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not dump nftables rules: %w", err)
+	}
+
+	var rules natRules
+	for _, m := range msgs {
+		if len(m.Data) < 4 {
+			continue
+		}
+		rule, isNAT, err := decodeNATRule(m.Data[0], m.Data[4:])
+		if err != nil {
+			return nil, err
+		}
+		if !isNAT {
+			continue
+		}
+		if rule.src == nil && rule.dst == nil {
+			return nil, nil
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// payloadLoad describes a part of the network header loaded into an nftables register
+type payloadLoad struct {
+	offset, length uint32
+	mask           []byte
+}
+
+// decodeNATRule decodes the attributes of an nftables rule, and returns whether it is a NAT rule along with the
+// address constraints it has. Only the constraints generated by iptables-nft and nft for address matches are
+// recognized, other constraints are ignored, which can only make the rule match more entries than it does.
+func decodeNATRule(family uint8, data []byte) (rule natRule, isNAT bool, err error) {
+	ad, err := netlink.NewAttributeDecoder(data)
+	if err != nil {
+		return rule, false, err
+	}
+	ad.ByteOrder = binary.BigEndian
+
+	registers := make(map[uint32]payloadLoad)
+	for ad.Next() {
+		if ad.Type()&attrTypeMask != unix.NFTA_RULE_EXPRESSIONS {
+			continue
+		}
+		ad.Nested(func(exprs *netlink.AttributeDecoder) error {
+			for exprs.Next() {
+				if exprs.Type()&attrTypeMask != unix.NFTA_LIST_ELEM {
+					continue
+				}
+				exprs.Nested(func(expr *netlink.AttributeDecoder) error {
+					var name string
+					for expr.Next() {
+						switch expr.Type() & attrTypeMask {
+						case unix.NFTA_EXPR_NAME:
+							name = expr.String()
+						case unix.NFTA_EXPR_DATA:
+							switch name {
+							case "nat", "masq", "redir":
+								isNAT = true
+							case "payload":
+								expr.Nested(decodePayload(registers))
+							case "bitwise":
+								expr.Nested(decodeBitwise(registers))
+							case "cmp":
+								expr.Nested(decodeCmp(family, registers, &rule))
+							}
+						}
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	}
+
+	return rule, isNAT, ad.Err()
+}
+
+func decodePayload(registers map[uint32]payloadLoad) func(*netlink.AttributeDecoder) error {
+	return func(ad *netlink.AttributeDecoder) error {
+		var dreg, base uint32
+		var load payloadLoad
+		for ad.Next() {
+			switch ad.Type() & attrTypeMask {
+			case unix.NFTA_PAYLOAD_DREG:
+				dreg = ad.Uint32()
+			case unix.NFTA_PAYLOAD_BASE:
+				base = ad.Uint32()
+			case unix.NFTA_PAYLOAD_OFFSET:
+				load.offset = ad.Uint32()
+			case unix.NFTA_PAYLOAD_LEN:
+				load.length = ad.Uint32()
+			}
+		}
+		if base == unix.NFT_PAYLOAD_NETWORK_HEADER {
+			registers[dreg] = load
+		} else {
+			delete(registers, dreg)
+		}
+		return nil
+	}
+}
+
+func decodeBitwise(registers map[uint32]payloadLoad) func(*netlink.AttributeDecoder) error {
+	return func(ad *netlink.AttributeDecoder) error {
+		var sreg, dreg uint32
+		var mask []byte
+		for ad.Next() {
+			switch ad.Type() & attrTypeMask {
+			case unix.NFTA_BITWISE_SREG:
+				sreg = ad.Uint32()
+			case unix.NFTA_BITWISE_DREG:
+				dreg = ad.Uint32()
+			case unix.NFTA_BITWISE_MASK:
+				mask = decodeDataValue(ad)
+			}
+		}
+		load, ok := registers[sreg]
+		if !ok {
+			delete(registers, dreg)
+			return nil
+		}
+		load.mask = mask
+		registers[dreg] = load
+		return nil
+	}
+}
+
+func decodeCmp(family uint8, registers map[uint32]payloadLoad, rule *natRule) func(*netlink.AttributeDecoder) error {
+	return func(ad *netlink.AttributeDecoder) error {
+		var sreg, op uint32
+		var value []byte
+		for ad.Next() {
+			switch ad.Type() & attrTypeMask {
+			case unix.NFTA_CMP_SREG:
+				sreg = ad.Uint32()
+			case unix.NFTA_CMP_OP:
+				op = ad.Uint32()
+			case unix.NFTA_CMP_DATA:
+				value = decodeDataValue(ad)
+			}
+		}
+
+		load, ok := registers[sreg]
+		if !ok || op != unix.NFT_CMP_EQ {
+			return nil
+		}
+
+		src, addrLen, ok := addressField(family, load.offset)
+		if !ok || int(load.length) != len(value) || load.length > addrLen {
+			return nil
+		}
+
+		ipNet := &net.IPNet{IP: make(net.IP, addrLen), Mask: net.CIDRMask(int(load.length)*8, int(addrLen)*8)}
+		copy(ipNet.IP, value)
+		if load.mask != nil {
+			if len(load.mask) != len(value) {
+				return nil
+			}
+			copy(ipNet.Mask, load.mask)
+			if ones, bits := ipNet.Mask.Size(); ones == 0 && bits == 0 {
+				// non canonical mask, the constraint can't be expressed as a network
+				return nil
+			}
+		}
+		ipNet.IP = ipNet.IP.Mask(ipNet.Mask)
+
+		if src {
+			rule.src = ipNet
+		} else {
+			rule.dst = ipNet
+		}
+		return nil
+	}
+}
+
+// addressField returns whether the given offset of the network header is the start of the source or the
+// destination address, along with the length of the address
+func addressField(family uint8, offset uint32) (src bool, length uint32, ok bool) {
+	if family == unix.NFPROTO_IPV4 || family == unix.NFPROTO_INET {
+		switch offset {
+		case 12:
+			return true, net.IPv4len, true
+		case 16:
+			return false, net.IPv4len, true
+		}
+	}
+	if family == unix.NFPROTO_IPV6 || family == unix.NFPROTO_INET {
+		switch offset {
+		case 8:
+			return true, net.IPv6len, true
+		case 24:
+			return false, net.IPv6len, true
+		}
+	}
+	return false, 0, false
+}
+
+func decodeDataValue(ad *netlink.AttributeDecoder) []byte {
+	var value []byte
+	ad.Nested(func(nad *netlink.AttributeDecoder) error {
+		for nad.Next() {
+			if nad.Type()&attrTypeMask == unix.NFTA_DATA_VALUE {
+				value = nad.Bytes()
+			}
+		}
+		return nil
+	})
+	return value
+}
@@ -0,0 +1,187 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestDecodeNATRule(t *testing.T) {
+	t.Run("masquerade with a byte aligned source prefix", func(t *testing.T) {
+		// iptables -t nat -A POSTROUTING -s 10.0.0.0/8 ! -o docker0 -j MASQUERADE
+		rule, isNAT := decodeTestRule(t, unix.NFPROTO_IPV4,
+			payloadExpr(12, 1),
+			cmpExpr(unix.NFT_CMP_EQ, []byte{10}),
+			cmpExpr(unix.NFT_CMP_NEQ, []byte("docker0")),
+			namedExpr("masq"),
+		)
+		assert.True(t, isNAT)
+		assert.Equal(t, "10.0.0.0/8", rule.src.String())
+		assert.Nil(t, rule.dst)
+	})
+
+	t.Run("masquerade with a masked source prefix", func(t *testing.T) {
+		// iptables -t nat -A POSTROUTING -s 172.17.16.0/20 -j MASQUERADE
+		rule, isNAT := decodeTestRule(t, unix.NFPROTO_IPV4,
+			payloadExpr(12, 4),
+			bitwiseExpr([]byte{255, 255, 240, 0}),
+			cmpExpr(unix.NFT_CMP_EQ, []byte{172, 17, 16, 0}),
+			namedExpr("masq"),
+		)
+		assert.True(t, isNAT)
+		assert.Equal(t, "172.17.16.0/20", rule.src.String())
+		assert.Nil(t, rule.dst)
+	})
+
+	t.Run("dnat with a destination address", func(t *testing.T) {
+		// iptables -t nat -A PREROUTING -d 2.2.2.2/32 -j DNAT --to-destination 1.1.1.1
+		rule, isNAT := decodeTestRule(t, unix.NFPROTO_IPV4,
+			payloadExpr(16, 4),
+			cmpExpr(unix.NFT_CMP_EQ, []byte{2, 2, 2, 2}),
+			namedExpr("nat"),
+		)
+		assert.True(t, isNAT)
+		assert.Nil(t, rule.src)
+		assert.Equal(t, "2.2.2.2/32", rule.dst.String())
+	})
+
+	t.Run("negated address match", func(t *testing.T) {
+		rule, isNAT := decodeTestRule(t, unix.NFPROTO_IPV4,
+			payloadExpr(12, 4),
+			cmpExpr(unix.NFT_CMP_NEQ, []byte{10, 0, 0, 1}),
+			namedExpr("masq"),
+		)
+		assert.True(t, isNAT)
+		assert.Nil(t, rule.src)
+		assert.Nil(t, rule.dst)
+	})
+
+	t.Run("ipv6 source prefix", func(t *testing.T) {
+		rule, isNAT := decodeTestRule(t, unix.NFPROTO_IPV6,
+			payloadExpr(8, 8),
+			cmpExpr(unix.NFT_CMP_EQ, []byte{0xfd, 0, 0, 0, 0, 0, 0, 0}),
+			namedExpr("masq"),
+		)
+		assert.True(t, isNAT)
+		assert.Equal(t, "fd00::/64", rule.src.String())
+	})
+
+	t.Run("filter rule", func(t *testing.T) {
+		_, isNAT := decodeTestRule(t, unix.NFPROTO_IPV4,
+			payloadExpr(12, 4),
+			cmpExpr(unix.NFT_CMP_EQ, []byte{10, 0, 0, 1}),
+			namedExpr("counter"),
+		)
+		assert.False(t, isNAT)
+	})
+}
+
+func TestNATRulesMatches(t *testing.T) {
+	_, podCIDR, _ := net.ParseCIDR("10.244.0.0/16")
+	_, vip, _ := net.ParseCIDR("10.96.0.1/32")
+	rules := natRules{{src: podCIDR}, {dst: vip}}
+
+	// masqueraded pod traffic
+	assert.True(t, rules.matches(makeTranslatedConn(net.ParseIP("10.244.1.2"), net.ParseIP("8.8.8.8"), net.ParseIP("8.8.8.8"), 6, 12345, 53, 53)))
+	// traffic to the VIP, from outside the pod CIDR
+	assert.True(t, rules.matches(makeTranslatedConn(net.ParseIP("192.168.0.2"), net.ParseIP("10.240.1.5"), net.ParseIP("10.96.0.1"), 6, 12345, 8080, 443)))
+	// traffic translated by something else
+	assert.False(t, rules.matches(makeTranslatedConn(net.ParseIP("192.168.0.2"), net.ParseIP("172.16.0.1"), net.ParseIP("172.16.0.2"), 6, 12345, 80, 80)))
+
+	assert.True(t, natRules(nil).matches(makeTranslatedConn(net.ParseIP("192.168.0.2"), net.ParseIP("172.16.0.1"), net.ParseIP("172.16.0.2"), 6, 12345, 80, 80)))
+}
+
+func TestRegisterFilteredByNATRules(t *testing.T) {
+	rt := newConntracker()
+	_, podCIDR, _ := net.ParseCIDR("10.244.0.0/16")
+	rt.natRules.Store(natRules{{src: podCIDR}})
+
+	rt.register(makeTranslatedConn(net.ParseIP("192.168.0.2"), net.ParseIP("172.16.0.1"), net.ParseIP("172.16.0.2"), 6, 12345, 80, 80))
+	assert.Equal(t, 0, rt.state.len())
+	assert.EqualValues(t, 1, rt.stats.registersFiltered)
+
+	rt.register(makeTranslatedConn(net.ParseIP("10.244.1.2"), net.ParseIP("1.1.1.1"), net.ParseIP("8.8.8.8"), 6, 12345, 53, 53))
+	assert.Equal(t, 1, rt.state.len())
+}
+
+type testExpr func(ae *netlink.AttributeEncoder)
+
+func decodeTestRule(t *testing.T, family uint8, exprs ...testExpr) (natRule, bool) {
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	ae.String(unix.NFTA_RULE_TABLE, "nat")
+	ae.Nested(unix.NFTA_RULE_EXPRESSIONS, func(list *netlink.AttributeEncoder) error {
+		for _, expr := range exprs {
+			list.Nested(unix.NFTA_LIST_ELEM, func(elem *netlink.AttributeEncoder) error {
+				expr(elem)
+				return nil
+			})
+		}
+		return nil
+	})
+	data, err := ae.Encode()
+	require.NoError(t, err)
+
+	rule, isNAT, err := decodeNATRule(family, data)
+	require.NoError(t, err)
+	return rule, isNAT
+}
+
+func namedExpr(name string) testExpr {
+	return func(ae *netlink.AttributeEncoder) {
+		ae.String(unix.NFTA_EXPR_NAME, name)
+		ae.Nested(unix.NFTA_EXPR_DATA, func(*netlink.AttributeEncoder) error { return nil })
+	}
+}
+
+func payloadExpr(offset, length uint32) testExpr {
+	return func(ae *netlink.AttributeEncoder) {
+		ae.String(unix.NFTA_EXPR_NAME, "payload")
+		ae.Nested(unix.NFTA_EXPR_DATA, func(data *netlink.AttributeEncoder) error {
+			data.Uint32(unix.NFTA_PAYLOAD_DREG, unix.NFT_REG_1)
+			data.Uint32(unix.NFTA_PAYLOAD_BASE, unix.NFT_PAYLOAD_NETWORK_HEADER)
+			data.Uint32(unix.NFTA_PAYLOAD_OFFSET, offset)
+			data.Uint32(unix.NFTA_PAYLOAD_LEN, length)
+			return nil
+		})
+	}
+}
+
+func bitwiseExpr(mask []byte) testExpr {
+	return func(ae *netlink.AttributeEncoder) {
+		ae.String(unix.NFTA_EXPR_NAME, "bitwise")
+		ae.Nested(unix.NFTA_EXPR_DATA, func(data *netlink.AttributeEncoder) error {
+			data.Uint32(unix.NFTA_BITWISE_SREG, unix.NFT_REG_1)
+			data.Uint32(unix.NFTA_BITWISE_DREG, unix.NFT_REG_1)
+			data.Uint32(unix.NFTA_BITWISE_LEN, uint32(len(mask)))
+			data.Nested(unix.NFTA_BITWISE_MASK, func(value *netlink.AttributeEncoder) error {
+				value.Bytes(unix.NFTA_DATA_VALUE, mask)
+				return nil
+			})
+			return nil
+		})
+	}
+}
+
+func cmpExpr(op uint32, value []byte) testExpr {
+	return func(ae *netlink.AttributeEncoder) {
+		ae.String(unix.NFTA_EXPR_NAME, "cmp")
+		ae.Nested(unix.NFTA_EXPR_DATA, func(data *netlink.AttributeEncoder) error {
+			data.Uint32(unix.NFTA_CMP_SREG, unix.NFT_REG_1)
+			data.Uint32(unix.NFTA_CMP_OP, op)
+			data.Nested(unix.NFTA_CMP_DATA, func(v *netlink.AttributeEncoder) error {
+				v.Bytes(unix.NFTA_DATA_VALUE, value)
+				return nil
+			})
+			return nil
+		})
+	}
+}
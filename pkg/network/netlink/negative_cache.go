@@ -0,0 +1,141 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"sync"
+	"time"
+)
+
+// minNegativeLookups is the smallest number of connections the negative cache holds, whatever the miss query rate
+const minNegativeLookups = 1024
+
+// negativeCache holds the connections recently found without translation, either in the state map or in the
+// kernel conntrack table, so the repeated lookups of the connections which aren't NAT'ed neither take the lock of
+// the conntracker nor query the kernel again. The connections are added with the lock of the conntracker held, and
+// removed whenever a translation is stored for them, so a connection is never cached without translation while it
+// has one. The connections are cached in their network namespace, and storing a translation of the root namespace
+// forgets the connection in all the namespaces, since their lookups fall back to the entries of the root namespace.
+// A nil *negativeCache caches nothing.
+type negativeCache struct {
+	mux     sync.RWMutex
+	ttl     int64
+	maxSize int
+	size    int
+	// keys holds when each connection was found without translation, in nanoseconds since the epoch, by tuple (the
+	// key without its network namespace) and then by network namespace
+	keys map[connKey]map[uint32]int64
+}
+
+// newNegativeCache returns a cache of the connections found without translation, which are looked up again once
+// they were cached for longer than the given ttl. It holds up to as many connections as can be queried over the ttl
+// at the given miss query rate, since caching more of them wouldn't save any query. It returns nil, caching nothing,
+// when either of them is 0.
+func newNegativeCache(ttl time.Duration, missQueryRate int) *negativeCache {
+	if ttl <= 0 || missQueryRate <= 0 {
+		return nil
+	}
+	maxSize := int(ttl/time.Second) * missQueryRate
+	if maxSize < minNegativeLookups {
+		maxSize = minNegativeLookups
+	}
+	return &negativeCache{
+		ttl:     int64(ttl),
+		maxSize: maxSize,
+		keys:    make(map[connKey]map[uint32]int64),
+	}
+}
+
+// has returns whether the connection of the given key was found without translation less than the ttl ago
+func (n *negativeCache) has(k connKey, now time.Time) bool {
+	if n == nil {
+		return false
+	}
+	netns := k.netns
+	k.netns = 0
+	n.mux.RLock()
+	at, ok := n.keys[k][netns]
+	n.mux.RUnlock()
+	return ok && now.UnixNano()-at < n.ttl
+}
+
+// add caches the connection of the given key as having no translation. It must be called with the lock of the
+// conntracker held, read or write, since the lookup which missed.
+func (n *negativeCache) add(k connKey, now time.Time) {
+	if n == nil {
+		return
+	}
+	netns := k.netns
+	k.netns = 0
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if _, ok := n.keys[k][netns]; !ok && n.size >= n.maxSize {
+		n.forgetExpired(now)
+		// the connections are looked up again sooner when too many of them are cached
+		if n.size >= n.maxSize {
+			n.keys = make(map[connKey]map[uint32]int64)
+			n.size = 0
+		}
+	}
+	nss, ok := n.keys[k]
+	if !ok {
+		nss = make(map[uint32]int64, 1)
+		n.keys[k] = nss
+	}
+	if _, ok := nss[netns]; !ok {
+		n.size++
+	}
+	nss[netns] = now.UnixNano()
+}
+
+// forget removes the connection of the given key, whose translation is being stored. The translations of the root
+// namespace forget the connection in all the namespaces.
+func (n *negativeCache) forget(k connKey) {
+	if n == nil {
+		return
+	}
+	netns := k.netns
+	k.netns = 0
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	nss, ok := n.keys[k]
+	if !ok {
+		return
+	}
+	if netns == 0 {
+		n.size -= len(nss)
+		delete(n.keys, k)
+		return
+	}
+	if _, ok := nss[netns]; ok {
+		n.size--
+		delete(nss, netns)
+	}
+	if len(nss) == 0 {
+		delete(n.keys, k)
+	}
+}
+
+func (n *negativeCache) forgetExpired(now time.Time) {
+	for k, nss := range n.keys {
+		for netns, at := range nss {
+			if now.UnixNano()-at >= n.ttl {
+				n.size--
+				delete(nss, netns)
+			}
+		}
+		if len(nss) == 0 {
+			delete(n.keys, k)
+		}
+	}
+}
+
+func (n *negativeCache) len() int {
+	if n == nil {
+		return 0
+	}
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+	return n.size
+}
@@ -0,0 +1,93 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegativeCache(t *testing.T) {
+	n := newNegativeCache(10*time.Second, 10)
+	now := time.Now()
+	k := connKey{srcIP: keyAddrFromString("10.0.0.1"), srcPort: 40000, dstIP: keyAddrFromString("10.0.0.2"), dstPort: 80}
+
+	assert.False(t, n.has(k, now))
+	n.add(k, now)
+	assert.True(t, n.has(k, now))
+
+	// the connections are cached in their namespace, whose lookups may find a translation the root namespace misses
+	namespaced := k
+	namespaced.netns = 4026532000
+	assert.False(t, n.has(namespaced, now))
+	n.add(namespaced, now)
+	assert.True(t, n.has(namespaced, now))
+	assert.Equal(t, 2, n.len())
+
+	// a translation stored in a namespace only forgets the connection in it, while one stored in the root namespace
+	// forgets it in all the namespaces
+	n.forget(namespaced)
+	assert.False(t, n.has(namespaced, now))
+	assert.True(t, n.has(k, now))
+	n.add(namespaced, now)
+	n.forget(k)
+	assert.False(t, n.has(k, now))
+	assert.False(t, n.has(namespaced, now))
+	assert.Zero(t, n.len())
+
+	n.add(k, now)
+	assert.False(t, n.has(k, now.Add(10*time.Second)))
+
+	var nilCache *negativeCache
+	nilCache.add(k, now)
+	assert.False(t, nilCache.has(k, now))
+	assert.Nil(t, newNegativeCache(0, 10))
+	assert.Nil(t, newNegativeCache(10*time.Second, 0))
+}
+
+func TestNegativeCacheSize(t *testing.T) {
+	// the cache holds as many connections as can be queried over its ttl
+	n := newNegativeCache(10*time.Second, 200)
+	assert.Equal(t, 2000, n.maxSize)
+	assert.Equal(t, minNegativeLookups, newNegativeCache(time.Second, 10).maxSize)
+
+	now := time.Now()
+	for i := 0; i < n.maxSize; i++ {
+		n.add(connKey{srcPort: uint16(i), netns: uint32(i % 2)}, now)
+	}
+	assert.Equal(t, n.maxSize, n.len())
+
+	// the expired connections are forgotten first
+	expired := now.Add(10 * time.Second)
+	n.add(connKey{dstPort: 1}, expired)
+	assert.Equal(t, 1, n.len())
+}
+
+func TestNegativeLookups(t *testing.T) {
+	rt := newConntracker()
+	rt.negatives = newNegativeCache(10*time.Second, 10)
+	conn := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  40000,
+		Dest:   util.AddressFromString("10.96.0.1"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+
+	// the state map is stale, so the miss is looked up with the lock held and cached
+	rt.stale = 1
+	assert.Nil(t, rt.GetTranslationForConn(conn))
+	assert.Equal(t, 1, rt.negatives.len())
+
+	// storing the translation of the connection forgets it
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("10.96.0.1"), 6, 40000, 80, 80))
+	assert.Equal(t, 0, rt.negatives.len())
+	assert.NotNil(t, rt.GetTranslationForConn(conn))
+	assert.NotNil(t, rt.GetTranslationsForConns([]network.ConnectionStats{conn})[0])
+}
@@ -0,0 +1,238 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// netnsContainersPollInterval is the interval at which the containers of the network namespaces are listed
+const netnsContainersPollInterval = 10 * time.Second
+
+// netnsContainers maps the network namespaces of the host to the container running in each of them, resolved from
+// one of the processes of the namespace. A namespace shared by several containers (eg. the containers of a Kubernetes
+// pod) is attributed to one of them, and keeps it until the namespace is removed. The root namespace isn't mapped.
+type netnsContainers struct {
+	sync.RWMutex
+	containers map[uint32]string
+
+	rootNS string
+	// list returns a pid of a process of each network namespace, by namespace
+	list func() (map[string]int, error)
+	// containerIDForPID returns the container id of a process, empty when it isn't in a container
+	containerIDForPID func(pid int) (string, error)
+
+	stats struct {
+		polls      int64
+		pollErrors int64
+	}
+}
+
+func newNetnsContainers(procRoot string, containerIDForPID func(pid int) (string, error)) (*netnsContainers, error) {
+	rootNS, err := os.Readlink(filepath.Join(procRoot, "1/ns/net"))
+	if err != nil {
+		return nil, err
+	}
+
+	n := &netnsContainers{
+		rootNS: rootNS,
+		list: func() (map[string]int, error) {
+			return listNetNamespaces(procRoot)
+		},
+		containerIDForPID: containerIDForPID,
+	}
+	if err := n.poll(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// poll lists the network namespaces, and resolves the container of the ones which weren't mapped yet. The
+// namespaces whose container can't be resolved are retried at the next poll, since their first process may not be
+// in its cgroup yet.
+func (n *netnsContainers) poll() error {
+	atomic.AddInt64(&n.stats.polls, 1)
+	nss, err := n.list()
+	if err != nil {
+		atomic.AddInt64(&n.stats.pollErrors, 1)
+		return err
+	}
+	delete(nss, n.rootNS)
+
+	n.RLock()
+	previous := n.containers
+	n.RUnlock()
+
+	containers := make(map[uint32]string, len(nss))
+	for ns, pid := range nss {
+		inode, err := parseNetnsInode(ns)
+		if err != nil {
+			continue
+		}
+		if id, ok := previous[inode]; ok {
+			containers[inode] = id
+			continue
+		}
+		// processes may exit while they are resolved
+		if id, err := n.containerIDForPID(pid); err == nil && id != "" {
+			containers[inode] = id
+		}
+	}
+
+	n.Lock()
+	n.containers = containers
+	n.Unlock()
+	return nil
+}
+
+// get returns the container of the given network namespace
+func (n *netnsContainers) get(netns uint32) (string, bool) {
+	n.RLock()
+	defer n.RUnlock()
+	id, ok := n.containers[netns]
+	return id, ok
+}
+
+func (n *netnsContainers) len() int {
+	n.RLock()
+	defer n.RUnlock()
+	return len(n.containers)
+}
+
+// parseNetnsInode returns the inode of the target of a /proc/<pid>/ns/net link ("net:[4026531992]")
+func parseNetnsInode(ns string) (uint32, error) {
+	if !strings.HasPrefix(ns, "net:[") || !strings.HasSuffix(ns, "]") {
+		return 0, fmt.Errorf("invalid network namespace %q", ns)
+	}
+	inode, err := strconv.ParseUint(ns[len("net:["):len(ns)-1], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid network namespace %q: %w", ns, err)
+	}
+	return uint32(inode), nil
+}
+
+// containerConntracker sets the container of the network namespace of the connections on the translations returned
+// by the wrapped Conntracker, so the NAT of the containers can be attributed to them
+type containerConntracker struct {
+	Conntracker
+	containers *netnsContainers
+
+	exit chan struct{}
+	done chan struct{}
+
+	stats struct {
+		resolved   int64
+		unresolved int64
+	}
+}
+
+// NewContainerConntracker returns a Conntracker setting ContainerID on the translations returned by the given
+// Conntracker for the connections of the network namespaces of containers. The containers of the namespaces are
+// resolved with the given function from a process of each namespace found in procRoot, every 10 seconds.
+func NewContainerConntracker(ctr Conntracker, procRoot string, containerIDForPID func(pid int) (string, error)) (Conntracker, error) {
+	containers, err := newNetnsContainers(procRoot, containerIDForPID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the network namespaces: %w", err)
+	}
+
+	c := newContainerConntracker(ctr, containers)
+	go c.run(time.NewTicker(netnsContainersPollInterval))
+	log.Infof("attributing the translations to the containers of %d network namespaces", containers.len())
+	return c, nil
+}
+
+func newContainerConntracker(ctr Conntracker, containers *netnsContainers) *containerConntracker {
+	return &containerConntracker{
+		Conntracker: ctr,
+		containers:  containers,
+		exit:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// run polls the containers of the network namespaces until the conntracker is closed
+func (ctr *containerConntracker) run(ticker *time.Ticker) {
+	defer close(ctr.done)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := ctr.containers.poll(); err != nil {
+				log.Warnf("could not list the containers of the network namespaces: %s", err)
+			}
+		case <-ctr.exit:
+			return
+		}
+	}
+}
+
+func (ctr *containerConntracker) GetTranslationForConn(c network.ConnectionStats) *network.IPTranslation {
+	return ctr.withContainer(c, ctr.Conntracker.GetTranslationForConn(c))
+}
+
+func (ctr *containerConntracker) GetTranslationsForConns(conns []network.ConnectionStats) []*network.IPTranslation {
+	translations := ctr.Conntracker.GetTranslationsForConns(conns)
+	for i := range translations {
+		translations[i] = ctr.withContainer(conns[i], translations[i])
+	}
+	return translations
+}
+
+func (ctr *containerConntracker) GetEntryForConn(c network.ConnectionStats) *ConntrackEntry {
+	e := ctr.Conntracker.GetEntryForConn(c)
+	if e != nil {
+		e.Translation = ctr.withContainer(c, e.Translation)
+	}
+	return e
+}
+
+// withContainer returns the given translation, along with the container of the network namespace of the connection
+// when it is known
+func (ctr *containerConntracker) withContainer(c network.ConnectionStats, t *network.IPTranslation) *network.IPTranslation {
+	if t == nil || c.NetNS == 0 {
+		return t
+	}
+	id, ok := ctr.containers.get(c.NetNS)
+	if !ok {
+		atomic.AddInt64(&ctr.stats.unresolved, 1)
+		return t
+	}
+	atomic.AddInt64(&ctr.stats.resolved, 1)
+
+	// translations returned by the wrapped Conntracker may be shared, so they are never modified
+	attributed := *t
+	attributed.ContainerID = id
+	return &attributed
+}
+
+func (ctr *containerConntracker) Describe() network.ConntrackDescription {
+	return withLayer(ctr.Conntracker.Describe(), "containers")
+}
+
+func (ctr *containerConntracker) GetStats() Stats {
+	s := ctr.Conntracker.GetStats()
+	s.set("container_netns", int64(ctr.containers.len()))
+	s.set("container_netns_polls", atomic.LoadInt64(&ctr.containers.stats.polls))
+	s.set("container_netns_poll_errors", atomic.LoadInt64(&ctr.containers.stats.pollErrors))
+	s.set("container_translations_resolved", atomic.LoadInt64(&ctr.stats.resolved))
+	s.set("container_translations_unresolved", atomic.LoadInt64(&ctr.stats.unresolved))
+	return s
+}
+
+func (ctr *containerConntracker) Close() {
+	close(ctr.exit)
+	<-ctr.done
+	ctr.Conntracker.Close()
+}
@@ -0,0 +1,126 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetnsContainersPoll(t *testing.T) {
+	current := map[string]int{
+		"net:[1]":   1,
+		"net:[100]": 100,
+		"net:[200]": 200,
+		"net:[300]": 300,
+		"invalid":   400,
+	}
+	cgroups := map[int]string{
+		1:   "",
+		100: "3726b9c1e7e3",
+		200: "",
+		300: "b1a1f5c43d15",
+	}
+	var lookups int
+	n := &netnsContainers{
+		rootNS: "net:[1]",
+		list: func() (map[string]int, error) {
+			nss := make(map[string]int, len(current))
+			for ns, pid := range current {
+				nss[ns] = pid
+			}
+			return nss, nil
+		},
+		containerIDForPID: func(pid int) (string, error) {
+			lookups++
+			id, ok := cgroups[pid]
+			if !ok {
+				return "", errors.New("no such process")
+			}
+			return id, nil
+		},
+	}
+
+	require.NoError(t, n.poll())
+	assert.Equal(t, 3, lookups)
+	id, ok := n.get(100)
+	assert.True(t, ok)
+	assert.Equal(t, "3726b9c1e7e3", id)
+	// the root namespace and the namespaces of the processes which aren't in containers aren't mapped
+	for _, netns := range []uint32{1, 200} {
+		_, ok = n.get(netns)
+		assert.False(t, ok)
+	}
+
+	// the namespaces mapped aren't resolved again, unlike the ones which weren't mapped, and the namespaces removed
+	// are forgotten
+	lookups = 0
+	delete(current, "net:[300]")
+	cgroups[200] = "5c2de30ffa51"
+	require.NoError(t, n.poll())
+	assert.Equal(t, 1, lookups)
+	id, ok = n.get(200)
+	assert.True(t, ok)
+	assert.Equal(t, "5c2de30ffa51", id)
+	_, ok = n.get(300)
+	assert.False(t, ok)
+	assert.Equal(t, 2, n.len())
+}
+
+func TestParseNetnsInode(t *testing.T) {
+	inode, err := parseNetnsInode("net:[4026531992]")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(4026531992), inode)
+
+	for _, ns := range []string{"", "mnt:[4026531840]", "net:[]", "net:[99999999999]"} {
+		_, err = parseNetnsInode(ns)
+		assert.Error(t, err, ns)
+	}
+}
+
+func TestContainerConntracker(t *testing.T) {
+	rt := newConntracker()
+	// 10.0.1.5:40000 -> 10.96.0.1:443 translated to 10.244.1.5:8443
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.1.5"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8443, 443))
+
+	containers := &netnsContainers{containers: map[uint32]string{4026532285: "3726b9c1e7e3"}}
+	ctr := newContainerConntracker(rt, containers)
+
+	c := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.1.5"),
+		SPort:  40000,
+		Dest:   util.AddressFromString("10.96.0.1"),
+		DPort:  443,
+		Type:   network.TCP,
+		NetNS:  4026532285,
+	}
+	trans := ctr.GetTranslationForConn(c)
+	require.NotNil(t, trans)
+	assert.Equal(t, "3726b9c1e7e3", trans.ContainerID)
+	assert.Equal(t, util.AddressFromString("10.244.1.5"), trans.ReplSrcIP)
+	// the cached translations are left untouched
+	assert.Empty(t, rt.GetTranslationForConn(c).ContainerID)
+
+	// the connections of the namespaces which aren't mapped aren't attributed
+	other := c
+	other.NetNS = 4026532300
+	translations := ctr.GetTranslationsForConns([]network.ConnectionStats{c, other})
+	require.Len(t, translations, 2)
+	assert.Equal(t, "3726b9c1e7e3", translations[0].ContainerID)
+	require.NotNil(t, translations[1])
+	assert.Empty(t, translations[1].ContainerID)
+
+	e := ctr.GetEntryForConn(c)
+	require.NotNil(t, e)
+	assert.Equal(t, "3726b9c1e7e3", e.Translation.ContainerID)
+
+	assert.Equal(t, int64(3), ctr.stats.resolved)
+	assert.Equal(t, int64(1), ctr.stats.unresolved)
+}
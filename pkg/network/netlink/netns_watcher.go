@@ -0,0 +1,140 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+// netnsPollInterval is the interval at which the network namespaces of the host are listed
+const netnsPollInterval = 10 * time.Second
+
+// netnsWatcher keeps track of the network namespaces of the host, so the conntrack table of the namespaces
+// created after startup can be dumped. The kernel doesn't report the creation of namespaces, so they are polled.
+// Namespaces are identified by the target of the /proc/<pid>/ns/net link, which is unique per namespace.
+type netnsWatcher struct {
+	rootNS string
+	known  map[string]struct{}
+
+	// list returns a pid of a process of each network namespace, by namespace
+	list func() (map[string]int, error)
+
+	stats struct {
+		added   int64
+		removed int64
+	}
+}
+
+// newNetnsWatcher returns a netnsWatcher aware of the namespaces which currently exist
+func newNetnsWatcher(procRoot string) (*netnsWatcher, error) {
+	rootNS, err := os.Readlink(filepath.Join(procRoot, "1/ns/net"))
+	if err != nil {
+		return nil, err
+	}
+
+	w := &netnsWatcher{
+		rootNS: rootNS,
+		list: func() (map[string]int, error) {
+			return listNetNamespaces(procRoot)
+		},
+	}
+	if _, _, err := w.poll(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// poll returns a pid of a process of each namespace created since the last poll, along with
+// the number of namespaces which were removed. The root namespace is never reported.
+func (w *netnsWatcher) poll() (added []int, removed int, err error) {
+	current, err := w.list()
+	if err != nil {
+		return nil, 0, err
+	}
+	delete(current, w.rootNS)
+
+	known := make(map[string]struct{}, len(current))
+	for ns, pid := range current {
+		known[ns] = struct{}{}
+		if _, ok := w.known[ns]; !ok && w.known != nil {
+			added = append(added, pid)
+		}
+	}
+	for ns := range w.known {
+		if _, ok := known[ns]; !ok {
+			removed++
+		}
+	}
+	w.known = known
+
+	atomic.AddInt64(&w.stats.added, int64(len(added)))
+	atomic.AddInt64(&w.stats.removed, int64(removed))
+	return added, removed, nil
+}
+
+func (w *netnsWatcher) getStats() map[string]int64 {
+	return map[string]int64{
+		"netns_added":   atomic.LoadInt64(&w.stats.added),
+		"netns_removed": atomic.LoadInt64(&w.stats.removed),
+	}
+}
+
+// listNetNamespaces returns a pid of a process of each network namespace, by namespace
+func listNetNamespaces(procRoot string) (map[string]int, error) {
+	nss := make(map[string]int)
+	err := util.WithAllProcs(procRoot, func(pid int) error {
+		// processes may exit while they are listed, or be kernel threads without namespaces
+		ns, err := os.Readlink(filepath.Join(procRoot, strconv.Itoa(pid), "ns/net"))
+		if err != nil {
+			return nil
+		}
+		if _, ok := nss[ns]; !ok {
+			nss[ns] = pid
+		}
+		return nil
+	})
+	return nss, err
+}
+
+// noNSID is the namespace id of the messages which aren't tagged with one, which are the messages of the namespace
+// of the socket they are read from
+const noNSID int32 = -1
+
+// netnsIDs maps the ids the root namespace assigned to its peer namespaces, which tag the conntrack events of
+// the peers, to the inodes of the namespaces, which identify them everywhere else. The mapping is learnt when
+// the tables of the peers are dumped. A nil netnsIDs maps every id to the root namespace.
+type netnsIDs struct {
+	mux    sync.RWMutex
+	inodes map[int32]uint32
+}
+
+func newNetnsIDs() *netnsIDs {
+	return &netnsIDs{inodes: make(map[int32]uint32)}
+}
+
+// set records the inode of the namespace of the given id. Ids are reused once their namespace is removed,
+// so a previous mapping is overwritten.
+func (n *netnsIDs) set(nsid int32, inode uint32) {
+	n.mux.Lock()
+	n.inodes[nsid] = inode
+	n.mux.Unlock()
+}
+
+// inode returns the inode of the namespace of the given id, or 0 for the root namespace. Ids which aren't
+// mapped yet are attributed to the root namespace as well, as they were before namespaces were told apart.
+func (n *netnsIDs) inode(nsid int32) uint32 {
+	if n == nil || nsid == noNSID {
+		return 0
+	}
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+	return n.inodes[nsid]
+}
@@ -0,0 +1,72 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetnsWatcherPoll(t *testing.T) {
+	current := map[string]int{
+		"net:[1]": 1,
+		"net:[2]": 100,
+	}
+	w := &netnsWatcher{
+		rootNS: "net:[1]",
+		list: func() (map[string]int, error) {
+			nss := make(map[string]int, len(current))
+			for ns, pid := range current {
+				nss[ns] = pid
+			}
+			return nss, nil
+		},
+	}
+
+	// the namespaces existing at the first poll aren't reported
+	added, removed, err := w.poll()
+	require.NoError(t, err)
+	assert.Empty(t, added)
+	assert.Zero(t, removed)
+
+	current["net:[3]"] = 200
+	current["net:[4]"] = 300
+	added, removed, err = w.poll()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{200, 300}, added)
+	assert.Zero(t, removed)
+
+	delete(current, "net:[2]")
+	delete(current, "net:[3]")
+	added, removed, err = w.poll()
+	require.NoError(t, err)
+	assert.Empty(t, added)
+	assert.Equal(t, 2, removed)
+
+	assert.Equal(t, map[string]int64{"netns_added": 2, "netns_removed": 2}, w.getStats())
+}
+
+func TestListNetNamespaces(t *testing.T) {
+	procRoot, err := ioutil.TempDir("", "proc")
+	require.NoError(t, err)
+	defer os.RemoveAll(procRoot)
+
+	for pid, ns := range map[string]string{"1": "net:[1]", "10": "net:[2]", "11": "net:[2]"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(procRoot, pid, "ns"), 0755))
+		require.NoError(t, os.Symlink(ns, filepath.Join(procRoot, pid, "ns/net")))
+	}
+	// processes without a namespace are ignored
+	require.NoError(t, os.MkdirAll(filepath.Join(procRoot, "12"), 0755))
+
+	nss, err := listNetNamespaces(procRoot)
+	require.NoError(t, err)
+	assert.Len(t, nss, 2)
+	assert.Equal(t, 1, nss["net:[1]"])
+	assert.Contains(t, []int{10, 11}, nss["net:[2]"])
+}
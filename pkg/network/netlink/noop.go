@@ -1,9 +1,15 @@
-// +build linux
+// +build linux windows
 // +build !android
 
 package netlink
 
-import "github.com/DataDog/datadog-agent/pkg/network"
+import (
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
 
 type noOpConntracker struct{}
 
@@ -16,14 +22,75 @@ func (*noOpConntracker) GetTranslationForConn(c network.ConnectionStats) *networ
 	return nil
 }
 
+func (*noOpConntracker) GetEntryForConn(c network.ConnectionStats) *ConntrackEntry {
+	return nil
+}
+
 func (*noOpConntracker) DeleteTranslation(c network.ConnectionStats) {
 
 }
 
+func (*noOpConntracker) DumpCachedTable() []network.DebugConntrackEntry {
+	return nil
+}
+
+func (*noOpConntracker) GetTCPStateForConn(c network.ConnectionStats) TCPState {
+	return TCPStateNone
+}
+
+func (*noOpConntracker) GetTCPStatesForConns(conns []network.ConnectionStats) []TCPState {
+	return make([]TCPState, len(conns))
+}
+
+func (*noOpConntracker) GetCountersForConn(c network.ConnectionStats) (Counters, bool) {
+	return Counters{}, false
+}
+
+func (*noOpConntracker) GetStartTimeForConn(c network.ConnectionStats) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (*noOpConntracker) GetStatusForConn(c network.ConnectionStats) Status {
+	return 0
+}
+
+func (*noOpConntracker) GetTranslationsForConns(conns []network.ConnectionStats) []*network.IPTranslation {
+	return make([]*network.IPTranslation, len(conns))
+}
+
+func (*noOpConntracker) Subscribe(filter TranslationFilter) (<-chan TranslationEvent, func()) {
+	events := make(chan TranslationEvent)
+	var once sync.Once
+	return events, func() { once.Do(func() { close(events) }) }
+}
+
+func (*noOpConntracker) DeleteTranslations(conns []network.ConnectionStats) {}
+
+func (*noOpConntracker) GetNATGateways() []util.Address {
+	return nil
+}
+
+func (*noOpConntracker) GetRecentErrors() []string {
+	return nil
+}
+
+func (*noOpConntracker) Refresh(family uint8) error {
+	return nil
+}
+
+func (*noOpConntracker) SetTargetRateLimit(limit int) {}
+
+// Describe returns the noop backend, which serves no translation
+func (*noOpConntracker) Describe() network.ConntrackDescription {
+	return network.ConntrackDescription{Backend: "noop", Status: "disabled"}
+}
+
 func (*noOpConntracker) Close() {}
 
-func (*noOpConntracker) GetStats() map[string]int64 {
-	return map[string]int64{
-		"noop_conntracker": 0,
+func (*noOpConntracker) GetStats() Stats {
+	return Stats{
+		Extra: map[string]int64{
+			"noop_conntracker": 0,
+		},
 	}
 }
@@ -0,0 +1,86 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// pendingEvents buffers the live events while the initial dump of the conntrack table is loaded, so they are
+// applied once it is loaded, in the order they were read, instead of racing with the dump. Only the last event of
+// each conntrack entry is kept, since it holds its newest state, at the position of the first one so an entry
+// destroyed is still removed before another entry re-uses its tuple. A nil *pendingEvents buffers nothing.
+type pendingEvents struct {
+	mux    sync.Mutex
+	events []Con
+	// byID is the position of the event of each entry of known id in events
+	byID map[coalescerKey]int
+	// maxSize caps the number of events buffered, the ones above it are dropped
+	maxSize int
+	// done is set once the events are applied, after which they aren't buffered anymore
+	done int32
+
+	buffered int64
+	dropped  int64
+}
+
+func newPendingEvents(maxSize int) *pendingEvents {
+	return &pendingEvents{
+		byID:    make(map[coalescerKey]int),
+		maxSize: maxSize,
+	}
+}
+
+// buffer copies the event into the buffer, and returns false when the events aren't buffered anymore, in which
+// case it must be applied right away. The events which don't fit in the buffer are dropped.
+func (p *pendingEvents) buffer(c *Con) bool {
+	if p == nil || atomic.LoadInt32(&p.done) == 1 {
+		return false
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	// the events may have been applied while waiting for the lock
+	if p.done == 1 {
+		return false
+	}
+
+	atomic.AddInt64(&p.buffered, 1)
+	key := coalescerKey{c.NetNS, c.ID}
+	if i, ok := p.byID[key]; ok && c.ID != 0 {
+		p.events[i] = *c
+		return true
+	}
+	if len(p.events) >= p.maxSize {
+		atomic.AddInt64(&p.dropped, 1)
+		return true
+	}
+	if c.ID != 0 {
+		p.byID[key] = len(p.events)
+	}
+	p.events = append(p.events, *c)
+	return true
+}
+
+// apply calls fn with the events buffered, in order, and stops the buffering. The events read in the meantime
+// wait for the events buffered to be applied. It returns whether events were dropped.
+func (p *pendingEvents) apply(fn func(c *Con)) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for i := range p.events {
+		fn(&p.events[i])
+	}
+	p.events, p.byID = nil, nil
+	atomic.StoreInt32(&p.done, 1)
+	return atomic.LoadInt64(&p.dropped) > 0
+}
+
+func (p *pendingEvents) getStats() map[string]int64 {
+	return map[string]int64{
+		"initial_dump_events_buffered": atomic.LoadInt64(&p.buffered),
+		"initial_dump_events_dropped":  atomic.LoadInt64(&p.dropped),
+	}
+}
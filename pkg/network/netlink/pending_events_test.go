@@ -0,0 +1,82 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingEvents(t *testing.T) {
+	var nilPending *pendingEvents
+	c := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8443, 443)
+	assert.False(t, nilPending.buffer(&c))
+
+	p := newPendingEvents(2)
+	c.ID = 1
+	c.EventType = EventNew
+	require.True(t, p.buffer(&c))
+
+	// the event buffered doesn't share the memory of the event read, which the decoder re-uses
+	c.Origin.src = keyAddrFromString("10.0.0.2")
+	other := makeTranslatedConn(net.ParseIP("10.0.0.3"), net.ParseIP("10.244.1.6"), net.ParseIP("10.96.0.1"), 6, 40001, 8443, 443)
+	require.True(t, p.buffer(&other))
+
+	// the last event of an entry replaces the previous one in place
+	destroy := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8443, 443)
+	destroy.ID = 1
+	destroy.EventType = EventDestroy
+	require.True(t, p.buffer(&destroy))
+
+	// the buffer is full
+	third := makeTranslatedConn(net.ParseIP("10.0.0.4"), net.ParseIP("10.244.1.7"), net.ParseIP("10.96.0.1"), 6, 40002, 8443, 443)
+	require.True(t, p.buffer(&third))
+
+	var applied []Con
+	assert.True(t, p.apply(func(c *Con) {
+		applied = append(applied, *c)
+	}))
+	require.Len(t, applied, 2)
+	assert.Equal(t, EventDestroy, applied[0].EventType)
+	assert.Equal(t, "10.0.0.1", applied[0].Origin.Src().String())
+	assert.Equal(t, "10.0.0.3", applied[1].Origin.Src().String())
+
+	// the events aren't buffered once applied
+	assert.False(t, p.buffer(&third))
+	assert.Equal(t, map[string]int64{
+		"initial_dump_events_buffered": 4,
+		"initial_dump_events_dropped":  1,
+	}, p.getStats())
+}
+
+func TestApplyPendingEvents(t *testing.T) {
+	rt := newConntracker()
+	rt.pending = newPendingEvents(10)
+	closed := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8443, 443)
+	closed.ID = 1
+	opened := makeTranslatedConn(net.ParseIP("10.0.0.2"), net.ParseIP("10.244.1.6"), net.ParseIP("10.96.0.1"), 6, 40000, 8443, 443)
+	opened.ID = 2
+	closedConn := network.ConnectionStats{Source: util.AddressFromString("10.0.0.1"), SPort: 40000, Dest: util.AddressFromString("10.96.0.1"), DPort: 443, Type: network.TCP}
+	openedConn := network.ConnectionStats{Source: util.AddressFromString("10.0.0.2"), SPort: 40000, Dest: util.AddressFromString("10.96.0.1"), DPort: 443, Type: network.TCP}
+
+	// the entry is destroyed while the dump which still holds it is read, and another one is created
+	destroy := closed
+	destroy.EventType = EventDestroy
+	require.True(t, rt.pending.buffer(&destroy))
+	opened.EventType = EventNew
+	require.True(t, rt.pending.buffer(&opened))
+	rt.register(closed)
+	assert.Nil(t, rt.GetTranslationForConn(openedConn))
+
+	rt.applyPendingEvents()
+	assert.Nil(t, rt.GetTranslationForConn(closedConn))
+	require.NotNil(t, rt.GetTranslationForConn(openedConn))
+	assert.Equal(t, util.AddressFromString("10.244.1.6"), rt.GetTranslationForConn(openedConn).ReplSrcIP)
+	assert.Equal(t, int64(2), rt.pending.getStats()["initial_dump_events_buffered"])
+}
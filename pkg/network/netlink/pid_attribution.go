@@ -0,0 +1,158 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+)
+
+// pidConntracker sets the process which created the connections on the translations returned by the wrapped
+// Conntracker. The pid of the connections the tracer looks up, which it knows from their sockets, is remembered until
+// they are closed, so the lookups of the same connections without it (eg. from the conntrack lookup service) and
+// the dumps of the cache are attributed as well. The connections are remembered whatever their network namespace,
+// like the lookups without it.
+type pidConntracker struct {
+	Conntracker
+	sync.RWMutex
+
+	pids    map[connKey]uint32
+	maxSize int
+
+	stats struct {
+		attributed   int64
+		connsDropped int64
+	}
+}
+
+// NewPIDConntracker returns a Conntracker setting Pid on the translations returned by the given Conntracker, from the
+// pid of the connections looked up. Up to Config.MaxStateSize connections are remembered.
+func NewPIDConntracker(ctr Conntracker, cfg *Config) Conntracker {
+	return &pidConntracker{
+		Conntracker: ctr,
+		pids:        make(map[connKey]uint32),
+		maxSize:     cfg.MaxStateSize,
+	}
+}
+
+func (ctr *pidConntracker) GetTranslationForConn(c network.ConnectionStats) *network.IPTranslation {
+	t := ctr.Conntracker.GetTranslationForConn(c)
+	if t == nil {
+		return nil
+	}
+	k := connStatsToConnKey(c)
+	if c.Pid != 0 {
+		ctr.Lock()
+		ctr.learn(k, c.Pid)
+		ctr.Unlock()
+	}
+	return ctr.withPid(k, c.Pid, t)
+}
+
+func (ctr *pidConntracker) GetTranslationsForConns(conns []network.ConnectionStats) []*network.IPTranslation {
+	translations := ctr.Conntracker.GetTranslationsForConns(conns)
+
+	ctr.Lock()
+	for i := range translations {
+		if translations[i] != nil && conns[i].Pid != 0 {
+			ctr.learn(connStatsToConnKey(conns[i]), conns[i].Pid)
+		}
+	}
+	ctr.Unlock()
+
+	for i := range translations {
+		if translations[i] != nil {
+			translations[i] = ctr.withPid(connStatsToConnKey(conns[i]), conns[i].Pid, translations[i])
+		}
+	}
+	return translations
+}
+
+func (ctr *pidConntracker) GetEntryForConn(c network.ConnectionStats) *ConntrackEntry {
+	e := ctr.Conntracker.GetEntryForConn(c)
+	if e != nil && e.Translation != nil {
+		e.Translation = ctr.withPid(connStatsToConnKey(c), c.Pid, e.Translation)
+	}
+	return e
+}
+
+// learn remembers the pid of a translated connection. It must be called with the write lock held.
+func (ctr *pidConntracker) learn(k connKey, pid uint32) {
+	if _, ok := ctr.pids[k]; !ok && ctr.maxSize > 0 && len(ctr.pids) >= ctr.maxSize {
+		atomic.AddInt64(&ctr.stats.connsDropped, 1)
+		return
+	}
+	ctr.pids[k] = pid
+}
+
+// withPid returns the given translation along with the pid of its connection, the given one or else the one
+// remembered for it
+func (ctr *pidConntracker) withPid(k connKey, pid uint32, t *network.IPTranslation) *network.IPTranslation {
+	if pid == 0 {
+		ctr.RLock()
+		pid = ctr.pids[k]
+		ctr.RUnlock()
+		if pid == 0 {
+			return t
+		}
+	}
+	atomic.AddInt64(&ctr.stats.attributed, 1)
+
+	// translations returned by the wrapped Conntracker may be shared, so they are never modified
+	attributed := *t
+	attributed.Pid = pid
+	return &attributed
+}
+
+func (ctr *pidConntracker) DeleteTranslation(c network.ConnectionStats) {
+	ctr.Conntracker.DeleteTranslation(c)
+
+	ctr.Lock()
+	delete(ctr.pids, connStatsToConnKey(c))
+	ctr.Unlock()
+}
+
+func (ctr *pidConntracker) DeleteTranslations(conns []network.ConnectionStats) {
+	ctr.Conntracker.DeleteTranslations(conns)
+
+	ctr.Lock()
+	defer ctr.Unlock()
+	for i := range conns {
+		delete(ctr.pids, connStatsToConnKey(conns[i]))
+	}
+}
+
+// DumpCachedTable returns the entries of the wrapped Conntracker, along with the pid of the connections of their
+// origin when it is known
+func (ctr *pidConntracker) DumpCachedTable() []network.DebugConntrackEntry {
+	entries := ctr.Conntracker.DumpCachedTable()
+
+	ctr.RLock()
+	defer ctr.RUnlock()
+	for i := range entries {
+		c, err := network.ConntrackLookupConn{Proto: entries[i].Proto, DebugConntrackTuple: entries[i].Origin}.ConnectionStats()
+		if err != nil {
+			continue
+		}
+		entries[i].Pid = ctr.pids[connStatsToConnKey(c)]
+	}
+	return entries
+}
+
+func (ctr *pidConntracker) Describe() network.ConntrackDescription {
+	return withLayer(ctr.Conntracker.Describe(), "pids")
+}
+
+func (ctr *pidConntracker) GetStats() Stats {
+	s := ctr.Conntracker.GetStats()
+	ctr.RLock()
+	size := len(ctr.pids)
+	ctr.RUnlock()
+	s.set("pid_state_size", int64(size))
+	s.set("pid_translations_attributed", atomic.LoadInt64(&ctr.stats.attributed))
+	s.set("pid_conns_dropped", atomic.LoadInt64(&ctr.stats.connsDropped))
+	return s
+}
@@ -0,0 +1,73 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPIDConntracker(t *testing.T) {
+	rt := newConntracker()
+	// 10.0.1.5:40000 -> 10.96.0.1:443 translated to 10.244.1.5:8443, and the same from port 40001
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.1.5"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8443, 443))
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.1.5"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40001, 8443, 443))
+	ctr := NewPIDConntracker(rt, &Config{MaxStateSize: 1}).(*pidConntracker)
+
+	c := network.ConnectionStats{
+		Pid:    4242,
+		Source: util.AddressFromString("10.0.1.5"),
+		SPort:  40000,
+		Dest:   util.AddressFromString("10.96.0.1"),
+		DPort:  443,
+		Type:   network.TCP,
+	}
+	other := c
+	other.SPort = 40001
+	other.Pid = 4343
+	untranslated := c
+	untranslated.Dest = util.AddressFromString("1.1.1.1")
+
+	translations := ctr.GetTranslationsForConns([]network.ConnectionStats{c, other, untranslated})
+	require.Len(t, translations, 3)
+	require.NotNil(t, translations[0])
+	assert.Equal(t, uint32(4242), translations[0].Pid)
+	assert.Equal(t, util.AddressFromString("10.244.1.5"), translations[0].ReplSrcIP)
+	require.NotNil(t, translations[1])
+	assert.Equal(t, uint32(4343), translations[1].Pid)
+	assert.Nil(t, translations[2])
+	// the cached translations are left untouched
+	assert.Zero(t, rt.GetTranslationForConn(c).Pid)
+
+	// the connections are attributed without their pid once it was learnt, up to the maximum size
+	assert.Len(t, ctr.pids, 1)
+	assert.Equal(t, int64(1), ctr.stats.connsDropped)
+	anonymous := c
+	anonymous.Pid = 0
+	assert.Equal(t, uint32(4242), ctr.GetTranslationForConn(anonymous).Pid)
+	e := ctr.GetEntryForConn(anonymous)
+	require.NotNil(t, e)
+	assert.Equal(t, uint32(4242), e.Translation.Pid)
+	other.Pid = 0
+	assert.Zero(t, ctr.GetTranslationForConn(other).Pid)
+
+	var attributed int
+	for _, e := range ctr.DumpCachedTable() {
+		if e.Pid != 0 {
+			assert.Equal(t, uint32(4242), e.Pid)
+			assert.Equal(t, uint16(40000), e.Origin.SPort)
+			attributed++
+		}
+	}
+	assert.Equal(t, 1, attributed)
+
+	// the pid of the closed connections is forgotten
+	ctr.DeleteTranslation(c)
+	assert.Empty(t, ctr.pids)
+}
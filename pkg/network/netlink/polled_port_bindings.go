@@ -0,0 +1,144 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// PortBindingSource lists the ports published on the host, eg. from the container runtime
+type PortBindingSource interface {
+	// Name is the name of the source, which the errors are reported with
+	Name() string
+	// PortBindings returns the ports currently published
+	PortBindings() ([]PortBinding, error)
+}
+
+// polledPortBindings resolves the ports published on the host from the bindings listed by a source periodically
+type polledPortBindings struct {
+	sync.RWMutex
+	table *portBindingTable
+
+	source     PortBindingSource
+	pollTicker *time.Ticker
+	exit       chan struct{}
+
+	stats struct {
+		polls      int64
+		pollErrors int64
+	}
+}
+
+// polledPortBindingConntracker is a portBindingConntracker stopping the polling of the source on Close
+type polledPortBindingConntracker struct {
+	portBindingConntracker
+	bindings *polledPortBindings
+}
+
+// NewPolledPortBindingConntracker returns a Conntracker falling back to the port bindings listed by the source,
+// every interval, for the connections to published ports the given Conntracker doesn't have a translation for
+func NewPolledPortBindingConntracker(ctr Conntracker, source PortBindingSource, interval time.Duration) (Conntracker, error) {
+	bindings := &polledPortBindings{
+		table:  newPortBindingTable(nil, nil),
+		source: source,
+		exit:   make(chan struct{}),
+	}
+	if err := bindings.poll(); err != nil {
+		return nil, fmt.Errorf("could not list the port bindings of %s: %w", source.Name(), err)
+	}
+
+	bindings.pollTicker = time.NewTicker(interval)
+	go bindings.run()
+
+	log.Infof("initialized %s port bindings conntracker", source.Name())
+	return &polledPortBindingConntracker{
+		portBindingConntracker: portBindingConntracker{
+			Conntracker: ctr,
+			resolver:    bindings,
+		},
+		bindings: bindings,
+	}, nil
+}
+
+func (ctr *polledPortBindingConntracker) GetStats() Stats {
+	s := ctr.portBindingConntracker.GetStats()
+
+	ctr.bindings.RLock()
+	s.set("port_bindings", int64(ctr.bindings.table.len()))
+	ctr.bindings.RUnlock()
+	s.set("port_bindings_polls_total", atomic.LoadInt64(&ctr.bindings.stats.polls))
+	s.set("port_bindings_poll_errors_total", atomic.LoadInt64(&ctr.bindings.stats.pollErrors))
+	return s
+}
+
+func (ctr *polledPortBindingConntracker) Close() {
+	ctr.bindings.pollTicker.Stop()
+	close(ctr.bindings.exit)
+	ctr.portBindingConntracker.Close()
+}
+
+func (b *polledPortBindings) ResolvePortBinding(addr util.Address, port uint16, transport network.ConnectionType) (PortBinding, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	return b.table.ResolvePortBinding(addr, port, transport)
+}
+
+func (b *polledPortBindings) run() {
+	for {
+		select {
+		case <-b.pollTicker.C:
+			if err := b.poll(); err != nil {
+				log.Warnf("could not list the port bindings of %s: %s", b.source.Name(), err)
+			}
+		case <-b.exit:
+			return
+		}
+	}
+}
+
+// poll replaces the bindings with the ones listed by the source, along with the addresses of the host
+func (b *polledPortBindings) poll() error {
+	atomic.AddInt64(&b.stats.polls, 1)
+
+	bindings, err := b.source.PortBindings()
+	if err != nil {
+		atomic.AddInt64(&b.stats.pollErrors, 1)
+		return err
+	}
+	hostIPs, err := hostAddresses()
+	if err != nil {
+		atomic.AddInt64(&b.stats.pollErrors, 1)
+		return err
+	}
+	table := newPortBindingTable(bindings, hostIPs)
+
+	b.Lock()
+	b.table = table
+	b.Unlock()
+	return nil
+}
+
+// hostAddresses returns the addresses of the interfaces of the host, which the ports published on all the
+// addresses are reached at
+func hostAddresses() ([]util.Address, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]util.Address, 0, len(addrs))
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, util.AddressFromNetIP(ipnet.IP))
+		}
+	}
+	return ips, nil
+}
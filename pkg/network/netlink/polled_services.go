@@ -0,0 +1,141 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// ServiceAddress is an address a Kubernetes service is exposed at, eg. its ClusterIP and one of its ports
+type ServiceAddress struct {
+	IP        util.Address
+	Port      uint16
+	Transport network.ConnectionType
+	// Service is the name of the service, "namespace/name"
+	Service string
+}
+
+// ServiceAddressSource lists the addresses the Kubernetes services are exposed at, eg. from the cluster agent
+type ServiceAddressSource interface {
+	// Name is the name of the source, which the errors are reported with
+	Name() string
+	// ServiceAddresses returns the addresses of the services currently exposed
+	ServiceAddresses() ([]ServiceAddress, error)
+}
+
+type serviceAddressKey struct {
+	ip        util.Address
+	port      uint16
+	transport network.ConnectionType
+}
+
+// polledServices resolves the services from the addresses listed by a source periodically
+type polledServices struct {
+	sync.RWMutex
+	services map[serviceAddressKey]string
+
+	source     ServiceAddressSource
+	pollTicker *time.Ticker
+	exit       chan struct{}
+
+	stats struct {
+		polls      int64
+		pollErrors int64
+	}
+}
+
+// polledServiceConntracker is a serviceConntracker stopping the polling of the source on Close
+type polledServiceConntracker struct {
+	serviceConntracker
+	services *polledServices
+}
+
+// NewPolledServiceConntracker returns a Conntracker setting the Service of the translations returned by the given
+// Conntracker, from the service addresses listed by the source every interval. The source failing to list them, eg.
+// because it isn't reachable yet, leaves the services unresolved until it does.
+func NewPolledServiceConntracker(ctr Conntracker, source ServiceAddressSource, interval time.Duration) Conntracker {
+	services := &polledServices{
+		services: make(map[serviceAddressKey]string),
+		source:   source,
+		exit:     make(chan struct{}),
+	}
+	if err := services.poll(); err != nil {
+		log.Warnf("could not list the services of %s: %s", source.Name(), err)
+	}
+
+	services.pollTicker = time.NewTicker(interval)
+	go services.run()
+
+	log.Infof("initialized %s services conntracker", source.Name())
+	return &polledServiceConntracker{
+		serviceConntracker: serviceConntracker{
+			Conntracker: ctr,
+			resolver:    services,
+		},
+		services: services,
+	}
+}
+
+func (ctr *polledServiceConntracker) GetStats() Stats {
+	s := ctr.serviceConntracker.GetStats()
+
+	ctr.services.RLock()
+	s.set("service_addresses", int64(len(ctr.services.services)))
+	ctr.services.RUnlock()
+	s.set("service_addresses_polls_total", atomic.LoadInt64(&ctr.services.stats.polls))
+	s.set("service_addresses_poll_errors_total", atomic.LoadInt64(&ctr.services.stats.pollErrors))
+	return s
+}
+
+func (ctr *polledServiceConntracker) Close() {
+	ctr.services.pollTicker.Stop()
+	close(ctr.services.exit)
+	ctr.serviceConntracker.Close()
+}
+
+func (s *polledServices) ResolveService(addr util.Address, port uint16, transport network.ConnectionType) (string, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	service, ok := s.services[serviceAddressKey{ip: addr, port: port, transport: transport}]
+	return service, ok
+}
+
+func (s *polledServices) run() {
+	for {
+		select {
+		case <-s.pollTicker.C:
+			if err := s.poll(); err != nil {
+				log.Warnf("could not list the services of %s: %s", s.source.Name(), err)
+			}
+		case <-s.exit:
+			return
+		}
+	}
+}
+
+// poll replaces the services with the ones listed by the source
+func (s *polledServices) poll() error {
+	atomic.AddInt64(&s.stats.polls, 1)
+
+	addrs, err := s.source.ServiceAddresses()
+	if err != nil {
+		atomic.AddInt64(&s.stats.pollErrors, 1)
+		return err
+	}
+	services := make(map[serviceAddressKey]string, len(addrs))
+	for _, a := range addrs {
+		services[serviceAddressKey{ip: a.IP, port: a.Port, transport: a.Transport}] = a.Service
+	}
+
+	s.Lock()
+	s.services = services
+	s.Unlock()
+	return nil
+}
@@ -0,0 +1,151 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+import (
+	"sync/atomic"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+// PortBinding is a container port published on a port of the host, eg. by docker on its bridge network
+type PortBinding struct {
+	// HostIP is nil when the port is published on all the addresses of the host
+	HostIP   util.Address
+	HostPort uint16
+
+	ContainerIP   util.Address
+	ContainerPort uint16
+	Transport     network.ConnectionType
+}
+
+// PortBindingResolver maps the ports published on the host to the container ports they are forwarded to
+type PortBindingResolver interface {
+	// ResolvePortBinding returns the binding of the port published at the given address and port of the host
+	ResolvePortBinding(addr util.Address, port uint16, transport network.ConnectionType) (PortBinding, bool)
+}
+
+type portBindingKey struct {
+	ip        util.Address
+	port      uint16
+	transport network.ConnectionType
+}
+
+// portBindingTable is a PortBindingResolver over a fixed set of bindings
+type portBindingTable struct {
+	bindings map[portBindingKey]PortBinding
+	// hostIPs are the addresses of the host, which the bindings published on all the addresses are reached at
+	hostIPs map[util.Address]struct{}
+}
+
+// newPortBindingTable returns a table of the given bindings, published on the host of the given addresses
+func newPortBindingTable(bindings []PortBinding, hostIPs []util.Address) *portBindingTable {
+	t := &portBindingTable{
+		bindings: make(map[portBindingKey]PortBinding, len(bindings)),
+		hostIPs:  make(map[util.Address]struct{}, len(hostIPs)),
+	}
+	for _, b := range bindings {
+		t.bindings[portBindingKey{ip: b.HostIP, port: b.HostPort, transport: b.Transport}] = b
+	}
+	for _, ip := range hostIPs {
+		t.hostIPs[ip] = struct{}{}
+	}
+	return t
+}
+
+func (t *portBindingTable) ResolvePortBinding(addr util.Address, port uint16, transport network.ConnectionType) (PortBinding, bool) {
+	if b, ok := t.bindings[portBindingKey{ip: addr, port: port, transport: transport}]; ok {
+		return b, true
+	}
+	if _, ok := t.hostIPs[addr]; !ok {
+		return PortBinding{}, false
+	}
+	b, ok := t.bindings[portBindingKey{port: port, transport: transport}]
+	return b, ok
+}
+
+func (t *portBindingTable) len() int {
+	return len(t.bindings)
+}
+
+// portBindingConntracker resolves the translation of the connections to the ports published by containers from
+// their bindings when the wrapped Conntracker doesn't know about them, eg. because the connection is forwarded by
+// docker-proxy rather than NATed, or its conntrack event was missed or not received yet.
+// Only the connections initiated towards the published ports are resolved: the connections seen from the container
+// can't be told apart from the ones reaching it directly on its network, which aren't translated.
+type portBindingConntracker struct {
+	Conntracker
+	resolver PortBindingResolver
+
+	stats struct {
+		resolved int64
+	}
+}
+
+// NewPortBindingConntracker returns a Conntracker falling back to the given PortBindingResolver for the connections
+// to published container ports the given Conntracker doesn't have a translation for
+func NewPortBindingConntracker(ctr Conntracker, resolver PortBindingResolver) Conntracker {
+	return &portBindingConntracker{
+		Conntracker: ctr,
+		resolver:    resolver,
+	}
+}
+
+func (ctr *portBindingConntracker) GetTranslationForConn(c network.ConnectionStats) *network.IPTranslation {
+	if t := ctr.Conntracker.GetTranslationForConn(c); t != nil {
+		return t
+	}
+	return ctr.resolve(c)
+}
+
+func (ctr *portBindingConntracker) GetTranslationsForConns(conns []network.ConnectionStats) []*network.IPTranslation {
+	translations := ctr.Conntracker.GetTranslationsForConns(conns)
+	for i := range translations {
+		if translations[i] == nil {
+			translations[i] = ctr.resolve(conns[i])
+		}
+	}
+	return translations
+}
+
+func (ctr *portBindingConntracker) GetEntryForConn(c network.ConnectionStats) *ConntrackEntry {
+	if e := ctr.Conntracker.GetEntryForConn(c); e != nil {
+		return e
+	}
+	t := ctr.resolve(c)
+	if t == nil {
+		return nil
+	}
+	return newConntrackEntry(c, t)
+}
+
+// resolve returns the translation of c when its destination is a published port: its destination is translated
+// to the container port, and its replies are sent back to its source
+func (ctr *portBindingConntracker) resolve(c network.ConnectionStats) *network.IPTranslation {
+	b, ok := ctr.resolver.ResolvePortBinding(util.UnmapIPv4(c.Dest), c.DPort, c.Type)
+	if !ok {
+		return nil
+	}
+	atomic.AddInt64(&ctr.stats.resolved, 1)
+
+	t := &network.IPTranslation{
+		ReplSrcIP:   b.ContainerIP,
+		ReplSrcPort: b.ContainerPort,
+		ReplDstIP:   c.Source,
+		ReplDstPort: c.SPort,
+	}
+	t.NATType = natType(connStatsToConnKey(c), t)
+	return t
+}
+
+func (ctr *portBindingConntracker) Describe() network.ConntrackDescription {
+	return withLayer(ctr.Conntracker.Describe(), "port_bindings")
+}
+
+func (ctr *portBindingConntracker) GetStats() Stats {
+	s := ctr.Conntracker.GetStats()
+	s.set("port_bindings_resolved", atomic.LoadInt64(&ctr.stats.resolved))
+	return s
+}
@@ -0,0 +1,94 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPortBindingTable(t *testing.T) {
+	table := newPortBindingTable([]PortBinding{
+		// -p 8080:80
+		{HostPort: 8080, ContainerIP: util.AddressFromString("172.17.0.2"), ContainerPort: 80, Transport: network.TCP},
+		// -p 10.0.0.5:5353:53/udp
+		{HostIP: util.AddressFromString("10.0.0.5"), HostPort: 5353, ContainerIP: util.AddressFromString("172.17.0.3"), ContainerPort: 53, Transport: network.UDP},
+	}, []util.Address{util.AddressFromString("127.0.0.1"), util.AddressFromString("10.0.0.5")})
+
+	b, ok := table.ResolvePortBinding(util.AddressFromString("10.0.0.5"), 8080, network.TCP)
+	require.True(t, ok)
+	assert.Equal(t, util.AddressFromString("172.17.0.2"), b.ContainerIP)
+	assert.Equal(t, uint16(80), b.ContainerPort)
+
+	_, ok = table.ResolvePortBinding(util.AddressFromString("127.0.0.1"), 8080, network.TCP)
+	assert.True(t, ok)
+
+	// the port isn't published on the addresses of other hosts, nor for the other transport
+	_, ok = table.ResolvePortBinding(util.AddressFromString("10.0.0.6"), 8080, network.TCP)
+	assert.False(t, ok)
+	_, ok = table.ResolvePortBinding(util.AddressFromString("10.0.0.5"), 8080, network.UDP)
+	assert.False(t, ok)
+
+	b, ok = table.ResolvePortBinding(util.AddressFromString("10.0.0.5"), 5353, network.UDP)
+	require.True(t, ok)
+	assert.Equal(t, uint16(53), b.ContainerPort)
+	_, ok = table.ResolvePortBinding(util.AddressFromString("127.0.0.1"), 5353, network.UDP)
+	assert.False(t, ok)
+}
+
+func TestPortBindingConntracker(t *testing.T) {
+	rt := newConntracker()
+	// 10.0.0.9:40000 -> 10.0.0.5:9090 translated by conntrack to 172.17.0.4:90
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.9"), net.ParseIP("172.17.0.4"), net.ParseIP("10.0.0.5"), 6, 40000, 90, 9090))
+
+	table := newPortBindingTable([]PortBinding{
+		{HostPort: 8080, ContainerIP: util.AddressFromString("172.17.0.2"), ContainerPort: 80, Transport: network.TCP},
+		{HostPort: 9090, ContainerIP: util.AddressFromString("172.17.0.9"), ContainerPort: 99, Transport: network.TCP},
+	}, []util.Address{util.AddressFromString("10.0.0.5")})
+	ctr := NewPortBindingConntracker(rt, table).(*portBindingConntracker)
+
+	proxied := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.5"),
+		SPort:  50000,
+		Dest:   util.AddressFromString("10.0.0.5"),
+		DPort:  8080,
+		Type:   network.TCP,
+	}
+	trans := ctr.GetTranslationForConn(proxied)
+	require.NotNil(t, trans)
+	assert.Equal(t, util.AddressFromString("172.17.0.2"), trans.ReplSrcIP)
+	assert.Equal(t, uint16(80), trans.ReplSrcPort)
+	assert.Equal(t, util.AddressFromString("10.0.0.5"), trans.ReplDstIP)
+	assert.Equal(t, uint16(50000), trans.ReplDstPort)
+	assert.Equal(t, network.DNAT, trans.NATType)
+
+	e := ctr.GetEntryForConn(proxied)
+	require.NotNil(t, e)
+	assert.Equal(t, trans, e.Translation)
+
+	// the translation conntrack has wins over the binding
+	natted := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.9"),
+		SPort:  40000,
+		Dest:   util.AddressFromString("10.0.0.5"),
+		DPort:  9090,
+		Type:   network.TCP,
+	}
+	unpublished := proxied
+	unpublished.DPort = 8081
+
+	translations := ctr.GetTranslationsForConns([]network.ConnectionStats{proxied, natted, unpublished})
+	require.Len(t, translations, 3)
+	require.NotNil(t, translations[0])
+	require.NotNil(t, translations[1])
+	assert.Equal(t, util.AddressFromString("172.17.0.4"), translations[1].ReplSrcIP)
+	assert.Nil(t, translations[2])
+
+	assert.Equal(t, int64(3), ctr.stats.resolved)
+}
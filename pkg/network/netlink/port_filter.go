@@ -0,0 +1,93 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// portRange is an inclusive range of ports
+type portRange struct {
+	low, high uint16
+}
+
+// portFilter restricts the conntrack entries decoded to the ones whose origin ports are in the configured ranges.
+// It is applied by the Decoder, so entries filtered out are never handed over to the conntracker.
+type portFilter struct {
+	include []portRange
+	exclude []portRange
+
+	// filtered is the number of entries filtered out so far
+	filtered int64
+}
+
+// newPortFilter returns a filter keeping the entries with an origin port in one of the include ranges (or all of them
+// if there is none), unless one of their origin ports is in one of the exclude ranges. Ranges are either a single port
+// ("53") or two ports separated by a dash ("32768-65535"). nil is returned when there is nothing to filter.
+func newPortFilter(include, exclude []string) (*portFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	f := &portFilter{}
+	var err error
+	if f.include, err = parsePortRanges(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = parsePortRanges(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parsePortRanges(ranges []string) ([]portRange, error) {
+	parsed := make([]portRange, 0, len(ranges))
+	for _, r := range ranges {
+		low, high := r, r
+		if i := strings.IndexByte(r, '-'); i >= 0 {
+			low, high = r[:i], r[i+1:]
+		}
+
+		l, err := strconv.ParseUint(strings.TrimSpace(low), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port range %q: %w", r, err)
+		}
+		h, err := strconv.ParseUint(strings.TrimSpace(high), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port range %q: %w", r, err)
+		}
+		if l > h {
+			return nil, fmt.Errorf("invalid port range %q: %d is greater than %d", r, l, h)
+		}
+		parsed = append(parsed, portRange{low: uint16(l), high: uint16(h)})
+	}
+	return parsed, nil
+}
+
+// matches returns whether the conntrack entry should be kept, and counts the entries filtered out
+func (f *portFilter) matches(c *Con) bool {
+	if f == nil || !c.Origin.hasPorts() {
+		return true
+	}
+
+	sport, dport := c.Origin.srcPort, c.Origin.dstPort
+	if inPortRanges(f.exclude, sport) || inPortRanges(f.exclude, dport) ||
+		(len(f.include) > 0 && !inPortRanges(f.include, sport) && !inPortRanges(f.include, dport)) {
+		atomic.AddInt64(&f.filtered, 1)
+		return false
+	}
+	return true
+}
+
+func inPortRanges(ranges []portRange, port uint16) bool {
+	for _, r := range ranges {
+		if port >= r.low && port <= r.high {
+			return true
+		}
+	}
+	return false
+}
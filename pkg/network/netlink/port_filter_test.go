@@ -0,0 +1,59 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePortRanges(t *testing.T) {
+	ranges, err := parsePortRanges([]string{"53", "1024-32767", " 8000 - 8080 "})
+	require.NoError(t, err)
+	assert.Equal(t, []portRange{{53, 53}, {1024, 32767}, {8000, 8080}}, ranges)
+
+	for _, invalid := range []string{"", "http", "70000", "100-10", "1-2-3"} {
+		_, err = parsePortRanges([]string{invalid})
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestPortFilter(t *testing.T) {
+	f, err := newPortFilter(nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, f)
+
+	// only track ports below 32768, but not DNS
+	f, err = newPortFilter([]string{"1-32767"}, []string{"53"})
+	require.NoError(t, err)
+
+	assert.True(t, f.matches(conWithPorts(45678, 443)))
+	assert.False(t, f.matches(conWithPorts(45678, 53)))
+	assert.False(t, f.matches(conWithPorts(45678, 50000)))
+	assert.EqualValues(t, 2, f.filtered)
+}
+
+func TestDecoderFiltersPorts(t *testing.T) {
+	// natEventPayload is orig_src=10.0.2.15:58472 orig_dst=2.2.2.2:5432
+	e := Event{msgs: []netlink.Message{{Data: natEventPayload}}}
+
+	decoded := 0
+	decoder := NewDecoder()
+	decoder.ports, _ = newPortFilter(nil, []string{"5432"})
+	decoder.DecodeAndReleaseEvent(e, func(c *Con) { decoded++ })
+	assert.Zero(t, decoded)
+
+	decoder.ports, _ = newPortFilter([]string{"5000-6000"}, nil)
+	decoder.DecodeAndReleaseEvent(e, func(c *Con) { decoded++ })
+	assert.Equal(t, 1, decoded)
+}
+
+func conWithPorts(sport, dport uint16) *Con {
+	c := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("1.1.1.1"), net.ParseIP("2.2.2.2"), 17, sport, dport, dport)
+	return &c
+}
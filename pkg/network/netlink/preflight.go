@@ -0,0 +1,116 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+	"github.com/mdlayher/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	nfConntrackModule        = "nf_conntrack"
+	nfConntrackNetlinkModule = "nf_conntrack_netlink"
+
+	// ipctnlMsgCtGetStats is the Conntrack message type used to get the global conntrack stats.
+	// It is used to make sure ctnetlink is available.
+	ipctnlMsgCtGetStats = 5
+)
+
+// PreflightError is returned by NewConntracker when the kernel doesn't provide what is needed to track conntrack entries
+type PreflightError struct {
+	// Module is the kernel module which is missing
+	Module string
+	Err    error
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("conntrack is not available, the %s kernel module is not loaded: %s", e.Module, e.Err)
+}
+
+func (e *PreflightError) Unwrap() error {
+	return e.Err
+}
+
+// checkConntrackAvailable makes sure both nf_conntrack and ctnetlink are available in the root network namespace.
+// When modprobe is true, missing modules are loaded if the agent has the permission to.
+func checkConntrackAvailable(procRoot string, modprobe bool) error {
+	checks := []struct {
+		module string
+		check  func(procRoot string) error
+	}{
+		{nfConntrackModule, checkNfConntrack},
+		{nfConntrackNetlinkModule, checkCtnetlink},
+	}
+
+	for _, c := range checks {
+		err := c.check(procRoot)
+		if err == nil {
+			continue
+		}
+
+		if modprobe {
+			if out, mErr := exec.Command("modprobe", c.module).CombinedOutput(); mErr != nil {
+				log.Warnf("could not load the %s kernel module: %s: %s", c.module, mErr, out)
+			} else if err = c.check(procRoot); err == nil {
+				log.Infof("loaded the %s kernel module", c.module)
+				continue
+			}
+		}
+
+		return &PreflightError{Module: c.module, Err: err}
+	}
+
+	return nil
+}
+
+// checkNfConntrack checks the conntrack sysctls are present, which is only the case once nf_conntrack is loaded
+func checkNfConntrack(procRoot string) error {
+	_, err := os.Stat(filepath.Join(procRoot, kernelConntrackSysctls["kernel_conntrack_max"]))
+	return err
+}
+
+// checkCtnetlink sends a request for the global conntrack stats, which fails if ctnetlink isn't available
+func checkCtnetlink(procRoot string) error {
+	rootNS, err := netns.GetFromPath(filepath.Join(procRoot, "1/ns/net"))
+	if err != nil {
+		return fmt.Errorf("could not get root namespace: %w", err)
+	}
+	defer rootNS.Close()
+
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, &netlink.Config{NetNS: int(rootNS)})
+	if err != nil {
+		return fmt.Errorf("could not open netlink socket: %w", err)
+	}
+	defer conn.Close()
+
+	req := netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType((unix.NFNL_SUBSYS_CTNETLINK << 8) | ipctnlMsgCtGetStats),
+			Flags: netlink.Request | netlink.Acknowledge,
+		},
+		Data: []byte{unix.AF_UNSPEC, unix.NFNETLINK_V0, 0, 0},
+	}
+
+	_, err = conn.Execute(req)
+	return err
+}
+
+// ipv6Available returns whether IPv6 is enabled on the host. if_inet6 is missing when the ipv6 module isn't loaded
+// or is disabled on the kernel command line, and the disable_ipv6 sysctl is set when it is disabled at runtime.
+func ipv6Available(procRoot string) bool {
+	if _, err := os.Stat(filepath.Join(procRoot, "net/if_inet6")); err != nil {
+		return false
+	}
+	disabled, err := ioutil.ReadFile(filepath.Join(procRoot, "sys/net/ipv6/conf/all/disable_ipv6"))
+	return err != nil || strings.TrimSpace(string(disabled)) != "1"
+}
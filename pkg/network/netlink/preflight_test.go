@@ -0,0 +1,45 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreflightMissingNfConntrack(t *testing.T) {
+	procRoot, err := ioutil.TempDir("", "proc")
+	require.NoError(t, err)
+	defer os.RemoveAll(procRoot)
+
+	err = checkConntrackAvailable(procRoot, false)
+	var preflightErr *PreflightError
+	require.True(t, errors.As(err, &preflightErr))
+	assert.Equal(t, nfConntrackModule, preflightErr.Module)
+}
+
+func TestIPv6Available(t *testing.T) {
+	procRoot, err := ioutil.TempDir("", "proc")
+	require.NoError(t, err)
+	defer os.RemoveAll(procRoot)
+	assert.False(t, ipv6Available(procRoot))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(procRoot, "net"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(procRoot, "net/if_inet6"), nil, 0644))
+	assert.True(t, ipv6Available(procRoot))
+
+	// disabled at runtime
+	sysctl := filepath.Join(procRoot, "sys/net/ipv6/conf/all")
+	require.NoError(t, os.MkdirAll(sysctl, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sysctl, "disable_ipv6"), []byte("1\n"), 0644))
+	assert.False(t, ipv6Available(procRoot))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sysctl, "disable_ipv6"), []byte("0\n"), 0644))
+	assert.True(t, ipv6Available(procRoot))
+}
@@ -0,0 +1,61 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// translationReader looks the translations up in the state map, or in its published copy
+type translationReader interface {
+	get(k connKey) (translationValue, bool)
+}
+
+// publication is a copy of the state map published for lock-free reads. The keys whose translation was deleted or
+// modified in the state map since the copy was published are dropped from it, so its lookups miss them rather than
+// return a translation the state map doesn't have anymore.
+type publication struct {
+	state *connTable
+	// dropped holds the dropped keys, and drops counts them so the lookups don't go through it while it is empty
+	dropped sync.Map
+	drops   int32
+}
+
+func newPublication(state *connTable) *publication {
+	return &publication{state: state}
+}
+
+// drop hides the translation of the given keys from the lookups. It must be called with the write lock of the state
+// map held, before it is modified.
+func (p *publication) drop(keys ...connKey) {
+	for _, k := range keys {
+		if !p.state.contains(k) {
+			continue
+		}
+		if _, loaded := p.dropped.LoadOrStore(k, struct{}{}); !loaded {
+			atomic.AddInt32(&p.drops, 1)
+		}
+	}
+}
+
+// dropCount returns the number of keys dropped from the publication
+func (p *publication) dropCount() int {
+	return int(atomic.LoadInt32(&p.drops))
+}
+
+// publicationReader looks the translations up in a publication, and records whether any of the keys looked up was
+// dropped from it, in which case the translation resolved from it may be out of date
+type publicationReader struct {
+	p       *publication
+	dropped bool
+}
+
+func (r *publicationReader) get(k connKey) (translationValue, bool) {
+	if _, ok := r.p.dropped.Load(k); ok {
+		r.dropped = true
+		return translationValue{}, false
+	}
+	return r.p.state.get(k)
+}
@@ -0,0 +1,162 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+	"github.com/mdlayher/netlink"
+)
+
+const recordingVersion = 1
+
+// recordingHeader starts a recording, which is then made of a recordedEvent for each event
+type recordingHeader struct {
+	Version int
+	Created time.Time
+}
+
+// recordedEvent is an event read off a netlink socket, along with the time it was read at
+type recordedEvent struct {
+	Time     time.Time
+	NetNS    int32
+	Messages []recordedMessage
+}
+
+type recordedMessage struct {
+	Type  uint16
+	Flags uint16
+	Data  []byte
+}
+
+// recorder records the raw netlink messages of the events read by the consumer, both streamed and dumped,
+// so they can be replayed offline. A nil recorder records nothing.
+type recorder struct {
+	mux sync.Mutex
+	f   *os.File
+	enc *gob.Encoder
+	err error
+
+	recorded int64
+}
+
+// newRecorder creates the recording file at path, replacing any previous recording
+func newRecorder(path string) (*recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &recorder{f: f, enc: gob.NewEncoder(f)}
+	if err := r.enc.Encode(&recordingHeader{Version: recordingVersion, Created: time.Now()}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// record appends the given messages to the recording. Recording stops at the first write error.
+func (r *recorder) record(now time.Time, netns int32, msgs []netlink.Message) {
+	if r == nil || len(msgs) == 0 {
+		return
+	}
+
+	e := recordedEvent{Time: now, NetNS: netns, Messages: make([]recordedMessage, len(msgs))}
+	for i, m := range msgs {
+		e.Messages[i] = recordedMessage{Type: uint16(m.Header.Type), Flags: uint16(m.Header.Flags), Data: m.Data}
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.err != nil {
+		return
+	}
+	if r.err = r.enc.Encode(&e); r.err != nil {
+		log.Errorf("could not record conntrack events to %s, recording stopped: %s", r.f.Name(), r.err)
+		return
+	}
+	atomic.AddInt64(&r.recorded, 1)
+}
+
+func (r *recorder) close() {
+	if r == nil {
+		return
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if err := r.f.Close(); err != nil {
+		log.Warnf("could not close conntrack recording %s: %s", r.f.Name(), err)
+	}
+	r.err = os.ErrClosed
+}
+
+func (r *recorder) getStats() map[string]int64 {
+	return map[string]int64{
+		"recorded_events": atomic.LoadInt64(&r.recorded),
+	}
+}
+
+// replayEvents sends the events recorded to path to output, until they are all sent or exit is closed.
+// The intervals between the events are kept when paced is set, otherwise events are sent as fast as they are consumed.
+func replayEvents(path string, output chan<- Event, exit <-chan struct{}, paced bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(f)
+	var h recordingHeader
+	if err := dec.Decode(&h); err != nil {
+		return fmt.Errorf("could not decode recording header: %w", err)
+	}
+	if h.Version != recordingVersion {
+		return fmt.Errorf("unsupported recording version %d", h.Version)
+	}
+
+	var first, started time.Time
+	for {
+		var e recordedEvent
+		if err := dec.Decode(&e); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("could not decode recorded event: %w", err)
+		}
+
+		if paced {
+			if first.IsZero() {
+				first, started = e.Time, time.Now()
+			}
+			if wait := e.Time.Sub(first) - time.Since(started); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-exit:
+					return nil
+				}
+			}
+		}
+
+		msgs := make([]netlink.Message, len(e.Messages))
+		for i, m := range e.Messages {
+			msgs[i] = netlink.Message{
+				Header: netlink.Header{Type: netlink.HeaderType(m.Type), Flags: netlink.HeaderFlags(m.Flags)},
+				Data:   m.Data,
+			}
+		}
+
+		select {
+		case output <- Event{msgs: msgs, netns: e.NetNS}:
+		case <-exit:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conntrack")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "recording")
+
+	r, err := newRecorder(path)
+	require.NoError(t, err)
+
+	message := func(c Con, flags netlink.HeaderFlags) netlink.Message {
+		data, err := EncodeConn(&c)
+		require.NoError(t, err)
+		return netlink.Message{
+			Header: netlink.Header{Type: netlink.HeaderType(unix.NFNL_SUBSYS_CTNETLINK << 8), Flags: flags},
+			Data:   data,
+		}
+	}
+	created := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	other := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	now := time.Now()
+	r.record(now, noNSID, []netlink.Message{message(created, netlink.Create), message(other, netlink.Create)})
+	r.record(now.Add(time.Millisecond), noNSID, []netlink.Message{message(created, netlink.Create)})
+	r.close()
+	assert.Equal(t, int64(2), r.getStats()["recorded_events"])
+
+	// recording stops once closed
+	r.record(now, noNSID, []netlink.Message{message(created, netlink.Create)})
+	assert.Equal(t, int64(2), r.getStats()["recorded_events"])
+
+	c := newReplayConsumer(&Config{ReplayPath: path, TargetRateLimit: 500})
+	c.replayPaced = false
+	rt := newConntracker()
+	decoder := rt.newDecoder()
+	var events, entries int
+	for e := range c.Events() {
+		events++
+		decoder.DecodeAndReleaseEvent(e, func(c *Con) {
+			entries++
+			assert.Equal(t, EventNew, c.EventType)
+			rt.register(*c)
+		})
+	}
+	assert.Equal(t, 2, events)
+	assert.Equal(t, 3, entries)
+
+	assert.Equal(t, util.AddressFromString("20.0.0.1"), rt.GetTranslationForConn(network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("50.30.40.10"),
+		DPort:  80,
+		Type:   network.TCP,
+	}).ReplSrcIP)
+}
+
+func TestReplayStops(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conntrack")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "recording")
+
+	r, err := newRecorder(path)
+	require.NoError(t, err)
+	now := time.Now()
+	r.record(now, noNSID, []netlink.Message{{Data: []byte{0}}})
+	r.record(now.Add(time.Hour), noNSID, []netlink.Message{{Data: []byte{0}}})
+	r.close()
+
+	// the replay is paced, so the second event is only replayed in an hour
+	c := newReplayConsumer(&Config{ReplayPath: path})
+	events := c.Events()
+	<-events
+	c.Stop()
+	_, ok := <-events
+	assert.False(t, ok)
+}
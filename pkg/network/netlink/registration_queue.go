@@ -0,0 +1,66 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"sync/atomic"
+)
+
+// registrationQueue decouples the reads of the conntrack events off the netlink socket from their registration,
+// which takes the write lock of the state map. The decoded entries are copied into a bounded channel, which
+// a dedicated writer goroutine registers in order. When the writer can't keep up, the entries are dropped and
+// counted, so the contention on the lock never stalls the socket reads, which would make the kernel drop events
+// without telling which. A nil *registrationQueue queues nothing.
+type registrationQueue struct {
+	// entries are the entries queued, which hold their fields by value, so queueing doesn't allocate
+	entries chan Con
+
+	droppedRegisters int64
+	droppedDestroys  int64
+}
+
+// newRegistrationQueue returns a queue of the given number of entries, nil when the size isn't positive
+func newRegistrationQueue(size int) *registrationQueue {
+	if size <= 0 {
+		return nil
+	}
+	return &registrationQueue{entries: make(chan Con, size)}
+}
+
+// push copies the entry into the queue, and returns false when it was dropped because the queue is full
+func (q *registrationQueue) push(c *Con) bool {
+	select {
+	case q.entries <- *c:
+		return true
+	default:
+		if c.EventType == EventDestroy {
+			atomic.AddInt64(&q.droppedDestroys, 1)
+		} else {
+			atomic.AddInt64(&q.droppedRegisters, 1)
+		}
+		return false
+	}
+}
+
+// run calls fn with the entries queued, in order, until the queue is closed
+func (q *registrationQueue) run(fn func(c Con)) {
+	for c := range q.entries {
+		fn(c)
+	}
+}
+
+// close stops the writer once the entries queued are registered. Nothing can be pushed afterwards.
+func (q *registrationQueue) close() {
+	if q == nil {
+		return
+	}
+	close(q.entries)
+}
+
+func (q *registrationQueue) getStats() map[string]int64 {
+	return map[string]int64{
+		"registration_queue_length":           int64(len(q.entries)),
+		"registration_queue_dropped_destroys": atomic.LoadInt64(&q.droppedDestroys),
+	}
+}
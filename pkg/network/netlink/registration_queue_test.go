@@ -0,0 +1,77 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistrationQueue(t *testing.T) {
+	assert.Nil(t, newRegistrationQueue(0))
+
+	q := newRegistrationQueue(2)
+	c := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8443, 443)
+	require.True(t, q.push(&c))
+
+	// the entry queued doesn't share the memory of the entry pushed, which the decoder re-uses
+	c.Origin.src = keyAddrFromString("10.0.0.2")
+	c.Reply.srcPort = 9443
+	destroy := makeTranslatedConn(net.ParseIP("10.0.0.3"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40001, 8443, 443)
+	destroy.EventType = EventDestroy
+	require.True(t, q.push(&destroy))
+
+	// the queue is full
+	assert.False(t, q.push(&c))
+	assert.False(t, q.push(&destroy))
+	assert.Equal(t, int64(1), q.droppedRegisters)
+	assert.Equal(t, int64(1), q.getStats()["registration_queue_dropped_destroys"])
+	assert.Equal(t, int64(2), q.getStats()["registration_queue_length"])
+
+	var registered []Con
+	q.close()
+	q.run(func(c Con) {
+		registered = append(registered, c)
+	})
+	require.Len(t, registered, 2)
+	assert.Equal(t, "10.0.0.1", registered[0].Origin.Src().String())
+	assert.Equal(t, uint16(8443), registered[0].Reply.SrcPort())
+	assert.Equal(t, EventDestroy, registered[1].EventType)
+}
+
+func TestQueuedRegistrations(t *testing.T) {
+	rt := newConntracker()
+	rt.queue = newRegistrationQueue(1)
+
+	// the writer waits for the lock, so the events read in the meantime are dropped once the queue is full
+	rt.Lock()
+	done := make(chan struct{})
+	go func() {
+		rt.queue.run(func(c Con) {
+			rt.register(c)
+		})
+		close(done)
+	}()
+	for i := 0; i < 3; i++ {
+		c := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, uint16(40000+i), 8443, 443)
+		rt.queue.push(&c)
+	}
+	rt.Unlock()
+	rt.queue.close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "the queue wasn't drained")
+	}
+	// the slot of the first event is only freed once it is registered
+	assert.Equal(t, 1, rt.state.len())
+	stats := rt.getDropStats()
+	assert.Equal(t, int64(2), stats["registers_dropped_queue_full"])
+	assert.Equal(t, int64(2), stats["registers_dropped"])
+}
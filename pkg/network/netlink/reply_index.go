@@ -0,0 +1,216 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"unsafe"
+)
+
+// indexSlotSize is the size of a slot of the reply index, from which its memory usage is estimated
+const indexSlotSize = unsafe.Sizeof(indexSlot{})
+
+// indexSlot is a slot of a replyIndex, free when its reply hash is 0
+type indexSlot struct {
+	reply  uint64
+	origin uint64
+}
+
+// indexSlots are the slots of a replyIndex, allocated in chunks of tableChunkSlots slots like the ones of a
+// translationTable, so they are shared the same way with the published copies of the index
+type indexSlots struct {
+	chunks [][]indexSlot
+	// shared marks the chunks shared with a copy of the slots, which must be copied before they are written
+	shared []bool
+	n      int
+}
+
+// newIndexSlots allocates the given number of slots
+func newIndexSlots(n int) *indexSlots {
+	s := &indexSlots{n: n}
+	for i := 0; i < n; i += tableChunkSlots {
+		size := n - i
+		if size > tableChunkSlots {
+			size = tableChunkSlots
+		}
+		s.chunks = append(s.chunks, make([]indexSlot, size))
+	}
+	s.shared = make([]bool, len(s.chunks))
+	return s
+}
+
+// at returns the slot i, to be read only
+func (s *indexSlots) at(i int) *indexSlot {
+	return &s.chunks[i>>tableChunkBits][i&(tableChunkSlots-1)]
+}
+
+// mut returns the slot i to be written, copying its chunk first when it is shared
+func (s *indexSlots) mut(i int) *indexSlot {
+	c := i >> tableChunkBits
+	if s.shared[c] {
+		s.chunks[c] = append([]indexSlot(nil), s.chunks[c]...)
+		s.shared[c] = false
+	}
+	return &s.chunks[c][i&(tableChunkSlots-1)]
+}
+
+// share returns a read-only copy of the slots, which shares their chunks until they are written
+func (s *indexSlots) share() *indexSlots {
+	for i := range s.shared {
+		s.shared[i] = true
+	}
+	return &indexSlots{chunks: append([][]indexSlot(nil), s.chunks...), n: s.n}
+}
+
+// replyIndex indexes the connections of a connTable by their reply tuple. It is an open-addressing hash table with
+// linear probing, like translationTable, but its slots only hold the hash of the reply key of a connection and the
+// hash of its origin key, with which the connection is stored: a sixth of the size of the slots of the table.
+// Different keys may share a hash, so the index returns the candidate connections of a reply key, which are checked
+// against their translation by the table.
+// The slots are small enough to be rehashed at once when the index is resized.
+type replyIndex struct {
+	slots    *indexSlots
+	count    int
+	minSlots int
+
+	// compaction is how the index is shrunk, either by the removals or by compact when deferCompaction is set.
+	// Since the index is rehashed at once, the incremental and copy strategies shrink it the same way.
+	compaction      compactionStrategy
+	deferCompaction bool
+}
+
+// newReplyIndex returns an index whose slots are pre-allocated for the given number of connections
+func newReplyIndex(entries int) *replyIndex {
+	minSlots := int(float64(entries)/tableMaxLoad) + 1
+	if minSlots < tableMinSlots {
+		minSlots = tableMinSlots
+	}
+	return &replyIndex{
+		slots:    newIndexSlots(minSlots),
+		minSlots: minSlots,
+	}
+}
+
+func (s *indexSlots) home(h uint64) int {
+	return int(h % uint64(s.n))
+}
+
+func (s *indexSlots) next(i int) int {
+	if i++; i == s.n {
+		return 0
+	}
+	return i
+}
+
+// add indexes the connection of the given origin hash by the given reply hash
+func (x *replyIndex) add(reply, origin uint64) {
+	if float64(x.count+1) > tableMaxLoad*float64(x.slots.n) {
+		x.rehash(2 * x.slots.n)
+	}
+	x.put(indexSlot{reply: reply, origin: origin})
+	x.count++
+}
+
+func (x *replyIndex) put(s indexSlot) {
+	i := x.slots.home(s.reply)
+	for x.slots.at(i).reply != 0 {
+		i = x.slots.next(i)
+	}
+	*x.slots.mut(i) = s
+}
+
+// remove removes the given pair of hashes from the index, shifting the slots following it in its probe sequence
+// back, the same way translationTable deletes its entries
+func (x *replyIndex) remove(reply, origin uint64) bool {
+	i := x.slots.home(reply)
+	for ; *x.slots.at(i) != (indexSlot{reply: reply, origin: origin}); i = x.slots.next(i) {
+		if x.slots.at(i).reply == 0 {
+			return false
+		}
+	}
+
+	for j := x.slots.next(i); x.slots.at(j).reply != 0; j = x.slots.next(j) {
+		home := x.slots.home(x.slots.at(j).reply)
+		if i <= j && (home <= i || home > j) || i > j && home <= i && home > j {
+			*x.slots.mut(i) = *x.slots.at(j)
+			i = j
+		}
+	}
+	*x.slots.mut(i) = indexSlot{}
+	x.count--
+
+	if !x.deferCompaction {
+		x.compact()
+	}
+	return true
+}
+
+// candidates appends the origin hashes of the connections indexed by the given reply hash to buf
+func (x *replyIndex) candidates(reply uint64, buf []uint64) []uint64 {
+	for i := x.slots.home(reply); x.slots.at(i).reply != 0; i = x.slots.next(i) {
+		if s := x.slots.at(i); s.reply == reply {
+			buf = append(buf, s.origin)
+		}
+	}
+	return buf
+}
+
+// compact shrinks the index so a quarter of its slots are used, when fewer than tableMinLoad of them are, and
+// returns whether it was shrunk
+func (x *replyIndex) compact() bool {
+	if x.compaction == compactOff || x.slots.n <= x.minSlots || float64(x.count) >= tableMinLoad*float64(x.slots.n) {
+		return false
+	}
+	size := 4 * x.count
+	if size < x.minSlots {
+		size = x.minSlots
+	}
+	x.rehash(size)
+	return true
+}
+
+// rehash moves the slots to the given number of slots
+func (x *replyIndex) rehash(size int) {
+	slots := x.slots
+	x.slots = newIndexSlots(size)
+	for _, c := range slots.chunks {
+		for _, s := range c {
+			if s.reply != 0 {
+				x.put(s)
+			}
+		}
+	}
+}
+
+func (x *replyIndex) len() int {
+	return x.count
+}
+
+// capacity returns the number of slots allocated by the index
+func (x *replyIndex) capacity() int {
+	return x.slots.n
+}
+
+// clone returns a copy of the index, which is read without the lock once published. It shares the chunks of the
+// slots of the index, like the clones of a translationTable.
+func (x *replyIndex) clone() *replyIndex {
+	c := *x
+	c.slots = x.slots.share()
+	return &c
+}
+
+// unsharedCapacity returns the number of slots of the index which aren't shared with the given index it was cloned
+// from
+func (x *replyIndex) unsharedCapacity(from *replyIndex) int {
+	chunks := make(map[*indexSlot]struct{}, len(from.slots.chunks))
+	for _, c := range from.slots.chunks {
+		chunks[&c[0]] = struct{}{}
+	}
+	n := 0
+	for _, c := range x.slots.chunks {
+		if _, ok := chunks[&c[0]]; !ok {
+			n += len(c)
+		}
+	}
+	return n
+}
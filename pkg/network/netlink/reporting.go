@@ -0,0 +1,40 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+// Readiness is a readiness check of the agent health, which a conntracker keeps healthy as long as it works.
+// The callers register it, so the conntrackers don't depend on the agent health.
+type Readiness interface {
+	// Healthy reports the check healthy until the next health check
+	Healthy()
+	// Deregister removes the check
+	Deregister() error
+}
+
+// StatsReporter is reported the typed stats of a conntracker periodically, eg. to forward them to the agent
+// telemetry. The stats being totals, it is up to the reporter to compute their change since the last report.
+type StatsReporter interface {
+	Report(Stats)
+}
+
+// noopReadiness is the Readiness of the conntrackers whose readiness isn't registered
+type noopReadiness struct{}
+
+func (noopReadiness) Healthy()          {}
+func (noopReadiness) Deregister() error { return nil }
+
+// registerReadiness registers the readiness check of the given name with the function of the config, if any
+func registerReadiness(cfg *Config, name string) Readiness {
+	if cfg.RegisterReadiness == nil {
+		return noopReadiness{}
+	}
+	return cfg.RegisterReadiness(name)
+}
+
+// reportStats reports the stats to the reporter, if any
+func reportStats(r StatsReporter, s Stats) {
+	if r != nil {
+		r.Report(s)
+	}
+}
@@ -0,0 +1,377 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// initRetryMinBackoff and initRetryMaxBackoff are the default bounds of the backoff between the retries
+	initRetryMinBackoff = 5 * time.Second
+	initRetryMaxBackoff = 5 * time.Minute
+)
+
+// retryPolicy is how the initialization of the conntracker is retried in the background
+type retryPolicy struct {
+	// backoff is the delay before the first retry, which doubles after each failure, up to maxBackoff
+	backoff    time.Duration
+	maxBackoff time.Duration
+	// maxRetries is the number of retries after which the initialization is given up on. It is retried forever
+	// when it is 0, and not retried at all when it is negative.
+	maxRetries int
+}
+
+func newRetryPolicy(cfg *Config) retryPolicy {
+	p := retryPolicy{
+		backoff:    initRetryMinBackoff,
+		maxBackoff: initRetryMaxBackoff,
+		maxRetries: cfg.InitMaxRetries,
+	}
+	if cfg.InitRetryBackoff > 0 {
+		p.backoff = cfg.InitRetryBackoff
+	}
+	if cfg.InitRetryMaxBackoff > 0 {
+		p.maxBackoff = cfg.InitRetryMaxBackoff
+	}
+	if p.maxBackoff < p.backoff {
+		p.maxBackoff = p.backoff
+	}
+	return p
+}
+
+// retries returns whether the initialization is retried at all
+func (p retryPolicy) retries() bool {
+	return p.maxRetries >= 0
+}
+
+// exhausted returns whether the given number of retries is the last one
+func (p retryPolicy) exhausted(retries int) bool {
+	return p.maxRetries != 0 && retries >= p.maxRetries
+}
+
+// initResult is the outcome of an attempt to initialize a conntracker
+type initResult struct {
+	conntracker Conntracker
+	err         error
+}
+
+// retryingConntracker is returned when the conntracker couldn't be initialized in time. It keeps retrying the
+// initialization in the background, with an exponential backoff, and behaves like a no-op conntracker until then.
+// Its readiness is reported through its stats and a health readiness check, which is unhealthy until the
+// conntracker is initialized and reports its own.
+type retryingConntracker struct {
+	current atomic.Value
+	ready   int32
+
+	mux     sync.Mutex
+	closed  bool
+	pending []*pendingSubscription
+	exit    chan struct{}
+
+	policy   retryPolicy
+	attempts int64
+	failures int64
+	// gaveUp is set once the retries are exhausted, the conntracker staying a no-op one for good
+	gaveUp int32
+	errors *errorLog
+
+	health Readiness
+}
+
+// pendingSubscription is a subscription made before the conntracker was initialized, whose events are forwarded
+// from the subscription made on the conntracker once it is
+type pendingSubscription struct {
+	filter     TranslationFilter
+	events     chan TranslationEvent
+	done       chan struct{}
+	forwarding bool
+}
+
+// newRetryingConntracker starts retrying the initialization with attempt, following the given policy, and reports
+// the given readiness check unhealthy until it succeeds.
+// pending, when not nil, is an attempt still in progress whose outcome is waited for before retrying.
+func newRetryingConntracker(pending <-chan initResult, attempt func() (Conntracker, error), policy retryPolicy, health Readiness) *retryingConntracker {
+	r := &retryingConntracker{
+		exit:   make(chan struct{}),
+		policy: policy,
+		errors: newErrorLog(maxRecentErrors),
+		health: health,
+	}
+	r.current.Store(conntrackerHolder{NewNoOpConntracker()})
+	go r.run(pending, attempt)
+	return r
+}
+
+// closeWhenDone leaves an attempt in progress to complete, and closes its conntracker
+func closeWhenDone(pending <-chan initResult) {
+	if res := <-pending; res.err == nil {
+		res.conntracker.Close()
+	}
+}
+
+func (r *retryingConntracker) run(pending <-chan initResult, attempt func() (Conntracker, error)) {
+	if pending != nil {
+		atomic.AddInt64(&r.attempts, 1)
+		select {
+		case res := <-pending:
+			if r.initialized(res) {
+				return
+			}
+		case <-r.exit:
+			go closeWhenDone(pending)
+			return
+		}
+	}
+
+	backoff := r.policy.backoff
+	for retries := 0; ; retries++ {
+		if r.policy.exhausted(retries) {
+			r.giveUp()
+			return
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-r.exit:
+			timer.Stop()
+			return
+		}
+
+		atomic.AddInt64(&r.attempts, 1)
+		c, err := attempt()
+		if r.initialized(initResult{c, err}) {
+			return
+		}
+
+		if backoff *= 2; backoff > r.policy.maxBackoff {
+			backoff = r.policy.maxBackoff
+		}
+	}
+}
+
+// giveUp stops the retries once they are exhausted. The conntracker stays a no-op one, and isn't reported
+// unready anymore, the same way as when conntrack is unavailable.
+func (r *retryingConntracker) giveUp() {
+	attempts := atomic.LoadInt64(&r.attempts)
+	log.Errorf("could not initialize conntrack after %d attempts, giving up: the tracer will continue without NAT tracking", attempts)
+	r.errors.add("gave up initializing conntrack after %d attempts", attempts)
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	atomic.StoreInt32(&r.gaveUp, 1)
+	if !r.closed {
+		_ = r.health.Deregister()
+	}
+}
+
+// initialized handles the outcome of an initialization attempt, and returns whether the retries are over
+func (r *retryingConntracker) initialized(res initResult) bool {
+	if res.err != nil {
+		atomic.AddInt64(&r.failures, 1)
+		log.Warnf("could not initialize conntrack, retrying: %s", res.err)
+		r.errors.add("could not initialize conntrack: %s", res.err)
+		return false
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.closed {
+		res.conntracker.Close()
+		return true
+	}
+
+	r.current.Store(conntrackerHolder{res.conntracker})
+	for _, s := range r.pending {
+		s.forwarding = true
+		events, cancel := res.conntracker.Subscribe(s.filter)
+		go s.forward(events, cancel)
+	}
+	r.pending = nil
+	atomic.StoreInt32(&r.ready, 1)
+	_ = r.health.Deregister()
+
+	log.Infof("conntrack initialized after %d attempts", atomic.LoadInt64(&r.attempts))
+	return true
+}
+
+func (r *retryingConntracker) isReady() bool {
+	return atomic.LoadInt32(&r.ready) == 1
+}
+
+func (r *retryingConntracker) get() Conntracker {
+	return r.current.Load().(conntrackerHolder).Conntracker
+}
+
+func (r *retryingConntracker) GetTranslationForConn(c network.ConnectionStats) *network.IPTranslation {
+	return r.get().GetTranslationForConn(c)
+}
+
+func (r *retryingConntracker) GetTranslationsForConns(conns []network.ConnectionStats) []*network.IPTranslation {
+	return r.get().GetTranslationsForConns(conns)
+}
+
+func (r *retryingConntracker) GetEntryForConn(c network.ConnectionStats) *ConntrackEntry {
+	return r.get().GetEntryForConn(c)
+}
+
+func (r *retryingConntracker) DeleteTranslation(c network.ConnectionStats) {
+	r.get().DeleteTranslation(c)
+}
+
+func (r *retryingConntracker) DeleteTranslations(conns []network.ConnectionStats) {
+	r.get().DeleteTranslations(conns)
+}
+
+func (r *retryingConntracker) DumpCachedTable() []network.DebugConntrackEntry {
+	return r.get().DumpCachedTable()
+}
+
+func (r *retryingConntracker) GetTCPStateForConn(c network.ConnectionStats) TCPState {
+	return r.get().GetTCPStateForConn(c)
+}
+
+func (r *retryingConntracker) GetTCPStatesForConns(conns []network.ConnectionStats) []TCPState {
+	return r.get().GetTCPStatesForConns(conns)
+}
+
+func (r *retryingConntracker) GetCountersForConn(c network.ConnectionStats) (Counters, bool) {
+	return r.get().GetCountersForConn(c)
+}
+
+func (r *retryingConntracker) GetStartTimeForConn(c network.ConnectionStats) (time.Time, bool) {
+	return r.get().GetStartTimeForConn(c)
+}
+
+func (r *retryingConntracker) GetStatusForConn(c network.ConnectionStats) Status {
+	return r.get().GetStatusForConn(c)
+}
+
+func (r *retryingConntracker) GetNATGateways() []util.Address {
+	return r.get().GetNATGateways()
+}
+
+// GetRecentErrors returns the errors of the failed initialization attempts, followed by the errors of the
+// conntracker once it is initialized
+func (r *retryingConntracker) GetRecentErrors() []string {
+	return append(r.errors.recent(), r.get().GetRecentErrors()...)
+}
+
+// Refresh fails until the conntracker is initialized, since there is no cache to reconcile yet
+func (r *retryingConntracker) Refresh(family uint8) error {
+	if !r.isReady() {
+		return errors.New("conntrack is not initialized")
+	}
+	return r.get().Refresh(family)
+}
+
+// SetTargetRateLimit is only applied once the conntracker is initialized
+func (r *retryingConntracker) SetTargetRateLimit(limit int) {
+	r.get().SetTargetRateLimit(limit)
+}
+
+// Describe returns the description of the conntracker once it is initialized. Until then, the configuration in
+// effect isn't known, and the status tells whether the initialization is still retried.
+func (r *retryingConntracker) Describe() network.ConntrackDescription {
+	if r.isReady() {
+		return r.get().Describe()
+	}
+	status := "initializing"
+	if atomic.LoadInt32(&r.gaveUp) == 1 {
+		status = "failed"
+	}
+	return network.ConntrackDescription{Backend: "netlink", Status: status}
+}
+
+// Subscribe subscribes to the conntracker once it is initialized, the channel not being notified until then
+func (r *retryingConntracker) Subscribe(filter TranslationFilter) (<-chan TranslationEvent, func()) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.isReady() {
+		return r.get().Subscribe(filter)
+	}
+
+	s := &pendingSubscription{
+		filter: filter,
+		events: make(chan TranslationEvent, subscriptionBufferSize),
+		done:   make(chan struct{}),
+	}
+	r.pending = append(r.pending, s)
+
+	var once sync.Once
+	return s.events, func() {
+		once.Do(func() {
+			r.mux.Lock()
+			defer r.mux.Unlock()
+			close(s.done)
+			if s.forwarding {
+				// the forwarding goroutine closes the channel
+				return
+			}
+			for i, p := range r.pending {
+				if p == s {
+					r.pending = append(r.pending[:i], r.pending[i+1:]...)
+					break
+				}
+			}
+			close(s.events)
+		})
+	}
+}
+
+func (s *pendingSubscription) forward(events <-chan TranslationEvent, cancel func()) {
+	defer close(s.events)
+	defer cancel()
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			select {
+			case s.events <- e:
+			default:
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// GetStats returns the stats of the conntracker once it is initialized, along with the initialization stats
+func (r *retryingConntracker) GetStats() Stats {
+	var stats Stats
+	if r.isReady() {
+		stats = r.get().GetStats()
+	}
+	stats.set("init_ready", int64(atomic.LoadInt32(&r.ready)))
+	stats.set("init_attempts", atomic.LoadInt64(&r.attempts))
+	stats.set("init_failures", atomic.LoadInt64(&r.failures))
+	stats.set("init_gave_up", int64(atomic.LoadInt32(&r.gaveUp)))
+	return stats
+}
+
+// Close stops the retries, or closes the conntracker when it is initialized
+func (r *retryingConntracker) Close() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	close(r.exit)
+	r.get().Close()
+	if !r.isReady() && atomic.LoadInt32(&r.gaveUp) == 0 {
+		_ = r.health.Deregister()
+	}
+}
@@ -0,0 +1,172 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryingConntracker(t *testing.T) {
+	fake := NewFakeConntracker()
+	var attempts int32
+	attempt := func() (Conntracker, error) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return nil, errors.New("conntrack unavailable")
+		}
+		return fake, nil
+	}
+
+	r := newRetryingConntracker(nil, attempt, retryPolicy{backoff: time.Millisecond, maxBackoff: time.Millisecond}, noopReadiness{})
+	defer r.Close()
+	events, cancel := r.Subscribe(nil)
+	defer cancel()
+
+	require.Eventually(t, r.isReady, time.Second, time.Millisecond)
+	stats := r.GetStats().Extra
+	assert.Equal(t, int64(1), stats["init_ready"])
+	assert.Equal(t, int64(3), stats["init_attempts"])
+	assert.Equal(t, int64(2), stats["init_failures"])
+	// the stats of the conntracker are reported once it is initialized
+	assert.Contains(t, stats, "gets_failed")
+	assert.Equal(t, "fake", r.Describe().Backend)
+
+	conn := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("50.30.40.10"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+	trans := &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("20.0.0.1"),
+		ReplDstIP:   util.AddressFromString("10.0.0.1"),
+		ReplSrcPort: 80,
+		ReplDstPort: 12345,
+	}
+	fake.AddTranslation(conn, trans)
+	assert.Equal(t, trans, r.GetTranslationForConn(conn))
+
+	// the subscription made before the initialization is forwarded the events
+	select {
+	case e := <-events:
+		assert.Equal(t, TranslationCreated, e.Type)
+	case <-time.After(time.Second):
+		t.Fatal("no event forwarded")
+	}
+}
+
+func TestRetryingConntrackerNotReady(t *testing.T) {
+	readiness := &fakeReadiness{}
+	r := newRetryingConntracker(nil, func() (Conntracker, error) {
+		return nil, errors.New("conntrack unavailable")
+	}, retryPolicy{backoff: time.Hour, maxBackoff: time.Hour}, readiness)
+
+	assert.Nil(t, r.GetTranslationForConn(network.ConnectionStats{}))
+	assert.Equal(t, int64(0), r.GetStats().Extra["init_ready"])
+	assert.True(t, readiness.registered())
+	assert.Equal(t, network.ConntrackDescription{Backend: "netlink", Status: "initializing"}, r.Describe())
+
+	events, cancel := r.Subscribe(nil)
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+
+	r.Close()
+	r.Close()
+	assert.False(t, readiness.registered())
+}
+
+func TestRetryingConntrackerPendingAttempt(t *testing.T) {
+	pending := make(chan initResult, 1)
+	r := newRetryingConntracker(pending, func() (Conntracker, error) {
+		return nil, errors.New("unexpected retry")
+	}, retryPolicy{backoff: time.Hour, maxBackoff: time.Hour}, noopReadiness{})
+	defer r.Close()
+
+	assert.False(t, r.isReady())
+	pending <- initResult{conntracker: NewFakeConntracker()}
+	require.Eventually(t, r.isReady, time.Second, time.Millisecond)
+	assert.Equal(t, int64(1), r.GetStats().Extra["init_attempts"])
+}
+
+func TestRetryingConntrackerRecentErrors(t *testing.T) {
+	pending := make(chan initResult, 1)
+	r := newRetryingConntracker(pending, func() (Conntracker, error) {
+		return nil, errors.New("unexpected retry")
+	}, retryPolicy{backoff: time.Hour, maxBackoff: time.Hour}, noopReadiness{})
+	defer r.Close()
+
+	pending <- initResult{err: errors.New("conntrack unavailable")}
+	require.Eventually(t, func() bool { return len(r.GetRecentErrors()) == 1 }, time.Second, time.Millisecond)
+	assert.Contains(t, r.GetRecentErrors()[0], "could not initialize conntrack: conntrack unavailable")
+}
+
+func TestRetryingConntrackerClosedBeforeReady(t *testing.T) {
+	pending := make(chan initResult, 1)
+	r := newRetryingConntracker(pending, nil, retryPolicy{backoff: time.Hour, maxBackoff: time.Hour}, noopReadiness{})
+	r.Close()
+
+	// the conntracker initialized after all is closed rather than leaked
+	fake := NewFakeConntracker()
+	pending <- initResult{conntracker: fake}
+	require.Eventually(t, fake.Closed, time.Second, time.Millisecond)
+	assert.False(t, r.isReady())
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := newRetryPolicy(&Config{})
+	assert.Equal(t, retryPolicy{backoff: initRetryMinBackoff, maxBackoff: initRetryMaxBackoff}, p)
+	assert.True(t, p.retries())
+	assert.False(t, p.exhausted(1000))
+
+	p = newRetryPolicy(&Config{InitRetryBackoff: 10 * time.Minute, InitMaxRetries: 3})
+	// the max backoff is never below the first one
+	assert.Equal(t, 10*time.Minute, p.maxBackoff)
+	assert.False(t, p.exhausted(2))
+	assert.True(t, p.exhausted(3))
+
+	assert.False(t, newRetryPolicy(&Config{InitMaxRetries: -1}).retries())
+}
+
+func TestRetryingConntrackerGivesUp(t *testing.T) {
+	var attempts int32
+	readiness := &fakeReadiness{}
+	r := newRetryingConntracker(nil, func() (Conntracker, error) {
+		atomic.AddInt32(&attempts, 1)
+		return nil, errors.New("conntrack unavailable")
+	}, retryPolicy{backoff: time.Millisecond, maxBackoff: time.Millisecond, maxRetries: 2}, readiness)
+	defer r.Close()
+
+	require.Eventually(t, func() bool { return r.GetStats().Extra["init_gave_up"] == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	assert.False(t, r.isReady())
+	assert.Equal(t, "failed", r.Describe().Status)
+	// the conntracker isn't reported unready once it was given up on
+	assert.False(t, readiness.registered())
+	assert.Contains(t, r.GetRecentErrors()[len(r.GetRecentErrors())-1], "gave up initializing conntrack after 2 attempts")
+}
+
+// fakeReadiness is a Readiness recording whether it was deregistered
+type fakeReadiness struct {
+	deregistered int32
+}
+
+func (r *fakeReadiness) Healthy() {}
+
+func (r *fakeReadiness) Deregister() error {
+	atomic.StoreInt32(&r.deregistered, 1)
+	return nil
+}
+
+func (r *fakeReadiness) registered() bool {
+	return atomic.LoadInt32(&r.deregistered) == 0
+}
@@ -0,0 +1,233 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// The netlink memory-mapped I/O interface, defined in include/uapi/linux/netlink.h. It was removed from Linux 4.6.
+const (
+	netlinkRxRing = 6
+
+	// nlMmapHdrLen is the size of the header of the frames, nl_mmap_hdr, aligned on NL_MMAP_MSG_ALIGNMENT
+	nlMmapHdrLen = 24
+
+	// the status of the frames
+	nlMmapStatusUnused   = 0
+	nlMmapStatusReserved = 1
+	nlMmapStatusValid    = 2
+	nlMmapStatusCopy     = 3
+	nlMmapStatusSkip     = 4
+
+	// rxRingFrameSize is the size of the frames of the ring, large enough for the conntrack events. The messages
+	// larger than a frame are left in the receive queue of the socket by the kernel.
+	rxRingFrameSize = 2048
+)
+
+// nlMmapReq is the nl_mmap_req structure describing the layout of a ring
+type nlMmapReq struct {
+	blockSize uint32
+	blockNr   uint32
+	frameSize uint32
+	frameNr   uint32
+}
+
+// nlMmapHdr is the nl_mmap_hdr structure heading each frame of a ring
+type nlMmapHdr struct {
+	status uint32
+	len    uint32
+	group  uint32
+	pid    uint32
+	uid    uint32
+	gid    uint32
+}
+
+// rxRing is a NETLINK_RX_RING ring, the frames of which the kernel writes the messages received by the socket to.
+// The frames are handed back to the kernel once their message is copied out, in the order they were written.
+type rxRing struct {
+	// mux keeps the ring from being unmapped while it is read, by another goroutine closing the socket
+	mux sync.Mutex
+	mem []byte
+	// head is the index of the next frame to read
+	head   int
+	frames int
+}
+
+// newRxRing sets up a ring of about size bytes on the given socket, and maps it in memory
+func newRxRing(fd int, size int) (*rxRing, error) {
+	blockSize := os.Getpagesize()
+	if blockSize < rxRingFrameSize {
+		blockSize = rxRingFrameSize
+	}
+	blocks := size / blockSize
+	if blocks == 0 {
+		blocks = 1
+	}
+
+	req := nlMmapReq{
+		blockSize: uint32(blockSize),
+		blockNr:   uint32(blocks),
+		frameSize: rxRingFrameSize,
+		frameNr:   uint32(blocks * blockSize / rxRingFrameSize),
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_SETSOCKOPT, uintptr(fd), unix.SOL_NETLINK, netlinkRxRing,
+		uintptr(unsafe.Pointer(&req)), unsafe.Sizeof(req), 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("setsockopt", errno)
+	}
+
+	mem, err := unix.Mmap(fd, 0, blocks*blockSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	return &rxRing{mem: mem, frames: int(req.frameNr)}, nil
+}
+
+// frame returns the header of the frame at the head of the ring
+func (r *rxRing) frame() *nlMmapHdr {
+	return (*nlMmapHdr)(unsafe.Pointer(&r.mem[r.head*rxRingFrameSize]))
+}
+
+// data returns the message of the frame at the head of the ring
+func (r *rxRing) data(hdr *nlMmapHdr) []byte {
+	offset := r.head*rxRingFrameSize + nlMmapHdrLen
+	return r.mem[offset : offset+int(hdr.len)]
+}
+
+// release hands the frame at the head of the ring back to the kernel, and moves on to the next one
+func (r *rxRing) release(hdr *nlMmapHdr) {
+	atomic.StoreUint32(&hdr.status, nlMmapStatusUnused)
+	r.head = (r.head + 1) % r.frames
+}
+
+func (r *rxRing) close() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.mem == nil {
+		return nil
+	}
+	err := unix.Munmap(r.mem)
+	r.mem = nil
+	return err
+}
+
+// EnableRxRing has the socket receive its messages in a ring of about size bytes mapped in memory, rather than
+// copying them with a recvmsg call each. It fails on the kernels without NETLINK_RX_RING (4.6 and later).
+// The messages received in the ring aren't tagged with their network namespace.
+func (s *Socket) EnableRxRing(size int) error {
+	var ring *rxRing
+	var err error
+	ctrlErr := s.conn.Control(func(fd uintptr) {
+		ring, err = newRxRing(int(fd), size)
+	})
+	if ctrlErr != nil {
+		return ctrlErr
+	}
+	if err != nil {
+		return err
+	}
+
+	s.ring = ring
+	return nil
+}
+
+// receiveRing reads the messages ready in the ring into b, waiting for the socket to be readable when the ring
+// is empty
+func (s *Socket) receiveRing(b []byte) ([]netlink.Message, int32, error) {
+	var (
+		n   int
+		err error
+	)
+	ctrlErr := s.conn.Read(func(fd uintptr) bool {
+		n, b, err = s.readRing(int(fd), b)
+		if n > 0 || err != nil {
+			return true
+		}
+
+		// The ring is empty. The errors of the socket, such as ENOBUFS when the ring overran, are only reported
+		// by recvmsg and SO_ERROR.
+		var soErr int
+		if soErr, err = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ERROR); err == nil && soErr != 0 {
+			err = syscall.Errno(soErr)
+		}
+		return err != nil
+	})
+	if ctrlErr != nil {
+		return nil, 0, ctrlErr
+	}
+	if err != nil {
+		return nil, 0, os.NewSyscallError("recvmsg", err)
+	}
+
+	raw, err := syscall.ParseNetlinkMessage(b[:n])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	msgs := make([]netlink.Message, 0, len(raw))
+	for _, r := range raw {
+		msgs = append(msgs, netlink.Message{
+			Header: sysToHeader(r.Header),
+			Data:   r.Data,
+		})
+	}
+	return msgs, noNSID, nil
+}
+
+// readRing copies the messages of the frames ready at the head of the ring into b, as many as fit, and releases
+// their frames. The message of a frame marked for copy is read off the receive queue of the socket on its own, so
+// the messages are kept in order. It returns the number of bytes copied, 0 when the ring is empty, and the buffer
+// they were copied to, which is only re-allocated when a single message doesn't fit in b.
+func (s *Socket) readRing(fd int, b []byte) (int, []byte, error) {
+	s.ring.mux.Lock()
+	defer s.ring.mux.Unlock()
+	if s.ring.mem == nil {
+		return 0, b, os.ErrClosed
+	}
+
+	var n int
+	for {
+		hdr := s.ring.frame()
+		switch atomic.LoadUint32(&hdr.status) {
+		case nlMmapStatusValid:
+			size := nlmsgAlign(int(hdr.len))
+			if n > 0 && n+size > len(b) {
+				return n, b, nil
+			}
+			if size > len(b) {
+				b = make([]byte, size)
+			}
+			copy(b[n:], s.ring.data(hdr))
+			n += size
+		case nlMmapStatusCopy:
+			if n > 0 {
+				return n, b, nil
+			}
+			m, _, _, _, err := unix.Recvmsg(fd, s.recvbuf, nil, unix.MSG_DONTWAIT)
+			s.ring.release(hdr)
+			if err != nil {
+				return 0, b, err
+			}
+			m = nlmsgAlign(m)
+			if m > len(b) {
+				b = make([]byte, m)
+			}
+			copy(b, s.recvbuf[:m])
+			return m, b, nil
+		case nlMmapStatusSkip:
+		default:
+			// the frame is unused, or still being written by the kernel
+			return n, b, nil
+		}
+		s.ring.release(hdr)
+	}
+}
@@ -0,0 +1,84 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// writeFrame writes a message with the given payload to a frame of the ring, the way the kernel does
+func writeFrame(t *testing.T, r *rxRing, i int, status uint32, payload []byte) {
+	m := netlink.Message{Header: netlink.Header{Length: uint32(16 + len(payload)), Type: 0x100}, Data: payload}
+	b, err := m.MarshalBinary()
+	require.NoError(t, err)
+
+	offset := i * rxRingFrameSize
+	copy(r.mem[offset+nlMmapHdrLen:], b)
+	hdr := (*nlMmapHdr)(unsafe.Pointer(&r.mem[offset]))
+	hdr.len = uint32(len(b))
+	hdr.status = status
+}
+
+func frameStatus(r *rxRing, i int) uint32 {
+	return (*nlMmapHdr)(unsafe.Pointer(&r.mem[i*rxRingFrameSize])).status
+}
+
+func TestReadRing(t *testing.T) {
+	mem, err := unix.Mmap(-1, 0, 4*rxRingFrameSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	require.NoError(t, err)
+	ring := &rxRing{mem: mem, frames: 4}
+	s := &Socket{ring: ring}
+
+	// the ring is empty
+	n, _, err := s.readRing(-1, make([]byte, 64))
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	// the messages ready are read in order, skipping the frames the kernel gave up on, as many as fit in the buffer
+	ring.head = 2
+	writeFrame(t, ring, 2, nlMmapStatusValid, []byte{1, 2, 3, 4})
+	writeFrame(t, ring, 3, nlMmapStatusSkip, nil)
+	writeFrame(t, ring, 0, nlMmapStatusValid, []byte{5, 6, 7, 8})
+	writeFrame(t, ring, 1, nlMmapStatusValid, []byte{9, 10, 11, 12})
+
+	b := make([]byte, 40)
+	n, b, err = s.readRing(-1, b)
+	require.NoError(t, err)
+	raw, err := syscall.ParseNetlinkMessage(b[:n])
+	require.NoError(t, err)
+	require.Len(t, raw, 2)
+	assert.Equal(t, []byte{1, 2, 3, 4}, raw[0].Data)
+	assert.Equal(t, []byte{5, 6, 7, 8}, raw[1].Data)
+	assert.Equal(t, uint32(nlMmapStatusUnused), frameStatus(ring, 2))
+	assert.Equal(t, uint32(nlMmapStatusUnused), frameStatus(ring, 3))
+	assert.Equal(t, uint32(nlMmapStatusUnused), frameStatus(ring, 0))
+	assert.Equal(t, uint32(nlMmapStatusValid), frameStatus(ring, 1))
+
+	// a message larger than the buffer gets a buffer of its own
+	writeFrame(t, ring, 1, nlMmapStatusValid, make([]byte, 100))
+	n, b, err = s.readRing(-1, make([]byte, 40))
+	require.NoError(t, err)
+	assert.Equal(t, 116, n)
+	assert.Len(t, b, 116)
+	assert.Equal(t, 2, ring.head)
+
+	// the frames still being written by the kernel aren't read
+	writeFrame(t, ring, 2, nlMmapStatusReserved, []byte{1, 2, 3, 4})
+	n, _, err = s.readRing(-1, b)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	require.NoError(t, ring.close())
+	_, _, err = s.readRing(-1, b)
+	assert.Equal(t, os.ErrClosed, err)
+	assert.Equal(t, errEOF, socketError(err))
+}
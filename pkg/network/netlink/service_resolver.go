@@ -0,0 +1,93 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+import (
+	"sync/atomic"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+// ServiceResolver maps the addresses Kubernetes services are exposed at (eg. ClusterIP:port) to their names
+type ServiceResolver interface {
+	// ResolveService returns the name ("namespace/name") of the service exposed at the given address and port
+	ResolveService(addr util.Address, port uint16, transport network.ConnectionType) (string, bool)
+}
+
+// serviceConntracker attaches the Kubernetes service the pre-NAT destination of a connection belongs to
+// to the translations returned by the wrapped Conntracker
+type serviceConntracker struct {
+	Conntracker
+	resolver ServiceResolver
+
+	stats struct {
+		resolved   int64
+		unresolved int64
+	}
+}
+
+// NewServiceConntracker returns a Conntracker setting the Service of the translations returned by the given
+// Conntracker, using the given ServiceResolver
+func NewServiceConntracker(ctr Conntracker, resolver ServiceResolver) Conntracker {
+	return &serviceConntracker{
+		Conntracker: ctr,
+		resolver:    resolver,
+	}
+}
+
+func (ctr *serviceConntracker) GetTranslationForConn(c network.ConnectionStats) *network.IPTranslation {
+	return ctr.withService(c, ctr.Conntracker.GetTranslationForConn(c))
+}
+
+func (ctr *serviceConntracker) GetTranslationsForConns(conns []network.ConnectionStats) []*network.IPTranslation {
+	translations := ctr.Conntracker.GetTranslationsForConns(conns)
+	for i := range translations {
+		translations[i] = ctr.withService(conns[i], translations[i])
+	}
+	return translations
+}
+
+func (ctr *serviceConntracker) GetEntryForConn(c network.ConnectionStats) *ConntrackEntry {
+	e := ctr.Conntracker.GetEntryForConn(c)
+	if e != nil {
+		e.Translation = ctr.withService(c, e.Translation)
+	}
+	return e
+}
+
+// withService returns the given translation of c with the service it belongs to
+func (ctr *serviceConntracker) withService(c network.ConnectionStats, t *network.IPTranslation) *network.IPTranslation {
+	if t == nil {
+		return nil
+	}
+
+	// The pre-NAT destination is the destination of the connection when it is initiated by the client,
+	// and the destination of the translation when the connection is seen from the server.
+	service, ok := ctr.resolver.ResolveService(c.Dest, c.DPort, c.Type)
+	if !ok {
+		service, ok = ctr.resolver.ResolveService(t.ReplDstIP, t.ReplDstPort, c.Type)
+	}
+	if !ok {
+		atomic.AddInt64(&ctr.stats.unresolved, 1)
+		return t
+	}
+	atomic.AddInt64(&ctr.stats.resolved, 1)
+
+	// translations returned by the wrapped Conntracker may be shared, so they are never modified
+	resolved := *t
+	resolved.Service = service
+	return &resolved
+}
+
+func (ctr *serviceConntracker) Describe() network.ConntrackDescription {
+	return withLayer(ctr.Conntracker.Describe(), "services")
+}
+
+func (ctr *serviceConntracker) GetStats() Stats {
+	s := ctr.Conntracker.GetStats()
+	s.set("services_resolved", atomic.LoadInt64(&ctr.stats.resolved))
+	s.set("services_unresolved", atomic.LoadInt64(&ctr.stats.unresolved))
+	return s
+}
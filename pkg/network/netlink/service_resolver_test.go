@@ -0,0 +1,111 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticServiceResolver map[string]string
+
+func (r staticServiceResolver) ResolveService(addr util.Address, port uint16, _ network.ConnectionType) (string, bool) {
+	s, ok := r[net.JoinHostPort(addr.String(), strconv.Itoa(int(port)))]
+	return s, ok
+}
+
+func TestServiceConntracker(t *testing.T) {
+	rt := newConntracker()
+	// 10.0.0.1:12345 -> 10.96.0.10:53 (ClusterIP) translated to 10.244.1.5:53
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.10"), 17, 12345, 53, 53))
+
+	ctr := NewServiceConntracker(rt, staticServiceResolver{"10.96.0.10:53": "kube-system/kube-dns"})
+
+	client := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("10.96.0.10"),
+		DPort:  53,
+		Type:   network.UDP,
+	}
+	trans := ctr.GetTranslationForConn(client)
+	require.NotNil(t, trans)
+	assert.Equal(t, "kube-system/kube-dns", trans.Service)
+	assert.Equal(t, util.AddressFromString("10.244.1.5"), trans.ReplSrcIP)
+
+	// the connection as seen from the pod serving the request
+	server := network.ConnectionStats{
+		Source: util.AddressFromString("10.244.1.5"),
+		SPort:  53,
+		Dest:   util.AddressFromString("10.0.0.1"),
+		DPort:  12345,
+		Type:   network.UDP,
+	}
+	trans = ctr.GetTranslationForConn(server)
+	require.NotNil(t, trans)
+	assert.Equal(t, "kube-system/kube-dns", trans.Service)
+
+	// the cached translations are left untouched
+	assert.Empty(t, rt.GetTranslationForConn(client).Service)
+}
+
+type fakeServiceSource struct {
+	addrs []ServiceAddress
+	err   error
+}
+
+func (s *fakeServiceSource) Name() string { return "the fake source" }
+
+func (s *fakeServiceSource) ServiceAddresses() ([]ServiceAddress, error) {
+	return s.addrs, s.err
+}
+
+func TestPolledServiceConntracker(t *testing.T) {
+	client := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("10.96.0.10"),
+		DPort:  53,
+		Type:   network.UDP,
+	}
+	fake := NewFakeConntracker()
+	fake.AddTranslation(client, &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("10.244.1.5"),
+		ReplSrcPort: 53,
+		ReplDstIP:   util.AddressFromString("10.0.0.1"),
+		ReplDstPort: 12345,
+	})
+
+	// the source isn't reachable yet
+	source := &fakeServiceSource{err: errors.New("unreachable")}
+	ctr := NewPolledServiceConntracker(fake, source, time.Hour).(*polledServiceConntracker)
+	defer ctr.Close()
+
+	trans := ctr.GetTranslationForConn(client)
+	require.NotNil(t, trans)
+	assert.Empty(t, trans.Service)
+	assert.Equal(t, int64(1), ctr.GetStats().Extra["service_addresses_poll_errors_total"])
+
+	source.err = nil
+	source.addrs = []ServiceAddress{
+		{IP: util.AddressFromString("10.96.0.10"), Port: 53, Transport: network.UDP, Service: "kube-system/kube-dns"},
+		{IP: util.AddressFromString("10.96.0.10"), Port: 53, Transport: network.TCP, Service: "kube-system/kube-dns-tcp"},
+	}
+	require.NoError(t, ctr.services.poll())
+
+	trans = ctr.GetTranslationForConn(client)
+	require.NotNil(t, trans)
+	assert.Equal(t, "kube-system/kube-dns", trans.Service)
+	stats := ctr.GetStats()
+	assert.Equal(t, int64(2), stats.Extra["service_addresses"])
+	assert.Equal(t, int64(1), stats.Extra["services_resolved"])
+}
@@ -0,0 +1,148 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+const (
+	// snapshotVersion 2 holds one entry per connection, where version 1 held an entry for each of its directions
+	snapshotVersion = 2
+
+	// maxSnapshotAge is the maximum age of a snapshot that can be restored. Older snapshots
+	// are likely to diverge too much from the kernel conntrack table to be worth restoring.
+	maxSnapshotAge = 10 * time.Minute
+)
+
+// snapshot is the representation of the conntrack cache persisted on disk
+type snapshot struct {
+	Version int
+	// BootID identifies the boot the snapshot was taken during, since the kernel table doesn't survive a reboot
+	BootID  string
+	Created time.Time
+	Entries []snapshotEntry
+}
+
+// snapshotEntry is a connection of the cache: Key is its origin tuple, and Translation its reply tuple
+type snapshotEntry struct {
+	Transport uint8
+	// NetNS is the inode of the network namespace of the entry, which is absent (0) from the snapshots
+	// taken before namespaces were told apart
+	NetNS       uint32
+	Key         snapshotTuple
+	Translation snapshotTuple
+}
+
+type snapshotTuple struct {
+	SrcIP, DstIP     []byte
+	SrcPort, DstPort uint16
+}
+
+// saveSnapshot persists the given state to path. The file is written atomically so a partially written
+// snapshot is never restored.
+func saveSnapshot(path, bootID string, state *connTable) error {
+	s := snapshot{
+		Version: snapshotVersion,
+		BootID:  bootID,
+		Created: time.Now(),
+		Entries: make([]snapshotEntry, 0, state.len()),
+	}
+	state.forEach(func(k connKey, t translationValue) bool {
+		s.Entries = append(s.Entries, snapshotEntry{
+			Transport:   uint8(k.transport),
+			NetNS:       k.netns,
+			Key:         snapshotTuple{SrcIP: k.srcIP.address().Bytes(), DstIP: k.dstIP.address().Bytes(), SrcPort: k.srcPort, DstPort: k.dstPort},
+			Translation: snapshotTuple{SrcIP: t.replSrcIP.address().Bytes(), DstIP: t.replDstIP.address().Bytes(), SrcPort: t.replSrcPort, DstPort: t.replDstPort},
+		})
+		return true
+	})
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := gob.NewEncoder(f).Encode(&s); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// loadSnapshot reads the snapshot persisted at path, and calls fn with the origin key and translation of each of
+// its connections.
+// The snapshot is removed once read, so it can only be restored once.
+func loadSnapshot(path, bootID string, fn func(connKey, network.IPTranslation)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	defer f.Close()
+
+	var s snapshot
+	if err := gob.NewDecoder(f).Decode(&s); err != nil {
+		return fmt.Errorf("could not decode snapshot: %w", err)
+	}
+
+	switch {
+	case s.Version != snapshotVersion:
+		return fmt.Errorf("unsupported snapshot version %d", s.Version)
+	case s.BootID != bootID:
+		return fmt.Errorf("snapshot was taken before the last reboot")
+	case time.Since(s.Created) > maxSnapshotAge:
+		return fmt.Errorf("snapshot is too old (taken at %s)", s.Created)
+	}
+
+	for _, e := range s.Entries {
+		k := connKey{
+			srcIP:     keyAddrFromNetIP(e.Key.SrcIP),
+			srcPort:   e.Key.SrcPort,
+			dstIP:     keyAddrFromNetIP(e.Key.DstIP),
+			dstPort:   e.Key.DstPort,
+			transport: network.ConnectionType(e.Transport),
+			netns:     e.NetNS,
+		}
+		t := network.IPTranslation{
+			ReplSrcIP:   addressFromBytes(e.Translation.SrcIP),
+			ReplDstIP:   addressFromBytes(e.Translation.DstIP),
+			ReplSrcPort: e.Translation.SrcPort,
+			ReplDstPort: e.Translation.DstPort,
+		}
+		// the NAT type isn't persisted, since it is derived from the key
+		t.NATType = natType(k, &t)
+		fn(k, t)
+	}
+	return nil
+}
+
+func addressFromBytes(b []byte) util.Address {
+	if len(b) == 4 {
+		return util.V4AddressFromBytes(b)
+	}
+	return util.V6AddressFromBytes(b)
+}
+
+// readBootID returns the identifier of the current boot
+func readBootID(procRoot string) string {
+	content, err := ioutil.ReadFile(filepath.Join(procRoot, "sys/kernel/random/boot_id"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
@@ -0,0 +1,63 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conntrack")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot")
+
+	rt := newConntracker()
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+	rt.register(makeTranslatedConn(net.ParseIP("fd00::1"), net.ParseIP("fd00::2"), net.ParseIP("fd00::3"), 17, 12345, 53, 53))
+	rt.nsids = newNetnsIDs()
+	rt.nsids.set(1, 4026532001)
+	namespaced := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	namespaced.NetNS = 1
+	rt.register(namespaced)
+	require.NoError(t, saveSnapshot(path, "boot", rt.state))
+
+	restored := make(map[connKey]network.IPTranslation)
+	require.NoError(t, loadSnapshot(path, "boot", func(k connKey, trans network.IPTranslation) {
+		restored[k] = trans
+	}))
+	assert.Len(t, restored, rt.state.len())
+	rt.state.forEach(func(k connKey, trans translationValue) bool {
+		assert.Equal(t, *trans.translation(), restored[k])
+		return true
+	})
+
+	// a snapshot can only be restored once
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSnapshotFromPreviousBoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conntrack")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot")
+
+	rt := newConntracker()
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+	require.NoError(t, saveSnapshot(path, "previous", rt.state))
+
+	err = loadSnapshot(path, "current", func(connKey, network.IPTranslation) {
+		assert.Fail(t, "no entry should be restored")
+	})
+	assert.Error(t, err)
+}
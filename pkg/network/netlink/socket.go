@@ -35,6 +35,9 @@ type Socket struct {
 	// which is currently a perf bottleneck in certain workloads.
 	// https://www.spinics.net/lists/netdev/msg431592.html
 	recvbuf []byte
+
+	// ring is the memory-mapped ring the messages are received in, nil unless EnableRxRing succeeded
+	ring *rxRing
 }
 
 // NewSocket creates a new NETLINK socket
@@ -114,6 +117,10 @@ func (s *Socket) Receive() ([]netlink.Message, error) {
 
 // ReceiveInto reads one or more netlink.Messages off the socket
 func (s *Socket) ReceiveInto(b []byte) ([]netlink.Message, int32, error) {
+	if s.ring != nil {
+		return s.receiveRing(b)
+	}
+
 	oob := make([]byte, unix.CmsgSpace(24))
 	n, oobn, err := s.recvmsg(s.recvbuf, oob, 0)
 	if err != nil {
@@ -143,7 +150,7 @@ func (s *Socket) ReceiveInto(b []byte) ([]netlink.Message, int32, error) {
 		msgs = append(msgs, m)
 	}
 
-	var netns int32
+	netns := noNSID
 	if oobn > 0 {
 		oob = oob[:oobn]
 		scms, err := unix.ParseSocketControlMessage(oob)
@@ -159,14 +166,14 @@ func (s *Socket) ReceiveInto(b []byte) ([]netlink.Message, int32, error) {
 
 func parseNetNS(scms []unix.SocketControlMessage) int32 {
 	for _, m := range scms {
-		if m.Header.Level != unix.SOL_NETLINK || m.Header.Type != unix.NETLINK_LISTEN_ALL_NSID {
+		if m.Header.Level != unix.SOL_NETLINK || m.Header.Type != unix.NETLINK_LISTEN_ALL_NSID || len(m.Data) < 4 {
 			continue
 		}
 
 		return *(*int32)(unsafe.Pointer(&m.Data[0]))
 	}
 
-	return 0
+	return noNSID
 }
 
 // File descriptor of the socket
@@ -176,7 +183,11 @@ func (s *Socket) File() *os.File {
 
 // Close the socket
 func (s *Socket) Close() error {
-	return s.fd.Close()
+	err := s.fd.Close()
+	if s.ring != nil {
+		s.ring.close()
+	}
+	return err
 }
 
 // SendMessages isn't implemented in our case
@@ -236,6 +247,48 @@ func (s *Socket) GetSockoptInt(level, opt int) (int, error) {
 	return v, err
 }
 
+// The indexes of the memory counters returned by SO_MEMINFO, defined in include/uapi/linux/sock_diag.h
+const (
+	skMeminfoRmemAlloc = 0
+	skMeminfoRcvbuf    = 1
+	skMeminfoDrops     = 8
+	skMeminfoVars      = 9
+)
+
+// MemInfo is the state of the receive queue of a socket, as reported by SO_MEMINFO
+type MemInfo struct {
+	// QueuedBytes is the memory used by the messages waiting in the receive queue
+	QueuedBytes uint32
+	// RcvBufBytes is the size of the receive buffer the queue is bounded by
+	RcvBufBytes uint32
+	// Drops is the number of messages dropped since the socket was created, for lack of room in its receive queue
+	Drops uint32
+}
+
+// MemInfo returns the state of the receive queue of the socket
+func (s *Socket) MemInfo() (MemInfo, error) {
+	var mem [skMeminfoVars]uint32
+	size := uint32(unsafe.Sizeof(mem))
+
+	var errno syscall.Errno
+	doErr := s.conn.Control(func(fd uintptr) {
+		_, _, errno = unix.Syscall6(unix.SYS_GETSOCKOPT, fd, unix.SOL_SOCKET, unix.SO_MEMINFO,
+			uintptr(unsafe.Pointer(&mem[0])), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if doErr != nil {
+		return MemInfo{}, doErr
+	}
+	if errno != 0 {
+		return MemInfo{}, errno
+	}
+
+	return MemInfo{
+		QueuedBytes: mem[skMeminfoRmemAlloc],
+		RcvBufBytes: mem[skMeminfoRcvbuf],
+		Drops:       mem[skMeminfoDrops],
+	}, nil
+}
+
 // SetBPF attaches an assembled BPF program to the socket
 func (s *Socket) SetBPF(filter []bpf.RawInstruction) error {
 	prog := unix.SockFprog{
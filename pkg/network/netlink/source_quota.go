@@ -0,0 +1,118 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"sync/atomic"
+)
+
+// quotaKey is what the connections are capped by: their source address in their namespace,
+// or only their namespace when the quota is per namespace
+type quotaKey struct {
+	netns uint32
+	ip    keyAddr
+}
+
+// sourceQuota caps the number of connections stored for each source, so a single scanning or misbehaving workload
+// can't fill the state map with its flows. It must be used with the write lock of the state map held.
+// A nil sourceQuota caps nothing.
+type sourceQuota struct {
+	max          int
+	perNamespace bool
+
+	counts map[quotaKey]int
+	// owners are the origin keys of the connections counted, with the source they are counted for
+	owners map[connKey]quotaKey
+
+	exceeded int64
+}
+
+// newSourceQuota returns a quota of max connections per source address, or per network namespace when
+// perNamespace is set. It returns nil when max is 0.
+func newSourceQuota(max int, perNamespace bool) *sourceQuota {
+	if max <= 0 {
+		return nil
+	}
+	return &sourceQuota{
+		max:          max,
+		perNamespace: perNamespace,
+		counts:       make(map[quotaKey]int),
+		owners:       make(map[connKey]quotaKey),
+	}
+}
+
+// keyOf returns the source the connection of the given origin key is counted for, and whether it is capped.
+// The root namespace isn't capped when the quota is per namespace, since it holds the connections of the host.
+func (q *sourceQuota) keyOf(origin connKey) (quotaKey, bool) {
+	if q.perNamespace {
+		return quotaKey{netns: origin.netns}, origin.netns != 0
+	}
+	return quotaKey{netns: origin.netns, ip: origin.srcIP}, true
+}
+
+// allows returns whether the connection of the given origin key can be stored: whether it is stored already,
+// or its source is under quota
+func (q *sourceQuota) allows(origin connKey) bool {
+	if q == nil {
+		return true
+	}
+	if _, ok := q.owners[origin]; ok {
+		return true
+	}
+	qk, capped := q.keyOf(origin)
+	if !capped || q.counts[qk] < q.max {
+		return true
+	}
+	atomic.AddInt64(&q.exceeded, 1)
+	return false
+}
+
+// add counts the connection of the given origin key once it is stored
+func (q *sourceQuota) add(origin connKey) {
+	if q == nil {
+		return
+	}
+	if _, ok := q.owners[origin]; ok {
+		return
+	}
+	qk, capped := q.keyOf(origin)
+	if !capped {
+		return
+	}
+	q.owners[origin] = qk
+	q.counts[qk]++
+}
+
+// remove stops counting the connection of the given key, if it was
+func (q *sourceQuota) remove(k connKey) {
+	if q == nil {
+		return
+	}
+	qk, ok := q.owners[k]
+	if !ok {
+		return
+	}
+	delete(q.owners, k)
+	if q.counts[qk] <= 1 {
+		delete(q.counts, qk)
+		return
+	}
+	q.counts[qk]--
+}
+
+// sources returns the number of sources counted
+func (q *sourceQuota) sources() int {
+	if q == nil {
+		return 0
+	}
+	return len(q.counts)
+}
+
+// exceededCount returns the number of connections which weren't stored because their source was over quota
+func (q *sourceQuota) exceededCount() int64 {
+	if q == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&q.exceeded)
+}
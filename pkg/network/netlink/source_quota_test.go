@@ -0,0 +1,47 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceQuota(t *testing.T) {
+	assert.Nil(t, newSourceQuota(0, false))
+
+	q := newSourceQuota(2, false)
+	conn := func(src string, port uint16) connKey {
+		return connKey{srcIP: keyAddrFromString(src), srcPort: port}
+	}
+	for port := uint16(1); port <= 2; port++ {
+		assert.True(t, q.allows(conn("10.0.0.1", port)))
+		q.add(conn("10.0.0.1", port))
+	}
+	assert.False(t, q.allows(conn("10.0.0.1", 3)))
+	// the connections stored already are still updated, and the other sources aren't capped
+	assert.True(t, q.allows(conn("10.0.0.1", 1)))
+	assert.True(t, q.allows(conn("10.0.0.2", 1)))
+
+	q.remove(conn("10.0.0.1", 1))
+	assert.True(t, q.allows(conn("10.0.0.1", 3)))
+	assert.Equal(t, 1, q.sources())
+	assert.Equal(t, int64(1), q.exceededCount())
+}
+
+func TestSourceQuotaPerNamespace(t *testing.T) {
+	q := newSourceQuota(1, true)
+	pod := connKey{srcIP: keyAddrFromString("10.0.0.1"), netns: 4026532000}
+	q.add(pod)
+	other := pod
+	other.srcIP = keyAddrFromString("10.0.0.2")
+	assert.False(t, q.allows(other))
+
+	// the root namespace isn't capped
+	root := connKey{srcIP: keyAddrFromString("10.0.0.1")}
+	q.add(root)
+	root.srcPort = 1
+	assert.True(t, q.allows(root))
+}
@@ -0,0 +1,62 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"unsafe"
+)
+
+const (
+	// mapBucketEntries and mapLoadFactor mirror the layout of the Go runtime maps, whose buckets hold 8 entries
+	// and are grown when they are 6.5 entries full on average
+	mapBucketEntries = 8
+	mapLoadFactor    = 6.5
+)
+
+// stateSizes are the numbers of entries of the maps of the conntracker, from which their memory usage is estimated.
+// slots and publishedSlots are the numbers of slots of the table of the state map and of its published copy, and
+// indexSlots and publishedIndexSlots the ones of their reply index. The slots of the published copy are the ones it
+// doesn't share with the state map. publishedDrops is the number of keys dropped from the published copy.
+type stateSizes struct {
+	entries             int
+	slots               int
+	publishedSlots      int
+	indexSlots          int
+	publishedIndexSlots int
+	publishedDrops      int
+	grows               int64
+	shrinks             int64
+	tcpStates           int
+	counters            int
+	startTimes          int
+	statuses            int
+	ids                 int
+	ttls                int
+}
+
+// mapEntryBytes estimates the memory taken by an entry of a map with the given key and value sizes,
+// including its share of the bucket it is stored in
+func mapEntryBytes(keySize, valueSize uintptr) float64 {
+	bucket := mapBucketEntries + mapBucketEntries*(keySize+valueSize) + unsafe.Sizeof(uintptr(0))
+	return float64(bucket) / mapLoadFactor
+}
+
+// estimateStateMemory returns an estimation, in bytes, of the memory taken by the translation cache:
+// the table and reply index of the state map and of its published copy, and the maps kept along with them.
+// Allocator and GC overheads aren't accounted for, so the actual usage is somewhat higher.
+func estimateStateMemory(s stateSizes) int64 {
+	keySize := unsafe.Sizeof(connKey{})
+
+	bytes := float64(s.slots+s.publishedSlots) * float64(tableSlotSize)
+	bytes += float64(s.indexSlots+s.publishedIndexSlots) * float64(indexSlotSize)
+	bytes += float64(s.tcpStates) * mapEntryBytes(keySize, unsafe.Sizeof(TCPState(0)))
+	bytes += float64(s.counters) * mapEntryBytes(keySize, unsafe.Sizeof(Counters{}))
+	bytes += float64(s.startTimes) * mapEntryBytes(keySize, unsafe.Sizeof(uint64(0)))
+	bytes += float64(s.statuses) * mapEntryBytes(keySize, unsafe.Sizeof(Status(0)))
+	bytes += float64(s.ids) * mapEntryBytes(keySize, unsafe.Sizeof(uint32(0)))
+	bytes += float64(s.ttls) * mapEntryBytes(keySize, unsafe.Sizeof(int64(0)))
+	// the keys dropped from the published copy are held by a sync.Map, which keeps them in two maps
+	bytes += float64(s.publishedDrops) * 2 * mapEntryBytes(keySize, 2*unsafe.Sizeof(uintptr(0)))
+	return int64(bytes)
+}
@@ -0,0 +1,46 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateStateMemory(t *testing.T) {
+	assert.Equal(t, int64(0), estimateStateMemory(stateSizes{}))
+
+	small := estimateStateMemory(stateSizes{entries: 500, slots: 1000})
+	large := estimateStateMemory(stateSizes{entries: 500, slots: 2000})
+	assert.True(t, large > small)
+	// a few dozens of bytes per slot at the very least
+	assert.True(t, small > 1000*64)
+
+	// the published copy and the maps kept along with the state add to it
+	assert.True(t, estimateStateMemory(stateSizes{entries: 500, slots: 1000, publishedSlots: 1000, tcpStates: 500}) > small)
+}
+
+func TestStateMemoryStat(t *testing.T) {
+	rt := newConntracker()
+	empty := estimateStateMemory(rt.getStateSizes())
+
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+	sizes := rt.getStateSizes()
+	assert.Equal(t, 1, sizes.entries)
+	assert.Equal(t, tableMinSlots, sizes.slots)
+
+	// the published copy shares the slots of the state map until they are written
+	rt.publish()
+	sizes = rt.getStateSizes()
+	assert.Zero(t, sizes.publishedSlots)
+	assert.Zero(t, sizes.publishedIndexSlots)
+
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+	sizes = rt.getStateSizes()
+	assert.Equal(t, tableMinSlots, sizes.publishedSlots)
+	assert.Equal(t, tableMinSlots, sizes.publishedIndexSlots)
+	assert.True(t, estimateStateMemory(sizes) > empty)
+}
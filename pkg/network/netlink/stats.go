@@ -0,0 +1,60 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+// Stats are the stats of a Conntracker. The stats every conntracker has are typed, the ones specific to a
+// conntracker or to one of the wrappers (eg. the IPVS or Cilium ones) are in Extra, by name.
+type Stats struct {
+	// StateSize is the number of entries of the cache
+	StateSize int64
+	// Gets is the number of translations looked up, GetHits the number of them found
+	Gets    int64
+	GetHits int64
+	// Registers is the number of conntrack entries registered, RegistersDropped the number of them which
+	// weren't stored, whatever the reason
+	Registers        int64
+	RegistersDropped int64
+	// Unregisters is the number of translations deleted along with their connection
+	Unregisters int64
+	// SamplingPercent is the percentage of the conntrack events processed, 0 when the events aren't sampled
+	SamplingPercent int64
+
+	Extra map[string]int64
+}
+
+// Map returns the stats by name, the way the debug endpoints and the tracer stats report them
+func (s Stats) Map() map[string]int64 {
+	m := make(map[string]int64, len(s.Extra)+9)
+	for k, v := range s.Extra {
+		m[k] = v
+	}
+	m["state_size"] = s.StateSize
+	m["gets_total"] = s.Gets
+	addHitStats(m, s.Gets, s.GetHits)
+	m["registers_total"] = s.Registers
+	m["registers_dropped"] = s.RegistersDropped
+	m["unregisters_total"] = s.Unregisters
+	if s.SamplingPercent != 0 {
+		m["sampling_pct"] = s.SamplingPercent
+	}
+	return m
+}
+
+// set sets an extra stat
+func (s *Stats) set(name string, value int64) {
+	if s.Extra == nil {
+		s.Extra = make(map[string]int64)
+	}
+	s.Extra[name] = value
+}
+
+// add adds the typed stats of other to the ones of s, the extra stats being left as they are
+func (s *Stats) add(other Stats) {
+	s.StateSize += other.StateSize
+	s.Gets += other.Gets
+	s.GetHits += other.GetHits
+	s.Registers += other.Registers
+	s.RegistersDropped += other.RegistersDropped
+	s.Unregisters += other.Unregisters
+}
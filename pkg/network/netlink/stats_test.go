@@ -0,0 +1,33 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsMap(t *testing.T) {
+	s := Stats{StateSize: 4, Gets: 3, GetHits: 2, Registers: 2, RegistersDropped: 1}
+	s.set("resyncs_total", 5)
+
+	m := s.Map()
+	assert.Equal(t, int64(4), m["state_size"])
+	assert.Equal(t, int64(3), m["gets_total"])
+	assert.Equal(t, int64(1), m["gets_misses"])
+	assert.Equal(t, int64(66), m["gets_hit_pct"])
+	assert.Equal(t, int64(2), m["registers_total"])
+	assert.Equal(t, int64(1), m["registers_dropped"])
+	assert.Equal(t, int64(0), m["unregisters_total"])
+	assert.Equal(t, int64(5), m["resyncs_total"])
+	// the events aren't sampled
+	assert.NotContains(t, m, "sampling_pct")
+}
+
+func TestStatsAdd(t *testing.T) {
+	s := Stats{StateSize: 4, Gets: 3}
+	s.add(Stats{StateSize: 2, Gets: 1, Extra: map[string]int64{"ipvs_state_size": 2}})
+	assert.Equal(t, Stats{StateSize: 6, Gets: 4}, s)
+}
@@ -0,0 +1,60 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+import "strings"
+
+// Status holds the status flags of a conntrack entry (enum ip_conntrack_status), it is zero when they are unknown
+type Status uint32
+
+// Status flags. The other flags the kernel sets are kept, but have no name.
+const (
+	// StatusSeenReply is set once the connection saw packets in the reply direction
+	StatusSeenReply Status = 1 << 1
+	// StatusAssured is set once the connection is established both ways (eg. its TCP handshake completed), after
+	// which conntrack doesn't evict its entry early when the table is full
+	StatusAssured Status = 1 << 2
+	// StatusDying is set on the entries being destroyed
+	StatusDying Status = 1 << 9
+)
+
+var statusNames = [...]struct {
+	flag Status
+	name string
+}{
+	{StatusSeenReply, "SEEN_REPLY"},
+	{StatusAssured, "ASSURED"},
+	{StatusDying, "DYING"},
+}
+
+// SeenReply returns whether the connection saw packets in the reply direction
+func (s Status) SeenReply() bool {
+	return s&StatusSeenReply != 0
+}
+
+// Assured returns whether conntrack considers the connection established both ways
+func (s Status) Assured() bool {
+	return s&StatusAssured != 0
+}
+
+// Dying returns whether the entry of the connection is being destroyed
+func (s Status) Dying() bool {
+	return s&StatusDying != 0
+}
+
+func (s Status) String() string {
+	if s == 0 {
+		return "NONE"
+	}
+	var names []string
+	for _, n := range statusNames {
+		if s&n.flag != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "UNKNOWN"
+	}
+	return strings.Join(names, "|")
+}
@@ -0,0 +1,22 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	s := StatusSeenReply | StatusAssured | 0x8
+	assert.True(t, s.SeenReply())
+	assert.True(t, s.Assured())
+	assert.False(t, s.Dying())
+	assert.Equal(t, "SEEN_REPLY|ASSURED", s.String())
+
+	assert.Equal(t, "ASSURED|DYING", (StatusDying | StatusAssured).String())
+	assert.Equal(t, "NONE", Status(0).String())
+	assert.Equal(t, "UNKNOWN", Status(0x8).String())
+}
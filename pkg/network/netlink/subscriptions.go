@@ -0,0 +1,210 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+)
+
+// subscriptionBufferSize is the number of events a subscriber can lag behind before events are dropped
+const subscriptionBufferSize = 1024
+
+// TranslationEventType is the kind of change a TranslationEvent notifies about
+type TranslationEventType uint8
+
+const (
+	// TranslationCreated is sent when a translation is stored, or when the translation of a connection changes.
+	// The netlink conntracker stores each connection once, and notifies about its origin direction only.
+	TranslationCreated TranslationEventType = iota
+	// TranslationDeleted is sent when a translation is removed
+	TranslationDeleted
+	// TableFlushed is sent when a flush of the conntrack table is detected, before the cache is rebuilt. It has no
+	// connection nor translation, and is sent to every subscriber whatever its filter.
+	TableFlushed
+	// ConnectionDestroyed is sent when conntrack destroys the entry of a UDP connection, whether it is translated
+	// or not, when Config.UDPCloseHints is set. The connection is the origin direction of the entry, and the
+	// translation holds its reply direction.
+	ConnectionDestroyed
+)
+
+// TranslationEvent notifies about a change of the translation of a connection
+type TranslationEvent struct {
+	Type TranslationEventType
+
+	Source    util.Address
+	SPort     uint16
+	Dest      util.Address
+	DPort     uint16
+	Transport network.ConnectionType
+	// NetNS is the inode of the network namespace of the connection, 0 for the root namespace
+	NetNS uint32
+
+	Translation network.IPTranslation
+	// Counters are the last counters of a destroyed connection, when conntrack counts them
+	Counters Counters
+	// Reply is set on the events about the reply direction of a connection, which the conntrackers storing both
+	// directions of the connections send along with the events about their origin direction
+	Reply bool
+}
+
+// TranslationFilter selects the events a subscriber is notified about. A nil filter selects all events.
+type TranslationFilter func(TranslationEvent) bool
+
+type subscription struct {
+	filter TranslationFilter
+	events chan TranslationEvent
+}
+
+// translationNotifier dispatches translation events to subscribers. Its zero value is ready to use.
+// Events are never blocking: they are dropped when a subscriber doesn't keep up.
+type translationNotifier struct {
+	mux           sync.RWMutex
+	subscriptions map[*subscription]struct{}
+
+	// subscribers is read without the lock, so events aren't built when nobody listens
+	subscribers int32
+	dropped     int64
+}
+
+func (n *translationNotifier) subscribe(filter TranslationFilter) (<-chan TranslationEvent, func()) {
+	s := &subscription{
+		filter: filter,
+		events: make(chan TranslationEvent, subscriptionBufferSize),
+	}
+
+	n.mux.Lock()
+	if n.subscriptions == nil {
+		n.subscriptions = make(map[*subscription]struct{})
+	}
+	n.subscriptions[s] = struct{}{}
+	atomic.StoreInt32(&n.subscribers, int32(len(n.subscriptions)))
+	n.mux.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			n.mux.Lock()
+			delete(n.subscriptions, s)
+			atomic.StoreInt32(&n.subscribers, int32(len(n.subscriptions)))
+			n.mux.Unlock()
+			close(s.events)
+		})
+	}
+	return s.events, cancel
+}
+
+// active returns whether there is any subscriber to notify
+func (n *translationNotifier) active() bool {
+	return atomic.LoadInt32(&n.subscribers) > 0
+}
+
+func (n *translationNotifier) notify(typ TranslationEventType, k connKey, t *network.IPTranslation) {
+	n.notifyDirection(typ, k, t, false)
+}
+
+// notifyDirection notifies about the given direction of a connection, the reply one when reply is set
+func (n *translationNotifier) notifyDirection(typ TranslationEventType, k connKey, t *network.IPTranslation, reply bool) {
+	if !n.active() {
+		return
+	}
+
+	e := TranslationEvent{
+		Type:        typ,
+		Source:      k.srcIP.address(),
+		SPort:       k.srcPort,
+		Dest:        k.dstIP.address(),
+		DPort:       k.dstPort,
+		Transport:   k.transport,
+		NetNS:       k.netns,
+		Translation: *t,
+		Reply:       reply,
+	}
+	n.dispatch(e)
+}
+
+// dispatch sends the event to the subscribers it passes the filter of
+func (n *translationNotifier) dispatch(e TranslationEvent) {
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+	for s := range n.subscriptions {
+		if s.filter != nil && !s.filter(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			atomic.AddInt64(&n.dropped, 1)
+		}
+	}
+}
+
+// notifyDestroyed notifies about the destruction of the conntrack entry of the given key, whose reply direction is
+// the given translation
+func (n *translationNotifier) notifyDestroyed(k connKey, reply network.IPTranslation, counters Counters) {
+	if !n.active() {
+		return
+	}
+
+	e := TranslationEvent{
+		Type:        ConnectionDestroyed,
+		Source:      k.srcIP.address(),
+		SPort:       k.srcPort,
+		Dest:        k.dstIP.address(),
+		DPort:       k.dstPort,
+		Transport:   k.transport,
+		NetNS:       k.netns,
+		Translation: reply,
+		Counters:    counters,
+	}
+	n.dispatch(e)
+}
+
+// notifyFlush notifies every subscriber about a flush of the conntrack table
+func (n *translationNotifier) notifyFlush() {
+	if !n.active() {
+		return
+	}
+
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+	for s := range n.subscriptions {
+		select {
+		case s.events <- TranslationEvent{Type: TableFlushed}:
+		default:
+			atomic.AddInt64(&n.dropped, 1)
+		}
+	}
+}
+
+func (n *translationNotifier) getStats() map[string]int64 {
+	return map[string]int64{
+		"subscribers":              int64(atomic.LoadInt32(&n.subscribers)),
+		"subscription_events_lost": atomic.LoadInt64(&n.dropped),
+	}
+}
+
+// notifyDiff notifies about the differences between two states replacing each other, whose keys of the reply
+// directions of the connections are the given ones
+func (n *translationNotifier) notifyDiff(before, after map[connKey]*network.IPTranslation, beforeReplies, afterReplies map[connKey]struct{}) {
+	if !n.active() {
+		return
+	}
+
+	for k, t := range after {
+		if old, ok := before[k]; !ok || *old != *t {
+			_, reply := afterReplies[k]
+			n.notifyDirection(TranslationCreated, k, t, reply)
+		}
+	}
+	for k, t := range before {
+		if _, ok := after[k]; !ok {
+			_, reply := beforeReplies[k]
+			n.notifyDirection(TranslationDeleted, k, t, reply)
+		}
+	}
+}
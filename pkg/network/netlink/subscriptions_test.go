@@ -0,0 +1,107 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribe(t *testing.T) {
+	rt := newConntracker()
+	events, cancel := rt.Subscribe(func(e TranslationEvent) bool {
+		return e.Source == util.AddressFromString("10.0.0.0")
+	})
+
+	c := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	rt.register(c)
+	// registering the same translation again isn't notified
+	rt.register(c)
+
+	require.Len(t, events, 1)
+	e := <-events
+	assert.Equal(t, TranslationCreated, e.Type)
+	assert.Equal(t, util.AddressFromString("50.30.40.10"), e.Dest)
+	assert.Equal(t, network.TCP, e.Transport)
+	assert.Equal(t, util.AddressFromString("20.0.0.0"), e.Translation.ReplSrcIP)
+
+	rt.unregister(c)
+	require.Len(t, events, 1)
+	assert.Equal(t, TranslationDeleted, (<-events).Type)
+
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+	assert.False(t, rt.notifier.active())
+
+	// cancelling twice is harmless
+	cancel()
+}
+
+func TestSubscriptionDropsEvents(t *testing.T) {
+	var n translationNotifier
+	events, cancel := n.subscribe(nil)
+	defer cancel()
+
+	k := connKey{srcIP: keyAddrFromString("10.0.0.0"), dstIP: keyAddrFromString("20.0.0.0"), transport: network.TCP}
+	for i := 0; i < subscriptionBufferSize+10; i++ {
+		n.notify(TranslationCreated, k, &network.IPTranslation{})
+	}
+	assert.Len(t, events, subscriptionBufferSize)
+	assert.Equal(t, int64(10), n.getStats()["subscription_events_lost"])
+}
+
+func TestNotifyFlush(t *testing.T) {
+	var n translationNotifier
+	// nothing is built when nobody listens
+	n.notifyFlush()
+
+	events, cancel := n.subscribe(func(e TranslationEvent) bool {
+		return e.Source == util.AddressFromString("10.0.0.0")
+	})
+	defer cancel()
+
+	// the flushes are sent whatever the filter
+	n.notifyFlush()
+	require.Len(t, events, 1)
+	assert.Equal(t, TableFlushed, (<-events).Type)
+}
+
+func TestNotifyDiff(t *testing.T) {
+	var n translationNotifier
+	events, cancel := n.subscribe(nil)
+	defer cancel()
+
+	kept := connKey{srcIP: keyAddrFromString("10.0.0.1"), dstIP: keyAddrFromString("20.0.0.1"), transport: network.TCP}
+	removed := connKey{srcIP: keyAddrFromString("10.0.0.2"), dstIP: keyAddrFromString("20.0.0.2"), transport: network.TCP}
+	added := connKey{srcIP: keyAddrFromString("10.0.0.3"), dstIP: keyAddrFromString("20.0.0.3"), transport: network.TCP}
+	translation := &network.IPTranslation{ReplSrcIP: util.AddressFromString("30.0.0.1"), ReplDstIP: util.AddressFromString("10.0.0.1")}
+
+	n.notifyDiff(
+		map[connKey]*network.IPTranslation{kept: translation, removed: translation},
+		map[connKey]*network.IPTranslation{kept: translation, added: translation},
+		map[connKey]struct{}{removed: {}},
+		nil,
+	)
+
+	require.Len(t, events, 2)
+	got := map[TranslationEventType]util.Address{}
+	replies := map[TranslationEventType]bool{}
+	for i := 0; i < 2; i++ {
+		e := <-events
+		got[e.Type] = e.Source
+		replies[e.Type] = e.Reply
+	}
+	assert.Equal(t, map[TranslationEventType]util.Address{
+		TranslationCreated: added.srcIP.address(),
+		TranslationDeleted: removed.srcIP.address(),
+	}, got)
+	// the events tell the reply directions of the connections apart
+	assert.Equal(t, map[TranslationEventType]bool{TranslationCreated: false, TranslationDeleted: true}, replies)
+}
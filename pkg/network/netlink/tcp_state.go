@@ -0,0 +1,46 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+// TCPState is the state of a TCP connection, as tracked by conntrack (enum tcp_conntrack)
+type TCPState uint8
+
+// TCP states, TCPStateNone is used when the state is unknown
+const (
+	TCPStateNone TCPState = iota
+	TCPStateSynSent
+	TCPStateSynRecv
+	TCPStateEstablished
+	TCPStateFinWait
+	TCPStateCloseWait
+	TCPStateLastAck
+	TCPStateTimeWait
+	TCPStateClose
+	TCPStateSynSent2
+)
+
+var tcpStateNames = [...]string{
+	TCPStateNone:        "NONE",
+	TCPStateSynSent:     "SYN_SENT",
+	TCPStateSynRecv:     "SYN_RECV",
+	TCPStateEstablished: "ESTABLISHED",
+	TCPStateFinWait:     "FIN_WAIT",
+	TCPStateCloseWait:   "CLOSE_WAIT",
+	TCPStateLastAck:     "LAST_ACK",
+	TCPStateTimeWait:    "TIME_WAIT",
+	TCPStateClose:       "CLOSE",
+	TCPStateSynSent2:    "SYN_SENT2",
+}
+
+func (s TCPState) String() string {
+	if int(s) < len(tcpStateNames) {
+		return tcpStateNames[s]
+	}
+	return "UNKNOWN"
+}
+
+// Closed returns whether the kernel considers the connection closed
+func (s TCPState) Closed() bool {
+	return s == TCPStateTimeWait || s == TCPStateClose
+}
@@ -0,0 +1,261 @@
+// +build linux windows
+// +build !android
+
+package netlink
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// ToggleConntracker is a Conntracker which can be enabled and disabled at runtime, eg. to stop the NAT resolution
+// of a host whose conntrack events are too costly to process. Disabling it closes the conntracker, which stops
+// consuming the conntrack events and frees its state, and behaves like a no-op conntracker until it is enabled
+// again, which initializes a new conntracker, loading the conntrack table anew. The subscriptions are kept across
+// the toggles, and are forwarded the events of the conntracker while it is enabled.
+type ToggleConntracker struct {
+	current atomic.Value
+	enabled int32
+
+	// mux serializes the toggles and the subscriptions, along with Close
+	mux           sync.Mutex
+	closed        bool
+	init          func() (Conntracker, error)
+	subscriptions map[*toggleSubscription]struct{}
+
+	enables        int64
+	enableFailures int64
+	disables       int64
+}
+
+// NewToggleConntracker returns a ToggleConntracker initializing its conntracker with init whenever it is enabled.
+// When it is created enabled and the initialization fails, it is left disabled and the error is returned.
+func NewToggleConntracker(init func() (Conntracker, error), enabled bool) (*ToggleConntracker, error) {
+	t := &ToggleConntracker{
+		init:          init,
+		subscriptions: make(map[*toggleSubscription]struct{}),
+	}
+	t.current.Store(conntrackerHolder{NewNoOpConntracker()})
+	if !enabled {
+		return t, nil
+	}
+	return t, t.Enable()
+}
+
+// Enable initializes the conntracker, unless it is already enabled. It is left disabled when the initialization
+// fails.
+func (t *ToggleConntracker) Enable() error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.closed || t.Enabled() {
+		return nil
+	}
+
+	c, err := t.init()
+	if err != nil {
+		atomic.AddInt64(&t.enableFailures, 1)
+		return err
+	}
+	t.current.Store(conntrackerHolder{c})
+	for s := range t.subscriptions {
+		s.forward(c)
+	}
+	atomic.StoreInt32(&t.enabled, 1)
+	atomic.AddInt64(&t.enables, 1)
+	log.Infof("conntrack enabled")
+	return nil
+}
+
+// Disable closes the conntracker, unless it is already disabled, the lookups missing until it is enabled again
+func (t *ToggleConntracker) Disable() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.closed || !t.Enabled() {
+		return
+	}
+
+	c := t.get()
+	t.current.Store(conntrackerHolder{NewNoOpConntracker()})
+	for s := range t.subscriptions {
+		s.stopForwarding()
+	}
+	atomic.StoreInt32(&t.enabled, 0)
+	atomic.AddInt64(&t.disables, 1)
+	c.Close()
+	log.Infof("conntrack disabled, the tracer will continue without NAT tracking")
+}
+
+// Enabled returns whether the conntracker is enabled
+func (t *ToggleConntracker) Enabled() bool {
+	return atomic.LoadInt32(&t.enabled) == 1
+}
+
+func (t *ToggleConntracker) get() Conntracker {
+	return t.current.Load().(conntrackerHolder).Conntracker
+}
+
+func (t *ToggleConntracker) GetTranslationForConn(c network.ConnectionStats) *network.IPTranslation {
+	return t.get().GetTranslationForConn(c)
+}
+
+func (t *ToggleConntracker) GetTranslationsForConns(conns []network.ConnectionStats) []*network.IPTranslation {
+	return t.get().GetTranslationsForConns(conns)
+}
+
+func (t *ToggleConntracker) GetEntryForConn(c network.ConnectionStats) *ConntrackEntry {
+	return t.get().GetEntryForConn(c)
+}
+
+func (t *ToggleConntracker) DeleteTranslation(c network.ConnectionStats) {
+	t.get().DeleteTranslation(c)
+}
+
+func (t *ToggleConntracker) DeleteTranslations(conns []network.ConnectionStats) {
+	t.get().DeleteTranslations(conns)
+}
+
+func (t *ToggleConntracker) DumpCachedTable() []network.DebugConntrackEntry {
+	return t.get().DumpCachedTable()
+}
+
+// Subscribe returns a subscription forwarded the events of the conntracker while it is enabled
+func (t *ToggleConntracker) Subscribe(filter TranslationFilter) (<-chan TranslationEvent, func()) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	s := &toggleSubscription{
+		filter: filter,
+		events: make(chan TranslationEvent, subscriptionBufferSize),
+	}
+	if t.Enabled() {
+		s.forward(t.get())
+	}
+	t.subscriptions[s] = struct{}{}
+
+	var once sync.Once
+	return s.events, func() {
+		once.Do(func() {
+			t.mux.Lock()
+			defer t.mux.Unlock()
+			delete(t.subscriptions, s)
+			s.stopForwarding()
+			close(s.events)
+		})
+	}
+}
+
+// toggleSubscription is a subscription to a ToggleConntracker
+type toggleSubscription struct {
+	filter TranslationFilter
+	events chan TranslationEvent
+	// stop stops forwarding the events of the conntracker, nil when they aren't forwarded
+	stop func()
+}
+
+// forward subscribes to the given conntracker, and forwards its events until stopForwarding is called
+func (s *toggleSubscription) forward(c Conntracker) {
+	events, cancel := c.Subscribe(s.filter)
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				select {
+				case s.events <- e:
+				default:
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	s.stop = func() {
+		close(done)
+		<-exited
+		cancel()
+	}
+}
+
+func (s *toggleSubscription) stopForwarding() {
+	if s.stop != nil {
+		s.stop()
+		s.stop = nil
+	}
+}
+
+func (t *ToggleConntracker) GetTCPStateForConn(c network.ConnectionStats) TCPState {
+	return t.get().GetTCPStateForConn(c)
+}
+
+func (t *ToggleConntracker) GetTCPStatesForConns(conns []network.ConnectionStats) []TCPState {
+	return t.get().GetTCPStatesForConns(conns)
+}
+
+func (t *ToggleConntracker) GetCountersForConn(c network.ConnectionStats) (Counters, bool) {
+	return t.get().GetCountersForConn(c)
+}
+
+func (t *ToggleConntracker) GetStartTimeForConn(c network.ConnectionStats) (time.Time, bool) {
+	return t.get().GetStartTimeForConn(c)
+}
+
+func (t *ToggleConntracker) GetStatusForConn(c network.ConnectionStats) Status {
+	return t.get().GetStatusForConn(c)
+}
+
+func (t *ToggleConntracker) GetNATGateways() []util.Address {
+	return t.get().GetNATGateways()
+}
+
+func (t *ToggleConntracker) GetRecentErrors() []string {
+	return t.get().GetRecentErrors()
+}
+
+func (t *ToggleConntracker) Refresh(family uint8) error {
+	return t.get().Refresh(family)
+}
+
+// SetTargetRateLimit is only applied to the conntracker while it is enabled
+func (t *ToggleConntracker) SetTargetRateLimit(limit int) {
+	t.get().SetTargetRateLimit(limit)
+}
+
+// Describe returns the description of the conntracker while it is enabled, and a disabled no-op one otherwise
+func (t *ToggleConntracker) Describe() network.ConntrackDescription {
+	return t.get().Describe()
+}
+
+// GetStats returns the stats of the conntracker while it is enabled, along with how many times it was toggled
+func (t *ToggleConntracker) GetStats() Stats {
+	var stats Stats
+	if t.Enabled() {
+		stats = t.get().GetStats()
+	}
+	stats.set("toggle_enabled", int64(atomic.LoadInt32(&t.enabled)))
+	stats.set("toggle_enables", atomic.LoadInt64(&t.enables))
+	stats.set("toggle_enable_failures", atomic.LoadInt64(&t.enableFailures))
+	stats.set("toggle_disables", atomic.LoadInt64(&t.disables))
+	return stats
+}
+
+// Close closes the conntracker, which can't be enabled anymore
+func (t *ToggleConntracker) Close() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	for s := range t.subscriptions {
+		s.stopForwarding()
+	}
+	t.get().Close()
+}
@@ -0,0 +1,110 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToggleConntracker(t *testing.T) {
+	conn := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("10.96.0.1"),
+		DPort:  443,
+		Type:   network.TCP,
+	}
+	trans := &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("10.244.1.1"),
+		ReplDstIP:   util.AddressFromString("10.0.0.1"),
+		ReplSrcPort: 8443,
+		ReplDstPort: 12345,
+	}
+
+	var fakes []*FakeConntracker
+	ctr, err := NewToggleConntracker(func() (Conntracker, error) {
+		fake := NewFakeConntracker()
+		fake.AddTranslation(conn, trans)
+		fakes = append(fakes, fake)
+		return fake, nil
+	}, false)
+	require.NoError(t, err)
+	defer ctr.Close()
+
+	// the conntracker isn't initialized until it is enabled
+	assert.False(t, ctr.Enabled())
+	assert.Empty(t, fakes)
+	assert.Nil(t, ctr.GetTranslationForConn(conn))
+	assert.Equal(t, "disabled", ctr.Describe().Status)
+
+	// the subscriptions are kept across the toggles
+	events, cancel := ctr.Subscribe(nil)
+	defer cancel()
+
+	require.NoError(t, ctr.Enable())
+	require.NoError(t, ctr.Enable())
+	assert.True(t, ctr.Enabled())
+	require.Len(t, fakes, 1)
+	assert.Equal(t, trans, ctr.GetTranslationForConn(conn))
+	stats := ctr.GetStats().Extra
+	assert.Equal(t, int64(1), stats["toggle_enabled"])
+	assert.Equal(t, int64(1), stats["toggle_enables"])
+
+	// disabling closes the conntracker, and enabling again initializes a new one
+	ctr.Disable()
+	ctr.Disable()
+	assert.False(t, ctr.Enabled())
+	assert.True(t, fakes[0].Closed())
+	assert.Nil(t, ctr.GetTranslationForConn(conn))
+	assert.Equal(t, int64(1), ctr.GetStats().Extra["toggle_disables"])
+
+	require.NoError(t, ctr.Enable())
+	require.Len(t, fakes, 2)
+	assert.Equal(t, trans, ctr.GetTranslationForConn(conn))
+
+	other := conn
+	other.SPort++
+	fakes[1].AddTranslation(other, trans)
+	select {
+	case e := <-events:
+		assert.Equal(t, TranslationCreated, e.Type)
+		assert.Equal(t, other.SPort, e.SPort)
+	case <-time.After(time.Second):
+		require.Fail(t, "the subscription wasn't forwarded the events of the new conntracker")
+	}
+
+	ctr.Close()
+	assert.True(t, fakes[1].Closed())
+	// it can't be enabled once closed
+	ctr.Disable()
+	require.NoError(t, ctr.Enable())
+	assert.Len(t, fakes, 2)
+}
+
+func TestToggleConntrackerEnableFailure(t *testing.T) {
+	fail := true
+	ctr, err := NewToggleConntracker(func() (Conntracker, error) {
+		if fail {
+			return nil, errors.New("conntrack unavailable")
+		}
+		return NewFakeConntracker(), nil
+	}, true)
+	assert.Error(t, err)
+	require.NotNil(t, ctr)
+	defer ctr.Close()
+
+	assert.False(t, ctr.Enabled())
+	assert.Equal(t, int64(1), ctr.GetStats().Extra["toggle_enable_failures"])
+
+	fail = false
+	require.NoError(t, ctr.Enable())
+	assert.True(t, ctr.Enabled())
+}
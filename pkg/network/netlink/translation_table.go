@@ -0,0 +1,521 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math/bits"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// tableMaxLoad is the share of the slots of the table in use above which it is grown, doubling its slots.
+	// Below a share of tableMinLoad, it is shrunk so a quarter of its slots are used.
+	tableMaxLoad = 0.75
+	tableMinLoad = 0.125
+
+	// tableMinSlots is the number of slots of a table which isn't pre-allocated
+	tableMinSlots = 64
+
+	// tableMigrationStep is the number of slots migrated by each update of the table while it is resized
+	tableMigrationStep = 16
+)
+
+// compactionStrategy is how a translationTable is shrunk once most of its entries were deleted
+type compactionStrategy int
+
+const (
+	// compactIncremental migrates the slots to the smaller table a few at a time, with the updates following the shrink
+	compactIncremental compactionStrategy = iota
+	// compactCopy moves all the entries to the smaller table at once, which releases the memory of the larger one
+	// sooner at the cost of a longer update
+	compactCopy
+	// compactOff never shrinks the table, which keeps the slots of its largest size
+	compactOff
+)
+
+var compactionStrategies = map[string]compactionStrategy{
+	"incremental": compactIncremental,
+	"copy":        compactCopy,
+	"off":         compactOff,
+}
+
+// parseCompactionStrategy returns the compaction strategy of the given name, the incremental one when it is empty or
+// unknown
+func parseCompactionStrategy(name string) compactionStrategy {
+	if name == "" {
+		return compactIncremental
+	}
+	strategy, ok := compactionStrategies[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		log.Warnf("ignoring unknown conntrack compaction strategy %q, valid strategies are: incremental, copy, off", name)
+		return compactIncremental
+	}
+	return strategy
+}
+
+// tableSlotSize is the size of a slot of the table, from which its memory usage is estimated
+const tableSlotSize = unsafe.Sizeof(tableSlot{})
+
+// tableSlot is a slot of a translationTable, free when its hash is 0
+type tableSlot struct {
+	hash  uint64
+	key   connKey
+	value translationValue
+	// deleted marks the entries deleted from the slots being migrated, whose probe sequences must be kept intact
+	deleted bool
+}
+
+// tableChunkSlots is the number of slots of the chunks the slots of a table are allocated in, 96KB
+const (
+	tableChunkBits  = 10
+	tableChunkSlots = 1 << tableChunkBits
+)
+
+// tableSlots are the slots of a translationTable, allocated in chunks of tableChunkSlots slots
+type tableSlots struct {
+	chunks [][]tableSlot
+	// shared marks the chunks shared with a copy of the slots, which must be copied before they are written
+	shared []bool
+	n      int
+}
+
+// newTableSlots allocates the given number of slots
+func newTableSlots(n int) *tableSlots {
+	s := &tableSlots{n: n}
+	for i := 0; i < n; i += tableChunkSlots {
+		size := n - i
+		if size > tableChunkSlots {
+			size = tableChunkSlots
+		}
+		s.chunks = append(s.chunks, make([]tableSlot, size))
+	}
+	s.shared = make([]bool, len(s.chunks))
+	return s
+}
+
+func (s *tableSlots) len() int {
+	return s.n
+}
+
+func (s *tableSlots) home(h uint64) int {
+	return int(h % uint64(s.n))
+}
+
+func (s *tableSlots) next(i int) int {
+	if i++; i == s.n {
+		return 0
+	}
+	return i
+}
+
+// at returns the slot i, to be read only
+func (s *tableSlots) at(i int) *tableSlot {
+	return &s.chunks[i>>tableChunkBits][i&(tableChunkSlots-1)]
+}
+
+// mut returns the slot i to be written, copying its chunk first when it is shared
+func (s *tableSlots) mut(i int) *tableSlot {
+	c := i >> tableChunkBits
+	if s.shared[c] {
+		s.chunks[c] = append([]tableSlot(nil), s.chunks[c]...)
+		s.shared[c] = false
+	}
+	return &s.chunks[c][i&(tableChunkSlots-1)]
+}
+
+// share returns a read-only copy of the slots, which shares their chunks until they are written
+func (s *tableSlots) share() *tableSlots {
+	for i := range s.shared {
+		s.shared[i] = true
+	}
+	return &tableSlots{chunks: append([][]tableSlot(nil), s.chunks...), n: s.n}
+}
+
+// allChunks returns the chunks of the slots, none when they are nil
+func (s *tableSlots) allChunks() [][]tableSlot {
+	if s == nil {
+		return nil
+	}
+	return s.chunks
+}
+
+// translationTable holds the entries of the state map. It is an open-addressing hash table with linear probing,
+// keyed by the SipHash of the keys, designed for the workload of the state map:
+//  - the slots don't hold any pointer, so the GC doesn't scan them
+//  - the slots are allocated in chunks shared with the copies of the table published for lock-free reads, and a
+//    chunk is only copied when it is written after a publication, so publishing the table doesn't copy it
+//  - the entries are stored in the slots themselves, next to the hash of their key, so the probe sequences
+//    compare the hashes first, in the same cache lines
+//  - the entries are deleted by shifting the following entries of their probe sequence back, so no tombstone
+//    is left behind
+//  - the table is resized incrementally: the slots are migrated a few at a time by the updates following the
+//    resize, rather than all at once, and it is shrunk once most of its entries were deleted, which compacts it
+//    along the way, according to its compaction strategy. A pre-allocated table isn't shrunk below its initial size.
+type translationTable struct {
+	slots *tableSlots
+	// used is the number of entries held in slots
+	used int
+	// old are the slots being migrated, nil otherwise. The ones before cursor were migrated already.
+	old    *tableSlots
+	cursor int
+	// count is the number of entries of the table, whether they are migrated or not
+	count    int
+	minSlots int
+
+	// k0 and k1 are the SipHash key, random so remote hosts, which partly choose the keys, can't make them collide
+	k0, k1 uint64
+
+	// compaction is how the table is shrunk. It is shrunk by the deletions unless deferCompaction is set, in which
+	// case compact is called by the owner of the table.
+	compaction      compactionStrategy
+	deferCompaction bool
+
+	grows   int64
+	shrinks int64
+}
+
+// newTranslationTable returns a table whose slots are pre-allocated for the given number of entries
+func newTranslationTable(entries int) *translationTable {
+	minSlots := int(float64(entries)/tableMaxLoad) + 1
+	if minSlots < tableMinSlots {
+		minSlots = tableMinSlots
+	}
+	var key [16]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		binary.LittleEndian.PutUint64(key[:], uint64(time.Now().UnixNano()))
+	}
+	return &translationTable{
+		slots:    newTableSlots(minSlots),
+		minSlots: minSlots,
+		k0:       binary.LittleEndian.Uint64(key[:8]),
+		k1:       binary.LittleEndian.Uint64(key[8:]),
+	}
+}
+
+// hash returns the SipHash-2-4 of the key, which is never 0 since it marks the free slots
+func (t *translationTable) hash(k connKey) uint64 {
+	s := sipState{
+		v0: t.k0 ^ 0x736f6d6570736575,
+		v1: t.k1 ^ 0x646f72616e646f6d,
+		v2: t.k0 ^ 0x6c7967656e657261,
+		v3: t.k1 ^ 0x7465646279746573,
+	}
+	s.block(binary.LittleEndian.Uint64(k.srcIP[:8]))
+	s.block(binary.LittleEndian.Uint64(k.srcIP[8:]))
+	s.block(binary.LittleEndian.Uint64(k.dstIP[:8]))
+	s.block(binary.LittleEndian.Uint64(k.dstIP[8:]))
+	s.block(uint64(k.srcPort) | uint64(k.dstPort)<<16 | uint64(k.netns)<<32)
+	s.block(uint64(k.transport))
+	// the message is 48 bytes long, so the last block only holds its length
+	s.block(48 << 56)
+	s.v2 ^= 0xff
+	s.round()
+	s.round()
+	s.round()
+	s.round()
+	if h := s.v0 ^ s.v1 ^ s.v2 ^ s.v3; h != 0 {
+		return h
+	}
+	return 1
+}
+
+type sipState struct {
+	v0, v1, v2, v3 uint64
+}
+
+func (s *sipState) block(m uint64) {
+	s.v3 ^= m
+	s.round()
+	s.round()
+	s.v0 ^= m
+}
+
+func (s *sipState) round() {
+	s.v0 += s.v1
+	s.v1 = bits.RotateLeft64(s.v1, 13)
+	s.v1 ^= s.v0
+	s.v0 = bits.RotateLeft64(s.v0, 32)
+	s.v2 += s.v3
+	s.v3 = bits.RotateLeft64(s.v3, 16)
+	s.v3 ^= s.v2
+	s.v0 += s.v3
+	s.v3 = bits.RotateLeft64(s.v3, 21)
+	s.v3 ^= s.v0
+	s.v2 += s.v1
+	s.v1 = bits.RotateLeft64(s.v1, 17)
+	s.v1 ^= s.v2
+	s.v2 = bits.RotateLeft64(s.v2, 32)
+}
+
+// findSlot returns the slot of the given key in the given slots, or else the free slot ending its probe sequence.
+// There always is one, since the slots are never full.
+func findSlot(slots *tableSlots, h uint64, k connKey) (int, bool) {
+	for i := slots.home(h); ; i = slots.next(i) {
+		s := slots.at(i)
+		if s.hash == 0 {
+			return i, false
+		}
+		if s.hash == h && s.key == k && !s.deleted {
+			return i, true
+		}
+	}
+}
+
+// findOld returns the slot of the given key in the slots being migrated, unless it was migrated already
+func (t *translationTable) findOld(h uint64, k connKey) (int, bool) {
+	if t.old == nil {
+		return 0, false
+	}
+	i, ok := findSlot(t.old, h, k)
+	return i, ok && i >= t.cursor
+}
+
+func (t *translationTable) get(k connKey) (translationValue, bool) {
+	return t.getHashed(t.hash(k), k)
+}
+
+// getHashed returns the entry of the given key, whose hash is h
+func (t *translationTable) getHashed(h uint64, k connKey) (translationValue, bool) {
+	if i, ok := findSlot(t.slots, h, k); ok {
+		return t.slots.at(i).value, true
+	}
+	if i, ok := t.findOld(h, k); ok {
+		return t.old.at(i).value, true
+	}
+	return translationValue{}, false
+}
+
+// getReply returns the entry whose key has the hash h, and whose translation has the given reply key
+func (t *translationTable) getReply(h uint64, reply connKey) (connKey, translationValue, bool) {
+	for i := t.slots.home(h); t.slots.at(i).hash != 0; i = t.slots.next(i) {
+		if s := t.slots.at(i); s.hash == h && !s.deleted && s.value.replyKey(s.key) == reply {
+			return s.key, s.value, true
+		}
+	}
+	if t.old == nil {
+		return connKey{}, translationValue{}, false
+	}
+	for i := t.old.home(h); t.old.at(i).hash != 0; i = t.old.next(i) {
+		if s := t.old.at(i); i >= t.cursor && s.hash == h && !s.deleted && s.value.replyKey(s.key) == reply {
+			return s.key, s.value, true
+		}
+	}
+	return connKey{}, translationValue{}, false
+}
+
+func (t *translationTable) contains(k connKey) bool {
+	_, ok := t.get(k)
+	return ok
+}
+
+func (t *translationTable) set(k connKey, v translationValue) {
+	defer t.migrate(tableMigrationStep)
+
+	h := t.hash(k)
+	i, ok := findSlot(t.slots, h, k)
+	if ok {
+		t.slots.mut(i).value = v
+		return
+	}
+	if j, ok := t.findOld(h, k); ok {
+		t.old.mut(j).value = v
+		return
+	}
+
+	if t.full() {
+		t.grow()
+		i, _ = findSlot(t.slots, h, k)
+	}
+	*t.slots.mut(i) = tableSlot{hash: h, key: k, value: v}
+	t.used++
+	t.count++
+}
+
+// delete frees the slot of the given key, and shifts the entries following it in its probe sequence back, so the
+// probe sequences don't go through free slots. The entries of the slots being migrated are only marked deleted.
+func (t *translationTable) delete(k connKey) {
+	defer t.migrate(tableMigrationStep)
+
+	h := t.hash(k)
+	i, ok := findSlot(t.slots, h, k)
+	if !ok {
+		if j, ok := t.findOld(h, k); ok {
+			t.old.mut(j).deleted = true
+			t.count--
+		}
+		return
+	}
+
+	for j := t.slots.next(i); t.slots.at(j).hash != 0; j = t.slots.next(j) {
+		// the entry of slot j can fill the free slot i unless its probe sequence starts after i
+		home := t.slots.home(t.slots.at(j).hash)
+		if i <= j && (home <= i || home > j) || i > j && home <= i && home > j {
+			*t.slots.mut(i) = *t.slots.at(j)
+			i = j
+		}
+	}
+	*t.slots.mut(i) = tableSlot{}
+	t.used--
+	t.count--
+
+	if !t.deferCompaction {
+		t.compact()
+	}
+}
+
+// compact shrinks the table so a quarter of its slots are used, when fewer than tableMinLoad of them are, and returns
+// whether it was shrunk
+func (t *translationTable) compact() bool {
+	if t.compaction == compactOff || t.old != nil || t.slots.len() <= t.minSlots ||
+		float64(t.count) >= tableMinLoad*float64(t.slots.len()) {
+		return false
+	}
+	size := 4 * t.count
+	if size < t.minSlots {
+		size = t.minSlots
+	}
+	if t.compaction == compactCopy {
+		t.rehash(size)
+	} else {
+		t.resize(size)
+	}
+	t.shrinks++
+	return true
+}
+
+// full returns whether the slots must be grown before an entry is added to them
+func (t *translationTable) full() bool {
+	return float64(t.used+1) > tableMaxLoad*float64(t.slots.len())
+}
+
+// grow doubles the slots. When they fill up before the resize in progress completes, which the sizes of the slots
+// make unlikely, all the entries are moved at once instead.
+func (t *translationTable) grow() {
+	t.grows++
+	if t.old == nil {
+		t.resize(2 * t.slots.len())
+		return
+	}
+
+	size := 2 * t.slots.len()
+	for float64(t.count+1) > tableMaxLoad*float64(size)/2 {
+		size *= 2
+	}
+	t.rehash(size)
+}
+
+// rehash moves all the entries to the given number of slots at once, the ones being migrated included
+func (t *translationTable) rehash(size int) {
+	slots, old, cursor := t.slots, t.old, t.cursor
+	t.slots, t.used, t.old, t.cursor = newTableSlots(size), 0, nil, 0
+	for i := 0; i < slots.len(); i++ {
+		if s := slots.at(i); s.hash != 0 {
+			t.put(*s)
+		}
+	}
+	for i := cursor; old != nil && i < old.len(); i++ {
+		if s := old.at(i); s.hash != 0 && !s.deleted {
+			t.put(*s)
+		}
+	}
+}
+
+// resize replaces the slots with the given number of slots, the current ones being migrated by the next updates
+func (t *translationTable) resize(size int) {
+	t.old, t.cursor = t.slots, 0
+	t.slots, t.used = newTableSlots(size), 0
+}
+
+// migrate moves the entries of up to n of the slots being migrated
+func (t *translationTable) migrate(n int) {
+	for end := t.cursor + n; t.old != nil && t.cursor < end && t.cursor < t.old.len(); t.cursor++ {
+		s := t.old.at(t.cursor)
+		if s.hash == 0 || s.deleted {
+			continue
+		}
+		if t.full() {
+			t.grow()
+			return
+		}
+		t.put(*s)
+	}
+	if t.old != nil && t.cursor == t.old.len() {
+		t.old, t.cursor = nil, 0
+	}
+}
+
+func (t *translationTable) put(s tableSlot) {
+	i, _ := findSlot(t.slots, s.hash, s.key)
+	*t.slots.mut(i) = s
+	t.used++
+}
+
+func (t *translationTable) len() int {
+	return t.count
+}
+
+// forEach calls fn with each entry until it returns false. The table must not be modified meanwhile.
+func (t *translationTable) forEach(fn func(connKey, translationValue) bool) {
+	for i := 0; i < t.slots.len(); i++ {
+		if s := t.slots.at(i); s.hash != 0 && !fn(s.key, s.value) {
+			return
+		}
+	}
+	for i := t.cursor; t.old != nil && i < t.old.len(); i++ {
+		if s := t.old.at(i); s.hash != 0 && !s.deleted && !fn(s.key, s.value) {
+			return
+		}
+	}
+}
+
+// clone returns a copy of the table, which is read without the lock once published. The copy shares the chunks
+// of the slots of the table, which are copied by the table before being written from then on, so cloning the
+// table only copies the pointers to its chunks.
+func (t *translationTable) clone() *translationTable {
+	c := *t
+	c.slots = t.slots.share()
+	if t.old != nil {
+		c.old = t.old.share()
+	}
+	return &c
+}
+
+// unsharedCapacity returns the number of slots of the table which aren't shared with the given table it was cloned
+// from, either because they were copied before being written, or because the table they are shared with was resized
+func (t *translationTable) unsharedCapacity(from *translationTable) int {
+	chunks := make(map[*tableSlot]struct{})
+	for _, slots := range []*tableSlots{from.slots, from.old} {
+		for _, c := range slots.allChunks() {
+			chunks[&c[0]] = struct{}{}
+		}
+	}
+	n := 0
+	for _, slots := range []*tableSlots{t.slots, t.old} {
+		for _, c := range slots.allChunks() {
+			if _, ok := chunks[&c[0]]; !ok {
+				n += len(c)
+			}
+		}
+	}
+	return n
+}
+
+// capacity returns the number of slots allocated by the table, including the ones being migrated
+func (t *translationTable) capacity() int {
+	if t.old == nil {
+		return t.slots.len()
+	}
+	return t.slots.len() + t.old.len()
+}
+
+// preallocated returns whether the table was allocated for a large number of entries upfront
+func (t *translationTable) preallocated() bool {
+	return t.minSlots > tableMinSlots
+}
@@ -0,0 +1,249 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tableKey(port uint16) connKey {
+	return connKey{srcIP: keyAddrFromString("10.0.0.1"), srcPort: port, dstIP: keyAddrFromString("50.30.40.10"), dstPort: 80}
+}
+
+func TestTranslationTable(t *testing.T) {
+	table := newTranslationTable(0)
+	k := tableKey(12345)
+	v := translationValue{replSrcIP: keyAddrFromString("20.0.0.1"), replSrcPort: 80}
+
+	assert.False(t, table.contains(k))
+	table.set(k, v)
+	got, ok := table.get(k)
+	assert.True(t, ok)
+	assert.Equal(t, v, got)
+
+	v.replSrcPort = 8080
+	table.set(k, v)
+	got, _ = table.get(k)
+	assert.Equal(t, v, got)
+	assert.Equal(t, 1, table.len())
+
+	table.delete(k)
+	assert.False(t, table.contains(k))
+	assert.Zero(t, table.len())
+
+	// the hashes depend on the key of the table
+	assert.Equal(t, table.hash(k), table.hash(k))
+	assert.NotEqual(t, table.hash(k), newTranslationTable(0).hash(k))
+}
+
+// TestTranslationTableResize checks the entries are found while the slots are migrated, and the migration completes
+func TestTranslationTableResize(t *testing.T) {
+	table := newTranslationTable(0)
+	n := tableMinSlots * 3 / 4
+	for i := 0; i < n; i++ {
+		table.set(tableKey(uint16(i)), translationValue{replSrcPort: uint16(i)})
+	}
+	require.Nil(t, table.old)
+
+	// the table grows, and the next updates migrate its slots
+	table.set(tableKey(1000), translationValue{replSrcPort: 1000})
+	assert.Equal(t, int64(1), table.grows)
+	assert.Equal(t, 2*tableMinSlots, table.slots.len())
+	require.NotNil(t, table.old)
+
+	// entries are updated and deleted before being migrated
+	table.set(tableKey(40), translationValue{replSrcPort: 4000})
+	table.delete(tableKey(41))
+	require.NotNil(t, table.old)
+
+	published := table.clone()
+	for _, table := range []*translationTable{table, published} {
+		assert.Equal(t, n, table.len())
+		for i := 0; i < n; i++ {
+			v, ok := table.get(tableKey(uint16(i)))
+			switch i {
+			case 40:
+				assert.Equal(t, uint16(4000), v.replSrcPort)
+			case 41:
+				assert.False(t, ok)
+			default:
+				assert.True(t, ok)
+				assert.Equal(t, uint16(i), v.replSrcPort)
+			}
+		}
+	}
+
+	for i := 0; table.old != nil; i++ {
+		require.True(t, i < tableMinSlots)
+		table.set(tableKey(1000), translationValue{replSrcPort: 1000})
+	}
+	assert.Equal(t, n, table.used)
+	assert.False(t, table.contains(tableKey(41)))
+
+	// the table shrinks back once most of its entries are deleted
+	for i := 0; i < n; i++ {
+		table.delete(tableKey(uint16(i)))
+	}
+	assert.Equal(t, int64(1), table.shrinks)
+	assert.Equal(t, tableMinSlots, table.slots.len())
+	assert.Equal(t, 1, table.len())
+	assert.True(t, table.contains(tableKey(1000)))
+}
+
+// TestTranslationTableOperations checks the table against a map over random operations, the number of entries
+// going up and down so the table is grown and shrunk along the way
+func TestTranslationTableOperations(t *testing.T) {
+	table := newTranslationTable(0)
+	reference := make(map[connKey]translationValue)
+	random := rand.New(rand.NewSource(1))
+
+	check := func() {
+		require.Equal(t, len(reference), table.len())
+		for k, v := range reference {
+			got, ok := table.get(k)
+			require.True(t, ok)
+			require.Equal(t, v, got)
+		}
+		entries := make(map[connKey]translationValue)
+		table.forEach(func(k connKey, v translationValue) bool {
+			entries[k] = v
+			return true
+		})
+		require.Equal(t, reference, entries)
+	}
+
+	for round := 0; round < 20; round++ {
+		// the rounds alternately add and delete more entries than they do the other way around
+		adds := 2
+		if round%2 == 1 {
+			adds = 1
+		}
+		for i := 0; i < 5000; i++ {
+			k := tableKey(uint16(random.Intn(4096)))
+			if random.Intn(3) < adds {
+				v := translationValue{replSrcPort: uint16(random.Intn(65536))}
+				table.set(k, v)
+				reference[k] = v
+			} else {
+				table.delete(k)
+				delete(reference, k)
+			}
+		}
+		check()
+	}
+	for k := range reference {
+		table.delete(k)
+		delete(reference, k)
+	}
+	check()
+
+	assert.True(t, table.grows > 0)
+	assert.True(t, table.shrinks > 0)
+}
+
+// TestTranslationTableCopyOnWrite checks the clones of a table keep their entries while the table is modified, the
+// table copying the chunks of slots it shares with them before writing them
+func TestTranslationTableCopyOnWrite(t *testing.T) {
+	table := newTranslationTable(4 * tableChunkSlots)
+	for i := 0; i < 2*tableChunkSlots; i++ {
+		table.set(tableKey(uint16(i)), translationValue{replSrcPort: uint16(i)})
+	}
+	published := table.clone()
+	assert.Zero(t, published.unsharedCapacity(table))
+
+	table.set(tableKey(0), translationValue{replSrcPort: 1})
+	table.delete(tableKey(1))
+	table.set(tableKey(60000), translationValue{replSrcPort: 60000})
+	// only the chunks written were copied
+	assert.True(t, published.unsharedCapacity(table) <= 3*tableChunkSlots)
+	assert.True(t, published.unsharedCapacity(table) < published.capacity())
+
+	for i := 0; i < 2*tableChunkSlots; i++ {
+		v, ok := published.get(tableKey(uint16(i)))
+		require.True(t, ok)
+		require.Equal(t, uint16(i), v.replSrcPort)
+	}
+	assert.False(t, published.contains(tableKey(60000)))
+	assert.Equal(t, 2*tableChunkSlots, published.len())
+
+	// the slots of a resized table aren't shared anymore
+	for i := 0; i < 4*tableChunkSlots; i++ {
+		table.set(tableKey(uint16(10000+i)), translationValue{})
+	}
+	for table.old != nil {
+		table.set(tableKey(0), translationValue{})
+	}
+	assert.Equal(t, published.capacity(), published.unsharedCapacity(table))
+	v, _ := published.get(tableKey(0))
+	assert.Equal(t, uint16(0), v.replSrcPort)
+}
+
+func TestTranslationTableCompaction(t *testing.T) {
+	fill := func(compaction compactionStrategy, deferred bool) *translationTable {
+		table := newTranslationTable(0)
+		table.compaction = compaction
+		table.deferCompaction = deferred
+		for i := 0; i < 200; i++ {
+			table.set(tableKey(uint16(i)), translationValue{replSrcPort: uint16(i)})
+		}
+		for table.old != nil {
+			table.migrate(tableMigrationStep)
+		}
+		require.Equal(t, 512, table.slots.len())
+		// only the last 10 entries are kept
+		for i := 0; i < 190; i++ {
+			table.delete(tableKey(uint16(i)))
+		}
+		for i := 190; i < 200; i++ {
+			v, ok := table.get(tableKey(uint16(i)))
+			require.True(t, ok)
+			require.Equal(t, uint16(i), v.replSrcPort)
+		}
+		return table
+	}
+
+	t.Run("incremental", func(t *testing.T) {
+		table := fill(compactIncremental, false)
+		assert.True(t, table.shrinks > 0)
+		assert.True(t, table.capacity() < 512)
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		table := fill(compactCopy, false)
+		assert.Equal(t, int64(3), table.shrinks)
+		assert.Nil(t, table.old)
+		assert.Equal(t, tableMinSlots, table.capacity())
+	})
+
+	t.Run("off", func(t *testing.T) {
+		table := fill(compactOff, false)
+		assert.Zero(t, table.shrinks)
+		assert.Equal(t, 512, table.capacity())
+		assert.False(t, table.compact())
+	})
+
+	t.Run("deferred", func(t *testing.T) {
+		table := fill(compactIncremental, true)
+		assert.Zero(t, table.shrinks)
+		assert.Equal(t, 512, table.slots.len())
+
+		assert.True(t, table.compact())
+		assert.Equal(t, int64(1), table.shrinks)
+		assert.Equal(t, tableMinSlots, table.slots.len())
+		// the table isn't shrunk again while its slots are migrated
+		assert.False(t, table.compact())
+		assert.Equal(t, 10, table.len())
+	})
+}
+
+func TestParseCompactionStrategy(t *testing.T) {
+	assert.Equal(t, compactIncremental, parseCompactionStrategy(""))
+	assert.Equal(t, compactCopy, parseCompactionStrategy(" Copy"))
+	assert.Equal(t, compactOff, parseCompactionStrategy("off"))
+	assert.Equal(t, compactIncremental, parseCompactionStrategy("unknown"))
+}
@@ -0,0 +1,64 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"github.com/DataDog/datadog-agent/pkg/network"
+)
+
+// translationValue is a translation the way the state map holds it. Unlike a network.IPTranslation, whose addresses
+// are boxed in interfaces, it doesn't hold any pointer, so the GC doesn't scan the state map nor its published copy,
+// which hold up to hundreds of thousands of entries. The lookups return copies of the translations.
+type translationValue struct {
+	replSrcIP   keyAddr
+	replDstIP   keyAddr
+	replSrcPort uint16
+	replDstPort uint16
+	natType     network.NATType
+}
+
+func newTranslationValue(t *network.IPTranslation) translationValue {
+	return translationValue{
+		replSrcIP:   newKeyAddr(t.ReplSrcIP),
+		replDstIP:   newKeyAddr(t.ReplDstIP),
+		replSrcPort: t.ReplSrcPort,
+		replDstPort: t.ReplDstPort,
+		natType:     t.NATType,
+	}
+}
+
+// translation returns a copy of the translation
+func (v translationValue) translation() *network.IPTranslation {
+	return &network.IPTranslation{
+		ReplSrcIP:   v.replSrcIP.address(),
+		ReplDstIP:   v.replDstIP.address(),
+		ReplSrcPort: v.replSrcPort,
+		ReplDstPort: v.replDstPort,
+		NATType:     v.natType,
+	}
+}
+
+// replyKey returns the key of the reply tuple of the translation, in the network namespace of the given key
+func (v translationValue) replyKey(k connKey) connKey {
+	return connKey{
+		srcIP:     v.replSrcIP,
+		srcPort:   v.replSrcPort,
+		dstIP:     v.replDstIP,
+		dstPort:   v.replDstPort,
+		transport: k.transport,
+		netns:     k.netns,
+	}
+}
+
+// natTypeOf returns which ends of the connection of the given key the translation rewrites, the same way natType does
+func (v translationValue) natTypeOf(k connKey) network.NATType {
+	var typ network.NATType
+	if v.replDstIP != k.srcIP || v.replDstPort != k.srcPort {
+		typ |= network.SNAT
+	}
+	if v.replSrcIP != k.dstIP || v.replSrcPort != k.dstPort {
+		typ |= network.DNAT
+	}
+	return typ
+}
@@ -0,0 +1,44 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslationValue(t *testing.T) {
+	trans := &network.IPTranslation{
+		ReplSrcIP:   util.AddressFromString("20.0.0.1"),
+		ReplDstIP:   util.AddressFromString("fd00::1"),
+		ReplSrcPort: 80,
+		ReplDstPort: 12345,
+		NATType:     network.SNAT,
+	}
+	assert.Equal(t, trans, newTranslationValue(trans).translation())
+}
+
+func TestLookupReturnsCopies(t *testing.T) {
+	rt := newConntracker()
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80))
+
+	c := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.0"),
+		SPort:  12345,
+		Dest:   util.AddressFromString("50.30.40.10"),
+		DPort:  80,
+		Type:   network.TCP,
+	}
+	trans := rt.GetTranslationForConn(c)
+	require.NotNil(t, trans)
+	trans.ReplSrcIP = util.AddressFromString("30.0.0.0")
+
+	// the cached translation isn't altered by the callers
+	assert.Equal(t, util.AddressFromString("20.0.0.0"), rt.GetTranslationForConn(c).ReplSrcIP)
+}
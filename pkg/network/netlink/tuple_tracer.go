@@ -0,0 +1,137 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// tupleTracer logs the events, registrations, deletions and lookups of the connections of the tuples it traces at
+// the info level, so the reason a connection is missing its translation can be followed without trace logs
+type tupleTracer struct {
+	// keys are the keys of the tuples traced in both directions, in the root namespace
+	keys map[connKey]struct{}
+}
+
+// newTupleTracer returns a tracer of the given tuples, formatted as "<proto> <src>:<sport> <dst>:<dport>"
+// (eg. "tcp 10.0.0.1:40000 10.96.0.1:443", IPv6 addresses being bracketed). The connections of the tuples are
+// traced whatever their direction and network namespace. nil is returned when there is nothing to trace.
+func newTupleTracer(tuples []string) (*tupleTracer, error) {
+	if len(tuples) == 0 {
+		return nil, nil
+	}
+
+	t := &tupleTracer{keys: make(map[connKey]struct{}, 2*len(tuples))}
+	for _, tuple := range tuples {
+		k, err := parseTracedTuple(tuple)
+		if err != nil {
+			return nil, err
+		}
+		t.keys[k] = struct{}{}
+		t.keys[connKey{srcIP: k.dstIP, srcPort: k.dstPort, dstIP: k.srcIP, dstPort: k.srcPort, transport: k.transport}] = struct{}{}
+	}
+	log.Infof("tracing the conntrack entries of %s", strings.Join(tuples, ", "))
+	return t, nil
+}
+
+func parseTracedTuple(tuple string) (connKey, error) {
+	var k connKey
+	fields := strings.Fields(tuple)
+	if len(fields) != 3 {
+		return k, fmt.Errorf("invalid tuple %q: expected \"<proto> <src>:<sport> <dst>:<dport>\"", tuple)
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "tcp":
+		k.transport = network.TCP
+	case "udp":
+		k.transport = network.UDP
+	default:
+		return k, fmt.Errorf("invalid tuple %q: unknown protocol %q", tuple, fields[0])
+	}
+
+	var err error
+	if k.srcIP, k.srcPort, err = parseTracedEndpoint(fields[1]); err != nil {
+		return k, fmt.Errorf("invalid tuple %q: %w", tuple, err)
+	}
+	if k.dstIP, k.dstPort, err = parseTracedEndpoint(fields[2]); err != nil {
+		return k, fmt.Errorf("invalid tuple %q: %w", tuple, err)
+	}
+	return k, nil
+}
+
+func parseTracedEndpoint(endpoint string) (keyAddr, uint16, error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return keyAddr{}, 0, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return keyAddr{}, 0, fmt.Errorf("invalid address %q", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return keyAddr{}, 0, fmt.Errorf("invalid port %q", port)
+	}
+	return keyAddrFromNetIP(ip), uint16(p), nil
+}
+
+// traces returns whether the given key is the one of a traced tuple
+func (t *tupleTracer) traces(k connKey) bool {
+	if t == nil {
+		return false
+	}
+	k.netns = 0
+	_, ok := t.keys[k]
+	return ok
+}
+
+// tracesCon returns whether either direction of the given entry is a traced tuple
+func (t *tupleTracer) tracesCon(c *Con) bool {
+	if t == nil {
+		return false
+	}
+	for _, tuple := range [...]IPTuple{c.Origin, c.Reply} {
+		if k, ok := formatKey(tuple); ok && t.traces(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// traceCon logs what happened to the given entry when it is traced
+func (t *tupleTracer) traceCon(c *Con, format string, args ...interface{}) {
+	if t.tracesCon(c) {
+		log.Infof("conntrack trace: %s: %s", c.String(), fmt.Sprintf(format, args...))
+	}
+}
+
+// traceKey logs what happened to the entry of the given key when it is traced
+func (t *tupleTracer) traceKey(k connKey, format string, args ...interface{}) {
+	if t.traces(k) {
+		log.Infof("conntrack trace: %s: %s", keyString(k), fmt.Sprintf(format, args...))
+	}
+}
+
+// traceLookup logs the translation looked up for the given key when it is traced
+func (t *tupleTracer) traceLookup(k connKey, trans *network.IPTranslation) {
+	if !t.traces(k) {
+		return
+	}
+	if trans == nil {
+		log.Infof("conntrack trace: %s: looked up, no translation", keyString(k))
+		return
+	}
+	log.Infof("conntrack trace: %s: looked up, translated to %s:%d -> %s:%d", keyString(k), trans.ReplDstIP, trans.ReplDstPort, trans.ReplSrcIP, trans.ReplSrcPort)
+}
+
+func keyString(k connKey) string {
+	return fmt.Sprintf("netns=%d %s %s -> %s", k.netns, k.transport, net.JoinHostPort(k.srcIP.String(), strconv.Itoa(int(k.srcPort))), net.JoinHostPort(k.dstIP.String(), strconv.Itoa(int(k.dstPort))))
+}
@@ -0,0 +1,106 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTracedTuple(t *testing.T) {
+	k, err := parseTracedTuple("TCP 10.0.0.1:40000 10.96.0.1:443")
+	require.NoError(t, err)
+	assert.Equal(t, connKey{
+		srcIP:     keyAddrFromString("10.0.0.1"),
+		srcPort:   40000,
+		dstIP:     keyAddrFromString("10.96.0.1"),
+		dstPort:   443,
+		transport: network.TCP,
+	}, k)
+
+	k, err = parseTracedTuple(" udp  [fd00::1]:5353 [fd00::2]:53 ")
+	require.NoError(t, err)
+	assert.Equal(t, keyAddrFromString("fd00::1"), k.srcIP)
+	assert.Equal(t, network.UDP, k.transport)
+
+	for _, invalid := range []string{
+		"",
+		"tcp 10.0.0.1:40000",
+		"icmp 10.0.0.1:0 10.0.0.2:0",
+		"tcp 10.0.0.1 10.96.0.1:443",
+		"tcp host:40000 10.96.0.1:443",
+		"tcp 10.0.0.1:40000 10.96.0.1:70000",
+		"udp fd00::1:5353 [fd00::2]:53",
+	} {
+		_, err = parseTracedTuple(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestTupleTracer(t *testing.T) {
+	tracer, err := newTupleTracer(nil)
+	require.NoError(t, err)
+	assert.Nil(t, tracer)
+	// a nil tracer traces nothing
+	assert.False(t, tracer.traces(connKey{}))
+
+	_, err = newTupleTracer([]string{"tcp 10.0.0.1:40000 10.96.0.1:443", "bogus"})
+	assert.Error(t, err)
+
+	tracer, err = newTupleTracer([]string{"tcp 10.0.0.1:40000 10.96.0.1:443"})
+	require.NoError(t, err)
+
+	k := connKey{
+		srcIP:     keyAddrFromString("10.0.0.1"),
+		srcPort:   40000,
+		dstIP:     keyAddrFromString("10.96.0.1"),
+		dstPort:   443,
+		transport: network.TCP,
+	}
+	assert.True(t, tracer.traces(k))
+	// in any network namespace
+	k.netns = 4026531993
+	assert.True(t, tracer.traces(k))
+	// in either direction
+	assert.True(t, tracer.traces(connKey{srcIP: k.dstIP, srcPort: k.dstPort, dstIP: k.srcIP, dstPort: k.srcPort, transport: network.TCP}))
+	k.transport = network.UDP
+	assert.False(t, tracer.traces(k))
+
+	// the connection is traced by its original tuple, and by its translated one
+	c := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8443, 443)
+	assert.True(t, tracer.tracesCon(&c))
+	tracer, err = newTupleTracer([]string{"tcp 10.244.1.5:8443 10.0.0.1:40000"})
+	require.NoError(t, err)
+	assert.True(t, tracer.tracesCon(&c))
+	assert.False(t, tracer.tracesCon(&Con{}))
+}
+
+func TestTracedConntracker(t *testing.T) {
+	tracer, err := newTupleTracer([]string{"tcp 10.0.0.1:40000 10.96.0.1:443"})
+	require.NoError(t, err)
+	rt := newConntracker()
+	rt.tracer = tracer
+
+	// tracing doesn't change what is registered, looked up or deleted
+	rt.register(makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.1"), 6, 40000, 8443, 443))
+	conn := network.ConnectionStats{
+		Source: util.AddressFromString("10.0.0.1"),
+		SPort:  40000,
+		Dest:   util.AddressFromString("10.96.0.1"),
+		DPort:  443,
+		Type:   network.TCP,
+	}
+	require.NotNil(t, rt.GetTranslationForConn(conn))
+	require.NotNil(t, rt.GetEntryForConn(conn))
+	assert.Len(t, rt.GetTranslationsForConns([]network.ConnectionStats{conn, conn}), 2)
+
+	rt.DeleteTranslation(conn)
+	assert.Nil(t, rt.GetTranslationForConn(conn))
+	assert.Zero(t, rt.state.len())
+}
@@ -0,0 +1,156 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+)
+
+// maxUDPCloseHints bounds the number of keys hinted between two expirations, up to four per connection, the hints
+// in excess being dropped
+const maxUDPCloseHints = 65536
+
+// UDPCloseHints collects the UDP connections conntrack destroyed, so the tracer can close them right away rather
+// than waiting for them to idle out. Conntrack destroys the entries of UDP connections once they idle out of its own
+// timeouts, or when they are flushed, so a hint only means the connection is likely over.
+// The hints of the connections the tracer doesn't track, such as the forwarded ones, are never consumed: they are
+// expired after two calls to Expire, which the tracer makes after each pass over its connections.
+type UDPCloseHints struct {
+	mux sync.Mutex
+	// current holds the hints received since the last call to Expire, previous the ones received before it
+	current  map[connKey]Counters
+	previous map[connKey]Counters
+
+	cancel func()
+	done   chan struct{}
+
+	received int64
+	consumed int64
+	dropped  int64
+}
+
+// NewUDPCloseHints subscribes to the UDP connections the given Conntracker destroys, which it only notifies about
+// when Config.UDPCloseHints is set
+func NewUDPCloseHints(ctr Conntracker) *UDPCloseHints {
+	events, cancel := ctr.Subscribe(func(e TranslationEvent) bool {
+		return e.Type == ConnectionDestroyed && e.Transport == network.UDP
+	})
+	h := &UDPCloseHints{
+		current:  make(map[connKey]Counters),
+		previous: make(map[connKey]Counters),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go func() {
+		defer close(h.done)
+		for e := range events {
+			h.add(e)
+		}
+	}()
+	return h
+}
+
+// add records the hint of a destroyed connection, for both directions of its origin and reply tuples, since the
+// tracer sees the connection from its local end, before or after its translation
+func (h *UDPCloseHints) add(e TranslationEvent) {
+	origin := connKey{
+		srcIP:     newKeyAddr(e.Source),
+		srcPort:   e.SPort,
+		dstIP:     newKeyAddr(e.Dest),
+		dstPort:   e.DPort,
+		transport: e.Transport,
+		netns:     e.NetNS,
+	}
+	reply := ipTranslationToConnKey(e.Transport, &e.Translation)
+	reply.netns = e.NetNS
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	atomic.AddInt64(&h.received, 1)
+	if len(h.current) >= maxUDPCloseHints {
+		atomic.AddInt64(&h.dropped, 1)
+		return
+	}
+	h.current[origin] = e.Counters
+	h.current[reversedKey(origin)] = e.Counters.reversed()
+	h.current[reply] = e.Counters.reversed()
+	h.current[reversedKey(reply)] = e.Counters
+}
+
+func reversedKey(k connKey) connKey {
+	return connKey{
+		srcIP:     k.dstIP,
+		srcPort:   k.dstPort,
+		dstIP:     k.srcIP,
+		dstPort:   k.srcPort,
+		transport: k.transport,
+		netns:     k.netns,
+	}
+}
+
+// Closed returns whether conntrack destroyed the given UDP connection, along with its last counters from the
+// point of view of its source. The hints are kept by network namespace, the connections falling back to the hints of
+// the root namespace like their translations do. The hint is consumed, so it is only returned once.
+func (h *UDPCloseHints) Closed(c network.ConnectionStats) (Counters, bool) {
+	if h == nil || c.Type != network.UDP {
+		return Counters{}, false
+	}
+
+	k := connStatsToNSConnKey(c)
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if counters, ok := h.consume(k); ok || k.netns == 0 {
+		return counters, ok
+	}
+	k.netns = 0
+	return h.consume(k)
+}
+
+// consume removes the hint of the given key, which must be called with the lock held
+func (h *UDPCloseHints) consume(k connKey) (Counters, bool) {
+	for _, hints := range [...]map[connKey]Counters{h.current, h.previous} {
+		if counters, ok := hints[k]; ok {
+			delete(hints, k)
+			atomic.AddInt64(&h.consumed, 1)
+			return counters, true
+		}
+	}
+	return Counters{}, false
+}
+
+// Expire drops the hints received before the previous call to Expire which weren't consumed
+func (h *UDPCloseHints) Expire() {
+	if h == nil {
+		return
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.previous = h.current
+	h.current = make(map[connKey]Counters)
+}
+
+// GetStats returns the number of hints received, consumed by the tracer, and dropped because too many were kept
+func (h *UDPCloseHints) GetStats() map[string]int64 {
+	if h == nil {
+		return nil
+	}
+	return map[string]int64{
+		"udp_close_hints_received": atomic.LoadInt64(&h.received),
+		"udp_close_hints_consumed": atomic.LoadInt64(&h.consumed),
+		"udp_close_hints_dropped":  atomic.LoadInt64(&h.dropped),
+	}
+}
+
+// Close cancels the subscription
+func (h *UDPCloseHints) Close() {
+	if h == nil {
+		return
+	}
+	h.cancel()
+	<-h.done
+}
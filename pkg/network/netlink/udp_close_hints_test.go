@@ -0,0 +1,131 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/network"
+	"github.com/DataDog/datadog-agent/pkg/process/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func udpConn(src string, sport uint16, dst string, dport uint16) network.ConnectionStats {
+	return network.ConnectionStats{
+		Source: util.AddressFromString(src),
+		SPort:  sport,
+		Dest:   util.AddressFromString(dst),
+		DPort:  dport,
+		Type:   network.UDP,
+	}
+}
+
+func TestUDPCloseHints(t *testing.T) {
+	rt := newConntracker()
+	rt.udpCloseHints = true
+	hints := NewUDPCloseHints(rt)
+	defer hints.Close()
+
+	// 10.0.0.1:5000 -> 10.96.0.10:53 translated to 10.244.1.5:53
+	nat := makeTranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("10.244.1.5"), net.ParseIP("10.96.0.10"), 17, 5000, 53, 53)
+	nat.EventType = EventDestroy
+	nat.Counters = Counters{SentPackets: 1, SentBytes: 60, RecvPackets: 2, RecvBytes: 240}
+	plain := makeUntranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("1.1.1.1"), 17, 5001, 53)
+	plain.EventType = EventDestroy
+	tcp := makeUntranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("1.1.1.1"), 6, 5002, 443)
+	tcp.EventType = EventDestroy
+	for _, c := range []Con{nat, plain, tcp} {
+		rt.processEvent(&c)
+	}
+
+	// the UDP connections are hinted whether they are translated or not
+	require.Eventually(t, func() bool { return hints.GetStats()["udp_close_hints_received"] == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&rt.stats.udpCloseHints))
+
+	counters, ok := hints.Closed(udpConn("10.0.0.1", 5000, "10.96.0.10", 53))
+	assert.True(t, ok)
+	assert.Equal(t, nat.Counters, counters)
+	// the server sees the connection from its other end, once translated
+	counters, ok = hints.Closed(udpConn("10.244.1.5", 53, "10.0.0.1", 5000))
+	assert.True(t, ok)
+	assert.Equal(t, nat.Counters.reversed(), counters)
+	// the hints are consumed
+	_, ok = hints.Closed(udpConn("10.0.0.1", 5000, "10.96.0.10", 53))
+	assert.False(t, ok)
+	_, ok = hints.Closed(udpConn("10.0.0.1", 5002, "1.1.1.1", 443))
+	assert.False(t, ok)
+
+	// the hints which aren't consumed expire after two expirations
+	hints.Expire()
+	_, ok = hints.Closed(udpConn("1.1.1.1", 53, "10.0.0.1", 5001))
+	assert.True(t, ok)
+	hints.Expire()
+	_, ok = hints.Closed(udpConn("10.0.0.1", 5001, "1.1.1.1", 53))
+	assert.False(t, ok)
+	assert.Equal(t, int64(3), hints.GetStats()["udp_close_hints_consumed"])
+}
+
+func TestUDPCloseHintsNamespaces(t *testing.T) {
+	hints := &UDPCloseHints{
+		current:  make(map[connKey]Counters),
+		previous: make(map[connKey]Counters),
+	}
+	destroyed := func(netns uint32, sport uint16) TranslationEvent {
+		return TranslationEvent{
+			Type:      ConnectionDestroyed,
+			Source:    util.AddressFromString("10.0.0.1"),
+			SPort:     sport,
+			Dest:      util.AddressFromString("1.1.1.1"),
+			DPort:     53,
+			Transport: network.UDP,
+			NetNS:     netns,
+			Translation: network.IPTranslation{
+				ReplSrcIP:   util.AddressFromString("1.1.1.1"),
+				ReplDstIP:   util.AddressFromString("10.0.0.1"),
+				ReplSrcPort: 53,
+				ReplDstPort: sport,
+			},
+		}
+	}
+	inNS := func(c network.ConnectionStats, netns uint32) network.ConnectionStats {
+		c.NetNS = netns
+		return c
+	}
+	hints.add(destroyed(4026532285, 5000))
+	hints.add(destroyed(0, 5001))
+
+	// the connections of the other namespaces aren't closed by the hints of a namespace
+	_, ok := hints.Closed(inNS(udpConn("10.0.0.1", 5000, "1.1.1.1", 53), 4026532300))
+	assert.False(t, ok)
+	_, ok = hints.Closed(udpConn("10.0.0.1", 5000, "1.1.1.1", 53))
+	assert.False(t, ok)
+	_, ok = hints.Closed(inNS(udpConn("1.1.1.1", 53, "10.0.0.1", 5000), 4026532285))
+	assert.True(t, ok)
+
+	// the hints of the root namespace are used for the connections of all the namespaces
+	_, ok = hints.Closed(inNS(udpConn("10.0.0.1", 5001, "1.1.1.1", 53), 4026532300))
+	assert.True(t, ok)
+}
+
+func TestUDPCloseHintsDisabled(t *testing.T) {
+	rt := newConntracker()
+	hints := NewUDPCloseHints(rt)
+	defer hints.Close()
+
+	plain := makeUntranslatedConn(net.ParseIP("10.0.0.1"), net.ParseIP("1.1.1.1"), 17, 5001, 53)
+	plain.EventType = EventDestroy
+	rt.processEvent(&plain)
+	_, ok := hints.Closed(udpConn("10.0.0.1", 5001, "1.1.1.1", 53))
+	assert.False(t, ok)
+
+	var none *UDPCloseHints
+	_, ok = none.Closed(udpConn("10.0.0.1", 5001, "1.1.1.1", 53))
+	assert.False(t, ok)
+	none.Expire()
+	none.Close()
+}
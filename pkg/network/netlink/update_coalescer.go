@@ -0,0 +1,146 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// coalescerKey identifies a conntrack entry across its events
+type coalescerKey struct {
+	netns int32
+	id    uint32
+}
+
+// updateCoalescer remembers a fingerprint of what was last stored for each conntrack entry, by conntrack id,
+// so the update events which wouldn't change anything stored (eg. the TCP state transitions when the TCP state
+// isn't tracked) are skipped without taking the write lock of the state map. A nil updateCoalescer skips nothing.
+type updateCoalescer struct {
+	mux          sync.Mutex
+	fingerprints map[coalescerKey]uint64
+	// maxSize bounds the fingerprints remembered, since the entries are only forgotten on destroy events,
+	// which may not be subscribed to. All the fingerprints are forgotten once it is reached.
+	maxSize int
+
+	// which fields of the entries are stored, and must be part of the fingerprints
+	tcpState, counters, startTime, status bool
+
+	coalesced int64
+}
+
+func newUpdateCoalescer(maxSize int, tcpState, counters, startTime, status bool) *updateCoalescer {
+	return &updateCoalescer{
+		fingerprints: make(map[coalescerKey]uint64),
+		maxSize:      maxSize,
+		tcpState:     tcpState,
+		counters:     counters,
+		startTime:    startTime,
+		status:       status,
+	}
+}
+
+// unchanged returns whether an update event would store exactly what was stored for its entry already
+func (u *updateCoalescer) unchanged(c *Con) bool {
+	if u == nil || c.EventType != EventUpdate || c.ID == 0 {
+		return false
+	}
+
+	fp := u.fingerprint(c)
+	u.mux.Lock()
+	stored, ok := u.fingerprints[coalescerKey{c.NetNS, c.ID}]
+	u.mux.Unlock()
+	if ok && stored == fp {
+		atomic.AddInt64(&u.coalesced, 1)
+		return true
+	}
+	return false
+}
+
+// stored remembers what was stored for an entry
+func (u *updateCoalescer) stored(c *Con) {
+	if u == nil || c.ID == 0 {
+		return
+	}
+
+	fp := u.fingerprint(c)
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if len(u.fingerprints) >= u.maxSize {
+		u.fingerprints = make(map[coalescerKey]uint64)
+	}
+	u.fingerprints[coalescerKey{c.NetNS, c.ID}] = fp
+}
+
+// forget removes the fingerprint of a destroyed entry
+func (u *updateCoalescer) forget(c *Con) {
+	if u == nil || c.ID == 0 {
+		return
+	}
+
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	delete(u.fingerprints, coalescerKey{c.NetNS, c.ID})
+}
+
+func (u *updateCoalescer) getStats() map[string]int64 {
+	u.mux.Lock()
+	size := len(u.fingerprints)
+	u.mux.Unlock()
+
+	return map[string]int64{
+		"updates_coalesced": atomic.LoadInt64(&u.coalesced),
+		"coalescer_entries": int64(size),
+	}
+}
+
+// fingerprint hashes (FNV-1a) the fields of an entry which are stored
+func (u *updateCoalescer) fingerprint(c *Con) uint64 {
+	h := uint64(fnvOffset64)
+	h = hashTuple(h, &c.Origin)
+	h = hashTuple(h, &c.Reply)
+	if u.tcpState {
+		h = hashUint64(h, uint64(c.TCPState))
+	}
+	if u.counters {
+		h = hashUint64(h, c.Counters.SentPackets)
+		h = hashUint64(h, c.Counters.SentBytes)
+		h = hashUint64(h, c.Counters.RecvPackets)
+		h = hashUint64(h, c.Counters.RecvBytes)
+	}
+	if u.startTime {
+		h = hashUint64(h, c.StartTime)
+	}
+	if u.status {
+		h = hashUint64(h, uint64(c.Status))
+	}
+	return h
+}
+
+func hashTuple(h uint64, t *IPTuple) uint64 {
+	h = hashBytes(h, t.src[:])
+	h = hashBytes(h, t.dst[:])
+	h = hashUint64(h, uint64(t.proto)<<40|uint64(t.srcPort)<<24|uint64(t.dstPort)<<8|uint64(t.fields))
+	return h
+}
+
+func hashUint64(h uint64, v uint64) uint64 {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return hashBytes(h, b[:])
+}
+
+func hashBytes(h uint64, b []byte) uint64 {
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= fnvPrime64
+	}
+	return h
+}
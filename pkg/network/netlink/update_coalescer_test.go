@@ -0,0 +1,87 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateCoalescer(t *testing.T) {
+	u := newUpdateCoalescer(10, false, false, false, false)
+	c := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	c.ID = 1
+	c.EventType = EventNew
+	assert.False(t, u.unchanged(&c))
+	u.stored(&c)
+
+	// the TCP state isn't stored, so its transitions don't change anything
+	c.EventType = EventUpdate
+	c.TCPState = TCPStateEstablished
+	assert.True(t, u.unchanged(&c))
+
+	// neither do the updates of other entries, nor the ones without id
+	other := c
+	other.ID = 2
+	assert.False(t, u.unchanged(&other))
+	other.ID = 0
+	assert.False(t, u.unchanged(&other))
+
+	u.forget(&c)
+	assert.False(t, u.unchanged(&c))
+	assert.Equal(t, int64(1), u.getStats()["updates_coalesced"])
+
+	// the fingerprints are forgotten all at once when there are too many
+	for i := uint32(1); i <= 10; i++ {
+		c.ID = i
+		u.stored(&c)
+	}
+	c.ID = 11
+	u.stored(&c)
+	assert.Equal(t, int64(1), u.getStats()["coalescer_entries"])
+}
+
+func TestUpdateCoalescerTrackedFields(t *testing.T) {
+	u := newUpdateCoalescer(10, true, false, false, false)
+	c := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	c.ID = 1
+	c.EventType = EventUpdate
+	c.TCPState = TCPStateSynSent
+	u.stored(&c)
+
+	c.TCPState = TCPStateEstablished
+	assert.False(t, u.unchanged(&c))
+	u.stored(&c)
+	assert.True(t, u.unchanged(&c))
+
+	// the translation changes when the NAT is set up after the entry is created
+	translated := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.1"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	translated.ID = 1
+	translated.EventType = EventUpdate
+	translated.TCPState = TCPStateEstablished
+	assert.False(t, u.unchanged(&translated))
+}
+
+func TestRegisterCoalescedUpdates(t *testing.T) {
+	rt := newConntracker()
+	rt.coalescer = newUpdateCoalescer(rt.maxStateSize, false, false, false, false)
+
+	c := makeTranslatedConn(net.ParseIP("10.0.0.0"), net.ParseIP("20.0.0.0"), net.ParseIP("50.30.40.10"), 6, 12345, 80, 80)
+	c.ID = 1
+	c.EventType = EventNew
+	rt.register(c)
+	c.EventType = EventUpdate
+	rt.register(c)
+	rt.register(c)
+	assert.EqualValues(t, 1, rt.stats.registers)
+	assert.Equal(t, 1, rt.state.len())
+
+	// once destroyed, the entry is registered again by its next event
+	rt.unregister(c)
+	rt.register(c)
+	assert.EqualValues(t, 2, rt.stats.registers)
+	assert.Equal(t, 1, rt.state.len())
+}
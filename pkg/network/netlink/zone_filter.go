@@ -0,0 +1,69 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultZone is the conntrack zone of the entries created outside of any zone, eg. by iptables
+const defaultZone = 0
+
+// zoneFilter restricts the conntrack entries tracked to the ones of the configured conntrack zones, besides the
+// default zone. OVS and OVN-Kubernetes track the connections of the pods in several zones, where the same connection
+// is found with and without its service NAT, so the entries of the zones not doing the NAT must be ignored for
+// their destruction not to remove the translation of the others.
+// It is applied by the Decoder, so entries filtered out are never handed over to the conntracker.
+type zoneFilter struct {
+	// priorities are the priorities of the zones tracked, the lowest first
+	priorities map[uint16]int
+
+	// filtered is the number of entries filtered out so far
+	filtered int64
+}
+
+// newZoneFilter returns a filter keeping the entries of the given zones and of the default zone. When the same
+// connection is tracked in several of them, the translation of the zone listed first is kept, and the default zone
+// comes last unless it is listed. nil is returned when there is nothing to filter.
+func newZoneFilter(zones []string) (*zoneFilter, error) {
+	if len(zones) == 0 {
+		return nil, nil
+	}
+
+	f := &zoneFilter{priorities: make(map[uint16]int, len(zones)+1)}
+	for i, z := range zones {
+		zone, err := strconv.ParseUint(strings.TrimSpace(z), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid conntrack zone %q: %w", z, err)
+		}
+		if _, ok := f.priorities[uint16(zone)]; !ok {
+			f.priorities[uint16(zone)] = i
+		}
+	}
+	if _, ok := f.priorities[defaultZone]; !ok {
+		f.priorities[defaultZone] = len(zones)
+	}
+	return f, nil
+}
+
+// matches returns whether the conntrack entry should be kept, and counts the entries filtered out
+func (f *zoneFilter) matches(c *Con) bool {
+	if f == nil {
+		return true
+	}
+	if _, ok := f.priorities[c.Zone]; !ok {
+		atomic.AddInt64(&f.filtered, 1)
+		return false
+	}
+	return true
+}
+
+// overrides returns whether the translation of an entry of the given zone replaces the translation stored for the
+// same connection from the other zone
+func (f *zoneFilter) overrides(zone, other uint16) bool {
+	return f == nil || f.priorities[zone] <= f.priorities[other]
+}
@@ -0,0 +1,63 @@
+// +build linux
+// +build !android
+
+package netlink
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestZoneFilter(t *testing.T) {
+	f, err := newZoneFilter(nil)
+	require.NoError(t, err)
+	assert.Nil(t, f)
+	assert.True(t, f.matches(&Con{Zone: 12}))
+	assert.True(t, f.overrides(12, 0))
+
+	_, err = newZoneFilter([]string{"70000"})
+	assert.Error(t, err)
+
+	f, err = newZoneFilter([]string{"64000", " 12"})
+	require.NoError(t, err)
+	assert.True(t, f.matches(&Con{Zone: 64000}))
+	assert.True(t, f.matches(&Con{Zone: 12}))
+	assert.True(t, f.matches(&Con{Zone: defaultZone}))
+	assert.False(t, f.matches(&Con{Zone: 13}))
+	assert.Equal(t, int64(1), f.filtered)
+
+	// the zones listed first win, then the default zone
+	assert.True(t, f.overrides(64000, 12))
+	assert.False(t, f.overrides(12, 64000))
+	assert.True(t, f.overrides(12, defaultZone))
+	assert.False(t, f.overrides(defaultZone, 12))
+}
+
+func TestDecodeZone(t *testing.T) {
+	data, err := EncodeConn(&Con{
+		Origin: newIPTuple("10.0.2.15", "2.2.2.2", 58472, 5432, uint8(unix.IPPROTO_TCP)),
+		Reply:  newIPTuple("1.1.1.1", "10.0.2.15", 5432, 58472, uint8(unix.IPPROTO_TCP)),
+	})
+	require.NoError(t, err)
+
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	ae.Uint16(ctaZone, 64000)
+	zone, err := ae.Encode()
+	require.NoError(t, err)
+
+	d := NewDecoder()
+	d.zones, err = newZoneFilter([]string{"64000"})
+	require.NoError(t, err)
+
+	var zones []uint16
+	d.DecodeAndReleaseEvent(Event{msgs: []netlink.Message{{Data: append(data, zone...)}, {Data: data}}}, func(c *Con) {
+		zones = append(zones, c.Zone)
+	})
+	assert.Equal(t, []uint16{64000, defaultZone}, zones)
+}
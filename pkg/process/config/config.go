@@ -95,8 +95,66 @@ type AgentConfig struct {
 	ExcludedDestinationConnections map[string][]string
 	EnableConntrack                bool
 	ConntrackMaxStateSize          int
+	ConntrackAutoMaxStateSize      bool
+	ConntrackArenaMinStateSize     int
+	ConntrackCompactionStrategy    string
+	ConntrackCompactInterval       time.Duration
+	ConntrackMaxEntriesPerSource   int
+	ConntrackQuotaPerNamespace     bool
 	ConntrackRateLimit             int
+	ConntrackNamespaceRateLimit    int
 	EnableConntrackAllNamespaces   bool
+	EnableConntrackModprobe        bool
+	EnableConntrackIPVS            bool
+	EnableConntrackCilium          bool
+	ConntrackDockerPortBindings    bool
+	ConntrackContainerAttribution  bool
+	ConntrackServiceResolution     bool
+	ConntrackPIDAttribution        bool
+	ConntrackNATRuleFilter         bool
+	ConntrackIncludeCIDRs          []string
+	ConntrackExcludeCIDRs          []string
+	ConntrackIncludePorts          []string
+	ConntrackExcludePorts          []string
+	ConntrackZones                 []string
+	ConntrackCloudNATCIDRs         []string
+	ConntrackTraceTuples           []string
+	ConntrackSkipUnreplied         bool
+	ConntrackSkipUnassured         bool
+	ConntrackSkipLocalReplies      bool
+	ConntrackTCPState              bool
+	ConntrackCounters              bool
+	ConntrackStartTimes            bool
+	ConntrackStatus                bool
+	ConntrackExpectations          bool
+	ConntrackDisableIPv6           bool
+	ConntrackLazyInit              bool
+	ConntrackInitTimeout           time.Duration
+	ConntrackDumpTimeout           time.Duration
+	ConntrackInitRetryBackoff      time.Duration
+	ConntrackInitRetryMaxBackoff   time.Duration
+	ConntrackInitMaxRetries        int
+	ConntrackDumpMarkMask          uint32
+	ConntrackRcvBufSize            int
+	ConntrackMmapRing              bool
+	ConntrackResyncInterval        time.Duration
+	ConntrackTCPTTL                time.Duration
+	ConntrackUDPTTL                time.Duration
+	ConntrackRegisterQueueSize     int
+	ConntrackMissQueryRate         int
+	ConntrackNegativeLookupTTL     time.Duration
+	ConntrackdSyncAddress          string
+	ConntrackdSyncInterface        string
+	ConntrackSnapshotPath          string
+	ConntrackRecordPath            string
+	ConntrackReplayPath            string
+	ConntrackNetlinkGroups         []string
+	ConntrackUDPCloseHints         bool
+	ConntrackNATEvents             bool
+	ConntrackLookupSocket          string
+	ConntrackAdaptiveSampling      bool
+	ConntrackAdaptiveCPUBudget     float64
+	ConntrackCPUBreakerBudget      float64
 	SystemProbeDebugPort           int
 	ClosedChannelSize              int
 	MaxClosedConnectionsBuffered   int
@@ -223,6 +281,7 @@ func NewDefaultAgentConfig(canAccessContainers bool) *AgentConfig {
 		ConntrackMaxStateSize:        defaultMaxTrackedConnections * 2,
 		ConntrackRateLimit:           500,
 		EnableConntrackAllNamespaces: true,
+		ConntrackLookupSocket:        defaultConntrackLookupSocket,
 		OffsetGuessThreshold:         400,
 		EnableTracepoints:            false,
 		CollectDNSStats:              true,
@@ -13,4 +13,7 @@ const (
 
 	// defaultSystemProbeAddress is the default unix socket path to be used for connecting to the system probe
 	defaultSystemProbeAddress = "/opt/datadog-agent/run/sysprobe.sock"
+
+	// defaultConntrackLookupSocket is the default unix socket path the system probe serves the conntrack lookups on
+	defaultConntrackLookupSocket = "/opt/datadog-agent/run/conntrack-lookup.sock"
 )
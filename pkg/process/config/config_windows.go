@@ -13,6 +13,9 @@ import (
 const (
 	// defaultSystemProbeAddress is the default address to be used for connecting to the system probe
 	defaultSystemProbeAddress = "localhost:3333"
+
+	// defaultConntrackLookupSocket is empty, the system probe only serves the conntrack lookups on linux
+	defaultConntrackLookupSocket = ""
 )
 
 var (
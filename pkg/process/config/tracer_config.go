@@ -58,7 +58,65 @@ func SysProbeConfigFromConfig(cfg *AgentConfig) *ebpf.Config {
 	tracerConfig.BPFDir = cfg.SystemProbeBPFDir
 	tracerConfig.EnableConntrack = cfg.EnableConntrack
 	tracerConfig.ConntrackMaxStateSize = cfg.ConntrackMaxStateSize
+	tracerConfig.ConntrackAutoMaxStateSize = cfg.ConntrackAutoMaxStateSize
+	tracerConfig.ConntrackArenaMinStateSize = cfg.ConntrackArenaMinStateSize
+	tracerConfig.ConntrackCompactionStrategy = cfg.ConntrackCompactionStrategy
+	tracerConfig.ConntrackCompactInterval = cfg.ConntrackCompactInterval
+	tracerConfig.ConntrackMaxEntriesPerSource = cfg.ConntrackMaxEntriesPerSource
+	tracerConfig.ConntrackQuotaPerNamespace = cfg.ConntrackQuotaPerNamespace
+	tracerConfig.ConntrackRateLimit = cfg.ConntrackRateLimit
+	tracerConfig.ConntrackNamespaceRateLimit = cfg.ConntrackNamespaceRateLimit
 	tracerConfig.EnableConntrackAllNamespaces = cfg.EnableConntrackAllNamespaces
+	tracerConfig.EnableConntrackModprobe = cfg.EnableConntrackModprobe
+	tracerConfig.EnableConntrackIPVS = cfg.EnableConntrackIPVS
+	tracerConfig.EnableConntrackCilium = cfg.EnableConntrackCilium
+	tracerConfig.ConntrackDockerPortBindings = cfg.ConntrackDockerPortBindings
+	tracerConfig.ConntrackContainerAttribution = cfg.ConntrackContainerAttribution
+	tracerConfig.ConntrackServiceResolution = cfg.ConntrackServiceResolution
+	tracerConfig.ConntrackPIDAttribution = cfg.ConntrackPIDAttribution
+	tracerConfig.ConntrackNATRuleFilter = cfg.ConntrackNATRuleFilter
+	tracerConfig.ConntrackIncludeCIDRs = cfg.ConntrackIncludeCIDRs
+	tracerConfig.ConntrackExcludeCIDRs = cfg.ConntrackExcludeCIDRs
+	tracerConfig.ConntrackIncludePorts = cfg.ConntrackIncludePorts
+	tracerConfig.ConntrackExcludePorts = cfg.ConntrackExcludePorts
+	tracerConfig.ConntrackZones = cfg.ConntrackZones
+	tracerConfig.ConntrackCloudNATCIDRs = cfg.ConntrackCloudNATCIDRs
+	tracerConfig.ConntrackTraceTuples = cfg.ConntrackTraceTuples
+	tracerConfig.ConntrackSkipUnreplied = cfg.ConntrackSkipUnreplied
+	tracerConfig.ConntrackSkipUnassured = cfg.ConntrackSkipUnassured
+	tracerConfig.ConntrackSkipLocalReplies = cfg.ConntrackSkipLocalReplies
+	tracerConfig.ConntrackTCPState = cfg.ConntrackTCPState
+	tracerConfig.ConntrackCounters = cfg.ConntrackCounters
+	tracerConfig.ConntrackStartTimes = cfg.ConntrackStartTimes
+	tracerConfig.ConntrackStatus = cfg.ConntrackStatus
+	tracerConfig.ConntrackExpectations = cfg.ConntrackExpectations
+	tracerConfig.ConntrackDisableIPv6 = cfg.ConntrackDisableIPv6
+	tracerConfig.ConntrackLazyInit = cfg.ConntrackLazyInit
+	tracerConfig.ConntrackInitTimeout = cfg.ConntrackInitTimeout
+	tracerConfig.ConntrackDumpTimeout = cfg.ConntrackDumpTimeout
+	tracerConfig.ConntrackInitRetryBackoff = cfg.ConntrackInitRetryBackoff
+	tracerConfig.ConntrackInitRetryMaxBackoff = cfg.ConntrackInitRetryMaxBackoff
+	tracerConfig.ConntrackInitMaxRetries = cfg.ConntrackInitMaxRetries
+	tracerConfig.ConntrackDumpMarkMask = cfg.ConntrackDumpMarkMask
+	tracerConfig.ConntrackRcvBufSize = cfg.ConntrackRcvBufSize
+	tracerConfig.ConntrackMmapRing = cfg.ConntrackMmapRing
+	tracerConfig.ConntrackResyncInterval = cfg.ConntrackResyncInterval
+	tracerConfig.ConntrackTCPTTL = cfg.ConntrackTCPTTL
+	tracerConfig.ConntrackUDPTTL = cfg.ConntrackUDPTTL
+	tracerConfig.ConntrackRegisterQueueSize = cfg.ConntrackRegisterQueueSize
+	tracerConfig.ConntrackMissQueryRate = cfg.ConntrackMissQueryRate
+	tracerConfig.ConntrackNegativeLookupTTL = cfg.ConntrackNegativeLookupTTL
+	tracerConfig.ConntrackdSyncAddress = cfg.ConntrackdSyncAddress
+	tracerConfig.ConntrackdSyncInterface = cfg.ConntrackdSyncInterface
+	tracerConfig.ConntrackSnapshotPath = cfg.ConntrackSnapshotPath
+	tracerConfig.ConntrackRecordPath = cfg.ConntrackRecordPath
+	tracerConfig.ConntrackReplayPath = cfg.ConntrackReplayPath
+	tracerConfig.ConntrackNetlinkGroups = cfg.ConntrackNetlinkGroups
+	tracerConfig.ConntrackUDPCloseHints = cfg.ConntrackUDPCloseHints
+	tracerConfig.ConntrackNATEvents = cfg.ConntrackNATEvents
+	tracerConfig.ConntrackAdaptiveSampling = cfg.ConntrackAdaptiveSampling
+	tracerConfig.ConntrackAdaptiveCPUBudget = cfg.ConntrackAdaptiveCPUBudget
+	tracerConfig.ConntrackCPUBreakerBudget = cfg.ConntrackCPUBreakerBudget
 	tracerConfig.DebugPort = cfg.SystemProbeDebugPort
 
 	if mccb := cfg.MaxClosedConnectionsBuffered; mccb > 0 {
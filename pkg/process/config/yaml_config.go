@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"math"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -78,15 +79,186 @@ func (a *AgentConfig) loadSysProbeYamlConfig(path string) error {
 	if config.Datadog.IsSet(key(spNS, "enable_conntrack")) {
 		a.EnableConntrack = config.Datadog.GetBool(key(spNS, "enable_conntrack"))
 	}
-	if s := config.Datadog.GetInt(key(spNS, "conntrack_max_state_size")); s > 0 {
+	if config.Datadog.GetString(key(spNS, "conntrack_max_state_size")) == "auto" {
+		a.ConntrackAutoMaxStateSize = true
+	} else if s := config.Datadog.GetInt(key(spNS, "conntrack_max_state_size")); s > 0 {
 		a.ConntrackMaxStateSize = s
 	}
+	if s := config.Datadog.GetInt(key(spNS, "conntrack_arena_min_state_size")); s > 0 {
+		a.ConntrackArenaMinStateSize = s
+	}
+	if s := config.Datadog.GetString(key(spNS, "conntrack_compaction_strategy")); s != "" {
+		a.ConntrackCompactionStrategy = s
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_compact_interval_in_s")) {
+		a.ConntrackCompactInterval = config.Datadog.GetDuration(key(spNS, "conntrack_compact_interval_in_s")) * time.Second
+	}
+	if s := config.Datadog.GetInt(key(spNS, "conntrack_max_entries_per_source")); s > 0 {
+		a.ConntrackMaxEntriesPerSource = s
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_quota_per_namespace")) {
+		a.ConntrackQuotaPerNamespace = config.Datadog.GetBool(key(spNS, "conntrack_quota_per_namespace"))
+	}
 	if config.Datadog.IsSet(key(spNS, "conntrack_rate_limit")) {
 		a.ConntrackRateLimit = config.Datadog.GetInt(key(spNS, "conntrack_rate_limit"))
 	}
+	if r := config.Datadog.GetInt(key(spNS, "conntrack_namespace_rate_limit")); r > 0 {
+		a.ConntrackNamespaceRateLimit = r
+	}
 	if config.Datadog.IsSet(key(spNS, "enable_conntrack_all_namespaces")) {
 		a.EnableConntrackAllNamespaces = config.Datadog.GetBool(key(spNS, "enable_conntrack_all_namespaces"))
 	}
+	if config.Datadog.IsSet(key(spNS, "enable_conntrack_modprobe")) {
+		a.EnableConntrackModprobe = config.Datadog.GetBool(key(spNS, "enable_conntrack_modprobe"))
+	}
+	if config.Datadog.IsSet(key(spNS, "enable_conntrack_ipvs")) {
+		a.EnableConntrackIPVS = config.Datadog.GetBool(key(spNS, "enable_conntrack_ipvs"))
+	}
+	if config.Datadog.IsSet(key(spNS, "enable_conntrack_cilium")) {
+		a.EnableConntrackCilium = config.Datadog.GetBool(key(spNS, "enable_conntrack_cilium"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_docker_port_bindings")) {
+		a.ConntrackDockerPortBindings = config.Datadog.GetBool(key(spNS, "conntrack_docker_port_bindings"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_container_attribution")) {
+		a.ConntrackContainerAttribution = config.Datadog.GetBool(key(spNS, "conntrack_container_attribution"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_service_resolution")) {
+		a.ConntrackServiceResolution = config.Datadog.GetBool(key(spNS, "conntrack_service_resolution"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_pid_attribution")) {
+		a.ConntrackPIDAttribution = config.Datadog.GetBool(key(spNS, "conntrack_pid_attribution"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_nat_rule_filter")) {
+		a.ConntrackNATRuleFilter = config.Datadog.GetBool(key(spNS, "conntrack_nat_rule_filter"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_include_cidrs")) {
+		a.ConntrackIncludeCIDRs = config.Datadog.GetStringSlice(key(spNS, "conntrack_include_cidrs"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_exclude_cidrs")) {
+		a.ConntrackExcludeCIDRs = config.Datadog.GetStringSlice(key(spNS, "conntrack_exclude_cidrs"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_include_ports")) {
+		a.ConntrackIncludePorts = config.Datadog.GetStringSlice(key(spNS, "conntrack_include_ports"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_exclude_ports")) {
+		a.ConntrackExcludePorts = config.Datadog.GetStringSlice(key(spNS, "conntrack_exclude_ports"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_zones")) {
+		a.ConntrackZones = config.Datadog.GetStringSlice(key(spNS, "conntrack_zones"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_cloud_nat_cidrs")) {
+		a.ConntrackCloudNATCIDRs = config.Datadog.GetStringSlice(key(spNS, "conntrack_cloud_nat_cidrs"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_trace_tuples")) {
+		a.ConntrackTraceTuples = config.Datadog.GetStringSlice(key(spNS, "conntrack_trace_tuples"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_skip_unreplied")) {
+		a.ConntrackSkipUnreplied = config.Datadog.GetBool(key(spNS, "conntrack_skip_unreplied"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_skip_unassured")) {
+		a.ConntrackSkipUnassured = config.Datadog.GetBool(key(spNS, "conntrack_skip_unassured"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_skip_local_replies")) {
+		a.ConntrackSkipLocalReplies = config.Datadog.GetBool(key(spNS, "conntrack_skip_local_replies"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_tcp_state")) {
+		a.ConntrackTCPState = config.Datadog.GetBool(key(spNS, "conntrack_tcp_state"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_counters")) {
+		a.ConntrackCounters = config.Datadog.GetBool(key(spNS, "conntrack_counters"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_start_times")) {
+		a.ConntrackStartTimes = config.Datadog.GetBool(key(spNS, "conntrack_start_times"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_status")) {
+		a.ConntrackStatus = config.Datadog.GetBool(key(spNS, "conntrack_status"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_expectations")) {
+		a.ConntrackExpectations = config.Datadog.GetBool(key(spNS, "conntrack_expectations"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_disable_ipv6")) {
+		a.ConntrackDisableIPv6 = config.Datadog.GetBool(key(spNS, "conntrack_disable_ipv6"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_lazy_init")) {
+		a.ConntrackLazyInit = config.Datadog.GetBool(key(spNS, "conntrack_lazy_init"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_init_timeout_in_s")) {
+		a.ConntrackInitTimeout = config.Datadog.GetDuration(key(spNS, "conntrack_init_timeout_in_s")) * time.Second
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_dump_timeout_in_s")) {
+		a.ConntrackDumpTimeout = config.Datadog.GetDuration(key(spNS, "conntrack_dump_timeout_in_s")) * time.Second
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_init_retry_backoff_in_s")) {
+		a.ConntrackInitRetryBackoff = config.Datadog.GetDuration(key(spNS, "conntrack_init_retry_backoff_in_s")) * time.Second
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_init_retry_max_backoff_in_s")) {
+		a.ConntrackInitRetryMaxBackoff = config.Datadog.GetDuration(key(spNS, "conntrack_init_retry_max_backoff_in_s")) * time.Second
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_init_max_retries")) {
+		a.ConntrackInitMaxRetries = config.Datadog.GetInt(key(spNS, "conntrack_init_max_retries"))
+	}
+	if m := config.Datadog.GetInt64(key(spNS, "conntrack_dump_mark_mask")); m > 0 && m <= math.MaxUint32 {
+		a.ConntrackDumpMarkMask = uint32(m)
+	}
+	if s := config.Datadog.GetInt(key(spNS, "conntrack_rcvbuf_size")); s > 0 {
+		a.ConntrackRcvBufSize = s
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_mmap_ring")) {
+		a.ConntrackMmapRing = config.Datadog.GetBool(key(spNS, "conntrack_mmap_ring"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_resync_interval_in_s")) {
+		a.ConntrackResyncInterval = config.Datadog.GetDuration(key(spNS, "conntrack_resync_interval_in_s")) * time.Second
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_tcp_ttl_in_s")) {
+		a.ConntrackTCPTTL = config.Datadog.GetDuration(key(spNS, "conntrack_tcp_ttl_in_s")) * time.Second
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_udp_ttl_in_s")) {
+		a.ConntrackUDPTTL = config.Datadog.GetDuration(key(spNS, "conntrack_udp_ttl_in_s")) * time.Second
+	}
+	if s := config.Datadog.GetInt(key(spNS, "conntrack_register_queue_size")); s > 0 {
+		a.ConntrackRegisterQueueSize = s
+	}
+	if r := config.Datadog.GetInt(key(spNS, "conntrack_miss_query_rate")); r > 0 {
+		a.ConntrackMissQueryRate = r
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_negative_lookup_ttl_in_s")) {
+		a.ConntrackNegativeLookupTTL = config.Datadog.GetDuration(key(spNS, "conntrack_negative_lookup_ttl_in_s")) * time.Second
+	}
+	if addr := config.Datadog.GetString(key(spNS, "conntrackd_sync_address")); addr != "" {
+		a.ConntrackdSyncAddress = addr
+		a.ConntrackdSyncInterface = config.Datadog.GetString(key(spNS, "conntrackd_sync_interface"))
+	}
+	if path := config.Datadog.GetString(key(spNS, "conntrack_snapshot_path")); path != "" {
+		a.ConntrackSnapshotPath = path
+	}
+	if path := config.Datadog.GetString(key(spNS, "conntrack_record_path")); path != "" {
+		a.ConntrackRecordPath = path
+	}
+	if path := config.Datadog.GetString(key(spNS, "conntrack_replay_path")); path != "" {
+		a.ConntrackReplayPath = path
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_netlink_groups")) {
+		a.ConntrackNetlinkGroups = config.Datadog.GetStringSlice(key(spNS, "conntrack_netlink_groups"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_udp_close_hints")) {
+		a.ConntrackUDPCloseHints = config.Datadog.GetBool(key(spNS, "conntrack_udp_close_hints"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_nat_events_enabled")) {
+		a.ConntrackNATEvents = config.Datadog.GetBool(key(spNS, "conntrack_nat_events_enabled"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_lookup_socket")) {
+		a.ConntrackLookupSocket = config.Datadog.GetString(key(spNS, "conntrack_lookup_socket"))
+	}
+	if config.Datadog.IsSet(key(spNS, "conntrack_adaptive_sampling")) {
+		a.ConntrackAdaptiveSampling = config.Datadog.GetBool(key(spNS, "conntrack_adaptive_sampling"))
+	}
+	if pct := config.Datadog.GetInt(key(spNS, "conntrack_adaptive_sampling_cpu_pct")); pct > 0 {
+		a.ConntrackAdaptiveCPUBudget = float64(pct) / 100
+	}
+	if pct := config.Datadog.GetInt(key(spNS, "conntrack_cpu_breaker_pct")); pct > 0 {
+		a.ConntrackCPUBreakerBudget = float64(pct) / 100
+	}
 
 	// When reading kernel structs at different offsets, don't go over the threshold
 	// This defaults to 400 and has a max of 3000. These are arbitrary choices to avoid infinite loops.
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	model "github.com/DataDog/agent-payload/process"
+	"github.com/DataDog/datadog-agent/pkg/network"
 	"github.com/DataDog/datadog-agent/pkg/network/encoding"
 	"github.com/DataDog/datadog-agent/pkg/util/log"
 	"github.com/DataDog/datadog-agent/pkg/util/retry"
@@ -137,6 +138,31 @@ func (r *RemoteSysProbeUtil) GetStats() (map[string]interface{}, error) {
 	return stats, nil
 }
 
+// GetConntrackTable returns the content of the system-probe conntrack cache, along with conntrack stats
+func (r *RemoteSysProbeUtil) GetConntrackTable() (*network.DebugConntrackTable, error) {
+	resp, err := r.httpClient.Get(conntrackURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("conntrack request failed: Path %s, url: %s, status code: %d", r.path, conntrackURL, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	table := &network.DebugConntrackTable{}
+	if err := json.Unmarshal(body, table); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
 func newSystemProbe() *RemoteSysProbeUtil {
 	return &RemoteSysProbeUtil{
 		path: globalSocketPath,
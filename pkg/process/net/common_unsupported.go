@@ -5,6 +5,7 @@ package net
 import (
 	model "github.com/DataDog/agent-payload/process"
 	"github.com/DataDog/datadog-agent/pkg/ebpf"
+	"github.com/DataDog/datadog-agent/pkg/network"
 )
 
 // RemoteSysProbeUtil is not supported
@@ -34,3 +35,8 @@ func (r *RemoteSysProbeUtil) GetConnections(clientID string) (*model.Connections
 func (r *RemoteSysProbeUtil) GetStats() (map[string]interface{}, error) {
 	return nil, ebpf.ErrNotImplemented
 }
+
+// GetConntrackTable is not supported
+func (r *RemoteSysProbeUtil) GetConntrackTable() (*network.DebugConntrackTable, error) {
+	return nil, ebpf.ErrNotImplemented
+}
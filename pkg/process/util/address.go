@@ -100,3 +100,38 @@ func (a v6Address) String() string {
 func (a v6Address) IsLoopback() bool {
 	return net.IP(a[:]).IsLoopback()
 }
+
+// To16 returns the 16-byte form of an address, IPv4 addresses being IPv4-mapped (::ffff:a.b.c.d) like in a net.IP.
+// Unlike Bytes, it doesn't allocate. The zero array is returned for a nil address.
+func To16(a Address) [16]byte {
+	var b [16]byte
+	switch a := a.(type) {
+	case nil:
+	case v4Address:
+		b[10], b[11] = 0xff, 0xff
+		copy(b[12:], a[:])
+	case v6Address:
+		b = a
+	default:
+		copy(b[:], net.IP(a.Bytes()).To16())
+	}
+	return b
+}
+
+// UnmapIPv4 returns the IPv4 address an IPv4-mapped IPv6 address (::ffff:a.b.c.d) stands for,
+// and the address as is otherwise
+func UnmapIPv4(a Address) Address {
+	if v6, ok := a.(v6Address); ok && isIPv4Mapped(v6) {
+		return V4AddressFromBytes(v6[12:])
+	}
+	return a
+}
+
+func isIPv4Mapped(a v6Address) bool {
+	for _, b := range a[:10] {
+		if b != 0 {
+			return false
+		}
+	}
+	return a[10] == 0xff && a[11] == 0xff
+}
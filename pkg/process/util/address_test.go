@@ -137,3 +137,12 @@ func TestAddressV6(t *testing.T) {
 	assert.Equal(t, "2001:db8::2:1", addr.String())
 	assert.False(t, addr.IsLoopback())
 }
+
+func TestTo16(t *testing.T) {
+	assert.Equal(t, [16]byte{}, To16(nil))
+	for _, ip := range []string{"10.0.0.1", "2001:db8::2:1"} {
+		b := To16(AddressFromString(ip))
+		assert.Equal(t, net.ParseIP(ip).To16(), net.IP(b[:]))
+		assert.Equal(t, AddressFromString(ip), AddressFromNetIP(b[:]))
+	}
+}
@@ -35,3 +35,32 @@ func GetSystemProbeStats(socketPath string) map[string]interface{} {
 
 	return systemProbeDetails
 }
+
+// GetSystemProbeConntrack returns the conntrack diagnostics of the system probe: a summary of its conntrack cache,
+// its conntrack stats, the kernel conntrack counters, its last conntrack errors and the description of its conntracker.
+// The cached entries themselves aren't returned, since they can be numerous.
+func GetSystemProbeConntrack(socketPath string) map[string]interface{} {
+	net.SetSystemProbePath(socketPath)
+	probeUtil, err := net.GetRemoteSystemProbeUtil()
+	if err != nil {
+		return map[string]interface{}{
+			"Errors": fmt.Sprintf("%v", err),
+		}
+	}
+
+	table, err := probeUtil.GetConntrackTable()
+	if err != nil {
+		return map[string]interface{}{
+			"Errors": fmt.Sprintf("issue querying conntrack table from system probe: %v", err),
+		}
+	}
+
+	return map[string]interface{}{
+		"summary":      table.Summary(),
+		"stats":        table.Stats,
+		"kernel":       table.Kernel,
+		"nat_gateways": table.NATGateways,
+		"errors":       table.Errors,
+		"description":  table.Description,
+	}
+}
@@ -17,3 +17,10 @@ func GetSystemProbeStats(socketPath string) map[string]interface{} {
 		"Errors": fmt.Sprintf("System Probe is not supported on this system"),
 	}
 }
+
+// GetSystemProbeConntrack returns a notice that it is not supported on systems that do not at least build the process agent
+func GetSystemProbeConntrack(socketPath string) map[string]interface{} {
+	return map[string]interface{}{
+		"Errors": fmt.Sprintf("System Probe is not supported on this system"),
+	}
+}
@@ -46,6 +46,9 @@ type FakeDCAClient struct {
 
 	EndpointsCheckConfigs    types.ConfigResponse
 	EndpointsCheckConfigsErr error
+
+	ServiceAddresses    []apiv1.ServiceAddress
+	ServiceAddressesErr error
 }
 
 func (f *FakeDCAClient) Version() version.Version {
@@ -83,6 +86,10 @@ func (f *FakeDCAClient) GetCFAppsMetadataForNode(nodename string) (map[string][]
 	panic("implement me")
 }
 
+func (f *FakeDCAClient) GetServiceAddresses() ([]apiv1.ServiceAddress, error) {
+	return f.ServiceAddresses, f.ServiceAddressesErr
+}
+
 func TestKubeMetadataCollector_getMetadaNames(t *testing.T) {
 	type fields struct {
 		dcaClient           clusteragent.DCAClientInterface
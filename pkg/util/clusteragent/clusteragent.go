@@ -51,6 +51,7 @@ type DCAClientInterface interface {
 	GetPodsMetadataForNode(nodeName string) (apiv1.NamespacesPodsStringsSet, error)
 	GetKubernetesMetadataNames(nodeName, ns, podName string) ([]string, error)
 	GetCFAppsMetadataForNode(nodename string) (map[string][]string, error)
+	GetServiceAddresses() ([]apiv1.ServiceAddress, error)
 
 	PostClusterCheckStatus(nodeName string, status types.NodeStatus) (types.StatusResponse, error)
 	GetClusterCheckConfigs(nodeName string) (types.ConfigResponse, error)
@@ -270,6 +271,39 @@ func (c *DCAClient) GetNodeLabels(nodeName string) (map[string]string, error) {
 	return labels, err
 }
 
+// GetServiceAddresses returns the addresses the services of the cluster are exposed at from the Cluster Agent.
+func (c *DCAClient) GetServiceAddresses() ([]apiv1.ServiceAddress, error) {
+	const dcaServiceAddresses = "api/v1/services/addresses"
+	var err error
+	var addrs []apiv1.ServiceAddress
+
+	// https://host:port/api/v1/services/addresses
+	rawURL := fmt.Sprintf("%s/%s", c.clusterAgentAPIEndpoint, dcaServiceAddresses)
+
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.clusterAgentAPIRequestHeaders
+
+	resp, err := c.clusterAgentAPIClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from cluster agent: %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &addrs)
+	return addrs, err
+}
+
 // GetCFAppsMetadataForNode returns the CF application tags from the Cluster Agent.
 func (c *DCAClient) GetCFAppsMetadataForNode(nodename string) (map[string][]string, error) {
 	const dcaCFAppsMeta = "api/v1/tags/cf/apps"
@@ -560,6 +560,27 @@ func (suite *clusterAgentSuite) TestGetCFAppsMetadataForNode() {
 	}
 }
 
+func (suite *clusterAgentSuite) TestGetServiceAddresses() {
+	dca, err := newDummyClusterAgent()
+	require.Nil(suite.T(), err, fmt.Sprintf("%v", err))
+	dca.rawResponses["/api/v1/services/addresses"] = `[{"ip":"10.96.0.10","port":53,"protocol":"UDP","namespace":"kube-system","name":"kube-dns"}]`
+
+	ts, p, err := dca.StartTLS()
+	defer ts.Close()
+	require.Nil(suite.T(), err, fmt.Sprintf("%v", err))
+
+	mockConfig.Set("cluster_agent.url", fmt.Sprintf("https://127.0.0.1:%d", p))
+
+	ca, err := GetClusterAgentClient()
+	require.Nil(suite.T(), err, fmt.Sprintf("%v", err))
+
+	addrs, err := ca.GetServiceAddresses()
+	require.Nil(suite.T(), err, fmt.Sprintf("%v", err))
+	assert.Equal(suite.T(), []apiv1.ServiceAddress{
+		{IP: "10.96.0.10", Port: 53, Protocol: "UDP", Namespace: "kube-system", Name: "kube-dns"},
+	}, addrs)
+}
+
 func (suite *clusterAgentSuite) TestGetPodsMetadataForNode() {
 	dca, err := newDummyClusterAgent()
 	require.Nil(suite.T(), err, fmt.Sprintf("%v", err))
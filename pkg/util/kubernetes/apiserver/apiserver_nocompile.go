@@ -54,3 +54,9 @@ func GetNodeLabels(nodeName string) (map[string]string, error) {
 	log.Errorf("GetNodeLabels not implemented %s", ErrNotCompiled.Error())
 	return nil, nil
 }
+
+// GetServiceAddresses returns the addresses the services of the cluster are exposed at, from the cache of the services informer.
+func GetServiceAddresses() ([]apiv1.ServiceAddress, error) {
+	log.Errorf("GetServiceAddresses not implemented %s", ErrNotCompiled.Error())
+	return nil, nil
+}
@@ -45,7 +45,9 @@ var controllerCatalog = map[controllerName]controllerFuncs{
 		startAutoscalersController,
 	},
 	servicesController: {
-		func() bool { return config.Datadog.GetBool("cluster_checks.enabled") },
+		func() bool {
+			return config.Datadog.GetBool("cluster_checks.enabled") || config.Datadog.GetBool("kubernetes_collect_metadata_tags")
+		},
 		registerServicesInformer,
 	},
 	endpointsController: {
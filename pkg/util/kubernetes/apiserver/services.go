@@ -11,6 +11,9 @@ import (
 	"fmt"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	apiv1 "github.com/DataDog/datadog-agent/pkg/clusteragent/api/v1"
 )
 
 const kubeServiceIDPrefix = "kube_service_uid://"
@@ -37,3 +40,50 @@ func EntityForService(svc *v1.Service) string {
 	}
 	return fmt.Sprintf("%s%s", kubeServiceIDPrefix, svc.ObjectMeta.UID)
 }
+
+// GetServiceAddresses returns the addresses the services of the cluster are exposed at, from the cache of the services
+// informer.
+func GetServiceAddresses() ([]apiv1.ServiceAddress, error) {
+	as, err := GetAPIClient()
+	if err != nil {
+		return nil, err
+	}
+	informer := as.InformerFactory.Core().V1().Services()
+	if !informer.Informer().HasSynced() {
+		return nil, fmt.Errorf("the services informer is not synced")
+	}
+	services, err := informer.Lister().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []apiv1.ServiceAddress
+	for _, svc := range services {
+		addrs = append(addrs, serviceAddresses(svc)...)
+	}
+	return addrs, nil
+}
+
+// serviceAddresses returns the addresses the given service is exposed at: each of its ports on its cluster IP and on
+// its external IPs. Headless services have no address of their own.
+func serviceAddresses(svc *v1.Service) []apiv1.ServiceAddress {
+	var ips []string
+	if ip := svc.Spec.ClusterIP; ip != "" && ip != v1.ClusterIPNone {
+		ips = append(ips, ip)
+	}
+	ips = append(ips, svc.Spec.ExternalIPs...)
+
+	var addrs []apiv1.ServiceAddress
+	for _, ip := range ips {
+		for _, port := range svc.Spec.Ports {
+			addrs = append(addrs, apiv1.ServiceAddress{
+				IP:        ip,
+				Port:      port.Port,
+				Protocol:  string(port.Protocol),
+				Namespace: svc.Namespace,
+				Name:      svc.Name,
+			})
+		}
+	}
+	return addrs
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 
 	apiv1 "github.com/DataDog/datadog-agent/pkg/clusteragent/api/v1"
@@ -38,3 +40,28 @@ func TestNamespacesPodsStringsSet(t *testing.T) {
 	_, ok := mapper["default"]
 	require.False(t, ok, "default namespace still exists")
 }
+
+func TestServiceAddresses(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kube-dns"},
+		Spec: v1.ServiceSpec{
+			ClusterIP:   "10.96.0.10",
+			ExternalIPs: []string{"192.168.1.10"},
+			Ports: []v1.ServicePort{
+				{Port: 53, Protocol: v1.ProtocolUDP},
+				{Port: 53, Protocol: v1.ProtocolTCP},
+			},
+		},
+	}
+	assert.Equal(t, []apiv1.ServiceAddress{
+		{IP: "10.96.0.10", Port: 53, Protocol: "UDP", Namespace: "kube-system", Name: "kube-dns"},
+		{IP: "10.96.0.10", Port: 53, Protocol: "TCP", Namespace: "kube-system", Name: "kube-dns"},
+		{IP: "192.168.1.10", Port: 53, Protocol: "UDP", Namespace: "kube-system", Name: "kube-dns"},
+		{IP: "192.168.1.10", Port: 53, Protocol: "TCP", Namespace: "kube-system", Name: "kube-dns"},
+	}, serviceAddresses(svc))
+
+	// headless services have no address of their own
+	svc.Spec.ClusterIP = v1.ClusterIPNone
+	svc.Spec.ExternalIPs = nil
+	assert.Empty(t, serviceAddresses(svc))
+}